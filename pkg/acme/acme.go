@@ -0,0 +1,417 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Let's Encrypt endpoints, also accepted as v2 and v2-staging aliases
+const (
+	EndpointV2        = "https://acme-v02.api.letsencrypt.org/directory"
+	EndpointV2Staging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// ChallengeResolver publishes the key authorization of the http-01 challenges,
+// which should be answered on http://<domain>/.well-known/acme-challenge/<token>
+type ChallengeResolver interface {
+	SetToken(domain, token, keyAuth string) error
+	ClearToken(domain, token string)
+}
+
+// Client is a minimal ACME v2 client, see RFC 8555. It registers an account
+// and issues certificates using the http-01 challenge. A Client isn't safe
+// for concurrent use.
+type Client struct {
+	endpoint     string
+	key          *ecdsa.PrivateKey
+	client       *http.Client
+	dir          *directory
+	kid          string
+	nonce        string
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *problem `json:"error"`
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// NewClient creates a client of an ACME server. endpoint is the URL of the
+// directory of the server, or one of the v2 and v2-staging aliases of
+// Let's Encrypt. key is the private key of the account.
+func NewClient(endpoint string, key *ecdsa.PrivateKey) *Client {
+	switch endpoint {
+	case "v2":
+		endpoint = EndpointV2
+	case "v2-staging":
+		endpoint = EndpointV2Staging
+	}
+	return &Client{
+		endpoint:     endpoint,
+		key:          key,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		pollInterval: 2 * time.Second,
+		pollTimeout:  2 * time.Minute,
+	}
+}
+
+// Register creates the account of the client key, or finds it if it already exists
+func (c *Client) Register(emails []string, termsAgreed bool) error {
+	if err := c.loadDirectory(); err != nil {
+		return err
+	}
+	contact := make([]string, len(emails))
+	for i, email := range emails {
+		contact[i] = "mailto:" + email
+	}
+	account := map[string]interface{}{
+		"contact":              contact,
+		"termsOfServiceAgreed": termsAgreed,
+	}
+	resp, _, err := c.post(c.dir.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("error registering account: %v", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("error registering account: missing account URL")
+	}
+	return nil
+}
+
+// Sign issues a certificate of domains for the private key, answering the
+// http-01 challenges with resolver. Register should be called first.
+// The certificate chain is returned PEM encoded.
+func (c *Client) Sign(domains []string, key crypto.Signer, resolver ChallengeResolver) ([]byte, error) {
+	if c.kid == "" {
+		return nil, fmt.Errorf("account isn't registered")
+	}
+	identifiers := make([]identifier, len(domains))
+	for i, domain := range domains {
+		identifiers[i] = identifier{Type: "dns", Value: domain}
+	}
+	var o order
+	resp, _, err := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %v", err)
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(authzURL, resolver); err != nil {
+			return nil, err
+		}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSR: %v", err)
+	}
+	if _, _, err := c.post(o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return nil, fmt.Errorf("error finalizing order: %v", err)
+	}
+	err = c.poll(func() (bool, error) {
+		switch o.Status {
+		case "valid":
+			return true, nil
+		case "invalid":
+			if o.Error != nil {
+				return false, fmt.Errorf("order is invalid: %v", o.Error)
+			}
+			return false, fmt.Errorf("order is invalid")
+		}
+		_, _, err := c.post(orderURL, nil, &o)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, cert, err := c.post(o.Certificate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading certificate: %v", err)
+	}
+	return cert, nil
+}
+
+func (c *Client) authorize(authzURL string, resolver ChallengeResolver) error {
+	var authz authorization
+	if _, _, err := c.post(authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("error reading authorization: %v", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	domain := authz.Identifier.Value
+	var chall *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chall = &authz.Challenges[i]
+			break
+		}
+	}
+	if chall == nil {
+		return fmt.Errorf("http-01 challenge not found on authorization of '%s'", domain)
+	}
+	thumbprint, err := c.thumbprint()
+	if err != nil {
+		return err
+	}
+	if err := resolver.SetToken(domain, chall.Token, chall.Token+"."+thumbprint); err != nil {
+		return fmt.Errorf("error publishing challenge token of '%s': %v", domain, err)
+	}
+	defer resolver.ClearToken(domain, chall.Token)
+	if _, _, err := c.post(chall.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("error accepting challenge of '%s': %v", domain, err)
+	}
+	return c.poll(func() (bool, error) {
+		if _, _, err := c.post(authzURL, nil, &authz); err != nil {
+			return false, err
+		}
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "pending", "processing":
+			return false, nil
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == "http-01" && ch.Error != nil {
+				return false, fmt.Errorf("challenge of '%s' failed: %v", domain, ch.Error)
+			}
+		}
+		return false, fmt.Errorf("authorization of '%s' is %s", domain, authz.Status)
+	})
+}
+
+// poll calls f until it returns true or an error
+func (c *Client) poll(f func() (bool, error)) error {
+	timeout := time.Now().Add(c.pollTimeout)
+	for {
+		done, err := f()
+		if done || err != nil {
+			return err
+		}
+		if time.Now().After(timeout) {
+			return fmt.Errorf("timeout waiting the ACME server")
+		}
+		time.Sleep(c.pollInterval)
+	}
+}
+
+func (c *Client) loadDirectory() error {
+	if c.dir != nil {
+		return nil
+	}
+	resp, err := c.client.Get(c.endpoint)
+	if err != nil {
+		return fmt.Errorf("error reading ACME directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error reading ACME directory: %s", resp.Status)
+	}
+	dir := &directory{}
+	if err := json.NewDecoder(resp.Body).Decode(dir); err != nil {
+		return fmt.Errorf("error reading ACME directory: %v", err)
+	}
+	c.dir = dir
+	return nil
+}
+
+// post sends a JWS signed request. A nil payload sends a POST-as-GET request.
+// The response body is decoded to out if not nil, and also returned.
+func (c *Client) post(url string, payload, out interface{}) (*http.Response, []byte, error) {
+	resp, body, err := c.postOnce(url, payload)
+	if err != nil {
+		if p, ok := err.(*problem); ok && p.Type == "urn:ietf:params:acme:error:badNonce" {
+			resp, body, err = c.postOnce(url, payload)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resp, body, nil
+}
+
+func (c *Client) postOnce(url string, payload interface{}) (*http.Response, []byte, error) {
+	if err := c.loadDirectory(); err != nil {
+		return nil, nil, err
+	}
+	jws, err := c.sign(url, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.client.Post(url, "application/jose+json", bytes.NewReader(jws))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p := &problem{}
+		if err := json.Unmarshal(body, p); err != nil || p.Type == "" {
+			return nil, nil, fmt.Errorf("%s", resp.Status)
+		}
+		return nil, nil, p
+	}
+	return resp, body, nil
+}
+
+func (c *Client) sign(url string, payload interface{}) ([]byte, error) {
+	if c.nonce == "" {
+		resp, err := c.client.Head(c.dir.NewNonce)
+		if err != nil {
+			return nil, fmt.Errorf("error reading nonce: %v", err)
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+		if c.nonce == "" {
+			return nil, fmt.Errorf("error reading nonce: missing Replay-Nonce header")
+		}
+	}
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   url,
+	}
+	c.nonce = ""
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if payload != nil {
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	header64 := encode(header)
+	payload64 := encode(data)
+	hash := sha256.Sum256([]byte(header64 + "." + payload64))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	copyPadded(signature[:32], r)
+	copyPadded(signature[32:], s)
+	return json.Marshal(map[string]string{
+		"protected": header64,
+		"payload":   payload64,
+		"signature": encode(signature),
+	})
+}
+
+func (c *Client) jwk() map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	copyPadded(x, c.key.X)
+	copyPadded(y, c.key.Y)
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   encode(x),
+		"y":   encode(y),
+	}
+}
+
+// thumbprint is the JWK thumbprint of the account key, see RFC 7638
+func (c *Client) thumbprint() (string, error) {
+	// json.Marshal sorts the keys of a map, as required by the thumbprint
+	jwk, err := json.Marshal(c.jwk())
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(jwk)
+	return encode(hash[:]), nil
+}
+
+func copyPadded(dst []byte, n *big.Int) {
+	b := n.Bytes()
+	copy(dst[len(dst)-len(b):], b)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
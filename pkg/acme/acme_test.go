@@ -0,0 +1,257 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type resolverMock struct {
+	tokens  map[string]string
+	cleared []string
+}
+
+func (r *resolverMock) SetToken(domain, token, keyAuth string) error {
+	r.tokens[domain+"/"+token] = keyAuth
+	return nil
+}
+
+func (r *resolverMock) ClearToken(domain, token string) {
+	r.cleared = append(r.cleared, domain+"/"+token)
+}
+
+// serverMock is a fake ACME server which verifies the JWS signatures
+// and validates the challenges reading the key authorization of the resolver
+type serverMock struct {
+	t          *testing.T
+	url        string
+	accountKey *ecdsa.PublicKey
+	resolver   *resolverMock
+	domains    []string
+	valid      map[string]bool
+	finalized  bool
+	csr        *x509.CertificateRequest
+	nonce      int
+	badNonce   bool
+}
+
+func (s *serverMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", s.nonce))
+	if r.URL.Path == "/directory" {
+		s.write(w, map[string]string{
+			"newNonce":   s.url + "/nonce",
+			"newAccount": s.url + "/account",
+			"newOrder":   s.url + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+	payload, err := s.verify(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		s.write(w, &problem{Type: "urn:ietf:params:acme:error:malformed", Detail: err.Error()})
+		return
+	}
+	if s.badNonce {
+		s.badNonce = false
+		w.WriteHeader(http.StatusBadRequest)
+		s.write(w, &problem{Type: "urn:ietf:params:acme:error:badNonce", Detail: "bad nonce"})
+		return
+	}
+	switch {
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", s.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		s.write(w, map[string]string{"status": "valid"})
+	case r.URL.Path == "/order":
+		var o order
+		json.Unmarshal(payload, &o)
+		s.domains = nil
+		for _, id := range o.Identifiers {
+			s.domains = append(s.domains, id.Value)
+		}
+		w.Header().Set("Location", s.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		s.write(w, s.order())
+	case r.URL.Path == "/order/1":
+		s.write(w, s.order())
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		domain := strings.TrimPrefix(r.URL.Path, "/authz/")
+		status := "pending"
+		if s.valid[domain] {
+			status = "valid"
+		}
+		s.write(w, &authorization{
+			Status:     status,
+			Identifier: identifier{Type: "dns", Value: domain},
+			Challenges: []challenge{
+				{Type: "dns-01", URL: s.url + "/chall-dns/" + domain, Token: "dns-" + domain},
+				{Type: "http-01", URL: s.url + "/chall/" + domain, Token: "token-" + domain},
+			},
+		})
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		domain := strings.TrimPrefix(r.URL.Path, "/chall/")
+		expected := "token-" + domain + "." + s.thumbprint()
+		if keyAuth := s.resolver.tokens[domain+"/token-"+domain]; keyAuth != expected {
+			s.t.Errorf("expected key authorization '%s' of %s, found '%s'", expected, domain, keyAuth)
+		}
+		s.valid[domain] = true
+		s.write(w, map[string]string{"status": "processing"})
+	case r.URL.Path == "/finalize":
+		var req map[string]string
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req["csr"])
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			s.t.Errorf("error parsing CSR: %v", err)
+		}
+		s.csr = csr
+		s.finalized = true
+		w.WriteHeader(http.StatusOK)
+		o := s.order()
+		o.Status = "processing"
+		s.write(w, o)
+	case r.URL.Path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write([]byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *serverMock) order() *order {
+	o := &order{
+		Status:   "pending",
+		Finalize: s.url + "/finalize",
+	}
+	if s.finalized {
+		o.Status = "valid"
+		o.Certificate = s.url + "/cert"
+	}
+	for _, domain := range s.domains {
+		o.Identifiers = append(o.Identifiers, identifier{Type: "dns", Value: domain})
+		o.Authorizations = append(o.Authorizations, s.url+"/authz/"+domain)
+	}
+	return o
+}
+
+func (s *serverMock) write(w http.ResponseWriter, obj interface{}) {
+	json.NewEncoder(w).Encode(obj)
+}
+
+func (s *serverMock) thumbprint() string {
+	c := &Client{key: &ecdsa.PrivateKey{PublicKey: *s.accountKey}}
+	thumbprint, _ := c.thumbprint()
+	return thumbprint
+}
+
+func (s *serverMock) verify(r *http.Request) ([]byte, error) {
+	var jws map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws["protected"])
+	var protected struct {
+		Alg   string            `json:"alg"`
+		Nonce string            `json:"nonce"`
+		URL   string            `json:"url"`
+		Kid   string            `json:"kid"`
+		JWK   map[string]string `json:"jwk"`
+	}
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, err
+	}
+	if protected.URL != s.url+r.URL.Path {
+		return nil, fmt.Errorf("url differs: %s", protected.URL)
+	}
+	if protected.Nonce == "" {
+		return nil, fmt.Errorf("missing nonce")
+	}
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		s.accountKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected.Kid != s.url+"/account/1" {
+		return nil, fmt.Errorf("invalid kid: %s", protected.Kid)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws["signature"])
+	hash := sha256.Sum256([]byte(jws["protected"] + "." + jws["payload"]))
+	if len(sig) != 64 || !ecdsa.Verify(s.accountKey, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return base64.RawURLEncoding.DecodeString(jws["payload"])
+}
+
+func TestSign(t *testing.T) {
+	resolver := &resolverMock{tokens: map[string]string{}}
+	server := &serverMock{t: t, resolver: resolver, valid: map[string]bool{"d2.local": true}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	server.url = ts.URL
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := NewClient(ts.URL+"/directory", accountKey)
+	client.pollInterval = time.Millisecond
+	if err := client.Register([]string{"admin@local"}, true); err != nil {
+		t.Fatalf("error registering: %v", err)
+	}
+	server.badNonce = true
+	certKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	cert, err := client.Sign([]string{"d1.local", "d2.local"}, certKey, resolver)
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	if block, _ := pem.Decode(cert); block == nil {
+		t.Errorf("expected a PEM encoded certificate, found: %s", cert)
+	}
+	if server.csr == nil || !reflect.DeepEqual(server.csr.DNSNames, []string{"d1.local", "d2.local"}) {
+		t.Errorf("expected a CSR of d1.local and d2.local, found %+v", server.csr)
+	}
+	if !reflect.DeepEqual(resolver.cleared, []string{"d1.local/token-d1.local"}) {
+		t.Errorf("expected d1.local token cleared, found %v", resolver.cleared)
+	}
+}
+
+func TestSignNotRegistered(t *testing.T) {
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := NewClient("v2-staging", accountKey)
+	if client.endpoint != EndpointV2Staging {
+		t.Errorf("expected staging endpoint, found %s", client.endpoint)
+	}
+	if _, err := client.Sign([]string{"d1.local"}, accountKey, &resolverMock{}); err == nil {
+		t.Errorf("expected an error signing without an account")
+	}
+}
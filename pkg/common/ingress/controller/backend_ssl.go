@@ -48,7 +48,7 @@ func (ic *GenericController) syncSecret(key string) {
 	cert, err := ic.getPemCertificate(secret)
 	if err != nil {
 		glog.V(3).Infof("syncing a non ca/crt secret %v", key)
-		ic.syncQueue.Enqueue(&extensions.Ingress{})
+		ic.enqueue(&extensions.Ingress{})
 		return
 	}
 
@@ -64,7 +64,7 @@ func (ic *GenericController) syncSecret(key string) {
 		ic.sslCertTracker.Update(key, cert)
 		// this update must trigger an update
 		// (like an update event from a change in Ingress)
-		ic.syncQueue.Enqueue(&extensions.Ingress{})
+		ic.enqueue(&extensions.Ingress{})
 		return
 	}
 
@@ -72,7 +72,7 @@ func (ic *GenericController) syncSecret(key string) {
 	ic.sslCertTracker.Add(key, cert)
 	// this update must trigger an update
 	// (like an update event from a change in Ingress)
-	ic.syncQueue.Enqueue(&extensions.Ingress{})
+	ic.enqueue(&extensions.Ingress{})
 }
 
 // getPemCertificate receives a secret, and creates a ingress.SSLCert as return.
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	backoffInitial = 500 * time.Millisecond
+	backoffMax     = 30 * time.Second
+)
+
+// backoffRoundTripper retries requests rejected by the apiserver with
+// 429 Too Many Requests, waiting the time suggested by the Retry-After
+// header or an exponential backoff
+type backoffRoundTripper struct {
+	rt         http.RoundTripper
+	maxRetries int
+	sleep      func(d time.Duration)
+}
+
+func newBackoffRoundTripper(rt http.RoundTripper, maxRetries int) *backoffRoundTripper {
+	return &backoffRoundTripper{
+		rt:         rt,
+		maxRetries: maxRetries,
+		sleep:      time.Sleep,
+	}
+}
+
+func (b *backoffRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait := backoffInitial
+	for retry := 0; ; retry++ {
+		resp, err := b.rt.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || retry >= b.maxRetries {
+			return resp, err
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req.Body = body
+		}
+		delay := wait
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		if delay > backoffMax {
+			delay = backoffMax
+		}
+		resp.Body.Close()
+		glog.V(2).Infof("apiserver throttled %s %s, retrying in %v", req.Method, req.URL.Path, delay)
+		b.sleep(delay)
+		wait *= 2
+	}
+}
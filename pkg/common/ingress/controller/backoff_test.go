@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRoundTripper struct {
+	status     []int
+	retryAfter string
+	calls      int
+}
+
+func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := f.status[f.calls]
+	f.calls++
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	if status == http.StatusTooManyRequests && f.retryAfter != "" {
+		resp.Header.Set("Retry-After", f.retryAfter)
+	}
+	return resp, nil
+}
+
+func TestBackoffRoundTripper(t *testing.T) {
+	testCases := []struct {
+		status     []int
+		retryAfter string
+		maxRetries int
+		expStatus  int
+		expSleep   []time.Duration
+	}{
+		// 0
+		{
+			status:     []int{200},
+			maxRetries: 3,
+			expStatus:  200,
+		},
+		// 1
+		{
+			status:     []int{429, 429, 200},
+			maxRetries: 3,
+			expStatus:  200,
+			expSleep:   []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		// 2
+		{
+			status:     []int{429, 429, 429},
+			maxRetries: 2,
+			expStatus:  429,
+			expSleep:   []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		// 3
+		{
+			status:     []int{429, 200},
+			retryAfter: "5",
+			maxRetries: 3,
+			expStatus:  200,
+			expSleep:   []time.Duration{5 * time.Second},
+		},
+		// 4
+		{
+			status:     []int{429, 200},
+			retryAfter: "120",
+			maxRetries: 3,
+			expStatus:  200,
+			expSleep:   []time.Duration{30 * time.Second},
+		},
+	}
+	for i, test := range testCases {
+		var sleep []time.Duration
+		b := newBackoffRoundTripper(&fakeRoundTripper{status: test.status, retryAfter: test.retryAfter}, test.maxRetries)
+		b.sleep = func(d time.Duration) { sleep = append(sleep, d) }
+		req, _ := http.NewRequest("GET", "https://127.0.0.1/api/v1/endpoints", nil)
+		resp, err := b.RoundTrip(req)
+		if err != nil {
+			t.Errorf("unexpected error on %d: %v", i, err)
+			continue
+		}
+		if resp.StatusCode != test.expStatus {
+			t.Errorf("status on %d differs - expected: %d - actual: %d", i, test.expStatus, resp.StatusCode)
+		}
+		if !reflect.DeepEqual(sleep, test.expSleep) {
+			t.Errorf("sleep on %d differs - expected: %v - actual: %v", i, test.expSleep, sleep)
+		}
+	}
+}
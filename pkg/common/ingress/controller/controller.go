@@ -115,23 +115,35 @@ type GenericController struct {
 	runningConfig *ingress.Configuration
 
 	forceReload int32
+
+	readiness *readiness
 }
 
 // Configuration contains all the settings required by an Ingress controller
 type Configuration struct {
 	Client clientset.Interface
 
-	RateLimitUpdate float32
-	ResyncPeriod    time.Duration
+	RateLimitUpdate  float32
+	WaitBeforeUpdate time.Duration
+	ResyncPeriod     time.Duration
 
 	DefaultService string
 	IngressClass   string
 	Namespace      string
 	ConfigMapName  string
 
+	// Namespaces is the list of watched namespaces, all of them if empty.
+	// Namespace is the same namespace if a single one is watched.
+	Namespaces []string
+	// WatchSelector is the label selector of the watched ingress and services
+	WatchSelector string
+
 	ForceNamespaceIsolation bool
 	AllowCrossNamespace     bool
 	DisableNodeList         bool
+	// SecretNamespaceAnnotation watches namespaces, whose annotations
+	// can allow the access to their secrets from other namespaces
+	SecretNamespaceAnnotation bool
 
 	// optional
 	TCPConfigMapName string
@@ -143,6 +155,8 @@ type Configuration struct {
 	DefaultIngressClass   string
 	// optional
 	PublishService string
+	// optional
+	PublishStatusAddress []string
 	// Backend is the particular implementation to be used.
 	// (for instance NGINX)
 	Backend ingress.Controller
@@ -150,13 +164,31 @@ type Configuration struct {
 	UpdateStatus           bool
 	UseNodeInternalIP      bool
 	ElectionID             string
+	ElectionLock           string
 	UpdateStatusOnShutdown bool
 
+	UpdateExternalDNSTarget bool
+
 	SortBackends bool
 
+	// optional
+	ChangeTracker    ChangeTracker
+	ReadinessChecker ReadinessChecker
+
 	V07 bool
 }
 
+// ChangeTracker is notified about every object which enqueues a new sync
+type ChangeTracker interface {
+	Track(obj interface{})
+}
+
+// ReadinessChecker tells if the proxy is ready to receive requests,
+// used by the readyz endpoint in addition to the sync results
+type ReadinessChecker interface {
+	Ready() error
+}
+
 // newIngressController creates an Ingress controller
 func newIngressController(config *Configuration) *GenericController {
 
@@ -169,6 +201,7 @@ func newIngressController(config *Configuration) *GenericController {
 	ic := GenericController{
 		cfg:             config,
 		stopLock:        &sync.Mutex{},
+		readiness:       &readiness{},
 		stopCh:          make(chan struct{}),
 		syncRateLimiter: flowcontrol.NewTokenBucketRateLimiter(config.RateLimitUpdate, 1),
 		recorder: eventBroadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{
@@ -177,21 +210,26 @@ func newIngressController(config *Configuration) *GenericController {
 		sslCertTracker: newSSLCertTracker(),
 	}
 
-	ic.syncQueue = task.NewTaskQueue(ic.syncIngress)
+	ic.syncQueue = task.NewTaskQueue(ic.sync)
+	ic.syncQueue.SetWait(ic.waitBeforeSync)
+	ic.syncQueue.SetSkipped(func(interface{}) { incSyncEventCount(coalescedLabel) })
 
-	ic.listers, ic.cacheController = ic.createListers(config.DisableNodeList)
+	ic.listers, ic.cacheController = ic.createListers(config.DisableNodeList, config.SecretNamespaceAnnotation)
 
 	if config.UpdateStatus {
 		ic.syncStatus = status.NewStatusSyncer(status.Config{
-			Client:                 config.Client,
-			PublishService:         ic.cfg.PublishService,
-			IngressLister:          ic.listers.Ingress,
-			ElectionID:             config.ElectionID,
-			IngressClass:           config.IngressClass,
-			DefaultIngressClass:    config.DefaultIngressClass,
-			UpdateStatusOnShutdown: config.UpdateStatusOnShutdown,
-			CustomIngressStatus:    ic.cfg.Backend.UpdateIngressStatus,
-			UseNodeInternalIP:      ic.cfg.UseNodeInternalIP,
+			Client:                  config.Client,
+			PublishService:          ic.cfg.PublishService,
+			PublishStatusAddress:    ic.cfg.PublishStatusAddress,
+			IngressLister:           ic.listers.Ingress,
+			ElectionID:              config.ElectionID,
+			ElectionLock:            config.ElectionLock,
+			IngressClass:            config.IngressClass,
+			DefaultIngressClass:     config.DefaultIngressClass,
+			UpdateStatusOnShutdown:  config.UpdateStatusOnShutdown,
+			UpdateExternalDNSTarget: config.UpdateExternalDNSTarget,
+			CustomIngressStatus:     ic.cfg.Backend.UpdateIngressStatus,
+			UseNodeInternalIP:       ic.cfg.UseNodeInternalIP,
 		})
 	} else {
 		glog.Warning("Update of ingress status is disabled (flag --update-status=false was specified)")
@@ -233,14 +271,13 @@ func (ic *GenericController) GetDefaultBackend() defaults.Backend {
 	return *ic.defaultBackend
 }
 
-// GetPublishService returns the configured service used to set ingress status
-func (ic GenericController) GetPublishService() *apiv1.Service {
-	s, err := ic.listers.Service.GetByName(ic.cfg.PublishService)
-	if err != nil {
-		return nil
+// GetPublishService returns the configured service used to set ingress status,
+// or nil if the controller isn't published by a service
+func (ic GenericController) GetPublishService() (*apiv1.Service, error) {
+	if ic.cfg.PublishService == "" {
+		return nil, nil
 	}
-
-	return s
+	return ic.listers.PublishService.GetByName(ic.cfg.PublishService)
 }
 
 // GetRecorder returns the event recorder
@@ -261,9 +298,16 @@ func (ic GenericController) GetService(name string) (*apiv1.Service, error) {
 // sync collects all the pieces required to assemble the configuration file and
 // then sends the content to the backend (OnUpdate) receiving the populated
 // template as response reloading the backend if is required.
-func (ic *GenericController) syncIngress(item interface{}) error {
+// waitBeforeSync blocks until the rate limit allows a new sync, and waits for
+// more events, so a burst of changes is applied by a single sync
+func (ic *GenericController) waitBeforeSync() {
 	ic.syncRateLimiter.Accept()
+	if ic.cfg.WaitBeforeUpdate > 0 {
+		time.Sleep(ic.cfg.WaitBeforeUpdate)
+	}
+}
 
+func (ic *GenericController) syncIngress(item interface{}) error {
 	if ic.syncQueue.IsShuttingDown() {
 		return nil
 	}
@@ -1601,7 +1645,7 @@ func (ic *GenericController) Start() {
 	}
 
 	// force initial sync
-	ic.syncQueue.Enqueue(&extensions.Ingress{})
+	ic.enqueue(&extensions.Ingress{})
 
 	<-ic.stopCh
 }
@@ -1610,11 +1654,35 @@ func (ic *GenericController) isForceReload() bool {
 	return atomic.LoadInt32(&ic.forceReload) != 0
 }
 
+// IsLeader returns true if this replica should run the cluster-wide tasks,
+// eg the status update. Every replica is the leader if the status update,
+// and so the leader election, is disabled.
+func (ic *GenericController) IsLeader() bool {
+	if ic.syncStatus == nil {
+		return true
+	}
+	return ic.syncStatus.IsLeader()
+}
+
+// Notify enqueues a new sync, used by watchers not managed by the controller
+func (ic *GenericController) Notify() {
+	ic.enqueue(&extensions.Ingress{})
+}
+
+// enqueue schedules a new sync, the change tracker is notified about the changed object
+func (ic *GenericController) enqueue(obj interface{}) {
+	if ic.cfg.ChangeTracker != nil {
+		ic.cfg.ChangeTracker.Track(obj)
+	}
+	incSyncEventCount(receivedLabel)
+	ic.syncQueue.Enqueue(obj)
+}
+
 // SetForceReload ...
 func (ic *GenericController) SetForceReload(shouldReload bool) {
 	if shouldReload {
 		atomic.StoreInt32(&ic.forceReload, 1)
-		ic.syncQueue.Enqueue(&extensions.Ingress{})
+		ic.enqueue(&extensions.Ingress{})
 	} else {
 		atomic.StoreInt32(&ic.forceReload, 0)
 	}
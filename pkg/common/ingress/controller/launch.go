@@ -17,6 +17,7 @@ import (
 
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -24,6 +25,8 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/status"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/jsonlog"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/k8s"
 )
 
@@ -38,6 +41,24 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 			"Kubernetes cluster and local discovery is attempted.")
 		kubeConfigFile = flags.String("kubeconfig", "", "Path to kubeconfig file with authorization and master location information.")
 
+		kubeAPIQPS = flags.Float32("kube-api-qps", 0,
+			`Maximum queries per second from this controller to the Kubernetes API server.
+		Default is 0, which uses a limit of 1e6 queries per second, high enough to not
+		throttle the client side`)
+
+		kubeAPIBurst = flags.Int("kube-api-burst", 0,
+			`Maximum burst of queries to the Kubernetes API server above kube-api-qps, used
+		only if kube-api-qps is declared. Values lesser than kube-api-qps are changed
+		to the kube-api-qps value`)
+
+		kubeAPIUserAgent = flags.String("kube-api-user-agent", "",
+			`User agent used on requests to the Kubernetes API server, useful to match a
+		priority and fairness FlowSchema. Default is <controller>-ingress/<release>`)
+
+		kubeAPIMaxRetries = flags.Int("kube-api-max-retries", 5,
+			`Maximum number of retries, with exponential backoff, of a request rejected by the
+		Kubernetes API server with 429 Too Many Requests. Use 0 to disable`)
+
 		defaultSvc = flags.String("default-backend-service", "",
 			`Service used to serve a 404 page for the default backend. Takes the form
     	namespace/name. The controller uses the first node port of this Service for
@@ -54,6 +75,11 @@ func NewIngressController(backend ingress.Controller) *GenericController {
  		namespace/name. The controller will set the endpoint records on the
  		ingress objects to reflect those on the service.`)
 
+		publishStatusAddress = flags.String("publish-status-address", "",
+			`Comma-separated list of IPs and/or hostnames used as the status of the ingress
+		objects, instead of the addresses of the publish-service or the nodes running the
+		controller.`)
+
 		tcpConfigMapName = flags.String("tcp-services-configmap", "",
 			`Name of the ConfigMap that contains the definition of the TCP services to expose.
 		The key in the map indicates the external port to be used. The value is the name of the
@@ -72,11 +98,20 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		Default is 0.5, which means wait 2 seconds between Ingress updates in order
 		to add more changes in a single reload`)
 
+		waitBeforeUpdate = flags.Duration("wait-before-update", 0,
+			`Amount of time to wait after an event before updating the configuration.
+		Events received meanwhile, eg endpoint changes of a rolling update, are applied
+		in the same update. Default is 0, which means update as soon as the rate limit allows`)
+
 		resyncPeriod = flags.Duration("sync-period", 600*time.Second,
 			`Relist and confirm cloud resources this often. Default is 10 minutes`)
 
 		watchNamespace = flags.String("watch-namespace", apiv1.NamespaceAll,
-			`Namespace to watch for Ingress. Default is to watch all namespaces`)
+			`Comma-separated list of namespaces to watch for Ingress. Default is to watch all namespaces`)
+
+		watchSelector = flags.String("watch-selector", "",
+			`Label selector of the Ingress and Service objects the controller should watch,
+		eg team=a or tier in (web,api). Default is to watch all of them`)
 
 		healthzPort = flags.Int("healthz-port", 10254, "port for healthz endpoint.")
 
@@ -97,6 +132,10 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 
 		electionID = flags.String("election-id", "ingress-controller-leader", `Election id to use for status update.`)
 
+		electionLock = flags.String("election-lock", status.ConfigMapsElectionLock,
+			`Kind of resource used to store the leader of the status update and other cluster-wide tasks.
+		Options are: configmaps (default) or leases, which requires Kubernetes 1.14 or newer`)
+
 		forceIsolation = flags.Bool("force-namespace-isolation", false,
 			`Force namespace isolation. This flag is required to avoid the reference of secrets,
 		configmaps or the default backend service located in a different namespace than the specified
@@ -106,6 +145,11 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 			`Defines if the ingress controller can reference resources of another namespaces.
 		Cannot be used if force-namespace-isolation is true`)
 
+		secretNamespaceAnnotation = flags.Bool("secret-namespace-annotation", false,
+			`Allow ingress resources to reference secrets of another namespace if the namespace of
+		the secret lists the namespace of the ingress in its allowed-secret-namespaces annotation.
+		Needs permission to list and watch namespaces. v0.8 only`)
+
 		disableNodeList = flags.Bool("disable-node-list", false,
 			`Disable querying nodes. If --force-namespace-isolation is true, this should also be set.`)
 
@@ -113,6 +157,10 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		ingress controller should update the Ingress status IP/hostname when the controller
 		is being stopped. Default is true`)
 
+		updateExternalDNSTarget = flags.Bool("update-external-dns-target", false,
+			`Defines if the external-dns.alpha.kubernetes.io/target annotation of the ingress
+		objects that declare it should be updated with the addresses of their status.`)
+
 		sortBackends = flags.Bool("sort-backends", false,
 			`Defines if backends and it's endpoints should be sorted`)
 
@@ -122,6 +170,10 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		v07 = flags.Bool("v07-controller", true,
 			`Defines if legacy v07 controller code should be used`)
 
+		logFormat = flags.String("log-format", "text",
+			`Format of the controller logs. Options are: text (default), the glog format, or json,
+		one JSON object per line with the level, the source object and the annotation of the message`)
+
 		showVersion = flags.Bool("version", false,
 			`Shows release information about the NGINX Ingress controller`)
 	)
@@ -138,6 +190,16 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		os.Exit(0)
 	}
 
+	switch *logFormat {
+	case "text":
+	case "json":
+		if err := jsonlog.Redirect(); err != nil {
+			glog.Fatalf("error redirecting logs: %v", err)
+		}
+	default:
+		glog.Fatalf("unsupported log format: %v", *logFormat)
+	}
+
 	backend.OverrideFlags(flags)
 
 	flag.Set("logtostderr", "true")
@@ -152,7 +214,17 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		glog.Fatalf("Please specify --default-backend-service")
 	}
 
-	kubeClient, err := createApiserverClient(*apiserverHost, *kubeConfigFile)
+	info := backend.Info()
+	userAgent := *kubeAPIUserAgent
+	if userAgent == "" {
+		userAgent = fmt.Sprintf("%s-ingress/%s", strings.ToLower(info.Name), info.Release)
+	}
+	kubeClient, err := createApiserverClient(*apiserverHost, *kubeConfigFile, &apiClientOptions{
+		qps:        *kubeAPIQPS,
+		burst:      *kubeAPIBurst,
+		userAgent:  userAgent,
+		maxRetries: *kubeAPIMaxRetries,
+	})
 	if err != nil {
 		handleFatalInitError(err)
 	}
@@ -185,7 +257,9 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		}
 
 		if len(svc.Status.LoadBalancer.Ingress) == 0 {
-			if len(svc.Spec.ExternalIPs) > 0 {
+			if svc.Spec.Type == apiv1.ServiceTypeExternalName {
+				glog.Infof("service %v validated as ExternalName", *publishSvc)
+			} else if len(svc.Spec.ExternalIPs) > 0 {
 				glog.Infof("service %v validated as assigned with externalIP", *publishSvc)
 			} else {
 				// We could poll here, but we instead just exit and rely on k8s to restart us
@@ -196,10 +270,13 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		}
 	}
 
-	if *watchNamespace != "" {
-		_, err = kubeClient.CoreV1().Namespaces().Get(*watchNamespace, metav1.GetOptions{})
-		if err != nil {
-			glog.Fatalf("no watchNamespace with name %v found: %v", *watchNamespace, err)
+	namespaces := splitList(*watchNamespace)
+	if len(namespaces) > 0 {
+		for _, ns := range namespaces {
+			_, err = kubeClient.CoreV1().Namespaces().Get(ns, metav1.GetOptions{})
+			if err != nil {
+				glog.Fatalf("no watchNamespace with name %v found: %v", ns, err)
+			}
 		}
 	} else {
 		_, err = kubeClient.CoreV1().Services("default").Get("kubernetes", metav1.GetOptions{})
@@ -221,6 +298,14 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 		glog.Fatalf("rate limit update is too high: up to %v Ingress reloads per second (max is 10)", *rateLimitUpdate)
 	}
 
+	if *electionLock != status.ConfigMapsElectionLock && *electionLock != status.LeasesElectionLock {
+		glog.Fatalf("unsupported election lock: %v", *electionLock)
+	}
+
+	if *waitBeforeUpdate < 0 {
+		glog.Fatalf("wait before update cannot be negative: %v", *waitBeforeUpdate)
+	}
+
 	if resyncPeriod.Seconds() < 10 {
 		glog.Fatalf("resync period (%vs) is too low", resyncPeriod.Seconds())
 	}
@@ -237,32 +322,58 @@ func NewIngressController(backend ingress.Controller) *GenericController {
 	if *forceIsolation && *allowCrossNamespace {
 		glog.Fatal("Cannot use --allow-cross-namespace if --force-namespace-isolation is true")
 	}
+	if *forceIsolation && *secretNamespaceAnnotation {
+		glog.Fatal("Cannot use --secret-namespace-annotation if --force-namespace-isolation is true")
+	}
+
+	// a single watched namespace is also used by the components which
+	// don't support a list, eg the events, which use all the namespaces otherwise
+	namespace := apiv1.NamespaceAll
+	if len(namespaces) == 1 {
+		namespace = namespaces[0]
+	}
+	if _, err := labels.Parse(*watchSelector); err != nil {
+		glog.Fatalf("invalid --watch-selector: %v", err)
+	}
 
 	config := &Configuration{
-		UpdateStatus:            *updateStatus,
-		ElectionID:              *electionID,
-		Client:                  kubeClient,
-		RateLimitUpdate:         *rateLimitUpdate,
-		ResyncPeriod:            *resyncPeriod,
-		DefaultService:          *defaultSvc,
-		IngressClass:            *ingressClass,
-		DefaultIngressClass:     backend.DefaultIngressClass(),
-		Namespace:               *watchNamespace,
-		ConfigMapName:           *configMap,
-		TCPConfigMapName:        *tcpConfigMapName,
-		UDPConfigMapName:        *udpConfigMapName,
-		DefaultSSLCertificate:   *defSSLCertificate,
-		VerifyHostname:          *verifyHostname,
-		DefaultHealthzURL:       *defHealthzURL,
-		PublishService:          *publishSvc,
-		Backend:                 backend,
-		ForceNamespaceIsolation: *forceIsolation,
-		AllowCrossNamespace:     *allowCrossNamespace,
-		DisableNodeList:         *disableNodeList,
-		UpdateStatusOnShutdown:  *updateStatusOnShutdown,
-		SortBackends:            *sortBackends,
-		UseNodeInternalIP:       *useNodeInternalIP,
-		V07:                     *v07,
+		UpdateStatus:              *updateStatus,
+		ElectionID:                *electionID,
+		ElectionLock:              *electionLock,
+		Client:                    kubeClient,
+		RateLimitUpdate:           *rateLimitUpdate,
+		WaitBeforeUpdate:          *waitBeforeUpdate,
+		ResyncPeriod:              *resyncPeriod,
+		DefaultService:            *defaultSvc,
+		IngressClass:              *ingressClass,
+		DefaultIngressClass:       backend.DefaultIngressClass(),
+		Namespace:                 namespace,
+		Namespaces:                namespaces,
+		WatchSelector:             *watchSelector,
+		ConfigMapName:             *configMap,
+		TCPConfigMapName:          *tcpConfigMapName,
+		UDPConfigMapName:          *udpConfigMapName,
+		DefaultSSLCertificate:     *defSSLCertificate,
+		VerifyHostname:            *verifyHostname,
+		DefaultHealthzURL:         *defHealthzURL,
+		PublishService:            *publishSvc,
+		PublishStatusAddress:      splitList(*publishStatusAddress),
+		Backend:                   backend,
+		ForceNamespaceIsolation:   *forceIsolation,
+		AllowCrossNamespace:       *allowCrossNamespace,
+		DisableNodeList:           *disableNodeList,
+		SecretNamespaceAnnotation: *secretNamespaceAnnotation,
+		UpdateStatusOnShutdown:    *updateStatusOnShutdown,
+		UpdateExternalDNSTarget:   *updateExternalDNSTarget,
+		SortBackends:              *sortBackends,
+		UseNodeInternalIP:         *useNodeInternalIP,
+		V07:                       *v07,
+	}
+	if tracker, ok := backend.(ChangeTracker); ok {
+		config.ChangeTracker = tracker
+	}
+	if checker, ok := backend.(ReadinessChecker); ok {
+		config.ReadinessChecker = checker
 	}
 
 	ic := newIngressController(config)
@@ -277,6 +388,8 @@ func registerHandlers(enableProfiling bool, port int, ic *GenericController) {
 		healthz.PingHealthz,
 		ic.cfg.Backend,
 	)
+	// expose readiness check endpoint (/readyz)
+	mux.Handle("/readyz", readyzHandler(ic.readyzChecks()...))
 
 	mux.Handle("/metrics", promhttp.Handler())
 
@@ -293,6 +406,8 @@ func registerHandlers(enableProfiling bool, port int, ic *GenericController) {
 		}
 	})
 
+	ic.cfg.Backend.RegisterHandlers(mux)
+
 	if enableProfiling {
 		mux.HandleFunc("/debug/pprof/", pprof.Index)
 		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -337,13 +452,21 @@ func buildConfigFromFlags(masterURL, kubeconfigPath string) (*rest.Config, error
 		}).ClientConfig()
 }
 
+// apiClientOptions has the rate limit and identification options of the apiserver client
+type apiClientOptions struct {
+	qps        float32
+	burst      int
+	userAgent  string
+	maxRetries int
+}
+
 // createApiserverClient creates new Kubernetes Apiserver client. When kubeconfig or apiserverHost param is empty
 // the function assumes that it is running inside a Kubernetes cluster and attempts to
 // discover the Apiserver. Otherwise, it connects to the Apiserver specified.
 //
 // apiserverHost param is in the format of protocol://address:port/pathPrefix, e.g.http://localhost:8001.
 // kubeConfig location of kubeconfig file
-func createApiserverClient(apiserverHost string, kubeConfig string) (*kubernetes.Clientset, error) {
+func createApiserverClient(apiserverHost string, kubeConfig string, options *apiClientOptions) (*kubernetes.Clientset, error) {
 	cfg, err := buildConfigFromFlags(apiserverHost, kubeConfig)
 	if err != nil {
 		return nil, err
@@ -351,6 +474,19 @@ func createApiserverClient(apiserverHost string, kubeConfig string) (*kubernetes
 
 	cfg.QPS = defaultQPS
 	cfg.Burst = defaultBurst
+	if options.qps > 0 {
+		cfg.QPS = options.qps
+		cfg.Burst = options.burst
+		if cfg.Burst < int(options.qps) {
+			cfg.Burst = int(options.qps)
+		}
+	}
+	cfg.UserAgent = options.userAgent
+	if options.maxRetries > 0 {
+		cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+			return newBackoffRoundTripper(rt, options.maxRetries)
+		}
+	}
 	cfg.ContentType = "application/vnd.kubernetes.protobuf"
 
 	glog.Infof("Creating API client for %s", cfg.Host)
@@ -382,3 +518,14 @@ func handleFatalInitError(err error) {
 		"Refer to the troubleshooting guide for more information: "+
 		"https://github.com/kubernetes/ingress-nginx/blob/master/docs/troubleshooting.md", err)
 }
+
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -24,31 +24,40 @@ import (
 
 	apiv1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 	fcache "k8s.io/client-go/tools/cache/testing"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/parser"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/status"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/k8s"
 )
 
 type cacheController struct {
-	Ingress   cache.Controller
-	Endpoint  cache.Controller
-	Service   cache.Controller
-	Node      cache.Controller
-	Secret    cache.Controller
-	Configmap cache.Controller
-	Pod       cache.Controller
+	Ingress        cache.Controller
+	Endpoint       cache.Controller
+	Service        cache.Controller
+	PublishService cache.Controller
+	Node           cache.Controller
+	Namespace      cache.Controller
+	Secret         cache.Controller
+	Configmap      cache.Controller
+	Pod            cache.Controller
 }
 
 func (c *cacheController) Run(stopCh chan struct{}) {
 	go c.Ingress.Run(stopCh)
 	go c.Endpoint.Run(stopCh)
 	go c.Service.Run(stopCh)
+	go c.PublishService.Run(stopCh)
 	go c.Node.Run(stopCh)
+	go c.Namespace.Run(stopCh)
 	go c.Secret.Run(stopCh)
 	go c.Configmap.Run(stopCh)
 	go c.Pod.Run(stopCh)
@@ -58,7 +67,9 @@ func (c *cacheController) Run(stopCh chan struct{}) {
 		c.Ingress.HasSynced,
 		c.Endpoint.HasSynced,
 		c.Service.HasSynced,
+		c.PublishService.HasSynced,
 		c.Node.HasSynced,
+		c.Namespace.HasSynced,
 		c.Secret.HasSynced,
 		c.Configmap.HasSynced,
 		c.Pod.HasSynced,
@@ -67,7 +78,7 @@ func (c *cacheController) Run(stopCh chan struct{}) {
 	}
 }
 
-func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.StoreLister, *cacheController) {
+func (ic *GenericController) createListers(disableNodeLister, enableNamespaceLister bool) (*ingress.StoreLister, *cacheController) {
 	// from here to the end of the method all the code is just boilerplate
 	// required to watch Ingress, Secrets, ConfigMaps and Endoints.
 	// This is used to detect new content, updates or removals and act accordingly
@@ -80,7 +91,7 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 				return
 			}
 			ic.recorder.Eventf(addIng, apiv1.EventTypeNormal, "CREATE", fmt.Sprintf("Ingress %s/%s", addIng.Namespace, addIng.Name))
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		DeleteFunc: func(obj interface{}) {
 			delIng, ok := obj.(*extensions.Ingress)
@@ -102,7 +113,7 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 				return
 			}
 			ic.recorder.Eventf(delIng, apiv1.EventTypeNormal, "DELETE", fmt.Sprintf("Ingress %s/%s", delIng.Namespace, delIng.Name))
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			oldIng := old.(*extensions.Ingress)
@@ -119,20 +130,25 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 				ic.recorder.Eventf(curIng, apiv1.EventTypeNormal, "UPDATE", fmt.Sprintf("Ingress %s/%s", curIng.Namespace, curIng.Name))
 			}
 
-			ic.syncQueue.Enqueue(cur)
+			ic.enqueue(cur)
 		},
 	}
 
 	secrEventHandler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		UpdateFunc: func(old, cur interface{}) {
-			if !reflect.DeepEqual(old, cur) {
-				sec := cur.(*apiv1.Secret)
-				key := fmt.Sprintf("%v/%v", sec.Namespace, sec.Name)
-				ic.syncSecret(key)
+			oldSec := old.(*apiv1.Secret)
+			curSec := cur.(*apiv1.Secret)
+			key := fmt.Sprintf("%v/%v", curSec.Namespace, curSec.Name)
+			if !secretContentChanged(oldSec, curSec) {
+				// metadata only updates, eg a re-applied manifest, don't change
+				// certificates or auth files and shouldn't rewrite the configuration
+				glog.V(3).Infof("ignoring update of secret %v: content didn't change", key)
+				return
 			}
+			ic.syncSecret(key)
 		},
 		DeleteFunc: func(obj interface{}) {
 			sec, ok := obj.(*apiv1.Secret)
@@ -151,22 +167,22 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 			}
 			key := fmt.Sprintf("%v/%v", sec.Namespace, sec.Name)
 			ic.sslCertTracker.DeleteAll(key)
-			ic.syncQueue.Enqueue(sec)
+			ic.enqueue(sec)
 		},
 	}
 
 	eventHandler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		DeleteFunc: func(obj interface{}) {
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			oep := old.(*apiv1.Endpoints)
 			ocur := cur.(*apiv1.Endpoints)
 			if !reflect.DeepEqual(ocur.Subsets, oep.Subsets) {
-				ic.syncQueue.Enqueue(cur)
+				ic.enqueue(cur)
 			}
 		},
 	}
@@ -193,57 +209,103 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 				// updates to configuration configmaps can trigger an update
 				if mapKey == ic.cfg.ConfigMapName || mapKey == ic.cfg.TCPConfigMapName || mapKey == ic.cfg.UDPConfigMapName {
 					ic.recorder.Eventf(upCmap, apiv1.EventTypeNormal, "UPDATE", fmt.Sprintf("ConfigMap %v", mapKey))
-					ic.syncQueue.Enqueue(cur)
+					ic.enqueue(cur)
 				}
 			}
 		},
 	}
 
+	// the published service has its own informer, so it's found
+	// even if it's outside of the watched namespaces or labels
+	pubSvcEventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if ic.syncStatus != nil {
+				ic.syncStatus.Trigger()
+			}
+			ic.enqueue(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			oldSvc := old.(*apiv1.Service)
+			curSvc := cur.(*apiv1.Service)
+			if status.PublishAddressesChanged(oldSvc, curSvc) {
+				glog.Infof("addresses of the published service %v changed", ic.cfg.PublishService)
+				if ic.syncStatus != nil {
+					ic.syncStatus.Trigger()
+				}
+			}
+			// ports of the published service are advertised, eg in the alt-svc header of HTTP/3
+			if !reflect.DeepEqual(oldSvc.Spec.Ports, curSvc.Spec.Ports) {
+				ic.enqueue(cur)
+			}
+		},
+	}
+
+	nsEventHandler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			oldNs := old.(*apiv1.Namespace)
+			curNs := cur.(*apiv1.Namespace)
+			// annotations of the namespace can allow or deny the access to its secrets
+			if !reflect.DeepEqual(oldNs.Annotations, curNs.Annotations) {
+				ic.enqueue(cur)
+			}
+		},
+	}
+
 	podEventHandler := cache.ResourceEventHandlerFuncs{
 		DeleteFunc: func(obj interface{}) {
-			ic.syncQueue.Enqueue(obj)
+			ic.enqueue(obj)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			oldPod := old.(*apiv1.Pod)
 			newPod := cur.(*apiv1.Pod)
 			if oldPod.DeletionTimestamp != newPod.DeletionTimestamp {
-				ic.syncQueue.Enqueue(cur)
+				ic.enqueue(cur)
 			}
 		},
 	}
 
-	watchNs := apiv1.NamespaceAll
-	if ic.cfg.ForceNamespaceIsolation && ic.cfg.Namespace != apiv1.NamespaceAll {
-		watchNs = ic.cfg.Namespace
+	var watchNs []string
+	if ic.cfg.ForceNamespaceIsolation {
+		watchNs = ic.cfg.Namespaces
 	}
 
 	lister := &ingress.StoreLister{}
 
 	controller := &cacheController{}
 
-	lister.Ingress.Store, controller.Ingress = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.ExtensionsV1beta1().RESTClient(), "ingresses", ic.cfg.Namespace, fields.Everything()),
-		&extensions.Ingress{}, ic.cfg.ResyncPeriod, ingEventHandler)
+	lister.Ingress.Store, controller.Ingress = ic.newInformer(
+		ic.cfg.Client.ExtensionsV1beta1().RESTClient(), "ingresses", ic.cfg.Namespaces, ic.cfg.WatchSelector,
+		&extensions.Ingress{}, ingEventHandler)
 
-	lister.Endpoint.Store, controller.Endpoint = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "endpoints", watchNs, fields.Everything()),
-		&apiv1.Endpoints{}, ic.cfg.ResyncPeriod, eventHandler)
+	lister.Endpoint.Store, controller.Endpoint = ic.newInformer(
+		ic.cfg.Client.CoreV1().RESTClient(), "endpoints", watchNs, "",
+		&apiv1.Endpoints{}, eventHandler)
 
-	lister.Secret.Store, controller.Secret = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "secrets", watchNs, fields.Everything()),
-		&apiv1.Secret{}, ic.cfg.ResyncPeriod, secrEventHandler)
+	lister.Secret.Store, controller.Secret = ic.newInformer(
+		ic.cfg.Client.CoreV1().RESTClient(), "secrets", watchNs, "",
+		&apiv1.Secret{}, secrEventHandler)
 
-	lister.ConfigMap.Store, controller.Configmap = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "configmaps", watchNs, fields.Everything()),
-		&apiv1.ConfigMap{}, ic.cfg.ResyncPeriod, mapEventHandler)
+	lister.ConfigMap.Store, controller.Configmap = ic.newInformer(
+		ic.cfg.Client.CoreV1().RESTClient(), "configmaps", watchNs, "",
+		&apiv1.ConfigMap{}, mapEventHandler)
 
-	lister.Service.Store, controller.Service = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "services", watchNs, fields.Everything()),
-		&apiv1.Service{}, ic.cfg.ResyncPeriod, cache.ResourceEventHandlerFuncs{})
+	lister.Service.Store, controller.Service = ic.newInformer(
+		ic.cfg.Client.CoreV1().RESTClient(), "services", watchNs, ic.cfg.WatchSelector,
+		&apiv1.Service{}, cache.ResourceEventHandlerFuncs{})
 
-	lister.Pod.Store, controller.Pod = cache.NewInformer(
-		cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "pods", ic.cfg.Namespace, fields.Everything()),
-		&apiv1.Pod{}, ic.cfg.ResyncPeriod, podEventHandler)
+	var pubSvcListerWatcher cache.ListerWatcher
+	if ns, name, err := k8s.ParseNameNS(ic.cfg.PublishService); err == nil {
+		pubSvcListerWatcher = cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "services", ns, fields.OneTermEqualSelector("metadata.name", name))
+	} else {
+		pubSvcListerWatcher = fcache.NewFakeControllerSource()
+	}
+	lister.PublishService.Store, controller.PublishService = cache.NewInformer(
+		pubSvcListerWatcher,
+		&apiv1.Service{}, ic.cfg.ResyncPeriod, pubSvcEventHandler)
+
+	lister.Pod.Store, controller.Pod = ic.newInformer(
+		ic.cfg.Client.CoreV1().RESTClient(), "pods", ic.cfg.Namespaces, "",
+		&apiv1.Pod{}, podEventHandler)
 
 	var nodeListerWatcher cache.ListerWatcher
 	if disableNodeLister {
@@ -255,5 +317,142 @@ func (ic *GenericController) createListers(disableNodeLister bool) (*ingress.Sto
 		nodeListerWatcher,
 		&apiv1.Node{}, ic.cfg.ResyncPeriod, cache.ResourceEventHandlerFuncs{})
 
+	var nsListerWatcher cache.ListerWatcher
+	if enableNamespaceLister {
+		nsListerWatcher = cache.NewListWatchFromClient(ic.cfg.Client.CoreV1().RESTClient(), "namespaces", apiv1.NamespaceAll, fields.Everything())
+	} else {
+		nsListerWatcher = fcache.NewFakeControllerSource()
+	}
+	lister.Namespace.Store, controller.Namespace = cache.NewInformer(
+		nsListerWatcher,
+		&apiv1.Namespace{}, ic.cfg.ResyncPeriod, nsEventHandler)
+
 	return lister, controller
 }
+
+// secretContentChanged returns true if the type or the content of the keys of
+// a secret changed, updates of the metadata only are ignored
+func secretContentChanged(old, cur *apiv1.Secret) bool {
+	return old.Type != cur.Type || !reflect.DeepEqual(old.Data, cur.Data)
+}
+
+// newInformer watches a resource on a list of namespaces, or on all of them if
+// the list is empty. Objects of more than one namespace are merged in a read only
+// store, so the watch and RBAC permissions can be restricted to the namespaces.
+// labelSelector, if not empty, restricts the watched objects.
+func (ic *GenericController) newInformer(client cache.Getter, resource string, namespaces []string, labelSelector string, objType kruntime.Object, handler cache.ResourceEventHandler) (cache.Store, cache.Controller) {
+	if len(namespaces) <= 1 {
+		namespace := apiv1.NamespaceAll
+		if len(namespaces) == 1 {
+			namespace = namespaces[0]
+		}
+		return cache.NewInformer(newListWatch(client, resource, namespace, labelSelector), objType, ic.cfg.ResyncPeriod, handler)
+	}
+	stores := make(multiStore, len(namespaces))
+	controllers := make(multiController, len(namespaces))
+	for i, namespace := range namespaces {
+		stores[i], controllers[i] = cache.NewInformer(newListWatch(client, resource, namespace, labelSelector), objType, ic.cfg.ResyncPeriod, handler)
+	}
+	return stores, controllers
+}
+
+func newListWatch(client cache.Getter, resource, namespace, labelSelector string) *cache.ListWatch {
+	lw := cache.NewListWatchFromClient(client, resource, namespace, fields.Everything())
+	if labelSelector == "" {
+		return lw
+	}
+	listFunc, watchFunc := lw.ListFunc, lw.WatchFunc
+	lw.ListFunc = func(options metav1.ListOptions) (kruntime.Object, error) {
+		options.LabelSelector = labelSelector
+		return listFunc(options)
+	}
+	lw.WatchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+		options.LabelSelector = labelSelector
+		return watchFunc(options)
+	}
+	return lw
+}
+
+// multiStore merges the stores of the informers of a resource
+// watched on more than one namespace
+type multiStore []cache.Store
+
+var errReadOnlyStore = fmt.Errorf("store of more than one namespace is read only")
+
+func (s multiStore) Add(obj interface{}) error {
+	return errReadOnlyStore
+}
+
+func (s multiStore) Update(obj interface{}) error {
+	return errReadOnlyStore
+}
+
+func (s multiStore) Delete(obj interface{}) error {
+	return errReadOnlyStore
+}
+
+func (s multiStore) List() []interface{} {
+	var items []interface{}
+	for _, store := range s {
+		items = append(items, store.List()...)
+	}
+	return items
+}
+
+func (s multiStore) ListKeys() []string {
+	var keys []string
+	for _, store := range s {
+		keys = append(keys, store.ListKeys()...)
+	}
+	return keys
+}
+
+func (s multiStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.GetByKey(key)
+}
+
+func (s multiStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	for _, store := range s {
+		if item, exists, err = store.GetByKey(key); exists || err != nil {
+			return item, exists, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (s multiStore) Replace(list []interface{}, resourceVersion string) error {
+	return errReadOnlyStore
+}
+
+func (s multiStore) Resync() error {
+	return errReadOnlyStore
+}
+
+// multiController runs the informers of a resource
+// watched on more than one namespace
+type multiController []cache.Controller
+
+func (c multiController) Run(stopCh <-chan struct{}) {
+	for _, controller := range c[1:] {
+		go controller.Run(stopCh)
+	}
+	c[0].Run(stopCh)
+}
+
+func (c multiController) HasSynced() bool {
+	for _, controller := range c {
+		if !controller.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c multiController) LastSyncResourceVersion() string {
+	// resource versions of distinct lists cannot be compared
+	return ""
+}
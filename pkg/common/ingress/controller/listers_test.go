@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMultiStore(t *testing.T) {
+	newSecret := func(namespace, name string) *apiv1.Secret {
+		return &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	store1 := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store1.Add(newSecret("ns1", "s1"))
+	store1.Add(newSecret("ns1", "s2"))
+	store2 := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store2.Add(newSecret("ns2", "s1"))
+	store := multiStore{store1, store2}
+
+	keys := store.ListKeys()
+	sort.Strings(keys)
+	expected := []string{"ns1/s1", "ns1/s2", "ns2/s1"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected keys %v, but was %v", expected, keys)
+	}
+	if items := store.List(); len(items) != 3 {
+		t.Errorf("expected 3 items, but was %d", len(items))
+	}
+	for _, key := range expected {
+		item, exists, err := store.GetByKey(key)
+		if !exists || err != nil {
+			t.Errorf("expected to find %s, exists=%t err=%v", key, exists, err)
+			continue
+		}
+		if secret := item.(*apiv1.Secret); secret.Namespace+"/"+secret.Name != key {
+			t.Errorf("expected %s, but found %s/%s", key, secret.Namespace, secret.Name)
+		}
+	}
+	if _, exists, _ := store.Get(newSecret("ns2", "s2")); exists {
+		t.Errorf("expected ns2/s2 to not be found")
+	}
+	if _, exists, _ := store.Get(newSecret("ns2", "s1")); !exists {
+		t.Errorf("expected ns2/s1 to be found")
+	}
+	if err := store.Add(newSecret("ns2", "s2")); err == nil {
+		t.Errorf("expected error adding to a read only store")
+	}
+}
+
+func TestSecretContentChanged(t *testing.T) {
+	newSecret := func(version string, labels map[string]string, data map[string]string) *apiv1.Secret {
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "s1", ResourceVersion: version, Labels: labels},
+			Type:       apiv1.SecretTypeOpaque,
+			Data:       map[string][]byte{},
+		}
+		for key, value := range data {
+			secret.Data[key] = []byte(value)
+		}
+		return secret
+	}
+	testCases := []struct {
+		old, cur *apiv1.Secret
+		expected bool
+	}{
+		// 0
+		{
+			old:      newSecret("1", nil, map[string]string{"auth": "usr:pwd"}),
+			cur:      newSecret("1", nil, map[string]string{"auth": "usr:pwd"}),
+			expected: false,
+		},
+		// 1
+		{
+			old:      newSecret("1", nil, map[string]string{"auth": "usr:pwd"}),
+			cur:      newSecret("2", map[string]string{"app": "echo"}, map[string]string{"auth": "usr:pwd"}),
+			expected: false,
+		},
+		// 2
+		{
+			old:      newSecret("1", nil, map[string]string{"auth": "usr:pwd"}),
+			cur:      newSecret("2", nil, map[string]string{"auth": "usr:pwd2"}),
+			expected: true,
+		},
+		// 3
+		{
+			old:      newSecret("1", nil, map[string]string{"auth": "usr:pwd"}),
+			cur:      newSecret("2", nil, map[string]string{"auth": "usr:pwd", "groups": "admin: usr"}),
+			expected: true,
+		},
+		// 4
+		{
+			old: newSecret("1", nil, map[string]string{"tls.crt": "crt", "tls.key": "key"}),
+			cur: func() *apiv1.Secret {
+				s := newSecret("2", nil, map[string]string{"tls.crt": "crt", "tls.key": "key"})
+				s.Type = apiv1.SecretTypeTLS
+				return s
+			}(),
+			expected: true,
+		},
+	}
+	for i, test := range testCases {
+		if changed := secretContentChanged(test.old, test.cur); changed != test.expected {
+			t.Errorf("changed on %d differs - expected: %v - actual: %v", i, test.expected, changed)
+		}
+	}
+}
@@ -26,6 +26,9 @@ const (
 	ns             = "ingress_controller"
 	operation      = "count"
 	reloadLabel    = "reloads"
+	eventLabel     = "event"
+	receivedLabel  = "received"
+	coalescedLabel = "coalesced"
 	sslLabelExpire = "ssl_expire_time_seconds"
 	sslLabelHost   = "host"
 )
@@ -34,6 +37,7 @@ func init() {
 	prometheus.MustRegister(reloadOperation)
 	prometheus.MustRegister(reloadOperationErrors)
 	prometheus.MustRegister(sslExpireTime)
+	prometheus.MustRegister(syncEvents)
 
 }
 
@@ -63,6 +67,15 @@ var (
 		},
 		[]string{sslLabelHost},
 	)
+	syncEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "sync_events",
+			Help: "Cumulative number of events which asked for a configuration update. Events merged into " +
+				"an update scheduled by a previous event, eg due to rate-limit-update or wait-before-update, are coalesced",
+		},
+		[]string{eventLabel},
+	)
 )
 
 func incReloadCount() {
@@ -73,6 +86,10 @@ func incReloadErrorCount() {
 	reloadOperationErrors.WithLabelValues(reloadLabel).Inc()
 }
 
+func incSyncEventCount(event string) {
+	syncEvents.WithLabelValues(event).Inc()
+}
+
 func setSSLExpireTime(servers []*ingress.Server) {
 
 	for _, s := range servers {
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+// readiness has the result of the syncs, used by the readyz endpoint
+type readiness struct {
+	mutex  sync.Mutex
+	synced bool
+	err    error
+}
+
+func (r *readiness) update(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.synced = true
+	r.err = err
+}
+
+func (r *readiness) checkInitialSync(_ *http.Request) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.synced {
+		return fmt.Errorf("initial sync hasn't completed")
+	}
+	return nil
+}
+
+func (r *readiness) checkLastSync(_ *http.Request) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return fmt.Errorf("last sync failed: %v", r.err)
+	}
+	return nil
+}
+
+// sync runs syncIngress and saves its result
+func (ic *GenericController) sync(item interface{}) error {
+	err := ic.syncIngress(item)
+	ic.readiness.update(err)
+	return err
+}
+
+func (ic *GenericController) readyzChecks() []healthz.HealthzChecker {
+	checks := []healthz.HealthzChecker{
+		healthz.PingHealthz,
+		healthz.NamedCheck("initial-sync", ic.readiness.checkInitialSync),
+		healthz.NamedCheck("last-sync", ic.readiness.checkLastSync),
+	}
+	if ic.cfg.ReadinessChecker != nil {
+		checks = append(checks, healthz.NamedCheck("backend", func(_ *http.Request) error {
+			return ic.cfg.ReadinessChecker.Ready()
+		}))
+	}
+	return checks
+}
+
+// readyzHandler runs all the checks and answers 503 if any of them fails.
+// Unlike healthz, failure reasons are always written: the controller is
+// ready only when the proxy is working, so they are useful on a rollout.
+func readyzHandler(checks ...healthz.HealthzChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		out := &bytes.Buffer{}
+		for _, check := range checks {
+			if err := check.Check(r); err != nil {
+				fmt.Fprintf(out, "[-]%s failed: %v\n", check.Name(), err)
+				failed = true
+			} else {
+				fmt.Fprintf(out, "[+]%s ok\n", check.Name())
+			}
+		}
+		if failed {
+			http.Error(w, out.String()+"readyz check failed", http.StatusServiceUnavailable)
+			return
+		}
+		if _, found := r.URL.Query()["verbose"]; !found {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		out.WriteTo(w)
+		fmt.Fprint(w, "readyz check passed\n")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type readinessCheckerMock struct {
+	err error
+}
+
+func (r *readinessCheckerMock) Ready() error {
+	return r.err
+}
+
+func TestReadyz(t *testing.T) {
+	testCases := []struct {
+		synced   bool
+		syncErr  error
+		backend  error
+		code     int
+		expected string
+	}{
+		// 0
+		{
+			code: http.StatusServiceUnavailable,
+			expected: `[+]ping ok
+[-]initial-sync failed: initial sync hasn't completed
+[+]last-sync ok
+[+]backend ok
+readyz check failed
+`,
+		},
+		// 1
+		{
+			synced:   true,
+			code:     http.StatusOK,
+			expected: `ok`,
+		},
+		// 2
+		{
+			synced:  true,
+			syncErr: fmt.Errorf("invalid config"),
+			code:    http.StatusServiceUnavailable,
+			expected: `[+]ping ok
+[+]initial-sync ok
+[-]last-sync failed: last sync failed: invalid config
+[+]backend ok
+readyz check failed
+`,
+		},
+		// 3
+		{
+			synced:  true,
+			backend: fmt.Errorf("haproxy is not running"),
+			code:    http.StatusServiceUnavailable,
+			expected: `[+]ping ok
+[+]initial-sync ok
+[+]last-sync ok
+[-]backend failed: haproxy is not running
+readyz check failed
+`,
+		},
+	}
+	for i, test := range testCases {
+		ic := &GenericController{
+			cfg: &Configuration{
+				ReadinessChecker: &readinessCheckerMock{err: test.backend},
+			},
+			readiness: &readiness{},
+		}
+		if test.synced {
+			ic.readiness.update(test.syncErr)
+		}
+		w := httptest.NewRecorder()
+		readyzHandler(ic.readyzChecks()...)(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != test.code {
+			t.Errorf("expected code %d on %d, found %d", test.code, i, w.Code)
+		}
+		if body := w.Body.String(); body != test.expected {
+			t.Errorf("body differs on %d\nexpected:\n%s\nactual:\n%s", i, test.expected, body)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// ConfigMapsElectionLock stores the leader in an annotation of a ConfigMap
+	ConfigMapsElectionLock = resourcelock.ConfigMapsResourceLock
+	// LeasesElectionLock stores the leader in a coordination.k8s.io Lease
+	LeasesElectionLock = "leases"
+
+	leaseGroupVersion = "coordination.k8s.io/v1"
+)
+
+// leaseLock implements the leader election resource lock using Lease objects.
+// The vendored client-go doesn't have a typed client of the coordination API,
+// so the lease is read and written using its JSON representation.
+type leaseLock struct {
+	client     rest.Interface
+	namespace  string
+	name       string
+	lockConfig resourcelock.ResourceLockConfig
+	lease      *leaseResource
+}
+
+type leaseResource struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       leaseSpec         `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string            `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int               `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *metav1.MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *metav1.MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int               `json:"leaseTransitions,omitempty"`
+}
+
+func (l *leaseLock) path(name string) string {
+	path := fmt.Sprintf("/apis/%s/namespaces/%s/leases", leaseGroupVersion, l.namespace)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// Get returns the election record from the Lease
+func (l *leaseLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	raw, err := l.client.Get().AbsPath(l.path(l.name)).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	lease := &leaseResource{}
+	if err := json.Unmarshal(raw, lease); err != nil {
+		return nil, errors.Wrapf(err, "error reading lease %s", l.Describe())
+	}
+	l.lease = lease
+	record := &resourcelock.LeaderElectionRecord{
+		HolderIdentity:       lease.Spec.HolderIdentity,
+		LeaseDurationSeconds: lease.Spec.LeaseDurationSeconds,
+		LeaderTransitions:    lease.Spec.LeaseTransitions,
+	}
+	if lease.Spec.AcquireTime != nil {
+		record.AcquireTime = metav1.NewTime(lease.Spec.AcquireTime.Time)
+	}
+	if lease.Spec.RenewTime != nil {
+		record.RenewTime = metav1.NewTime(lease.Spec.RenewTime.Time)
+	}
+	return record, nil
+}
+
+// Create attempts to create a Lease with the election record
+func (l *leaseLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	lease := &leaseResource{
+		APIVersion: leaseGroupVersion,
+		Kind:       "Lease",
+		Metadata: metav1.ObjectMeta{
+			Namespace: l.namespace,
+			Name:      l.name,
+		},
+	}
+	return l.write(l.client.Post().AbsPath(l.path("")), lease, ler)
+}
+
+// Update updates the election record of an existing Lease
+func (l *leaseLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if l.lease == nil {
+		return fmt.Errorf("lease not initialized, call get or create first")
+	}
+	return l.write(l.client.Put().AbsPath(l.path(l.name)), l.lease, ler)
+}
+
+func (l *leaseLock) write(req *rest.Request, lease *leaseResource, ler resourcelock.LeaderElectionRecord) error {
+	acquireTime := metav1.NewMicroTime(ler.AcquireTime.Time)
+	renewTime := metav1.NewMicroTime(ler.RenewTime.Time)
+	lease.Spec = leaseSpec{
+		HolderIdentity:       ler.HolderIdentity,
+		LeaseDurationSeconds: ler.LeaseDurationSeconds,
+		AcquireTime:          &acquireTime,
+		RenewTime:            &renewTime,
+		LeaseTransitions:     ler.LeaderTransitions,
+	}
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	raw, err := req.SetHeader("Content-Type", "application/json").Body(body).DoRaw()
+	if err != nil {
+		return err
+	}
+	updated := &leaseResource{}
+	if err := json.Unmarshal(raw, updated); err != nil {
+		return errors.Wrapf(err, "error reading lease %s", l.Describe())
+	}
+	l.lease = updated
+	return nil
+}
+
+// RecordEvent logs the leader election events, Lease isn't
+// registered in the scheme used by the event recorder
+func (l *leaseLock) RecordEvent(s string) {
+	glog.Infof("%v %v on lease %v", l.lockConfig.Identity, s, l.Describe())
+}
+
+// Describe returns the namespace/name of the Lease
+func (l *leaseLock) Describe() string {
+	return fmt.Sprintf("%v/%v", l.namespace, l.name)
+}
+
+// Identity returns the identity of this controller instance
+func (l *leaseLock) Identity() string {
+	return l.lockConfig.Identity
+}
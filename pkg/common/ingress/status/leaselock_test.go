@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestLeaseLock(t *testing.T) {
+	var stored []byte
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case http.MethodPost, http.MethodPut:
+			stored, _ = ioutil.ReadAll(r.Body)
+		}
+		w.Write(stored)
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	lock := &leaseLock{
+		client:     client.CoreV1().RESTClient(),
+		namespace:  "ingress",
+		name:       "leader-haproxy",
+		lockConfig: resourcelock.ResourceLockConfig{Identity: "pod1"},
+	}
+
+	if _, err := lock.Get(); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected not found error, found: %v", err)
+	}
+	now := metav1.NewTime(time.Unix(1500000000, 0))
+	record := resourcelock.LeaderElectionRecord{
+		HolderIdentity:       "pod1",
+		LeaseDurationSeconds: 30,
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+	if err := lock.Create(record); err != nil {
+		t.Errorf("error creating lease: %v", err)
+	}
+	current, err := lock.Get()
+	if err != nil {
+		t.Fatalf("error reading lease: %v", err)
+	}
+	if current.HolderIdentity != "pod1" || current.LeaseDurationSeconds != 30 || !current.RenewTime.Equal(&now) {
+		t.Errorf("unexpected record: %+v", current)
+	}
+	record.HolderIdentity = "pod2"
+	record.LeaderTransitions = 1
+	if err := lock.Update(record); err != nil {
+		t.Errorf("error updating lease: %v", err)
+	}
+	current, err = lock.Get()
+	if err != nil {
+		t.Fatalf("error reading lease: %v", err)
+	}
+	if current.HolderIdentity != "pod2" || current.LeaderTransitions != 1 {
+		t.Errorf("unexpected record: %+v", current)
+	}
+
+	path := "/apis/coordination.k8s.io/v1/namespaces/ingress/leases"
+	expected := []string{
+		"GET " + path + "/leader-haproxy",
+		"POST " + path,
+		"GET " + path + "/leader-haproxy",
+		"PUT " + path + "/leader-haproxy",
+		"GET " + path + "/leader-haproxy",
+	}
+	if len(methods) != len(expected) {
+		t.Fatalf("expected requests %v, found %v", expected, methods)
+	}
+	for i := range expected {
+		if methods[i] != expected[i] {
+			t.Errorf("expected request '%s', found '%s'", expected[i], methods[i])
+		}
+	}
+}
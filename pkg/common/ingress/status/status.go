@@ -48,12 +48,17 @@ import (
 
 const (
 	updateInterval = 60 * time.Second
+
+	// ExternalDNSTargetAnnotation overrides the status of an ingress as the target of its DNS records
+	ExternalDNSTargetAnnotation = "external-dns.alpha.kubernetes.io/target"
 )
 
 // Sync ...
 type Sync interface {
 	Run(stopCh <-chan struct{})
 	Shutdown()
+	Trigger()
+	IsLeader() bool
 }
 
 // Config ...
@@ -62,10 +67,23 @@ type Config struct {
 
 	PublishService string
 
+	// PublishStatusAddress, if not empty, is used as the status of
+	// the ingress resources instead of the service or node addresses
+	PublishStatusAddress []string
+
 	ElectionID string
 
+	// ElectionLock is the kind of resource used to store the leader,
+	// ConfigMapsElectionLock or LeasesElectionLock
+	ElectionLock string
+
 	UpdateStatusOnShutdown bool
 
+	// UpdateExternalDNSTarget, if true, keeps the external-dns target
+	// annotation of the ingress resources that declare it in sync with
+	// their status, eg when the published service is re-provisioned
+	UpdateExternalDNSTarget bool
+
 	UseNodeInternalIP bool
 
 	IngressLister store.IngressLister
@@ -106,6 +124,18 @@ func (s *statusSync) update() {
 	s.syncQueue.Enqueue("sync status")
 }
 
+// Trigger enqueues a status update without waiting for the next periodic check,
+// eg when the addresses of the published service change
+func (s statusSync) Trigger() {
+	s.syncQueue.Enqueue("sync status")
+}
+
+// IsLeader returns true if this instance is the current leader, used by
+// tasks that should run in only one of the controller replicas
+func (s statusSync) IsLeader() bool {
+	return s.elector.IsLeader()
+}
+
 // Shutdown stop the sync. In case the instance is the leader it will remove the current IP
 // if there is no other instances running.
 func (s statusSync) Shutdown() {
@@ -208,18 +238,30 @@ func NewStatusSyncer(config Config) Sync {
 		Host:      hostname,
 	})
 
-	lock := resourcelock.ConfigMapLock{
-		ConfigMapMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: electionID},
-		Client:        config.Client.CoreV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity:      pod.Name,
-			EventRecorder: recorder,
-		},
+	lockConfig := resourcelock.ResourceLockConfig{
+		Identity:      pod.Name,
+		EventRecorder: recorder,
+	}
+	var lock resourcelock.Interface
+	switch config.ElectionLock {
+	case LeasesElectionLock:
+		lock = &leaseLock{
+			client:     config.Client.CoreV1().RESTClient(),
+			namespace:  pod.Namespace,
+			name:       electionID,
+			lockConfig: lockConfig,
+		}
+	default:
+		lock = &resourcelock.ConfigMapLock{
+			ConfigMapMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: electionID},
+			Client:        config.Client.CoreV1(),
+			LockConfig:    lockConfig,
+		}
 	}
 
 	ttl := 30 * time.Second
 	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
-		Lock:          &lock,
+		Lock:          lock,
 		LeaseDuration: ttl,
 		RenewDeadline: ttl / 2,
 		RetryPeriod:   ttl / 4,
@@ -237,6 +279,10 @@ func NewStatusSyncer(config Config) Sync {
 // runningAddresses returns a list of IP addresses and/or FQDN where the
 // ingress controller is currently running
 func (s *statusSync) runningAddresses() ([]string, error) {
+	if len(s.PublishStatusAddress) > 0 {
+		return s.PublishStatusAddress, nil
+	}
+
 	if s.PublishService != "" {
 		ns, name, _ := k8s.ParseNameNS(s.PublishService)
 		svc, err := s.Client.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
@@ -244,19 +290,7 @@ func (s *statusSync) runningAddresses() ([]string, error) {
 			return nil, err
 		}
 
-		addrs := []string{}
-		for _, ip := range svc.Status.LoadBalancer.Ingress {
-			if ip.IP == "" {
-				addrs = append(addrs, ip.Hostname)
-			} else {
-				addrs = append(addrs, ip.IP)
-			}
-		}
-		for _, ip := range svc.Spec.ExternalIPs {
-			addrs = append(addrs, ip)
-		}
-
-		return addrs, nil
+		return serviceAddresses(svc), nil
 	}
 
 	// get information about all the pods running the ingress controller
@@ -277,6 +311,36 @@ func (s *statusSync) runningAddresses() ([]string, error) {
 	return addrs, nil
 }
 
+// serviceAddresses returns the load balancer IPs or hostnames and the external IPs of a service,
+// or the external name of an ExternalName service
+func serviceAddresses(svc *apiv1.Service) []string {
+	addrs := []string{}
+	if svc.Spec.Type == apiv1.ServiceTypeExternalName {
+		return append(addrs, svc.Spec.ExternalName)
+	}
+	for _, ip := range svc.Status.LoadBalancer.Ingress {
+		if ip.IP == "" {
+			addrs = append(addrs, ip.Hostname)
+		} else {
+			addrs = append(addrs, ip.IP)
+		}
+	}
+	for _, ip := range svc.Spec.ExternalIPs {
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
+// PublishAddressesChanged returns true if the addresses used in the ingress
+// status differ between two versions of the published service
+func PublishAddressesChanged(old, cur *apiv1.Service) bool {
+	oldAddrs := serviceAddresses(old)
+	curAddrs := serviceAddresses(cur)
+	sort.Strings(oldAddrs)
+	sort.Strings(curAddrs)
+	return strings.Join(oldAddrs, ",") != strings.Join(curAddrs, ",")
+}
+
 func (s *statusSync) isRunningMultiplePods() bool {
 	pods, err := s.Client.CoreV1().Pods(s.pod.Namespace).List(metav1.ListOptions{
 		LabelSelector: labels.SelectorFromSet(s.pod.Labels).String(),
@@ -324,7 +388,7 @@ func (s *statusSync) updateStatus(newIngressPoint []apiv1.LoadBalancerIngress) {
 			continue
 		}
 
-		batch.Queue(runUpdate(ing, newIngressPoint, s.Client, s.CustomIngressStatus))
+		batch.Queue(runUpdate(ing, newIngressPoint, s.Client, s.CustomIngressStatus, s.UpdateExternalDNSTarget))
 	}
 
 	batch.QueueComplete()
@@ -333,7 +397,8 @@ func (s *statusSync) updateStatus(newIngressPoint []apiv1.LoadBalancerIngress) {
 
 func runUpdate(ing *extensions.Ingress, status []apiv1.LoadBalancerIngress,
 	client clientset.Interface,
-	statusFunc func(*extensions.Ingress) []apiv1.LoadBalancerIngress) pool.WorkFunc {
+	statusFunc func(*extensions.Ingress) []apiv1.LoadBalancerIngress,
+	updateTarget bool) pool.WorkFunc {
 	return func(wu pool.WorkUnit) (interface{}, error) {
 		if wu.IsCancelled() {
 			return nil, nil
@@ -349,7 +414,12 @@ func runUpdate(ing *extensions.Ingress, status []apiv1.LoadBalancerIngress,
 		curIPs := ing.Status.LoadBalancer.Ingress
 		sort.SliceStable(curIPs, lessLoadBalancerIngress(curIPs))
 
-		if ingressSliceEqual(addrs, curIPs) {
+		statusChanged := !ingressSliceEqual(addrs, curIPs)
+		target, targetChanged := "", false
+		if updateTarget {
+			target, targetChanged = externalDNSTarget(ing, addrs)
+		}
+		if !statusChanged && !targetChanged {
 			glog.V(3).Infof("skipping update of Ingress %v/%v (no change)", ing.Namespace, ing.Name)
 			return true, nil
 		}
@@ -361,17 +431,49 @@ func runUpdate(ing *extensions.Ingress, status []apiv1.LoadBalancerIngress,
 			return nil, errors.Wrap(err, fmt.Sprintf("unexpected error searching Ingress %v/%v", ing.Namespace, ing.Name))
 		}
 
-		glog.Infof("updating Ingress %v/%v status to %v", currIng.Namespace, currIng.Name, addrs)
-		currIng.Status.LoadBalancer.Ingress = addrs
-		_, err = ingClient.UpdateStatus(currIng)
-		if err != nil {
-			glog.Warningf("error updating ingress rule: %v", err)
+		if targetChanged {
+			glog.Infof("updating Ingress %v/%v external-dns target to %v", currIng.Namespace, currIng.Name, target)
+			currIng.Annotations[ExternalDNSTargetAnnotation] = target
+			currIng, err = ingClient.Update(currIng)
+			if err != nil {
+				glog.Warningf("error updating ingress rule: %v", err)
+				return true, nil
+			}
+		}
+
+		if statusChanged {
+			glog.Infof("updating Ingress %v/%v status to %v", currIng.Namespace, currIng.Name, addrs)
+			currIng.Status.LoadBalancer.Ingress = addrs
+			_, err = ingClient.UpdateStatus(currIng)
+			if err != nil {
+				glog.Warningf("error updating ingress rule: %v", err)
+			}
 		}
 
 		return true, nil
 	}
 }
 
+// externalDNSTarget returns the addresses of the status as the external-dns
+// target of an ingress, and if the ingress declares a distinct target. Ingress
+// resources without the annotation are left as is, external-dns reads their status
+func externalDNSTarget(ing *extensions.Ingress, addrs []apiv1.LoadBalancerIngress) (string, bool) {
+	cur, found := ing.Annotations[ExternalDNSTargetAnnotation]
+	if !found || len(addrs) == 0 {
+		return "", false
+	}
+	targets := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if addr.IP != "" {
+			targets[i] = addr.IP
+		} else {
+			targets[i] = addr.Hostname
+		}
+	}
+	target := strings.Join(targets, ",")
+	return target, target != cur
+}
+
 func lessLoadBalancerIngress(addrs []apiv1.LoadBalancerIngress) func(int, int) bool {
 	return func(a, b int) bool {
 		switch strings.Compare(addrs[a].Hostname, addrs[b].Hostname) {
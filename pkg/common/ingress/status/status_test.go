@@ -18,6 +18,7 @@ package status
 
 import (
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -373,6 +374,29 @@ func TestRunningAddresessWithPods(t *testing.T) {
 	}
 }
 
+func TestRunningAddresessWithPublishStatusAddress(t *testing.T) {
+	fk := buildStatusSync()
+	fk.PublishStatusAddress = []string{"10.0.0.10", "lb.example.com"}
+
+	r, _ := fk.runningAddresses()
+	if !reflect.DeepEqual(r, fk.PublishStatusAddress) {
+		t.Errorf("returned %v but expected %v", r, fk.PublishStatusAddress)
+	}
+}
+
+func TestServiceAddressesExternalName(t *testing.T) {
+	svc := &apiv1.Service{
+		Spec: apiv1.ServiceSpec{
+			Type:         apiv1.ServiceTypeExternalName,
+			ExternalName: "lb.example.com",
+		},
+	}
+	r := serviceAddresses(svc)
+	if !reflect.DeepEqual(r, []string{"lb.example.com"}) {
+		t.Errorf("returned %v but expected %v", r, []string{"lb.example.com"})
+	}
+}
+
 /*
 TODO: this test requires a refactoring
 func TestUpdateStatus(t *testing.T) {
@@ -461,3 +485,71 @@ func TestIngressSliceEqual(t *testing.T) {
 		}
 	}
 }
+
+func TestPublishAddressesChanged(t *testing.T) {
+	buildService := func(externalIPs []string, lbs ...apiv1.LoadBalancerIngress) *apiv1.Service {
+		svc := &apiv1.Service{}
+		svc.Spec.ExternalIPs = externalIPs
+		svc.Status.LoadBalancer.Ingress = lbs
+		return svc
+	}
+	lb1 := apiv1.LoadBalancerIngress{IP: "10.0.0.1"}
+	lb2 := apiv1.LoadBalancerIngress{IP: "10.0.0.2"}
+	lbHost := apiv1.LoadBalancerIngress{Hostname: "lb.example.com"}
+
+	fooTests := []struct {
+		old *apiv1.Service
+		cur *apiv1.Service
+		er  bool
+	}{
+		{buildService(nil), buildService(nil), false},
+		{buildService(nil, lb1), buildService(nil, lb1), false},
+		{buildService(nil, lb1, lb2), buildService(nil, lb2, lb1), false},
+		{buildService(nil, lb1), buildService(nil, lb2), true},
+		{buildService(nil, lb1), buildService(nil, lbHost), true},
+		{buildService(nil), buildService(nil, lb1), true},
+		{buildService([]string{"192.168.0.1"}), buildService([]string{"192.168.0.1"}), false},
+		{buildService([]string{"192.168.0.1"}), buildService([]string{"192.168.0.2"}), true},
+	}
+
+	for _, fooTest := range fooTests {
+		r := PublishAddressesChanged(fooTest.old, fooTest.cur)
+		if r != fooTest.er {
+			t.Errorf("returned %v but expected %v", r, fooTest.er)
+		}
+	}
+}
+
+func TestExternalDNSTarget(t *testing.T) {
+	buildIngress := func(target *string) *extensions.Ingress {
+		ing := &extensions.Ingress{}
+		if target != nil {
+			ing.Annotations = map[string]string{ExternalDNSTargetAnnotation: *target}
+		}
+		return ing
+	}
+	empty := ""
+	old := "10.0.0.1"
+	cur := "10.0.0.2,lb.example.com"
+	addrs := []apiv1.LoadBalancerIngress{{IP: "10.0.0.2"}, {Hostname: "lb.example.com"}}
+
+	fooTests := []struct {
+		ing     *extensions.Ingress
+		addrs   []apiv1.LoadBalancerIngress
+		target  string
+		changed bool
+	}{
+		{buildIngress(nil), addrs, "", false},
+		{buildIngress(&old), nil, "", false},
+		{buildIngress(&old), addrs, cur, true},
+		{buildIngress(&empty), addrs, cur, true},
+		{buildIngress(&cur), addrs, cur, false},
+	}
+
+	for i, fooTest := range fooTests {
+		target, changed := externalDNSTarget(fooTest.ing, fooTest.addrs)
+		if target != fooTest.target || changed != fooTest.changed {
+			t.Errorf("returned %v/%v on %d but expected %v/%v", target, changed, i, fooTest.target, fooTest.changed)
+		}
+	}
+}
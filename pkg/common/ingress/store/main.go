@@ -85,6 +85,23 @@ type NodeLister struct {
 	cache.Store
 }
 
+// NamespaceLister makes a Store that lists Namespaces.
+type NamespaceLister struct {
+	cache.Store
+}
+
+// GetByName searches for a namespace in the local namespace Store
+func (nl *NamespaceLister) GetByName(name string) (*apiv1.Namespace, error) {
+	ns, exists, err := nl.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("namespace %v was not found", name)
+	}
+	return ns.(*apiv1.Namespace), nil
+}
+
 // EndpointLister makes a Store that lists Endpoints.
 type EndpointLister struct {
 	cache.Store
@@ -18,6 +18,7 @@ package ingress
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/agentcheck"
@@ -123,18 +124,23 @@ type Controller interface {
 	// and terminating pods are included in the list of returned pods and used to direct
 	// certain traffic (e.g., traffic using persistence) to terminating/unavailable pods.
 	DrainSupport() bool
+	// RegisterHandlers allows the backend to add its own endpoints
+	// to the healthz/metrics http server
+	RegisterHandlers(mux *http.ServeMux)
 }
 
 // StoreLister returns the configured stores for ingresses, services,
 // endpoints, secrets and configmaps.
 type StoreLister struct {
-	Ingress   store.IngressLister
-	Service   store.ServiceLister
-	Node      store.NodeLister
-	Endpoint  store.EndpointLister
-	Secret    store.SecretLister
-	ConfigMap store.ConfigMapLister
-	Pod       store.PodLister
+	Ingress        store.IngressLister
+	Service        store.ServiceLister
+	PublishService store.ServiceLister
+	Node           store.NodeLister
+	Namespace      store.NamespaceLister
+	Endpoint       store.EndpointLister
+	Secret         store.SecretLister
+	ConfigMap      store.ConfigMapLister
+	Pod            store.PodLister
 }
 
 // BackendInfo returns information about the backend.
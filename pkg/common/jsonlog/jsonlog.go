@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Entry is a log line in the JSON format
+type Entry struct {
+	Time       string  `json:"ts"`
+	Level      string  `json:"level"`
+	Caller     string  `json:"caller,omitempty"`
+	Message    string  `json:"msg"`
+	Object     *Object `json:"object,omitempty"`
+	Annotation string  `json:"annotation,omitempty"`
+}
+
+// Object is the Kubernetes resource a log line refers to
+type Object struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+var (
+	// glog header: Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+	headerRegex     = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+\d+ ([^ \]]+)\] (.*)$`)
+	objectRegex     = regexp.MustCompile(`\b(ingress|service|secret|configmap|endpoints|HAProxyBackend|HAProxyHost) '([^'/ ]+)/([^' ]+)'`)
+	annotationRegex = regexp.MustCompile(`\bannotation '([^']+)'`)
+	levels          = map[string]string{"I": "info", "W": "warning", "E": "error", "F": "fatal"}
+)
+
+// Redirect replaces the standard error of the process with a pipe, whose
+// glog formatted lines are written in the JSON format to the former stderr.
+//
+// glog doesn't have an extension point for the output format, this
+// is the only way to convert all the lines, including the ones of the
+// vendored libraries. Fatal messages are written before glog exits
+// the process and might be lost.
+func Redirect() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	go Convert(r, stderr, time.Now)
+	return nil
+}
+
+// Convert reads glog formatted lines from r and writes them to w in the JSON format.
+// Lines without a glog header are appended to the message of the former line.
+func Convert(r io.Reader, w io.Writer, now func() time.Time) {
+	reader := bufio.NewReader(r)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	var entry *Entry
+	flush := func() {
+		if entry != nil {
+			entry.fill()
+			encoder.Encode(entry)
+			entry = nil
+		}
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			if e := parseHeader(line, now()); e != nil {
+				flush()
+				entry = e
+			} else if entry != nil {
+				entry.Message += "\n" + line
+			} else {
+				entry = &Entry{Time: now().Format(time.RFC3339Nano), Level: "info", Message: line}
+			}
+		}
+		// glog writes a whole entry at once, so an empty buffer
+		// means that the current entry has no more lines
+		if reader.Buffered() == 0 {
+			flush()
+		}
+		if err != nil {
+			flush()
+			return
+		}
+	}
+}
+
+func parseHeader(line string, now time.Time) *Entry {
+	match := headerRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	ts, err := time.ParseInLocation("0102 15:04:05.000000", match[2], now.Location())
+	if err != nil {
+		return nil
+	}
+	// glog doesn't log the year, an entry newer than now was logged in the former year
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.Add(time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return &Entry{
+		Time:    ts.Format(time.RFC3339Nano),
+		Level:   levels[match[1]],
+		Caller:  match[3],
+		Message: match[4],
+	}
+}
+
+// FindObject returns the first Kubernetes resource a message refers to, eg
+// `ingress 'default/echo'`, or nil if the message doesn't refer to a resource
+func FindObject(msg string) *Object {
+	if match := objectRegex.FindStringSubmatch(msg); match != nil {
+		return &Object{Kind: match[1], Namespace: match[2], Name: match[3]}
+	}
+	return nil
+}
+
+// fill reads the object and the annotation the message refers to
+func (e *Entry) fill() {
+	e.Object = FindObject(e.Message)
+	if match := annotationRegex.FindStringSubmatch(e.Message); match != nil {
+		e.Annotation = match[1]
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvert(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		// 0
+		{
+			input:    `I0101 09:30:15.123456    4321 controller.go:184] HAProxy successfully reloaded`,
+			expected: `{"ts":"2019-01-01T09:30:15.123456Z","level":"info","caller":"controller.go:184","msg":"HAProxy successfully reloaded"}`,
+		},
+		// 1
+		{
+			input:    `W1231 23:59:59.000001      12 backend.go:289] invalid balance algorithm 'fast' on ingress 'default/echo', using 'roundrobin' instead`,
+			expected: `{"ts":"2018-12-31T23:59:59.000001Z","level":"warning","caller":"backend.go:289","msg":"invalid balance algorithm 'fast' on ingress 'default/echo', using 'roundrobin' instead","object":{"kind":"ingress","namespace":"default","name":"echo"}}`,
+		},
+		// 2
+		{
+			input:    `W0101 09:00:00.000000       1 ingress.go:459] annotation 'ingress.kubernetes.io/timeout-server' of service 'app/api' overrides HAProxyBackend 'app/api': '30s' -> '1m'`,
+			expected: `{"ts":"2019-01-01T09:00:00Z","level":"warning","caller":"ingress.go:459","msg":"annotation 'ingress.kubernetes.io/timeout-server' of service 'app/api' overrides HAProxyBackend 'app/api': '30s' -> '1m'","object":{"kind":"service","namespace":"app","name":"api"},"annotation":"ingress.kubernetes.io/timeout-server"}`,
+		},
+		// 3
+		{
+			input: `E0101 09:00:00.000000       1 instance.go:193] error reloading server:
+[ALERT] 000/000000 (1) : parsing [/etc/haproxy/haproxy.cfg:10]
+I0101 09:00:01.000000       1 controller.go:184] done`,
+			expected: `{"ts":"2019-01-01T09:00:00Z","level":"error","caller":"instance.go:193","msg":"error reloading server:\n[ALERT] 000/000000 (1) : parsing [/etc/haproxy/haproxy.cfg:10]"}
+{"ts":"2019-01-01T09:00:01Z","level":"info","caller":"controller.go:184","msg":"done"}`,
+		},
+		// 4
+		{
+			input:    `plain output`,
+			expected: `{"ts":"2019-01-01T10:00:00Z","level":"info","msg":"plain output"}`,
+		},
+	}
+	for i, test := range testCases {
+		out := &bytes.Buffer{}
+		Convert(strings.NewReader(test.input+"\n"), out, now)
+		if actual := strings.TrimSuffix(out.String(), "\n"); actual != test.expected {
+			t.Errorf("output differs on %d\nexpected: %s\nactual:   %s", i, test.expected, actual)
+		}
+	}
+}
@@ -327,6 +327,44 @@ func AddOrUpdateDHParam(name string, dh []byte) (string, error) {
 	return pemFileName, nil
 }
 
+// AddOrUpdateCRL creates a certificate revocation list file with the specified name
+func AddOrUpdateCRL(name string, crl []byte) (string, error) {
+	crlName := fmt.Sprintf("%v.crl.pem", name)
+	crlFileName := fmt.Sprintf("%v/%v", ingress.DefaultSSLDirectory, crlName)
+
+	crlBlock, _ := pem.Decode(crl)
+	if crlBlock == nil {
+		return "", fmt.Errorf("no valid PEM formatted block found")
+	}
+	// If the file does not start with 'BEGIN X509 CRL' it's invalid and must not be used.
+	if crlBlock.Type != "X509 CRL" {
+		return "", fmt.Errorf("CRL %v contains invalid data", name)
+	}
+	if _, err := x509.ParseCRL(crlBlock.Bytes); err != nil {
+		return "", err
+	}
+
+	tempCRLFile, err := ioutil.TempFile(ingress.DefaultSSLDirectory, crlName)
+	if err != nil {
+		return "", fmt.Errorf("could not create temp CRL file %v: %v", crlFileName, err)
+	}
+	if _, err := tempCRLFile.Write(crl); err != nil {
+		tempCRLFile.Close()
+		_ = os.Remove(tempCRLFile.Name())
+		return "", fmt.Errorf("could not write to CRL file %v: %v", tempCRLFile.Name(), err)
+	}
+	if err := tempCRLFile.Close(); err != nil {
+		_ = os.Remove(tempCRLFile.Name())
+		return "", fmt.Errorf("could not close temp CRL file %v: %v", tempCRLFile.Name(), err)
+	}
+	if err := os.Rename(tempCRLFile.Name(), crlFileName); err != nil {
+		return "", fmt.Errorf("could not move temp CRL file %v to destination %v: %v", tempCRLFile.Name(), crlFileName, err)
+	}
+
+	glog.V(3).Infof("Created CRL for Authentication: %v", crlFileName)
+	return crlFileName, nil
+}
+
 // GetFakeSSLCert creates a Self Signed Certificate
 // Based in the code https://golang.org/src/crypto/tls/generate_cert.go
 func GetFakeSSLCert() ([]byte, []byte) {
@@ -17,7 +17,10 @@ limitations under the License.
 package ssl
 
 import (
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -152,3 +155,30 @@ func TestAddCertAuth(t *testing.T) {
 		t.Fatalf("expected a valid CA file name")
 	}
 }
+
+func TestAddOrUpdateCRL(t *testing.T) {
+	td, err := ioutil.TempDir("", "ssl")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temporal directory: %v", err)
+	}
+	ingress.DefaultSSLDirectory = td
+
+	_, ca, err := generateRSACerts("demo-ca")
+	if err != nil {
+		t.Fatalf("unexpected error creating SSL certificate: %v", err)
+	}
+	crl, err := ca.Cert.CreateCRL(rand.Reader, ca.Key, []pkix.RevokedCertificate{}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error creating CRL: %v", err)
+	}
+	crlFileName, err := AddOrUpdateCRL("demo-crl", pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}))
+	if err != nil {
+		t.Fatalf("unexpected error creating CRL file: %v", err)
+	}
+	if crlFileName != td+"/demo-crl.crl.pem" {
+		t.Errorf("unexpected CRL file name: %v", crlFileName)
+	}
+	if _, err := AddOrUpdateCRL("demo-crl", certutil.EncodeCertPEM(ca.Cert)); err == nil {
+		t.Errorf("expected an error adding a certificate as a CRL")
+	}
+}
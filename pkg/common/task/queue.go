@@ -45,6 +45,11 @@ type Queue struct {
 
 	fn func(obj interface{}) (interface{}, error)
 
+	// wait, if assigned, blocks the worker before every sync
+	wait func()
+	// skipped, if assigned, is called for every item merged into a previous sync
+	skipped func(key interface{})
+
 	lastSync int64
 }
 
@@ -98,15 +103,20 @@ func (t *Queue) worker() {
 			}
 			return
 		}
-		ts := time.Now().UnixNano()
-
 		item := key.(Element)
 		if t.lastSync > item.Timestamp {
 			glog.V(3).Infof("skipping %v sync (%v > %v)", item.Key, t.lastSync, item.Timestamp)
+			if t.skipped != nil {
+				t.skipped(item.Key)
+			}
 			t.queue.Forget(key)
 			t.queue.Done(key)
 			continue
 		}
+		if t.wait != nil {
+			t.wait()
+		}
+		ts := time.Now().UnixNano()
 
 		glog.V(3).Infof("syncing %v", item.Key)
 		if err := t.sync(key); err != nil {
@@ -134,6 +144,20 @@ func isClosed(ch <-chan bool) bool {
 	return false
 }
 
+// SetWait configures a function called before every sync, eg a rate limiter.
+// Items enqueued while the worker is waiting are merged into the same sync.
+// Should be called before Run.
+func (t *Queue) SetWait(wait func()) {
+	t.wait = wait
+}
+
+// SetSkipped configures a function called for every item which didn't
+// start a new sync because it was merged into a previous one.
+// Should be called before Run.
+func (t *Queue) SetSkipped(skipped func(key interface{})) {
+	t.skipped = skipped
+}
+
 // Shutdown shuts down the work queue and waits for the worker to ACK
 func (t *Queue) Shutdown() {
 	t.queue.ShutDown()
@@ -158,3 +158,35 @@ func TestSkipEnqueue(t *testing.T) {
 	// shutdown queue before exit
 	q.Shutdown()
 }
+
+func TestWaitMergeEnqueue(t *testing.T) {
+	// initialize result
+	atomic.StoreUint32(&sr, 0)
+	var skipped uint32
+	q := NewCustomTaskQueue(mockSynFn, mockKeyFn)
+	q.SetWait(func() { time.Sleep(50 * time.Millisecond) })
+	q.SetSkipped(func(interface{}) { atomic.AddUint32(&skipped, 1) })
+	stopCh := make(chan struct{})
+	// run queue
+	go q.Run(time.Second, stopCh)
+	mo := mockEnqueueObj{
+		k: "testKey",
+		v: "testValue",
+	}
+	// enqueued while the first one is waiting
+	for i := 0; i < 3; i++ {
+		q.Enqueue(mo)
+		time.Sleep(time.Millisecond)
+	}
+	// wait for 'mockSynFn'
+	time.Sleep(time.Millisecond * 100)
+	if atomic.LoadUint32(&sr) != 1 {
+		t.Errorf("sr should be 1, but is %d", sr)
+	}
+	if atomic.LoadUint32(&skipped) != 2 {
+		t.Errorf("skipped should be 2, but is %d", skipped)
+	}
+
+	// shutdown queue before exit
+	q.Shutdown()
+}
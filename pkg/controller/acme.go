@@ -0,0 +1,436 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	k8scache "k8s.io/client-go/tools/cache"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/acme"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+const (
+	acmeSocket            = "/var/run/acme.sock"
+	acmeChallengePrefix   = "/.well-known/acme-challenge/"
+	acmeDomainsAnnotation = "haproxy-ingress.github.io/acme-domains"
+	acmeRetryInterval     = time.Hour
+)
+
+// acmeManager issues and renews the certificates of the TLS secrets tracked
+// by the converter. Only the leader talks to the ACME server. Challenge tokens
+// are stored in a ConfigMap, so every controller answers the http-01
+// challenges forwarded by its HAProxy, regardless of the leader. The ConfigMap
+// is watched and its tokens are kept in memory, challenges are answered
+// without reading the API server.
+type acmeManager struct {
+	mutex       sync.Mutex
+	client      kubernetes.Interface
+	isLeader    func() bool
+	namespace   string
+	keyName     string
+	tokenName   string
+	checkPeriod time.Duration
+	trigger     chan struct{}
+	config      hatypes.AcmeConfig
+	current     map[string]*acmeCert
+	certs       map[string]*acmeCert
+	tokens      map[string]string
+	// used by the check goroutine only
+	acme     *acme.Client
+	account  string
+	failures map[string]time.Time
+}
+
+type acmeCert struct {
+	domains   map[string]bool
+	annotated bool
+}
+
+func newAcmeManager(client kubernetes.Interface, isLeader func() bool, namespace, keyName, tokenName string, checkPeriod time.Duration) *acmeManager {
+	return &acmeManager{
+		client:      client,
+		isLeader:    isLeader,
+		namespace:   namespace,
+		keyName:     keyName,
+		tokenName:   tokenName,
+		checkPeriod: checkPeriod,
+		trigger:     make(chan struct{}, 1),
+		current:     map[string]*acmeCert{},
+		certs:       map[string]*acmeCert{},
+		tokens:      map[string]string{},
+		failures:    map[string]time.Time{},
+	}
+}
+
+// TrackAcme implements ingtypes.AcmeTracker
+func (m *acmeManager) TrackAcme(secretName, domain string, annotated bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cert := m.current[secretName]
+	if cert == nil {
+		cert = &acmeCert{domains: map[string]bool{}}
+		m.current[secretName] = cert
+	}
+	cert.domains[domain] = true
+	cert.annotated = cert.annotated || annotated
+}
+
+// commit finishes a sync and asks for a check of the tracked secrets
+func (m *acmeManager) commit(config hatypes.AcmeConfig) {
+	m.mutex.Lock()
+	m.certs = m.current
+	m.current = map[string]*acmeCert{}
+	m.config = config
+	m.mutex.Unlock()
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *acmeManager) run(stopCh <-chan struct{}) {
+	os.Remove(acmeSocket)
+	listener, err := net.Listen("unix", acmeSocket)
+	if err != nil {
+		glog.Fatalf("error listening on ACME socket: %v", err)
+	}
+	m.watchTokens(stopCh)
+	mux := http.NewServeMux()
+	mux.HandleFunc(acmeChallengePrefix, m.challengeHandler)
+	go func() {
+		glog.Fatalf("error serving ACME challenges: %v", http.Serve(listener, mux))
+	}()
+	go func() {
+		ticker := time.NewTicker(m.checkPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.trigger:
+			case <-ticker.C:
+			case <-stopCh:
+				listener.Close()
+				return
+			}
+			m.check()
+		}
+	}()
+}
+
+// watchTokens keeps the tokens of the ConfigMap in memory, the ConfigMap
+// is changed by the leader and read by all the controllers
+func (m *acmeManager) watchTokens(stopCh <-chan struct{}) {
+	selector := fields.OneTermEqualSelector("metadata.name", m.tokenName).String()
+	configMaps := m.client.CoreV1().ConfigMaps(m.namespace)
+	listerWatcher := &k8scache.ListWatch{
+		ListFunc: func(options meta.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return configMaps.List(options)
+		},
+		WatchFunc: func(options meta.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return configMaps.Watch(options)
+		},
+	}
+	_, controller := k8scache.NewInformer(listerWatcher, &api.ConfigMap{}, 0, k8scache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.setTokens(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			m.setTokens(cur)
+		},
+		DeleteFunc: func(obj interface{}) {
+			m.setTokens(nil)
+		},
+	})
+	go controller.Run(stopCh)
+}
+
+func (m *acmeManager) setTokens(obj interface{}) {
+	tokens := map[string]string{}
+	if cm, ok := obj.(*api.ConfigMap); ok {
+		for token, keyAuth := range cm.Data {
+			tokens[token] = keyAuth
+		}
+	}
+	m.mutex.Lock()
+	m.tokens = tokens
+	m.mutex.Unlock()
+}
+
+func (m *acmeManager) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+	m.mutex.Lock()
+	keyAuth := m.tokens[token]
+	m.mutex.Unlock()
+	if keyAuth == "" {
+		glog.V(2).Infof("ACME token not found: %s", token)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// SetToken implements acme.ChallengeResolver
+func (m *acmeManager) SetToken(domain, token, keyAuth string) error {
+	err := m.updateTokens(func(data map[string]string) {
+		data[token] = keyAuth
+	})
+	if err == nil {
+		// the ACME server might ask for the token before the leader receives the update
+		m.mutex.Lock()
+		m.tokens[token] = keyAuth
+		m.mutex.Unlock()
+	}
+	return err
+}
+
+// ClearToken implements acme.ChallengeResolver
+func (m *acmeManager) ClearToken(domain, token string) {
+	err := m.updateTokens(func(data map[string]string) {
+		delete(data, token)
+	})
+	if err != nil {
+		glog.Warningf("error removing ACME token of '%s': %v", domain, err)
+	}
+}
+
+func (m *acmeManager) updateTokens(update func(data map[string]string)) error {
+	configMaps := m.client.CoreV1().ConfigMaps(m.namespace)
+	cm, err := configMaps.Get(m.tokenName, meta.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &api.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{Name: m.tokenName, Namespace: m.namespace},
+			Data:       map[string]string{},
+		}
+		update(cm.Data)
+		_, err = configMaps.Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	update(cm.Data)
+	_, err = configMaps.Update(cm)
+	return err
+}
+
+// check issues the certificates of the secrets which don't exist, or whose
+// certificate doesn't have all the domains or is about to expire. Secrets
+// which already exist are only changed if the acme annotation is used
+// or if the secret was created by the ACME subsystem.
+func (m *acmeManager) check() {
+	m.mutex.Lock()
+	certs := m.certs
+	config := m.config
+	m.mutex.Unlock()
+	if !config.Enabled || !m.isLeader() {
+		return
+	}
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cert := certs[name]
+		domains := make([]string, 0, len(cert.domains))
+		for domain := range cert.domains {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		key := name + ":" + strings.Join(domains, ",")
+		if failure, found := m.failures[key]; found && time.Since(failure) < acmeRetryInterval {
+			continue
+		}
+		namespace, secretName := splitName(name)
+		secret, err := m.client.CoreV1().Secrets(namespace).Get(secretName, meta.GetOptions{})
+		if err == nil {
+			if !cert.annotated && secret.Annotations[acmeDomainsAnnotation] == "" {
+				// secret not managed by the ACME subsystem
+				continue
+			}
+			reason := m.renewReason(secret, domains, config.Expiring)
+			if reason == "" {
+				continue
+			}
+			glog.Infof("renewing ACME certificate of secret '%s': %s", name, reason)
+		} else if errors.IsNotFound(err) {
+			secret = nil
+		} else {
+			glog.Warningf("error reading secret '%s': %v", name, err)
+			continue
+		}
+		if err := m.issue(config, namespace, secretName, secret, domains); err != nil {
+			glog.Warningf("error issuing ACME certificate of secret '%s': %v", name, err)
+			m.failures[key] = time.Now()
+			continue
+		}
+		delete(m.failures, key)
+		glog.Infof("ACME certificate of secret '%s' issued, domains: %s", name, strings.Join(domains, ","))
+	}
+}
+
+// renewReason returns why the certificate of secret should be issued again, or an empty string
+func (m *acmeManager) renewReason(secret *api.Secret, domains []string, expiring int) string {
+	block, _ := pem.Decode(secret.Data[api.TLSCertKey])
+	if block == nil {
+		return "certificate not found"
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Sprintf("invalid certificate: %v", err)
+	}
+	for _, domain := range domains {
+		if crt.VerifyHostname(domain) != nil {
+			return fmt.Sprintf("missing domain %s", domain)
+		}
+	}
+	if time.Until(crt.NotAfter) < time.Duration(expiring)*24*time.Hour {
+		return fmt.Sprintf("expires on %s", crt.NotAfter.Format(time.RFC3339))
+	}
+	return ""
+}
+
+func (m *acmeManager) issue(config hatypes.AcmeConfig, namespace, secretName string, secret *api.Secret, domains []string) error {
+	client, err := m.acmeClient(config)
+	if err != nil {
+		return err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	crt, err := client.Sign(domains, key, m)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	secrets := m.client.CoreV1().Secrets(namespace)
+	if secret == nil {
+		secret = &api.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: secretName, Namespace: namespace},
+			Type:       api.SecretTypeTLS,
+		}
+	} else {
+		secret = secret.DeepCopy()
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[acmeDomainsAnnotation] = strings.Join(domains, ",")
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[api.TLSCertKey] = crt
+	secret.Data[api.TLSPrivateKeyKey] = keyPEM
+	if secret.ResourceVersion == "" {
+		_, err = secrets.Create(secret)
+	} else {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+// acmeClient returns a client whose account is registered, a new account is
+// registered on the first call and whenever the endpoint or the emails change
+func (m *acmeManager) acmeClient(config hatypes.AcmeConfig) (*acme.Client, error) {
+	account := config.Endpoint + ";" + config.Emails
+	if m.acme != nil && m.account == account {
+		return m.acme, nil
+	}
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, fmt.Errorf("error reading ACME account key: %v", err)
+	}
+	client := acme.NewClient(config.Endpoint, key)
+	if err := client.Register(strings.Split(config.Emails, ","), config.TermsAgreed); err != nil {
+		return nil, err
+	}
+	m.acme = client
+	m.account = account
+	return client, nil
+}
+
+// accountKey reads the private key of the ACME account, creating it if the secret doesn't exist
+func (m *acmeManager) accountKey() (*ecdsa.PrivateKey, error) {
+	secrets := m.client.CoreV1().Secrets(m.namespace)
+	secret, err := secrets.Get(m.keyName, meta.GetOptions{})
+	if err == nil {
+		block, _ := pem.Decode(secret.Data[api.TLSPrivateKeyKey])
+		if block == nil {
+			return nil, fmt.Errorf("secret '%s/%s' does not have a PEM encoded 'tls.key'", m.namespace, m.keyName)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = secrets.Create(&api.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: m.keyName, Namespace: m.namespace},
+		Data: map[string][]byte{
+			api.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("ACME account key created on secret '%s/%s'", m.namespace, m.keyName)
+	return key, nil
+}
+
+func splitName(name string) (namespace, localName string) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAcmeChallengeHandler(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("configmaps", k8stesting.DefaultWatchReactor(watcher, nil))
+	m := newAcmeManager(client, func() bool { return true }, "ingress", "acme-key", "acme-tokens", time.Hour)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	m.watchTokens(stopCh)
+	tokens := func(data map[string]string) *api.ConfigMap {
+		return &api.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{Name: "acme-tokens", Namespace: "ingress"},
+			Data:       data,
+		}
+	}
+	gets := func() int {
+		// list and watch are done by the informer
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "get" {
+				count++
+			}
+		}
+		return count
+	}
+	challenge := func(token string) string {
+		count := gets()
+		w := httptest.NewRecorder()
+		m.challengeHandler(w, httptest.NewRequest("GET", acmeChallengePrefix+token, nil))
+		if gets() != count {
+			t.Errorf("expected no API call answering the challenge of '%s'", token)
+		}
+		if w.Code != http.StatusOK {
+			return strconv.Itoa(w.Code)
+		}
+		return w.Body.String()
+	}
+	eventually := func(token, expected string) {
+		for i := 0; i < 100 && challenge(token) != expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if actual := challenge(token); actual != expected {
+			t.Errorf("challenge of '%s' differs, expected: %s, actual: %s", token, expected, actual)
+		}
+	}
+
+	// no challenge pending
+	if actual := challenge("token1"); actual != "404" {
+		t.Errorf("challenge without tokens differs, expected: 404, actual: %s", actual)
+	}
+
+	// token of the leader, answered before the watch receives the update
+	if err := m.SetToken("d1.local", "token1", "token1.key"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := challenge("token1"); actual != "token1.key" {
+		t.Errorf("challenge of the leader differs, expected: token1.key, actual: %s", actual)
+	}
+
+	// token of another controller
+	watcher.Modify(tokens(map[string]string{"token1": "token1.key", "token2": "token2.key"}))
+	eventually("token2", "token2.key")
+
+	// token removed
+	watcher.Modify(tokens(map[string]string{"token2": "token2.key"}))
+	eventually("token1", "404")
+
+	// configmap removed
+	watcher.Delete(tokens(nil))
+	eventually("token2", "404")
+}
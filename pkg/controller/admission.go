@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
+	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
+)
+
+const admissionWebhookPath = "/validate-ingress"
+
+// admissionReview is the subset of the AdmissionReview of both
+// admission.k8s.io/v1 and admission.k8s.io/v1beta1 used by the webhook
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID      string       `json:"uid"`
+	Allowed  bool         `json:"allowed"`
+	Result   *meta.Status `json:"status,omitempty"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// runAdmissionWebhook starts the HTTPS server of the validating admission webhook
+func (hc *HAProxyController) runAdmissionWebhook() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(admissionWebhookPath, hc.admissionHandler)
+	// the api server waits up to 30s for a webhook, 10s by default
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *hc.admissionPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	go func() {
+		glog.Infof("starting admission webhook on %s%s", server.Addr, admissionWebhookPath)
+		err := server.ListenAndServeTLS(*hc.admissionCert, *hc.admissionKey)
+		glog.Fatalf("admission webhook stopped: %v", err)
+	}()
+}
+
+func (hc *HAProxyController) admissionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := admissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	review.Response = hc.validateIngress(review.Request)
+	review.Request = nil
+	out, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func (hc *HAProxyController) validateIngress(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != "CREATE" && req.Operation != "UPDATE" {
+		return resp
+	}
+	// networking.k8s.io/v1beta1 and extensions/v1beta1 share the same schema
+	ing := &extensions.Ingress{}
+	if err := json.Unmarshal(req.Object, ing); err != nil {
+		resp.Allowed = false
+		resp.Result = &meta.Status{
+			Status:  meta.StatusFailure,
+			Message: fmt.Sprintf("error parsing ingress: %v", err),
+			Reason:  meta.StatusReasonBadRequest,
+			Code:    http.StatusBadRequest,
+		}
+		return resp
+	}
+	if !class.IsValid(ing, hc.cfg.IngressClass, hc.cfg.DefaultIngressClass) {
+		return resp
+	}
+	globalConfig, options := hc.syncConfig()
+	errors, warnings := ingressconverter.Validate(options, globalConfig, ing)
+	resp.Warnings = warnings
+	if len(errors) > 0 {
+		glog.Infof("rejecting ingress '%s/%s': %s", ing.Namespace, ing.Name, strings.Join(errors, "; "))
+		resp.Allowed = false
+		resp.Result = &meta.Status{
+			Status:  meta.StatusFailure,
+			Message: strings.Join(errors, "; "),
+			Reason:  meta.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return resp
+}
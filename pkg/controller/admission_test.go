@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/controller"
+	conv_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+func TestAdmissionHandler(t *testing.T) {
+	ingress := func(class, affinity string) string {
+		return `{"metadata":{"name":"app","namespace":"default","annotations":{` +
+			`"kubernetes.io/ingress.class":"` + class + `",` +
+			`"ingress.kubernetes.io/affinity":"` + affinity + `"}},` +
+			`"spec":{"rules":[{"host":"d1.local","http":{"paths":[{"path":"/","backend":{"serviceName":"app","servicePort":8080}}]}}]}}`
+	}
+	review := func(operation, object string) string {
+		return `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1234","operation":"` + operation + `","object":` + object + `}}`
+	}
+	testCases := []struct {
+		body       string
+		expCode    int
+		expAllowed bool
+		expMessage string
+	}{
+		// 0
+		{
+			body:    `{"apiVersion":"admission.k8s.io/v1"`,
+			expCode: http.StatusBadRequest,
+		},
+		// 1
+		{
+			body:    `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			expCode: http.StatusBadRequest,
+		},
+		// 2
+		{
+			body:       review("DELETE", `{}`),
+			expCode:    http.StatusOK,
+			expAllowed: true,
+		},
+		// 3
+		{
+			body:       review("CREATE", ingress("haproxy", "cookie")),
+			expCode:    http.StatusOK,
+			expAllowed: true,
+		},
+		// 4
+		{
+			body:       review("CREATE", ingress("other", "invalid")),
+			expCode:    http.StatusOK,
+			expAllowed: true,
+		},
+		// 5
+		{
+			body:       review("UPDATE", ingress("haproxy", "invalid")),
+			expCode:    http.StatusOK,
+			expMessage: "422 unsupported affinity type on service 'default/app': invalid",
+		},
+		// 6
+		{
+			body:       review("CREATE", `"app"`),
+			expCode:    http.StatusOK,
+			expMessage: "400 error parsing ingress: json: cannot unmarshal string into Go value of type v1beta1.Ingress",
+		},
+	}
+	hc := &HAProxyController{
+		cfg: &controller.Configuration{IngressClass: "haproxy", DefaultIngressClass: "haproxy"},
+		converterOptions: &ingtypes.ConverterOptions{
+			Cache: &conv_helper.CacheMock{SvcList: []*api.Service{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec: api.ServiceSpec{Ports: []api.ServicePort{{
+					Port: 8080, TargetPort: intstr.FromInt(8080),
+				}}},
+			}}},
+			AnnotationPrefix: []string{"ingress.kubernetes.io"},
+		},
+	}
+	for i, test := range testCases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", admissionWebhookPath, strings.NewReader(test.body))
+		hc.admissionHandler(w, r)
+		if w.Code != test.expCode {
+			t.Errorf("status code on %d differs, expected: %d, actual: %d", i, test.expCode, w.Code)
+		}
+		if test.expCode != http.StatusOK {
+			continue
+		}
+		actual := admissionReview{}
+		if err := json.Unmarshal(w.Body.Bytes(), &actual); err != nil {
+			t.Errorf("error parsing response on %d: %v", i, err)
+			continue
+		}
+		if actual.APIVersion != "admission.k8s.io/v1" || actual.Request != nil || actual.Response == nil {
+			t.Errorf("review on %d should preserve the api version and replace the request: %+v", i, actual)
+			continue
+		}
+		var message string
+		if result := actual.Response.Result; result != nil {
+			message = fmt.Sprintf("%d %s", result.Code, result.Message)
+		}
+		if actual.Response.UID != "1234" || actual.Response.Allowed != test.expAllowed || message != test.expMessage {
+			t.Errorf("response on %d differs, expected: allowed=%t '%s', actual: %+v '%s'", i, test.expAllowed, test.expMessage, actual.Response, message)
+		}
+	}
+}
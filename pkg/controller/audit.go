@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	auditMaxFileSize = 10 * 1024 * 1024
+	auditMaxFiles    = 5
+)
+
+// configAudit logs the differences of the HAProxy configuration file
+// applied by every update, and the objects which triggered the update
+type configAudit struct {
+	mutex    sync.Mutex
+	file     string
+	pending  map[string]bool
+	triggers []string
+}
+
+// newConfigAudit creates an audit which writes to the controller log if
+// output is `log`, or to a file rotated after 10MB otherwise
+func newConfigAudit(output string) *configAudit {
+	audit := &configAudit{
+		pending: map[string]bool{},
+	}
+	if output != "log" {
+		audit.file = output
+	}
+	return audit
+}
+
+// track saves an object which enqueued a sync
+func (a *configAudit) track(obj interface{}) {
+	if d, ok := obj.(k8scache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil || m.GetName() == "" {
+		// periodic syncs and changes on the controller config
+		return
+	}
+	kind := reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+	name := m.GetName()
+	if m.GetNamespace() != "" {
+		name = m.GetNamespace() + "/" + name
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pending[kind+" "+name] = true
+}
+
+// startSync moves the objects tracked so far to the sync which is starting
+func (a *configAudit) startSync() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.triggers = make([]string, 0, len(a.pending))
+	for trigger := range a.pending {
+		a.triggers = append(a.triggers, trigger)
+	}
+	sort.Strings(a.triggers)
+	a.pending = map[string]bool{}
+}
+
+// Audit implements haproxy.Auditor
+func (a *configAudit) Audit(action, diff string) {
+	a.mutex.Lock()
+	triggers := "periodic sync"
+	if len(a.triggers) > 0 {
+		triggers = strings.Join(a.triggers, ", ")
+	}
+	a.mutex.Unlock()
+	if a.file == "" {
+		glog.Infof("haproxy configuration changed, action: %s, triggered by: %s\n%s", action, triggers, diff)
+		return
+	}
+	record := fmt.Sprintf("# %s action: %s\n# triggered by: %s\n%s\n",
+		time.Now().Format(time.RFC3339), action, triggers, diff)
+	if err := a.write(record); err != nil {
+		glog.Warningf("error writing config audit: %v", err)
+	}
+}
+
+func (a *configAudit) write(record string) error {
+	if st, err := os.Stat(a.file); err == nil && st.Size()+int64(len(record)) > auditMaxFileSize {
+		a.rotate()
+	}
+	f, err := os.OpenFile(a.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(record); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate renames file to file.1, file.1 to file.2 and so on,
+// removing the oldest file
+func (a *configAudit) rotate() {
+	os.Remove(fmt.Sprintf("%s.%d", a.file, auditMaxFiles))
+	for i := auditMaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.file, i), fmt.Sprintf("%s.%d", a.file, i+1))
+	}
+	os.Rename(a.file, a.file+".1")
+}
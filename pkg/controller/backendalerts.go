@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// econReadings is the number of consecutive readings with new connection
+// errors needed to consider a server unreachable, so a few failed connections
+// due eg to a single restart or a network glitch don't raise an alert
+const econReadings = 3
+
+// serverStat is the health of a HAProxy server, read from `show stat`
+type serverStat struct {
+	backend string
+	server  string
+	down    bool
+	maint   bool
+	econ    int
+}
+
+// econState is the connection errors counter of a server in the last reading,
+// and the number of consecutive readings the counter has increased
+type econState struct {
+	econ   int
+	streak int
+}
+
+// backendAlerts periodically reads the state of the servers from the
+// HAProxy stats socket, notifying the unreachable servers of every backend.
+// A server is unreachable if it is down due to failing health checks, or
+// if new connection errors happened in the last econReadings readings.
+// Servers in maintenance, eg the empty slots of dynamic updates, are ignored
+type backendAlerts struct {
+	socket   string
+	interval time.Duration
+	lastEcon map[string]econState
+	notify   func(backend string, unreachable []string, total int)
+}
+
+func newBackendAlerts(socket string, interval time.Duration, notify func(backend string, unreachable []string, total int)) *backendAlerts {
+	return &backendAlerts{
+		socket:   socket,
+		interval: interval,
+		lastEcon: map[string]econState{},
+		notify:   notify,
+	}
+}
+
+func (b *backendAlerts) run(stopCh <-chan struct{}) {
+	go wait.Until(b.collect, b.interval, stopCh)
+}
+
+func (b *backendAlerts) collect() {
+	out, err := utils.HAProxyCommand(b.socket, "show stat -1 4 -1")
+	if err != nil {
+		glog.V(2).Infof("error reading show stat from haproxy: %v", err)
+		return
+	}
+	b.update(parseServerStat(out))
+}
+
+func (b *backendAlerts) update(stats []*serverStat) {
+	var backends []string
+	total := map[string]int{}
+	unreachable := map[string][]string{}
+	lastEcon := map[string]econState{}
+	for _, stat := range stats {
+		if stat.maint {
+			continue
+		}
+		if total[stat.backend] == 0 {
+			backends = append(backends, stat.backend)
+		}
+		total[stat.backend]++
+		key := stat.backend + "/" + stat.server
+		last, found := b.lastEcon[key]
+		state := econState{econ: stat.econ}
+		// counters restart on HAProxy reloads
+		if found && stat.econ > last.econ {
+			state.streak = last.streak + 1
+		}
+		if stat.down || state.streak >= econReadings {
+			unreachable[stat.backend] = append(unreachable[stat.backend], stat.server)
+		}
+		lastEcon[key] = state
+	}
+	b.lastEcon = lastEcon
+	for _, backend := range backends {
+		b.notify(backend, unreachable[backend], total[backend])
+	}
+}
+
+func parseServerStat(out string) []*serverStat {
+	var stats []*serverStat
+	for _, line := range strings.Split(out, "\n") {
+		// pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,...
+		fields := strings.Split(line, ",")
+		if len(fields) < 18 || strings.HasPrefix(line, "#") || fields[1] == "BACKEND" || fields[1] == "FRONTEND" {
+			continue
+		}
+		stat := &serverStat{
+			backend: fields[0],
+			server:  fields[1],
+			down:    strings.HasPrefix(fields[17], "DOWN"),
+			maint:   strings.HasPrefix(fields[17], "MAINT"),
+		}
+		stat.econ, _ = strconv.Atoi(fields[13])
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// podState describes why the pod of an unreachable endpoint might be failing
+func podState(pod *api.Pod) string {
+	if pod == nil {
+		return "pod not found"
+	}
+	if pod.DeletionTimestamp != nil {
+		return "terminating"
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff":
+			return "pending image pull"
+		case "CrashLoopBackOff":
+			return "crash looping"
+		}
+	}
+	switch pod.Status.Phase {
+	case api.PodPending:
+		return "pending"
+	case api.PodFailed:
+		return "failed"
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == api.PodReady && cond.Status != api.ConditionTrue {
+			return "not ready"
+		}
+	}
+	return "running and ready"
+}
+
+// summarizePodStates builds a summary of the states of the pods,
+// eg `pending image pull (2), running and ready (1)`
+func summarizePodStates(states []string) string {
+	count := map[string]int{}
+	var uniq []string
+	for _, state := range states {
+		if count[state] == 0 {
+			uniq = append(uniq, state)
+		}
+		count[state]++
+	}
+	sort.SliceStable(uniq, func(i, j int) bool {
+		if count[uniq[i]] != count[uniq[j]] {
+			return count[uniq[i]] > count[uniq[j]]
+		}
+		return uniq[i] < uniq[j]
+	})
+	summary := make([]string, len(uniq))
+	for i, state := range uniq {
+		summary[i] = fmt.Sprintf("%s (%d)", state, count[state])
+	}
+	return strings.Join(summary, ", ")
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseServerStat(t *testing.T) {
+	header := "# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight\n"
+	testCases := []struct {
+		out      string
+		expected []*serverStat
+	}{
+		// 0
+		{
+			out: header,
+		},
+		// 1
+		{
+			out: header + `
+d1_app_8080,FRONTEND,0,0,0,0,,0,0,0,0,0,0,,,,,OPEN,
+d1_app_8080,srv001,0,0,0,0,,0,0,0,,0,,2,0,0,0,UP,1
+d1_app_8080,srv002,0,0,0,0,,0,0,0,,0,,0,0,0,0,DOWN,1
+d1_app_8080,srv003,0,0,0,0,,0,0,0,,0,,0,0,0,0,DOWN 1/2,1
+d1_app_8080,_slot001,0,0,0,0,,0,0,0,,0,,0,0,0,0,MAINT,1
+d1_app_8080,BACKEND,0,0,0,0,,0,0,0,0,0,,2,0,0,0,UP,3
+`,
+			expected: []*serverStat{
+				{backend: "d1_app_8080", server: "srv001", econ: 2},
+				{backend: "d1_app_8080", server: "srv002", down: true},
+				{backend: "d1_app_8080", server: "srv003", down: true},
+				{backend: "d1_app_8080", server: "_slot001", maint: true},
+			},
+		},
+		// 2
+		{
+			out: "d1_app_8080,srv001,0,0,0\n",
+		},
+	}
+	for i, test := range testCases {
+		stats := parseServerStat(test.out)
+		if !reflect.DeepEqual(stats, test.expected) {
+			t.Errorf("stats on %d differs, expected: %+v, actual: %+v", i, test.expected, stats)
+		}
+	}
+}
+
+func TestBackendAlertsUpdate(t *testing.T) {
+	type reading struct {
+		stats    []*serverStat
+		expected string
+	}
+	testCases := [][]reading{
+		// 0
+		{
+			{
+				stats: []*serverStat{
+					{backend: "b1", server: "s1"},
+					{backend: "b1", server: "s2", down: true},
+					{backend: "b1", server: "_slot1", maint: true},
+				},
+				expected: "b1 [s2] 2",
+			},
+		},
+		// 1
+		{
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 5}},
+				expected: "b1 [] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 6}},
+				expected: "b1 [] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 7}},
+				expected: "b1 [] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 8}},
+				expected: "b1 [s1] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 8}},
+				expected: "b1 [] 1",
+			},
+		},
+		// 2
+		{
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 5}},
+				expected: "b1 [] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 6}},
+				expected: "b1 [] 1",
+			},
+			{
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 7}},
+				expected: "b1 [] 1",
+			},
+			{
+				// HAProxy reloaded
+				stats:    []*serverStat{{backend: "b1", server: "s1", econ: 1}},
+				expected: "b1 [] 1",
+			},
+		},
+	}
+	for i, readings := range testCases {
+		var notified []string
+		b := newBackendAlerts("", 0, func(backend string, unreachable []string, total int) {
+			notified = append(notified, fmt.Sprintf("%s %v %d", backend, unreachable, total))
+		})
+		for j, r := range readings {
+			notified = nil
+			b.update(r.stats)
+			actual := strings.Join(notified, ";")
+			if actual != r.expected {
+				t.Errorf("notify on %d/%d differs, expected: %s, actual: %s", i, j, r.expected, actual)
+			}
+		}
+	}
+}
+
+func TestPodState(t *testing.T) {
+	waiting := func(reason string) api.PodStatus {
+		return api.PodStatus{
+			Phase: api.PodPending,
+			ContainerStatuses: []api.ContainerStatus{{
+				State: api.ContainerState{Waiting: &api.ContainerStateWaiting{Reason: reason}},
+			}},
+		}
+	}
+	ready := func(status api.ConditionStatus) api.PodStatus {
+		return api.PodStatus{
+			Phase:      api.PodRunning,
+			Conditions: []api.PodCondition{{Type: api.PodReady, Status: status}},
+		}
+	}
+	now := metav1.Now()
+	testCases := []struct {
+		pod      *api.Pod
+		expected string
+	}{
+		// 0
+		{
+			expected: "pod not found",
+		},
+		// 1
+		{
+			pod:      &api.Pod{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}, Status: ready(api.ConditionTrue)},
+			expected: "terminating",
+		},
+		// 2
+		{
+			pod:      &api.Pod{Status: waiting("ErrImagePull")},
+			expected: "pending image pull",
+		},
+		// 3
+		{
+			pod:      &api.Pod{Status: waiting("ImagePullBackOff")},
+			expected: "pending image pull",
+		},
+		// 4
+		{
+			pod:      &api.Pod{Status: waiting("CrashLoopBackOff")},
+			expected: "crash looping",
+		},
+		// 5
+		{
+			pod:      &api.Pod{Status: waiting("ContainerCreating")},
+			expected: "pending",
+		},
+		// 6
+		{
+			pod:      &api.Pod{Status: api.PodStatus{Phase: api.PodFailed}},
+			expected: "failed",
+		},
+		// 7
+		{
+			pod:      &api.Pod{Status: ready(api.ConditionFalse)},
+			expected: "not ready",
+		},
+		// 8
+		{
+			pod:      &api.Pod{Status: ready(api.ConditionTrue)},
+			expected: "running and ready",
+		},
+	}
+	for i, test := range testCases {
+		state := podState(test.pod)
+		if state != test.expected {
+			t.Errorf("state on %d differs, expected: %s, actual: %s", i, test.expected, state)
+		}
+	}
+}
+
+func TestSummarizePodStates(t *testing.T) {
+	testCases := []struct {
+		states   []string
+		expected string
+	}{
+		// 0
+		{
+			expected: "",
+		},
+		// 1
+		{
+			states:   []string{"pending"},
+			expected: "pending (1)",
+		},
+		// 2
+		{
+			states:   []string{"running and ready", "pending image pull", "pending image pull"},
+			expected: "pending image pull (2), running and ready (1)",
+		},
+		// 3
+		{
+			states:   []string{"pending", "failed", "pending", "failed", "crash looping"},
+			expected: "failed (2), pending (2), crash looping (1)",
+		},
+	}
+	for i, test := range testCases {
+		summary := summarizePodStates(test.states)
+		if summary != test.expected {
+			t.Errorf("summary on %d differs, expected: %s, actual: %s", i, test.expected, summary)
+		}
+	}
+}
@@ -17,8 +17,13 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	api "k8s.io/api/core/v1"
 
@@ -32,12 +37,23 @@ import (
 type cache struct {
 	listers    *ingress.StoreLister
 	controller *controller.GenericController
+	failover   *failoverCluster
+	globalCRD  *globalConfigCRD
+	resources  *configResources
+	classCfg   *ingressClassParams
+	certMutex  sync.Mutex
+	certs      map[string]*ingress.SSLCert
 }
 
-func newCache(listers *ingress.StoreLister, controller *controller.GenericController) *cache {
+func newCache(listers *ingress.StoreLister, controller *controller.GenericController, failover *failoverCluster, globalCRD *globalConfigCRD, resources *configResources, classCfg *ingressClassParams) *cache {
 	return &cache{
 		listers:    listers,
 		controller: controller,
+		failover:   failover,
+		globalCRD:  globalCRD,
+		resources:  resources,
+		classCfg:   classCfg,
+		certs:      map[string]*ingress.SSLCert{},
 	}
 }
 
@@ -45,11 +61,22 @@ func (c *cache) GetService(serviceName string) (*api.Service, error) {
 	return c.listers.Service.GetByName(serviceName)
 }
 
+func (c *cache) GetPublishService() (*api.Service, error) {
+	return c.controller.GetPublishService()
+}
+
 func (c *cache) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
 	ep, err := c.listers.Endpoint.GetServiceEndpoints(service)
 	return &ep, err
 }
 
+func (c *cache) GetRemoteEndpoints(serviceName string) (*api.Endpoints, error) {
+	if c.failover == nil {
+		return nil, fmt.Errorf("failover cluster is not configured, see --failover-kubeconfig command-line option")
+	}
+	return c.failover.getEndpoints(serviceName)
+}
+
 func (c *cache) GetTerminatingPods(service *api.Service) ([]*api.Pod, error) {
 	pods, err := c.listers.Pod.GetTerminatingServicePods(service)
 	if err != nil {
@@ -70,6 +97,29 @@ func (c *cache) GetPod(podName string) (*api.Pod, error) {
 	return c.listers.Pod.GetPod(sname[0], sname[1])
 }
 
+// GetNodeZone returns the zone of a node, read from the well-known
+// zone labels. An empty string is returned if the node has no zone.
+func (c *cache) GetNodeZone(nodeName string) (string, error) {
+	obj, exists, err := c.listers.Node.GetByKey(nodeName)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("node not found: '%s'", nodeName)
+	}
+	labels := obj.(*api.Node).Labels
+	for _, label := range []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"} {
+		if zone, found := labels[label]; found {
+			return zone, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *cache) GetNamespace(name string) (*api.Namespace, error) {
+	return c.listers.Namespace.GetByName(name)
+}
+
 func (c *cache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
 	sslCert, err := c.controller.GetCertificate(secretName)
 	if err != nil {
@@ -78,24 +128,57 @@ func (c *cache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
 	if sslCert.PemFileName == "" {
 		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have keys 'tls.crt' and 'tls.key'", secretName)
 	}
+	c.certMutex.Lock()
+	c.certs[secretName] = sslCert
+	c.certMutex.Unlock()
+	hash := sslCert.PemSHA
+	if checkOCSPFile(sslCert.PemFileName) {
+		// a new OCSP response is only read by HAProxy on reloads
+		hash += ocspFileSuffix
+	}
 	return ingtypes.File{
 		Filename: sslCert.PemFileName,
-		SHA1Hash: sslCert.PemSHA,
+		SHA1Hash: hash,
+		NotAfter: sslCert.ExpireTime,
 	}, nil
 }
 
-func (c *cache) GetCASecretPath(secretName string) (ingtypes.File, error) {
+// takeCerts returns the certificates read since the last call, by secret name
+func (c *cache) takeCerts() map[string]*ingress.SSLCert {
+	c.certMutex.Lock()
+	defer c.certMutex.Unlock()
+	certs := c.certs
+	c.certs = map[string]*ingress.SSLCert{}
+	return certs
+}
+
+func (c *cache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
 	sslCert, err := c.controller.GetCertificate(secretName)
 	if err != nil {
-		return ingtypes.File{}, err
+		return ca, crl, err
 	}
 	if sslCert.CAFileName == "" {
-		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
+		return ca, crl, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
 	}
-	return ingtypes.File{
+	ca = ingtypes.File{
 		Filename: sslCert.CAFileName,
 		SHA1Hash: sslCert.PemSHA,
-	}, nil
+	}
+	secret, err := c.listers.Secret.GetByName(secretName)
+	if err != nil {
+		return ca, crl, err
+	}
+	if crlData, found := secret.Data[caCRLFilename]; found {
+		crlFileName, err := ssl.AddOrUpdateCRL(strings.Replace(secretName, "/", "_", -1), crlData)
+		if err != nil {
+			return ca, crl, fmt.Errorf("error creating CRL file of secret '%s': %v", secretName, err)
+		}
+		crl = ingtypes.File{
+			Filename: crlFileName,
+			SHA1Hash: file.SHA1(crlFileName),
+		}
+	}
+	return ca, crl, nil
 }
 
 func (c *cache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
@@ -129,3 +212,87 @@ func (c *cache) GetSecretContent(secretName, keyName string) ([]byte, error) {
 	}
 	return data, nil
 }
+
+func (c *cache) GetConfigMapContent(configMapName, keyName string) ([]byte, error) {
+	configMap, err := c.listers.ConfigMap.GetByName(configMapName)
+	if err != nil {
+		return nil, err
+	}
+	data, found := configMap.Data[keyName]
+	if !found {
+		return nil, fmt.Errorf("configmap '%s' does not have key '%s'", configMapName, keyName)
+	}
+	return []byte(data), nil
+}
+
+// luaScriptsDir is the directory of the Lua scripts read from ConfigMaps
+const luaScriptsDir = "/etc/haproxy/lua"
+
+func (c *cache) GetLuaScriptPaths(configMapName string) ([]ingtypes.File, error) {
+	configMap, err := c.listers.ConfigMap.GetByName(configMapName)
+	if err != nil {
+		return nil, err
+	}
+	keys := luaScriptKeys(configMap.Data)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("configmap '%s' does not have keys with the .lua extension", configMapName)
+	}
+	if err := os.MkdirAll(luaScriptsDir, 0755); err != nil {
+		return nil, err
+	}
+	files := make([]ingtypes.File, len(keys))
+	for i, key := range keys {
+		filename := luaScriptsDir + "/" + strings.Replace(configMapName, "/", "_", -1) + "_" + key
+		content := []byte(configMap.Data[key])
+		if current, err := ioutil.ReadFile(filename); err != nil || !bytes.Equal(current, content) {
+			if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+				return nil, err
+			}
+		}
+		files[i] = ingtypes.File{
+			Filename: filename,
+			SHA1Hash: file.SHA1(filename),
+		}
+	}
+	return files, nil
+}
+
+// luaScriptKeys returns the sorted keys of a ConfigMap with the .lua extension
+func luaScriptKeys(data map[string]string) []string {
+	var keys []string
+	for key := range data {
+		if strings.HasSuffix(key, ".lua") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *cache) GetGlobalConfig() (map[string]string, error) {
+	if c.globalCRD == nil {
+		return nil, nil
+	}
+	return c.globalCRD.getConfig()
+}
+
+func (c *cache) GetIngressClassConfig() (map[string]string, error) {
+	if c.classCfg == nil {
+		return nil, nil
+	}
+	return c.classCfg.getConfig()
+}
+
+func (c *cache) GetBackendResource(resourceName string) map[string]string {
+	if c.resources == nil {
+		return nil
+	}
+	return c.resources.getBackend(resourceName)
+}
+
+func (c *cache) GetHostResource(resourceName string) map[string]string {
+	if c.resources == nil {
+		return nil
+	}
+	return c.resources.getHost(resourceName)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	certManagerGroup      = "cert-manager.io"
+	certManagerAPIVersion = certManagerGroup + "/v1"
+	certManagerLabel      = "app.kubernetes.io/managed-by"
+	certManagerLabelValue = "haproxy-ingress"
+)
+
+// certManager creates the cert-manager Certificate resources of the TLS
+// secrets which don't exist, and updates the Certificates it has created
+// whenever their hosts or issuer change. Only the leader writes.
+type certManager struct {
+	mutex    sync.Mutex
+	client   kubernetes.Interface
+	isLeader func() bool
+	trigger  chan struct{}
+	current  map[string]*certificateSpec
+	certs    map[string]*certificateSpec
+}
+
+type certificate struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   meta.ObjectMeta `json:"metadata"`
+	Spec       certificateSpec `json:"spec"`
+}
+
+type certificateSpec struct {
+	SecretName string         `json:"secretName"`
+	DNSNames   []string       `json:"dnsNames"`
+	IssuerRef  certIssuerSpec `json:"issuerRef"`
+}
+
+type certIssuerSpec struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Group string `json:"group"`
+}
+
+func newCertManager(client kubernetes.Interface, isLeader func() bool) *certManager {
+	return &certManager{
+		client:   client,
+		isLeader: isLeader,
+		trigger:  make(chan struct{}, 1),
+		current:  map[string]*certificateSpec{},
+		certs:    map[string]*certificateSpec{},
+	}
+}
+
+// TrackCertificate implements ingtypes.CertificateTracker
+func (m *certManager) TrackCertificate(secretName, domain, issuer, issuerKind string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	spec := m.current[secretName]
+	if spec == nil {
+		_, name := splitName(secretName)
+		spec = &certificateSpec{
+			SecretName: name,
+			IssuerRef: certIssuerSpec{
+				Name:  issuer,
+				Kind:  issuerKind,
+				Group: certManagerGroup,
+			},
+		}
+		m.current[secretName] = spec
+	} else if spec.IssuerRef.Name != issuer || spec.IssuerRef.Kind != issuerKind {
+		glog.Warningf("skipping cert-manager issuer '%s' of secret '%s': issuer '%s' was already assigned", issuer, secretName, spec.IssuerRef.Name)
+	}
+	for _, dnsName := range spec.DNSNames {
+		if dnsName == domain {
+			return
+		}
+	}
+	spec.DNSNames = append(spec.DNSNames, domain)
+}
+
+// commit finishes a sync and asks for a reconciliation of the Certificates
+func (m *certManager) commit() {
+	m.mutex.Lock()
+	m.certs = m.current
+	m.current = map[string]*certificateSpec{}
+	m.mutex.Unlock()
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *certManager) run(stopCh <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-m.trigger:
+				m.reconcile()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *certManager) reconcile() {
+	if !m.isLeader() {
+		return
+	}
+	m.mutex.Lock()
+	certs := m.certs
+	m.mutex.Unlock()
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := *certs[name]
+		sort.Strings(spec.DNSNames)
+		if err := m.sync(name, &spec); err != nil {
+			glog.Warningf("error updating cert-manager Certificate of secret '%s': %v", name, err)
+		}
+	}
+}
+
+func (m *certManager) sync(name string, spec *certificateSpec) error {
+	namespace, certName := splitName(name)
+	path := fmt.Sprintf("/apis/%s/namespaces/%s/certificates", certManagerAPIVersion, namespace)
+	client := m.client.CoreV1().RESTClient()
+	raw, err := client.Get().AbsPath(path, certName).DoRaw()
+	if err == nil {
+		cert := &certificate{}
+		if err := json.Unmarshal(raw, cert); err != nil {
+			return err
+		}
+		if cert.Metadata.Labels[certManagerLabel] != certManagerLabelValue || reflect.DeepEqual(&cert.Spec, spec) {
+			// either created by someone else or up to date
+			return nil
+		}
+		patch, _ := json.Marshal(map[string]interface{}{"spec": spec})
+		if _, err := client.Patch(k8stypes.MergePatchType).AbsPath(path, certName).Body(patch).DoRaw(); err != nil {
+			return err
+		}
+		glog.Infof("cert-manager Certificate '%s' updated, hosts: %v", name, spec.DNSNames)
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if _, err := m.client.CoreV1().Secrets(namespace).Get(spec.SecretName, meta.GetOptions{}); err == nil {
+		// secret already exists and isn't issued by a Certificate we own
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	cert := &certificate{
+		APIVersion: certManagerAPIVersion,
+		Kind:       "Certificate",
+		Metadata: meta.ObjectMeta{
+			Name:      certName,
+			Namespace: namespace,
+			Labels:    map[string]string{certManagerLabel: certManagerLabelValue},
+		},
+		Spec: *spec,
+	}
+	body, _ := json.Marshal(cert)
+	if _, err := client.Post().AbsPath(path).Body(body).DoRaw(); err != nil {
+		return err
+	}
+	glog.Infof("cert-manager Certificate '%s' created, hosts: %v", name, spec.DNSNames)
+	return nil
+}
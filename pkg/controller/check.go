@@ -0,0 +1,528 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/file"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
+	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// RunCheck implements the `check` subcommand: reads ingress, service, endpoints,
+// secret and configmap resources from manifest files or from a live cluster, runs
+// the v0.8 converters and prints the rendered haproxy.cfg on stdout and all the
+// WARN and ERROR logging on stderr, without touching a running proxy. Returns
+// the exit code of the command.
+func RunCheck(args []string) int {
+	flags := pflag.NewFlagSet("check", pflag.ContinueOnError)
+	manifests := flags.StringSlice("manifests", nil,
+		`Comma-separated list of YAML or JSON manifest files or directories. Multi document files and v1 List are supported`)
+	kubeconfig := flags.String("kubeconfig", "",
+		`Path to a kubeconfig file whose cluster resources should be read instead of the manifests`)
+	namespace := flags.String("watch-namespace", "",
+		`Comma-separated list of namespaces to read resources from the cluster. Defaults to all namespaces`)
+	configMap := flags.String("configmap", "",
+		`Name of the global ConfigMap in the form namespace/name`)
+	ingressClass := flags.String("ingress-class", "haproxy",
+		`Name of the ingress class of the controller`)
+	annPrefix := flags.String("annotations-prefix", "ingress.kubernetes.io",
+		`Comma-separated list of prefixes of ingress and service annotations, in order of precedence`)
+	secretNamespaces := flags.String("secret-namespaces", "",
+		`Comma-separated list of namespaces whose secrets can be referenced from ingress resources of another namespace`)
+	defaultBackend := flags.String("default-backend-service", "",
+		`Service used as the default backend in the form namespace/name`)
+	defaultSSL := flags.String("default-ssl-certificate", "",
+		`Secret used as the default certificate in the form namespace/name`)
+	templatesDir := flags.String("templates-dir", "/etc/haproxy",
+		`Directory of the haproxy, map and modsecurity templates`)
+	haproxyTemplate := flags.String("haproxy-template", "",
+		`Path of a template file which replaces the haproxy.cfg template of the templates dir`)
+	templatePartials := flags.String("haproxy-template-partials", "",
+		`Directory of template files whose define actions replace sections of the haproxy.cfg template`)
+	outputDir := flags.String("output-dir", "",
+		`Directory where haproxy.cfg, maps and certificates are written. Defaults to a temporary directory`)
+	haproxyCmd := flags.String("haproxy-cmd", "",
+		`Path of a haproxy binary used to validate the rendered configuration. Use an empty string to skip`)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*manifests) == 0 && *kubeconfig == "" {
+		fmt.Fprintln(os.Stderr, "either --manifests or --kubeconfig should be declared")
+		return 2
+	}
+
+	cache, err := readCheckResources(*manifests, *kubeconfig, *namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading resources: %v\n", err)
+		return 2
+	}
+	if *outputDir == "" {
+		*outputDir, err = ioutil.TempDir("", "haproxy-ingress-check")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating output dir: %v\n", err)
+			return 2
+		}
+		defer os.RemoveAll(*outputDir)
+	}
+	cache.sslDir = *outputDir + "/ssl"
+	for _, dir := range []string{cache.sslDir, *outputDir + "/maps"} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			fmt.Fprintf(os.Stderr, "error creating output dir: %v\n", err)
+			return 2
+		}
+	}
+
+	logger := &checkLogger{out: os.Stderr}
+	configFile := *outputDir + "/haproxy.cfg"
+	instance := haproxy.CreateInstance(logger, haproxy.InstanceOptions{
+		HAProxyConfigFile: configFile,
+		TemplatesDir:      *templatesDir,
+		HAProxyTemplate:   *haproxyTemplate,
+		TemplatePartials:  *templatePartials,
+		MapsDir:           *outputDir + "/maps",
+	})
+	if err := instance.ParseTemplates(); err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing templates: %v\n", err)
+		return 2
+	}
+	defaultSSLFile := ingtypes.File{Filename: cache.sslDir + "/default.pem"}
+	if *defaultSSL != "" {
+		if defaultSSLFile, err = cache.GetTLSSecretPath(*defaultSSL); err != nil {
+			logger.Error("error reading default certificate: %v", err)
+		}
+	}
+	options := &ingtypes.ConverterOptions{
+		Logger:           logger,
+		Cache:            cache,
+		AnnotationPrefix: utils.Split(*annPrefix, ","),
+		SecretNamespaces: utils.Split(*secretNamespaces, ","),
+		DefaultBackend:   *defaultBackend,
+		DefaultSSLFile:   defaultSSLFile,
+	}
+	var globalConfig map[string]string
+	if *configMap != "" {
+		cm, found := cache.configMaps[*configMap]
+		if !found {
+			fmt.Fprintf(os.Stderr, "global ConfigMap not found: '%s'\n", *configMap)
+			return 2
+		}
+		globalConfig = cm.Data
+	}
+	var ingress []*extensions.Ingress
+	for _, ing := range cache.ingress {
+		if class.IsValid(ing, *ingressClass, "haproxy") {
+			ingress = append(ingress, ing)
+		}
+	}
+	ingressconverter.NewIngressConverter(options, instance.Config(), globalConfig).Sync(ingress)
+	instance.Update()
+
+	cfg, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading rendered configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(cfg)
+	if *haproxyCmd != "" {
+		out, err := exec.Command(*haproxyCmd, "-c", "-f", configFile).CombinedOutput()
+		if err != nil {
+			logger.Error("error validating config file:\n%s", string(out))
+		}
+	}
+	if logger.errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkCache is an in memory ingtypes.Cache of the resources read by the check command
+type checkCache struct {
+	sslDir     string
+	ingress    []*extensions.Ingress
+	services   map[string]*api.Service
+	endpoints  map[string]*api.Endpoints
+	pods       map[string]*api.Pod
+	secrets    map[string]*api.Secret
+	configMaps map[string]*api.ConfigMap
+}
+
+func newCheckCache() *checkCache {
+	return &checkCache{
+		services:   map[string]*api.Service{},
+		endpoints:  map[string]*api.Endpoints{},
+		pods:       map[string]*api.Pod{},
+		secrets:    map[string]*api.Secret{},
+		configMaps: map[string]*api.ConfigMap{},
+	}
+}
+
+// readCheckResources reads the resources from a live cluster if kubeconfig
+// is declared, from the manifest files or directories otherwise
+func readCheckResources(manifests []string, kubeconfig, namespace string) (*checkCache, error) {
+	cache := newCheckCache()
+	var err error
+	if kubeconfig != "" {
+		namespaces := utils.Split(namespace, ",")
+		if len(namespaces) == 0 {
+			namespaces = []string{api.NamespaceAll}
+		}
+		for _, ns := range namespaces {
+			if err = cache.readCluster(kubeconfig, ns); err != nil {
+				break
+			}
+		}
+	} else {
+		err = cache.readManifests(manifests)
+	}
+	return cache, err
+}
+
+func (c *checkCache) readManifests(manifests []string) error {
+	for _, manifest := range manifests {
+		err := filepath.Walk(manifest, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			ext := filepath.Ext(path)
+			if path != manifest && ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				// explicitly declared files are always read
+				return nil
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := c.readManifest(content); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checkCache) readManifest(content []byte) error {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return err
+		}
+		if list, ok := obj.(*api.List); ok {
+			for _, item := range list.Items {
+				if err := c.readManifest(item.Raw); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		c.add(obj)
+	}
+}
+
+func (c *checkCache) readCluster(kubeconfig, namespace string) error {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	opts := meta.ListOptions{}
+	ingList, err := client.ExtensionsV1beta1().Ingresses(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range ingList.Items {
+		c.add(&ingList.Items[i])
+	}
+	svcList, err := client.CoreV1().Services(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range svcList.Items {
+		c.add(&svcList.Items[i])
+	}
+	epList, err := client.CoreV1().Endpoints(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range epList.Items {
+		c.add(&epList.Items[i])
+	}
+	podList, err := client.CoreV1().Pods(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range podList.Items {
+		c.add(&podList.Items[i])
+	}
+	secretList, err := client.CoreV1().Secrets(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range secretList.Items {
+		c.add(&secretList.Items[i])
+	}
+	cmList, err := client.CoreV1().ConfigMaps(namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for i := range cmList.Items {
+		c.add(&cmList.Items[i])
+	}
+	return nil
+}
+
+func (c *checkCache) add(obj runtime.Object) {
+	switch obj := obj.(type) {
+	case *extensions.Ingress:
+		if obj.Namespace == "" {
+			obj.Namespace = api.NamespaceDefault
+		}
+		c.ingress = append(c.ingress, obj)
+	case *api.Service:
+		c.services[checkKey(&obj.ObjectMeta)] = obj
+	case *api.Endpoints:
+		c.endpoints[checkKey(&obj.ObjectMeta)] = obj
+	case *api.Pod:
+		c.pods[checkKey(&obj.ObjectMeta)] = obj
+	case *api.Secret:
+		c.secrets[checkKey(&obj.ObjectMeta)] = obj
+	case *api.ConfigMap:
+		c.configMaps[checkKey(&obj.ObjectMeta)] = obj
+	}
+}
+
+func checkKey(obj *meta.ObjectMeta) string {
+	if obj.Namespace == "" {
+		obj.Namespace = api.NamespaceDefault
+	}
+	return obj.Namespace + "/" + obj.Name
+}
+
+func (c *checkCache) GetService(serviceName string) (*api.Service, error) {
+	svc, found := c.services[serviceName]
+	if !found {
+		return nil, fmt.Errorf("service not found: '%s'", serviceName)
+	}
+	return svc, nil
+}
+
+func (c *checkCache) GetPublishService() (*api.Service, error) {
+	return nil, nil
+}
+
+func (c *checkCache) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
+	ep, found := c.endpoints[service.Namespace+"/"+service.Name]
+	if !found {
+		return nil, fmt.Errorf("could not find endpoints for service '%s/%s'", service.Namespace, service.Name)
+	}
+	return ep, nil
+}
+
+func (c *checkCache) GetRemoteEndpoints(serviceName string) (*api.Endpoints, error) {
+	return nil, fmt.Errorf("failover cluster is not supported by the check command")
+}
+
+func (c *checkCache) GetTerminatingPods(service *api.Service) ([]*api.Pod, error) {
+	return []*api.Pod{}, nil
+}
+
+func (c *checkCache) GetPod(podName string) (*api.Pod, error) {
+	pod, found := c.pods[podName]
+	if !found {
+		return nil, fmt.Errorf("pod not found: '%s'", podName)
+	}
+	return pod, nil
+}
+
+func (c *checkCache) GetNodeZone(nodeName string) (string, error) {
+	return "", fmt.Errorf("node zones are not supported by the check command")
+}
+
+func (c *checkCache) GetNamespace(name string) (*api.Namespace, error) {
+	return nil, fmt.Errorf("namespaces are not supported by the check command")
+}
+
+func (c *checkCache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
+	secret, found := c.secrets[secretName]
+	if !found {
+		return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
+	}
+	crt, crtFound := secret.Data[api.TLSCertKey]
+	key, keyFound := secret.Data[api.TLSPrivateKeyKey]
+	if !crtFound || !keyFound {
+		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have keys 'tls.crt' and 'tls.key'", secretName)
+	}
+	return c.writeFile(secretName, ".pem", append(append(crt, '\n'), key...))
+}
+
+func (c *checkCache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	caData, err := c.GetSecretContent(secretName, "ca.crt")
+	if err != nil {
+		return ca, crl, err
+	}
+	if ca, err = c.writeFile(secretName, "-ca.pem", caData); err != nil {
+		return ca, crl, err
+	}
+	if crlData, found := c.secrets[secretName].Data[caCRLFilename]; found {
+		crl, err = c.writeFile(secretName, "-crl.pem", crlData)
+	}
+	return ca, crl, err
+}
+
+func (c *checkCache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
+	dh, err := c.GetSecretContent(secretName, dhparamFilename)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	return c.writeFile(secretName, "-dh.pem", dh)
+}
+
+func (c *checkCache) writeFile(secretName, suffix string, content []byte) (ingtypes.File, error) {
+	filename := c.sslDir + "/" + strings.Replace(secretName, "/", "_", -1) + suffix
+	if err := ioutil.WriteFile(filename, content, 0600); err != nil {
+		return ingtypes.File{}, err
+	}
+	return ingtypes.File{
+		Filename: filename,
+		SHA1Hash: file.SHA1(filename),
+	}, nil
+}
+
+func (c *checkCache) GetSecretContent(secretName, keyName string) ([]byte, error) {
+	secret, found := c.secrets[secretName]
+	if !found {
+		return nil, fmt.Errorf("secret not found: '%s'", secretName)
+	}
+	data, found := secret.Data[keyName]
+	if !found {
+		return nil, fmt.Errorf("secret '%s' does not have key '%s'", secretName, keyName)
+	}
+	return data, nil
+}
+
+func (c *checkCache) GetConfigMapContent(configMapName, keyName string) ([]byte, error) {
+	configMap, found := c.configMaps[configMapName]
+	if !found {
+		return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+	}
+	data, found := configMap.Data[keyName]
+	if !found {
+		return nil, fmt.Errorf("configmap '%s' does not have key '%s'", configMapName, keyName)
+	}
+	return []byte(data), nil
+}
+
+func (c *checkCache) GetLuaScriptPaths(configMapName string) ([]ingtypes.File, error) {
+	configMap, found := c.configMaps[configMapName]
+	if !found {
+		return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+	}
+	keys := luaScriptKeys(configMap.Data)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("configmap '%s' does not have keys with the .lua extension", configMapName)
+	}
+	files := make([]ingtypes.File, len(keys))
+	for i, key := range keys {
+		f, err := c.writeFile(configMapName, "_"+key, []byte(configMap.Data[key]))
+		if err != nil {
+			return nil, err
+		}
+		files[i] = f
+	}
+	return files, nil
+}
+
+func (c *checkCache) GetGlobalConfig() (map[string]string, error) {
+	return nil, nil
+}
+
+func (c *checkCache) GetIngressClassConfig() (map[string]string, error) {
+	return nil, nil
+}
+
+func (c *checkCache) GetBackendResource(resourceName string) map[string]string {
+	return nil
+}
+
+func (c *checkCache) GetHostResource(resourceName string) map[string]string {
+	return nil
+}
+
+// checkLogger writes WARN and ERROR messages, counting the errors
+type checkLogger struct {
+	out    io.Writer
+	errors int
+}
+
+func (l *checkLogger) InfoV(v int, msg string, args ...interface{}) {}
+
+func (l *checkLogger) Info(msg string, args ...interface{}) {}
+
+func (l *checkLogger) Warn(msg string, args ...interface{}) {
+	fmt.Fprintf(l.out, "WARN "+msg+"\n", args...)
+}
+
+func (l *checkLogger) Error(msg string, args ...interface{}) {
+	l.errors++
+	fmt.Fprintf(l.out, "ERROR "+msg+"\n", args...)
+}
+
+func (l *checkLogger) Fatal(msg string, args ...interface{}) {
+	l.errors++
+	fmt.Fprintf(l.out, "FATAL "+msg+"\n", args...)
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+const checkManifests = `
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: app
+  annotations:
+    kubernetes.io/ingress.class: haproxy
+spec:
+  rules:
+  - host: app.local
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: app
+          servicePort: 8080
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: app
+  spec:
+    ports:
+    - port: 8080
+- apiVersion: v1
+  kind: Endpoints
+  metadata:
+    name: app
+  subsets:
+  - addresses:
+    - ip: 172.17.0.11
+    ports:
+    - port: 8080
+---
+{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config", "namespace": "ingress"}, "data": {"timeout-client": "1m", "script.lua": "core.log(core.info, 'x')"}}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: tls
+data:
+  tls.crt: Y3J0
+  tls.key: a2V5
+  ca.crt: Y2E=
+`
+
+func TestCheckReadManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "app.yaml")
+	ioutil.WriteFile(manifest, []byte(checkManifests), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0600)
+	invalid := filepath.Join(dir, "invalid.txt")
+	ioutil.WriteFile(invalid, []byte("kind: Unknown\n"), 0600)
+	testCases := []struct {
+		manifests []string
+		expError  bool
+		expected  []string
+	}{
+		// 0
+		{
+			manifests: []string{manifest},
+			expected:  []string{"configmap/ingress/config", "endpoints/default/app", "ingress/default/app", "secret/default/tls", "service/default/app"},
+		},
+		// 1
+		{
+			// only yaml, yml and json files are read from directories
+			manifests: []string{dir},
+			expected:  []string{"configmap/ingress/config", "endpoints/default/app", "ingress/default/app", "secret/default/tls", "service/default/app"},
+		},
+		// 2
+		{
+			manifests: []string{invalid},
+			expError:  true,
+		},
+		// 3
+		{
+			manifests: []string{filepath.Join(dir, "missing.yaml")},
+			expError:  true,
+		},
+	}
+	for i, test := range testCases {
+		cache, err := readCheckResources(test.manifests, "", "")
+		if (err != nil) != test.expError {
+			t.Errorf("error on %d differs, expected: %v, actual: %v", i, test.expError, err)
+			continue
+		}
+		if test.expError {
+			continue
+		}
+		var resources []string
+		for _, ing := range cache.ingress {
+			resources = append(resources, "ingress/"+ing.Namespace+"/"+ing.Name)
+		}
+		for key := range cache.services {
+			resources = append(resources, "service/"+key)
+		}
+		for key := range cache.endpoints {
+			resources = append(resources, "endpoints/"+key)
+		}
+		for key := range cache.secrets {
+			resources = append(resources, "secret/"+key)
+		}
+		for key := range cache.configMaps {
+			resources = append(resources, "configmap/"+key)
+		}
+		sort.Strings(resources)
+		if !reflect.DeepEqual(resources, test.expected) {
+			t.Errorf("resources on %d differs, expected: %v, actual: %v", i, test.expected, resources)
+		}
+	}
+}
+
+func TestCheckCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := newCheckCache()
+	cache.sslDir = dir
+	if err := cache.readManifest([]byte(checkManifests)); err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(f ingtypes.File, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		content, _ := ioutil.ReadFile(f.Filename)
+		return filepath.Base(f.Filename) + ": " + string(content)
+	}
+	readContent := func(content []byte, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return string(content)
+	}
+	testCases := []struct {
+		get      func() string
+		expected string
+	}{
+		// 0
+		{
+			get: func() string {
+				svc, err := cache.GetService("default/app")
+				if err != nil {
+					return err.Error()
+				}
+				ep, err := cache.GetEndpoints(svc)
+				if err != nil {
+					return err.Error()
+				}
+				return ep.Subsets[0].Addresses[0].IP
+			},
+			expected: "172.17.0.11",
+		},
+		// 1
+		{
+			get: func() string {
+				_, err := cache.GetService("default/web")
+				return err.Error()
+			},
+			expected: "service not found: 'default/web'",
+		},
+		// 2
+		{
+			get: func() string {
+				return readFile(cache.GetTLSSecretPath("default/tls"))
+			},
+			expected: "default_tls.pem: crt\nkey",
+		},
+		// 3
+		{
+			get: func() string {
+				return readFile(cache.GetTLSSecretPath("default/web"))
+			},
+			expected: "secret not found: 'default/web'",
+		},
+		// 4
+		{
+			get: func() string {
+				ca, crl, err := cache.GetCASecretPath("default/tls")
+				return readFile(ca, err) + " " + crl.Filename
+			},
+			expected: "default_tls-ca.pem: ca ",
+		},
+		// 5
+		{
+			get: func() string {
+				return readContent(cache.GetSecretContent("default/tls", "dh"))
+			},
+			expected: "secret 'default/tls' does not have key 'dh'",
+		},
+		// 6
+		{
+			get: func() string {
+				return readContent(cache.GetConfigMapContent("ingress/config", "timeout-client"))
+			},
+			expected: "1m",
+		},
+		// 7
+		{
+			get: func() string {
+				return readContent(cache.GetConfigMapContent("default/config", "timeout-client"))
+			},
+			expected: "configmap not found: 'default/config'",
+		},
+		// 8
+		{
+			get: func() string {
+				files, err := cache.GetLuaScriptPaths("ingress/config")
+				if err != nil {
+					return err.Error()
+				}
+				return readFile(files[0], nil)
+			},
+			expected: "ingress_config_script.lua: core.log(core.info, 'x')",
+		},
+		// 9
+		{
+			get: func() string {
+				_, err := cache.GetRemoteEndpoints("default/app")
+				return err.Error()
+			},
+			expected: "failover cluster is not supported by the check command",
+		},
+	}
+	for i, test := range testCases {
+		if actual := test.get(); actual != test.expected {
+			t.Errorf("result on %d differs, expected: %q, actual: %q", i, test.expected, actual)
+		}
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "app.yaml")
+	ioutil.WriteFile(manifest, []byte(checkManifests), 0600)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	os.Stdout, os.Stderr = devNull, devNull
+	templates := "--templates-dir=../../rootfs/etc/haproxy"
+	testCases := []struct {
+		args     []string
+		expected int
+	}{
+		// 0
+		{
+			args:     []string{"--unknown"},
+			expected: 2,
+		},
+		// 1
+		{
+			expected: 2,
+		},
+		// 2
+		{
+			args:     []string{"--manifests=" + filepath.Join(dir, "missing.yaml")},
+			expected: 2,
+		},
+		// 3
+		{
+			args:     []string{"--manifests=" + manifest, "--configmap=default/config", templates},
+			expected: 2,
+		},
+		// 4
+		{
+			args:     []string{"--manifests=" + manifest, "--templates-dir=" + dir},
+			expected: 2,
+		},
+		// 5
+		{
+			args:     []string{"--manifests=" + manifest, "--default-ssl-certificate=default/web", templates},
+			expected: 1,
+		},
+		// 6
+		{
+			args:     []string{"--manifests=" + manifest, "--haproxy-cmd=false", templates},
+			expected: 1,
+		},
+		// 7
+		{
+			args:     []string{"--manifests=" + manifest, "--configmap=ingress/config", templates},
+			expected: 0,
+		},
+	}
+	for i, test := range testCases {
+		if code := RunCheck(test.args); code != test.expected {
+			t.Errorf("exit code on %d differs, expected: %d, actual: %d", i, test.expected, code)
+		}
+	}
+}
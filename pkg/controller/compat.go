@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/pflag"
+
+	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// RunNginxCompat implements the `nginx-compat` subcommand: reads ingress resources
+// from manifest files or from a live cluster and reports how their nginx-ingress
+// annotations would behave under haproxy-ingress. Returns 1 if any annotation
+// is unsupported, so the command can be used on CI pipelines.
+func RunNginxCompat(args []string) int {
+	flags := pflag.NewFlagSet("nginx-compat", pflag.ContinueOnError)
+	manifests := flags.StringSlice("manifests", nil,
+		`Comma-separated list of YAML or JSON manifest files or directories. Multi document files and v1 List are supported`)
+	kubeconfig := flags.String("kubeconfig", "",
+		`Path to a kubeconfig file whose cluster resources should be read instead of the manifests`)
+	namespace := flags.String("watch-namespace", "",
+		`Comma-separated list of namespaces to read resources from the cluster. Defaults to all namespaces`)
+	annPrefix := flags.String("annotations-prefix", "ingress.kubernetes.io",
+		`Comma-separated list of prefixes of haproxy-ingress annotations, the first one is used in the recommendations`)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*manifests) == 0 && *kubeconfig == "" {
+		fmt.Fprintln(os.Stderr, "either --manifests or --kubeconfig should be declared")
+		return 2
+	}
+	cache, err := readCheckResources(*manifests, *kubeconfig, *namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading resources: %v\n", err)
+		return 2
+	}
+	prefix := "ingress.kubernetes.io"
+	if prefixes := utils.Split(*annPrefix, ","); len(prefixes) > 0 {
+		prefix = prefixes[0]
+	}
+	ingress := cache.ingress
+	sort.SliceStable(ingress, func(i, j int) bool {
+		return ingress[i].Namespace+"/"+ingress[i].Name < ingress[j].Namespace+"/"+ingress[j].Name
+	})
+	count := map[string]int{}
+	for _, ing := range ingress {
+		findings := ingressconverter.NginxCompat(ing, prefix)
+		if len(findings) == 0 {
+			continue
+		}
+		fmt.Printf("ingress '%s/%s':\n", ing.Namespace, ing.Name)
+		for _, finding := range findings {
+			fmt.Printf("  %s\n", finding)
+			count[finding.Level]++
+		}
+	}
+	fmt.Printf("%d equivalent, %d differ, %d unsupported\n",
+		count[ingressconverter.CompatEquivalent], count[ingressconverter.CompatDiffers], count[ingressconverter.CompatUnsupported])
+	if count[ingressconverter.CompatUnsupported] > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	backendConfigResource = "haproxybackends"
+	hostConfigResource    = "haproxyhosts"
+)
+
+// configResources reads the namespaced HAProxyBackend and HAProxyHost
+// resources, a typed alternative to backend and host annotations
+type configResources struct {
+	mutex      sync.Mutex
+	client     rest.Interface
+	namespaces []string
+	notify     func()
+	backends   map[string]map[string]string
+	hosts      map[string]map[string]string
+}
+
+type configResourceItem struct {
+	Metadata struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"metadata"`
+	Spec map[string]json.RawMessage `json:"spec"`
+}
+
+func newConfigResources(client rest.Interface, namespaces []string, notify func()) *configResources {
+	return &configResources{
+		client:     client,
+		namespaces: namespaces,
+		notify:     notify,
+	}
+}
+
+func (r *configResources) run(stopCh <-chan struct{}) {
+	r.read()
+	go wait.Until(func() {
+		if r.read() {
+			r.notify()
+		}
+	}, configResourcePollInterval, stopCh)
+}
+
+// read updates all the resources from the apiserver, returns true if any has changed
+func (r *configResources) read() bool {
+	backends, errBackends := r.list(backendConfigResource)
+	hosts, errHosts := r.list(hostConfigResource)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	changed := false
+	// keep the last known state on errors
+	if errBackends == nil && !reflect.DeepEqual(backends, r.backends) {
+		r.backends = backends
+		changed = true
+	}
+	if errHosts == nil && !reflect.DeepEqual(hosts, r.hosts) {
+		r.hosts = hosts
+		changed = true
+	}
+	return changed
+}
+
+func (r *configResources) list(resource string) (map[string]map[string]string, error) {
+	var list []configResourceItem
+	if err := listResources(r.client, configResourceGroupVersion, resource, r.namespaces, &list); err != nil {
+		glog.Warningf("error listing %s: %v", resource, err)
+		return nil, err
+	}
+	items := make(map[string]map[string]string, len(list))
+	for _, item := range list {
+		name := item.Metadata.Namespace + "/" + item.Metadata.Name
+		config, err := parseResourceSpec(item.Spec)
+		if err != nil {
+			glog.Warningf("ignoring %s '%s': %v", resource, name, err)
+			continue
+		}
+		items[name] = config
+	}
+	return items, nil
+}
+
+// listResources reads the items of a resource from the watched namespaces,
+// or from all the namespaces if the list is empty, into a slice of items
+func listResources(client rest.Interface, groupVersion, resource string, namespaces []string, items interface{}) error {
+	paths := []string{fmt.Sprintf("/apis/%s/%s", groupVersion, resource)}
+	if len(namespaces) > 0 {
+		paths = paths[:0]
+		for _, ns := range namespaces {
+			paths = append(paths, fmt.Sprintf("/apis/%s/namespaces/%s/%s", groupVersion, ns, resource))
+		}
+	}
+	var rawItems []json.RawMessage
+	for _, path := range paths {
+		raw, err := client.Get().AbsPath(path).DoRaw()
+		if err != nil {
+			return err
+		}
+		list := struct {
+			Items []json.RawMessage `json:"items"`
+		}{}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		rawItems = append(rawItems, list.Items...)
+	}
+	if rawItems == nil {
+		// same empty and non nil slice parsed from an empty list of the API
+		rawItems = []json.RawMessage{}
+	}
+	raw, err := json.Marshal(rawItems)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, items)
+}
+
+func (r *configResources) getBackend(name string) map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.backends[name]
+}
+
+func (r *configResources) getHost(name string) map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.hosts[name]
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	extensions "k8s.io/api/extensions/v1beta1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/jsonlog"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+const (
+	configStatusAnnotation  = "haproxy-ingress.github.io/config-status"
+	configStatusMaxMessages = 10
+)
+
+// ingressConfigStatus is the content of the config-status annotation
+type ingressConfigStatus struct {
+	Accepted           bool     `json:"accepted"`
+	ObservedGeneration int64    `json:"observedGeneration"`
+	Errors             []string `json:"errors,omitempty"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// configStatus summarizes the warnings and errors of the converters in
+// an annotation of every ingress resource. Warnings include the fallback
+// values used in place of misconfigured annotations.
+type configStatus struct {
+	mutex    sync.Mutex
+	client   kubernetes.Interface
+	isLeader func() bool
+	current  map[string]*ingressConfigStatus
+	last     map[string]*ingressConfigStatus
+}
+
+func newConfigStatus(client kubernetes.Interface, isLeader func() bool) *configStatus {
+	return &configStatus{
+		client:   client,
+		isLeader: isLeader,
+		current:  map[string]*ingressConfigStatus{},
+		last:     map[string]*ingressConfigStatus{},
+	}
+}
+
+// message implements messageListener
+func (s *configStatus) message(level, msg string) {
+	obj := jsonlog.FindObject(msg)
+	if obj == nil || obj.Kind != "ingress" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := obj.Namespace + "/" + obj.Name
+	status := s.current[name]
+	if status == nil {
+		status = &ingressConfigStatus{}
+		s.current[name] = status
+	}
+	if level == "error" {
+		status.Errors = appendMessage(status.Errors, msg)
+	} else {
+		status.Warnings = appendMessage(status.Warnings, msg)
+	}
+}
+
+func appendMessage(messages []string, msg string) []string {
+	for _, m := range messages {
+		if m == msg {
+			return messages
+		}
+	}
+	return append(messages, msg)
+}
+
+// update writes the status of the ingress resources of the sync which has just finished.
+// changes has the objects changed since the former sync if the controller reuses backends:
+// messages of reused backends aren't logged again, so the former status of the ingress
+// resources which didn't change is merged.
+func (s *configStatus) update(ingresses []*extensions.Ingress, changes *ingtypes.Changes) {
+	s.mutex.Lock()
+	current := s.current
+	last := s.last
+	s.current = map[string]*ingressConfigStatus{}
+	s.mutex.Unlock()
+	statuses := make(map[string]*ingressConfigStatus, len(ingresses))
+	for _, ing := range ingresses {
+		name := ing.Namespace + "/" + ing.Name
+		status := current[name]
+		if status == nil {
+			status = &ingressConfigStatus{}
+		}
+		if changes != nil && !changes.Full && !changes.Ingresses[name] && last[name] != nil {
+			for _, msg := range last[name].Errors {
+				status.Errors = appendMessage(status.Errors, msg)
+			}
+			for _, msg := range last[name].Warnings {
+				status.Warnings = appendMessage(status.Warnings, msg)
+			}
+		}
+		sort.Strings(status.Errors)
+		sort.Strings(status.Warnings)
+		statuses[name] = status
+		if len(status.Errors) > configStatusMaxMessages {
+			status.Errors = status.Errors[:configStatusMaxMessages]
+		}
+		if len(status.Warnings) > configStatusMaxMessages {
+			status.Warnings = status.Warnings[:configStatusMaxMessages]
+		}
+		status.Accepted = len(status.Errors) == 0
+		status.ObservedGeneration = ing.Generation
+		if s.isLeader() {
+			s.write(ing, status)
+		}
+	}
+	s.mutex.Lock()
+	s.last = statuses
+	s.mutex.Unlock()
+}
+
+func (s *configStatus) write(ing *extensions.Ingress, status *ingressConfigStatus) {
+	value, err := json.Marshal(status)
+	if err != nil {
+		glog.Warningf("error encoding config status of ingress '%s/%s': %v", ing.Namespace, ing.Name, err)
+		return
+	}
+	if ing.Annotations[configStatusAnnotation] == string(value) {
+		return
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{configStatusAnnotation: string(value)},
+		},
+	})
+	if _, err := s.client.ExtensionsV1beta1().Ingresses(ing.Namespace).Patch(ing.Name, k8stypes.MergePatchType, patch); err != nil {
+		glog.Warningf("error updating config status of ingress '%s/%s': %v", ing.Namespace, ing.Name, err)
+	}
+}
@@ -17,28 +17,40 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/controller"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/defaults"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/controller/dynconfig"
+	gatewayconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/gateway"
 	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	tcpserviceconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/tcpservice"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/tracing"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/vault"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/version"
 )
 
@@ -52,16 +64,108 @@ type HAProxyController struct {
 	converterOptions  *ingtypes.ConverterOptions
 	command           string
 	reloadStrategy    *string
+	masterSocket      *string
+	version           *string
 	configDir         string
 	configFilePrefix  string
 	configFileSuffix  string
 	maxOldConfigFiles *int
+	backendShards     *int
+	converterWorkers  *int
+	incrementalSync   *bool
+	tracker           *ingtypes.Tracker
+	oauthNamespaces   *string
+	secretNamespaces  *string
+	annPrefix         *string
+	failoverConfig    *string
+	failover          *failoverCluster
+	globalConfigName  *string
+	globalCRD         *globalConfigCRD
+	useResources      *bool
+	resources         *configResources
+	useClassParams    *bool
+	classParams       *ingressClassParams
+	gatewayClass      *string
+	gateway           *gatewayResources
+	useTCPServices    *bool
+	tcpServices       *tcpServiceResources
+	showErrorsIntvl   *time.Duration
+	dynJournal        *string
+	journalVerified   bool
+	showErrors        *showErrors
+	errorsTokenFile   *string
+	showTableIntvl    *time.Duration
+	showTable         *showTable
+	tableTokenFile    *string
+	alertsIntvl       *time.Duration
+	backendAlerts     *backendAlerts
+	statsIntvl        *time.Duration
+	haproxyStats      *haproxyStats
+	logStdout         *bool
+	haproxyLogs       *haproxyLogs
+	templateFile      *string
+	templatePartials  *string
+	templateWatcher   *templateWatcher
+	otlpEndpoint      *string
+	tracer            *tracing.Tracer
+	auditOutput       *string
+	configEvents      *bool
+	events            *converterEvents
+	useConfigStatus   *bool
+	configStatus      *configStatus
+	webhookURL        *string
+	webhookFormat     *string
+	notifier          *webhookNotifier
+	acmeServer        *bool
+	acmeCheckPeriod   *time.Duration
+	acmeKeyName       *string
+	acmeTokenName     *string
+	acme              *acmeManager
+	useCertManager    *bool
+	certManager       *certManager
+	ocsp              *ocspManager
+	vaultAddress      *string
+	vaultPKIMount     *string
+	vaultTokenFile    *string
+	vaultK8sRole      *string
+	vaultK8sMount     *string
+	vaultCertTTL      *string
+	vault             *vaultProvider
+	spiffeSocket      *string
+	spiffe            *spiffeWatcher
+	fallbackCertName  *string
+	defaultCert       *defaultCertificate
+	cache             *cache
+	audit             *configAudit
+	admissionPort     *int
+	admissionCert     *string
+	admissionKey      *string
+	modelTokenFile    *string
+	dumpModelFile     *string
+	resyncTokenFile   *string
+	resyncPending     int32
+	backendRefs       map[string]*backendRef
+	backendRefsMutex  sync.Mutex
+	configMutex       sync.Mutex
+	stopCh            chan struct{}
+	stopOnce          sync.Once
 	haproxyTemplate   *template
 	modsecConfigFile  string
 	modsecTemplate    *template
 	currentConfig     *types.ControllerConfig
 }
 
+type backendRef struct {
+	namespace string
+	name      string
+	reuse     string
+	ingresses string
+	advised   bool
+	servers   map[string]string
+	addrs     map[string]string
+	alert     string
+}
+
 // NewHAProxyController constructor
 func NewHAProxyController() *HAProxyController {
 	return &HAProxyController{}
@@ -81,7 +185,55 @@ func (hc *HAProxyController) Info() *ingress.BackendInfo {
 func (hc *HAProxyController) Start() {
 	hc.controller = controller.NewIngressController(hc)
 	hc.controller.StartControllers()
+	hc.stopCh = make(chan struct{})
+	if hc.haproxyLogs != nil {
+		hc.haproxyLogs.run(hc.stopCh)
+	}
 	hc.configController()
+	if hc.showErrors != nil {
+		hc.showErrors.run(hc.stopCh)
+	}
+	if hc.showTable != nil {
+		hc.showTable.run(hc.stopCh)
+	}
+	if hc.backendAlerts != nil {
+		hc.backendAlerts.run(hc.stopCh)
+	}
+	if hc.haproxyStats != nil {
+		hc.haproxyStats.run(hc.stopCh)
+	}
+	if hc.tracer != nil {
+		hc.tracer.Run(5*time.Second, hc.stopCh)
+	}
+	if hc.notifier != nil {
+		hc.notifier.run(hc.stopCh)
+	}
+	if hc.acme != nil {
+		hc.acme.run(hc.stopCh)
+	}
+	if hc.certManager != nil {
+		hc.certManager.run(hc.stopCh)
+	}
+	if hc.ocsp != nil {
+		hc.ocsp.run(hc.stopCh)
+	}
+	if hc.vault != nil {
+		hc.vault.run(hc.stopCh)
+	}
+	if hc.spiffe != nil {
+		hc.spiffe.run(hc.stopCh)
+	}
+	if *hc.dumpModelFile != "" {
+		hc.handleDumpModel()
+	}
+	hc.handleResyncSignal()
+	if *hc.admissionPort > 0 {
+		if hc.cfg.V07 {
+			glog.Warningf("admission webhook is only supported on v0.8 controller, ignoring --admission-webhook-port")
+		} else {
+			hc.runAdmissionWebhook()
+		}
+	}
 	hc.controller.Start()
 }
 
@@ -92,10 +244,26 @@ func (hc *HAProxyController) configController() {
 	hc.cfg = hc.controller.GetConfig()
 
 	if hc.cfg.V07 {
+		if *hc.masterSocket != "" {
+			glog.Warningf("external haproxy is only supported on v0.8 controller, ignoring --master-socket")
+		}
+		if *hc.dynJournal != "" {
+			dynconfig.EnableJournal(*hc.dynJournal)
+			hc.journalVerified = dynconfig.VerifyJournal("/var/run/haproxy-stats.sock")
+		}
 		return
 	}
 
 	// starting v0.8 only config
+	converterLogger := &logger{depth: 1, messages: converterMessages}
+	if *hc.configEvents {
+		hc.events = newConverterEvents(hc.controller.GetRecorder, hc.storeLister)
+		converterLogger.listeners = append(converterLogger.listeners, hc.events)
+	}
+	if *hc.useConfigStatus {
+		hc.configStatus = newConfigStatus(hc.cfg.Client, hc.controller.IsLeader)
+		converterLogger.listeners = append(converterLogger.listeners, hc.configStatus)
+	}
 	logger := &logger{depth: 1}
 	instanceOptions := haproxy.InstanceOptions{
 		HAProxyCmd:        "haproxy",
@@ -103,63 +271,137 @@ func (hc *HAProxyController) configController() {
 		HAProxyConfigFile: "/etc/haproxy/haproxy.cfg",
 		ReloadStrategy:    *hc.reloadStrategy,
 		MaxOldConfigFiles: *hc.maxOldConfigFiles,
+		BackendShards:     *hc.backendShards,
+		HAProxyTemplate:   *hc.templateFile,
+		TemplatePartials:  *hc.templatePartials,
+		Tracer:            hc.tracer,
+	}
+	if *hc.masterSocket != "" {
+		masters := utils.Split(*hc.masterSocket, ",")
+		utils.SetHAProxyMasters(masters)
+		instanceOptions.MasterSockets = masters
 	}
-	hc.instance = haproxy.CreateInstance(logger, hc, instanceOptions)
+	if *hc.dynJournal != "" {
+		// verified and replayed before the first update, so it can skip the reload
+		dynconfig.EnableJournal(*hc.dynJournal)
+		instanceOptions.Journal = dynconfig.Journal{}
+		instanceOptions.JournalVerified = dynconfig.VerifyJournal("/var/run/haproxy-stats.sock")
+	}
+	haproxyVersion := hc.haproxyVersion()
+	instanceOptions.HAProxyVersion = haproxyVersion
+	if hc.audit != nil {
+		instanceOptions.Auditor = hc.audit
+	}
+	if hc.notifier != nil {
+		instanceOptions.Notifier = hc.notifier
+	}
+	var partials []string
+	if *hc.templatePartials != "" {
+		partials = append(partials, *hc.templatePartials)
+	}
+	if *hc.useClassParams {
+		// class params are read before parsing the templates, so its partials are used on startup
+		hc.classParams = newIngressClassParams(hc.cfg.Client, hc.cfg.IngressClass, ingressClassPartials, hc.controller.Notify)
+		hc.classParams.run(hc.stopCh)
+		instanceOptions.ClassPartials = ingressClassPartials
+		partials = append(partials, ingressClassPartials)
+	}
+	hc.instance = haproxy.CreateInstance(logger, instanceOptions)
 	if err := hc.instance.ParseTemplates(); err != nil {
 		glog.Fatalf("error creating HAProxy instance: %v", err)
 	}
-	cache := newCache(hc.storeLister, hc.controller)
-	hc.converterOptions = &ingtypes.ConverterOptions{
-		Logger:           logger,
-		Cache:            cache,
-		AnnotationPrefix: "ingress.kubernetes.io",
-		DefaultBackend:   hc.cfg.DefaultService,
-		DefaultSSLFile:   hc.createDefaultSSLFile(cache),
+	if *hc.templateFile != "" || len(partials) > 0 {
+		hc.templateWatcher = newTemplateWatcher(*hc.templateFile, partials, 10*time.Second, hc.controller.Notify, hc.controller.GetRecorder, os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME"))
+		hc.templateWatcher.run(hc.stopCh)
 	}
-}
-
-func (hc *HAProxyController) createDefaultSSLFile(cache *cache) (tlsFile ingtypes.File) {
-	if hc.cfg.DefaultSSLCertificate != "" {
-		tlsFile, err := cache.GetTLSSecretPath(hc.cfg.DefaultSSLCertificate)
-		if err == nil {
-			return tlsFile
+	if *hc.failoverConfig != "" {
+		failover, err := newFailoverCluster(*hc.failoverConfig, hc.cfg.ResyncPeriod, hc.controller.Notify)
+		if err != nil {
+			glog.Fatalf("error creating failover cluster client: %v", err)
 		}
-		glog.Warningf("using auto generated fake certificate due to an error reading default TLS certificate: %v", err)
-	} else {
-		glog.Info("using auto generated fake certificate")
+		hc.failover = failover
+		hc.failover.run(hc.stopCh)
 	}
-	path, hash := hc.controller.CreateDefaultSSLCertificate()
-	tlsFile = ingtypes.File{
-		Filename: path,
-		SHA1Hash: hash,
+	if *hc.globalConfigName != "" {
+		hc.globalCRD = newGlobalConfigCRD(hc.cfg.Client.CoreV1().RESTClient(), *hc.globalConfigName, hc.controller.Notify)
+		hc.globalCRD.run(hc.stopCh)
 	}
-	return tlsFile
-}
-
-// CreateX509CertsDir hard link files from certs to a single directory.
-func (hc *HAProxyController) CreateX509CertsDir(bindName string, certs []string) (string, error) {
-	x509dir := "/var/haproxy/certs/" + bindName
-	if err := os.RemoveAll(x509dir); err != nil {
-		return "", err
+	if *hc.useResources {
+		hc.resources = newConfigResources(hc.cfg.Client.CoreV1().RESTClient(), hc.cfg.Namespaces, hc.controller.Notify)
+		hc.resources.run(hc.stopCh)
 	}
-	if err := os.MkdirAll(x509dir, 0700); err != nil {
-		return "", err
+	if *hc.gatewayClass != "" {
+		hc.gateway = newGatewayResources(hc.cfg.Client.CoreV1().RESTClient(), hc.cfg.Namespaces, *hc.gatewayClass, hc.controller.Notify)
+		hc.gateway.run(hc.stopCh)
 	}
-	for _, cert := range certs {
-		srcFile, err := os.Stat(cert)
-		if err != nil {
-			return "", err
+	if *hc.useTCPServices {
+		hc.tcpServices = newTCPServiceResources(hc.cfg.Client.CoreV1().RESTClient(), hc.cfg.Namespaces, hc.controller.Notify)
+		hc.tcpServices.run(hc.stopCh)
+	}
+	if *hc.acmeServer {
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			glog.Fatalf("POD_NAMESPACE environment variable is required by --acme-server")
 		}
-		dstFile := x509dir + "/" + srcFile.Name()
-		if err := os.Link(cert, dstFile); err != nil {
-			return "", err
+		hc.acme = newAcmeManager(hc.cfg.Client, hc.controller.IsLeader, namespace, *hc.acmeKeyName, *hc.acmeTokenName, *hc.acmeCheckPeriod)
+	}
+	if *hc.useCertManager {
+		hc.certManager = newCertManager(hc.cfg.Client, hc.controller.IsLeader)
+	}
+	hc.ocsp = newOCSPManager(hc.controller.Notify)
+	if *hc.vaultAddress != "" {
+		hc.vault = newVaultProvider(vault.Config{
+			Address:         *hc.vaultAddress,
+			PKIMount:        *hc.vaultPKIMount,
+			TokenFile:       *hc.vaultTokenFile,
+			KubernetesRole:  *hc.vaultK8sRole,
+			KubernetesMount: *hc.vaultK8sMount,
+			TTL:             *hc.vaultCertTTL,
+		}, hc.controller.Notify)
+	}
+	if *hc.spiffeSocket != "" {
+		hc.spiffe = newSPIFFEWatcher(*hc.spiffeSocket, hc.controller.Notify)
+	}
+	cache := newCache(hc.storeLister, hc.controller, hc.failover, hc.globalCRD, hc.resources, hc.classParams)
+	hc.cache = cache
+	hc.defaultCert = newDefaultCertificate(hc.cfg.Client, cache, hc.cfg.DefaultSSLCertificate, os.Getenv("POD_NAMESPACE"), *hc.fallbackCertName, hc.controller.CreateDefaultSSLCertificate)
+	hc.converterOptions = &ingtypes.ConverterOptions{
+		Logger:                    converterLogger,
+		Cache:                     cache,
+		AnnotationPrefix:          utils.Split(*hc.annPrefix, ","),
+		DefaultBackend:            hc.cfg.DefaultService,
+		DefaultSSLFile:            hc.defaultCert.file(),
+		OAuthNamespaces:           utils.Split(*hc.oauthNamespaces, ","),
+		SecretNamespaces:          hc.secretNamespaceList(),
+		SecretNamespaceAnnotation: hc.cfg.SecretNamespaceAnnotation,
+		Workers:                   *hc.converterWorkers,
+		Tracker:                   hc.tracker,
+		HAProxyVersion:            haproxyVersion,
+		LocalNodeName:             hc.localNodeName(),
+		LocalPodName:              os.Getenv("POD_NAME"),
+	}
+	if hc.haproxyLogs != nil {
+		hc.converterOptions.LogSocket = haproxyLogSocket
+	}
+	if hc.acme != nil {
+		hc.converterOptions.AcmeSocket = acmeSocket
+		hc.converterOptions.AcmeTracker = hc.acme
+	}
+	if hc.certManager != nil {
+		hc.converterOptions.CertTracker = hc.certManager
+	}
+	if hc.vault != nil {
+		hc.converterOptions.SecretProviders = map[string]ingtypes.SecretProvider{
+			"vault": hc.vault,
 		}
 	}
-	return x509dir, nil
 }
 
 // Stop shutdown the controller process
 func (hc *HAProxyController) Stop() error {
+	// closing twice panics, the generic controller already answers
+	// a second call with a shutdown in progress error
+	hc.stopOnce.Do(func() { close(hc.stopCh) })
 	err := hc.controller.Stop()
 	return err
 }
@@ -179,11 +421,44 @@ func (hc *HAProxyController) Check(_ *http.Request) error {
 	return nil
 }
 
+// Ready implements the readiness check: the last configuration
+// should be applied and HAProxy should answer on its stats socket
+func (hc *HAProxyController) Ready() error {
+	if hc.instance == nil {
+		return fmt.Errorf("controller hasn't started")
+	}
+	if !hc.cfg.V07 {
+		if err := hc.instance.UpdateError(); err != nil {
+			return fmt.Errorf("last configuration failed: %v", err)
+		}
+	}
+	if _, err := utils.HAProxyCommand("/var/run/haproxy-stats.sock", "show info"); err != nil {
+		return fmt.Errorf("haproxy is not running: %v", err)
+	}
+	return nil
+}
+
 // SetListers give access to the store listers
 func (hc *HAProxyController) SetListers(lister *ingress.StoreLister) {
 	hc.storeLister = lister
 }
 
+// RegisterHandlers adds the HAProxy debug endpoints
+func (hc *HAProxyController) RegisterHandlers(mux *http.ServeMux) {
+	if hc.showErrors != nil && *hc.errorsTokenFile != "" {
+		mux.HandleFunc("/debug/haproxy-errors", hc.showErrors.handler)
+	}
+	if hc.showTable != nil && *hc.tableTokenFile != "" {
+		mux.HandleFunc("/debug/haproxy-tables", hc.showTable.handler)
+	}
+	if *hc.modelTokenFile != "" {
+		mux.HandleFunc("/debug/model", hc.modelHandler)
+	}
+	if *hc.resyncTokenFile != "" {
+		mux.HandleFunc("/resync", hc.resyncHandler)
+	}
+}
+
 // UpdateIngressStatus custom callback used to update the status in an Ingress rule
 // If the function returns nil the standard functions will be executed.
 func (hc *HAProxyController) UpdateIngressStatus(*extensions.Ingress) []api.LoadBalancerIngress {
@@ -195,8 +470,106 @@ func (hc *HAProxyController) UpdateIngressStatus(*extensions.Ingress) []api.Load
 func (hc *HAProxyController) ConfigureFlags(flags *pflag.FlagSet) {
 	hc.reloadStrategy = flags.String("reload-strategy", "native",
 		`Name of the reload strategy. Options are: native (default) or reusesocket`)
+	hc.version = flags.String("haproxy-version", "",
+		`Major and minor version of HAProxy, eg 2.4, used to skip configurations which need a newer version with a warning. Read from the HAProxy binary, or from the first external instance, by default. v0.8 only`)
+	hc.masterSocket = flags.String("master-socket", "",
+		`Comma-separated list of addresses of the master CLI of external HAProxy instances, either a unix socket or host:port. HAProxy is reloaded and receives runtime commands via its master CLI, and should read the configuration files from a volume shared with the controller. v0.8 only`)
 	hc.maxOldConfigFiles = flags.Int("max-old-config-files", 0,
 		`Maximum old haproxy timestamped config files to allow before being cleaned up. A value <= 0 indicates a single non-timestamped config file will be used`)
+	hc.backendShards = flags.Int("backend-shards", 0,
+		`Number of files the backends are split into, only files whose content changed are written. Use 0 to declare all the backends in the main config file. v0.8 only`)
+	hc.converterWorkers = flags.Int("converter-workers", 1,
+		`Number of backends whose annotations are parsed concurrently, used to reduce the time of a full sync on big clusters. v0.8 only`)
+	hc.incrementalSync = flags.Bool("incremental-sync", false,
+		`Reuse the backends whose ingress resources, service and endpoints didn't change since the last sync instead of parsing their annotations again. v0.8 only`)
+	hc.oauthNamespaces = flags.String("oauth-namespaces", "",
+		`Comma-separated list of namespaces whose services can be used as oauth-service from ingress resources of another namespace. Use '*' to allow any namespace`)
+	hc.secretNamespaces = flags.String("secret-namespaces", "",
+		`Comma-separated list of namespaces whose secrets can be referenced from ingress resources of another namespace. Use '*' to allow any namespace. v0.8 only`)
+	hc.annPrefix = flags.String("annotations-prefix", "ingress.kubernetes.io",
+		`Comma-separated list of prefixes of ingress and service annotations, in order of precedence. v0.8 only`)
+	hc.failoverConfig = flags.String("failover-kubeconfig", "",
+		`Path to a kubeconfig file of a secondary cluster whose endpoints can be added to the local backends, see failover-cluster annotation`)
+	hc.globalConfigName = flags.String("global-config-resource", "",
+		`Name of a cluster scoped HAProxyGlobalConfig resource whose spec supersedes the global ConfigMap. v0.8 only`)
+	hc.useResources = flags.Bool("config-resources", false,
+		`Read HAProxyBackend and HAProxyHost resources, a typed alternative to backend and host annotations. v0.8 only`)
+	hc.useClassParams = flags.Bool("ingress-class-parameters", false,
+		`Read the ConfigMap referenced by the parameters of the IngressClass named by --ingress-class, whose keys override the global ConfigMap, and keys ending with .tmpl are template partials. v0.8 only`)
+	hc.gatewayClass = flags.String("gateway-class", "",
+		`Name of the GatewayClass whose Gateway API resources should be used to configure HAProxy. Use an empty string to disable. v0.8 only`)
+	hc.useTCPServices = flags.Bool("tcp-service-resources", false,
+		`Read TCPService resources, which route TCP connections to services, optionally by the TLS SNI extension. v0.8 only`)
+	hc.dynJournal = flags.String("dynamic-update-journal", "",
+		`Path of a file used to journal the runtime API commands applied to HAProxy since its last reload. Journaled commands are verified and replayed on startup`)
+	hc.showErrorsIntvl = flags.Duration("show-errors-interval", 0,
+		`Interval between readings of malformed requests and responses captured by HAProxy. Use 0 to disable`)
+	hc.errorsTokenFile = flags.String("show-errors-token-file", "",
+		`Path of a file with a bearer token which enables the /debug/haproxy-errors endpoint of the healthz port, used to dump the last malformed requests and responses captured by HAProxy`)
+	hc.showTableIntvl = flags.Duration("show-table-interval", 0,
+		`Interval between readings of the HAProxy stick tables, used by rate limits. Use 0 to disable`)
+	hc.tableTokenFile = flags.String("show-table-token-file", "",
+		`Path of a file with a bearer token which enables the /debug/haproxy-tables endpoint of the healthz port, used to dump the top and the blocked entries of the stick tables`)
+	hc.alertsIntvl = flags.Duration("backend-alerts-interval", 0,
+		`Interval between readings of the state of the HAProxy servers, used to emit an aggregated event on services with unreachable endpoints. Use 0 to disable. v0.8 only`)
+	hc.statsIntvl = flags.Duration("haproxy-metrics-interval", 0,
+		`Interval between readings of the HAProxy backends and servers, exported as metrics labeled with their namespace, service, ingress and pod. Use 0 to disable. v0.8 only`)
+	hc.templateFile = flags.String("haproxy-template", "",
+		`Path of a template file which replaces the built-in haproxy.cfg template, eg mounted from a ConfigMap. Changes of the file are applied without restarting the controller. v0.8 only`)
+	hc.templatePartials = flags.String("haproxy-template-partials", "",
+		`Directory of template files, eg mounted from a ConfigMap, whose define actions replace the sections of the same name of the haproxy.cfg template: global, defaults, backend and frontends. Changes of the files are applied without restarting the controller. v0.8 only`)
+	hc.logStdout = flags.Bool("haproxy-log-stdout", false,
+		`Receives the logs of HAProxy in a syslog listener of the controller and writes them to the standard output, used if the syslog-endpoint config key is not declared. v0.8 only`)
+	hc.otlpEndpoint = flags.String("otlp-endpoint", "",
+		`URL of the OTLP/HTTP endpoint of an OpenTelemetry collector, eg http://otel-collector:4318, where the spans of the synchronizations are exported. Use an empty string to disable. v0.8 only`)
+	hc.configEvents = flags.Bool("config-events", true,
+		`Emits the warnings and errors found converting ingress resources and annotations as events of the ingress or service they refer to. v0.8 only`)
+	hc.useConfigStatus = flags.Bool("config-status", false,
+		`Writes the haproxy-ingress.github.io/config-status annotation on every ingress resource, summarizing the errors and warnings found converting it. v0.8 only`)
+	hc.webhookURL = flags.String("notify-webhook-url", "",
+		`URL where a JSON summary of every HAProxy update which changed the configuration is posted: changed hosts and backends, result and duration. Use an empty string to disable. v0.8 only`)
+	hc.webhookFormat = flags.String("notify-webhook-format", "json",
+		`Format of the webhook payload. Options are: json (default), slack or teams`)
+	hc.acmeServer = flags.Bool("acme-server", false,
+		`Starts the ACME subsystem, which issues certificates of TLS secrets using the http-01 challenge. The ACME server and account are configured in the global ConfigMap. v0.8 only`)
+	hc.acmeCheckPeriod = flags.Duration("acme-check-period", 24*time.Hour,
+		`Interval between checks of the certificates issued by the ACME subsystem, which are renewed before they expire`)
+	hc.acmeKeyName = flags.String("acme-secret-key-name", "acme-private-key",
+		`Name of the secret, in the namespace of the controller, with the private key of the ACME account. The secret is created if it does not exist`)
+	hc.acmeTokenName = flags.String("acme-token-configmap-name", "acme-validation-tokens",
+		`Name of the ConfigMap, in the namespace of the controller, used to share the http-01 challenge tokens between the controller instances`)
+	hc.fallbackCertName = flags.String("default-ssl-fallback-secret-name", "default-ssl-fallback-certificate",
+		`Name of the secret, in the namespace of the controller, with the self-signed certificate used while the --default-ssl-certificate secret is not configured or doesn't exist. The secret is created if it does not exist. Use an empty string to use a non persisted certificate. v0.8 only`)
+	hc.useCertManager = flags.Bool("cert-manager", false,
+		`Creates cert-manager Certificate resources of the TLS secrets which don't exist, if the cert-manager-issuer annotation is configured. v0.8 only`)
+	hc.vaultAddress = flags.String("vault-address", "",
+		`Address of a HashiCorp Vault server, eg https://vault:8200, whose PKI secrets engine issues the certificates of the TLS secret names in the form vault:<role>. Use an empty string to disable. v0.8 only`)
+	hc.vaultPKIMount = flags.String("vault-pki-mount", "pki",
+		`Mount path of the Vault PKI secrets engine`)
+	hc.vaultTokenFile = flags.String("vault-token-file", "",
+		`Path of a file with the Vault token, read before every request. The Kubernetes auth method is used if empty`)
+	hc.vaultK8sRole = flags.String("vault-kubernetes-role", "",
+		`Role used to login in Vault via the Kubernetes auth method, using the service account token of the controller`)
+	hc.vaultK8sMount = flags.String("vault-kubernetes-mount", "kubernetes",
+		`Mount path of the Vault Kubernetes auth method`)
+	hc.vaultCertTTL = flags.String("vault-cert-ttl", "",
+		`TTL of the certificates issued by Vault, eg 720h. The default TTL of the role is used if empty`)
+	hc.spiffeSocket = flags.String("spiffe-workload-socket", "",
+		`Path of the unix socket of the SPIFFE Workload API of a SPIRE agent, eg unix:///run/spire/sockets/agent.sock. The X.509 SVID of the controller is used as the client certificate of the backends with secure-spiffe. Use an empty string to disable. v0.8 only`)
+	hc.auditOutput = flags.String("config-audit", "",
+		`Logs the differences of the HAProxy configuration file applied by every update and the objects which triggered it. Use log to write to the controller log, or the path of a file rotated after 10MB. Use an empty string to disable. v0.8 only`)
+	hc.admissionPort = flags.Int("admission-webhook-port", 0,
+		`Port of the HTTPS server of a validating admission webhook which rejects ingress resources with invalid annotations. Use 0 to disable. v0.8 only`)
+	hc.admissionCert = flags.String("admission-webhook-cert", "",
+		`Path of the certificate file used by the admission webhook server`)
+	hc.admissionKey = flags.String("admission-webhook-key", "",
+		`Path of the private key file used by the admission webhook server`)
+	hc.modelTokenFile = flags.String("model-api-token-file", "",
+		`Path of a file with a bearer token which enables the /debug/model endpoint of the healthz port, used to dump the HAProxy model. v0.8 only`)
+	hc.dumpModelFile = flags.String("dump-model", "",
+		`Path of a file where the HAProxy model is written when the controller receives a SIGUSR1 signal. Use a .yaml suffix to dump as YAML. v0.8 only`)
+	hc.resyncTokenFile = flags.String("resync-api-token-file", "",
+		`Path of a file with a bearer token which enables the /resync endpoint of the healthz port, used to force a full sync and a reload of HAProxy. A SIGUSR2 signal has the same effect`)
 	ingressClass := flags.Lookup("ingress-class")
 	if ingressClass != nil {
 		ingressClass.Value.Set("haproxy")
@@ -213,17 +586,86 @@ func (hc *HAProxyController) OverrideFlags(flags *pflag.FlagSet) {
 	hc.modsecConfigFile = "/etc/haproxy/spoe-modsecurity.conf"
 	hc.modsecTemplate = newTemplate("spoe-modsecurity-v07.tmpl", "/etc/haproxy/modsecurity/spoe-modsecurity-v07.tmpl", 1024)
 	hc.command = "/haproxy-reload.sh"
+	if *hc.showErrorsIntvl > 0 {
+		hc.showErrors = newShowErrors("/var/run/haproxy-stats.sock", *hc.showErrorsIntvl, *hc.errorsTokenFile, hc.adviseH2Reuse)
+	}
+	if *hc.showTableIntvl > 0 {
+		hc.showTable = newShowTable("/var/run/haproxy-stats.sock", *hc.showTableIntvl, *hc.tableTokenFile)
+	}
+	if *hc.alertsIntvl > 0 {
+		hc.backendAlerts = newBackendAlerts("/var/run/haproxy-stats.sock", *hc.alertsIntvl, hc.alertUnreachable)
+	}
+	if *hc.statsIntvl > 0 {
+		hc.haproxyStats = newHAProxyStats("/var/run/haproxy-stats.sock", *hc.statsIntvl, hc.statRef)
+		prometheus.MustRegister(hc.haproxyStats)
+	}
+	if *hc.logStdout {
+		hc.haproxyLogs = newHAProxyLogs(haproxyLogSocket, os.Stdout)
+	}
+	if *hc.otlpEndpoint != "" {
+		hc.tracer = tracing.NewTracer(*hc.otlpEndpoint, "haproxy-ingress")
+	}
+	if *hc.auditOutput != "" {
+		hc.audit = newConfigAudit(*hc.auditOutput)
+	}
+	if *hc.webhookURL != "" {
+		notifier, err := newWebhookNotifier(*hc.webhookURL, *hc.webhookFormat)
+		if err != nil {
+			glog.Fatalf("error creating webhook notifier: %v", err)
+		}
+		hc.notifier = notifier
+	}
+	if *hc.incrementalSync {
+		hc.tracker = ingtypes.NewTracker()
+	}
 
 	if !(*hc.reloadStrategy == "native" || *hc.reloadStrategy == "reusesocket" || *hc.reloadStrategy == "multibinder") {
 		glog.Fatalf("Unsupported reload strategy: %v", *hc.reloadStrategy)
 	}
 }
 
+// Track receives the objects which enqueue a new sync. Ingress resources
+// and endpoints are tracked by name, any other object asks for a full sync.
+func (hc *HAProxyController) Track(obj interface{}) {
+	if hc.audit != nil {
+		hc.audit.track(obj)
+	}
+	if hc.tracker == nil {
+		return
+	}
+	switch obj := obj.(type) {
+	case *extensions.Ingress:
+		if obj.Name != "" {
+			hc.tracker.TrackIngress(obj.Namespace + "/" + obj.Name)
+			return
+		}
+	case *api.Endpoints:
+		hc.tracker.TrackService(obj.Namespace + "/" + obj.Name)
+		return
+	}
+	hc.tracker.TrackAll()
+}
+
 // SetConfig receives the ConfigMap the user has configured
 func (hc *HAProxyController) SetConfig(configMap *api.ConfigMap) {
+	hc.configMutex.Lock()
+	defer hc.configMutex.Unlock()
 	hc.configMap = configMap
 }
 
+// syncConfig returns the global config and a copy of the converter options
+// of the last sync. Safe to be called concurrently, eg by the admission webhook.
+func (hc *HAProxyController) syncConfig() (map[string]string, *ingtypes.ConverterOptions) {
+	hc.configMutex.Lock()
+	defer hc.configMutex.Unlock()
+	var globalConfig map[string]string
+	if hc.configMap != nil {
+		globalConfig = hc.configMap.Data
+	}
+	options := *hc.converterOptions
+	return globalConfig, &options
+}
+
 // BackendDefaults defines default values to the ingress core
 func (hc *HAProxyController) BackendDefaults() defaults.Backend {
 	return newHAProxyConfig(hc).Backend
@@ -252,6 +694,12 @@ func (hc *HAProxyController) DrainSupport() (drainSupport bool) {
 
 // SyncIngress sync HAProxy config from a very early stage
 func (hc *HAProxyController) SyncIngress(item interface{}) error {
+	trace := hc.tracer.StartSync("sync")
+	defer trace.End()
+	if hc.audit != nil {
+		hc.audit.startSync()
+	}
+	span := hc.tracer.Start("list")
 	var ingress []*extensions.Ingress
 	for _, iing := range hc.storeLister.Ingress.List() {
 		ing := iing.(*extensions.Ingress)
@@ -262,22 +710,225 @@ func (hc *HAProxyController) SyncIngress(item interface{}) error {
 	sort.SliceStable(ingress, func(i, j int) bool {
 		return ingress[i].ResourceVersion < ingress[j].ResourceVersion
 	})
+	span.SetAttr("ingresses", len(ingress))
+	span.End()
 
-	var globalConfig map[string]string
-	if hc.configMap != nil {
-		globalConfig = hc.configMap.Data
+	start := time.Now()
+	span = hc.tracer.Start("convert")
+	hc.configMutex.Lock()
+	hc.converterOptions.DefaultSSLFile = hc.defaultCert.file()
+	if hc.spiffe != nil {
+		hc.converterOptions.SPIFFESVIDFile, hc.converterOptions.SPIFFEBundleFile = hc.spiffe.files()
 	}
+	hc.configMutex.Unlock()
+	globalConfig, _ := hc.syncConfig()
 	converter := ingressconverter.NewIngressConverter(
 		hc.converterOptions,
 		hc.instance.Config(),
 		globalConfig,
 	)
 	converter.Sync(ingress)
+	if hc.gateway != nil {
+		gwconverter := gatewayconverter.NewGatewayConverter(
+			hc.converterOptions,
+			hc.instance.Config(),
+		)
+		gwconverter.Sync(hc.gateway.getResources())
+		if hc.controller.IsLeader() {
+			hc.gateway.updateStatus(gwconverter.RouteStatus())
+		}
+	}
+	if hc.tcpServices != nil {
+		tcpserviceconverter.NewTCPServiceConverter(
+			hc.converterOptions,
+			hc.instance.Config(),
+		).Sync(hc.tcpServices.getServices())
+	}
+	if hc.events != nil {
+		hc.events.commit()
+	}
+	if hc.certManager != nil {
+		hc.certManager.commit()
+	}
+	if hc.vault != nil {
+		hc.vault.commit()
+	}
+	if hc.configStatus != nil {
+		var changes *ingtypes.Changes
+		if hc.tracker != nil {
+			changes = hc.tracker.LastChanges()
+		}
+		hc.configStatus.update(ingress, changes)
+	}
+	hc.updateBackendRefs(ingress)
+	if hc.showTable != nil {
+		hc.showTable.setLimits(hc.instance.Config().Backends())
+	}
+	updateSyncObjects(len(ingress), hc.instance.Config())
+	updateCertExpire(hc.cache.takeCerts())
+	span.SetAttr("hosts", len(hc.instance.Config().Hosts()))
+	span.SetAttr("backends", len(hc.instance.Config().Backends()))
+	span.End()
+	start = observeSyncStep("convert", start)
+	acmeConfig := hc.instance.Config().Global().Acme
+	hc.ocsp.commit(hc.instance.Config(), hc.converterOptions.DefaultSSLFile.Filename)
+	if hc.templateWatcher != nil {
+		hc.templateWatcher.parse(hc.instance.ParseTemplates)
+	}
+	if hc.takeResync() {
+		hc.instance.ForceReload()
+	}
 	hc.instance.Update()
+	if hc.templateWatcher != nil {
+		hc.templateWatcher.updated(hc.instance.UpdateError())
+	}
+	observeSyncStep("update", start)
+	if hc.acme != nil {
+		// after the update, so HAProxy already answers the challenges
+		hc.acme.commit(acmeConfig)
+	}
 
 	return nil
 }
 
+func (hc *HAProxyController) updateBackendRefs(ingress []*extensions.Ingress) {
+	if hc.showErrors == nil && hc.backendAlerts == nil && hc.haproxyStats == nil {
+		return
+	}
+	svcIngresses := serviceIngresses(ingress)
+	hc.backendRefsMutex.Lock()
+	defer hc.backendRefsMutex.Unlock()
+	backendRefs := make(map[string]*backendRef, len(hc.backendRefs))
+	for _, backend := range hc.instance.Config().Backends() {
+		ref := &backendRef{
+			namespace: backend.Namespace,
+			name:      backend.Name,
+			reuse:     backend.HTTPReuse,
+			ingresses: strings.Join(svcIngresses[backend.Namespace+"/"+backend.Name], ","),
+			servers:   make(map[string]string, len(backend.Endpoints)),
+			addrs:     make(map[string]string, len(backend.Endpoints)),
+		}
+		for _, ep := range backend.Endpoints {
+			ref.servers[ep.Name] = ep.TargetRef
+			ref.addrs[fmt.Sprintf("%s:%d", ep.IP, ep.Port)] = ep.TargetRef
+		}
+		if old, found := hc.backendRefs[backend.ID]; found {
+			ref.advised = old.advised
+			ref.alert = old.alert
+		}
+		backendRefs[backend.ID] = ref
+	}
+	hc.backendRefs = backendRefs
+}
+
+// serviceIngresses maps the namespace/name of the services to the names of the ingress resources using them
+func serviceIngresses(ingress []*extensions.Ingress) map[string][]string {
+	svcIngresses := map[string][]string{}
+	add := func(ing *extensions.Ingress, svcName string) {
+		svc := ing.Namespace + "/" + svcName
+		for _, name := range svcIngresses[svc] {
+			if name == ing.Name {
+				return
+			}
+		}
+		svcIngresses[svc] = append(svcIngresses[svc], ing.Name)
+	}
+	for _, ing := range ingress {
+		if ing.Spec.Backend != nil {
+			add(ing, ing.Spec.Backend.ServiceName)
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				add(ing, path.Backend.ServiceName)
+			}
+		}
+	}
+	for _, names := range svcIngresses {
+		sort.Strings(names)
+	}
+	return svcIngresses
+}
+
+// statRef returns the Kubernetes objects of a HAProxy backend or server, used as metric labels
+func (hc *HAProxyController) statRef(backend, addr string) statRef {
+	hc.backendRefsMutex.Lock()
+	defer hc.backendRefsMutex.Unlock()
+	ref, found := hc.backendRefs[backend]
+	if !found {
+		return statRef{}
+	}
+	// servers are mapped by their address, names of servers added by dynamic
+	// updates don't follow the endpoints; targetRef has the namespace/name format
+	targetRef := ref.addrs[addr]
+	return statRef{
+		namespace: ref.namespace,
+		service:   ref.name,
+		ingress:   ref.ingresses,
+		pod:       targetRef[strings.LastIndex(targetRef, "/")+1:],
+	}
+}
+
+// adviseH2Reuse emits a warning event on services whose backend
+// received invalid responses while connection reuse is enabled
+func (hc *HAProxyController) adviseH2Reuse(e *capturedError) {
+	if e.ProxyType != "backend" || e.Type != "response" {
+		return
+	}
+	hc.backendRefsMutex.Lock()
+	defer hc.backendRefsMutex.Unlock()
+	ref, found := hc.backendRefs[e.Proxy]
+	if !found || ref.advised || ref.reuse == "never" {
+		return
+	}
+	ref.advised = true
+	svc := objectReference(hc.storeLister, "Service", ref.namespace, ref.name)
+	if svc == nil {
+		return
+	}
+	hc.controller.GetRecorder().Eventf(svc, api.EventTypeWarning, "InvalidResponse",
+		"HAProxy captured an invalid response from server '%s' of backend '%s', consider adding the disable-h2-reuse annotation if the server misbehaves on reused connections",
+		e.Server, e.Proxy)
+}
+
+// alertUnreachable emits a single event on the service of a backend
+// whenever the summary of its unreachable endpoints changes
+func (hc *HAProxyController) alertUnreachable(backend string, unreachable []string, total int) {
+	hc.backendRefsMutex.Lock()
+	defer hc.backendRefsMutex.Unlock()
+	ref, found := hc.backendRefs[backend]
+	if !found {
+		return
+	}
+	var alert string
+	if len(unreachable) > 0 {
+		states := make([]string, len(unreachable))
+		for i, server := range unreachable {
+			var pod *api.Pod
+			if targetRef := strings.Split(ref.servers[server], "/"); len(targetRef) == 2 {
+				pod, _ = hc.storeLister.Pod.GetPod(targetRef[0], targetRef[1])
+			}
+			states[i] = podState(pod)
+		}
+		alert = fmt.Sprintf("%d/%d endpoints unreachable, pod state: %s", len(unreachable), total, summarizePodStates(states))
+	}
+	if alert == ref.alert {
+		return
+	}
+	svc := objectReference(hc.storeLister, "Service", ref.namespace, ref.name)
+	if svc == nil {
+		return
+	}
+	if alert != "" {
+		hc.controller.GetRecorder().Event(svc, api.EventTypeWarning, "EndpointsUnreachable", alert)
+	} else {
+		hc.controller.GetRecorder().Eventf(svc, api.EventTypeNormal, "EndpointsRecovered", "all %d endpoints are reachable", total)
+	}
+	ref.alert = alert
+}
+
 // OnUpdate regenerate the configuration file of the backend
 func (hc *HAProxyController) OnUpdate(cfg ingress.Configuration) error {
 	updatedConfig, err := newControllerConfig(&cfg, hc)
@@ -285,6 +936,8 @@ func (hc *HAProxyController) OnUpdate(cfg ingress.Configuration) error {
 		return err
 	}
 
+	dynconfig.BeginJournal()
+	firstUpdate := hc.currentConfig == nil
 	reloadRequired := !dynconfig.ConfigBackends(hc.currentConfig, updatedConfig)
 	hc.currentConfig = updatedConfig
 
@@ -302,12 +955,19 @@ func (hc *HAProxyController) OnUpdate(cfg ingress.Configuration) error {
 		return err
 	}
 
+	if reloadRequired && firstUpdate && hc.journalVerified && bytes.Equal(data, hc.lastConfigFile()) {
+		// the running HAProxy was verified by the journal and its config file didn't change
+		glog.Infoln("HAProxy config file didn't change since the last run, skipping reload")
+		reloadRequired = false
+	}
+
 	configFile, err := hc.rewriteConfigFiles(data)
 	if err != nil {
 		return err
 	}
 
 	if !reloadRequired {
+		dynconfig.CommitJournal()
 		glog.Infoln("HAProxy updated without needing to reload")
 		return nil
 	}
@@ -317,6 +977,9 @@ func (hc *HAProxyController) OnUpdate(cfg ingress.Configuration) error {
 	if len(out) > 0 {
 		glog.Infof("HAProxy[pid=%v] output:\n%v", reloadCmd.Process.Pid, string(out))
 	}
+	if err == nil {
+		dynconfig.ResetJournal()
+	}
 	return err
 }
 
@@ -358,6 +1021,22 @@ func (hc *HAProxyController) rewriteConfigFiles(data []byte) (string, error) {
 	return configFile, nil
 }
 
+// lastConfigFile returns the content of the last config file written
+func (hc *HAProxyController) lastConfigFile() []byte {
+	configFile := hc.configDir + "/" + hc.configFilePrefix + hc.configFileSuffix
+	if *hc.maxOldConfigFiles > 0 {
+		files, _ := filepath.Glob(hc.configDir + "/" + hc.configFilePrefix + "-*" + hc.configFileSuffix)
+		// timestamps of the file names are sortable
+		sort.Strings(files)
+		if len(files) == 0 {
+			return nil
+		}
+		configFile = files[len(files)-1]
+	}
+	data, _ := ioutil.ReadFile(configFile)
+	return data
+}
+
 func (hc *HAProxyController) removeOldConfigFiles() error {
 	files, err := ioutil.ReadDir(hc.configDir)
 	if err != nil {
@@ -385,6 +1064,65 @@ func (hc *HAProxyController) removeOldConfigFiles() error {
 	return nil
 }
 
+// haproxyVersion reads the version of HAProxy, which gates the keywords
+// used in the configuration: the --haproxy-version option, the version of
+// the first external instance, or the version of the HAProxy binary. The
+// zero value is returned if the version cannot be read
+func (hc *HAProxyController) haproxyVersion() hatypes.Version {
+	if *hc.version != "" {
+		version, err := hatypes.ParseVersion(*hc.version)
+		if err != nil {
+			glog.Fatalf("invalid --haproxy-version: %s", *hc.version)
+		}
+		glog.Infof("HAProxy version: %s", version)
+		return version
+	}
+	var out []byte
+	var err error
+	if *hc.masterSocket != "" {
+		var info string
+		info, err = utils.HAProxyCommand("", "show info")
+		out = []byte(info)
+	} else {
+		out, err = exec.Command("haproxy", "-v").CombinedOutput()
+	}
+	if err != nil {
+		glog.Warningf("error reading HAProxy version: %v", err)
+		return hatypes.Version{}
+	}
+	version, err := hatypes.ParseVersion(string(out))
+	if err != nil {
+		glog.Warningf("error reading HAProxy version: %v", err)
+	}
+	glog.Infof("HAProxy version: %s", version)
+	return version
+}
+
+// secretNamespaceList returns the namespaces whose secrets can be read from
+// ingress resources of another namespace, --allow-cross-namespace allows all
+func (hc *HAProxyController) secretNamespaceList() []string {
+	if hc.cfg.AllowCrossNamespace {
+		return []string{"*"}
+	}
+	return utils.Split(*hc.secretNamespaces, ",")
+}
+
+// localNodeName returns the name of the node where the controller is
+// running, used to find the local zone of zone-local-routing
+func (hc *HAProxyController) localNodeName() string {
+	podName := os.Getenv("POD_NAME")
+	podNamespace := os.Getenv("POD_NAMESPACE")
+	if podName == "" || podNamespace == "" {
+		return ""
+	}
+	pod, err := hc.cfg.Client.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		glog.Warningf("error reading the controller pod: %v", err)
+		return ""
+	}
+	return pod.Spec.NodeName
+}
+
 // checkValidity runs a HAProxy configuration validity check on a file
 func checkValidity(configFile string) error {
 	out, err := exec.Command("haproxy", "-c", "-f", configFile).CombinedOutput()
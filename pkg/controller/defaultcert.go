@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// defaultCertificate resolves the default certificate on every sync, so the
+// secret of --default-ssl-certificate is used as soon as it is created. A
+// self-signed certificate is used while the secret is missing. The
+// self-signed certificate is persisted in a secret of the controller's
+// namespace, so all the instances and restarts use the same certificate.
+type defaultCertificate struct {
+	client       kubernetes.Interface
+	cache        *cache
+	secretName   string
+	namespace    string
+	fallbackName string
+	createFake   func() (path, hash string)
+	fallback     ingtypes.File
+	lastError    string
+}
+
+func newDefaultCertificate(client kubernetes.Interface, cache *cache, secretName, namespace, fallbackName string, createFake func() (path, hash string)) *defaultCertificate {
+	return &defaultCertificate{
+		client:       client,
+		cache:        cache,
+		secretName:   secretName,
+		namespace:    namespace,
+		fallbackName: fallbackName,
+		createFake:   createFake,
+	}
+}
+
+func (d *defaultCertificate) file() ingtypes.File {
+	if d.secretName != "" {
+		tlsFile, err := d.cache.GetTLSSecretPath(d.secretName)
+		if err == nil {
+			if d.lastError != "" {
+				glog.Infof("default TLS certificate '%s' found, replacing the fallback certificate", d.secretName)
+				d.lastError = ""
+			}
+			return tlsFile
+		}
+		if msg := err.Error(); msg != d.lastError {
+			glog.Warningf("using fallback certificate due to an error reading default TLS certificate: %v", err)
+			d.lastError = msg
+		}
+	} else if d.lastError == "" {
+		glog.Info("default TLS certificate not configured, using fallback certificate")
+		d.lastError = "not configured"
+	}
+	if d.fallback.Filename == "" {
+		d.fallback = d.fallbackFile()
+	}
+	return d.fallback
+}
+
+// fallbackFile reads the self-signed certificate from its secret, creating
+// the secret if it doesn't exist. The certificate is only kept in the local
+// filesystem if the secret cannot be used.
+func (d *defaultCertificate) fallbackFile() ingtypes.File {
+	if d.namespace != "" && d.fallbackName != "" {
+		crt, err := d.persistedCert()
+		if err == nil {
+			return *crt
+		}
+		glog.Warningf("error reading fallback certificate from secret '%s/%s', using a non persisted one: %v", d.namespace, d.fallbackName, err)
+	}
+	path, hash := d.createFake()
+	return ingtypes.File{
+		Filename: path,
+		SHA1Hash: hash,
+	}
+}
+
+func (d *defaultCertificate) persistedCert() (*ingtypes.File, error) {
+	secrets := d.client.CoreV1().Secrets(d.namespace)
+	secret, err := secrets.Get(d.fallbackName, meta.GetOptions{})
+	if errors.IsNotFound(err) {
+		crt, key := ssl.GetFakeSSLCert()
+		secret, err = secrets.Create(&api.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: d.fallbackName, Namespace: d.namespace},
+			Type:       api.SecretTypeTLS,
+			Data: map[string][]byte{
+				api.TLSCertKey:       crt,
+				api.TLSPrivateKeyKey: key,
+			},
+		})
+		if errors.IsAlreadyExists(err) {
+			// created by another controller instance
+			secret, err = secrets.Get(d.fallbackName, meta.GetOptions{})
+		} else if err == nil {
+			glog.Infof("fallback certificate created on secret '%s/%s'", d.namespace, d.fallbackName)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	crt := secret.Data[api.TLSCertKey]
+	key := secret.Data[api.TLSPrivateKeyKey]
+	if len(crt) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("secret does not have keys 'tls.crt' and 'tls.key'")
+	}
+	sslCert, err := ssl.AddOrUpdateCertAndKey(d.namespace+"-"+d.fallbackName, crt, key, []byte{})
+	if err != nil {
+		return nil, err
+	}
+	return &ingtypes.File{
+		Filename: sslCert.PemFileName,
+		SHA1Hash: sslCert.PemSHA,
+	}, nil
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
@@ -94,13 +95,23 @@ func findEmptySlot(slots []types.HAProxyBackendSlot) int {
 	return -1
 }
 
+// sendCommand journals and sends runtime API commands to HAProxy
+func sendCommand(statsSocket, command string) error {
+	if currentJournal != nil {
+		for _, cmd := range strings.Split(strings.TrimSpace(command), "\n") {
+			currentJournal.append(cmd)
+		}
+	}
+	return utils.SendToSocket(statsSocket, command)
+}
+
 // remove Ingress Endpoint from a backend by disabling a specific server slot
 func removeEndpoint(statsSocket, backendName, backendServerName string) bool {
-	err1 := utils.SendToSocket(statsSocket,
+	err1 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s state maint\n", backendName, backendServerName))
-	err2 := utils.SendToSocket(statsSocket,
+	err2 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s addr 127.0.0.1 port 81\n", backendName, backendServerName))
-	err3 := utils.SendToSocket(statsSocket,
+	err3 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s weight 1\n", backendName, backendServerName))
 	if err1 != nil || err2 != nil || err3 != nil {
 		glog.Warningln("failed socket command srv remove")
@@ -119,23 +130,23 @@ func addEndpoint(statsSocket, backendName, backendServerName, address, port stri
 		state = "ready"
 	}
 
-	err1 := utils.SendToSocket(statsSocket,
+	err1 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s addr %s port %s\n", backendName, backendServerName, address, port))
-	err2 := utils.SendToSocket(statsSocket,
+	err2 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s state %s\n", backendName, backendServerName, state))
-	err3 := utils.SendToSocket(statsSocket,
+	err3 := sendCommand(statsSocket,
 		fmt.Sprintf("set server %s/%s weight %d\n", backendName, backendServerName, weight))
 	var err error
 	if err == nil && healthCheck.Port != "" {
-		err = utils.SendToSocket(statsSocket,
+		err = sendCommand(statsSocket,
 			fmt.Sprintf("set server %s/%s check-port %s\n", backendName, backendServerName, healthCheck.Port))
 	}
 	if err == nil && agentCheck.Addr != "" {
-		err = utils.SendToSocket(statsSocket,
+		err = sendCommand(statsSocket,
 			fmt.Sprintf("set server %s/%s agent-addr %s\n", backendName, backendServerName, agentCheck.Addr))
 	}
 	if err == nil && agentCheck.Send != "" {
-		err = utils.SendToSocket(statsSocket,
+		err = sendCommand(statsSocket,
 			fmt.Sprintf("set server %s/%s agent-send %s\n", backendName, backendServerName, agentCheck.Send))
 	}
 	if err1 != nil || err2 != nil || err3 != nil || err != nil {
@@ -153,7 +164,7 @@ func setEndpointWeight(statsSocket, backendName, backendServerName string, weigh
 	} else {
 		state = "ready"
 	}
-	err := utils.SendToSocket(statsSocket, fmt.Sprintf("set server %s/%s weight %d\nset server %s/%s state %s\n",
+	err := sendCommand(statsSocket, fmt.Sprintf("set server %s/%s weight %d\nset server %s/%s state %s\n",
 		backendName, backendServerName, weight, backendName, backendServerName, state))
 	if err != nil {
 		glog.Warningln("failed socket command srv weight")
@@ -0,0 +1,296 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// journal persists the runtime API commands applied to the running
+// HAProxy since its last reload, so the runtime state can be verified
+// and replayed after a controller crash. Updates are enclosed by the
+// pending and applied lines, a pending update means that the config
+// files and the running HAProxy might diverge.
+type journal struct {
+	mutex    sync.Mutex
+	filename string
+}
+
+// serverState is the state of a server after applying all the journaled commands
+type serverState struct {
+	backend string
+	server  string
+	fields  map[string]string
+	order   []string
+}
+
+const (
+	journalPending = "# pending"
+	journalApplied = "# applied"
+)
+
+var currentJournal *journal
+
+// EnableJournal starts to persist runtime API commands in filename
+func EnableJournal(filename string) {
+	currentJournal = &journal{filename: filename}
+}
+
+// BeginJournal should be called before changing the config files or
+// sending runtime API commands
+func BeginJournal() {
+	if currentJournal != nil {
+		currentJournal.append(journalPending)
+	}
+}
+
+// AppendJournal persists a runtime API command applied to HAProxy
+func AppendJournal(command string) {
+	if currentJournal != nil {
+		currentJournal.append(command)
+	}
+}
+
+// CommitJournal should be called after HAProxy was updated without a reload
+func CommitJournal() {
+	if currentJournal != nil {
+		currentJournal.append(journalApplied)
+	}
+}
+
+// Journal implements haproxy.Journal, used by the v0.8 controller
+type Journal struct{}
+
+// Begin ...
+func (Journal) Begin() { BeginJournal() }
+
+// Append ...
+func (Journal) Append(command string) { AppendJournal(command) }
+
+// Commit ...
+func (Journal) Commit() { CommitJournal() }
+
+// Reset ...
+func (Journal) Reset() { ResetJournal() }
+
+// ResetJournal clears the journal, should be called whenever HAProxy
+// is reloaded and its runtime state matches the config file again
+func ResetJournal() {
+	if currentJournal == nil {
+		return
+	}
+	currentJournal.mutex.Lock()
+	defer currentJournal.mutex.Unlock()
+	if err := os.Remove(currentJournal.filename); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("error removing dynamic update journal: %v", err)
+	}
+}
+
+// VerifyJournal compares the journaled commands with the current state of
+// HAProxy, replaying the commands of the servers that diverge. Returns true
+// if HAProxy is running and its state reflects the last config files written
+// and the journaled commands, so the first update doesn't need to reload
+// HAProxy if the config files don't change.
+func VerifyJournal(statsSocket string) bool {
+	if currentJournal == nil {
+		return false
+	}
+	states, pending, err := currentJournal.read()
+	if err != nil {
+		glog.Warningf("error reading dynamic update journal: %v", err)
+		return false
+	}
+	out, err := utils.HAProxyCommand(statsSocket, "show servers state")
+	if err != nil {
+		glog.Warningf("error verifying dynamic update journal: %v", err)
+		return false
+	}
+	if pending {
+		glog.Infof("dynamic update journal has an update which was not applied, HAProxy will be reloaded")
+		return false
+	}
+	running := parseServersState(out)
+	replayed := 0
+	for _, state := range states {
+		if state.matches(running[state.backend+"/"+state.server]) {
+			continue
+		}
+		for _, field := range state.order {
+			cmd := fmt.Sprintf("set server %s/%s %s %s", state.backend, state.server, field, state.fields[field])
+			out, err := utils.HAProxyCommand(statsSocket, cmd)
+			if err == nil && strings.TrimSpace(out) != "" {
+				// successful set server commands have an empty response
+				err = errors.New(strings.TrimSpace(out))
+			}
+			if err != nil {
+				glog.Warningf("error replaying dynamic update journal: %v", err)
+				return false
+			}
+		}
+		replayed++
+	}
+	glog.Infof("dynamic update journal verified: %d server(s) journaled, %d replayed", len(states), replayed)
+	return true
+}
+
+func (j *journal) append(command string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	f, err := os.OpenFile(j.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		glog.Warningf("error opening dynamic update journal: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.TrimSpace(command) + "\n"); err != nil {
+		glog.Warningf("error writing dynamic update journal: %v", err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		glog.Warningf("error syncing dynamic update journal: %v", err)
+	}
+}
+
+// read returns the journaled state of the servers, and if the last
+// update was not applied
+func (j *journal) read() ([]*serverState, bool, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	f, err := os.Open(j.filename)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	statesMap := map[string]*serverState{}
+	pending := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case journalPending:
+			pending = true
+			continue
+		case journalApplied:
+			pending = false
+			continue
+		}
+		// set server <backend>/<server> <field> <value...>
+		cmd := strings.Fields(scanner.Text())
+		if len(cmd) < 5 || cmd[0] != "set" || cmd[1] != "server" {
+			continue
+		}
+		name := strings.SplitN(cmd[2], "/", 2)
+		if len(name) != 2 {
+			continue
+		}
+		state, found := statesMap[cmd[2]]
+		if !found {
+			state = &serverState{backend: name[0], server: name[1], fields: map[string]string{}}
+			statesMap[cmd[2]] = state
+		}
+		field := cmd[3]
+		if _, found := state.fields[field]; !found {
+			state.order = append(state.order, field)
+		}
+		state.fields[field] = strings.Join(cmd[4:], " ")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	states := make([]*serverState, 0, len(statesMap))
+	for _, state := range statesMap {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].backend+"/"+states[i].server < states[j].backend+"/"+states[j].server
+	})
+	return states, pending, nil
+}
+
+// runningServer has the fields of `show servers state` used to verify the journal
+type runningServer struct {
+	addr       string
+	port       string
+	adminState int
+	weight     string
+}
+
+func parseServersState(out string) map[string]*runningServer {
+	servers := map[string]*runningServer{}
+	for _, line := range strings.Split(out, "\n") {
+		// be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight ... srv_port
+		f := strings.Fields(line)
+		if len(f) < 19 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		adminState, _ := strconv.Atoi(f[6])
+		servers[f[1]+"/"+f[3]] = &runningServer{
+			addr:       f[4],
+			port:       f[18],
+			adminState: adminState,
+			weight:     f[7],
+		}
+	}
+	return servers
+}
+
+const (
+	adminForcedMaint = 0x01
+	adminForcedDrain = 0x08
+)
+
+func (s *serverState) matches(running *runningServer) bool {
+	if running == nil {
+		return false
+	}
+	if addr, found := s.fields["addr"]; found {
+		// addr <ip> port <port>
+		a := strings.Fields(addr)
+		if a[0] != running.addr || (len(a) == 3 && a[2] != running.port) {
+			return false
+		}
+	}
+	if weight, found := s.fields["weight"]; found && weight != running.weight {
+		return false
+	}
+	if state, found := s.fields["state"]; found {
+		maint := running.adminState&adminForcedMaint != 0
+		drain := running.adminState&adminForcedDrain != 0
+		switch state {
+		case "maint":
+			return maint
+		case "drain":
+			return !maint && drain
+		case "ready":
+			return !maint && !drain
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJournalRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testCases := []struct {
+		content    string
+		expected   []*serverState
+		expPending bool
+	}{
+		// 0
+		{
+			content: "",
+		},
+		// 1
+		{
+			content: `
+show servers state
+set server d1_app_8080 state ready
+set server d1_app_8080/srv001
+set weight d1_app_8080/srv001 state ready
+`,
+		},
+		// 2
+		{
+			content: `
+set server d1_app_8080/srv002 addr 172.17.0.12 port 8080
+set server d1_app_8080/srv001 state maint
+set server d1_app_8080/srv001 addr 172.17.0.11 port 8080
+set server d1_app_8080/srv001 state ready
+set server d1_app_8080/srv001 weight 2
+`,
+			expected: []*serverState{
+				{
+					backend: "d1_app_8080",
+					server:  "srv001",
+					fields:  map[string]string{"state": "ready", "addr": "172.17.0.11 port 8080", "weight": "2"},
+					order:   []string{"state", "addr", "weight"},
+				},
+				{
+					backend: "d1_app_8080",
+					server:  "srv002",
+					fields:  map[string]string{"addr": "172.17.0.12 port 8080"},
+					order:   []string{"addr"},
+				},
+			},
+		},
+		// 3
+		{
+			content: `
+# pending
+set server d1_app_8080/srv001 weight 2
+# applied
+# pending
+`,
+			expected: []*serverState{
+				{
+					backend: "d1_app_8080",
+					server:  "srv001",
+					fields:  map[string]string{"weight": "2"},
+					order:   []string{"weight"},
+				},
+			},
+			expPending: true,
+		},
+		// 4
+		{
+			content: `
+# pending
+set server d1_app_8080/srv001 weight 2
+# applied
+`,
+			expected: []*serverState{
+				{
+					backend: "d1_app_8080",
+					server:  "srv001",
+					fields:  map[string]string{"weight": "2"},
+					order:   []string{"weight"},
+				},
+			},
+		},
+	}
+	for i, test := range testCases {
+		j := &journal{filename: filepath.Join(dir, "journal")}
+		if err := ioutil.WriteFile(j.filename, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		states, pending, err := j.read()
+		if err != nil {
+			t.Errorf("read on %d returned error: %v", i, err)
+		}
+		if len(states) == 0 {
+			states = nil
+		}
+		if !reflect.DeepEqual(states, test.expected) || pending != test.expPending {
+			t.Errorf("states on %d differs, expected: %+v %v, actual: %+v %v", i, test.expected, test.expPending, states, pending)
+		}
+	}
+	j := &journal{filename: filepath.Join(dir, "missing")}
+	if states, pending, err := j.read(); states != nil || pending || err != nil {
+		t.Errorf("read of a missing journal differs, expected: [] false <nil>, actual: %v %v %v", states, pending, err)
+	}
+}
+
+func TestParseServersState(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected map[string]*runningServer
+	}{
+		// 0
+		{
+			out:      "",
+			expected: map[string]*runningServer{},
+		},
+		// 1
+		{
+			out: `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port
+3 d1_app_8080 1 srv001 172.17.0.11 2 0 1 1 120 1 0 2 0 0 0 0 - 8080
+3 d1_app_8080 2 srv002 127.0.0.1 0 1 1 1 120 1 0 0 0 0 0 0 - 1023
+3 d1_app_8080 3 srv003 172.17.0.13 2 8 0 1
+`,
+			expected: map[string]*runningServer{
+				"d1_app_8080/srv001": {addr: "172.17.0.11", port: "8080", adminState: 0, weight: "1"},
+				"d1_app_8080/srv002": {addr: "127.0.0.1", port: "1023", adminState: 1, weight: "1"},
+			},
+		},
+	}
+	for i, test := range testCases {
+		servers := parseServersState(test.out)
+		if !reflect.DeepEqual(servers, test.expected) {
+			t.Errorf("servers on %d differs, expected: %+v, actual: %+v", i, test.expected, servers)
+		}
+	}
+}
+
+func TestServerStateMatches(t *testing.T) {
+	running := &runningServer{addr: "172.17.0.11", port: "8080", weight: "1"}
+	testCases := []struct {
+		fields   map[string]string
+		running  *runningServer
+		expected bool
+	}{
+		// 0
+		{
+			fields:   map[string]string{"state": "ready"},
+			expected: false,
+		},
+		// 1
+		{
+			fields:   map[string]string{"addr": "172.17.0.11 port 8080", "weight": "1", "state": "ready"},
+			running:  running,
+			expected: true,
+		},
+		// 2
+		{
+			fields:   map[string]string{"addr": "172.17.0.11"},
+			running:  running,
+			expected: true,
+		},
+		// 3
+		{
+			fields:   map[string]string{"addr": "172.17.0.12 port 8080"},
+			running:  running,
+			expected: false,
+		},
+		// 4
+		{
+			fields:   map[string]string{"addr": "172.17.0.11 port 8081"},
+			running:  running,
+			expected: false,
+		},
+		// 5
+		{
+			fields:   map[string]string{"weight": "2"},
+			running:  running,
+			expected: false,
+		},
+		// 6
+		{
+			fields:   map[string]string{"state": "maint"},
+			running:  &runningServer{adminState: adminForcedMaint},
+			expected: true,
+		},
+		// 7
+		{
+			fields:   map[string]string{"state": "maint"},
+			running:  running,
+			expected: false,
+		},
+		// 8
+		{
+			fields:   map[string]string{"state": "drain"},
+			running:  &runningServer{adminState: adminForcedDrain},
+			expected: true,
+		},
+		// 9
+		{
+			fields:   map[string]string{"state": "drain"},
+			running:  &runningServer{adminState: adminForcedMaint | adminForcedDrain},
+			expected: false,
+		},
+		// 10
+		{
+			fields:   map[string]string{"state": "ready"},
+			running:  &runningServer{adminState: adminForcedDrain},
+			expected: false,
+		},
+	}
+	for i, test := range testCases {
+		state := &serverState{backend: "d1_app_8080", server: "srv001", fields: test.fields}
+		if matches := state.matches(test.running); matches != test.expected {
+			t.Errorf("matches on %d differs, expected: %v, actual: %v", i, test.expected, matches)
+		}
+	}
+}
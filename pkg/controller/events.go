@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/jsonlog"
+)
+
+var eventKinds = map[string]string{
+	"ingress": "Ingress",
+	"service": "Service",
+}
+
+// converterEvents emits the warnings and errors of the converters as
+// events of the ingress or service they refer to. Messages already
+// emitted by the former sync aren't emitted again.
+type converterEvents struct {
+	mutex    sync.Mutex
+	recorder func() record.EventRecorder
+	lister   *ingress.StoreLister
+	last     map[string]bool
+	current  map[string]bool
+}
+
+func newConverterEvents(recorder func() record.EventRecorder, lister *ingress.StoreLister) *converterEvents {
+	return &converterEvents{
+		recorder: recorder,
+		lister:   lister,
+		last:     map[string]bool{},
+		current:  map[string]bool{},
+	}
+}
+
+// message implements messageListener
+func (e *converterEvents) message(level, msg string) {
+	obj := jsonlog.FindObject(msg)
+	if obj == nil || eventKinds[obj.Kind] == "" {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	key := obj.Kind + "/" + obj.Namespace + "/" + obj.Name + "/" + msg
+	e.current[key] = true
+	if e.last[key] {
+		return
+	}
+	reason := "ConfigWarning"
+	if level == "error" {
+		reason = "ConfigError"
+	}
+	ref := objectReference(e.lister, eventKinds[obj.Kind], obj.Namespace, obj.Name)
+	if ref == nil {
+		return
+	}
+	e.recorder().Event(ref, api.EventTypeWarning, reason, msg)
+}
+
+// objectReference builds the reference of an ingress or a service read from
+// the listers, so the event has the uid and the api version of the object and
+// is listed by `kubectl describe`. Returns nil if the object doesn't exist.
+func objectReference(lister *ingress.StoreLister, kind, namespace, name string) *api.ObjectReference {
+	key := namespace + "/" + name
+	switch kind {
+	case "Ingress":
+		obj, exists, _ := lister.Ingress.GetByKey(key)
+		if ing, ok := obj.(*extensions.Ingress); exists && ok {
+			return &api.ObjectReference{
+				Kind:            kind,
+				APIVersion:      extensions.SchemeGroupVersion.String(),
+				Namespace:       ing.Namespace,
+				Name:            ing.Name,
+				UID:             ing.UID,
+				ResourceVersion: ing.ResourceVersion,
+			}
+		}
+	case "Service":
+		obj, exists, _ := lister.Service.GetByKey(key)
+		if svc, ok := obj.(*api.Service); exists && ok {
+			return &api.ObjectReference{
+				Kind:            kind,
+				APIVersion:      api.SchemeGroupVersion.String(),
+				Namespace:       svc.Namespace,
+				Name:            svc.Name,
+				UID:             svc.UID,
+				ResourceVersion: svc.ResourceVersion,
+			}
+		}
+	}
+	return nil
+}
+
+// commit finishes a sync, messages emitted by this sync
+// won't be emitted again by the next one
+func (e *converterEvents) commit() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.last = e.current
+	e.current = map[string]bool{}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8scache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/store"
+)
+
+func TestObjectReference(t *testing.T) {
+	ingStore := k8scache.NewStore(k8scache.MetaNamespaceKeyFunc)
+	ingStore.Add(&extensions.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "app", UID: "uid-ing", ResourceVersion: "10",
+	}})
+	svcStore := k8scache.NewStore(k8scache.MetaNamespaceKeyFunc)
+	svcStore.Add(&api.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "app", UID: "uid-svc", ResourceVersion: "20",
+	}})
+	lister := &ingress.StoreLister{
+		Ingress: store.IngressLister{Store: ingStore},
+		Service: store.ServiceLister{Store: svcStore},
+	}
+	testCases := []struct {
+		kind     string
+		name     string
+		expected *api.ObjectReference
+	}{
+		// 0
+		{
+			kind: "Ingress",
+			name: "app",
+			expected: &api.ObjectReference{
+				Kind: "Ingress", APIVersion: "extensions/v1beta1",
+				Namespace: "default", Name: "app", UID: "uid-ing", ResourceVersion: "10",
+			},
+		},
+		// 1
+		{
+			kind: "Service",
+			name: "app",
+			expected: &api.ObjectReference{
+				Kind: "Service", APIVersion: "v1",
+				Namespace: "default", Name: "app", UID: "uid-svc", ResourceVersion: "20",
+			},
+		},
+		// 2
+		{
+			kind: "Service",
+			name: "other",
+		},
+		// 3
+		{
+			kind: "Pod",
+			name: "app",
+		},
+	}
+	for i, test := range testCases {
+		actual := objectReference(lister, test.kind, "default", test.name)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("object reference on %d differs, expected: %+v, actual: %+v", i, test.expected, actual)
+		}
+	}
+}
+
+func TestConverterEvents(t *testing.T) {
+	ingStore := k8scache.NewStore(k8scache.MetaNamespaceKeyFunc)
+	ingStore.Add(&extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "echo1"}})
+	lister := &ingress.StoreLister{
+		Ingress: store.IngressLister{Store: ingStore},
+		Service: store.ServiceLister{Store: k8scache.NewStore(k8scache.MetaNamespaceKeyFunc)},
+	}
+	recorder := record.NewFakeRecorder(10)
+	events := newConverterEvents(func() record.EventRecorder { return recorder }, lister)
+	conflict := "skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo1' due to conflict with another ingress: [balance-algorithm]"
+	sync := func(msgs ...string) {
+		for _, msg := range msgs {
+			events.message("warning", msg)
+		}
+		events.commit()
+	}
+	sync(conflict, "error reading service 'default/echo2'", "unrelated message")
+	// messages of the former sync aren't emitted again
+	sync(conflict)
+	sync()
+	sync(conflict)
+	close(recorder.Events)
+	var actual []string
+	for event := range recorder.Events {
+		actual = append(actual, event)
+	}
+	expected := []string{
+		"Warning ConfigWarning " + conflict,
+		"Warning ConfigWarning " + conflict,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("events differ, expected: %v, actual: %v", expected, actual)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	k8scache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// failoverCluster watches endpoints of a secondary cluster, used
+// as backup or low weight servers of the local backends
+type failoverCluster struct {
+	store      k8scache.Store
+	controller k8scache.Controller
+}
+
+func newFailoverCluster(kubeconfig string, resync time.Duration, notify func()) (*failoverCluster, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	handler := k8scache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			notify()
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify()
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old.(*api.Endpoints).Subsets, cur.(*api.Endpoints).Subsets) {
+				notify()
+			}
+		},
+	}
+	f := &failoverCluster{}
+	f.store, f.controller = k8scache.NewInformer(
+		k8scache.NewListWatchFromClient(client.CoreV1().RESTClient(), "endpoints", api.NamespaceAll, fields.Everything()),
+		&api.Endpoints{}, resync, handler)
+	return f, nil
+}
+
+func (f *failoverCluster) run(stopCh <-chan struct{}) {
+	go f.controller.Run(stopCh)
+}
+
+func (f *failoverCluster) getEndpoints(serviceName string) (*api.Endpoints, error) {
+	obj, exists, err := f.store.GetByKey(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("could not find endpoints for service '%s' on the failover cluster", serviceName)
+	}
+	return obj.(*api.Endpoints), nil
+}
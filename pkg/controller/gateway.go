@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/gateway"
+)
+
+const (
+	gatewayGroupVersion      = "gateway.networking.k8s.io/v1"
+	gatewayAlphaGroupVersion = "gateway.networking.k8s.io/v1alpha2"
+	gatewayBetaGroupVersion  = "gateway.networking.k8s.io/v1beta1"
+	gatewayControllerName    = "haproxy-ingress.github.io/controller"
+)
+
+// gatewayResources reads the Gateway API resources whose gateways use
+// the configured gateway class
+type gatewayResources struct {
+	mutex      sync.Mutex
+	client     rest.Interface
+	namespaces []string
+	class      string
+	notify     func()
+	resources  *gateway.Resources
+	lastStatus map[string]string
+}
+
+func newGatewayResources(client rest.Interface, namespaces []string, class string, notify func()) *gatewayResources {
+	return &gatewayResources{
+		client:     client,
+		namespaces: namespaces,
+		class:      class,
+		notify:     notify,
+		resources:  &gateway.Resources{},
+		lastStatus: map[string]string{},
+	}
+}
+
+func (g *gatewayResources) run(stopCh <-chan struct{}) {
+	g.read()
+	go wait.Until(func() {
+		if g.read() {
+			g.notify()
+		}
+	}, configResourcePollInterval, stopCh)
+}
+
+// read updates all the resources from the apiserver, returns true if any has changed
+func (g *gatewayResources) read() bool {
+	var gateways []*gateway.Gateway
+	var httpRoutes []*gateway.HTTPRoute
+	var tlsRoutes []*gateway.TLSRoute
+	var tcpRoutes []*gateway.TCPRoute
+	var grants []*gateway.ReferenceGrant
+	if err := g.list(gatewayGroupVersion, "gateways", &gateways); err != nil {
+		return false
+	}
+	if err := g.list(gatewayGroupVersion, "httproutes", &httpRoutes); err != nil {
+		return false
+	}
+	// TLSRoute and TCPRoute are experimental and optional, ignore if not installed
+	g.list(gatewayAlphaGroupVersion, "tlsroutes", &tlsRoutes)
+	g.list(gatewayAlphaGroupVersion, "tcproutes", &tcpRoutes)
+	// without ReferenceGrants, cross namespace references aren't allowed
+	g.list(gatewayBetaGroupVersion, "referencegrants", &grants)
+	resources := &gateway.Resources{
+		HTTPRoutes:      httpRoutes,
+		TLSRoutes:       tlsRoutes,
+		TCPRoutes:       tcpRoutes,
+		ReferenceGrants: grants,
+	}
+	for _, gw := range gateways {
+		if gw.Spec.GatewayClassName == g.class {
+			resources.Gateways = append(resources.Gateways, gw)
+		}
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if reflect.DeepEqual(resources, g.resources) {
+		return false
+	}
+	g.resources = resources
+	return true
+}
+
+func (g *gatewayResources) list(groupVersion, resource string, items interface{}) error {
+	if err := listResources(g.client, groupVersion, resource, g.namespaces, items); err != nil {
+		glog.Warningf("error listing %s: %v", resource, err)
+		return err
+	}
+	return nil
+}
+
+func (g *gatewayResources) getResources() *gateway.Resources {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.resources
+}
+
+// updateStatus writes the Accepted and ResolvedRefs conditions of the routes
+// attached to the gateways of the configured class. Route statuses are only
+// written if changed, and the parent statuses of other controllers are kept.
+func (g *gatewayResources) updateStatus(statuses []*gateway.RouteStatus) {
+	parents := map[string][]interface{}{}
+	current := map[string]string{}
+	var routes []string
+	for _, st := range statuses {
+		name := st.Kind + "/" + st.Route.Namespace + "/" + st.Route.Name
+		if _, found := parents[name]; !found {
+			routes = append(routes, name)
+		}
+		// lastTransitionTime is always updated, compare the conditions instead
+		current[name] += fmt.Sprintf("%+v;", *st)
+		parents[name] = append(parents[name], map[string]interface{}{
+			"parentRef": map[string]string{
+				"group":     "gateway.networking.k8s.io",
+				"kind":      "Gateway",
+				"namespace": st.Gateway.Namespace,
+				"name":      st.Gateway.Name,
+			},
+			"controllerName": gatewayControllerName,
+			"conditions": []map[string]interface{}{
+				gatewayCondition("Accepted", st.Accepted, st.Route.Generation),
+				gatewayCondition("ResolvedRefs", st.ResolvedRefs, st.Route.Generation),
+			},
+		})
+	}
+	for _, name := range routes {
+		route := strings.SplitN(name, "/", 3)
+		kind, namespace, routeName := route[0], route[1], route[2]
+		if g.lastStatus[name] == current[name] {
+			continue
+		}
+		if err := g.writeStatus(kind, namespace, routeName, parents[name]); err != nil {
+			glog.Warningf("error updating status of %s '%s/%s': %v", strings.ToLower(kind), namespace, routeName, err)
+			continue
+		}
+		g.lastStatus[name] = current[name]
+	}
+}
+
+func gatewayCondition(condType string, cond gateway.Condition, generation int64) map[string]interface{} {
+	status := "False"
+	if cond.Status {
+		status = "True"
+	}
+	return map[string]interface{}{
+		"type":               condType,
+		"status":             status,
+		"reason":             cond.Reason,
+		"message":            cond.Message,
+		"observedGeneration": generation,
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func (g *gatewayResources) writeStatus(kind, namespace, name string, parents []interface{}) error {
+	groupVersion := gatewayAlphaGroupVersion
+	if kind == "HTTPRoute" {
+		groupVersion = gatewayGroupVersion
+	}
+	path := fmt.Sprintf("/apis/%s/namespaces/%s/%ss/%s", groupVersion, namespace, strings.ToLower(kind), name)
+	raw, err := g.client.Get().AbsPath(path).DoRaw()
+	if err != nil {
+		return err
+	}
+	var current struct {
+		Status struct {
+			Parents []map[string]interface{} `json:"parents"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return err
+	}
+	for _, parent := range current.Status.Parents {
+		if parent["controllerName"] != gatewayControllerName {
+			parents = append(parents, parent)
+		}
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"parents": parents},
+	})
+	_, err = g.client.Patch(k8stypes.MergePatchType).AbsPath(path + "/status").Body(patch).DoRaw()
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	configResourceGroup        = "haproxy-ingress.github.io"
+	configResourceGroupVersion = configResourceGroup + "/v1alpha1"
+	configResourcePollInterval = 10 * time.Second
+	globalConfigResource       = "haproxyglobalconfigs"
+)
+
+// globalConfigCRD reads a cluster scoped HAProxyGlobalConfig resource, whose
+// typed spec supersedes the keys of the global ConfigMap
+type globalConfigCRD struct {
+	mutex   sync.Mutex
+	client  rest.Interface
+	name    string
+	notify  func()
+	version string
+	config  map[string]string
+	err     error
+}
+
+type globalConfigResourceData struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec map[string]json.RawMessage `json:"spec"`
+}
+
+func newGlobalConfigCRD(client rest.Interface, name string, notify func()) *globalConfigCRD {
+	return &globalConfigCRD{
+		client: client,
+		name:   name,
+		notify: notify,
+	}
+}
+
+func (g *globalConfigCRD) run(stopCh <-chan struct{}) {
+	// read synchronously the first time, so the first sync already has the config
+	g.read()
+	go wait.Until(func() {
+		if g.read() {
+			g.notify()
+		}
+	}, configResourcePollInterval, stopCh)
+}
+
+// read updates the config from the apiserver, returns true if it has changed
+func (g *globalConfigCRD) read() bool {
+	path := fmt.Sprintf("/apis/%s/%s/%s", configResourceGroupVersion, globalConfigResource, g.name)
+	raw, err := g.client.Get().AbsPath(path).DoRaw()
+	var version string
+	var config map[string]string
+	if err == nil {
+		version, config, err = parseGlobalConfig(raw)
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if err != nil {
+		changed := g.err == nil || g.err.Error() != err.Error()
+		if changed {
+			glog.Warningf("error reading global config resource '%s': %v", g.name, err)
+		}
+		g.err = err
+		return changed
+	}
+	changed := g.err != nil || version != g.version || !reflect.DeepEqual(config, g.config)
+	g.version = version
+	g.config = config
+	g.err = nil
+	return changed
+}
+
+func (g *globalConfigCRD) getConfig() (map[string]string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.err != nil {
+		return nil, g.err
+	}
+	return g.config, nil
+}
+
+// parseGlobalConfig converts the typed spec of the resource to the
+// same string based representation used by the global ConfigMap
+func parseGlobalConfig(raw []byte) (string, map[string]string, error) {
+	data := globalConfigResourceData{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return "", nil, err
+	}
+	config, err := parseResourceSpec(data.Spec)
+	if err != nil {
+		return "", nil, err
+	}
+	return data.Metadata.ResourceVersion, config, nil
+}
+
+// parseResourceSpec converts the typed spec of a config resource
+// to the string based representation used by annotations and ConfigMaps
+func parseResourceSpec(spec map[string]json.RawMessage) (map[string]string, error) {
+	config := make(map[string]string, len(spec))
+	for key, value := range spec {
+		var out interface{}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&out); err != nil {
+			return nil, err
+		}
+		switch v := out.(type) {
+		case string:
+			config[key] = v
+		case bool, json.Number:
+			config[key] = fmt.Sprintf("%v", v)
+		default:
+			return nil, fmt.Errorf("unsupported value type of spec.%s", key)
+		}
+	}
+	return config, nil
+}
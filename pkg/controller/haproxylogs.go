@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+const haproxyLogSocket = "/var/run/haproxy-log.sock"
+
+// haproxyLogs is a syslog listener which receives the logs of HAProxy
+// and writes them to the standard output of the controller
+type haproxyLogs struct {
+	socket string
+	out    io.Writer
+}
+
+func newHAProxyLogs(socket string, out io.Writer) *haproxyLogs {
+	return &haproxyLogs{
+		socket: socket,
+		out:    out,
+	}
+}
+
+func (l *haproxyLogs) run(stopCh <-chan struct{}) {
+	// a socket file left by a previous controller process
+	// would prevent the listener from binding
+	os.Remove(l.socket)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: l.socket, Net: "unixgram"})
+	if err != nil {
+		glog.Errorf("error listening to haproxy logs on %s: %v", l.socket, err)
+		return
+	}
+	if err := os.Chmod(l.socket, 0666); err != nil {
+		glog.Warningf("error changing permission of %s: %v", l.socket, err)
+	}
+	go func() {
+		<-stopCh
+		conn.Close()
+	}()
+	go l.read(conn)
+}
+
+func (l *haproxyLogs) read(conn *net.UnixConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			glog.V(2).Infof("stopped reading haproxy logs: %v", err)
+			return
+		}
+		msg := syslogMessage(buf[:n])
+		if len(msg) > 0 {
+			l.out.Write(append(msg, '\n'))
+		}
+	}
+}
+
+// syslogMessage removes the priority of a syslog message, which is
+// meaningless on a log file, and its trailing line breaks
+func syslogMessage(msg []byte) []byte {
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+	if len(msg) > 0 && msg[0] == '<' {
+		if i := bytes.IndexByte(msg, '>'); i > 0 {
+			msg = msg[i+1:]
+		}
+	}
+	return msg
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+var (
+	backendStatLabels = []string{"backend", "namespace", "service", "ingress"}
+	serverStatLabels  = []string{"backend", "namespace", "service", "ingress", "server", "pod"}
+	responseCodes     = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+)
+
+// statRef has the Kubernetes objects related with a HAProxy backend or server
+type statRef struct {
+	namespace string
+	service   string
+	ingress   string
+	pod       string
+}
+
+// proxyStat is a backend or a server read from `show stat`
+type proxyStat struct {
+	backend   string
+	server    string
+	addr      string
+	up        bool
+	sessions  float64
+	total     float64
+	queue     float64
+	responses []float64
+}
+
+type statDescs struct {
+	sessions  *prometheus.Desc
+	total     *prometheus.Desc
+	queue     *prometheus.Desc
+	responses *prometheus.Desc
+	up        *prometheus.Desc
+}
+
+func newStatDescs(kind string, labels []string) *statDescs {
+	name := func(metric string) string {
+		return prometheus.BuildFQName("ingress_controller", "haproxy_"+kind, metric)
+	}
+	return &statDescs{
+		sessions:  prometheus.NewDesc(name("current_sessions"), "Current number of sessions of the HAProxy "+kind, labels, nil),
+		total:     prometheus.NewDesc(name("sessions_total"), "Cumulative number of sessions of the HAProxy "+kind, labels, nil),
+		queue:     prometheus.NewDesc(name("current_queue"), "Current number of queued requests of the HAProxy "+kind, labels, nil),
+		responses: prometheus.NewDesc(name("http_responses_total"), "Cumulative number of HTTP responses of the HAProxy "+kind+" by code", append(labels, "code"), nil),
+		up:        prometheus.NewDesc(name("up"), "Health state of the HAProxy "+kind+", 1 is up", labels, nil),
+	}
+}
+
+func (d *statDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.sessions
+	ch <- d.total
+	ch <- d.queue
+	ch <- d.responses
+	ch <- d.up
+}
+
+// haproxyStats periodically reads the backends and servers from the HAProxy
+// stats socket, and exports their metrics labeled with the namespace, service,
+// ingress resources and pod they were built from
+type haproxyStats struct {
+	mutex    sync.Mutex
+	socket   string
+	interval time.Duration
+	refs     func(backend, addr string) statRef
+	stats    []*proxyStat
+	backend  *statDescs
+	server   *statDescs
+}
+
+func newHAProxyStats(socket string, interval time.Duration, refs func(backend, addr string) statRef) *haproxyStats {
+	return &haproxyStats{
+		socket:   socket,
+		interval: interval,
+		refs:     refs,
+		backend:  newStatDescs("backend", backendStatLabels),
+		server:   newStatDescs("server", serverStatLabels),
+	}
+}
+
+func (h *haproxyStats) run(stopCh <-chan struct{}) {
+	go wait.Until(h.collect, h.interval, stopCh)
+}
+
+func (h *haproxyStats) collect() {
+	out, err := utils.HAProxyCommand(h.socket, "show stat -1 6 -1")
+	if err != nil {
+		glog.V(2).Infof("error reading show stat from haproxy: %v", err)
+		return
+	}
+	stats := parseProxyStat(out)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.stats = stats
+}
+
+// Describe implements prometheus.Collector
+func (h *haproxyStats) Describe(ch chan<- *prometheus.Desc) {
+	h.backend.describe(ch)
+	h.server.describe(ch)
+}
+
+// Collect implements prometheus.Collector, exporting the last reading of the stats socket
+func (h *haproxyStats) Collect(ch chan<- prometheus.Metric) {
+	h.mutex.Lock()
+	stats := h.stats
+	h.mutex.Unlock()
+	for _, stat := range stats {
+		ref := h.refs(stat.backend, stat.addr)
+		descs := h.backend
+		labels := []string{stat.backend, ref.namespace, ref.service, ref.ingress}
+		if stat.server != "" {
+			descs = h.server
+			labels = append(labels, stat.server, ref.pod)
+		}
+		up := 0.0
+		if stat.up {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(descs.sessions, prometheus.GaugeValue, stat.sessions, labels...)
+		ch <- prometheus.MustNewConstMetric(descs.total, prometheus.CounterValue, stat.total, labels...)
+		ch <- prometheus.MustNewConstMetric(descs.queue, prometheus.GaugeValue, stat.queue, labels...)
+		ch <- prometheus.MustNewConstMetric(descs.up, prometheus.GaugeValue, up, labels...)
+		for i, code := range responseCodes {
+			ch <- prometheus.MustNewConstMetric(descs.responses, prometheus.CounterValue, stat.responses[i], append(labels, code)...)
+		}
+	}
+}
+
+// parseProxyStat reads the backends and servers of the `show stat` output.
+// Fields are found by the header, its layout changes between HAProxy versions.
+func parseProxyStat(out string) []*proxyStat {
+	lines := strings.Split(out, "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "# ") {
+		return nil
+	}
+	fields := map[string]int{}
+	for i, name := range strings.Split(strings.TrimPrefix(lines[0], "# "), ",") {
+		fields[name] = i
+	}
+	value := func(row []string, name string) string {
+		if i, found := fields[name]; found && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	number := func(row []string, name string) float64 {
+		n, _ := strconv.ParseFloat(value(row, name), 64)
+		return n
+	}
+	var stats []*proxyStat
+	for _, line := range lines[1:] {
+		row := strings.Split(line, ",")
+		if len(row) < 2 || row[1] == "FRONTEND" {
+			continue
+		}
+		stat := &proxyStat{
+			backend:   row[0],
+			server:    row[1],
+			addr:      value(row, "addr"),
+			sessions:  number(row, "scur"),
+			total:     number(row, "stot"),
+			queue:     number(row, "qcur"),
+			responses: make([]float64, len(responseCodes)),
+		}
+		if stat.server == "BACKEND" {
+			stat.server = ""
+		}
+		status := value(row, "status")
+		stat.up = strings.HasPrefix(status, "UP") || status == "no check"
+		for i, code := range responseCodes {
+			stat.responses[i] = number(row, "hrsp_"+code)
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyStat(t *testing.T) {
+	responses := func(r ...float64) []float64 {
+		return r
+	}
+	testCases := []struct {
+		out      string
+		expected []*proxyStat
+	}{
+		// 0
+		{
+			out: "",
+		},
+		// 1
+		{
+			out: "d1_app_8080,srv001,0,0,1,1\n",
+		},
+		// 2
+		{
+			out: `# pxname,svname,qcur,scur,stot,status,addr,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other
+_front_http,FRONTEND,,5,100,OPEN,,0,90,5,4,1,0
+d1_app_8080,srv001,1,2,30,UP,172.17.0.11:8080,0,25,3,2,0,0
+d1_app_8080,srv002,0,0,10,DOWN,172.17.0.12:8080,0,5,1,2,2,0
+d1_app_8080,srv003,0,1,5,no check,172.17.0.13:8080,0,5,0,0,0,0
+d1_app_8080,BACKEND,1,3,45,UP,,0,35,4,4,2,0
+`,
+			expected: []*proxyStat{
+				{backend: "d1_app_8080", server: "srv001", addr: "172.17.0.11:8080", up: true, sessions: 2, total: 30, queue: 1, responses: responses(0, 25, 3, 2, 0, 0)},
+				{backend: "d1_app_8080", server: "srv002", addr: "172.17.0.12:8080", sessions: 0, total: 10, queue: 0, responses: responses(0, 5, 1, 2, 2, 0)},
+				{backend: "d1_app_8080", server: "srv003", addr: "172.17.0.13:8080", up: true, sessions: 1, total: 5, queue: 0, responses: responses(0, 5, 0, 0, 0, 0)},
+				{backend: "d1_app_8080", server: "", up: true, sessions: 3, total: 45, queue: 1, responses: responses(0, 35, 4, 4, 2, 0)},
+			},
+		},
+		// 3
+		{
+			out: `# pxname,svname,scur,status
+d1_app_8080,srv001,2,MAINT
+`,
+			expected: []*proxyStat{
+				{backend: "d1_app_8080", server: "srv001", sessions: 2, responses: responses(0, 0, 0, 0, 0, 0)},
+			},
+		},
+	}
+	for i, test := range testCases {
+		stats := parseProxyStat(test.out)
+		if !reflect.DeepEqual(stats, test.expected) {
+			t.Errorf("stats on %d differs, expected: %+v, actual: %+v", i, test.expected, stats)
+		}
+	}
+}
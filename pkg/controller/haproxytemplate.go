@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha1"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// templateWatcher polls a HAProxy template file which overrides the
+// built-in one, and directories of partial templates which override some
+// of its sections, eg mounted from ConfigMaps, and asks for a sync when
+// their content changes. Errors parsing the template or updating HAProxy
+// are logged and emitted as events of the controller pod.
+type templateWatcher struct {
+	mutex    sync.Mutex
+	file     string
+	partials []string
+	interval time.Duration
+	hash     [sha1.Size]byte
+	changed  bool
+	lastErr  string
+	notify   func()
+	recorder func() record.EventRecorder
+	pod      *api.ObjectReference
+}
+
+func newTemplateWatcher(file string, partials []string, interval time.Duration, notify func(), recorder func() record.EventRecorder, podNamespace, podName string) *templateWatcher {
+	w := &templateWatcher{
+		file:     file,
+		partials: partials,
+		interval: interval,
+		notify:   notify,
+		recorder: recorder,
+	}
+	if podNamespace != "" && podName != "" {
+		w.pod = &api.ObjectReference{
+			Kind:      "Pod",
+			Namespace: podNamespace,
+			Name:      podName,
+		}
+	}
+	// the template is parsed on startup
+	if hash, err := w.sum(); err == nil {
+		w.hash = hash
+	}
+	return w
+}
+
+// sum hashes the name and the content of the template files, so adding
+// or removing a partial template is also a change
+func (w *templateWatcher) sum() (hash [sha1.Size]byte, err error) {
+	var files []string
+	if w.file != "" {
+		files = append(files, w.file)
+	}
+	for _, dir := range w.partials {
+		partials, err := filepath.Glob(dir + "/*.tmpl")
+		if err != nil {
+			return hash, err
+		}
+		files = append(files, partials...)
+	}
+	h := sha1.New()
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return hash, err
+		}
+		h.Write([]byte(file))
+		h.Write(content)
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, nil
+}
+
+func (w *templateWatcher) desc() string {
+	var desc []string
+	for _, name := range append([]string{w.file}, w.partials...) {
+		if name != "" {
+			desc = append(desc, "'"+name+"'")
+		}
+	}
+	return strings.Join(desc, " and ")
+}
+
+func (w *templateWatcher) run(stopCh <-chan struct{}) {
+	go wait.Until(w.check, w.interval, stopCh)
+}
+
+func (w *templateWatcher) check() {
+	hash, err := w.sum()
+	if err != nil {
+		glog.Warningf("error reading haproxy template %s: %v", w.desc(), err)
+		return
+	}
+	w.mutex.Lock()
+	changed := hash != w.hash
+	if changed {
+		w.hash = hash
+		w.changed = true
+	}
+	w.mutex.Unlock()
+	if changed {
+		glog.Infof("haproxy template %s changed, scheduling a sync", w.desc())
+		w.notify()
+	}
+}
+
+// parse calls parseTemplates if the template has changed since the last
+// call, should be called before updating HAProxy
+func (w *templateWatcher) parse(parseTemplates func() error) {
+	w.mutex.Lock()
+	changed := w.changed
+	w.changed = false
+	w.mutex.Unlock()
+	if !changed {
+		return
+	}
+	if err := parseTemplates(); err != nil {
+		glog.Errorf("error parsing haproxy template, using the former one: %v", err)
+		w.event("TemplateParseError", err)
+	}
+}
+
+// updated receives the result of the last HAProxy update, a failure
+// is emitted only once
+func (w *templateWatcher) updated(err error) {
+	if err == nil {
+		w.lastErr = ""
+		return
+	}
+	if err.Error() == w.lastErr {
+		return
+	}
+	w.lastErr = err.Error()
+	w.event("ConfigUpdateError", err)
+}
+
+func (w *templateWatcher) event(reason string, err error) {
+	if w.pod == nil {
+		return
+	}
+	w.recorder().Eventf(w.pod, api.EventTypeWarning, reason, "template %s: %v", w.desc(), err)
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const ingressClassController = "haproxy-ingress.github.io/controller"
+
+// ingressClassPartials is the directory of the template partials declared
+// in the parameters of the IngressClass
+const ingressClassPartials = "/etc/haproxy/class-templates"
+
+var (
+	ingressClassGroupVersions = []string{"networking.k8s.io/v1", "networking.k8s.io/v1beta1"}
+	partialNameRegex          = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.tmpl$`)
+)
+
+// ingressClassParams reads the IngressClass of the controller and the
+// resource referenced by its parameters, either a ConfigMap or a
+// HAProxyGlobalConfig, whose keys are class specific global config.
+// Keys ending with .tmpl are template partials, written to partialsDir.
+type ingressClassParams struct {
+	mutex       sync.Mutex
+	client      kubernetes.Interface
+	class       string
+	notify      func()
+	partialsDir string
+	config      map[string]string
+	partials    map[string]string
+	err         error
+}
+
+type ingressClassParameters struct {
+	APIGroup  string `json:"apiGroup"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type ingressClassResource struct {
+	Spec struct {
+		Controller string                  `json:"controller"`
+		Parameters *ingressClassParameters `json:"parameters"`
+	} `json:"spec"`
+}
+
+func newIngressClassParams(client kubernetes.Interface, class, partialsDir string, notify func()) *ingressClassParams {
+	return &ingressClassParams{
+		client:      client,
+		class:       class,
+		notify:      notify,
+		partialsDir: partialsDir,
+	}
+}
+
+func (p *ingressClassParams) run(stopCh <-chan struct{}) {
+	p.read()
+	go wait.Until(func() {
+		if p.read() {
+			p.notify()
+		}
+	}, configResourcePollInterval, stopCh)
+}
+
+// read updates the class parameters from the apiserver, returns true if they have changed
+func (p *ingressClassParams) read() bool {
+	config, err := p.readParameters()
+	if err != nil {
+		glog.Warningf("error reading parameters of IngressClass '%s': %v", p.class, err)
+	}
+	config, partials := splitPartials(config)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err == nil && !reflect.DeepEqual(partials, p.partials) {
+		// partials are preserved on errors, the template watcher
+		// reparses the templates when the files change
+		if err := writePartials(p.partialsDir, partials); err != nil {
+			glog.Warningf("error writing template partials of IngressClass '%s': %v", p.class, err)
+		}
+		p.partials = partials
+	}
+	if reflect.DeepEqual(config, p.config) && reflect.DeepEqual(err, p.err) {
+		return false
+	}
+	p.config = config
+	p.err = err
+	return true
+}
+
+// splitPartials moves the keys ending with .tmpl of the class config to the partials
+func splitPartials(config map[string]string) (map[string]string, map[string]string) {
+	var partials map[string]string
+	for key, value := range config {
+		if strings.HasSuffix(key, ".tmpl") {
+			if partials == nil {
+				partials = map[string]string{}
+			}
+			partials[key] = value
+		}
+	}
+	if partials == nil {
+		return config, nil
+	}
+	global := make(map[string]string, len(config)-len(partials))
+	for key, value := range config {
+		if _, found := partials[key]; !found {
+			global[key] = value
+		}
+	}
+	return global, partials
+}
+
+// writePartials writes the template partials to dir, and removes
+// the partials no longer declared
+func writePartials(dir string, partials map[string]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, _ := filepath.Glob(dir + "/*.tmpl")
+	for _, file := range files {
+		if _, found := partials[filepath.Base(file)]; !found {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	for name, content := range partials {
+		if !partialNameRegex.MatchString(name) {
+			glog.Warningf("ignoring template partial with invalid name: '%s'", name)
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ingressClassParams) readParameters() (map[string]string, error) {
+	var ingClass *ingressClassResource
+	for _, groupVersion := range ingressClassGroupVersions {
+		path := fmt.Sprintf("/apis/%s/ingressclasses/%s", groupVersion, p.class)
+		raw, err := p.client.CoreV1().RESTClient().Get().AbsPath(path).DoRaw()
+		if errors.IsNotFound(err) {
+			// either the IngressClass or the API version does not exist
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ingClass = &ingressClassResource{}
+		if err := json.Unmarshal(raw, ingClass); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if ingClass == nil || ingClass.Spec.Parameters == nil {
+		return nil, nil
+	}
+	if ingClass.Spec.Controller != ingressClassController {
+		return nil, fmt.Errorf("IngressClass is assigned to another controller: '%s'", ingClass.Spec.Controller)
+	}
+	params := ingClass.Spec.Parameters
+	switch {
+	case params.APIGroup == "" && params.Kind == "ConfigMap":
+		return p.readConfigMap(params)
+	case params.APIGroup == configResourceGroup && params.Kind == "HAProxyGlobalConfig":
+		return p.readGlobalConfig(params)
+	}
+	return nil, fmt.Errorf("unsupported parameters kind '%s' of API group '%s'", params.Kind, params.APIGroup)
+}
+
+func (p *ingressClassParams) readConfigMap(params *ingressClassParameters) (map[string]string, error) {
+	if params.Namespace == "" {
+		return nil, fmt.Errorf("missing namespace of ConfigMap '%s'", params.Name)
+	}
+	cm, err := p.client.CoreV1().ConfigMaps(params.Namespace).Get(params.Name, meta.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+func (p *ingressClassParams) readGlobalConfig(params *ingressClassParameters) (map[string]string, error) {
+	path := fmt.Sprintf("/apis/%s/%s/%s", configResourceGroupVersion, globalConfigResource, params.Name)
+	raw, err := p.client.CoreV1().RESTClient().Get().AbsPath(path).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	_, config, err := parseGlobalConfig(raw)
+	return config, err
+}
+
+func (p *ingressClassParams) getConfig() (map[string]string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.config, p.err
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitPartials(t *testing.T) {
+	testCases := []struct {
+		config      map[string]string
+		expGlobal   map[string]string
+		expPartials map[string]string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			config:    map[string]string{"ssl-redirect": "false"},
+			expGlobal: map[string]string{"ssl-redirect": "false"},
+		},
+		// 2
+		{
+			config:      map[string]string{"ssl-redirect": "false", "frontend.tmpl": "{{ define \"x\" }}{{ end }}"},
+			expGlobal:   map[string]string{"ssl-redirect": "false"},
+			expPartials: map[string]string{"frontend.tmpl": "{{ define \"x\" }}{{ end }}"},
+		},
+		// 3
+		{
+			config:      map[string]string{"frontend.tmpl": ""},
+			expGlobal:   map[string]string{},
+			expPartials: map[string]string{"frontend.tmpl": ""},
+		},
+	}
+	for i, test := range testCases {
+		global, partials := splitPartials(test.config)
+		if !reflect.DeepEqual(global, test.expGlobal) || !reflect.DeepEqual(partials, test.expPartials) {
+			t.Errorf("split on %d differs, expected: %v %v, actual: %v %v", i, test.expGlobal, test.expPartials, global, partials)
+		}
+	}
+}
+
+func TestWritePartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir += "/class"
+	read := func() map[string]string {
+		files, _ := filepath.Glob(dir + "/*")
+		content := map[string]string{}
+		for _, file := range files {
+			data, _ := ioutil.ReadFile(file)
+			content[filepath.Base(file)] = string(data)
+		}
+		return content
+	}
+	testCases := []struct {
+		partials map[string]string
+		expected map[string]string
+	}{
+		// 0
+		{
+			partials: map[string]string{"a.tmpl": "a1", "b.tmpl": "b1"},
+			expected: map[string]string{"a.tmpl": "a1", "b.tmpl": "b1"},
+		},
+		// 1
+		{
+			partials: map[string]string{"b.tmpl": "b2", "../c.tmpl": "c1", "d e.tmpl": "d1"},
+			expected: map[string]string{"b.tmpl": "b2"},
+		},
+		// 2
+		{
+			expected: map[string]string{},
+		},
+	}
+	for i, test := range testCases {
+		if err := writePartials(dir, test.partials); err != nil {
+			t.Errorf("error writing partials on %d: %v", i, err)
+		}
+		if actual := read(); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("partials on %d differ, expected: %v, actual: %v", i, test.expected, actual)
+		}
+	}
+}
@@ -20,10 +20,30 @@ import (
 	"fmt"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type logger struct {
 	depth int
+	// messages, if assigned, counts warnings and errors by level, source and annotation
+	messages *prometheus.CounterVec
+	// listeners receive the warnings and errors
+	listeners []messageListener
+}
+
+// messageListener receives the warnings and errors of a logger
+type messageListener interface {
+	message(level, msg string)
+}
+
+func (l *logger) count(level, msg string) {
+	if l.messages != nil {
+		source, annotation := messageLabels(msg)
+		l.messages.WithLabelValues(level, source, annotation).Inc()
+	}
+	for _, listener := range l.listeners {
+		listener.message(level, msg)
+	}
 }
 
 func (l *logger) build(msg string, args []interface{}) string {
@@ -44,11 +64,15 @@ func (l *logger) Info(msg string, args ...interface{}) {
 }
 
 func (l *logger) Warn(msg string, args ...interface{}) {
-	glog.WarningDepth(l.depth, l.build(msg, args))
+	msg = l.build(msg, args)
+	l.count("warn", msg)
+	glog.WarningDepth(l.depth, msg)
 }
 
 func (l *logger) Error(msg string, args ...interface{}) {
-	glog.ErrorDepth(l.depth, l.build(msg, args))
+	msg = l.build(msg, args)
+	l.count("error", msg)
+	glog.ErrorDepth(l.depth, msg)
 }
 
 func (l *logger) Fatal(msg string, args ...interface{}) {
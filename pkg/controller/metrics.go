@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/jsonlog"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+)
+
+var (
+	syncDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ingress_controller",
+			Name:      "sync_duration_seconds",
+			Help:      "Time spent synchronizing the cluster state to HAProxy, by step: convert the resources to the HAProxy model, and update HAProxy",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"step"},
+	)
+	syncObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ingress_controller",
+			Name:      "objects",
+			Help:      "Number of objects of the last synchronization, by kind",
+		},
+		[]string{"kind"},
+	)
+	converterMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ingress_controller",
+			Name:      "converter_messages",
+			Help:      "Cumulative number of warnings and errors found converting ingress resources and annotations, by level, kind of the source resource and annotation",
+		},
+		[]string{"level", "source", "annotation"},
+	)
+	certExpire = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "haproxy_ingress",
+			Name:      "cert_expire_seconds",
+			Help:      "Expiration of the certificates of the TLS secrets used by the last synchronization, as a Unix timestamp in seconds",
+		},
+		[]string{"secret", "cn"},
+	)
+)
+
+var (
+	annotationMessageRegex = regexp.MustCompile(`\bannotation '([^']+)'`)
+	keyMessageRegex        = regexp.MustCompile(`\b(?:ignoring|invalid) (?:invalid )?([a-z0-9]+(?:-[a-z0-9]+)+)\b`)
+)
+
+func init() {
+	prometheus.MustRegister(syncDuration)
+	prometheus.MustRegister(syncObjects)
+	prometheus.MustRegister(converterMessages)
+	prometheus.MustRegister(certExpire)
+}
+
+func observeSyncStep(step string, start time.Time) time.Time {
+	now := time.Now()
+	syncDuration.WithLabelValues(step).Observe(now.Sub(start).Seconds())
+	return now
+}
+
+// messageLabels returns the kind of the resource a converter message refers to,
+// eg `ingress`, and the annotation or configuration key without the prefix, eg
+// `cache-max-age`. Labels are empty if the message doesn't refer to them.
+func messageLabels(msg string) (source, annotation string) {
+	if obj := jsonlog.FindObject(msg); obj != nil {
+		source = obj.Kind
+	}
+	if match := annotationMessageRegex.FindStringSubmatch(msg); match != nil {
+		annotation = match[1][strings.LastIndex(match[1], "/")+1:]
+	} else if match := keyMessageRegex.FindStringSubmatch(msg); match != nil {
+		annotation = match[1]
+	}
+	return source, annotation
+}
+
+func updateSyncObjects(ingresses int, config haproxy.Config) {
+	endpoints := 0
+	for _, backend := range config.Backends() {
+		endpoints += len(backend.Endpoints)
+	}
+	syncObjects.WithLabelValues("ingresses").Set(float64(ingresses))
+	syncObjects.WithLabelValues("hosts").Set(float64(len(config.Hosts())))
+	syncObjects.WithLabelValues("backends").Set(float64(len(config.Backends())))
+	syncObjects.WithLabelValues("endpoints").Set(float64(endpoints))
+	syncObjects.WithLabelValues("userlists").Set(float64(len(config.Userlists())))
+}
+
+func updateCertExpire(certs map[string]*ingress.SSLCert) {
+	certExpire.Reset()
+	for secretName, cert := range certs {
+		if cert.ExpireTime.IsZero() {
+			continue
+		}
+		var cn string
+		if cert.Certificate != nil {
+			cn = cert.Certificate.Subject.CommonName
+		}
+		certExpire.WithLabelValues(secretName, cn).Set(float64(cert.ExpireTime.Unix()))
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMessageLabels(t *testing.T) {
+	testCases := []struct {
+		msg           string
+		expSource     string
+		expAnnotation string
+	}{
+		// 0
+		{
+			msg: "error reading configmap: not found",
+		},
+		// 1
+		{
+			msg:       "service 'default/app' not found",
+			expSource: "service",
+		},
+		// 2
+		{
+			msg:           "ignoring auth-realm with quotes on ingress 'default/app'",
+			expSource:     "ingress",
+			expAnnotation: "auth-realm",
+		},
+		// 3
+		{
+			msg:           "ignoring cache-max-object-size '8m' on ingress 'default/app': needs HAProxy 1.9 or newer, found version 1.8",
+			expSource:     "ingress",
+			expAnnotation: "cache-max-object-size",
+		},
+		// 4
+		{
+			msg:           "invalid balance algorithm 'x' on ingress 'default/app', using 'roundrobin' instead",
+			expSource:     "ingress",
+			expAnnotation: "",
+		},
+		// 5
+		{
+			msg:           "annotation 'haproxy-ingress.github.io/ssl-redirect' overrides 'ssl-redirect' of a lower precedence prefix on ingress 'default/app': 'true' -> 'false'",
+			expSource:     "ingress",
+			expAnnotation: "ssl-redirect",
+		},
+		// 6
+		{
+			msg:           "ignoring invalid timeout-client '1x' on global config",
+			expAnnotation: "timeout-client",
+		},
+	}
+	for i, test := range testCases {
+		source, annotation := messageLabels(test.msg)
+		if source != test.expSource || annotation != test.expAnnotation {
+			t.Errorf("labels on %d differs, expected: %q %q, actual: %q %q", i, test.expSource, test.expAnnotation, source, annotation)
+		}
+	}
+}
+
+func TestLoggerCount(t *testing.T) {
+	messages := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "messages"},
+		[]string{"level", "source", "annotation"},
+	)
+	l := &logger{messages: messages}
+	l.Warn("ignoring auth-realm with quotes on %s", "ingress 'default/app1'")
+	l.Warn("ignoring auth-realm with quotes on %s", "ingress 'default/app2'")
+	l.Error("service '%s' not found", "default/app")
+	testCases := []struct {
+		labels   []string
+		expected float64
+	}{
+		// 0
+		{
+			labels:   []string{"warn", "ingress", "auth-realm"},
+			expected: 2,
+		},
+		// 1
+		{
+			labels:   []string{"error", "service", ""},
+			expected: 1,
+		},
+		// 2
+		{
+			labels:   []string{"warn", "service", ""},
+			expected: 0,
+		},
+	}
+	for i, test := range testCases {
+		m := &dto.Metric{}
+		if err := messages.WithLabelValues(test.labels...).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if count := m.GetCounter().GetValue(); count != test.expected {
+			t.Errorf("count on %d differs, expected: %v, actual: %v", i, test.expected, count)
+		}
+	}
+}
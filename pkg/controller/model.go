@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+const redacted = "<redacted>"
+
+// modelDump is the serializable view of the haproxy model. Backends are
+// referenced by ID from hosts, and secret values are redacted
+type modelDump struct {
+	Global    hatypes.Global
+	Hosts     []*modelHost
+	Backends  []*hatypes.Backend
+	Userlists []*hatypes.Userlist
+}
+
+type modelHost struct {
+	hatypes.Host
+	Paths                  []*modelHostPath
+	HTTPPassthroughBackend string `json:",omitempty"`
+}
+
+type modelHostPath struct {
+	Path      string
+	BackendID string
+}
+
+func buildModelDump(config haproxy.Config) *modelDump {
+	dump := &modelDump{
+		Global:   *config.Global(),
+		Backends: config.Backends(),
+	}
+	if dump.Global.Cookie.Key != "" {
+		dump.Global.Cookie.Key = redacted
+	}
+	if auth := dump.Global.Stats.Auth; auth != "" {
+		// keep the user, which helps to debug the stats page access
+		if pos := strings.Index(auth, ":"); pos >= 0 {
+			dump.Global.Stats.Auth = auth[:pos+1] + redacted
+		} else {
+			dump.Global.Stats.Auth = redacted
+		}
+	}
+	for _, host := range config.Hosts() {
+		h := &modelHost{Host: *host}
+		for _, path := range host.Paths {
+			h.Paths = append(h.Paths, &modelHostPath{Path: path.Path, BackendID: path.BackendID})
+		}
+		if host.HTTPPassthroughBackend != nil {
+			h.HTTPPassthroughBackend = host.HTTPPassthroughBackend.ID
+		}
+		dump.Hosts = append(dump.Hosts, h)
+	}
+	for _, userlist := range config.Userlists() {
+		u := *userlist
+		u.Users = make([]hatypes.User, len(userlist.Users))
+		for i, user := range userlist.Users {
+			u.Users[i] = user
+			u.Users[i].Passwd = redacted
+		}
+		dump.Userlists = append(dump.Userlists, &u)
+	}
+	return dump
+}
+
+// marshalModel serializes the last configuration applied to HAProxy
+// as JSON or YAML, returns nil if a configuration wasn't applied yet
+func (hc *HAProxyController) marshalModel(format string) ([]byte, error) {
+	if hc.instance == nil {
+		return nil, nil
+	}
+	config := hc.instance.LastConfig()
+	if config == nil {
+		return nil, nil
+	}
+	dump := buildModelDump(config)
+	if format == "yaml" {
+		return yaml.Marshal(dump)
+	}
+	return json.MarshalIndent(dump, "", "  ")
+}
+
+// authorized compares the bearer token of a request with the content of
+// tokenFile, and writes an error response if the request isn't authorized.
+// The file is read on every request, so a mounted secret can be rotated.
+func authorized(w http.ResponseWriter, r *http.Request, tokenFile, api string) bool {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		glog.Warningf("error reading %s token: %v", api, err)
+		http.Error(w, api+" is not available", http.StatusServiceUnavailable)
+		return false
+	}
+	expected := "Bearer " + strings.TrimSpace(string(token))
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (hc *HAProxyController) modelHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r, *hc.modelTokenFile, "model API") {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	out, err := hc.marshalModel(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if out == nil {
+		http.Error(w, "configuration was not applied yet", http.StatusServiceUnavailable)
+		return
+	}
+	if format == "yaml" {
+		w.Header().Set("Content-Type", "application/x-yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(out)
+}
+
+// handleDumpModel writes the model to the --dump-model file whenever SIGUSR1 is received
+func (hc *HAProxyController) handleDumpModel() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				if err := hc.dumpModel(*hc.dumpModelFile); err != nil {
+					glog.Warningf("error dumping model: %v", err)
+				} else {
+					glog.Infof("model dumped to %s", *hc.dumpModelFile)
+				}
+			case <-hc.stopCh:
+				signal.Stop(sig)
+				return
+			}
+		}
+	}()
+}
+
+func (hc *HAProxyController) dumpModel(filename string) error {
+	format := "json"
+	if strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml") {
+		format = "yaml"
+	}
+	out, err := hc.marshalModel(format)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return fmt.Errorf("configuration was not applied yet")
+	}
+	return ioutil.WriteFile(filename, out, 0600)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestBuildModelDump(t *testing.T) {
+	testCases := []struct {
+		statsAuth string
+		expected  string
+	}{
+		// 0
+		{
+			statsAuth: "",
+			expected:  "",
+		},
+		// 1
+		{
+			statsAuth: "admin:s3cr3t",
+			expected:  "admin:<redacted>",
+		},
+		// 2
+		{
+			statsAuth: "s3cr3t",
+			expected:  "<redacted>",
+		},
+	}
+	for i, test := range testCases {
+		logger := &types_helper.LoggerMock{T: t}
+		config := haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config()
+		config.Global().Cookie.Key = "cookie-s3cr3t"
+		config.Global().Stats.Auth = test.statsAuth
+		config.AddUserlist("default_auth", []hatypes.User{{Name: "user1", Passwd: "passwd-s3cr3t"}})
+		dump := buildModelDump(config)
+		if dump.Global.Stats.Auth != test.expected {
+			t.Errorf("stats auth on %d differs - expected: %s - actual: %s", i, test.expected, dump.Global.Stats.Auth)
+		}
+		if dump.Global.Cookie.Key != redacted {
+			t.Errorf("cookie key on %d was not redacted: %s", i, dump.Global.Cookie.Key)
+		}
+		out, _ := json.Marshal(dump)
+		if strings.Contains(string(out), "s3cr3t") {
+			t.Errorf("model dump on %d has secret values: %s", i, out)
+		}
+		if config.Global().Stats.Auth != test.statsAuth || config.Userlists()[0].Users[0].Passwd != "passwd-s3cr3t" {
+			t.Errorf("model dump on %d changed the haproxy model", i)
+		}
+	}
+}
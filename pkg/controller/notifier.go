@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+)
+
+const webhookQueueSize = 100
+
+// webhookNotifier posts a summary of every HAProxy update to a webhook.
+// Summaries are sent by a single goroutine, so a slow webhook doesn't
+// block the sync; summaries are discarded if the queue is full.
+type webhookNotifier struct {
+	url      string
+	format   string
+	instance string
+	client   *http.Client
+	queue    chan *haproxy.UpdateSummary
+}
+
+// webhookPayload is the body of the json format
+type webhookPayload struct {
+	Instance string `json:"instance"`
+	Time     string `json:"time"`
+	*haproxy.UpdateSummary
+}
+
+// webhookText is the body of the slack and teams formats, both accept a text field
+type webhookText struct {
+	Text string `json:"text"`
+}
+
+func newWebhookNotifier(url, format string) (*webhookNotifier, error) {
+	if format != "json" && format != "slack" && format != "teams" {
+		return nil, fmt.Errorf("unsupported webhook format: %s", format)
+	}
+	instance, _ := os.Hostname()
+	return &webhookNotifier{
+		url:      url,
+		format:   format,
+		instance: instance,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *haproxy.UpdateSummary, webhookQueueSize),
+	}, nil
+}
+
+func (n *webhookNotifier) run(stopCh <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case summary := <-n.queue:
+				n.send(summary)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Notify implements haproxy.Notifier
+func (n *webhookNotifier) Notify(summary *haproxy.UpdateSummary) {
+	select {
+	case n.queue <- summary:
+	default:
+		glog.Warningf("webhook queue is full, discarding the summary of a HAProxy update")
+	}
+}
+
+func (n *webhookNotifier) send(summary *haproxy.UpdateSummary) {
+	var payload interface{}
+	if n.format == "json" {
+		payload = &webhookPayload{
+			Instance:      n.instance,
+			Time:          time.Now().Format(time.RFC3339),
+			UpdateSummary: summary,
+		}
+	} else {
+		payload = &webhookText{Text: n.text(summary)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		glog.Warningf("error encoding webhook payload: %v", err)
+		return
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		glog.Warningf("error posting to webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		glog.Warningf("error posting to webhook: %s", resp.Status)
+	}
+}
+
+// text builds a human readable summary, used by chat webhooks
+func (n *webhookNotifier) text(summary *haproxy.UpdateSummary) string {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "HAProxy update on %s: %s in %s", n.instance, summary.Result, summary.Duration.Round(time.Millisecond))
+	if summary.Error != "" {
+		fmt.Fprintf(out, "\nerror: %s", summary.Error)
+	}
+	items := []struct {
+		name  string
+		items []string
+	}{
+		{"hosts added", summary.HostsAdded},
+		{"hosts removed", summary.HostsRemoved},
+		{"hosts changed", summary.HostsChanged},
+		{"backends added", summary.BackendsAdded},
+		{"backends removed", summary.BackendsRemoved},
+		{"backends changed", summary.BackendsChanged},
+	}
+	for _, item := range items {
+		if len(item.items) > 0 {
+			fmt.Fprintf(out, "\n%s: %s", item.name, strings.Join(item.items, ", "))
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/ocsp"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+const (
+	ocspCheckPeriod   = time.Hour
+	ocspRetryInterval = 10 * time.Minute
+	ocspFileSuffix    = ".ocsp"
+)
+
+// ocspManager fetches the OCSP responses of the certificates used by HAProxy
+// and stores them side by side with the certificate, where HAProxy reads the
+// response on reloads. Responses of certificates whose file already exists
+// are updated via the runtime API, new files ask for a reload instead, since
+// HAProxy only accepts runtime updates of certificates that had a response
+// when they were loaded.
+type ocspManager struct {
+	mutex   sync.Mutex
+	notify  func()
+	trigger chan struct{}
+	enabled bool
+	socket  string
+	files   []string
+	// used by the check goroutine only
+	client  *http.Client
+	issuers map[string]*x509.Certificate
+	refresh map[string]time.Time
+}
+
+func newOCSPManager(notify func()) *ocspManager {
+	return &ocspManager{
+		notify:  notify,
+		trigger: make(chan struct{}, 1),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		issuers: map[string]*x509.Certificate{},
+		refresh: map[string]time.Time{},
+	}
+}
+
+// commit updates the certificates used by HAProxy and asks for a check
+func (m *ocspManager) commit(config haproxy.Config, defaultCrt string) {
+	files := map[string]bool{}
+	if defaultCrt != "" {
+		files[defaultCrt] = true
+	}
+	for _, host := range config.Hosts() {
+		if host.TLS.TLSFilename != "" && !host.SSLPassthrough {
+			files[host.TLS.TLSFilename] = true
+		}
+	}
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	m.mutex.Lock()
+	m.enabled = config.Global().SSL.OCSPUpdate
+	m.socket = config.Global().StatsSocket
+	m.files = filenames
+	m.mutex.Unlock()
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *ocspManager) run(stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(ocspCheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.trigger:
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+			m.check()
+		}
+	}()
+}
+
+// check fetches the responses which are missing or about to expire, or
+// removes the responses if the OCSP update was disabled
+func (m *ocspManager) check() {
+	m.mutex.Lock()
+	enabled := m.enabled
+	socket := m.socket
+	files := m.files
+	m.mutex.Unlock()
+	var reload bool
+	for _, filename := range files {
+		ocspFile := filename + ocspFileSuffix
+		_, err := os.Stat(ocspFile)
+		exists := err == nil
+		if !enabled {
+			if exists {
+				if err := os.Remove(ocspFile); err != nil {
+					glog.Warningf("error removing OCSP response '%s': %v", ocspFile, err)
+					continue
+				}
+				delete(m.refresh, filename)
+				reload = true
+			}
+			continue
+		}
+		if refresh, found := m.refresh[filename]; found && exists && time.Now().Before(refresh) {
+			continue
+		}
+		crt, issuer, err := m.readCertificate(filename)
+		if err != nil {
+			glog.Warningf("error reading certificate '%s' for OCSP update: %v", filename, err)
+			m.refresh[filename] = time.Now().Add(ocspRetryInterval)
+			continue
+		}
+		if crt == nil {
+			// certificate doesn't declare an OCSP responder
+			m.refresh[filename] = time.Now().Add(ocspCheckPeriod)
+			continue
+		}
+		resp, err := ocsp.Fetch(m.client, crt, issuer)
+		if err != nil {
+			glog.Warningf("error fetching OCSP response of '%s': %v", filename, err)
+			m.refresh[filename] = time.Now().Add(ocspRetryInterval)
+			continue
+		}
+		if resp.Status != ocsp.Good {
+			glog.Warningf("OCSP responder didn't return a good status for '%s', status: %d", filename, resp.Status)
+		}
+		if err := writeOCSPFile(ocspFile, resp.Raw); err != nil {
+			glog.Warningf("error writing OCSP response '%s': %v", ocspFile, err)
+			m.refresh[filename] = time.Now().Add(ocspRetryInterval)
+			continue
+		}
+		m.refresh[filename] = ocspRefresh(resp)
+		if !exists {
+			glog.Infof("OCSP response of '%s' fetched, reloading HAProxy", filename)
+			reload = true
+			continue
+		}
+		cmd := "set ssl ocsp-response " + base64.StdEncoding.EncodeToString(resp.Raw)
+		if out, err := utils.HAProxyCommand(socket, cmd); err != nil || !strings.Contains(out, "updated") {
+			if err == nil {
+				err = fmt.Errorf("%s", strings.TrimSpace(out))
+			}
+			glog.Warningf("error updating OCSP response of '%s', it will be used on the next reload: %v", filename, err)
+			continue
+		}
+		glog.V(2).Infof("OCSP response of '%s' updated", filename)
+	}
+	if reload {
+		m.notify()
+	}
+}
+
+// readCertificate reads the first certificate of a PEM file and its issuer,
+// either from the chain or from the issuing certificate URL. A nil
+// certificate means that it doesn't declare an OCSP responder.
+func (m *ocspManager) readCertificate(filename string) (crt, issuer *x509.Certificate, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("certificate not found")
+	}
+	crt = chain[0]
+	if len(crt.OCSPServer) == 0 {
+		return nil, nil, nil
+	}
+	for _, c := range chain[1:] {
+		if crt.CheckSignatureFrom(c) == nil {
+			return crt, c, nil
+		}
+	}
+	for _, url := range crt.IssuingCertificateURL {
+		if issuer = m.issuers[url]; issuer == nil {
+			issuer, err = m.fetchIssuer(url)
+			if err != nil {
+				glog.Warningf("error fetching issuer certificate from '%s': %v", url, err)
+				continue
+			}
+		}
+		if crt.CheckSignatureFrom(issuer) == nil {
+			m.issuers[url] = issuer
+			return crt, issuer, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("issuer certificate not found")
+}
+
+func (m *ocspManager) fetchIssuer(url string) (*x509.Certificate, error) {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// ocspRefresh returns when a response should be fetched again: at the half
+// of its validity, or on the next check if the responder doesn't declare
+// when the next update will be available
+func ocspRefresh(resp *ocsp.Response) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return time.Now().Add(ocspCheckPeriod)
+	}
+	return resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+}
+
+func writeOCSPFile(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// checkOCSPFile removes the OCSP response of a certificate if the response
+// refers to another certificate, eg after the update of a secret, so HAProxy
+// doesn't fail to load it. Returns true if the response exists.
+func checkOCSPFile(pemFile string) bool {
+	ocspFile := pemFile + ocspFileSuffix
+	der, err := ioutil.ReadFile(ocspFile)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(pemFile)
+	if err == nil {
+		block, rest := pem.Decode(data)
+		for block != nil && block.Type != "CERTIFICATE" {
+			block, rest = pem.Decode(rest)
+		}
+		if block != nil {
+			if crt, err := x509.ParseCertificate(block.Bytes); err == nil && ocsp.MatchSerial(der, crt) {
+				return true
+			}
+		}
+	}
+	if err := os.Remove(ocspFile); err != nil {
+		glog.Warningf("error removing outdated OCSP response '%s': %v", ocspFile, err)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// forceResync schedules a full sync which writes the configuration and
+// reloads HAProxy even if the model didn't change, eg after changes made
+// via the admin socket or a suspected drift from the running proxy
+func (hc *HAProxyController) forceResync(source string) {
+	glog.Infof("full sync and reload of HAProxy requested by %s", source)
+	atomic.StoreInt32(&hc.resyncPending, 1)
+	hc.controller.SetForceReload(true)
+}
+
+// takeResync returns true, only once, if a full sync was requested
+func (hc *HAProxyController) takeResync() bool {
+	return atomic.CompareAndSwapInt32(&hc.resyncPending, 1, 0)
+}
+
+func (hc *HAProxyController) resyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(w, r, *hc.resyncTokenFile, "resync API") {
+		return
+	}
+	hc.forceResync("the resync API")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "full sync scheduled")
+}
+
+// handleResyncSignal forces a full sync whenever SIGUSR2 is received
+func (hc *HAProxyController) handleResyncSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				hc.forceResync("SIGUSR2")
+			case <-hc.stopCh:
+				signal.Stop(sig)
+				return
+			}
+		}
+	}()
+}
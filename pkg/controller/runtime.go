@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// RunRuntime implements the `runtime` subcommand: sends a command to the
+// HAProxy runtime API, either through the local unix socket or through the
+// TCP port configured with runtime-api-port, and writes the response to stdout.
+func RunRuntime(args []string) int {
+	flags := pflag.NewFlagSet("runtime", pflag.ContinueOnError)
+	socket := flags.String("socket", "/var/run/haproxy-stats.sock",
+		`Path of the HAProxy admin socket`)
+	address := flags.String("address", "",
+		`Optional host:port of the runtime API exposed with runtime-api-port, used instead of the socket`)
+	timeout := flags.Duration("timeout", 30*time.Second,
+		`Maximum time to wait for the response`)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	command := strings.Join(flags.Args(), " ")
+	if command == "" {
+		fmt.Fprintln(os.Stderr, "missing runtime API command, eg: haproxy-ingress runtime show info")
+		return 2
+	}
+	network, addr := "unix", *socket
+	if *address != "" {
+		network, addr = "tcp", *address
+	}
+	out, err := runtimeCommand(network, addr, command, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error sending command to %s: %v\n", addr, err)
+		return 1
+	}
+	fmt.Print(out)
+	return 0
+}
+
+func runtimeCommand(network, addr, command string, timeout time.Duration) (string, error) {
+	c, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+	out, err := ioutil.ReadAll(c)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+const maxCapturedErrors = 100
+
+var (
+	showErrorsHeaderRegex = regexp.MustCompile(`^\[([^\]]+)\] (frontend|backend) (\S+) \(#-?[0-9]+\): invalid (request|response)`)
+	showErrorsEventRegex  = regexp.MustCompile(`^\s+(frontend|backend) (\S+) \(#-?[0-9]+\), server (\S+) \(#-?[0-9]+\), event #([0-9]+)(?:, src (\S+))?`)
+
+	capturedErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ingress_controller",
+			Name:      "haproxy_captured_errors",
+			Help:      "Cumulative number of malformed requests and responses captured by HAProxy, see show errors",
+		},
+		[]string{"proxy_type", "proxy", "type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(capturedErrors)
+}
+
+// capturedError is a malformed request or response captured by HAProxy
+type capturedError struct {
+	Date      string `json:"date"`
+	ProxyType string `json:"proxyType"`
+	Proxy     string `json:"proxy"`
+	Type      string `json:"type"`
+	Peer      string `json:"peer"`
+	Server    string `json:"server"`
+	Event     int    `json:"event"`
+	Source    string `json:"source"`
+	Details   string `json:"details"`
+}
+
+// showErrors periodically reads `show errors` from the HAProxy stats
+// socket, counting and storing the new captures
+type showErrors struct {
+	mutex     sync.Mutex
+	socket    string
+	interval  time.Duration
+	lastEvent int
+	errors    []*capturedError
+	tokenFile string
+	notify    func(e *capturedError)
+}
+
+func newShowErrors(socket string, interval time.Duration, tokenFile string, notify func(e *capturedError)) *showErrors {
+	return &showErrors{
+		socket:    socket,
+		interval:  interval,
+		lastEvent: -1,
+		tokenFile: tokenFile,
+		notify:    notify,
+	}
+}
+
+func (s *showErrors) run(stopCh <-chan struct{}) {
+	go wait.Until(s.collect, s.interval, stopCh)
+}
+
+func (s *showErrors) collect() {
+	out, err := utils.HAProxyCommand(s.socket, "show errors")
+	if err != nil {
+		glog.V(2).Infof("error reading show errors from haproxy: %v", err)
+		return
+	}
+	for _, e := range s.update(parseShowErrors(out)) {
+		s.notify(e)
+	}
+}
+
+func (s *showErrors) update(errors []*capturedError) []*capturedError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	maxEvent := -1
+	for _, e := range errors {
+		if e.Event > maxEvent {
+			maxEvent = e.Event
+		}
+	}
+	if maxEvent < s.lastEvent {
+		// event IDs restart on HAProxy reloads
+		s.lastEvent = -1
+	}
+	var newErrors []*capturedError
+	for _, e := range errors {
+		if e.Event > s.lastEvent {
+			capturedErrors.WithLabelValues(e.ProxyType, e.Proxy, e.Type).Inc()
+			newErrors = append(newErrors, e)
+		}
+	}
+	s.errors = append(s.errors, newErrors...)
+	if maxEvent > s.lastEvent {
+		s.lastEvent = maxEvent
+	}
+	if len(s.errors) > maxCapturedErrors {
+		s.errors = s.errors[len(s.errors)-maxCapturedErrors:]
+	}
+	return newErrors
+}
+
+func (s *showErrors) handler(w http.ResponseWriter, r *http.Request) {
+	// captures have the raw bytes of the requests, including cookies and credentials
+	if !authorized(w, r, s.tokenFile, "haproxy errors API") {
+		return
+	}
+	s.mutex.Lock()
+	out, err := json.Marshal(s.errors)
+	s.mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func parseShowErrors(out string) []*capturedError {
+	var errors []*capturedError
+	var current *capturedError
+	var details []string
+	flush := func() {
+		if current != nil {
+			current.Details = strings.Join(details, "\n")
+			errors = append(errors, current)
+		}
+		current = nil
+		details = nil
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if header := showErrorsHeaderRegex.FindStringSubmatch(line); header != nil {
+			flush()
+			current = &capturedError{
+				Date:      header[1],
+				ProxyType: header[2],
+				Proxy:     header[3],
+				Type:      header[4],
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if event := showErrorsEventRegex.FindStringSubmatch(line); event != nil && current.Peer == "" {
+			current.Peer = event[2]
+			current.Server = event[3]
+			current.Event, _ = strconv.Atoi(event[4])
+			current.Source = event[5]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			details = append(details, line)
+		}
+	}
+	flush()
+	return errors
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShowErrors(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected []*capturedError
+	}{
+		// 0
+		{
+			out: "Total events captured on [15/Oct/2019:10:00:00.000] : 0\n",
+		},
+		// 1
+		{
+			out: `Total events captured on [15/Oct/2019:10:00:00.000] : 2
+
+[15/Oct/2019:09:58:12.345] frontend _front_http (#2): invalid request
+  backend <NONE> (#-1), server <NONE> (#-1), event #0, src 10.0.0.1:51234
+  buffer starts at 0 (including 0 out), 16380 free,
+  len 2, wraps at 16336, error at position 0
+
+  00000  \x00\r
+
+[15/Oct/2019:09:59:01.001] backend d1_app_8080 (#5): invalid response
+  frontend _front_http (#2), server srv001 (#1), event #1
+  buffer starts at 0 (including 0 out), 16200 free,
+  00000  HTTP/1.1 200 OK\r\n
+  00017  X Bad: header\r\n
+`,
+			expected: []*capturedError{
+				{
+					Date:      "15/Oct/2019:09:58:12.345",
+					ProxyType: "frontend",
+					Proxy:     "_front_http",
+					Type:      "request",
+					Peer:      "<NONE>",
+					Server:    "<NONE>",
+					Event:     0,
+					Source:    "10.0.0.1:51234",
+					Details: `  buffer starts at 0 (including 0 out), 16380 free,
+  len 2, wraps at 16336, error at position 0
+  00000  \x00\r`,
+				},
+				{
+					Date:      "15/Oct/2019:09:59:01.001",
+					ProxyType: "backend",
+					Proxy:     "d1_app_8080",
+					Type:      "response",
+					Peer:      "_front_http",
+					Server:    "srv001",
+					Event:     1,
+					Details: `  buffer starts at 0 (including 0 out), 16200 free,
+  00000  HTTP/1.1 200 OK\r\n
+  00017  X Bad: header\r\n`,
+				},
+			},
+		},
+	}
+	for i, test := range testCases {
+		errors := parseShowErrors(test.out)
+		if !reflect.DeepEqual(errors, test.expected) {
+			t.Errorf("errors on %d differs, expected: %+v, actual: %+v", i, test.expected, errors)
+		}
+	}
+}
+
+func TestShowErrorsUpdate(t *testing.T) {
+	capture := func(event int) *capturedError {
+		return &capturedError{ProxyType: "backend", Proxy: "d1_app_8080", Type: "response", Event: event}
+	}
+	events := func(errors []*capturedError) []int {
+		var ids []int
+		for _, e := range errors {
+			ids = append(ids, e.Event)
+		}
+		return ids
+	}
+	s := newShowErrors("", 0, "", nil)
+	testCases := []struct {
+		errors   []*capturedError
+		expected []int
+	}{
+		// 0
+		{
+			errors:   []*capturedError{capture(0), capture(1)},
+			expected: []int{0, 1},
+		},
+		// 1
+		{
+			errors:   []*capturedError{capture(0), capture(1), capture(2)},
+			expected: []int{2},
+		},
+		// 2
+		{
+			errors: []*capturedError{capture(1), capture(2)},
+		},
+		// 3
+		{
+			// HAProxy reloaded
+			errors:   []*capturedError{capture(0)},
+			expected: []int{0},
+		},
+	}
+	for i, test := range testCases {
+		newErrors := events(s.update(test.errors))
+		if !reflect.DeepEqual(newErrors, test.expected) {
+			t.Errorf("new errors on %d differs, expected: %v, actual: %v", i, test.expected, newErrors)
+		}
+	}
+}
+
+func TestShowErrorsHandler(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("s3cret\n")
+	tokenFile.Close()
+	s := newShowErrors("", 0, tokenFile.Name(), nil)
+	s.update([]*capturedError{{ProxyType: "frontend", Proxy: "_front_http", Type: "request", Details: "Cookie: session=1"}})
+	testCases := []struct {
+		auth    string
+		expCode int
+		expBody string
+	}{
+		// 0
+		{
+			expCode: http.StatusUnauthorized,
+			expBody: "unauthorized",
+		},
+		// 1
+		{
+			auth:    "Bearer wrong",
+			expCode: http.StatusUnauthorized,
+			expBody: "unauthorized",
+		},
+		// 2
+		{
+			auth:    "Bearer s3cret",
+			expCode: http.StatusOK,
+			expBody: `[{"date":"","proxyType":"frontend","proxy":"_front_http","type":"request","peer":"","server":"","event":0,"source":"","details":"Cookie: session=1"}]`,
+		},
+	}
+	for i, test := range testCases {
+		req := httptest.NewRequest("GET", "/debug/haproxy-errors", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		s.handler(w, req)
+		body := strings.TrimSpace(w.Body.String())
+		if w.Code != test.expCode || body != test.expBody {
+			t.Errorf("response on %d differs, expected: %d %s, actual: %d %s", i, test.expCode, test.expBody, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+const defaultTopTableEntries = 10
+
+var (
+	showTableHeaderRegex = regexp.MustCompile(`^# table: ([^,]+), type: ([^,]+), size:([0-9]+), used:([0-9]+)`)
+	showTableEntryRegex  = regexp.MustCompile(`^0x[0-9a-f]+: key=(\S+) use=[0-9]+ exp=([0-9]+)(.*)$`)
+
+	tableEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ingress_controller",
+			Name:      "haproxy_table_entries",
+			Help:      "Number of entries of HAProxy stick tables",
+		},
+		[]string{"table"},
+	)
+	tableMaxValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ingress_controller",
+			Name:      "haproxy_table_max_value",
+			Help:      "Highest value of a stored data type among the entries of HAProxy stick tables",
+		},
+		[]string{"table", "data"},
+	)
+	tableBlocked = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ingress_controller",
+			Name:      "haproxy_table_blocked_entries",
+			Help:      "Number of entries of HAProxy rate limit tables whose request rate is above the limit",
+		},
+		[]string{"table"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tableEntries)
+	prometheus.MustRegister(tableMaxValue)
+	prometheus.MustRegister(tableBlocked)
+}
+
+// stickTable is the content of a HAProxy stick table
+type stickTable struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Size    int           `json:"size"`
+	Used    int           `json:"used"`
+	Limit   int           `json:"limit,omitempty"`
+	Entries []*tableEntry `json:"entries"`
+	Blocked []*tableEntry `json:"blocked,omitempty"`
+}
+
+// tableEntry is an entry of a stick table, eg a client IP, and its stored data
+type tableEntry struct {
+	Key     string         `json:"key"`
+	Expires int            `json:"expiresMillis"`
+	Data    map[string]int `json:"data"`
+}
+
+// showTable periodically reads the stick tables from the HAProxy stats
+// socket, exporting summarized metrics, the top entries of each table and
+// the entries blocked by rate limits. limits maps the rate limit tables to
+// the number of requests allowed in their period
+type showTable struct {
+	mutex     sync.Mutex
+	socket    string
+	interval  time.Duration
+	tokenFile string
+	limits    map[string]int
+	tables    []*stickTable
+}
+
+func newShowTable(socket string, interval time.Duration, tokenFile string) *showTable {
+	return &showTable{
+		socket:    socket,
+		interval:  interval,
+		tokenFile: tokenFile,
+	}
+}
+
+// setLimits updates the rate limits of the tables, used
+// to find the blocked entries on the next readings
+func (s *showTable) setLimits(backends []*hatypes.Backend) {
+	limits := map[string]int{}
+	for _, backend := range backends {
+		rl := backend.RateLimit
+		if rl.Limit == 0 {
+			continue
+		}
+		// a table shared by backends blocks on the lowest limit
+		for _, table := range rl.Tables {
+			if limit, found := limits[table]; !found || rl.Limit < limit {
+				limits[table] = rl.Limit
+			}
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limits = limits
+}
+
+func (s *showTable) run(stopCh <-chan struct{}) {
+	go wait.Until(s.collect, s.interval, stopCh)
+}
+
+func (s *showTable) collect() {
+	out, err := utils.HAProxyCommand(s.socket, "show table")
+	if err != nil {
+		glog.V(2).Infof("error reading show table from haproxy: %v", err)
+		return
+	}
+	tables := parseShowTable(out)
+	for _, table := range tables {
+		out, err := utils.HAProxyCommand(s.socket, "show table "+table.Name)
+		if err != nil {
+			glog.V(2).Infof("error reading table '%s' from haproxy: %v", table.Name, err)
+			continue
+		}
+		if content := parseShowTable(out); len(content) == 1 {
+			table.Entries = content[0].Entries
+		}
+	}
+	s.update(tables)
+}
+
+func (s *showTable) update(tables []*stickTable) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tableEntries.Reset()
+	tableMaxValue.Reset()
+	tableBlocked.Reset()
+	for _, table := range tables {
+		tableEntries.WithLabelValues(table.Name).Set(float64(table.Used))
+		if limit, found := s.limits[table.Name]; found {
+			table.Limit = limit
+			table.Blocked = table.blockedEntries()
+			tableBlocked.WithLabelValues(table.Name).Set(float64(len(table.Blocked)))
+		}
+		maxValues := map[string]int{}
+		for _, entry := range table.Entries {
+			for data, value := range entry.Data {
+				if value > maxValues[data] {
+					maxValues[data] = value
+				}
+			}
+		}
+		for data, value := range maxValues {
+			tableMaxValue.WithLabelValues(table.Name, data).Set(float64(value))
+		}
+	}
+	s.tables = tables
+}
+
+// topEntries returns a copy of the tables with their top entries,
+// sorted by the highest rate counter of each entry
+func (s *showTable) topEntries(top int) []*stickTable {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tables := make([]*stickTable, len(s.tables))
+	for i, table := range s.tables {
+		entries := make([]*tableEntry, len(table.Entries))
+		copy(entries, table.Entries)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].maxRate() > entries[j].maxRate()
+		})
+		if len(entries) > top {
+			entries = entries[:top]
+		}
+		t := *table
+		t.Entries = entries
+		if len(t.Blocked) > top {
+			t.Blocked = t.Blocked[:top]
+		}
+		tables[i] = &t
+	}
+	return tables
+}
+
+func (s *showTable) handler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r, s.tokenFile, "tables API") {
+		return
+	}
+	top := defaultTopTableEntries
+	if param := r.URL.Query().Get("top"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 {
+			http.Error(w, "invalid top parameter: "+param, http.StatusBadRequest)
+			return
+		}
+		top = value
+	}
+	out, err := json.Marshal(s.topEntries(top))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// blockedEntries returns the entries whose request rate is above the limit
+// of the table, sorted by their remaining time to expire, the longest first
+func (t *stickTable) blockedEntries() []*tableEntry {
+	var blocked []*tableEntry
+	for _, entry := range t.Entries {
+		if entry.maxRate() > t.Limit {
+			blocked = append(blocked, entry)
+		}
+	}
+	sort.SliceStable(blocked, func(i, j int) bool {
+		return blocked[i].Expires > blocked[j].Expires
+	})
+	return blocked
+}
+
+func (e *tableEntry) maxRate() int {
+	max := 0
+	for data, value := range e.Data {
+		if strings.Contains(data, "_rate(") && value > max {
+			max = value
+		}
+	}
+	return max
+}
+
+func parseShowTable(out string) []*stickTable {
+	var tables []*stickTable
+	var current *stickTable
+	for _, line := range strings.Split(out, "\n") {
+		if header := showTableHeaderRegex.FindStringSubmatch(line); header != nil {
+			current = &stickTable{Name: header[1], Type: header[2]}
+			current.Size, _ = strconv.Atoi(header[3])
+			current.Used, _ = strconv.Atoi(header[4])
+			tables = append(tables, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if entry := showTableEntryRegex.FindStringSubmatch(line); entry != nil {
+			e := &tableEntry{Key: entry[1], Data: map[string]int{}}
+			e.Expires, _ = strconv.Atoi(entry[2])
+			for _, field := range strings.Fields(entry[3]) {
+				// conn_rate(1000)=3 http_req_cnt=10 ...
+				pos := strings.LastIndex(field, "=")
+				if pos < 0 {
+					continue
+				}
+				if value, err := strconv.Atoi(field[pos+1:]); err == nil {
+					e.Data[field[:pos]] = value
+				}
+			}
+			current.Entries = append(current.Entries, e)
+		}
+	}
+	return tables
+}
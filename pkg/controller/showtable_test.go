@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+func TestParseShowTable(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected []*stickTable
+	}{
+		// 0
+		{
+			out: "",
+		},
+		// 1
+		{
+			out: `
+# table: d1_app_8080_rl, type: ip, size:102400, used:0
+# table: d1_web_8080_rl, type: ip, size:102400, used:2
+`,
+			expected: []*stickTable{
+				{Name: "d1_app_8080_rl", Type: "ip", Size: 102400},
+				{Name: "d1_web_8080_rl", Type: "ip", Size: 102400, Used: 2},
+			},
+		},
+		// 2
+		{
+			out: `
+0x55d8a2a2b1c0: key=10.0.0.1 use=0 exp=100 http_req_rate(1000)=1
+# table: d1_app_8080_rl, type: ip, size:102400, used:2
+0x55d8a2a2b1c0: key=10.0.0.1 use=0 exp=5000 http_req_rate(1000)=12
+0x55d8a2a2b2d0: key=10.0.0.2 use=1 exp=800 conn_rate(1000)=3 http_req_cnt=10 server_id=x
+`,
+			expected: []*stickTable{
+				{Name: "d1_app_8080_rl", Type: "ip", Size: 102400, Used: 2, Entries: []*tableEntry{
+					{Key: "10.0.0.1", Expires: 5000, Data: map[string]int{"http_req_rate(1000)": 12}},
+					{Key: "10.0.0.2", Expires: 800, Data: map[string]int{"conn_rate(1000)": 3, "http_req_cnt": 10}},
+				}},
+			},
+		},
+	}
+	for i, test := range testCases {
+		tables := parseShowTable(test.out)
+		if !reflect.DeepEqual(tables, test.expected) {
+			t.Errorf("tables on %d differs, expected: %+v, actual: %+v", i, test.expected, tables)
+		}
+	}
+}
+
+func TestShowTableBlocked(t *testing.T) {
+	entry := func(key string, expires, rate int) *tableEntry {
+		return &tableEntry{Key: key, Expires: expires, Data: map[string]int{"http_req_rate(1000)": rate}}
+	}
+	backend := func(limit int, tables ...string) *hatypes.Backend {
+		return &hatypes.Backend{RateLimit: hatypes.RateLimitConfig{Limit: limit, Tables: tables}}
+	}
+	testCases := []struct {
+		backends []*hatypes.Backend
+		entries  []*tableEntry
+		expLimit int
+		expected []string
+	}{
+		// 0
+		{
+			entries: []*tableEntry{entry("10.0.0.1", 1000, 20)},
+		},
+		// 1
+		{
+			backends: []*hatypes.Backend{backend(10, "t1")},
+			entries:  []*tableEntry{entry("10.0.0.1", 1000, 10), entry("10.0.0.2", 500, 11), entry("10.0.0.3", 900, 50)},
+			expLimit: 10,
+			expected: []string{"10.0.0.3", "10.0.0.2"},
+		},
+		// 2
+		{
+			backends: []*hatypes.Backend{backend(10, "t1"), backend(5, "t2", "t1"), backend(0, "t1")},
+			entries:  []*tableEntry{entry("10.0.0.1", 1000, 8)},
+			expLimit: 5,
+			expected: []string{"10.0.0.1"},
+		},
+		// 3
+		{
+			backends: []*hatypes.Backend{backend(10, "t2")},
+			entries:  []*tableEntry{entry("10.0.0.1", 1000, 20)},
+		},
+	}
+	for i, test := range testCases {
+		s := newShowTable("", 0, "")
+		s.setLimits(test.backends)
+		s.update([]*stickTable{{Name: "t1", Entries: test.entries}})
+		table := s.topEntries(defaultTopTableEntries)[0]
+		var blocked []string
+		for _, e := range table.Blocked {
+			blocked = append(blocked, e.Key)
+		}
+		if table.Limit != test.expLimit || !reflect.DeepEqual(blocked, test.expected) {
+			t.Errorf("blocked on %d differs, expected: %d %v, actual: %d %v", i, test.expLimit, test.expected, table.Limit, blocked)
+		}
+	}
+}
+
+func TestShowTableHandler(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("s3cret\n")
+	tokenFile.Close()
+	s := newShowTable("", 0, tokenFile.Name())
+	s.update([]*stickTable{{Name: "t1", Type: "ip"}})
+	testCases := []struct {
+		auth    string
+		query   string
+		expCode int
+		expBody string
+	}{
+		// 0
+		{
+			expCode: http.StatusUnauthorized,
+			expBody: "unauthorized",
+		},
+		// 1
+		{
+			auth:    "Bearer wrong",
+			expCode: http.StatusUnauthorized,
+			expBody: "unauthorized",
+		},
+		// 2
+		{
+			auth:    "Bearer s3cret",
+			query:   "?top=0",
+			expCode: http.StatusBadRequest,
+			expBody: "invalid top parameter: 0",
+		},
+		// 3
+		{
+			auth:    "Bearer s3cret",
+			expCode: http.StatusOK,
+			expBody: `[{"name":"t1","type":"ip","size":0,"used":0,"entries":[]}]`,
+		},
+	}
+	for i, test := range testCases {
+		req := httptest.NewRequest("GET", "/debug/haproxy-tables"+test.query, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		s.handler(w, req)
+		body := strings.TrimSpace(w.Body.String())
+		if w.Code != test.expCode || body != test.expBody {
+			t.Errorf("response on %d differs, expected: %d %s, actual: %d %s", i, test.expCode, test.expBody, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/spiffe"
+)
+
+const spiffeRetryInterval = 10 * time.Second
+
+// spiffeWatcher keeps the X.509 SVID of the controller, received from the
+// Workload API of a SPIRE agent, and the trust bundle of its trust domain in
+// the local filesystem. Backends with secure-spiffe use them as the client
+// certificate and the CA to verify the backend servers. A resync is notified
+// whenever the agent rotates the SVID.
+type spiffeWatcher struct {
+	mutex  sync.Mutex
+	socket string
+	notify func()
+	svid   ingtypes.File
+	bundle ingtypes.File
+}
+
+func newSPIFFEWatcher(socket string, notify func()) *spiffeWatcher {
+	return &spiffeWatcher{
+		socket: socket,
+		notify: notify,
+	}
+}
+
+// files returns the SVID and the bundle files, both are empty if the SVID
+// wasn't received yet
+func (w *spiffeWatcher) files() (svid, bundle ingtypes.File) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.svid, w.bundle
+}
+
+func (w *spiffeWatcher) run(stopCh <-chan struct{}) {
+	go func() {
+		for {
+			err := spiffe.WatchX509SVID(w.socket, stopCh, w.update)
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			glog.Warningf("error watching SVIDs from the Workload API '%s', retrying in %s: %v", w.socket, spiffeRetryInterval, err)
+			select {
+			case <-time.After(spiffeRetryInterval):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (w *spiffeWatcher) update(svids []spiffe.SVID) {
+	// the first SVID is the default identity of the workload
+	svid := svids[0]
+	if len(svid.Bundle) == 0 {
+		glog.Warningf("ignoring SVID '%s': Workload API did not send the trust bundle", svid.ID)
+		return
+	}
+	crt, err := ssl.AddOrUpdateCertAndKey("spiffe-svid", svid.CertificatePEM(), svid.PrivateKeyPEM(), []byte{})
+	if err != nil {
+		glog.Warningf("error writing SVID '%s': %v", svid.ID, err)
+		return
+	}
+	ca, err := ssl.AddCertAuth("spiffe-bundle", svid.BundlePEM())
+	if err != nil {
+		glog.Warningf("error writing the trust bundle of SVID '%s': %v", svid.ID, err)
+		return
+	}
+	w.mutex.Lock()
+	w.svid = ingtypes.File{
+		Filename: crt.PemFileName,
+		SHA1Hash: crt.PemSHA,
+		NotAfter: svid.Certificates[0].NotAfter,
+	}
+	w.bundle = ingtypes.File{
+		Filename: ca.CAFileName,
+		SHA1Hash: ca.PemSHA,
+	}
+	w.mutex.Unlock()
+	glog.Infof("SVID '%s' updated, expires on %s", svid.ID, svid.Certificates[0].NotAfter.UTC().Format(time.RFC3339))
+	w.notify()
+}
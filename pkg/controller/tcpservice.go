@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/tcpservice"
+)
+
+const tcpServiceResource = "tcpservices"
+
+// tcpServiceResources reads the namespaced TCPService resources, a typed
+// alternative to the tcp services configmap with SNI routing
+type tcpServiceResources struct {
+	mutex      sync.Mutex
+	client     rest.Interface
+	namespaces []string
+	notify     func()
+	services   []*tcpservice.TCPService
+}
+
+func newTCPServiceResources(client rest.Interface, namespaces []string, notify func()) *tcpServiceResources {
+	return &tcpServiceResources{
+		client:     client,
+		namespaces: namespaces,
+		notify:     notify,
+	}
+}
+
+func (r *tcpServiceResources) run(stopCh <-chan struct{}) {
+	r.read()
+	go wait.Until(func() {
+		if r.read() {
+			r.notify()
+		}
+	}, configResourcePollInterval, stopCh)
+}
+
+// read updates the resources from the apiserver, returns true if any has changed
+func (r *tcpServiceResources) read() bool {
+	var services []*tcpservice.TCPService
+	if err := listResources(r.client, configResourceGroupVersion, tcpServiceResource, r.namespaces, &services); err != nil {
+		glog.Warningf("error listing %s: %v", tcpServiceResource, err)
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if reflect.DeepEqual(services, r.services) {
+		return false
+	}
+	r.services = services
+	return true
+}
+
+func (r *tcpServiceResources) getServices() []*tcpservice.TCPService {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.services
+}
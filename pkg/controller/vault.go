@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/vault"
+)
+
+const (
+	vaultCheckPeriod   = time.Minute
+	vaultRetryInterval = 5 * time.Minute
+)
+
+var vaultRoleRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// vaultProvider issues the certificates of the hosts whose TLS secret name
+// is `vault:<role>` using the Vault PKI secrets engine. Certificates are
+// issued asynchronously and only kept in the local filesystem: the default
+// certificate is used until the first certificate is issued, and a resync
+// is notified whenever a certificate is issued or renewed. Certificates are
+// renewed after two thirds of their lifetime, and forgotten as soon as they
+// aren't used by any ingress.
+type vaultProvider struct {
+	mutex   sync.Mutex
+	client  *vault.Client
+	notify  func()
+	trigger chan struct{}
+	current map[string]bool
+	certs   map[string]*vaultCert
+}
+
+type vaultCert struct {
+	role   string
+	domain string
+	file   ingtypes.File
+	err    error
+	renew  time.Time
+}
+
+func newVaultProvider(config vault.Config, notify func()) *vaultProvider {
+	return &vaultProvider{
+		client:  vault.NewClient(config),
+		notify:  notify,
+		trigger: make(chan struct{}, 1),
+		current: map[string]bool{},
+		certs:   map[string]*vaultCert{},
+	}
+}
+
+// GetTLSCertificate implements ingtypes.SecretProvider
+func (v *vaultProvider) GetTLSCertificate(role, domain string) (ingtypes.File, error) {
+	if !vaultRoleRegex.MatchString(role) {
+		return ingtypes.File{}, fmt.Errorf("invalid Vault role name")
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	key := role + "/" + domain
+	v.current[key] = true
+	crt := v.certs[key]
+	if crt == nil {
+		v.certs[key] = &vaultCert{role: role, domain: domain}
+		select {
+		case v.trigger <- struct{}{}:
+		default:
+		}
+		return ingtypes.File{}, fmt.Errorf("certificate is being issued")
+	}
+	if crt.file.Filename == "" {
+		if crt.err != nil {
+			return ingtypes.File{}, crt.err
+		}
+		return ingtypes.File{}, fmt.Errorf("certificate is being issued")
+	}
+	return crt.file, nil
+}
+
+// commit finishes a sync and removes the certificates which aren't used anymore
+func (v *vaultProvider) commit() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for key, crt := range v.certs {
+		if v.current[key] {
+			continue
+		}
+		if crt.file.Filename != "" {
+			if err := os.Remove(crt.file.Filename); err != nil {
+				glog.Warningf("error removing Vault certificate '%s': %v", crt.file.Filename, err)
+			}
+		}
+		delete(v.certs, key)
+	}
+	v.current = map[string]bool{}
+}
+
+func (v *vaultProvider) run(stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(vaultCheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-v.trigger:
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+			v.check()
+		}
+	}()
+}
+
+// check issues the certificates which are missing or should be renewed
+func (v *vaultProvider) check() {
+	now := time.Now()
+	v.mutex.Lock()
+	var pending []vaultCert
+	for _, crt := range v.certs {
+		if now.After(crt.renew) {
+			pending = append(pending, *crt)
+		}
+	}
+	v.mutex.Unlock()
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].role == pending[j].role {
+			return pending[i].domain < pending[j].domain
+		}
+		return pending[i].role < pending[j].role
+	})
+	var updated bool
+	for _, crt := range pending {
+		file, renew, err := v.issue(crt.role, crt.domain)
+		v.mutex.Lock()
+		cur := v.certs[crt.role+"/"+crt.domain]
+		if cur == nil {
+			// not used anymore
+			v.mutex.Unlock()
+			if err == nil {
+				os.Remove(file.Filename)
+			}
+			continue
+		}
+		if err != nil {
+			glog.Warningf("error issuing Vault certificate of '%s' using role '%s': %v", crt.domain, crt.role, err)
+			cur.err = err
+			cur.renew = now.Add(vaultRetryInterval)
+		} else {
+			glog.Infof("Vault certificate of '%s' issued using role '%s', expires on %s", crt.domain, crt.role, file.NotAfter.UTC().Format(time.RFC3339))
+			cur.file = file
+			cur.err = nil
+			cur.renew = renew
+			updated = true
+		}
+		v.mutex.Unlock()
+	}
+	if updated {
+		v.notify()
+	}
+}
+
+func (v *vaultProvider) issue(role, domain string) (file ingtypes.File, renew time.Time, err error) {
+	issued := time.Now()
+	crt, err := v.client.Issue(role, []string{domain})
+	if err != nil {
+		return file, renew, err
+	}
+	name := "vault_" + role + "_" + strings.Replace(domain, "*", "_", -1)
+	sslCert, err := ssl.AddOrUpdateCertAndKey(name, crt.Certificate, crt.PrivateKey, []byte{})
+	if err != nil {
+		return file, renew, err
+	}
+	file = ingtypes.File{
+		Filename: sslCert.PemFileName,
+		SHA1Hash: sslCert.PemSHA,
+		NotAfter: crt.NotAfter,
+	}
+	renew = crt.NotAfter.Add(-crt.NotAfter.Sub(issued) / 3)
+	return file, renew, nil
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	ingutils "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/utils"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// Config ...
+type Config interface {
+	Sync(resources *Resources)
+	RouteStatus() []*RouteStatus
+}
+
+// NewGatewayConverter ...
+func NewGatewayConverter(options *ingtypes.ConverterOptions, haproxy haproxy.Config) Config {
+	return &converter{
+		haproxy: haproxy,
+		options: options,
+		logger:  options.Logger,
+		cache:   options.Cache,
+	}
+}
+
+type converter struct {
+	haproxy   haproxy.Config
+	options   *ingtypes.ConverterOptions
+	logger    types.Logger
+	cache     ingtypes.Cache
+	resources *Resources
+	status    []*routeStatus
+}
+
+// routeStatus tracks the listeners a route could attach to
+type routeStatus struct {
+	RouteStatus
+	attached   bool
+	notAllowed bool
+}
+
+func (c *converter) Sync(resources *Resources) {
+	c.resources = resources
+	c.status = nil
+	gateways := make([]*Gateway, len(resources.Gateways))
+	copy(gateways, resources.Gateways)
+	sort.Slice(gateways, func(i, j int) bool {
+		return gateways[i].Metadata.Namespace+"/"+gateways[i].Metadata.Name <
+			gateways[j].Metadata.Namespace+"/"+gateways[j].Metadata.Name
+	})
+	for _, gw := range gateways {
+		for i := range gw.Spec.Listeners {
+			listener := &gw.Spec.Listeners[i]
+			switch listener.Protocol {
+			case "HTTP", "HTTPS":
+				for _, route := range resources.HTTPRoutes {
+					if st := c.attach(gw, listener, "HTTPRoute", &route.Metadata, route.Spec.ParentRefs); st != nil {
+						c.syncHTTPRoute(gw, listener, route, st)
+					}
+				}
+			case "TLS":
+				for _, route := range resources.TLSRoutes {
+					if st := c.attach(gw, listener, "TLSRoute", &route.Metadata, route.Spec.ParentRefs); st != nil {
+						c.syncTLSRoute(listener, route, st)
+					}
+				}
+			case "TCP":
+				for _, route := range resources.TCPRoutes {
+					if st := c.attach(gw, listener, "TCPRoute", &route.Metadata, route.Spec.ParentRefs); st != nil {
+						c.syncTCPRoute(listener, route, st)
+					}
+				}
+			default:
+				c.logger.Warn("ignoring listener '%s' of gateway '%s/%s': unsupported protocol '%s'",
+					listener.Name, gw.Metadata.Namespace, gw.Metadata.Name, listener.Protocol)
+			}
+		}
+	}
+	for _, st := range c.status {
+		if !st.attached && st.Accepted.Status {
+			if st.notAllowed {
+				st.Accepted = Condition{Reason: "NotAllowedByListeners", Message: "route namespace is not allowed by the listeners of the gateway"}
+			} else {
+				st.Accepted = Condition{Reason: "NoMatchingParent", Message: "no listener of the gateway matches the parent reference"}
+			}
+		}
+	}
+}
+
+// RouteStatus returns the status of the routes attached to the gateways
+// of the last sync
+func (c *converter) RouteStatus() []*RouteStatus {
+	status := make([]*RouteStatus, len(c.status))
+	for i, st := range c.status {
+		status[i] = &st.RouteStatus
+	}
+	return status
+}
+
+func (c *converter) acquireStatus(kind string, route *ObjectMeta, gw *Gateway) *routeStatus {
+	for _, st := range c.status {
+		if st.Kind == kind && st.Route.Namespace == route.Namespace && st.Route.Name == route.Name &&
+			st.Gateway.Namespace == gw.Metadata.Namespace && st.Gateway.Name == gw.Metadata.Name {
+			return st
+		}
+	}
+	st := &routeStatus{
+		RouteStatus: RouteStatus{
+			Kind:         kind,
+			Route:        *route,
+			Gateway:      gw.Metadata,
+			Accepted:     Condition{Status: true, Reason: "Accepted"},
+			ResolvedRefs: Condition{Status: true, Reason: "ResolvedRefs"},
+		},
+	}
+	c.status = append(c.status, st)
+	return st
+}
+
+// attach checks if a route references the gateway, and the listener if a
+// section name is used, and if the listener allows routes of the route's
+// namespace. Returns the status of the route on the gateway if attached.
+func (c *converter) attach(gw *Gateway, listener *Listener, kind string, route *ObjectMeta, parentRefs []ObjectRef) *routeStatus {
+	for _, ref := range parentRefs {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = route.Namespace
+		}
+		if namespace != gw.Metadata.Namespace || ref.Name != gw.Metadata.Name {
+			continue
+		}
+		st := c.acquireStatus(kind, route, gw)
+		if ref.SectionName != "" && ref.SectionName != listener.Name {
+			continue
+		}
+		if !c.allowedRoute(gw, listener, route.Namespace) {
+			st.notAllowed = true
+			continue
+		}
+		if !st.Accepted.Status {
+			// already rejected on another listener
+			return nil
+		}
+		st.attached = true
+		return st
+	}
+	return nil
+}
+
+// allowedRoute checks if the listener accepts routes of a namespace, only
+// routes of the gateway's namespace are accepted by default
+func (c *converter) allowedRoute(gw *Gateway, listener *Listener, namespace string) bool {
+	from := "Same"
+	var selector *meta.LabelSelector
+	if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil {
+		if listener.AllowedRoutes.Namespaces.From != "" {
+			from = listener.AllowedRoutes.Namespaces.From
+		}
+		selector = listener.AllowedRoutes.Namespaces.Selector
+	}
+	switch from {
+	case "All":
+		return true
+	case "Same":
+		return namespace == gw.Metadata.Namespace
+	case "Selector":
+		if selector == nil {
+			return false
+		}
+		sel, err := meta.LabelSelectorAsSelector(selector)
+		if err != nil {
+			c.logger.Warn("ignoring routes of listener '%s' of gateway '%s/%s': invalid selector: %v",
+				listener.Name, gw.Metadata.Namespace, gw.Metadata.Name, err)
+			return false
+		}
+		ns, err := c.cache.GetNamespace(namespace)
+		if err != nil {
+			return false
+		}
+		return sel.Matches(labels.Set(ns.Labels))
+	}
+	return false
+}
+
+// referenceGranted checks if a resource of a namespace can reference an
+// object of another namespace, which should be allowed by a ReferenceGrant
+// of the target namespace
+func (c *converter) referenceGranted(fromKind, fromNamespace, toKind, toNamespace, toName string) bool {
+	if fromNamespace == toNamespace {
+		return true
+	}
+	for _, grant := range c.resources.ReferenceGrants {
+		if grant.Metadata.Namespace != toNamespace {
+			continue
+		}
+		fromMatch := false
+		for _, from := range grant.Spec.From {
+			if from.Group == gatewayGroup && from.Kind == fromKind && from.Namespace == fromNamespace {
+				fromMatch = true
+				break
+			}
+		}
+		if !fromMatch {
+			continue
+		}
+		for _, to := range grant.Spec.To {
+			if to.Group == "" && to.Kind == toKind && (to.Name == "" || to.Name == toName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const gatewayGroup = "gateway.networking.k8s.io"
+
+// hostnames returns the route hostnames that match the listener hostname
+func hostnames(listener *Listener, routeHostnames []string) []string {
+	if len(routeHostnames) == 0 {
+		if listener.Hostname == "" {
+			return []string{"*"}
+		}
+		return []string{listener.Hostname}
+	}
+	if listener.Hostname == "" {
+		return routeHostnames
+	}
+	var match []string
+	for _, hostname := range routeHostnames {
+		if hostname == listener.Hostname ||
+			(strings.HasPrefix(listener.Hostname, "*.") && strings.HasSuffix(hostname, listener.Hostname[1:])) {
+			match = append(match, hostname)
+		}
+	}
+	return match
+}
+
+func (c *converter) syncHTTPRoute(gw *Gateway, listener *Listener, route *HTTPRoute, st *routeStatus) {
+	source := fmt.Sprintf("httproute '%s/%s'", route.Metadata.Namespace, route.Metadata.Name)
+	var rulePaths [][]string
+	for _, rule := range route.Spec.Rules {
+		paths, err := readPaths(rule.Matches)
+		if err != nil {
+			c.logger.Warn("ignoring %s: %v", source, err)
+			st.Accepted = Condition{Reason: "UnsupportedValue", Message: err.Error()}
+			st.attached = false
+			return
+		}
+		rulePaths = append(rulePaths, paths)
+	}
+	for _, hostname := range hostnames(listener, route.Spec.Hostnames) {
+		host := c.haproxy.AcquireHost(hostname)
+		if listener.Protocol == "HTTPS" {
+			c.addTLS(gw, listener, host)
+		}
+		for i, rule := range route.Spec.Rules {
+			backend := c.addBackendRefs(source, "HTTPRoute", route.Metadata.Namespace, rule.BackendRefs, false, st)
+			if backend == nil {
+				continue
+			}
+			for _, path := range rulePaths[i] {
+				if host.FindPath(path) != nil {
+					c.logger.Warn("skipping redeclared path '%s' of %s", path, source)
+					continue
+				}
+				host.AddPath(backend, path)
+			}
+		}
+	}
+}
+
+func (c *converter) syncTLSRoute(listener *Listener, route *TLSRoute, st *routeStatus) {
+	source := fmt.Sprintf("tlsroute '%s/%s'", route.Metadata.Namespace, route.Metadata.Name)
+	if listener.TLS != nil && listener.TLS.Mode != "" && listener.TLS.Mode != "Passthrough" {
+		c.logger.Warn("ignoring %s: TLS listener '%s' should use Passthrough mode", source, listener.Name)
+		return
+	}
+	for _, hostname := range hostnames(listener, route.Spec.Hostnames) {
+		if hostname == "*" {
+			c.logger.Warn("ignoring %s: TLS routes need a hostname", source)
+			continue
+		}
+		for _, rule := range route.Spec.Rules {
+			backend := c.addBackendRefs(source, "TLSRoute", route.Metadata.Namespace, rule.BackendRefs, true, st)
+			if backend == nil {
+				continue
+			}
+			host := c.haproxy.AcquireHost(hostname)
+			if host.FindPath("/") != nil {
+				c.logger.Warn("skipping redeclared hostname '%s' of %s", hostname, source)
+				break
+			}
+			host.AddPath(backend, "/")
+			host.SSLPassthrough = true
+			break
+		}
+	}
+}
+
+func (c *converter) syncTCPRoute(listener *Listener, route *TCPRoute, st *routeStatus) {
+	source := fmt.Sprintf("tcproute '%s/%s'", route.Metadata.Namespace, route.Metadata.Name)
+	if listener.Port <= 0 || listener.Port > 65535 || listener.Port == 80 || listener.Port == 443 {
+		c.logger.Warn("ignoring %s: port '%d' of listener '%s' cannot be used by TCP services", source, listener.Port, listener.Name)
+		return
+	}
+	tcpPort := c.haproxy.FindTCPServicePort(listener.Port)
+	if tcpPort != nil && (tcpPort.FindService("") != nil || tcpPort.HasTLS() || tcpPort.AcceptProxy) {
+		c.logger.Warn("skipping %s: port '%d' is already used by another TCP service", source, listener.Port)
+		return
+	}
+	for _, rule := range route.Spec.Rules {
+		backend := c.addBackendRefs(source, "TCPRoute", route.Metadata.Namespace, rule.BackendRefs, true, st)
+		if backend == nil {
+			continue
+		}
+		if tcpPort == nil {
+			tcpPort = c.haproxy.AcquireTCPServicePort(listener.Port)
+		}
+		service := tcpPort.AddService("", backend)
+		service.Source = source
+		break
+	}
+}
+
+func (c *converter) addTLS(gw *Gateway, listener *Listener, host *hatypes.Host) {
+	if host.TLS.TLSHash != "" {
+		return
+	}
+	tlsFile := c.options.DefaultSSLFile
+	if listener.TLS != nil && len(listener.TLS.CertificateRefs) > 0 {
+		ref := listener.TLS.CertificateRefs[0]
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = gw.Metadata.Namespace
+		}
+		secretName := namespace + "/" + ref.Name
+		if !c.referenceGranted("Gateway", gw.Metadata.Namespace, "Secret", namespace, ref.Name) {
+			c.logger.Warn("using default certificate of gateway '%s/%s': cross namespace reference to secret '%s' is not allowed by a ReferenceGrant",
+				gw.Metadata.Namespace, gw.Metadata.Name, secretName)
+		} else if fullName, err := ingutils.SecretName(c.options, c.cache, gw.Metadata.Namespace, secretName); err != nil {
+			c.logger.Warn("using default certificate of gateway '%s/%s': %v", gw.Metadata.Namespace, gw.Metadata.Name, err)
+		} else if file, err := c.cache.GetTLSSecretPath(fullName); err == nil {
+			tlsFile = file
+		} else {
+			c.logger.Warn("using default certificate due to an error reading secret '%s': %v", secretName, err)
+		}
+	}
+	host.TLS.TLSFilename = tlsFile.Filename
+	host.TLS.TLSHash = tlsFile.SHA1Hash
+}
+
+// readPaths reads the paths of the matches of a rule. The v0.8 model only
+// supports prefix match, rules using other match types are rejected.
+func readPaths(matches []HTTPRouteMatch) ([]string, error) {
+	if len(matches) == 0 {
+		return []string{"/"}, nil
+	}
+	var paths []string
+	for _, match := range matches {
+		if match.Path == nil {
+			paths = append(paths, "/")
+			continue
+		}
+		switch match.Path.Type {
+		case "", "PathPrefix":
+			paths = append(paths, match.Path.Value)
+		default:
+			return nil, fmt.Errorf("unsupported path match type '%s' of path '%s'", match.Path.Type, match.Path.Value)
+		}
+	}
+	return paths, nil
+}
+
+func (c *converter) addBackendRefs(source, kind, namespace string, refs []BackendRef, modeTCP bool, st *routeStatus) *hatypes.Backend {
+	if len(refs) == 0 {
+		return nil
+	}
+	if len(refs) > 1 {
+		c.logger.Warn("using only the first backendRef of %s: weighted backends are not supported yet", source)
+	}
+	ref := refs[0]
+	svcNamespace := namespace
+	if ref.Namespace != "" {
+		svcNamespace = ref.Namespace
+	}
+	if !c.referenceGranted(kind, namespace, "Service", svcNamespace, ref.Name) {
+		msg := fmt.Sprintf("cross namespace reference to service '%s/%s' is not allowed by a ReferenceGrant", svcNamespace, ref.Name)
+		c.logger.Warn("skipping backend of %s: %s", source, msg)
+		st.ResolvedRefs = Condition{Reason: "RefNotPermitted", Message: msg}
+		return nil
+	}
+	backend, err := c.addBackend(svcNamespace, ref.Name, ref.Port, modeTCP)
+	if err != nil {
+		c.logger.Warn("skipping backend of %s: %v", source, err)
+		st.ResolvedRefs = Condition{Reason: "BackendNotFound", Message: err.Error()}
+		return nil
+	}
+	return backend
+}
+
+func (c *converter) addBackend(namespace, svcName string, port int, modeTCP bool) (*hatypes.Backend, error) {
+	svc, err := c.cache.GetService(namespace + "/" + svcName)
+	if err != nil {
+		return nil, err
+	}
+	var svcPort *api.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == port {
+			svcPort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("port not found: '%d'", port)
+	}
+	backend := c.haproxy.FindBackend(namespace, svcName, svcPort.TargetPort.String())
+	if backend != nil {
+		if backend.ModeTCP != modeTCP {
+			if modeTCP {
+				return nil, fmt.Errorf("service '%s/%s' is already used as a HTTP backend", namespace, svcName)
+			}
+			return nil, fmt.Errorf("service '%s/%s' is already used as a TCP backend", namespace, svcName)
+		}
+		return backend, nil
+	}
+	backend = c.haproxy.AcquireBackend(namespace, svcName, svcPort.TargetPort.String())
+	backend.ModeTCP = modeTCP
+	if !modeTCP {
+		// gateway backends don't read annotations, use the forwardfor default
+		backend.ForwardFor = "add"
+	}
+	endpoints, err := c.cache.GetEndpoints(svc)
+	if err != nil {
+		c.logger.Error("error adding endpoints of service '%s/%s': %v", namespace, svcName, err)
+		return backend, nil
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, epPort := range subset.Ports {
+			if epPort.Protocol != api.ProtocolTCP ||
+				(epPort.Name != svcPort.Name && strconv.Itoa(int(epPort.Port)) != svcPort.TargetPort.String()) {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				targetRef := ""
+				if addr.TargetRef != nil {
+					targetRef = addr.TargetRef.Namespace + "/" + addr.TargetRef.Name
+				}
+				backend.NewEndpoint(addr.IP, int(epPort.Port), targetRef)
+			}
+		}
+	}
+	return backend, nil
+}
@@ -0,0 +1,584 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/diff"
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestSyncHTTPRoute(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8000, "172.17.0.11,172.17.0.12")
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - {name: http, protocol: HTTP, port: 80}
+httproutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo.local]
+    rules:
+    - matches: [{path: {type: PathPrefix, value: /app}}]
+      backendRefs: [{name: echo, port: 8080}]
+`)
+
+	c.compareHosts(`
+echo.local /app default_echo_8000 [172.17.0.11:8000 172.17.0.12:8000]`)
+}
+
+func TestSyncHTTPSRoute(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.cache.SecretTLSPath = map[string]string{"default/echo-tls": "/tls/echo.pem"}
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - name: https
+      protocol: HTTPS
+      port: 443
+      hostname: "*.local"
+      tls: {certificateRefs: [{name: echo-tls}]}
+    - {name: other, protocol: HTTPS, port: 8443, hostname: other.local}
+httproutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw, sectionName: https}]
+    hostnames: [echo.local, echo.domain]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}, {name: echo, port: 8080}]
+`)
+
+	c.compareHosts(`
+echo.local / default_echo_8080 [172.17.0.11:8080] tls=/tls/echo.pem`)
+
+	c.compareLogging(`
+WARN using only the first backendRef of httproute 'default/echo': weighted backends are not supported yet`)
+}
+
+func TestSyncTLSRoute(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8443, 8443, "172.17.0.11")
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - {name: tls, protocol: TLS, port: 443, tls: {mode: Passthrough}}
+    - {name: tcp, protocol: TCP, port: 5432}
+tlsroutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo.local]
+    rules:
+    - backendRefs: [{name: echo, port: 8443}]
+`)
+
+	c.compareHosts(`
+echo.local / default_echo_8443 [172.17.0.11:8443] passthrough`)
+}
+
+func TestSyncTCPRoute(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/db", 5432, 5432, "172.17.0.21")
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - {name: http, protocol: HTTP, port: 80}
+    - {name: db, protocol: TCP, port: 5432}
+    - {name: echo, protocol: TCP, port: 8080}
+    - {name: web, protocol: TCP, port: 443}
+httproutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw, sectionName: http}]
+    hostnames: [echo.local]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+tcproutes:
+- metadata: {namespace: default, name: db1}
+  spec:
+    parentRefs: [{name: gw, sectionName: db}]
+    rules:
+    - backendRefs: [{name: db, port: 5432}]
+- metadata: {namespace: default, name: db2}
+  spec:
+    parentRefs: [{name: gw, sectionName: db}]
+    rules:
+    - backendRefs: [{name: db, port: 5432}]
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw, sectionName: echo}]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: default, name: web}
+  spec:
+    parentRefs: [{name: gw, sectionName: web}]
+    rules:
+    - backendRefs: [{name: db, port: 5432}]
+`)
+
+	c.compareHosts(`
+echo.local / default_echo_8080 [172.17.0.11:8080]`)
+	c.compareTCPServices(`
+5432 default_db_5432 [172.17.0.21:5432] tcproute 'default/db1'`)
+
+	c.compareLogging(`
+WARN skipping tcproute 'default/db2': port '5432' is already used by another TCP service
+WARN skipping backend of tcproute 'default/echo': service 'default/echo' is already used as a HTTP backend
+WARN ignoring tcproute 'default/web': port '443' of listener 'web' cannot be used by TCP services`)
+}
+
+func TestAllowedRoutes(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("app1/echo", 8080, 8080, "172.17.0.11")
+	c.createSvc("app2/echo", 8080, 8080, "172.17.0.12")
+	c.createSvc("app3/echo", 8080, 8080, "172.17.0.13")
+	c.createNamespace("app1", map[string]string{"team": "a"})
+	c.createNamespace("app2", map[string]string{"team": "b"})
+	c.createNamespace("app3", map[string]string{"team": "a"})
+	c.sync(`
+gateways:
+- metadata: {namespace: app1, name: gw}
+  spec:
+    listeners:
+    - {name: same, protocol: HTTP, port: 80, hostname: same.local}
+    - name: all
+      protocol: HTTP
+      port: 80
+      hostname: all.local
+      allowedRoutes: {namespaces: {from: All}}
+    - name: selector
+      protocol: HTTP
+      port: 80
+      hostname: selector.local
+      allowedRoutes: {namespaces: {from: Selector, selector: {matchLabels: {team: a}}}}
+httproutes:
+- metadata: {namespace: app1, name: echo}
+  spec:
+    parentRefs: [{namespace: app1, name: gw}]
+    rules:
+    - matches: [{path: {value: /app1}}]
+      backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: app2, name: echo}
+  spec:
+    parentRefs: [{namespace: app1, name: gw}]
+    rules:
+    - matches: [{path: {value: /app2}}]
+      backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: app3, name: echo}
+  spec:
+    parentRefs: [{namespace: app1, name: gw}]
+    rules:
+    - matches: [{path: {value: /app3}}]
+      backendRefs: [{name: echo, port: 8080}]
+`)
+
+	c.compareHosts(`
+all.local /app3 app3_echo_8080 [172.17.0.13:8080]
+all.local /app2 app2_echo_8080 [172.17.0.12:8080]
+all.local /app1 app1_echo_8080 [172.17.0.11:8080]
+same.local /app1 app1_echo_8080 [172.17.0.11:8080]
+selector.local /app3 app3_echo_8080 [172.17.0.13:8080]
+selector.local /app1 app1_echo_8080 [172.17.0.11:8080]`)
+}
+
+func TestReferenceGrant(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.createSvc("other/echo1", 8080, 8080, "172.17.0.21")
+	c.createSvc("other/echo2", 8080, 8080, "172.17.0.22")
+	c.cache.SecretTLSPath = map[string]string{
+		"certs/tls1": "/tls/tls1.pem",
+		"certs/tls2": "/tls/tls2.pem",
+	}
+	c.options.SecretNamespaces = []string{"certs"}
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - name: https1
+      protocol: HTTPS
+      port: 443
+      hostname: echo1.local
+      tls: {certificateRefs: [{namespace: certs, name: tls1}]}
+    - name: https2
+      protocol: HTTPS
+      port: 443
+      hostname: echo2.local
+      tls: {certificateRefs: [{namespace: certs, name: tls2}]}
+httproutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw}]
+    rules:
+    - matches: [{path: {value: /app1}}]
+      backendRefs: [{namespace: other, name: echo1, port: 8080}]
+    - matches: [{path: {value: /app2}}]
+      backendRefs: [{namespace: other, name: echo2, port: 8080}]
+    - backendRefs: [{name: echo, port: 8080}]
+referencegrants:
+- metadata: {namespace: other, name: echo1}
+  spec:
+    from: [{group: gateway.networking.k8s.io, kind: HTTPRoute, namespace: default}]
+    to: [{kind: Service, name: echo1}]
+- metadata: {namespace: certs, name: tls1}
+  spec:
+    from: [{group: gateway.networking.k8s.io, kind: Gateway, namespace: default}]
+    to: [{kind: Secret, name: tls1}]
+`)
+
+	c.compareHosts(`
+echo1.local /app1 other_echo1_8080 [172.17.0.21:8080] tls=/tls/tls1.pem
+echo1.local / default_echo_8080 [172.17.0.11:8080] tls=/tls/tls1.pem
+echo2.local /app1 other_echo1_8080 [172.17.0.21:8080]
+echo2.local / default_echo_8080 [172.17.0.11:8080]`)
+
+	c.compareLogging(`
+WARN skipping backend of httproute 'default/echo': cross namespace reference to service 'other/echo2' is not allowed by a ReferenceGrant
+WARN using default certificate of gateway 'default/gw': cross namespace reference to secret 'certs/tls2' is not allowed by a ReferenceGrant
+WARN skipping backend of httproute 'default/echo': cross namespace reference to service 'other/echo2' is not allowed by a ReferenceGrant`)
+}
+
+func TestSecretAllowlist(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.cache.SecretTLSPath = map[string]string{"certs/tls": "/tls/tls.pem"}
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - name: https
+      protocol: HTTPS
+      port: 443
+      hostname: echo.local
+      tls: {certificateRefs: [{namespace: certs, name: tls}]}
+httproutes:
+- metadata: {namespace: default, name: echo}
+  spec:
+    parentRefs: [{name: gw}]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+referencegrants:
+- metadata: {namespace: certs, name: tls}
+  spec:
+    from: [{group: gateway.networking.k8s.io, kind: Gateway, namespace: default}]
+    to: [{kind: Secret}]
+`)
+
+	c.compareHosts(`
+echo.local / default_echo_8080 [172.17.0.11:8080]`)
+
+	c.compareLogging(`
+WARN using default certificate of gateway 'default/gw': cross namespace access to secret 'certs/tls' is not allowed`)
+}
+
+func TestRouteStatus(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - {name: http, protocol: HTTP, port: 80}
+    - {name: http2, protocol: HTTP, port: 8000}
+httproutes:
+- metadata: {namespace: default, name: echo1}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo1.local]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: default, name: echo2}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo2.local]
+    rules:
+    - matches: [{path: {type: Exact, value: /app}}]
+      backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: default, name: echo3}
+  spec:
+    parentRefs: [{name: gw, sectionName: http}]
+    hostnames: [echo3.local]
+    rules:
+    - backendRefs: [{namespace: other, name: echo, port: 8080}]
+- metadata: {namespace: default, name: echo4}
+  spec:
+    parentRefs: [{name: gw, sectionName: https}]
+    hostnames: [echo4.local]
+- metadata: {namespace: other, name: echo5}
+  spec:
+    parentRefs: [{namespace: default, name: gw}]
+    hostnames: [echo5.local]
+`)
+
+	c.compareStatus(`
+HTTPRoute default/echo1 default/gw Accepted=true ResolvedRefs=true
+HTTPRoute default/echo2 default/gw Accepted=false/UnsupportedValue ResolvedRefs=true
+HTTPRoute default/echo3 default/gw Accepted=true ResolvedRefs=false/RefNotPermitted
+HTTPRoute default/echo4 default/gw Accepted=false/NoMatchingParent ResolvedRefs=true
+HTTPRoute other/echo5 default/gw Accepted=false/NotAllowedByListeners ResolvedRefs=true`)
+
+	c.logger.Logging = []string{}
+}
+
+func TestSyncRouteErrors(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/echo", 8080, 8080, "172.17.0.11")
+	c.sync(`
+gateways:
+- metadata: {namespace: default, name: gw}
+  spec:
+    listeners:
+    - {name: http, protocol: HTTP, port: 80}
+    - {name: udp, protocol: UDP, port: 53}
+httproutes:
+- metadata: {namespace: default, name: echo1}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo.local]
+    rules:
+    - matches: [{path: {type: RegularExpression, value: "/a.*"}}, {path: {value: /}}]
+      backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: default, name: echo2}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo.local]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+    - matches: [{path: {value: /app1}}]
+      backendRefs: [{name: echo, port: 9000}]
+    - matches: [{path: {value: /app2}}]
+      backendRefs: [{name: notfound, port: 8080}]
+- metadata: {namespace: default, name: echo3}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [echo.local]
+    rules:
+    - backendRefs: [{name: echo, port: 8080}]
+- metadata: {namespace: other, name: echo4}
+  spec:
+    parentRefs: [{name: gw}]
+    hostnames: [other.local]
+`)
+
+	c.compareHosts(`
+echo.local / default_echo_8080 [172.17.0.11:8080]`)
+
+	c.compareLogging(`
+WARN ignoring httproute 'default/echo1': unsupported path match type 'RegularExpression' of path '/a.*'
+WARN skipping backend of httproute 'default/echo2': port not found: '9000'
+WARN skipping backend of httproute 'default/echo2': service not found: 'default/notfound'
+WARN skipping redeclared path '/' of httproute 'default/echo3'
+WARN ignoring listener 'udp' of gateway 'default/gw': unsupported protocol 'UDP'`)
+}
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ *
+ *  BUILDERS
+ *
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type testConfig struct {
+	t         *testing.T
+	hconfig   haproxy.Config
+	logger    *types_helper.LoggerMock
+	cache     *ing_helper.CacheMock
+	options   *ingtypes.ConverterOptions
+	converter Config
+}
+
+func setup(t *testing.T) *testConfig {
+	logger := &types_helper.LoggerMock{
+		Logging: []string{},
+		T:       t,
+	}
+	return &testConfig{
+		t:       t,
+		hconfig: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
+		cache: &ing_helper.CacheMock{
+			SvcList: []*api.Service{},
+			EpList:  map[string]*api.Endpoints{},
+			NsList:  map[string]*api.Namespace{},
+		},
+		options: &ingtypes.ConverterOptions{},
+		logger:  logger,
+	}
+}
+
+func (c *testConfig) teardown() {
+	c.compareLogging("")
+}
+
+func (c *testConfig) createSvc(name string, port, targetPort int, endpoints string) {
+	sname := strings.Split(name, "/")
+	svc := &api.Service{
+		ObjectMeta: meta.ObjectMeta{Namespace: sname[0], Name: sname[1]},
+		Spec: api.ServiceSpec{
+			Ports: []api.ServicePort{{
+				Port:       int32(port),
+				TargetPort: intstr.FromInt(targetPort),
+			}},
+		},
+	}
+	ep := &api.Endpoints{
+		ObjectMeta: meta.ObjectMeta{Namespace: sname[0], Name: sname[1]},
+		Subsets: []api.EndpointSubset{{
+			Ports: []api.EndpointPort{{Port: int32(targetPort), Protocol: api.ProtocolTCP}},
+		}},
+	}
+	for _, ip := range strings.Split(endpoints, ",") {
+		ep.Subsets[0].Addresses = append(ep.Subsets[0].Addresses, api.EndpointAddress{IP: ip})
+	}
+	c.cache.SvcList = append(c.cache.SvcList, svc)
+	c.cache.EpList[name] = ep
+}
+
+func (c *testConfig) createNamespace(name string, labels map[string]string) {
+	c.cache.NsList[name] = &api.Namespace{
+		ObjectMeta: meta.ObjectMeta{Name: name, Labels: labels},
+	}
+}
+
+func (c *testConfig) sync(resources string) {
+	res := &Resources{}
+	var raw struct {
+		Gateways   []*Gateway        `json:"gateways"`
+		HTTPRoutes []*HTTPRoute      `json:"httproutes"`
+		TLSRoutes  []*TLSRoute       `json:"tlsroutes"`
+		TCPRoutes  []*TCPRoute       `json:"tcproutes"`
+		Grants     []*ReferenceGrant `json:"referencegrants"`
+	}
+	if err := yaml.Unmarshal([]byte(resources), &raw); err != nil {
+		c.t.Fatalf("error parsing resources: %v", err)
+	}
+	res.Gateways = raw.Gateways
+	res.HTTPRoutes = raw.HTTPRoutes
+	res.TLSRoutes = raw.TLSRoutes
+	res.TCPRoutes = raw.TCPRoutes
+	res.ReferenceGrants = raw.Grants
+	c.options.Cache = c.cache
+	c.options.Logger = c.logger
+	c.converter = NewGatewayConverter(c.options, c.hconfig)
+	c.converter.Sync(res)
+}
+
+func (c *testConfig) compareHosts(expected string) {
+	var actual []string
+	for _, host := range c.hconfig.Hosts() {
+		for _, path := range host.Paths {
+			var endpoints []string
+			for _, ep := range path.Backend.Endpoints {
+				endpoints = append(endpoints, ep.Name)
+			}
+			line := fmt.Sprintf("%s %s %s %v", host.Hostname, path.Path, path.Backend.ID, endpoints)
+			if host.SSLPassthrough {
+				line += " passthrough"
+			}
+			if host.TLS.TLSFilename != "" {
+				line += " tls=" + host.TLS.TLSFilename
+			}
+			actual = append(actual, line)
+		}
+	}
+	c.compareText(strings.Join(actual, "\n"), expected)
+}
+
+func (c *testConfig) compareTCPServices(expected string) {
+	var actual []string
+	for _, tcpPort := range c.hconfig.TCPServicePorts() {
+		for _, svc := range tcpPort.Services {
+			var endpoints []string
+			for _, ep := range svc.Backend.Endpoints {
+				endpoints = append(endpoints, ep.Name)
+			}
+			actual = append(actual, fmt.Sprintf("%d %s %v %s", tcpPort.Port, svc.Backend.ID, endpoints, svc.Source))
+		}
+	}
+	c.compareText(strings.Join(actual, "\n"), expected)
+}
+
+func (c *testConfig) compareStatus(expected string) {
+	condition := func(cond Condition) string {
+		if cond.Status {
+			return "true"
+		}
+		return "false/" + cond.Reason
+	}
+	var actual []string
+	for _, st := range c.converter.RouteStatus() {
+		actual = append(actual, fmt.Sprintf("%s %s/%s %s/%s Accepted=%s ResolvedRefs=%s",
+			st.Kind, st.Route.Namespace, st.Route.Name, st.Gateway.Namespace, st.Gateway.Name,
+			condition(st.Accepted), condition(st.ResolvedRefs)))
+	}
+	c.compareText(strings.Join(actual, "\n"), expected)
+}
+
+func (c *testConfig) compareLogging(expected string) {
+	c.compareText(strings.Join(c.logger.Logging, "\n"), expected)
+	c.logger.Logging = []string{}
+}
+
+func (c *testConfig) compareText(actual, expected string) {
+	txt1 := "\n" + strings.Trim(expected, "\n")
+	txt2 := "\n" + strings.Trim(actual, "\n")
+	if txt1 != txt2 {
+		c.t.Error(diff.Diff(txt1, txt2))
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The types below are a subset of the Gateway API resources, only the
+// fields used by the converter are declared. Json tags follow the API,
+// so list items read from the apiserver can be unmarshaled verbatim.
+
+// Resources ...
+type Resources struct {
+	Gateways   []*Gateway
+	HTTPRoutes []*HTTPRoute
+	TLSRoutes  []*TLSRoute
+	TCPRoutes  []*TCPRoute
+	// ReferenceGrants allow routes and gateways to reference
+	// services and secrets of another namespace
+	ReferenceGrants []*ReferenceGrant
+}
+
+// ObjectMeta ...
+type ObjectMeta struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+}
+
+// Gateway ...
+type Gateway struct {
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     GatewaySpec `json:"spec"`
+}
+
+// GatewaySpec ...
+type GatewaySpec struct {
+	GatewayClassName string     `json:"gatewayClassName"`
+	Listeners        []Listener `json:"listeners"`
+}
+
+// Listener ...
+type Listener struct {
+	Name          string         `json:"name"`
+	Hostname      string         `json:"hostname"`
+	Port          int            `json:"port"`
+	Protocol      string         `json:"protocol"`
+	TLS           *ListenerTLS   `json:"tls"`
+	AllowedRoutes *AllowedRoutes `json:"allowedRoutes"`
+}
+
+// AllowedRoutes ...
+type AllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces"`
+}
+
+// RouteNamespaces ...
+type RouteNamespaces struct {
+	// From is one of Same (default), All or Selector
+	From     string              `json:"from"`
+	Selector *meta.LabelSelector `json:"selector"`
+}
+
+// ListenerTLS ...
+type ListenerTLS struct {
+	Mode            string      `json:"mode"`
+	CertificateRefs []ObjectRef `json:"certificateRefs"`
+}
+
+// ObjectRef ...
+type ObjectRef struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	SectionName string `json:"sectionName"`
+}
+
+// BackendRef ...
+type BackendRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+	Weight    *int   `json:"weight"`
+}
+
+// HTTPRoute ...
+type HTTPRoute struct {
+	Metadata ObjectMeta    `json:"metadata"`
+	Spec     HTTPRouteSpec `json:"spec"`
+}
+
+// HTTPRouteSpec ...
+type HTTPRouteSpec struct {
+	ParentRefs []ObjectRef     `json:"parentRefs"`
+	Hostnames  []string        `json:"hostnames"`
+	Rules      []HTTPRouteRule `json:"rules"`
+}
+
+// HTTPRouteRule ...
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch `json:"matches"`
+	BackendRefs []BackendRef     `json:"backendRefs"`
+}
+
+// HTTPRouteMatch ...
+type HTTPRouteMatch struct {
+	Path *HTTPPathMatch `json:"path"`
+}
+
+// HTTPPathMatch ...
+type HTTPPathMatch struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// TLSRoute ...
+type TLSRoute struct {
+	Metadata ObjectMeta   `json:"metadata"`
+	Spec     TLSRouteSpec `json:"spec"`
+}
+
+// TLSRouteSpec ...
+type TLSRouteSpec struct {
+	ParentRefs []ObjectRef `json:"parentRefs"`
+	Hostnames  []string    `json:"hostnames"`
+	Rules      []RouteRule `json:"rules"`
+}
+
+// TCPRoute ...
+type TCPRoute struct {
+	Metadata ObjectMeta   `json:"metadata"`
+	Spec     TCPRouteSpec `json:"spec"`
+}
+
+// TCPRouteSpec ...
+type TCPRouteSpec struct {
+	ParentRefs []ObjectRef `json:"parentRefs"`
+	Rules      []RouteRule `json:"rules"`
+}
+
+// RouteRule ...
+type RouteRule struct {
+	BackendRefs []BackendRef `json:"backendRefs"`
+}
+
+// ReferenceGrant ...
+type ReferenceGrant struct {
+	Metadata ObjectMeta         `json:"metadata"`
+	Spec     ReferenceGrantSpec `json:"spec"`
+}
+
+// ReferenceGrantSpec ...
+type ReferenceGrantSpec struct {
+	From []ReferenceGrantFrom `json:"from"`
+	To   []ReferenceGrantTo   `json:"to"`
+}
+
+// ReferenceGrantFrom ...
+type ReferenceGrantFrom struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo ...
+type ReferenceGrantTo struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+// RouteStatus is the outcome of a route attached to a gateway of the
+// configured class, used to update the status of the route resource
+type RouteStatus struct {
+	Kind         string
+	Route        ObjectMeta
+	Gateway      ObjectMeta
+	Accepted     Condition
+	ResolvedRefs Condition
+}
+
+// Condition ...
+type Condition struct {
+	Status  bool
+	Reason  string
+	Message string
+}
@@ -20,8 +20,12 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
 
 	ingutils "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/utils"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
@@ -64,25 +68,78 @@ func (c *updater) buildBackendAuthHTTP(d *backData) {
 		c.logger.Error("missing secret name on basic authentication on %v", d.ann.Source)
 		return
 	}
-	secretName := ingutils.FullQualifiedName(d.ann.Source.Namespace, d.ann.AuthSecret)
-	listName := strings.Replace(secretName, "/", "_", 1)
+	var sourceNames, listNames []string
+	for _, source := range utils.Split(d.ann.AuthSecret, ",") {
+		if source == "" {
+			continue
+		}
+		sourceNames = append(sourceNames, source)
+		listNames = append(listNames, strings.NewReplacer(":", "-", "/", "_").Replace(source))
+	}
+	listName := d.ann.Source.Namespace + "_" + strings.Join(listNames, "_")
+	c.userlistMutex.Lock()
+	defer c.userlistMutex.Unlock()
 	userlist := c.haproxy.FindUserlist(listName)
 	if userlist == nil {
-		userb, err := c.cache.GetSecretContent(secretName, "auth")
-		if err != nil {
-			c.logger.Error("error reading basic authentication on %v: %v", d.ann.Source, err)
-			return
+		var users []hatypes.User
+		var groups []hatypes.UserGroup
+		var found bool
+		for _, source := range sourceNames {
+			var userb []byte
+			var err error
+			var sourceDesc string
+			var readContent func(name, key string) ([]byte, error)
+			var name string
+			if strings.HasPrefix(source, "configmap:") {
+				name = ingutils.FullQualifiedName(d.ann.Source.Namespace, strings.TrimPrefix(source, "configmap:"))
+				sourceDesc = "configmap '" + name + "'"
+				readContent = c.cache.GetConfigMapContent
+			} else {
+				name, err = ingutils.SecretName(c.options, c.cache, d.ann.Source.Namespace, strings.TrimPrefix(source, "secret:"))
+				if err != nil {
+					c.logger.Error("error reading basic authentication on %v: %v", d.ann.Source, err)
+					continue
+				}
+				sourceDesc = "secret '" + name + "'"
+				readContent = c.cache.GetSecretContent
+			}
+			userb, err = readContent(name, "auth")
+			if err != nil {
+				c.logger.Error("error reading basic authentication on %v: %v", d.ann.Source, err)
+				continue
+			}
+			found = true
+			userstr := string(userb)
+			sourceUsers, errs := c.buildBackendAuthHTTPExtractUserlist(d.ann.Source.Name, sourceDesc, userstr)
+			for _, err := range errs {
+				c.logger.Warn("ignoring malformed usr/passwd on %s, declared on %v: %v", sourceDesc, d.ann.Source, err)
+			}
+			users = c.buildBackendAuthHTTPMergeUsers(d, sourceDesc, users, sourceUsers)
+			// groups is optional, an htpasswd-group like content
+			if groupb, err := readContent(name, "groups"); err == nil {
+				groups = buildBackendAuthHTTPMergeGroups(groups, buildBackendAuthHTTPExtractGroups(string(groupb)))
+			}
 		}
-		userstr := string(userb)
-		users, errs := c.buildBackendAuthHTTPExtractUserlist(d.ann.Source.Name, secretName, userstr)
-		for _, err := range errs {
-			c.logger.Warn("ignoring malformed usr/passwd on secret '%s', declared on %v: %v", secretName, d.ann.Source, err)
+		if !found {
+			return
 		}
 		userlist = c.haproxy.AddUserlist(listName, users)
+		userlist.Groups = c.buildBackendAuthHTTPCheckGroups(d, users, groups)
 		if len(users) == 0 {
 			c.logger.Warn("userlist on %v for basic authentication is empty", d.ann.Source)
 		}
 	}
+	for _, group := range utils.Split(d.ann.AuthGroupsAllowed, ",") {
+		if group == "" {
+			continue
+		}
+		if !userlist.HasGroup(group) {
+			// an empty group doesn't match any user but keeps HAProxy config valid
+			c.logger.Warn("group '%s' not found on userlist, declared on %v", group, d.ann.Source)
+			userlist.Groups = append(userlist.Groups, hatypes.UserGroup{Name: group})
+		}
+		d.backend.Userlist.Groups = append(d.backend.Userlist.Groups, group)
+	}
 	d.backend.Userlist.Name = userlist.Name
 	realm := "localhost" // HAProxy's backend name would be used if missing
 	if strings.Index(d.ann.AuthRealm, `"`) >= 0 {
@@ -93,6 +150,96 @@ func (c *updater) buildBackendAuthHTTP(d *backData) {
 	d.backend.Userlist.Realm = realm
 }
 
+func (c *updater) buildBackendAuthHTTPMergeUsers(d *backData, sourceDesc string, users, newUsers []hatypes.User) []hatypes.User {
+	for _, newUser := range newUsers {
+		duplicated := false
+		for _, user := range users {
+			if user.Name == newUser.Name {
+				duplicated = true
+				if user.Passwd != newUser.Passwd || user.Encrypted != newUser.Encrypted {
+					c.logger.Warn("skipping user '%s' from %s, declared on %v: user was already declared with another password",
+						newUser.Name, sourceDesc, d.ann.Source)
+				}
+				break
+			}
+		}
+		if !duplicated {
+			users = append(users, newUser)
+		}
+	}
+	return users
+}
+
+// buildBackendAuthHTTPExtractGroups parses an htpasswd-group like
+// content: one `group: user1 user2 ...` per line
+func buildBackendAuthHTTPExtractGroups(content string) []hatypes.UserGroup {
+	var groups []hatypes.UserGroup
+	for _, line := range strings.Split(content, "\n") {
+		sep := strings.Index(line, ":")
+		if sep <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:sep])
+		if name == "" {
+			continue
+		}
+		groups = append(groups, hatypes.UserGroup{
+			Name:  name,
+			Users: strings.Fields(line[sep+1:]),
+		})
+	}
+	return groups
+}
+
+func buildBackendAuthHTTPMergeGroups(groups, newGroups []hatypes.UserGroup) []hatypes.UserGroup {
+	for _, newGroup := range newGroups {
+		merged := false
+		for i := range groups {
+			if groups[i].Name == newGroup.Name {
+				groups[i].Users = append(groups[i].Users, newGroup.Users...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			groups = append(groups, newGroup)
+		}
+	}
+	return groups
+}
+
+func (c *updater) buildBackendAuthHTTPCheckGroups(d *backData, users []hatypes.User, groups []hatypes.UserGroup) []hatypes.UserGroup {
+	hasUser := func(name string) bool {
+		for _, user := range users {
+			if user.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range groups {
+		var groupUsers []string
+		for _, user := range groups[i].Users {
+			if !hasUser(user) {
+				c.logger.Warn("skipping user '%s' of group '%s' declared on %v: user not found", user, groups[i].Name, d.ann.Source)
+				continue
+			}
+			found := false
+			for _, u := range groupUsers {
+				if u == user {
+					found = true
+					break
+				}
+			}
+			if !found {
+				groupUsers = append(groupUsers, user)
+			}
+		}
+		groups[i].Users = groupUsers
+	}
+	return groups
+}
+
 func (c *updater) buildBackendAuthHTTPExtractUserlist(source, secret, users string) ([]hatypes.User, []error) {
 	var userlist []hatypes.User
 	var err []error
@@ -135,6 +282,75 @@ func (c *updater) buildBackendAuthHTTPExtractUserlist(source, secret, users stri
 	return userlist, err
 }
 
+var (
+	balanceRegex     = regexp.MustCompile(`^(roundrobin|static-rr|leastconn|first|source|random|uri( (whole|len [0-9]+|depth [0-9]+))*|url_param [A-Za-z0-9_.-]+( check_post)?|hdr\([A-Za-z0-9_-]+\)( use_domain_only)?|rdp-cookie(\([A-Za-z0-9_-]+\))?)$`)
+	balanceHTTPRegex = regexp.MustCompile(`^(uri|url_param|hdr\()`)
+)
+
+func (c *updater) buildBackendBalance(d *backData) {
+	balance := strings.TrimSpace(d.ann.BalanceAlgorithm)
+	if balance == "" {
+		balance = "roundrobin"
+	} else if !balanceRegex.MatchString(balance) {
+		c.logger.Warn("invalid balance algorithm '%s' on %v, using 'roundrobin' instead", balance, d.ann.Source)
+		balance = "roundrobin"
+	} else if d.backend.ModeTCP && balanceHTTPRegex.MatchString(balance) {
+		c.logger.Warn("balance algorithm '%s' on %v requires HTTP mode, using 'roundrobin' instead", balance, d.ann.Source)
+		balance = "roundrobin"
+	}
+	d.backend.BalanceAlgorithm = balance
+}
+
+var (
+	bandwidthSizeRegex   = regexp.MustCompile(`^[0-9]+[kmg]?$`)
+	bandwidthPeriodRegex = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+)
+
+func (c *updater) buildBackendBandwidthLimit(d *backData) {
+	download := d.ann.BandwidthLimitDown
+	upload := d.ann.BandwidthLimitUp
+	if download == "" && upload == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring bandwidth limit on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if download != "" && !bandwidthSizeRegex.MatchString(download) {
+		c.logger.Warn("ignoring invalid bandwidth-limit-download '%s' on %v", download, d.ann.Source)
+		download = ""
+	}
+	if upload != "" && !bandwidthSizeRegex.MatchString(upload) {
+		c.logger.Warn("ignoring invalid bandwidth-limit-upload '%s' on %v", upload, d.ann.Source)
+		upload = ""
+	}
+	if download == "" && upload == "" {
+		return
+	}
+	if !c.options.HAProxyVersion.AtLeast(2, 7) {
+		c.logger.Warn("ignoring bandwidth limit on %v: needs HAProxy 2.7 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		return
+	}
+	period := d.ann.BandwidthLimitPeriod
+	if period == "" {
+		period = "1s"
+	} else if !bandwidthPeriodRegex.MatchString(period) {
+		c.logger.Warn("invalid bandwidth-limit-period '%s' on %v, using '1s' instead", period, d.ann.Source)
+		period = "1s"
+	}
+	key := d.ann.BandwidthLimitKey
+	if key != "" && key != "stream" && key != "src" {
+		c.logger.Warn("invalid bandwidth-limit-key '%s' on %v, using 'stream' instead", key, d.ann.Source)
+		key = "stream"
+	}
+	d.backend.BandwidthLimit = hatypes.BandwidthLimit{
+		Download: download,
+		Upload:   upload,
+		Period:   period,
+		PerSrc:   key == "src",
+	}
+}
+
 func (c *updater) buildBackendBlueGreen(d *backData) {
 	balance := d.ann.BlueGreenBalance
 	if balance == "" {
@@ -314,10 +530,850 @@ func (c *updater) buildBackendCors(d *backData) {
 	}
 }
 
+// defaultResolver is created on demand, reading the nameservers
+// from the resolv.conf of the controller
+const defaultResolver = "k8s"
+
+// buildBackendDNS configures a resolver on backends whose servers are
+// declared by hostname instead of IP, eg ExternalName services
+func (c *updater) buildBackendDrainTimeout(d *backData) {
+	if d.ann.DrainSupportTimeout == "" {
+		return
+	}
+	timeout, err := time.ParseDuration(d.ann.DrainSupportTimeout)
+	if err != nil || timeout < 0 {
+		c.logger.Warn("ignoring invalid drain-support-timeout on %v: %s", d.ann.Source, d.ann.DrainSupportTimeout)
+		return
+	}
+	endpoints := make([]*hatypes.Endpoint, 0, len(d.backend.Endpoints))
+	for _, ep := range d.backend.Endpoints {
+		if ep.Weight == 0 && ep.TargetRef != "" {
+			// draining endpoint, removed if its pod was deleted before the timeout
+			pod, err := c.cache.GetPod(ep.TargetRef)
+			if err == nil && pod.DeletionTimestamp != nil && time.Since(pod.DeletionTimestamp.Time) > timeout {
+				continue
+			}
+		}
+		endpoints = append(endpoints, ep)
+	}
+	d.backend.Endpoints = endpoints
+}
+
+func (c *updater) buildBackendDNS(d *backData) {
+	var hasHostname bool
+	for _, ep := range d.backend.Endpoints {
+		if net.ParseIP(ep.IP) == nil {
+			hasHostname = true
+			break
+		}
+	}
+	if !hasHostname {
+		return
+	}
+	resolver, err := c.findBackendResolver(d)
+	if err != nil {
+		c.logger.Warn("skipping servers of %v: %v", d.ann.Source, err)
+		d.backend.Endpoints = nil
+		return
+	}
+	d.backend.Resolver = resolver
+}
+
+var dnsSRVRegex = regexp.MustCompile(`^_[A-Za-z0-9-]+\._[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+\.?$`)
+
+// buildBackendDNSSRV adds servers discovered from the SRV records of a
+// domain, resolved by HAProxy, alongside the endpoints of the service
+func (c *updater) buildBackendDNSSRV(d *backData) {
+	if d.ann.DNSSRVRecord == "" {
+		return
+	}
+	if !dnsSRVRegex.MatchString(d.ann.DNSSRVRecord) {
+		c.logger.Warn("ignoring invalid dns-srv-record on %v: %s", d.ann.Source, d.ann.DNSSRVRecord)
+		return
+	}
+	slots := d.ann.DNSSRVSlots
+	if slots == 0 {
+		slots = defaultDNSSRVSlots
+	} else if slots < 0 {
+		c.logger.Warn("invalid dns-srv-slots '%d' on %v, using '%d' instead", slots, d.ann.Source, defaultDNSSRVSlots)
+		slots = defaultDNSSRVSlots
+	}
+	resolver, err := c.findBackendResolver(d)
+	if err != nil {
+		c.logger.Warn("ignoring dns-srv-record on %v: %v", d.ann.Source, err)
+		return
+	}
+	d.backend.DNSSRV = hatypes.DNSSRVConfig{
+		Record:   d.ann.DNSSRVRecord,
+		Resolver: resolver,
+		Slots:    slots,
+	}
+}
+
+const defaultDNSSRVSlots = 10
+
+// findBackendResolver returns the resolver of the use-resolver annotation,
+// or the default resolver which is created on demand
+func (c *updater) findBackendResolver(d *backData) (string, error) {
+	c.resolverMutex.Lock()
+	defer c.resolverMutex.Unlock()
+	dns := &c.haproxy.Global().DNS
+	resolver := d.ann.UseResolver
+	if resolver != "" {
+		if dns.FindResolver(resolver) != nil {
+			return resolver, nil
+		}
+		c.logger.Warn("ignoring use-resolver on %v: DNS resolver '%s' not found", d.ann.Source, resolver)
+	}
+	if dns.FindResolver(defaultResolver) == nil {
+		if !c.options.HAProxyVersion.AtLeast(2, 0) {
+			return "", fmt.Errorf("the default DNS resolver needs HAProxy 2.0 or newer, found version %s. Use a resolver declared in dns-resolvers instead", c.options.HAProxyVersion)
+		}
+		dns.Resolvers = append(dns.Resolvers, &hatypes.DNSResolver{
+			Name:            defaultResolver,
+			ParseResolvConf: true,
+		})
+	}
+	return defaultResolver, nil
+}
+
+func (c *updater) buildBackendFailoverCluster(d *backData) {
+	mode := d.ann.FailoverCluster
+	if mode == "" {
+		return
+	}
+	if mode != "backup" && mode != "weight" {
+		c.logger.Warn("ignoring invalid failover-cluster mode '%s' on %v", mode, d.ann.Source)
+		return
+	}
+	weight := d.ann.FailoverClusterWeight
+	if mode == "backup" {
+		weight = 1
+	} else if weight < 0 {
+		c.logger.Warn("invalid failover-cluster-weight '%d' on %v, using '0' instead", weight, d.ann.Source)
+		weight = 0
+	} else if weight > 256 {
+		c.logger.Warn("invalid failover-cluster-weight '%d' on %v, using '256' instead", weight, d.ann.Source)
+		weight = 256
+	}
+	serviceName := d.backend.Namespace + "/" + d.backend.Name
+	endpoints, err := c.cache.GetRemoteEndpoints(serviceName)
+	if err != nil {
+		c.logger.Warn("skipping failover cluster endpoints on %v: %v", d.ann.Source, err)
+		return
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Protocol != api.ProtocolTCP ||
+				(port.Name != d.backend.Port && strconv.Itoa(int(port.Port)) != d.backend.Port) {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				name := fmt.Sprintf("%s:%d", addr.IP, port.Port)
+				if d.backend.FindEndpoint(name) != nil {
+					c.logger.Warn("skipping failover cluster endpoint '%s' on %v: endpoint already declared on the local cluster", name, d.ann.Source)
+					continue
+				}
+				ep := d.backend.NewEndpoint(addr.IP, int(port.Port), "")
+				ep.Backup = mode == "backup"
+				ep.Weight = weight
+			}
+		}
+	}
+}
+
+var forwardRegex = regexp.MustCompile(`^(add|ignore|ifmissing|update)$`)
+
+func (c *updater) buildBackendForwardFor(d *backData) {
+	if forwardRegex.MatchString(d.ann.Forwardfor) {
+		d.backend.ForwardFor = d.ann.Forwardfor
+	} else {
+		if d.ann.Forwardfor != "" {
+			c.logger.Warn("invalid forwardfor value '%s' on %v, using 'add' instead", d.ann.Forwardfor, d.ann.Source)
+		}
+		d.backend.ForwardFor = "add"
+	}
+	for _, header := range utils.Split(d.ann.ForwardedHeaders, ",") {
+		switch header {
+		case "host":
+			d.backend.ForwardedHeaders.Host = true
+		case "port":
+			d.backend.ForwardedHeaders.Port = true
+		case "proto":
+			d.backend.ForwardedHeaders.Proto = true
+		default:
+			c.logger.Warn("ignoring invalid header '%s' in forwarded-headers on %v", header, d.ann.Source)
+		}
+	}
+}
+
+func (c *updater) buildBackendGlobalRateLimit(d *backData) {
+	limit := d.ann.GlobalRateLimit
+	if limit == 0 {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring global-rate-limit on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if limit < 0 {
+		c.logger.Warn("ignoring invalid global-rate-limit '%d' on %v", limit, d.ann.Source)
+		return
+	}
+	period := d.ann.GlobalRateLimitPeriod
+	if period == "" {
+		period = "1s"
+	} else if !bandwidthPeriodRegex.MatchString(period) {
+		c.logger.Warn("invalid global-rate-limit-period '%s' on %v, using '1s' instead", period, d.ann.Source)
+		period = "1s"
+	}
+	var whitelist []string
+	for _, cidr := range utils.Split(d.ann.LimitWhitelist, ",") {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			c.logger.Warn("skipping invalid cidr '%s' in limit-whitelist on %v", cidr, d.ann.Source)
+		} else {
+			whitelist = append(whitelist, cidr)
+		}
+	}
+	// every replica tracks the requests in its own table, which is synchronized
+	// with the other replicas, so the rates of all the tables can be summed.
+	// Peers only replicate the entries, they don't aggregate the counters.
+	prefix := "_rl_" + d.backend.ID
+	rateLimit := hatypes.RateLimitConfig{
+		Limit:     limit,
+		Period:    period,
+		Whitelist: whitelist,
+	}
+	peers := c.haproxy.Global().Peers
+	if len(peers.Peers) > 0 && peers.LocalPeer != "" {
+		rateLimit.Peers = peers.Name
+		rateLimit.Track = prefix + "_" + peers.LocalPeer
+		local := false
+		for _, peer := range peers.Peers {
+			rateLimit.Tables = append(rateLimit.Tables, prefix+"_"+peer.Name)
+			local = local || peer.Name == peers.LocalPeer
+		}
+		if !local {
+			rateLimit.Tables = append(rateLimit.Tables, rateLimit.Track)
+			sort.Strings(rateLimit.Tables)
+		}
+	} else {
+		if len(peers.Peers) > 0 {
+			c.logger.Warn("global-rate-limit on %v is applied per replica: the name of the local peer is unknown, declare the POD_NAME environment variable", d.ann.Source)
+		}
+		rateLimit.Track = prefix
+		rateLimit.Tables = []string{prefix}
+	}
+	d.backend.RateLimit = rateLimit
+}
+
+var logCaptureHeaderRegex = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+func (c *updater) buildBackendLogCapture(d *backData) {
+	readHeaders := func(annName, headers string) []string {
+		var names []string
+		for _, header := range utils.Split(headers, ",") {
+			if !logCaptureHeaderRegex.MatchString(header) {
+				c.logger.Warn("skipping invalid header name '%s' in %s on %v", header, annName, d.ann.Source)
+				continue
+			}
+			if !stringInSliceFold(header, names) {
+				names = append(names, header)
+			}
+		}
+		return names
+	}
+	if d.ann.LogCaptureReqHeaders == "" && d.ann.LogCaptureResHeaders == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring log-capture headers on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if c.haproxy.Global().Syslog.Endpoint == "" {
+		c.logger.Warn("ignoring log-capture headers on %v: syslog-endpoint is not configured", d.ann.Source)
+		return
+	}
+	d.backend.LogCapture = hatypes.LogCaptureConfig{
+		RequestHeaders:  readHeaders("log-capture-request-headers", d.ann.LogCaptureReqHeaders),
+		ResponseHeaders: readHeaders("log-capture-response-headers", d.ann.LogCaptureResHeaders),
+	}
+}
+
+func stringInSliceFold(s string, slice []string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *updater) buildBackendLogFields(d *backData) {
+	fields := strings.TrimSpace(d.ann.LogFields)
+	if fields == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring log-fields on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if strings.ContainsAny(fields, "\"\r\n") {
+		c.logger.Warn("ignoring log-fields on %v: quotes and line breaks are not allowed", d.ann.Source)
+		return
+	}
+	d.backend.LogFields = fields
+}
+
+var luaActionRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func (c *updater) buildBackendLua(d *backData) {
+	readActions := func(annName, actions string) []string {
+		var names []string
+		for _, action := range utils.Split(actions, ",") {
+			if !luaActionRegex.MatchString(action) {
+				c.logger.Warn("skipping invalid Lua action '%s' in %s on %v", action, annName, d.ann.Source)
+				continue
+			}
+			names = append(names, action)
+		}
+		return names
+	}
+	if d.ann.LuaRequestAction == "" && d.ann.LuaResponseAction == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring Lua actions on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if len(c.haproxy.Global().LuaScripts) == 0 {
+		c.logger.Warn("ignoring Lua actions on %v: lua-scripts is not configured", d.ann.Source)
+		return
+	}
+	d.backend.Lua = hatypes.LuaActionsConfig{
+		RequestActions:  readActions("lua-request-action", d.ann.LuaRequestAction),
+		ResponseActions: readActions("lua-response-action", d.ann.LuaResponseAction),
+	}
+}
+
+const (
+	defaultCacheMaxAge       = 60
+	defaultCacheTotalMaxSize = 4
+	maxCacheTotalMaxSize     = 4095
+)
+
+func (c *updater) buildBackendCache(d *backData) {
+	if !d.ann.CacheEnable {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring cache on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	maxAge := d.ann.CacheMaxAge
+	if maxAge < 0 {
+		c.logger.Warn("invalid cache-max-age '%d' on %v, using '%d' instead", maxAge, d.ann.Source, defaultCacheMaxAge)
+		maxAge = defaultCacheMaxAge
+	} else if maxAge == 0 {
+		maxAge = defaultCacheMaxAge
+	}
+	// total-max-size is declared in megabytes, up to 4095, and HAProxy
+	// refuses objects bigger than half of the cache size
+	const mb = 1024 * 1024
+	var maxObjectSize int64
+	if d.ann.CacheMaxObjectSize != "" {
+		size, err := utils.SizeSuffixToInt64(d.ann.CacheMaxObjectSize)
+		if err != nil || size <= 0 {
+			c.logger.Warn("ignoring invalid cache-max-object-size '%s' on %v", d.ann.CacheMaxObjectSize, d.ann.Source)
+		} else if 2*size > maxCacheTotalMaxSize*mb {
+			c.logger.Warn("ignoring cache-max-object-size '%s' on %v: objects should be up to half of the maximum cache size of %d megabytes",
+				d.ann.CacheMaxObjectSize, d.ann.Source, maxCacheTotalMaxSize)
+		} else if !c.options.HAProxyVersion.AtLeast(1, 9) {
+			c.logger.Warn("ignoring cache-max-object-size on %v: needs HAProxy 1.9 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		} else {
+			maxObjectSize = size
+		}
+	}
+	totalMaxSize := int64(defaultCacheTotalMaxSize)
+	if size := (2*maxObjectSize + mb - 1) / mb; size > totalMaxSize {
+		totalMaxSize = size
+	}
+	d.backend.Cache = hatypes.CacheConfig{
+		Name:          "_cache_" + d.backend.ID,
+		MaxAge:        maxAge,
+		MaxObjectSize: maxObjectSize,
+		TotalMaxSize:  totalMaxSize,
+	}
+}
+
+var (
+	compressionTypeRegex    = regexp.MustCompile(`^[A-Za-z0-9.+-]+/[A-Za-z0-9.+*-]+$`)
+	defaultCompressionTypes = []string{"text/html", "text/plain", "text/css", "application/javascript", "application/json"}
+)
+
+func (c *updater) buildBackendCompression(d *backData) {
+	if !d.ann.Compression {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring compression on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	var types []string
+	for _, mime := range strings.Fields(strings.Replace(d.ann.CompressionTypes, ",", " ", -1)) {
+		if !compressionTypeRegex.MatchString(mime) {
+			c.logger.Warn("skipping invalid compression type '%s' on %v", mime, d.ann.Source)
+			continue
+		}
+		types = append(types, mime)
+	}
+	if len(types) == 0 {
+		types = defaultCompressionTypes
+	}
+	var minSize int64
+	if d.ann.CompressionMinSize != "" {
+		size, err := utils.SizeSuffixToInt64(d.ann.CompressionMinSize)
+		if err != nil || size < 0 {
+			c.logger.Warn("ignoring invalid compression-min-size '%s' on %v", d.ann.CompressionMinSize, d.ann.Source)
+		} else if !c.options.HAProxyVersion.AtLeast(2, 8) {
+			c.logger.Warn("ignoring compression-min-size on %v: needs HAProxy 2.8 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		} else {
+			minSize = size
+		}
+	}
+	d.backend.Compression = hatypes.CompressionConfig{
+		Algo:    "gzip",
+		MinSize: minSize,
+		Types:   types,
+	}
+}
+
+func (c *updater) buildBackendSPOE(d *backData) {
+	if d.ann.SPOEFilter == "" {
+		return
+	}
+	agents := map[string]bool{}
+	for _, agent := range c.haproxy.Global().SPOEAgents {
+		agents[agent.Name] = true
+	}
+	added := map[string]bool{}
+	for _, filter := range utils.Split(d.ann.SPOEFilter, ",") {
+		if !agents[filter] {
+			c.logger.Warn("skipping SPOE filter '%s' on %v: agent not found", filter, d.ann.Source)
+			continue
+		}
+		if !added[filter] {
+			added[filter] = true
+			d.backend.SPOEFilters = append(d.backend.SPOEFilters, filter)
+		}
+	}
+}
+
+var (
+	hashTypeRegex = regexp.MustCompile(`^(map-based|consistent)( (sdbm|djb2|wt6|crc32))?( avalanche)?$`)
+)
+
+func (c *updater) buildBackendHash(d *backData) {
+	if d.ann.HashType != "" {
+		if hashTypeRegex.MatchString(d.ann.HashType) {
+			d.backend.HashType = d.ann.HashType
+		} else {
+			c.logger.Warn("ignoring invalid hash-type '%s' on %v", d.ann.HashType, d.ann.Source)
+		}
+	}
+	if factor := d.ann.HashBalanceFactor; factor != 0 {
+		if !strings.HasPrefix(d.backend.HashType, "consistent") {
+			c.logger.Warn("ignoring hash-balance-factor on %v: hash-type should be consistent", d.ann.Source)
+		} else if factor < 100 {
+			c.logger.Warn("invalid hash-balance-factor '%d' on %v, using '100' instead", factor, d.ann.Source)
+			d.backend.HashBalanceFactor = 100
+		} else {
+			d.backend.HashBalanceFactor = factor
+		}
+	}
+}
+
+func (c *updater) buildBackendHTTPConnMode(d *backData) {
+	switch d.ann.HTTPConnectionMode {
+	case "", "http-keep-alive":
+		// default mode, declared in the defaults section
+	case "http-server-close", "httpclose":
+		d.backend.HTTPConnMode = d.ann.HTTPConnectionMode
+	default:
+		c.logger.Warn("ignoring invalid http-connection-mode '%s' on %v", d.ann.HTTPConnectionMode, d.ann.Source)
+	}
+}
+
+// defaultBufSize is the default tune.bufsize of HAProxy
+const defaultBufSize = 16384
+
+func (c *updater) buildBackendHTTPBufferRequest(d *backData) {
+	if !d.ann.HTTPBufferRequest {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring http-buffer-request on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if size, err := utils.SizeSuffixToInt64(d.ann.ProxyBodySize); err == nil && size > defaultBufSize {
+		c.logger.Warn("http-buffer-request on %v only waits for the first %d bytes of the body, proxy-body-size '%s' allows bigger requests",
+			d.ann.Source, defaultBufSize, d.ann.ProxyBodySize)
+	}
+	d.backend.HTTPBufferRequest = true
+}
+
+func (c *updater) buildBackendHTTPReuse(d *backData) {
+	if d.ann.DisableH2Reuse {
+		if d.backend.ModeTCP {
+			c.logger.Warn("ignoring disable-h2-reuse on %v: backend is in TCP mode", d.ann.Source)
+			return
+		}
+		if d.ann.HTTPReuse != "" && d.ann.HTTPReuse != "never" {
+			c.logger.Warn("ignoring http-reuse '%s' on %v: disable-h2-reuse is also configured", d.ann.HTTPReuse, d.ann.Source)
+		}
+		d.backend.HTTPReuse = "never"
+		return
+	}
+	if d.ann.HTTPReuse == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring http-reuse on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	switch d.ann.HTTPReuse {
+	case "never", "safe", "aggressive", "always":
+		d.backend.HTTPReuse = d.ann.HTTPReuse
+	default:
+		c.logger.Warn("ignoring invalid http-reuse '%s' on %v", d.ann.HTTPReuse, d.ann.Source)
+	}
+}
+
+var keepAliveTimeoutRegex = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+
+// buildBackendHTTPKeepAliveTimeout configures how long idle server side
+// connections are kept in the pool, the client side is configured by
+// timeout-keep-alive
+func (c *updater) buildBackendHTTPKeepAliveTimeout(d *backData) {
+	timeout := d.ann.HTTPKeepAliveTimeout
+	if timeout == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring http-keep-alive-timeout on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if !keepAliveTimeoutRegex.MatchString(timeout) {
+		c.logger.Warn("ignoring invalid http-keep-alive-timeout '%s' on %v", timeout, d.ann.Source)
+		return
+	}
+	if !c.options.HAProxyVersion.AtLeast(1, 9) {
+		c.logger.Warn("ignoring http-keep-alive-timeout on %v: needs HAProxy 1.9 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		return
+	}
+	d.backend.PoolPurgeDelay = timeout
+}
+
+func (c *updater) buildBackendSecurityExempt(d *backData) {
+	if d.ann.ExcludePathsSecurity == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring exclude-paths-from-security on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	exempt := &d.backend.SecurityExempt
+	for _, path := range utils.Split(d.ann.ExcludePathsSecurity, ",") {
+		if path == "" {
+			continue
+		}
+		prefix := strings.HasSuffix(path, "*")
+		path = strings.TrimSuffix(path, "*")
+		if !oauthSkipPathRegex.MatchString(path) || dotSegmentRegex.MatchString(path) {
+			c.logger.Warn("ignoring invalid path '%s' of exclude-paths-from-security on %v", path, d.ann.Source)
+			continue
+		}
+		if prefix {
+			// prefixes match on a path segment boundary: `/status*`
+			// exempts `/status` and `/status/*` but not `/statusx`
+			if !strings.HasSuffix(path, "/") {
+				exempt.Exact = append(exempt.Exact, path)
+				path += "/"
+			}
+			exempt.Prefix = append(exempt.Prefix, path)
+		} else {
+			exempt.Exact = append(exempt.Exact, path)
+		}
+	}
+}
+
+func (c *updater) buildBackendSecureSPIFFE(d *backData) {
+	if !d.ann.SecureSPIFFE {
+		return
+	}
+	svid, bundle := c.options.SPIFFESVIDFile, c.options.SPIFFEBundleFile
+	if svid.Filename == "" || bundle.Filename == "" {
+		c.logger.Warn("ignoring secure-spiffe on %v: SVID is not available, either the SPIFFE Workload API is not configured or the SVID was not received yet", d.ann.Source)
+		return
+	}
+	ssl := &d.backend.SSL
+	ssl.IsSecure = true
+	ssl.CertFilename = svid.Filename
+	ssl.CertHash = svid.SHA1Hash
+	ssl.CAFilename = bundle.Filename
+	ssl.CAHash = bundle.SHA1Hash
+}
+
+func (c *updater) buildBackendSecureALPN(d *backData) {
+	if d.ann.SecureALPN == "" {
+		return
+	}
+	if !d.backend.SSL.IsSecure {
+		c.logger.Warn("ignoring secure-alpn on %v: backend does not use TLS", d.ann.Source)
+		return
+	}
+	if !alpnRegex.MatchString(d.ann.SecureALPN) {
+		c.logger.Warn("ignoring invalid secure-alpn on %v: %s", d.ann.Source, d.ann.SecureALPN)
+		return
+	}
+	d.backend.SSL.ALPN = d.ann.SecureALPN
+}
+
+func (c *updater) buildBackendProtocol(d *backData) {
+	switch d.ann.BackendProtocol {
+	case "", "h1":
+		return
+	case "h2":
+	default:
+		c.logger.Warn("ignoring invalid backend-protocol on %v: %s", d.ann.Source, d.ann.BackendProtocol)
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring backend-protocol on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if !c.options.HAProxyVersion.AtLeast(2, 0) {
+		c.logger.Warn("ignoring backend-protocol on %v: HTTP/2 backends need HAProxy 2.0 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		return
+	}
+	d.backend.ProtoH2 = true
+	if d.backend.SSL.IsSecure && d.backend.SSL.ALPN == "" {
+		// HTTP/2 over TLS needs to be negotiated
+		d.backend.SSL.ALPN = "h2"
+	}
+}
+
+func (c *updater) buildBackendServerNaming(d *backData) {
+	switch d.ann.ServerNaming {
+	case "", "ip":
+		// default, ip:port names from the endpoints
+	case "sequence":
+		for i, ep := range d.backend.Endpoints {
+			ep.Name = fmt.Sprintf("srv%03d", i+1)
+		}
+	case "pod":
+		used := make(map[string]bool, len(d.backend.Endpoints))
+		for _, ep := range d.backend.Endpoints {
+			used[ep.Name] = true
+		}
+		// dynamic updates need to know that server names cannot be reused
+		d.backend.ServerNaming = "pod"
+		for _, ep := range d.backend.Endpoints {
+			// TargetRef is namespace/podname, missing on eg failover or blue/green endpoints
+			pos := strings.Index(ep.TargetRef, "/")
+			if pos < 0 {
+				continue
+			}
+			name := ep.TargetRef[pos+1:]
+			if name == "" || used[name] {
+				// keep ip:port on duplicated pod names, eg distinct ports of the same pod
+				continue
+			}
+			used[name] = true
+			ep.Name = name
+		}
+	default:
+		c.logger.Warn("ignoring invalid server naming '%s' on %v", d.ann.ServerNaming, d.ann.Source)
+	}
+}
+
+func (c *updater) buildBackendTimeout(d *backData) {
+	// only timeouts distinct from the defaults section need to be declared
+	global := c.haproxy.Global().Timeout.BackendTimeoutConfig
+	copyBackendTime := func(dst *string, globalValue, src string) {
+		if src != globalValue {
+			copyHAProxyTime(dst, src)
+		}
+	}
+	copyBackendTime(&d.backend.Timeout.Connect, global.Connect, d.ann.TimeoutConnect)
+	copyBackendTime(&d.backend.Timeout.HTTPRequest, global.HTTPRequest, d.ann.TimeoutHTTPRequest)
+	copyBackendTime(&d.backend.Timeout.KeepAlive, global.KeepAlive, d.ann.TimeoutKeepAlive)
+	copyBackendTime(&d.backend.Timeout.Queue, global.Queue, d.ann.TimeoutQueue)
+	copyBackendTime(&d.backend.Timeout.Server, global.Server, d.ann.TimeoutServer)
+	copyBackendTime(&d.backend.Timeout.ServerFin, global.ServerFin, d.ann.TimeoutServerFin)
+	copyBackendTime(&d.backend.Timeout.Tunnel, global.Tunnel, d.ann.TimeoutTunnel)
+}
+
 var (
-	oauthHeaderRegex = regexp.MustCompile(`^[A-Za-z0-9-]+:[A-Za-z0-9-_]+$`)
+	backendVarNameRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	backendVarStaticRegex = regexp.MustCompile(`^[A-Za-z0-9_.:/@+-]+$`)
+	// arguments cannot have quotes, whitespaces, `#` or `\`, which would
+	// change how haproxy parses the remaining of the line
+	backendVarSampleRegex = regexp.MustCompile(`^([a-z0-9_.]+)(\([^()"'\s#\\]*\))?$`)
+	// sample fetches and converters allowed in backend-vars expressions,
+	// without access to the environment, files or the payload
+	backendVarFetches = map[string]bool{
+		"base": true, "be_name": true, "bool": true, "dst": true, "dst_port": true,
+		"fe_name": true, "int": true, "method": true, "path": true, "query": true,
+		"req.cook": true, "req.hdr": true, "src": true, "src_port": true, "ssl_c_s_dn": true,
+		"ssl_fc": true, "ssl_fc_sni": true, "str": true, "url": true, "url_param": true,
+		"var": true,
+	}
+	backendVarConverters = map[string]bool{
+		"add": true, "and": true, "base64": true, "bytes": true, "div": true,
+		"field": true, "hex": true, "ipmask": true, "json": true, "length": true,
+		"lower": true, "mod": true, "mul": true, "or": true, "sub": true,
+		"upper": true, "url_dec": true, "word": true, "xor": true,
+	}
 )
 
+func (c *updater) buildBackendVars(d *backData) {
+	if d.ann.BackendVars == "" {
+		return
+	}
+	var vars []hatypes.BackendVar
+	for _, line := range strings.Split(d.ann.BackendVars, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			c.logger.Warn("skipping invalid backend var '%s' on %v: expected format is <name>=<value>", line, d.ann.Source)
+			continue
+		}
+		name := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		if !backendVarNameRegex.MatchString(name) {
+			c.logger.Warn("skipping backend var with invalid name '%s' on %v", name, d.ann.Source)
+			continue
+		}
+		var expr string
+		if strings.HasPrefix(value, "%[") && strings.HasSuffix(value, "]") {
+			var err error
+			expr, err = backendVarExpr(value[2 : len(value)-1])
+			if err != nil {
+				c.logger.Warn("skipping backend var '%s' on %v: %v", name, d.ann.Source, err)
+				continue
+			}
+		} else if backendVarStaticRegex.MatchString(value) {
+			expr = "str(" + value + ")"
+		} else {
+			c.logger.Warn("skipping backend var '%s' on %v: invalid static value '%s'", name, d.ann.Source, value)
+			continue
+		}
+		vars = append(vars, hatypes.BackendVar{Name: name, Expr: expr})
+	}
+	d.backend.Vars = vars
+}
+
+// backendVarExpr validates a sample expression against the
+// allowed fetches and converters, eg `req.hdr(host),lower`
+func backendVarExpr(expr string) (string, error) {
+	var samples []string
+	depth, start := 0, 0
+	for i, ch := range expr {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				samples = append(samples, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	samples = append(samples, expr[start:])
+	for i, sample := range samples {
+		match := backendVarSampleRegex.FindStringSubmatch(sample)
+		if match == nil {
+			return "", fmt.Errorf("invalid expression '%s'", expr)
+		}
+		if i == 0 && !backendVarFetches[match[1]] {
+			return "", fmt.Errorf("sample fetch '%s' is not allowed", match[1])
+		}
+		if i > 0 && !backendVarConverters[match[1]] {
+			return "", fmt.Errorf("converter '%s' is not allowed", match[1])
+		}
+	}
+	return expr, nil
+}
+
+func (c *updater) buildBackendSSE(d *backData) {
+	if !d.ann.SSE {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring sse on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	d.backend.SSE = true
+	// event streams are long lived, use a long inactivity timeout if
+	// a backend specific one wasn't configured
+	if d.backend.Timeout.Server == "" {
+		d.backend.Timeout.Server = "1h"
+	}
+	if d.backend.Timeout.Tunnel == "" {
+		d.backend.Timeout.Tunnel = "1h"
+	}
+}
+
+var (
+	oauthHeaderRegex   = regexp.MustCompile(`^[A-Za-z0-9-]+:[A-Za-z0-9-_]+$`)
+	oauthSkipPathRegex = regexp.MustCompile(`^/[^"' {}#]*$`)
+	// dot segments and encoded dots or slashes, which backends might resolve
+	// to another path
+	dotSegmentRegex = regexp.MustCompile(`(?i)(^|/)\.\.?(/|$)|%2e|%2f|%5c|\\`)
+)
+
+func (c *updater) buildBackendSlots(d *backData) {
+	dynScaling := c.haproxy.Global().DynamicScaling
+	if d.backend.Resolver != "" {
+		// servers are resolved by HAProxy, empty slots aren't used
+		return
+	}
+	if !dynScaling.Enabled && d.ann.SlotsMin == 0 && d.ann.SlotsIncrement == 0 {
+		return
+	}
+	increment := d.ann.SlotsIncrement
+	if increment < 0 {
+		c.logger.Warn("invalid slots-increment '%d' on %v, using the global config instead", increment, d.ann.Source)
+		increment = 0
+	}
+	if increment == 0 {
+		increment = dynScaling.SlotsIncrement
+	}
+	if increment <= 0 {
+		increment = 1
+	}
+	min := d.ann.SlotsMin
+	if min < 0 {
+		c.logger.Warn("invalid slots-min '%d' on %v, using '0' instead", min, d.ann.Source)
+		min = 0
+	}
+	count := len(d.backend.Endpoints)
+	total := ((count + increment - 1) / increment) * increment
+	if total < increment {
+		total = increment
+	}
+	if total < min {
+		total = min
+	}
+	d.backend.EmptySlots = total - count
+}
+
 func (c *updater) buildOAuth(d *backData) {
 	if d.ann.OAuth == "" {
 		return
@@ -335,11 +1391,19 @@ func (c *updater) buildOAuth(d *backData) {
 		headers = strings.Split(d.ann.OAuthHeaders, ",")
 	}
 	uriPrefix = strings.TrimRight(uriPrefix, "/")
-	namespace := d.ann.Source.Namespace
-	backend := c.findBackend(namespace, uriPrefix)
-	if backend == nil {
-		c.logger.Error("path '%s' was not found on namespace '%s'", uriPrefix, namespace)
-		return
+	var backend *hatypes.Backend
+	if d.ann.OAuthService != "" {
+		backend = c.findOAuthServiceBackend(d)
+		if backend == nil {
+			return
+		}
+	} else {
+		namespace := d.ann.Source.Namespace
+		backend = c.findBackend(namespace, uriPrefix)
+		if backend == nil {
+			c.logger.Error("path '%s' was not found on namespace '%s'", uriPrefix, namespace)
+			return
+		}
 	}
 	headersMap := make(map[string]string, len(headers))
 	for _, header := range headers {
@@ -353,10 +1417,78 @@ func (c *updater) buildOAuth(d *backData) {
 		h := strings.Split(header, ":")
 		headersMap[h[0]] = h[1]
 	}
+	// skip paths match on a path segment boundary: `/api` skips `/api`
+	// and `/api/*` but not `/apix`
+	var skipPaths, skipPrefixes []string
+	for _, path := range utils.Split(d.ann.OAuthSkipPaths, ",") {
+		if path == "" {
+			continue
+		}
+		if !oauthSkipPathRegex.MatchString(path) || dotSegmentRegex.MatchString(path) {
+			c.logger.Warn("ignoring invalid oauth skip path '%s' on %v", path, d.ann.Source)
+			continue
+		}
+		if !strings.HasSuffix(path, "/") {
+			skipPaths = append(skipPaths, path)
+			path += "/"
+		}
+		skipPrefixes = append(skipPrefixes, path)
+	}
 	d.backend.OAuth.Impl = d.ann.OAuth
 	d.backend.OAuth.BackendName = backend.ID
 	d.backend.OAuth.URIPrefix = uriPrefix
 	d.backend.OAuth.Headers = headersMap
+	d.backend.OAuth.SkipPaths = skipPaths
+	d.backend.OAuth.SkipPrefixes = skipPrefixes
+}
+
+// findOAuthServiceBackend reads a `[<namespace>/]<service>:<port>` service
+// reference and finds the backend that was created for it. port might be
+// the service port number or name, or the target port of the endpoints.
+func (c *updater) findOAuthServiceBackend(d *backData) *hatypes.Backend {
+	svc := d.ann.OAuthService
+	sep := strings.LastIndex(svc, ":")
+	if sep <= 0 || sep == len(svc)-1 {
+		c.logger.Error("invalid oauth-service '%s' on %v, expected format is [<namespace>/]<service>:<port>", svc, d.ann.Source)
+		return nil
+	}
+	fullSvcName := svc[:sep]
+	port := svc[sep+1:]
+	if strings.Index(fullSvcName, "/") < 0 {
+		fullSvcName = d.ann.Source.Namespace + "/" + fullSvcName
+	}
+	sname := strings.Split(fullSvcName, "/")
+	if len(sname) != 2 || sname[0] == "" || sname[1] == "" {
+		c.logger.Error("invalid oauth-service '%s' on %v, expected format is [<namespace>/]<service>:<port>", svc, d.ann.Source)
+		return nil
+	}
+	namespace, name := sname[0], sname[1]
+	if namespace != d.ann.Source.Namespace && !c.isOAuthNamespaceAllowed(namespace) {
+		c.logger.Error("oauth-service '%s' on %v: cross namespace access to '%s' is not allowed", svc, d.ann.Source, namespace)
+		return nil
+	}
+	if service, err := c.cache.GetService(fullSvcName); err == nil {
+		for _, svcPort := range service.Spec.Ports {
+			if svcPort.Name == port || strconv.Itoa(int(svcPort.Port)) == port {
+				port = svcPort.TargetPort.String()
+				break
+			}
+		}
+	}
+	backend := c.haproxy.FindBackend(namespace, name, port)
+	if backend == nil {
+		c.logger.Error("oauth-service '%s' on %v was not found, it should be used by at least one ingress resource", svc, d.ann.Source)
+	}
+	return backend
+}
+
+func (c *updater) isOAuthNamespaceAllowed(namespace string) bool {
+	for _, ns := range c.options.OAuthNamespaces {
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *updater) findBackend(namespace, uriPrefix string) *hatypes.Backend {
@@ -370,6 +1502,45 @@ func (c *updater) findBackend(namespace, uriPrefix string) *hatypes.Backend {
 	return nil
 }
 
+var (
+	retryOnRegex = regexp.MustCompile(`^(conn-failure|empty-response|junk-response|response-timeout|0rtt-rejected|all-retryable-errors|401|403|404|408|425|500|501|502|503|504)$`)
+	// response-<code> is an alias to the status code
+	retryOnStatusRegex = regexp.MustCompile(`^response-([0-9]{3})$`)
+)
+
+func (c *updater) buildRetry(d *backData) {
+	if d.ann.RetryOn == "" {
+		return
+	}
+	var retryOn []string
+	for _, cond := range utils.Split(d.ann.RetryOn, ",") {
+		if status := retryOnStatusRegex.FindStringSubmatch(cond); status != nil {
+			cond = status[1]
+		}
+		if !retryOnRegex.MatchString(cond) {
+			c.logger.Warn("skipping invalid retry-on condition '%s' on %v", cond, d.ann.Source)
+			continue
+		}
+		retryOn = append(retryOn, cond)
+	}
+	if len(retryOn) == 0 {
+		return
+	}
+	if !c.options.HAProxyVersion.AtLeast(2, 0) {
+		c.logger.Warn("ignoring retry-on on %v: needs HAProxy 2.0 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		return
+	}
+	retries := d.ann.Retries
+	if retries <= 0 {
+		retries = 3
+	} else if retries > 10 {
+		c.logger.Warn("retries '%d' on %v is too high, using '10' instead", retries, d.ann.Source)
+		retries = 10
+	}
+	d.backend.Retry.On = retryOn
+	d.backend.Retry.Retries = retries
+}
+
 var (
 	rewriteURLRegex = regexp.MustCompile(`^[^"' ]+$`)
 )
@@ -385,6 +1556,62 @@ func (c *updater) buildRewriteURL(d *backData) {
 	d.backend.RewriteURL = d.ann.RewriteTarget
 }
 
+var (
+	stripPathLiteralRegex     = regexp.MustCompile(`^[A-Za-z0-9_.~-]+$`)
+	stripPathPlaceholderRegex = regexp.MustCompile(`^\{([A-Za-z0-9-]+)\}$`)
+)
+
+// stripPathSegment matches a non empty path segment on the request line
+const stripPathSegment = `/[^/\ ?]+`
+
+func (c *updater) buildStripPath(d *backData) {
+	strip := d.ann.StripPathPrefix
+	if strip == "" {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.logger.Warn("ignoring strip-path-prefix on %v: backend is in TCP mode", d.ann.Source)
+		return
+	}
+	if d.backend.RewriteURL != "" {
+		c.logger.Warn("ignoring strip-path-prefix on %v: rewrite-target is also declared", d.ann.Source)
+		return
+	}
+	var regex string
+	var headers []hatypes.StripPathHeader
+	if count, err := strconv.Atoi(strip); err == nil {
+		if count <= 0 {
+			c.logger.Warn("ignoring invalid strip-path-prefix '%s' on %v", strip, d.ann.Source)
+			return
+		}
+		regex = strings.Repeat(stripPathSegment, count)
+	} else {
+		if !strings.HasPrefix(strip, "/") {
+			c.logger.Warn("ignoring invalid strip-path-prefix '%s' on %v", strip, d.ann.Source)
+			return
+		}
+		for i, segment := range strings.Split(strings.Trim(strip, "/"), "/") {
+			if header := stripPathPlaceholderRegex.FindStringSubmatch(segment); header != nil {
+				// field 1 is the empty string before the leading slash
+				headers = append(headers, hatypes.StripPathHeader{Name: header[1], Field: i + 2})
+				regex += stripPathSegment
+			} else if stripPathLiteralRegex.MatchString(segment) {
+				regex += "/" + strings.Replace(segment, ".", `\.`, -1)
+			} else {
+				c.logger.Warn("ignoring invalid strip-path-prefix '%s' on %v", strip, d.ann.Source)
+				return
+			}
+		}
+	}
+	d.backend.StripPath = hatypes.StripPathConfig{
+		Regex:   regex,
+		Match:   "^" + regex + "(/|$)",
+		Headers: headers,
+		// reqrep was removed on HAProxy 2.1, which added replace-path
+		ReplacePath: c.options.HAProxyVersion.AtLeast(2, 1),
+	}
+}
+
 func (c *updater) buildWAF(d *backData) {
 	if d.ann.WAF == "" {
 		return
@@ -410,3 +1637,73 @@ func (c *updater) buildWhitelist(d *backData) {
 	}
 	d.backend.Whitelist = cidrlist
 }
+
+const defaultZoneLocalWeight = 10
+
+func (c *updater) buildBackendZoneLocal(d *backData) {
+	mode := d.ann.ZoneLocalRouting
+	if mode == "" {
+		return
+	}
+	if mode != "backup" && mode != "weight" {
+		c.logger.Warn("ignoring invalid zone-local-routing mode '%s' on %v", mode, d.ann.Source)
+		return
+	}
+	localZone := c.haproxy.Global().LocalZone
+	if localZone == "" {
+		c.logger.Warn("ignoring zone-local-routing on %v: the local zone is unknown, configure local-zone", d.ann.Source)
+		return
+	}
+	weight := d.ann.ZoneLocalWeight
+	if weight == 0 {
+		weight = defaultZoneLocalWeight
+	} else if weight < 0 {
+		c.logger.Warn("invalid zone-local-weight '%d' on %v, using '%d' instead", weight, d.ann.Source, defaultZoneLocalWeight)
+		weight = defaultZoneLocalWeight
+	}
+	nodeZones := map[string]string{}
+	var local, remote []*hatypes.Endpoint
+	for _, ep := range d.backend.Endpoints {
+		if ep.TargetRef == "" {
+			// endpoints of failover clusters and ExternalName services
+			remote = append(remote, ep)
+			continue
+		}
+		pod, err := c.cache.GetPod(ep.TargetRef)
+		if err != nil {
+			c.logger.Warn("cannot read the zone of endpoint '%s' on %v: %v", ep.Name, d.ann.Source, err)
+			remote = append(remote, ep)
+			continue
+		}
+		nodeName := pod.Spec.NodeName
+		zone, found := nodeZones[nodeName]
+		if !found {
+			zone, err = c.cache.GetNodeZone(nodeName)
+			if err != nil {
+				c.logger.Warn("cannot read the zone of endpoint '%s' on %v: %v", ep.Name, d.ann.Source, err)
+			}
+			nodeZones[nodeName] = zone
+		}
+		if zone == localZone {
+			local = append(local, ep)
+		} else {
+			remote = append(remote, ep)
+		}
+	}
+	if len(local) == 0 {
+		// all the endpoints are used if none is zone-local
+		return
+	}
+	if mode == "backup" {
+		for _, ep := range remote {
+			ep.Backup = true
+		}
+		return
+	}
+	for _, ep := range local {
+		ep.Weight *= weight
+		if ep.Weight > 256 {
+			ep.Weight = 256
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	api "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -103,7 +104,10 @@ func TestAuthHTTP(t *testing.T) {
 		ingname      string
 		ann          types.BackendAnnotations
 		secrets      ing_helper.SecretContent
+		configmaps   ing_helper.ConfigMapContent
+		nsAnn        map[string]map[string]string
 		expUserlists []*hatypes.Userlist
+		expGroups    []string
 		expLogging   string
 	}{
 		// 0
@@ -198,6 +202,108 @@ usr2::clearpwd2`)}},
 			}}},
 			expLogging: "",
 		},
+		// 11
+		{
+			ann: types.BackendAnnotations{AuthType: "basic", AuthSecret: "team1, configmap:team2"},
+			secrets: ing_helper.SecretContent{"default/team1": {"auth": []byte(`
+usr1:encpwd1
+usr2::clearpwd2`)}},
+			configmaps: ing_helper.ConfigMapContent{"default/team2": {"auth": `
+usr2::clearpwd2
+usr3:encpwd3`}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{Name: "default_team1_configmap-team2", Users: []hatypes.User{
+				{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+				{Name: "usr2", Passwd: "clearpwd2", Encrypted: false},
+				{Name: "usr3", Passwd: "encpwd3", Encrypted: true},
+			}}},
+			expLogging: "",
+		},
+		// 12
+		{
+			ann:     types.BackendAnnotations{AuthType: "basic", AuthSecret: "team1,team2,team3"},
+			secrets: ing_helper.SecretContent{"default/team1": {"auth": []byte("usr1:encpwd1")}, "default/team2": {"auth": []byte("usr1:encpwd2")}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{Name: "default_team1_team2_team3", Users: []hatypes.User{
+				{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+			}}},
+			expLogging: `
+WARN skipping user 'usr1' from secret 'default/team2', declared on ingress 'default/ing1': user was already declared with another password
+ERROR error reading basic authentication on ingress 'default/ing1': secret not found: 'default/team3'`,
+		},
+		// 13
+		{
+			ann:        types.BackendAnnotations{AuthType: "basic", AuthSecret: "configmap:team1"},
+			configmaps: ing_helper.ConfigMapContent{"default/team1": {"users": ""}},
+			expLogging: "ERROR error reading basic authentication on ingress 'default/ing1': configmap 'default/team1' does not have key 'auth'",
+		},
+		// 14
+		{
+			ann: types.BackendAnnotations{AuthType: "basic", AuthSecret: "team1", AuthGroupsAllowed: "admin"},
+			secrets: ing_helper.SecretContent{"default/team1": {
+				"auth": []byte("usr1:encpwd1\nusr2:encpwd2"),
+				"groups": []byte(`
+admin: usr1 usr3
+dev: usr1 usr2 usr1`)}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{
+				Name: "default_team1",
+				Groups: []hatypes.UserGroup{
+					{Name: "admin", Users: []string{"usr1"}},
+					{Name: "dev", Users: []string{"usr1", "usr2"}},
+				},
+				Users: []hatypes.User{
+					{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+					{Name: "usr2", Passwd: "encpwd2", Encrypted: true},
+				}}},
+			expGroups:  []string{"admin"},
+			expLogging: "WARN skipping user 'usr3' of group 'admin' declared on ingress 'default/ing1': user not found",
+		},
+		// 15
+		{
+			ann:     types.BackendAnnotations{AuthType: "basic", AuthSecret: "team1", AuthGroupsAllowed: "ops"},
+			secrets: ing_helper.SecretContent{"default/team1": {"auth": []byte("usr1:encpwd1")}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{
+				Name:   "default_team1",
+				Groups: []hatypes.UserGroup{{Name: "ops"}},
+				Users: []hatypes.User{
+					{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+				}}},
+			expGroups:  []string{"ops"},
+			expLogging: "WARN group 'ops' not found on userlist, declared on ingress 'default/ing1'",
+		},
+		// 16
+		{
+			ann:        types.BackendAnnotations{AuthType: "basic", AuthSecret: "shared/team1"},
+			secrets:    ing_helper.SecretContent{"shared/team1": {"auth": []byte("usr1:encpwd1")}},
+			nsAnn:      map[string]map[string]string{"shared": {}},
+			expLogging: "ERROR error reading basic authentication on ingress 'default/ing1': cross namespace access to secret 'shared/team1' is not allowed",
+		},
+		// 17
+		{
+			ann:     types.BackendAnnotations{AuthType: "basic", AuthSecret: "shared/team1"},
+			secrets: ing_helper.SecretContent{"shared/team1": {"auth": []byte("usr1:encpwd1")}},
+			nsAnn: map[string]map[string]string{"shared": {
+				"ingress.kubernetes.io/allowed-secret-namespaces": "default",
+			}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{
+				Name: "default_shared_team1",
+				Users: []hatypes.User{
+					{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+				}}},
+		},
+		// 18
+		{
+			ann:        types.BackendAnnotations{AuthType: "basic", AuthSecret: "configmap:team1,shared/team2"},
+			configmaps: ing_helper.ConfigMapContent{"default/team1": {"auth": "usr1:encpwd1"}},
+			secrets:    ing_helper.SecretContent{"shared/team2": {"auth": []byte("usr2:encpwd2")}},
+			nsAnn: map[string]map[string]string{"shared": {
+				"ingress.kubernetes.io/allowed-secret-namespaces": "default",
+			}},
+			expUserlists: []*hatypes.Userlist{&hatypes.Userlist{
+				Name: "default_configmap-team1_shared_team2",
+				Users: []hatypes.User{
+					{Name: "usr1", Passwd: "encpwd1", Encrypted: true},
+					{Name: "usr2", Passwd: "encpwd2", Encrypted: true},
+				}}},
+		},
 	}
 
 	for i, test := range testCase {
@@ -211,12 +317,171 @@ usr2::clearpwd2`)}},
 			test.ingname = "ing1"
 		}
 		c.cache.SecretContent = test.secrets
+		c.cache.ConfigMapContent = test.configmaps
+		c.cache.NsList = map[string]*api.Namespace{}
+		for ns, ann := range test.nsAnn {
+			c.cache.NsList[ns] = &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: ns, Annotations: ann}}
+		}
+		c.options.AnnotationPrefix = []string{"ingress.kubernetes.io"}
+		c.options.SecretNamespaceAnnotation = true
 		d := c.createBackendData(test.namespace, test.ingname, &test.ann)
 		u.buildBackendAuthHTTP(d)
 		userlists := u.haproxy.Userlists()
 		if len(userlists)+len(test.expUserlists) > 0 && !reflect.DeepEqual(test.expUserlists, userlists) {
 			t.Errorf("userlists config %d differs - expected: %+v - actual: %+v", i, test.expUserlists, userlists)
 		}
+		if !reflect.DeepEqual(test.expGroups, d.backend.Userlist.Groups) {
+			t.Errorf("allowed groups on %d differs - expected: %v - actual: %v", i, test.expGroups, d.backend.Userlist.Groups)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBalance(t *testing.T) {
+	testCase := []struct {
+		balance    string
+		modeTCP    bool
+		expected   string
+		expLogging string
+	}{
+		// 0
+		{
+			balance:  "",
+			expected: "roundrobin",
+		},
+		// 1
+		{
+			balance:  "leastconn",
+			expected: "leastconn",
+		},
+		// 2
+		{
+			balance:  "first",
+			expected: "first",
+		},
+		// 3
+		{
+			balance:  "uri whole",
+			expected: "uri whole",
+		},
+		// 4
+		{
+			balance:  "hdr(X-Tenant)",
+			expected: "hdr(X-Tenant)",
+		},
+		// 5
+		{
+			balance:  "source",
+			modeTCP:  true,
+			expected: "source",
+		},
+		// 6
+		{
+			balance:    "hdr(X-Tenant)",
+			modeTCP:    true,
+			expected:   "roundrobin",
+			expLogging: "WARN balance algorithm 'hdr(X-Tenant)' on ingress 'default/app' requires HTTP mode, using 'roundrobin' instead",
+		},
+		// 7
+		{
+			balance:    "fastest",
+			expected:   "roundrobin",
+			expLogging: "WARN invalid balance algorithm 'fastest' on ingress 'default/app', using 'roundrobin' instead",
+		},
+		// 8
+		{
+			balance:    "hdr(Host) if",
+			expected:   "roundrobin",
+			expLogging: "WARN invalid balance algorithm 'hdr(Host) if' on ingress 'default/app', using 'roundrobin' instead",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{BalanceAlgorithm: test.balance})
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendBalance(d)
+		if d.backend.BalanceAlgorithm != test.expected {
+			t.Errorf("balance on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.BalanceAlgorithm)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		version    hatypes.Version
+		modeTCP    bool
+		expected   hatypes.BandwidthLimit
+		expLogging string
+	}{
+		// 0
+		{
+			ann: types.BackendAnnotations{},
+		},
+		// 1
+		{
+			ann:      types.BackendAnnotations{BandwidthLimitDown: "10m"},
+			version:  hatypes.Version{Major: 2, Minor: 7},
+			expected: hatypes.BandwidthLimit{Download: "10m", Period: "1s"},
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{BandwidthLimitDown: "10m", BandwidthLimitUp: "512k", BandwidthLimitKey: "src", BandwidthLimitPeriod: "10s"},
+			version:  hatypes.Version{Major: 2, Minor: 7},
+			expected: hatypes.BandwidthLimit{Download: "10m", Upload: "512k", Period: "10s", PerSrc: true},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{BandwidthLimitDown: "10mb", BandwidthLimitUp: "1m"},
+			version:    hatypes.Version{Major: 2, Minor: 7},
+			expected:   hatypes.BandwidthLimit{Upload: "1m", Period: "1s"},
+			expLogging: "WARN ignoring invalid bandwidth-limit-download '10mb' on ingress 'default/app'",
+		},
+		// 4
+		{
+			ann:      types.BackendAnnotations{BandwidthLimitUp: "1m", BandwidthLimitKey: "dst", BandwidthLimitPeriod: "1x"},
+			version:  hatypes.Version{Major: 2, Minor: 7},
+			expected: hatypes.BandwidthLimit{Upload: "1m", Period: "1s"},
+			expLogging: `
+WARN invalid bandwidth-limit-period '1x' on ingress 'default/app', using '1s' instead
+WARN invalid bandwidth-limit-key 'dst' on ingress 'default/app', using 'stream' instead`,
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{BandwidthLimitDown: "10m"},
+			modeTCP:    true,
+			expLogging: "WARN ignoring bandwidth limit on ingress 'default/app': backend is in TCP mode",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{BandwidthLimitDown: "10m"},
+			version:    hatypes.Version{Major: 2, Minor: 6},
+			expLogging: "WARN ignoring bandwidth limit on ingress 'default/app': needs HAProxy 2.7 or newer, found version 2.6",
+		},
+		// 7
+		{
+			ann:        types.BackendAnnotations{BandwidthLimitUp: "1m"},
+			expLogging: "WARN ignoring bandwidth limit on ingress 'default/app': needs HAProxy 2.7 or newer, found version unknown",
+		},
+		// 8
+		{
+			ann:      types.BackendAnnotations{BandwidthLimitUp: "1m"},
+			version:  hatypes.Version{Major: 3, Minor: 0},
+			expected: hatypes.BandwidthLimit{Upload: "1m", Period: "1s"},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendBandwidthLimit(d)
+		if !reflect.DeepEqual(d.backend.BandwidthLimit, test.expected) {
+			t.Errorf("bandwidth limit on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.BandwidthLimit)
+		}
 		c.logger.CompareLogging(test.expLogging)
 		c.teardown()
 	}
@@ -518,243 +783,2311 @@ INFO-V(3) blue/green balance label 'v=3' on ingress 'default/ing1' does not refe
 	}
 }
 
-func TestOAuth(t *testing.T) {
-	testCases := []struct {
-		ann      types.BackendAnnotations
-		backend  string
-		oauthExp hatypes.OAuthConfig
-		logging  string
+func TestFailoverCluster(t *testing.T) {
+	remoteEp := &api.Endpoints{
+		Subsets: []api.EndpointSubset{
+			{
+				Addresses: []api.EndpointAddress{{IP: "10.1.0.11"}, {IP: "10.1.0.12"}},
+				Ports:     []api.EndpointPort{{Name: "http", Port: 8080, Protocol: api.ProtocolTCP}},
+			},
+		},
+	}
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		port       string
+		remote     bool
+		expected   []*hatypes.Endpoint
+		expLogging string
 	}{
 		// 0
 		{
 			ann:      types.BackendAnnotations{},
-			oauthExp: hatypes.OAuthConfig{},
+			remote:   true,
+			expected: []*hatypes.Endpoint{{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1}},
 		},
 		// 1
 		{
-			ann:     types.BackendAnnotations{OAuth: "none"},
-			logging: "WARN ignoring invalid oauth implementation 'none' on ingress 'default/app'",
+			ann:    types.BackendAnnotations{FailoverCluster: "backup"},
+			remote: true,
+			expected: []*hatypes.Endpoint{
+				{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1},
+				{Name: "10.1.0.11:8080", IP: "10.1.0.11", Port: 8080, Backup: true, Weight: 1},
+				{Name: "10.1.0.12:8080", IP: "10.1.0.12", Port: 8080, Backup: true, Weight: 1},
+			},
 		},
 		// 2
 		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy"},
-			logging: "ERROR path '/oauth2' was not found on namespace 'default'",
+			ann:    types.BackendAnnotations{FailoverCluster: "weight", FailoverClusterWeight: 0},
+			port:   "http",
+			remote: true,
+			expected: []*hatypes.Endpoint{
+				{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1},
+				{Name: "10.1.0.11:8080", IP: "10.1.0.11", Port: 8080, Weight: 0},
+				{Name: "10.1.0.12:8080", IP: "10.1.0.12", Port: 8080, Weight: 0},
+			},
 		},
 		// 3
 		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy"},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+			ann:    types.BackendAnnotations{FailoverCluster: "weight", FailoverClusterWeight: 300},
+			remote: true,
+			expected: []*hatypes.Endpoint{
+				{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1},
+				{Name: "10.1.0.11:8080", IP: "10.1.0.11", Port: 8080, Weight: 256},
+				{Name: "10.1.0.12:8080", IP: "10.1.0.12", Port: 8080, Weight: 256},
 			},
+			expLogging: "WARN invalid failover-cluster-weight '300' on ingress 'default/app', using '256' instead",
 		},
 		// 4
 		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthURIPrefix: "/auth"},
-			backend: "default:back:/auth",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/auth",
-				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
-			},
+			ann:      types.BackendAnnotations{FailoverCluster: "backup"},
+			port:     "9000",
+			remote:   true,
+			expected: []*hatypes.Endpoint{{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1}},
 		},
 		// 5
 		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "X-Auth-New:attr_from_lua"},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers:     map[string]string{"X-Auth-New": "attr_from_lua"},
-			},
+			ann:        types.BackendAnnotations{FailoverCluster: "backup"},
+			expected:   []*hatypes.Endpoint{{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1}},
+			expLogging: "WARN skipping failover cluster endpoints on ingress 'default/app': could not find remote endpoints for service 'default/app'",
 		},
 		// 6
 		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "space before:attr"},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers:     map[string]string{},
-			},
-			logging: "WARN invalid header format 'space before:attr' on ingress 'default/app'",
-		},
-		// 7
-		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "no-colon"},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers:     map[string]string{},
-			},
-			logging: "WARN invalid header format 'no-colon' on ingress 'default/app'",
-		},
-		// 8
-		{
-			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "more:colons:unsupported"},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers:     map[string]string{},
-			},
-			logging: "WARN invalid header format 'more:colons:unsupported' on ingress 'default/app'",
-		},
-		// 9
-		{
-			ann: types.BackendAnnotations{
-				OAuth:        "oauth2_proxy",
-				OAuthHeaders: ",,X-Auth-Request-Email:auth_response_email,,X-Auth-New:attr_from_lua,",
-			},
-			backend: "default:back:/oauth2",
-			oauthExp: hatypes.OAuthConfig{
-				Impl:        "oauth2_proxy",
-				BackendName: "default_back_8080",
-				URIPrefix:   "/oauth2",
-				Headers: map[string]string{
-					"X-Auth-Request-Email": "auth_response_email",
-					"X-Auth-New":           "attr_from_lua",
-				},
-			},
+			ann:        types.BackendAnnotations{FailoverCluster: "always"},
+			remote:     true,
+			expected:   []*hatypes.Endpoint{{Name: "10.0.0.11:8080", IP: "10.0.0.11", Port: 8080, TargetRef: "default/app-1", Weight: 1}},
+			expLogging: "WARN ignoring invalid failover-cluster mode 'always' on ingress 'default/app'",
 		},
 	}
-	for i, test := range testCases {
+	for i, test := range testCase {
 		c := setup(t)
+		if test.remote {
+			c.cache.RemoteEpList = map[string]*api.Endpoints{"default/app": remoteEp}
+		}
 		d := c.createBackendData("default", "app", &test.ann)
-		if test.backend != "" {
-			b := strings.Split(test.backend, ":")
-			backend := c.haproxy.AcquireBackend(b[0], b[1], "8080")
-			c.haproxy.AcquireHost("app.local").AddPath(backend, b[2])
+		d.backend.Namespace = "default"
+		d.backend.Name = "app"
+		d.backend.Port = test.port
+		if d.backend.Port == "" {
+			d.backend.Port = "8080"
 		}
-		c.createUpdater().buildOAuth(d)
-		if !reflect.DeepEqual(test.oauthExp, d.backend.OAuth) {
-			t.Errorf("oauth on %d differs - expected: %+v - actual: %+v", i, test.oauthExp, d.backend.OAuth)
+		d.backend.NewEndpoint("10.0.0.11", 8080, "default/app-1")
+		c.createUpdater().buildBackendFailoverCluster(d)
+		if !reflect.DeepEqual(d.backend.Endpoints, test.expected) {
+			t.Errorf("endpoints on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Endpoints)
 		}
-		c.logger.CompareLogging(test.logging)
+		c.logger.CompareLogging(test.expLogging)
 		c.teardown()
 	}
 }
 
-func TestRewriteURL(t *testing.T) {
-	testCases := []struct {
-		input    string
-		expected string
-		logging  string
+func TestHash(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		expType    string
+		expFactor  int
+		expLogging string
 	}{
 		// 0
 		{
-			input:    ``,
-			expected: ``,
+			ann: types.BackendAnnotations{},
 		},
 		// 1
 		{
-			input:    `/"/`,
-			expected: ``,
-			logging:  `WARN rewrite-target does not allow white spaces or single/double quotes on ingress 'default/app'`,
+			ann:     types.BackendAnnotations{HashType: "consistent"},
+			expType: "consistent",
 		},
 		// 2
 		{
-			input:    `/app`,
-			expected: `/app`,
+			ann:     types.BackendAnnotations{HashType: "map-based djb2 avalanche"},
+			expType: "map-based djb2 avalanche",
+		},
+		// 3
+		{
+			ann:       types.BackendAnnotations{HashType: "consistent wt6", HashBalanceFactor: 150},
+			expType:   "consistent wt6",
+			expFactor: 150,
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{HashType: "consistent", HashBalanceFactor: 50},
+			expType:    "consistent",
+			expFactor:  100,
+			expLogging: "WARN invalid hash-balance-factor '50' on ingress 'default/app', using '100' instead",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{HashType: "map-based", HashBalanceFactor: 150},
+			expType:    "map-based",
+			expLogging: "WARN ignoring hash-balance-factor on ingress 'default/app': hash-type should be consistent",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{HashType: "consistent md5"},
+			expLogging: "WARN ignoring invalid hash-type 'consistent md5' on ingress 'default/app'",
 		},
 	}
-
-	for i, test := range testCases {
+	for i, test := range testCase {
 		c := setup(t)
-		d := c.createBackendData("default", "app", &types.BackendAnnotations{RewriteTarget: test.input})
-		c.createUpdater().buildRewriteURL(d)
-		if d.backend.RewriteURL != test.expected {
-			t.Errorf("rewrite on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.RewriteURL)
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendHash(d)
+		if d.backend.HashType != test.expType {
+			t.Errorf("hash-type on %d differs - expected: %v - actual: %v", i, test.expType, d.backend.HashType)
 		}
-		c.logger.CompareLogging(test.logging)
-		c.teardown()
+		if d.backend.HashBalanceFactor != test.expFactor {
+			t.Errorf("hash-balance-factor on %d differs - expected: %v - actual: %v", i, test.expFactor, d.backend.HashBalanceFactor)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
 	}
 }
 
-func TestWAF(t *testing.T) {
+func TestHTTPConnMode(t *testing.T) {
 	testCase := []struct {
-		waf      string
-		expected string
-		logging  string
+		mode       string
+		expected   string
+		expLogging string
 	}{
+		// 0
 		{
-			waf:      "",
+			mode:     "",
 			expected: "",
-			logging:  "",
 		},
+		// 1
 		{
-			waf:      "none",
+			mode:     "http-keep-alive",
 			expected: "",
-			logging:  "WARN ignoring invalid WAF mode: none",
 		},
+		// 2
 		{
-			waf:      "modsecurity",
-			expected: "modsecurity",
-			logging:  "",
+			mode:     "http-server-close",
+			expected: "http-server-close",
+		},
+		// 3
+		{
+			mode:     "httpclose",
+			expected: "httpclose",
+		},
+		// 4
+		{
+			mode:       "forceclose",
+			expected:   "",
+			expLogging: "WARN ignoring invalid http-connection-mode 'forceclose' on ingress 'default/app'",
 		},
 	}
 	for i, test := range testCase {
 		c := setup(t)
-		d := c.createBackendData("default", "app", &types.BackendAnnotations{WAF: test.waf})
-		c.createUpdater().buildWAF(d)
-		if d.backend.WAF != test.expected {
-			t.Errorf("WAF on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.WAF)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{HTTPConnectionMode: test.mode})
+		c.createUpdater().buildBackendHTTPConnMode(d)
+		if d.backend.HTTPConnMode != test.expected {
+			t.Errorf("http connection mode on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.HTTPConnMode)
 		}
-		c.logger.CompareLogging(test.logging)
+		c.logger.CompareLogging(test.expLogging)
 		c.teardown()
 	}
 }
 
-func TestWhitelist(t *testing.T) {
+func TestHTTPReuse(t *testing.T) {
 	testCase := []struct {
-		cidrlist string
-		expected []string
-		logging  string
+		disable    bool
+		reuse      string
+		modeTCP    bool
+		expected   string
+		expLogging string
 	}{
 		// 0
 		{
-			cidrlist: "10.0.0.0/8,192.168.0.0/16",
-			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			disable:  false,
+			expected: "",
 		},
 		// 1
 		{
-			cidrlist: "10.0.0.0/8, 192.168.0.0/16",
-			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			disable:  true,
+			expected: "never",
 		},
 		// 2
 		{
-			cidrlist: "10.0.0.0/8,192.168.0/16",
-			expected: []string{"10.0.0.0/8"},
-			logging:  `WARN skipping invalid cidr '192.168.0/16' in whitelist config on ingress 'default/app'`,
+			disable:    true,
+			modeTCP:    true,
+			expected:   "",
+			expLogging: "WARN ignoring disable-h2-reuse on ingress 'default/app': backend is in TCP mode",
 		},
 		// 3
 		{
-			cidrlist: "10.0.0/8,192.168.0/16",
-			expected: []string{},
-			logging: `
-WARN skipping invalid cidr '10.0.0/8' in whitelist config on ingress 'default/app'
-WARN skipping invalid cidr '192.168.0/16' in whitelist config on ingress 'default/app'`,
+			reuse:    "aggressive",
+			expected: "aggressive",
+		},
+		// 4
+		{
+			reuse:    "always",
+			expected: "always",
+		},
+		// 5
+		{
+			disable:    true,
+			reuse:      "always",
+			expected:   "never",
+			expLogging: "WARN ignoring http-reuse 'always' on ingress 'default/app': disable-h2-reuse is also configured",
+		},
+		// 6
+		{
+			reuse:      "sometimes",
+			expected:   "",
+			expLogging: "WARN ignoring invalid http-reuse 'sometimes' on ingress 'default/app'",
+		},
+		// 7
+		{
+			reuse:      "safe",
+			modeTCP:    true,
+			expected:   "",
+			expLogging: "WARN ignoring http-reuse on ingress 'default/app': backend is in TCP mode",
 		},
 	}
 	for i, test := range testCase {
 		c := setup(t)
-		d := c.createBackendData("default", "app", &types.BackendAnnotations{WhitelistSourceRange: test.cidrlist})
-		c.createUpdater().buildWhitelist(d)
-		if !reflect.DeepEqual(d.backend.Whitelist, test.expected) {
-			if len(d.backend.Whitelist) > 0 || len(test.expected) > 0 {
-				t.Errorf("whitelist on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.Whitelist)
-			}
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{DisableH2Reuse: test.disable, HTTPReuse: test.reuse})
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendHTTPReuse(d)
+		if d.backend.HTTPReuse != test.expected {
+			t.Errorf("http reuse on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.HTTPReuse)
 		}
-		c.logger.CompareLogging(test.logging)
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestHTTPKeepAliveTimeout(t *testing.T) {
+	testCase := []struct {
+		timeout    string
+		modeTCP    bool
+		version    hatypes.Version
+		expected   string
+		expLogging string
+	}{
+		// 0
+		{
+			version: hatypes.Version{Major: 1, Minor: 9},
+		},
+		// 1
+		{
+			timeout:  "30s",
+			version:  hatypes.Version{Major: 1, Minor: 9},
+			expected: "30s",
+		},
+		// 2
+		{
+			timeout:  "500",
+			version:  hatypes.Version{Major: 2, Minor: 4},
+			expected: "500",
+		},
+		// 3
+		{
+			timeout:    "30 s",
+			version:    hatypes.Version{Major: 2, Minor: 4},
+			expLogging: "WARN ignoring invalid http-keep-alive-timeout '30 s' on ingress 'default/app'",
+		},
+		// 4
+		{
+			timeout:    "30s",
+			modeTCP:    true,
+			version:    hatypes.Version{Major: 2, Minor: 4},
+			expLogging: "WARN ignoring http-keep-alive-timeout on ingress 'default/app': backend is in TCP mode",
+		},
+		// 5
+		{
+			timeout:    "30s",
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expLogging: "WARN ignoring http-keep-alive-timeout on ingress 'default/app': needs HAProxy 1.9 or newer, found version 1.8",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{HTTPKeepAliveTimeout: test.timeout})
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendHTTPKeepAliveTimeout(d)
+		if d.backend.PoolPurgeDelay != test.expected {
+			t.Errorf("http keep-alive timeout on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.PoolPurgeDelay)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	testCases := []struct {
+		ann        types.BackendAnnotations
+		backend    string
+		namespaces []string
+		oauthExp   hatypes.OAuthConfig
+		logging    string
+	}{
+		// 0
+		{
+			ann:      types.BackendAnnotations{},
+			oauthExp: hatypes.OAuthConfig{},
+		},
+		// 1
+		{
+			ann:     types.BackendAnnotations{OAuth: "none"},
+			logging: "WARN ignoring invalid oauth implementation 'none' on ingress 'default/app'",
+		},
+		// 2
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy"},
+			logging: "ERROR path '/oauth2' was not found on namespace 'default'",
+		},
+		// 3
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+			},
+		},
+		// 4
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthURIPrefix: "/auth"},
+			backend: "default:back:/auth",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/auth",
+				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+			},
+		},
+		// 5
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "X-Auth-New:attr_from_lua"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{"X-Auth-New": "attr_from_lua"},
+			},
+		},
+		// 6
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "space before:attr"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{},
+			},
+			logging: "WARN invalid header format 'space before:attr' on ingress 'default/app'",
+		},
+		// 7
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "no-colon"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{},
+			},
+			logging: "WARN invalid header format 'no-colon' on ingress 'default/app'",
+		},
+		// 8
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthHeaders: "more:colons:unsupported"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{},
+			},
+			logging: "WARN invalid header format 'more:colons:unsupported' on ingress 'default/app'",
+		},
+		// 9
+		{
+			ann: types.BackendAnnotations{
+				OAuth:        "oauth2_proxy",
+				OAuthHeaders: ",,X-Auth-Request-Email:auth_response_email,,X-Auth-New:attr_from_lua,",
+			},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers: map[string]string{
+					"X-Auth-Request-Email": "auth_response_email",
+					"X-Auth-New":           "attr_from_lua",
+				},
+			},
+		},
+		// 10
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthService: "back:8080"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "default_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+			},
+		},
+		// 11
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthService: "auth/back:8080"},
+			backend: "auth:back:/oauth2",
+			logging: "ERROR oauth-service 'auth/back:8080' on ingress 'default/app': cross namespace access to 'auth' is not allowed",
+		},
+		// 12
+		{
+			ann:        types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthService: "auth/back:8080"},
+			backend:    "auth:back:/oauth2",
+			namespaces: []string{"auth"},
+			oauthExp: hatypes.OAuthConfig{
+				Impl:        "oauth2_proxy",
+				BackendName: "auth_back_8080",
+				URIPrefix:   "/oauth2",
+				Headers:     map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+			},
+		},
+		// 13
+		{
+			ann:        types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthService: "auth/back:8000"},
+			backend:    "auth:back:/oauth2",
+			namespaces: []string{"*"},
+			logging:    "ERROR oauth-service 'auth/back:8000' on ingress 'default/app' was not found, it should be used by at least one ingress resource",
+		},
+		// 14
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthService: "auth/back"},
+			logging: "ERROR invalid oauth-service 'auth/back' on ingress 'default/app', expected format is [<namespace>/]<service>:<port>",
+		},
+		// 15
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthSkipPaths: "/health, /api/public,health,/a b,/static/"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:         "oauth2_proxy",
+				BackendName:  "default_back_8080",
+				URIPrefix:    "/oauth2",
+				Headers:      map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+				SkipPaths:    []string{"/health", "/api/public"},
+				SkipPrefixes: []string{"/health/", "/api/public/", "/static/"},
+			},
+			logging: `
+WARN ignoring invalid oauth skip path 'health' on ingress 'default/app'
+WARN ignoring invalid oauth skip path '/a b' on ingress 'default/app'`,
+		},
+		// 16
+		{
+			ann:     types.BackendAnnotations{OAuth: "oauth2_proxy", OAuthSkipPaths: "/api/../admin,/api/..,/api/%2E%2E/admin,/api%2fadmin,/./api,/api/.well-known"},
+			backend: "default:back:/oauth2",
+			oauthExp: hatypes.OAuthConfig{
+				Impl:         "oauth2_proxy",
+				BackendName:  "default_back_8080",
+				URIPrefix:    "/oauth2",
+				Headers:      map[string]string{"X-Auth-Request-Email": "auth_response_email"},
+				SkipPaths:    []string{"/api/.well-known"},
+				SkipPrefixes: []string{"/api/.well-known/"},
+			},
+			logging: `
+WARN ignoring invalid oauth skip path '/api/../admin' on ingress 'default/app'
+WARN ignoring invalid oauth skip path '/api/..' on ingress 'default/app'
+WARN ignoring invalid oauth skip path '/api/%2E%2E/admin' on ingress 'default/app'
+WARN ignoring invalid oauth skip path '/api%2fadmin' on ingress 'default/app'
+WARN ignoring invalid oauth skip path '/./api' on ingress 'default/app'`,
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.OAuthNamespaces = test.namespaces
+		d := c.createBackendData("default", "app", &test.ann)
+		if test.backend != "" {
+			b := strings.Split(test.backend, ":")
+			backend := c.haproxy.AcquireBackend(b[0], b[1], "8080")
+			c.haproxy.AcquireHost("app.local").AddPath(backend, b[2])
+		}
+		c.createUpdater().buildOAuth(d)
+		if !reflect.DeepEqual(test.oauthExp, d.backend.OAuth) {
+			t.Errorf("oauth on %d differs - expected: %+v - actual: %+v", i, test.oauthExp, d.backend.OAuth)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestRetry(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		version    hatypes.Version
+		expected   hatypes.RetryConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.BackendAnnotations{},
+			expected: hatypes.RetryConfig{},
+		},
+		// 1
+		{
+			ann:      types.BackendAnnotations{RetryOn: "502,503,504"},
+			version:  hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.RetryConfig{On: []string{"502", "503", "504"}, Retries: 3},
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{RetryOn: "conn-failure, response-503", Retries: 2},
+			version:  hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.RetryConfig{On: []string{"conn-failure", "503"}, Retries: 2},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{RetryOn: "503,302"},
+			version:    hatypes.Version{Major: 2, Minor: 0},
+			expected:   hatypes.RetryConfig{On: []string{"503"}, Retries: 3},
+			expLogging: "WARN skipping invalid retry-on condition '302' on ingress 'default/app'",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{RetryOn: "none"},
+			expected:   hatypes.RetryConfig{},
+			expLogging: "WARN skipping invalid retry-on condition 'none' on ingress 'default/app'",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{RetryOn: "504", Retries: 20},
+			version:    hatypes.Version{Major: 2, Minor: 0},
+			expected:   hatypes.RetryConfig{On: []string{"504"}, Retries: 10},
+			expLogging: "WARN retries '20' on ingress 'default/app' is too high, using '10' instead",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{RetryOn: "503"},
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expected:   hatypes.RetryConfig{},
+			expLogging: "WARN ignoring retry-on on ingress 'default/app': needs HAProxy 2.0 or newer, found version 1.8",
+		},
+		// 7
+		{
+			ann:        types.BackendAnnotations{RetryOn: "503"},
+			expected:   hatypes.RetryConfig{},
+			expLogging: "WARN ignoring retry-on on ingress 'default/app': needs HAProxy 2.0 or newer, found version unknown",
+		},
+		// 8
+		{
+			ann:      types.BackendAnnotations{RetryOn: "response-timeout,response-404,response-foo,timeout"},
+			version:  hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.RetryConfig{On: []string{"response-timeout", "404"}, Retries: 3},
+			expLogging: `
+WARN skipping invalid retry-on condition 'response-foo' on ingress 'default/app'
+WARN skipping invalid retry-on condition 'timeout' on ingress 'default/app'`,
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildRetry(d)
+		if !reflect.DeepEqual(d.backend.Retry, test.expected) {
+			t.Errorf("retry on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Retry)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		logging  string
+	}{
+		// 0
+		{
+			input:    ``,
+			expected: ``,
+		},
+		// 1
+		{
+			input:    `/"/`,
+			expected: ``,
+			logging:  `WARN rewrite-target does not allow white spaces or single/double quotes on ingress 'default/app'`,
+		},
+		// 2
+		{
+			input:    `/app`,
+			expected: `/app`,
+		},
+	}
+
+	for i, test := range testCases {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{RewriteTarget: test.input})
+		c.createUpdater().buildRewriteURL(d)
+		if d.backend.RewriteURL != test.expected {
+			t.Errorf("rewrite on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.RewriteURL)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestSecurityExempt(t *testing.T) {
+	testCase := []struct {
+		paths      string
+		tcp        bool
+		expected   hatypes.SecurityExemptConfig
+		expLogging string
+	}{
+		// 0
+		{
+			paths:    "",
+			expected: hatypes.SecurityExemptConfig{},
+		},
+		// 1
+		{
+			paths: "/healthz,/metrics",
+			expected: hatypes.SecurityExemptConfig{
+				Exact: []string{"/healthz", "/metrics"},
+			},
+		},
+		// 2
+		{
+			paths: "/healthz, /status/*",
+			expected: hatypes.SecurityExemptConfig{
+				Exact:  []string{"/healthz"},
+				Prefix: []string{"/status/"},
+			},
+		},
+		// 3
+		{
+			paths: "/healthz,metrics,/a b",
+			expected: hatypes.SecurityExemptConfig{
+				Exact: []string{"/healthz"},
+			},
+			expLogging: `
+WARN ignoring invalid path 'metrics' of exclude-paths-from-security on ingress 'default/app'
+WARN ignoring invalid path '/a b' of exclude-paths-from-security on ingress 'default/app'`,
+		},
+		// 4
+		{
+			paths: "/status*, /api/v1/*",
+			expected: hatypes.SecurityExemptConfig{
+				Exact:  []string{"/status"},
+				Prefix: []string{"/status/", "/api/v1/"},
+			},
+		},
+		// 5
+		{
+			paths: "/api/../admin,/api/%2e%2e/*,/api%2Fadmin,/healthz/.",
+			expLogging: `
+WARN ignoring invalid path '/api/../admin' of exclude-paths-from-security on ingress 'default/app'
+WARN ignoring invalid path '/api/%2e%2e/' of exclude-paths-from-security on ingress 'default/app'
+WARN ignoring invalid path '/api%2Fadmin' of exclude-paths-from-security on ingress 'default/app'
+WARN ignoring invalid path '/healthz/.' of exclude-paths-from-security on ingress 'default/app'`,
+		},
+		// 6
+		{
+			paths:      "/healthz",
+			tcp:        true,
+			expected:   hatypes.SecurityExemptConfig{},
+			expLogging: "WARN ignoring exclude-paths-from-security on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{ExcludePathsSecurity: test.paths})
+		d.backend.ModeTCP = test.tcp
+		c.createUpdater().buildBackendSecurityExempt(d)
+		if !reflect.DeepEqual(d.backend.SecurityExempt, test.expected) {
+			t.Errorf("security exempt on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.SecurityExempt)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestServerNaming(t *testing.T) {
+	testCase := []struct {
+		naming     string
+		targets    []string
+		expected   []string
+		expLogging string
+	}{
+		// 0
+		{
+			naming:   "",
+			targets:  []string{"default/app-1", "default/app-2"},
+			expected: []string{"172.17.0.11:8080", "172.17.0.12:8080"},
+		},
+		// 1
+		{
+			naming:   "ip",
+			targets:  []string{"default/app-1"},
+			expected: []string{"172.17.0.11:8080"},
+		},
+		// 2
+		{
+			naming:   "sequence",
+			targets:  []string{"default/app-1", "default/app-2", "default/app-3"},
+			expected: []string{"srv001", "srv002", "srv003"},
+		},
+		// 3
+		{
+			naming:   "pod",
+			targets:  []string{"default/app-1", "default/app-2"},
+			expected: []string{"app-1", "app-2"},
+		},
+		// 4
+		{
+			naming:   "pod",
+			targets:  []string{"default/app-1", "", "default/app-1"},
+			expected: []string{"app-1", "172.17.0.12:8080", "172.17.0.13:8080"},
+		},
+		// 5
+		{
+			naming:     "name",
+			targets:    []string{"default/app-1"},
+			expected:   []string{"172.17.0.11:8080"},
+			expLogging: "WARN ignoring invalid server naming 'name' on ingress 'default/app'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{ServerNaming: test.naming})
+		for j, target := range test.targets {
+			d.backend.NewEndpoint("172.17.0."+strconv.Itoa(11+j), 8080, target)
+		}
+		c.createUpdater().buildBackendServerNaming(d)
+		var actual []string
+		for _, ep := range d.backend.Endpoints {
+			actual = append(actual, ep.Name)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("server names on %d differs - expected: %v - actual: %v", i, test.expected, actual)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestStripPath(t *testing.T) {
+	testCase := []struct {
+		strip      string
+		rewrite    string
+		tcp        bool
+		version    hatypes.Version
+		expected   hatypes.StripPathConfig
+		expLogging string
+	}{
+		// 0
+		{
+			strip:    "",
+			expected: hatypes.StripPathConfig{},
+		},
+		// 1
+		{
+			strip: "2",
+			expected: hatypes.StripPathConfig{
+				Regex: `/[^/\ ?]+/[^/\ ?]+`,
+				Match: `^/[^/\ ?]+/[^/\ ?]+(/|$)`,
+			},
+		},
+		// 2
+		{
+			strip: "/tenants/{X-Tenant-Id}",
+			expected: hatypes.StripPathConfig{
+				Regex: `/tenants/[^/\ ?]+`,
+				Match: `^/tenants/[^/\ ?]+(/|$)`,
+				Headers: []hatypes.StripPathHeader{
+					{Name: "X-Tenant-Id", Field: 3},
+				},
+			},
+		},
+		// 3
+		{
+			strip: "/api/v1.0/{X-Org}/{X-Team}/",
+			expected: hatypes.StripPathConfig{
+				Regex: `/api/v1\.0/[^/\ ?]+/[^/\ ?]+`,
+				Match: `^/api/v1\.0/[^/\ ?]+/[^/\ ?]+(/|$)`,
+				Headers: []hatypes.StripPathHeader{
+					{Name: "X-Org", Field: 4},
+					{Name: "X-Team", Field: 5},
+				},
+			},
+		},
+		// 4
+		{
+			strip:      "0",
+			expLogging: "WARN ignoring invalid strip-path-prefix '0' on ingress 'default/app'",
+		},
+		// 5
+		{
+			strip:      "tenants/{id}",
+			expLogging: "WARN ignoring invalid strip-path-prefix 'tenants/{id}' on ingress 'default/app'",
+		},
+		// 6
+		{
+			strip:      "/tenants/(.*)",
+			expLogging: "WARN ignoring invalid strip-path-prefix '/tenants/(.*)' on ingress 'default/app'",
+		},
+		// 7
+		{
+			strip:      "/tenants/{X Id}",
+			expLogging: "WARN ignoring invalid strip-path-prefix '/tenants/{X Id}' on ingress 'default/app'",
+		},
+		// 8
+		{
+			strip:      "1",
+			rewrite:    "/app",
+			expLogging: "WARN ignoring strip-path-prefix on ingress 'default/app': rewrite-target is also declared",
+		},
+		// 9
+		{
+			strip:      "1",
+			tcp:        true,
+			expLogging: "WARN ignoring strip-path-prefix on ingress 'default/app': backend is in TCP mode",
+		},
+		// 10
+		{
+			strip:   "2",
+			version: hatypes.Version{Major: 2, Minor: 1},
+			expected: hatypes.StripPathConfig{
+				Regex:       `/[^/\ ?]+/[^/\ ?]+`,
+				Match:       `^/[^/\ ?]+/[^/\ ?]+(/|$)`,
+				ReplacePath: true,
+			},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{StripPathPrefix: test.strip})
+		d.backend.ModeTCP = test.tcp
+		d.backend.RewriteURL = test.rewrite
+		c.createUpdater().buildStripPath(d)
+		if !reflect.DeepEqual(d.backend.StripPath, test.expected) {
+			t.Errorf("strip path on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.StripPath)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendTimeout(t *testing.T) {
+	testCase := []struct {
+		global   hatypes.BackendTimeoutConfig
+		ann      types.BackendAnnotations
+		expected hatypes.BackendTimeoutConfig
+	}{
+		// 0
+		{
+			global:   hatypes.BackendTimeoutConfig{KeepAlive: "1m", Server: "50s"},
+			ann:      types.BackendAnnotations{TimeoutKeepAlive: "1m", TimeoutServer: "50s"},
+			expected: hatypes.BackendTimeoutConfig{},
+		},
+		// 1
+		{
+			global:   hatypes.BackendTimeoutConfig{KeepAlive: "1m", Server: "50s", Tunnel: "1h"},
+			ann:      types.BackendAnnotations{TimeoutKeepAlive: "10m", TimeoutServer: "50s", TimeoutTunnel: "1h"},
+			expected: hatypes.BackendTimeoutConfig{KeepAlive: "10m"},
+		},
+		// 2
+		{
+			global: hatypes.BackendTimeoutConfig{},
+			ann: types.BackendAnnotations{
+				TimeoutConnect:     "1s",
+				TimeoutHTTPRequest: "2s",
+				TimeoutKeepAlive:   "3s",
+				TimeoutQueue:       "4s",
+				TimeoutServer:      "5s",
+				TimeoutServerFin:   "6s",
+				TimeoutTunnel:      "7s",
+			},
+			expected: hatypes.BackendTimeoutConfig{
+				Connect:     "1s",
+				HTTPRequest: "2s",
+				KeepAlive:   "3s",
+				Queue:       "4s",
+				Server:      "5s",
+				ServerFin:   "6s",
+				Tunnel:      "7s",
+			},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.haproxy.Global().Timeout.BackendTimeoutConfig = test.global
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendTimeout(d)
+		if !reflect.DeepEqual(d.backend.Timeout, test.expected) {
+			t.Errorf("timeout on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Timeout)
+		}
+		c.teardown()
+	}
+}
+
+func TestBackendVars(t *testing.T) {
+	testCase := []struct {
+		vars       string
+		expected   []hatypes.BackendVar
+		expLogging string
+	}{
+		// 0
+		{
+			vars: "",
+		},
+		// 1
+		{
+			vars: "tier=gold\ncontact=team-a@example.com",
+			expected: []hatypes.BackendVar{
+				{Name: "tier", Expr: "str(gold)"},
+				{Name: "contact", Expr: "str(team-a@example.com)"},
+			},
+		},
+		// 2
+		{
+			vars: "tenant = %[req.hdr(x-tenant,1),lower]\n\nclient=%[src,ipmask(24)]",
+			expected: []hatypes.BackendVar{
+				{Name: "tenant", Expr: "req.hdr(x-tenant,1),lower"},
+				{Name: "client", Expr: "src,ipmask(24)"},
+			},
+		},
+		// 3
+		{
+			vars:       "tier",
+			expLogging: "WARN skipping invalid backend var 'tier' on ingress 'default/app': expected format is <name>=<value>",
+		},
+		// 4
+		{
+			vars:       "my-tier=gold",
+			expLogging: "WARN skipping backend var with invalid name 'my-tier' on ingress 'default/app'",
+		},
+		// 5
+		{
+			vars:       "tier=gold silver",
+			expLogging: "WARN skipping backend var 'tier' on ingress 'default/app': invalid static value 'gold silver'",
+		},
+		// 6
+		{
+			vars:       "home=%[env(HOME)]\ntier=gold",
+			expected:   []hatypes.BackendVar{{Name: "tier", Expr: "str(gold)"}},
+			expLogging: "WARN skipping backend var 'home' on ingress 'default/app': sample fetch 'env' is not allowed",
+		},
+		// 7
+		{
+			vars:       "id=%[path,map(/etc/passwd)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': converter 'map' is not allowed",
+		},
+		// 8
+		{
+			vars:       "id=%[path,regsub(\"a\",b)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid expression 'path,regsub(\"a\",b)'",
+		},
+		// 9
+		{
+			vars:       "tier=\ntier2=%[str()]",
+			expected:   []hatypes.BackendVar{{Name: "tier2", Expr: "str()"}},
+			expLogging: "WARN skipping backend var 'tier' on ingress 'default/app': invalid static value ''",
+		},
+		// 10
+		{
+			vars:       "id=%[str(a#b)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid expression 'str(a#b)'",
+		},
+		// 11
+		{
+			vars:       "id=%[str(a\\b)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid expression 'str(a\\b)'",
+		},
+		// 12
+		{
+			vars:       "id=%[str(a\tb)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid expression 'str(a\tb)'",
+		},
+		// 13
+		{
+			vars:       "id=%[req.hdr(x-id),lower(#)]",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid expression 'req.hdr(x-id),lower(#)'",
+		},
+		// 14
+		{
+			vars:       "id=a#b",
+			expLogging: "WARN skipping backend var 'id' on ingress 'default/app': invalid static value 'a#b'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{BackendVars: test.vars})
+		c.createUpdater().buildBackendVars(d)
+		if !reflect.DeepEqual(d.backend.Vars, test.expected) {
+			t.Errorf("backend vars on %d differ - expected: %+v - actual: %+v", i, test.expected, d.backend.Vars)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestSecureSPIFFE(t *testing.T) {
+	svid := types.File{Filename: "/var/haproxy/ssl/spiffe-svid.pem", SHA1Hash: "1"}
+	bundle := types.File{Filename: "/var/haproxy/ssl/cacerts/ca-spiffe-bundle.pem", SHA1Hash: "2"}
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		svid       types.File
+		bundle     types.File
+		expected   hatypes.SSLBackendConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:    types.BackendAnnotations{},
+			svid:   svid,
+			bundle: bundle,
+		},
+		// 1
+		{
+			ann:        types.BackendAnnotations{SecureSPIFFE: true},
+			expLogging: "WARN ignoring secure-spiffe on ingress 'default/app': SVID is not available, either the SPIFFE Workload API is not configured or the SVID was not received yet",
+		},
+		// 2
+		{
+			ann:    types.BackendAnnotations{SecureSPIFFE: true},
+			svid:   svid,
+			bundle: bundle,
+			expected: hatypes.SSLBackendConfig{
+				IsSecure:     true,
+				CertFilename: "/var/haproxy/ssl/spiffe-svid.pem",
+				CertHash:     "1",
+				CAFilename:   "/var/haproxy/ssl/cacerts/ca-spiffe-bundle.pem",
+				CAHash:       "2",
+			},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.SPIFFESVIDFile = test.svid
+		c.options.SPIFFEBundleFile = test.bundle
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendSecureSPIFFE(d)
+		if !reflect.DeepEqual(d.backend.SSL, test.expected) {
+			t.Errorf("ssl on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.SSL)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestSecureALPN(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		secure     bool
+		expected   string
+		expLogging string
+	}{
+		// 0
+		{
+			ann:    types.BackendAnnotations{},
+			secure: true,
+		},
+		// 1
+		{
+			ann:      types.BackendAnnotations{SecureALPN: "h2,http/1.1"},
+			secure:   true,
+			expected: "h2,http/1.1",
+		},
+		// 2
+		{
+			ann:        types.BackendAnnotations{SecureALPN: "h2,http/1.1"},
+			expLogging: "WARN ignoring secure-alpn on ingress 'default/app': backend does not use TLS",
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{SecureALPN: "h2;http/1.1"},
+			secure:     true,
+			expLogging: "WARN ignoring invalid secure-alpn on ingress 'default/app': h2;http/1.1",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.SSL.IsSecure = test.secure
+		c.createUpdater().buildBackendSecureALPN(d)
+		if d.backend.SSL.ALPN != test.expected {
+			t.Errorf("alpn on %d differs - expected: %s - actual: %s", i, test.expected, d.backend.SSL.ALPN)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendProtocol(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		version    hatypes.Version
+		secure     bool
+		alpn       string
+		modeTCP    bool
+		expH2      bool
+		expALPN    string
+		expLogging string
+	}{
+		// 0
+		{
+			ann: types.BackendAnnotations{BackendProtocol: "h1"},
+		},
+		// 1
+		{
+			ann:     types.BackendAnnotations{BackendProtocol: "h2"},
+			version: hatypes.Version{Major: 2, Minor: 0},
+			expH2:   true,
+		},
+		// 2
+		{
+			ann:     types.BackendAnnotations{BackendProtocol: "h2"},
+			version: hatypes.Version{Major: 2, Minor: 0},
+			secure:  true,
+			expH2:   true,
+			expALPN: "h2",
+		},
+		// 3
+		{
+			ann:     types.BackendAnnotations{BackendProtocol: "h2"},
+			version: hatypes.Version{Major: 2, Minor: 0},
+			secure:  true,
+			alpn:    "h2,http/1.1",
+			expH2:   true,
+			expALPN: "h2,http/1.1",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{BackendProtocol: "h2"},
+			modeTCP:    true,
+			expLogging: "WARN ignoring backend-protocol on ingress 'default/app': backend is in TCP mode",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{BackendProtocol: "h3"},
+			expLogging: "WARN ignoring invalid backend-protocol on ingress 'default/app': h3",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{BackendProtocol: "h2"},
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			secure:     true,
+			expLogging: "WARN ignoring backend-protocol on ingress 'default/app': HTTP/2 backends need HAProxy 2.0 or newer, found version 1.8",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		d.backend.SSL.IsSecure = test.secure
+		d.backend.SSL.ALPN = test.alpn
+		c.createUpdater().buildBackendProtocol(d)
+		if d.backend.ProtoH2 != test.expH2 {
+			t.Errorf("proto h2 on %d differs - expected: %t - actual: %t", i, test.expH2, d.backend.ProtoH2)
+		}
+		if d.backend.SSL.ALPN != test.expALPN {
+			t.Errorf("alpn on %d differs - expected: %s - actual: %s", i, test.expALPN, d.backend.SSL.ALPN)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendDNS(t *testing.T) {
+	testCase := []struct {
+		ann          types.BackendAnnotations
+		ip           string
+		resolvers    []string
+		version      hatypes.Version
+		expResolver  string
+		expResolvers []string
+		expEndpoints int
+		expLogging   string
+	}{
+		// 0
+		{
+			ip:           "172.17.0.11",
+			expEndpoints: 1,
+		},
+		// 1
+		{
+			ip:           "api.example.com",
+			version:      hatypes.Version{Major: 2, Minor: 0},
+			expResolver:  "k8s",
+			expResolvers: []string{"k8s"},
+			expEndpoints: 1,
+		},
+		// 2
+		{
+			ann:          types.BackendAnnotations{UseResolver: "dns1"},
+			ip:           "api.example.com",
+			resolvers:    []string{"dns1"},
+			expResolver:  "dns1",
+			expResolvers: []string{"dns1"},
+			expEndpoints: 1,
+		},
+		// 3
+		{
+			ann:          types.BackendAnnotations{UseResolver: "dns2"},
+			ip:           "api.example.com",
+			resolvers:    []string{"dns1", "k8s"},
+			expResolver:  "k8s",
+			expResolvers: []string{"dns1", "k8s"},
+			expEndpoints: 1,
+			expLogging:   "WARN ignoring use-resolver on ingress 'default/app': DNS resolver 'dns2' not found",
+		},
+		// 4
+		{
+			ip:         "api.example.com",
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expLogging: "WARN skipping servers of ingress 'default/app': the default DNS resolver needs HAProxy 2.0 or newer, found version 1.8. Use a resolver declared in dns-resolvers instead",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		dns := &c.haproxy.Global().DNS
+		for _, name := range test.resolvers {
+			dns.Resolvers = append(dns.Resolvers, &hatypes.DNSResolver{Name: name})
+		}
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.NewEndpoint(test.ip, 443, "")
+		c.createUpdater().buildBackendDNS(d)
+		if d.backend.Resolver != test.expResolver {
+			t.Errorf("resolver on %d differs - expected: %s - actual: %s", i, test.expResolver, d.backend.Resolver)
+		}
+		var resolvers []string
+		for _, resolver := range dns.Resolvers {
+			resolvers = append(resolvers, resolver.Name)
+		}
+		if !reflect.DeepEqual(resolvers, test.expResolvers) {
+			t.Errorf("resolvers on %d differs - expected: %v - actual: %v", i, test.expResolvers, resolvers)
+		}
+		if len(d.backend.Endpoints) != test.expEndpoints {
+			t.Errorf("endpoints on %d differs - expected: %d - actual: %d", i, test.expEndpoints, len(d.backend.Endpoints))
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendDNSSRV(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		resolvers  []string
+		version    hatypes.Version
+		expected   hatypes.DNSSRVConfig
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:     types.BackendAnnotations{DNSSRVRecord: "_http._tcp.app.example.com"},
+			version: hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.DNSSRVConfig{
+				Record:   "_http._tcp.app.example.com",
+				Resolver: "k8s",
+				Slots:    10,
+			},
+		},
+		// 2
+		{
+			ann:       types.BackendAnnotations{DNSSRVRecord: "_http._tcp.app.example.com", DNSSRVSlots: 4, UseResolver: "dns1"},
+			resolvers: []string{"dns1"},
+			expected: hatypes.DNSSRVConfig{
+				Record:   "_http._tcp.app.example.com",
+				Resolver: "dns1",
+				Slots:    4,
+			},
+		},
+		// 3
+		{
+			ann:       types.BackendAnnotations{DNSSRVRecord: "_http._tcp.app.example.com", DNSSRVSlots: -1, UseResolver: "dns1"},
+			resolvers: []string{"dns1"},
+			expected: hatypes.DNSSRVConfig{
+				Record:   "_http._tcp.app.example.com",
+				Resolver: "dns1",
+				Slots:    10,
+			},
+			expLogging: "WARN invalid dns-srv-slots '-1' on ingress 'default/app', using '10' instead",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{DNSSRVRecord: "app.example.com"},
+			version:    hatypes.Version{Major: 2, Minor: 0},
+			expLogging: "WARN ignoring invalid dns-srv-record on ingress 'default/app': app.example.com",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{DNSSRVRecord: "_http._tcp.app.example.com"},
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expLogging: "WARN ignoring dns-srv-record on ingress 'default/app': the default DNS resolver needs HAProxy 2.0 or newer, found version 1.8. Use a resolver declared in dns-resolvers instead",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		dns := &c.haproxy.Global().DNS
+		for _, name := range test.resolvers {
+			dns.Resolvers = append(dns.Resolvers, &hatypes.DNSResolver{Name: name})
+		}
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendDNSSRV(d)
+		if !reflect.DeepEqual(d.backend.DNSSRV, test.expected) {
+			t.Errorf("dns srv on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.DNSSRV)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestSSE(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		modeTCP    bool
+		timeout    hatypes.BackendTimeoutConfig
+		expSSE     bool
+		expTimeout hatypes.BackendTimeoutConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann: types.BackendAnnotations{},
+		},
+		// 1
+		{
+			ann:        types.BackendAnnotations{SSE: true},
+			expSSE:     true,
+			expTimeout: hatypes.BackendTimeoutConfig{Server: "1h", Tunnel: "1h"},
+		},
+		// 2
+		{
+			ann:        types.BackendAnnotations{SSE: true},
+			timeout:    hatypes.BackendTimeoutConfig{Server: "10m"},
+			expSSE:     true,
+			expTimeout: hatypes.BackendTimeoutConfig{Server: "10m", Tunnel: "1h"},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{SSE: true},
+			modeTCP:    true,
+			expLogging: "WARN ignoring sse on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		d.backend.Timeout = test.timeout
+		c.createUpdater().buildBackendSSE(d)
+		if d.backend.SSE != test.expSSE {
+			t.Errorf("sse on %d differs - expected: %v - actual: %v", i, test.expSSE, d.backend.SSE)
+		}
+		if !reflect.DeepEqual(d.backend.Timeout, test.expTimeout) {
+			t.Errorf("timeout on %d differs - expected: %+v - actual: %+v", i, test.expTimeout, d.backend.Timeout)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestSlots(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		dynScaling hatypes.DynamicScalingConfig
+		endpoints  int
+		expected   int
+		expLogging string
+	}{
+		// 0
+		{
+			ann:       types.BackendAnnotations{},
+			endpoints: 3,
+			expected:  0,
+		},
+		// 1
+		{
+			ann:        types.BackendAnnotations{},
+			dynScaling: hatypes.DynamicScalingConfig{Enabled: true, SlotsIncrement: 32},
+			endpoints:  3,
+			expected:   29,
+		},
+		// 2
+		{
+			ann:       types.BackendAnnotations{SlotsIncrement: 4},
+			endpoints: 5,
+			expected:  3,
+		},
+		// 3
+		{
+			ann:       types.BackendAnnotations{SlotsIncrement: 4},
+			endpoints: 0,
+			expected:  4,
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{SlotsMin: 10},
+			dynScaling: hatypes.DynamicScalingConfig{SlotsIncrement: 4},
+			endpoints:  3,
+			expected:   7,
+		},
+		// 5
+		{
+			ann:       types.BackendAnnotations{SlotsMin: 6, SlotsIncrement: 4},
+			endpoints: 7,
+			expected:  1,
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{SlotsMin: -1, SlotsIncrement: 2},
+			endpoints:  3,
+			expected:   1,
+			expLogging: "WARN invalid slots-min '-1' on ingress 'default/app', using '0' instead",
+		},
+		// 7
+		{
+			ann:        types.BackendAnnotations{SlotsIncrement: -2},
+			dynScaling: hatypes.DynamicScalingConfig{SlotsIncrement: 8},
+			endpoints:  3,
+			expected:   5,
+			expLogging: "WARN invalid slots-increment '-2' on ingress 'default/app', using the global config instead",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.haproxy.Global().DynamicScaling = test.dynScaling
+		d := c.createBackendData("default", "app", &test.ann)
+		for j := 0; j < test.endpoints; j++ {
+			d.backend.NewEndpoint("172.17.0."+strconv.Itoa(11+j), 8080, "")
+		}
+		c.createUpdater().buildBackendSlots(d)
+		if d.backend.EmptySlots != test.expected {
+			t.Errorf("empty slots on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.EmptySlots)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestWAF(t *testing.T) {
+	testCase := []struct {
+		waf      string
+		expected string
+		logging  string
+	}{
+		{
+			waf:      "",
+			expected: "",
+			logging:  "",
+		},
+		{
+			waf:      "none",
+			expected: "",
+			logging:  "WARN ignoring invalid WAF mode: none",
+		},
+		{
+			waf:      "modsecurity",
+			expected: "modsecurity",
+			logging:  "",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{WAF: test.waf})
+		c.createUpdater().buildWAF(d)
+		if d.backend.WAF != test.expected {
+			t.Errorf("WAF on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.WAF)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestWhitelist(t *testing.T) {
+	testCase := []struct {
+		cidrlist string
+		expected []string
+		logging  string
+	}{
+		// 0
+		{
+			cidrlist: "10.0.0.0/8,192.168.0.0/16",
+			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		// 1
+		{
+			cidrlist: "10.0.0.0/8, 192.168.0.0/16",
+			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		// 2
+		{
+			cidrlist: "10.0.0.0/8,192.168.0/16",
+			expected: []string{"10.0.0.0/8"},
+			logging:  `WARN skipping invalid cidr '192.168.0/16' in whitelist config on ingress 'default/app'`,
+		},
+		// 3
+		{
+			cidrlist: "10.0.0/8,192.168.0/16",
+			expected: []string{},
+			logging: `
+WARN skipping invalid cidr '10.0.0/8' in whitelist config on ingress 'default/app'
+WARN skipping invalid cidr '192.168.0/16' in whitelist config on ingress 'default/app'`,
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{WhitelistSourceRange: test.cidrlist})
+		c.createUpdater().buildWhitelist(d)
+		if !reflect.DeepEqual(d.backend.Whitelist, test.expected) {
+			if len(d.backend.Whitelist) > 0 || len(test.expected) > 0 {
+				t.Errorf("whitelist on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.Whitelist)
+			}
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestBackendZoneLocal(t *testing.T) {
+	pods := map[string]*api.Pod{
+		"default/pod1": {Spec: api.PodSpec{NodeName: "node1"}},
+		"default/pod2": {Spec: api.PodSpec{NodeName: "node2"}},
+		"default/pod3": {Spec: api.PodSpec{NodeName: "node3"}},
+	}
+	nodeZones := map[string]string{
+		"node1": "zone-a",
+		"node2": "zone-b",
+		"node3": "zone-a",
+	}
+	buildEndpoints := func(targets ...string) []*hatypes.Endpoint {
+		eps := make([]*hatypes.Endpoint, len(targets))
+		for i, target := range targets {
+			eps[i] = &hatypes.Endpoint{
+				Name:      "srv00" + strconv.Itoa(i+1),
+				TargetRef: target,
+				Weight:    1,
+			}
+		}
+		return eps
+	}
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		localZone  string
+		endpoints  []*hatypes.Endpoint
+		expWeights []int
+		expBackup  []bool
+		expLogging string
+	}{
+		// 0
+		{
+			localZone:  "zone-a",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 1},
+			expBackup:  []bool{false, false},
+		},
+		// 1
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "backup"},
+			localZone:  "zone-a",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2", "default/pod3", ""),
+			expWeights: []int{1, 1, 1, 1},
+			expBackup:  []bool{false, true, false, true},
+		},
+		// 2
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "weight"},
+			localZone:  "zone-a",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{10, 1},
+			expBackup:  []bool{false, false},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "weight", ZoneLocalWeight: 500},
+			localZone:  "zone-b",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 256},
+			expBackup:  []bool{false, false},
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "weight", ZoneLocalWeight: -1},
+			localZone:  "zone-b",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 10},
+			expBackup:  []bool{false, false},
+			expLogging: "WARN invalid zone-local-weight '-1' on ingress 'default/app', using '10' instead",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "backup"},
+			localZone:  "zone-c",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 1},
+			expBackup:  []bool{false, false},
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "backup"},
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 1},
+			expBackup:  []bool{false, false},
+			expLogging: "WARN ignoring zone-local-routing on ingress 'default/app': the local zone is unknown, configure local-zone",
+		},
+		// 7
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "primary"},
+			localZone:  "zone-a",
+			endpoints:  buildEndpoints("default/pod1", "default/pod2"),
+			expWeights: []int{1, 1},
+			expBackup:  []bool{false, false},
+			expLogging: "WARN ignoring invalid zone-local-routing mode 'primary' on ingress 'default/app'",
+		},
+		// 8
+		{
+			ann:        types.BackendAnnotations{ZoneLocalRouting: "backup"},
+			localZone:  "zone-a",
+			endpoints:  buildEndpoints("default/pod1", "default/pod4"),
+			expWeights: []int{1, 1},
+			expBackup:  []bool{false, true},
+			expLogging: "WARN cannot read the zone of endpoint 'srv002' on ingress 'default/app': pod not found: 'default/pod4'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.PodList = pods
+		c.cache.NodeZones = nodeZones
+		c.haproxy.Global().LocalZone = test.localZone
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.Endpoints = test.endpoints
+		c.createUpdater().buildBackendZoneLocal(d)
+		weights := make([]int, len(d.backend.Endpoints))
+		backup := make([]bool, len(d.backend.Endpoints))
+		for j, ep := range d.backend.Endpoints {
+			weights[j] = ep.Weight
+			backup[j] = ep.Backup
+		}
+		if !reflect.DeepEqual(weights, test.expWeights) {
+			t.Errorf("weight on %d differs - expected: %v - actual: %v", i, test.expWeights, weights)
+		}
+		if !reflect.DeepEqual(backup, test.expBackup) {
+			t.Errorf("backup on %d differs - expected: %v - actual: %v", i, test.expBackup, backup)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendDrainTimeout(t *testing.T) {
+	pods := map[string]*api.Pod{
+		"default/pod1": {},
+		"default/pod2": {ObjectMeta: meta.ObjectMeta{DeletionTimestamp: &meta.Time{Time: time.Now().Add(-10 * time.Second)}}},
+		"default/pod3": {ObjectMeta: meta.ObjectMeta{DeletionTimestamp: &meta.Time{Time: time.Now().Add(-time.Minute)}}},
+	}
+	buildEndpoints := func() []*hatypes.Endpoint {
+		return []*hatypes.Endpoint{
+			{Name: "srv001", TargetRef: "default/pod1", Weight: 0},
+			{Name: "srv002", TargetRef: "default/pod2", Weight: 0},
+			{Name: "srv003", TargetRef: "default/pod3", Weight: 0},
+			{Name: "srv004", TargetRef: "default/pod3", Weight: 1},
+		}
+	}
+	testCase := []struct {
+		timeout    string
+		expected   []string
+		expLogging string
+	}{
+		// 0
+		{
+			expected: []string{"srv001", "srv002", "srv003", "srv004"},
+		},
+		// 1
+		{
+			timeout:  "30s",
+			expected: []string{"srv001", "srv002", "srv004"},
+		},
+		// 2
+		{
+			timeout:  "5s",
+			expected: []string{"srv001", "srv004"},
+		},
+		// 3
+		{
+			timeout:    "30",
+			expected:   []string{"srv001", "srv002", "srv003", "srv004"},
+			expLogging: "WARN ignoring invalid drain-support-timeout on ingress 'default/app': 30",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.PodList = pods
+		d := c.createBackendData("default", "app", &types.BackendAnnotations{DrainSupportTimeout: test.timeout})
+		d.backend.Endpoints = buildEndpoints()
+		c.createUpdater().buildBackendDrainTimeout(d)
+		var names []string
+		for _, ep := range d.backend.Endpoints {
+			names = append(names, ep.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("endpoints on %d differs - expected: %v - actual: %v", i, test.expected, names)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendGlobalRateLimit(t *testing.T) {
+	peers := hatypes.PeersConfig{
+		Name: "ingress",
+		Peers: []*hatypes.Peer{
+			{Name: "ingress-a", Endpoint: "10.0.1.11:10000"},
+			{Name: "ingress-b", Endpoint: "10.0.1.12:10000"},
+		},
+	}
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		localPeer  string
+		peers      bool
+		modeTCP    bool
+		expected   hatypes.RateLimitConfig
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann: types.BackendAnnotations{GlobalRateLimit: 100},
+			expected: hatypes.RateLimitConfig{
+				Limit:  100,
+				Period: "1s",
+				Tables: []string{"_rl_d1_app_8080"},
+				Track:  "_rl_d1_app_8080",
+			},
+		},
+		// 2
+		{
+			ann:       types.BackendAnnotations{GlobalRateLimit: 100, GlobalRateLimitPeriod: "10s", LimitWhitelist: "10.0.0.0/8,10.0.0.1"},
+			peers:     true,
+			localPeer: "ingress-b",
+			expected: hatypes.RateLimitConfig{
+				Limit:     100,
+				Period:    "10s",
+				Peers:     "ingress",
+				Tables:    []string{"_rl_d1_app_8080_ingress-a", "_rl_d1_app_8080_ingress-b"},
+				Track:     "_rl_d1_app_8080_ingress-b",
+				Whitelist: []string{"10.0.0.0/8"},
+			},
+			expLogging: "WARN skipping invalid cidr '10.0.0.1' in limit-whitelist on ingress 'default/app'",
+		},
+		// 3
+		{
+			ann:       types.BackendAnnotations{GlobalRateLimit: 100},
+			peers:     true,
+			localPeer: "ingress-0",
+			expected: hatypes.RateLimitConfig{
+				Limit:  100,
+				Period: "1s",
+				Peers:  "ingress",
+				Tables: []string{"_rl_d1_app_8080_ingress-0", "_rl_d1_app_8080_ingress-a", "_rl_d1_app_8080_ingress-b"},
+				Track:  "_rl_d1_app_8080_ingress-0",
+			},
+		},
+		// 4
+		{
+			ann:   types.BackendAnnotations{GlobalRateLimit: 100, GlobalRateLimitPeriod: "1x"},
+			peers: true,
+			expected: hatypes.RateLimitConfig{
+				Limit:  100,
+				Period: "1s",
+				Tables: []string{"_rl_d1_app_8080"},
+				Track:  "_rl_d1_app_8080",
+			},
+			expLogging: `
+WARN invalid global-rate-limit-period '1x' on ingress 'default/app', using '1s' instead
+WARN global-rate-limit on ingress 'default/app' is applied per replica: the name of the local peer is unknown, declare the POD_NAME environment variable`,
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{GlobalRateLimit: -1},
+			expLogging: "WARN ignoring invalid global-rate-limit '-1' on ingress 'default/app'",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{GlobalRateLimit: 100},
+			modeTCP:    true,
+			expLogging: "WARN ignoring global-rate-limit on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		if test.peers {
+			c.haproxy.Global().Peers = peers
+			c.haproxy.Global().Peers.LocalPeer = test.localPeer
+		}
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ID = "d1_app_8080"
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendGlobalRateLimit(d)
+		if !reflect.DeepEqual(d.backend.RateLimit, test.expected) {
+			t.Errorf("rate limit on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.RateLimit)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendLua(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		scripts    bool
+		modeTCP    bool
+		expected   hatypes.LuaActionsConfig
+		expLogging string
+	}{
+		// 0
+		{
+			scripts: true,
+		},
+		// 1
+		{
+			ann:     types.BackendAnnotations{LuaRequestAction: "tenant,add_headers", LuaResponseAction: "strip_headers"},
+			scripts: true,
+			expected: hatypes.LuaActionsConfig{
+				RequestActions:  []string{"tenant", "add_headers"},
+				ResponseActions: []string{"strip_headers"},
+			},
+		},
+		// 2
+		{
+			ann:     types.BackendAnnotations{LuaRequestAction: "tenant,add headers"},
+			scripts: true,
+			expected: hatypes.LuaActionsConfig{
+				RequestActions: []string{"tenant"},
+			},
+			expLogging: "WARN skipping invalid Lua action 'add headers' in lua-request-action on ingress 'default/app'",
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{LuaRequestAction: "tenant"},
+			expLogging: "WARN ignoring Lua actions on ingress 'default/app': lua-scripts is not configured",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{LuaRequestAction: "tenant"},
+			scripts:    true,
+			modeTCP:    true,
+			expLogging: "WARN ignoring Lua actions on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		if test.scripts {
+			c.haproxy.Global().LuaScripts = []hatypes.LuaScript{{Filename: "/etc/haproxy/lua/default_scripts_tenant.lua"}}
+		}
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendLua(d)
+		if !reflect.DeepEqual(d.backend.Lua, test.expected) {
+			t.Errorf("lua on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Lua)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendSPOE(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		expected   []string
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:      types.BackendAnnotations{SPOEFilter: "auth"},
+			expected: []string{"auth"},
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{SPOEFilter: "auth, mirror, auth"},
+			expected: []string{"auth", "mirror"},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{SPOEFilter: "scoring,mirror"},
+			expected:   []string{"mirror"},
+			expLogging: "WARN skipping SPOE filter 'scoring' on ingress 'default/app': agent not found",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.haproxy.Global().SPOEAgents = []*hatypes.SPOEAgent{{Name: "auth"}, {Name: "mirror"}}
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendSPOE(d)
+		if !reflect.DeepEqual(d.backend.SPOEFilters, test.expected) {
+			t.Errorf("spoe filters on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.SPOEFilters)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendCache(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		version    hatypes.Version
+		modeTCP    bool
+		expected   hatypes.CacheConfig
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:      types.BackendAnnotations{CacheEnable: true},
+			expected: hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, TotalMaxSize: 4},
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{CacheEnable: true, CacheMaxAge: 300, CacheMaxObjectSize: "512k"},
+			version:  hatypes.Version{Major: 1, Minor: 9},
+			expected: hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 300, MaxObjectSize: 524288, TotalMaxSize: 4},
+		},
+		// 3
+		{
+			ann:      types.BackendAnnotations{CacheEnable: true, CacheMaxObjectSize: "5m"},
+			version:  hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, MaxObjectSize: 5242880, TotalMaxSize: 10},
+		},
+		// 4
+		{
+			ann:      types.BackendAnnotations{CacheEnable: true, CacheMaxAge: -1, CacheMaxObjectSize: "5x"},
+			expected: hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, TotalMaxSize: 4},
+			expLogging: `
+WARN invalid cache-max-age '-1' on ingress 'default/app', using '60' instead
+WARN ignoring invalid cache-max-object-size '5x' on ingress 'default/app'`,
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{CacheEnable: true},
+			modeTCP:    true,
+			expLogging: "WARN ignoring cache on ingress 'default/app': backend is in TCP mode",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{CacheEnable: true, CacheMaxObjectSize: "512k"},
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expected:   hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, TotalMaxSize: 4},
+			expLogging: "WARN ignoring cache-max-object-size on ingress 'default/app': needs HAProxy 1.9 or newer, found version 1.8",
+		},
+		// 7
+		{
+			ann:      types.BackendAnnotations{CacheEnable: true, CacheMaxObjectSize: "2047m"},
+			version:  hatypes.Version{Major: 2, Minor: 0},
+			expected: hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, MaxObjectSize: 2146435072, TotalMaxSize: 4094},
+		},
+		// 8
+		{
+			ann:        types.BackendAnnotations{CacheEnable: true, CacheMaxObjectSize: "3g"},
+			version:    hatypes.Version{Major: 2, Minor: 0},
+			expected:   hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, TotalMaxSize: 4},
+			expLogging: "WARN ignoring cache-max-object-size '3g' on ingress 'default/app': objects should be up to half of the maximum cache size of 4095 megabytes",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ID = "d1_app_8080"
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendCache(d)
+		if !reflect.DeepEqual(d.backend.Cache, test.expected) {
+			t.Errorf("cache on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Cache)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendCompression(t *testing.T) {
+	defaultTypes := []string{"text/html", "text/plain", "text/css", "application/javascript", "application/json"}
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		version    hatypes.Version
+		modeTCP    bool
+		expected   hatypes.CompressionConfig
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:      types.BackendAnnotations{Compression: true},
+			expected: hatypes.CompressionConfig{Algo: "gzip", Types: defaultTypes},
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{Compression: true, CompressionTypes: "application/json, text/plain", CompressionMinSize: "1k"},
+			version:  hatypes.Version{Major: 2, Minor: 8},
+			expected: hatypes.CompressionConfig{Algo: "gzip", MinSize: 1024, Types: []string{"application/json", "text/plain"}},
+		},
+		// 3
+		{
+			ann:      types.BackendAnnotations{Compression: true, CompressionTypes: "application/json application/problem+json"},
+			expected: hatypes.CompressionConfig{Algo: "gzip", Types: []string{"application/json", "application/problem+json"}},
+		},
+		// 4
+		{
+			ann:      types.BackendAnnotations{Compression: true, CompressionTypes: "json", CompressionMinSize: "1x"},
+			expected: hatypes.CompressionConfig{Algo: "gzip", Types: defaultTypes},
+			expLogging: `
+WARN skipping invalid compression type 'json' on ingress 'default/app'
+WARN ignoring invalid compression-min-size '1x' on ingress 'default/app'`,
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{Compression: true},
+			modeTCP:    true,
+			expLogging: "WARN ignoring compression on ingress 'default/app': backend is in TCP mode",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{Compression: true, CompressionMinSize: "1k"},
+			version:    hatypes.Version{Major: 2, Minor: 6},
+			expected:   hatypes.CompressionConfig{Algo: "gzip", Types: defaultTypes},
+			expLogging: "WARN ignoring compression-min-size on ingress 'default/app': needs HAProxy 2.8 or newer, found version 2.6",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendCompression(d)
+		if !reflect.DeepEqual(d.backend.Compression, test.expected) {
+			t.Errorf("compression on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.Compression)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendForwardFor(t *testing.T) {
+	testCases := []struct {
+		ann        types.BackendAnnotations
+		expected   string
+		expHeaders hatypes.ForwardedHeadersConfig
+		expLogging string
+	}{
+		// 0
+		{
+			expected: "add",
+		},
+		// 1
+		{
+			ann:        types.BackendAnnotations{Forwardfor: "non"},
+			expected:   "add",
+			expLogging: "WARN invalid forwardfor value 'non' on ingress 'default/app', using 'add' instead",
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{Forwardfor: "add"},
+			expected: "add",
+		},
+		// 3
+		{
+			ann:      types.BackendAnnotations{Forwardfor: "ignore"},
+			expected: "ignore",
+		},
+		// 4
+		{
+			ann:      types.BackendAnnotations{Forwardfor: "ifmissing"},
+			expected: "ifmissing",
+		},
+		// 5
+		{
+			ann:      types.BackendAnnotations{Forwardfor: "update"},
+			expected: "update",
+		},
+		// 6
+		{
+			ann:        types.BackendAnnotations{Forwardfor: "add", ForwardedHeaders: "proto,host"},
+			expected:   "add",
+			expHeaders: hatypes.ForwardedHeadersConfig{Host: true, Proto: true},
+		},
+		// 7
+		{
+			ann:        types.BackendAnnotations{Forwardfor: "add", ForwardedHeaders: "port,for"},
+			expected:   "add",
+			expHeaders: hatypes.ForwardedHeadersConfig{Port: true},
+			expLogging: "WARN ignoring invalid header 'for' in forwarded-headers on ingress 'default/app'",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &test.ann)
+		c.createUpdater().buildBackendForwardFor(d)
+		if d.backend.ForwardFor != test.expected {
+			t.Errorf("forwardfor differs on %d: expected '%s' but was '%s'", i, test.expected, d.backend.ForwardFor)
+		}
+		if d.backend.ForwardedHeaders != test.expHeaders {
+			t.Errorf("forwarded-headers differs on %d: expected %+v but was %+v", i, test.expHeaders, d.backend.ForwardedHeaders)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendLogCapture(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		modeTCP    bool
+		noSyslog   bool
+		expected   hatypes.LogCaptureConfig
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann: types.BackendAnnotations{LogCaptureReqHeaders: "User-Agent,X-Tenant"},
+			expected: hatypes.LogCaptureConfig{
+				RequestHeaders: []string{"User-Agent", "X-Tenant"},
+			},
+		},
+		// 2
+		{
+			ann: types.BackendAnnotations{LogCaptureReqHeaders: "User-Agent, user-agent", LogCaptureResHeaders: "Content-Type"},
+			expected: hatypes.LogCaptureConfig{
+				RequestHeaders:  []string{"User-Agent"},
+				ResponseHeaders: []string{"Content-Type"},
+			},
+		},
+		// 3
+		{
+			ann: types.BackendAnnotations{LogCaptureReqHeaders: "X-Tenant,X Tenant", LogCaptureResHeaders: "res.hdr(x)"},
+			expected: hatypes.LogCaptureConfig{
+				RequestHeaders: []string{"X-Tenant"},
+			},
+			expLogging: `
+WARN skipping invalid header name 'X Tenant' in log-capture-request-headers on ingress 'default/app'
+WARN skipping invalid header name 'res.hdr(x)' in log-capture-response-headers on ingress 'default/app'`,
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{LogCaptureReqHeaders: "User-Agent"},
+			modeTCP:    true,
+			expLogging: "WARN ignoring log-capture headers on ingress 'default/app': backend is in TCP mode",
+		},
+		// 5
+		{
+			ann:        types.BackendAnnotations{LogCaptureReqHeaders: "User-Agent"},
+			noSyslog:   true,
+			expLogging: "WARN ignoring log-capture headers on ingress 'default/app': syslog-endpoint is not configured",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		if !test.noSyslog {
+			c.haproxy.Global().Syslog.Endpoint = "127.0.0.1:514"
+		}
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendLogCapture(d)
+		if !reflect.DeepEqual(d.backend.LogCapture, test.expected) {
+			t.Errorf("log-capture on %d differs - expected: %+v - actual: %+v", i, test.expected, d.backend.LogCapture)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendLogFields(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		modeTCP    bool
+		expected   string
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:      types.BackendAnnotations{LogFields: " %sslv %[req.hdr(x-tenant)] "},
+			expected: "%sslv %[req.hdr(x-tenant)]",
+		},
+		// 2
+		{
+			ann:        types.BackendAnnotations{LogFields: `%{+Q}[req.hdr(x-tenant)] "x"`},
+			expLogging: "WARN ignoring log-fields on ingress 'default/app': quotes and line breaks are not allowed",
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{LogFields: "%sslv"},
+			modeTCP:    true,
+			expLogging: "WARN ignoring log-fields on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendLogFields(d)
+		if d.backend.LogFields != test.expected {
+			t.Errorf("log-fields on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.LogFields)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestBackendHTTPBufferRequest(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		modeTCP    bool
+		expected   bool
+		expLogging string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			ann:      types.BackendAnnotations{HTTPBufferRequest: true},
+			expected: true,
+		},
+		// 2
+		{
+			ann:      types.BackendAnnotations{HTTPBufferRequest: true, ProxyBodySize: "8k"},
+			expected: true,
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{HTTPBufferRequest: true, ProxyBodySize: "10m"},
+			expected:   true,
+			expLogging: "WARN http-buffer-request on ingress 'default/app' only waits for the first 16384 bytes of the body, proxy-body-size '10m' allows bigger requests",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{HTTPBufferRequest: true},
+			modeTCP:    true,
+			expLogging: "WARN ignoring http-buffer-request on ingress 'default/app': backend is in TCP mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createBackendData("default", "app", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		c.createUpdater().buildBackendHTTPBufferRequest(d)
+		if d.backend.HTTPBufferRequest != test.expected {
+			t.Errorf("http-buffer-request on %d differs - expected: %v - actual: %v", i, test.expected, d.backend.HTTPBufferRequest)
+		}
+		c.logger.CompareLogging(test.expLogging)
 		c.teardown()
 	}
 }
@@ -18,9 +18,16 @@ package annotations
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/ghodss/yaml"
+	api "k8s.io/api/core/v1"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
 )
 
@@ -89,8 +96,51 @@ func (c *updater) buildGlobalTimeout(d *globalData) {
 	copyHAProxyTime(&d.global.Timeout.Stop, d.config.TimeoutStop)
 }
 
+func (c *updater) buildGlobalSyslog(d *globalData) {
+	if d.config.SyslogEndpoint != "" {
+		d.global.Syslog.Endpoint = d.config.SyslogEndpoint
+	} else {
+		// empty if the controller isn't listening to the logs
+		d.global.Syslog.Endpoint = c.options.LogSocket
+	}
+}
+
+var httpLogFormatPresets = map[string]string{
+	"default": "",
+	"clf":     `%{+Q}o %{-Q}ci - - [%trg] %r %ST %B "" "" %cp %ms %ft %b %s %TR %Tw %Tc %Tr %Ta %tsc %ac %fc %bc %sc %rc %sq %bq %CC %CS %hrl %hsl`,
+	"tls":     hatypes.DefaultHTTPLogFormat + " %sslv/%sslc",
+}
+
+func (c *updater) buildGlobalHTTPLogFormat(d *globalData) {
+	format := d.config.HTTPLogFormat
+	if format == "" {
+		return
+	}
+	if preset, found := httpLogFormatPresets[format]; found {
+		d.global.Syslog.HTTPLogFormat = preset
+	} else if !strings.Contains(format, "%") {
+		c.logger.Warn("ignoring http-log-format '%s': unknown preset", format)
+	} else {
+		d.global.Syslog.HTTPLogFormat = format
+	}
+}
+
 func (c *updater) buildGlobalSSL(d *globalData) {
 	d.global.SSL.Ciphers = d.config.SSLCiphers
+	if d.config.TLSALPN == "" || alpnRegex.MatchString(d.config.TLSALPN) {
+		d.global.SSL.ALPN = d.config.TLSALPN
+	} else {
+		c.logger.Warn("ignoring invalid tls-alpn: %s", d.config.TLSALPN)
+	}
+	if !d.config.UseHTTP2 && d.global.SSL.ALPN != "" {
+		var protos []string
+		for _, proto := range strings.Split(d.global.SSL.ALPN, ",") {
+			if proto != "h2" {
+				protos = append(protos, proto)
+			}
+		}
+		d.global.SSL.ALPN = strings.Join(protos, ",")
+	}
 	d.global.SSL.Options = d.config.SSLOptions
 	if d.config.SSLDHParam != "" {
 		if dhFile, err := c.cache.GetDHSecretPath(d.config.SSLDHParam); err == nil {
@@ -102,7 +152,197 @@ func (c *updater) buildGlobalSSL(d *globalData) {
 	d.global.SSL.DHParam.DefaultMaxSize = d.config.SSLDHDefaultMaxSize
 	d.global.SSL.Engine = d.config.SSLEngine
 	d.global.SSL.ModeAsync = d.config.SSLModeAsync
+	d.global.SSL.OCSPUpdate = d.config.SSLOCSPUpdate
 	d.global.SSL.HeadersPrefix = d.config.SSLHeadersPrefix
+	if d.config.SSLCertRuntimeUpdate {
+		if c.options.HAProxyVersion.AtLeast(2, 1) {
+			d.global.SSL.CertRuntimeUpdate = true
+		} else {
+			c.logger.Warn("ignoring ssl-cert-runtime-update: needs HAProxy 2.1 or newer, found version %s", c.options.HAProxyVersion)
+		}
+	}
+}
+
+func (c *updater) buildGlobalQUIC(d *globalData) {
+	if !d.config.UseQUIC {
+		return
+	}
+	if !c.options.HAProxyVersion.AtLeast(2, 6) {
+		c.logger.Warn("ignoring use-quic: HTTP/3 needs HAProxy 2.6 or newer, found version %s", c.options.HAProxyVersion)
+		return
+	}
+	d.global.Bind.QUIC = true
+	d.global.Bind.QUICAltSvcMaxAge = d.config.QUICAltSvcMaxAge
+	d.global.Bind.QUICAltSvcPort = c.quicAltSvcPort()
+}
+
+// quicAltSvcPort returns the port advertised to HTTP/3 clients: the UDP port of
+// the published service which targets the QUIC binds, 443 if the controller
+// isn't published by a service, or 0 if the published service doesn't expose QUIC
+func (c *updater) quicAltSvcPort() int {
+	svc, err := c.cache.GetPublishService()
+	if err != nil {
+		c.logger.Warn("advertising HTTP/3 on the UDP port 443: %v", err)
+		return 443
+	}
+	if svc == nil {
+		return 443
+	}
+	for _, port := range svc.Spec.Ports {
+		target := port.TargetPort.IntValue()
+		if target == 0 {
+			target = int(port.Port)
+		}
+		if port.Protocol == api.ProtocolUDP && target == 443 {
+			return int(port.Port)
+		}
+	}
+	c.logger.Warn("ignoring alt-svc of use-quic: published service '%s/%s' doesn't expose the UDP port 443", svc.Namespace, svc.Name)
+	return 0
+}
+
+func (c *updater) buildGlobalBind(d *globalData) {
+	d.global.Bind.HTTPAddr = c.parseBindAddress("bind-ip-addr-http", d.config.BindIPAddrHTTP)
+	d.global.Bind.TCPAddr = c.parseBindAddress("bind-ip-addr-tcp", d.config.BindIPAddrTCP)
+}
+
+// parseBindAddress reads a comma-separated list of IPv4 and IPv6 addresses,
+// `*` means all the IPv4 addresses and `::` all the IPv6 addresses
+func (c *updater) parseBindAddress(key, value string) hatypes.BindAddress {
+	var addrs hatypes.BindAddress
+	for _, addr := range utils.Split(value, ",") {
+		addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if addr == "*" {
+			addrs = append(addrs, "")
+		} else if net.ParseIP(addr) != nil {
+			addrs = append(addrs, addr)
+		} else {
+			c.logger.Warn("ignoring invalid address '%s' on %s", addr, key)
+		}
+	}
+	if len(addrs) == 1 && addrs[0] == "" {
+		// the same as an empty list, all the IPv4 addresses
+		return nil
+	}
+	return addrs
+}
+
+func (c *updater) buildGlobalDNS(d *globalData) {
+	d.global.DNS.AcceptedPayloadSize = d.config.DNSAcceptedPayloadSize
+	d.global.DNS.HoldObsolete = d.config.DNSHoldObsolete
+	d.global.DNS.HoldValid = d.config.DNSHoldValid
+	d.global.DNS.TimeoutRetry = d.config.DNSTimeoutRetry
+	for _, line := range utils.Split(d.config.DNSResolvers, "\n") {
+		if line == "" {
+			continue
+		}
+		resolverData := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(resolverData[0])
+		if len(resolverData) != 2 || name == "" {
+			c.logger.Warn("ignoring misconfigured DNS resolver: %s", line)
+			continue
+		}
+		if d.global.DNS.FindResolver(name) != nil {
+			c.logger.Warn("ignoring duplicated DNS resolver: %s", name)
+			continue
+		}
+		resolver := &hatypes.DNSResolver{Name: name}
+		for _, nameserver := range utils.Split(resolverData[1], ",") {
+			ip, port, err := net.SplitHostPort(nameserver)
+			if err != nil {
+				ip, port = strings.TrimSuffix(strings.TrimPrefix(nameserver, "["), "]"), "53"
+			}
+			if net.ParseIP(ip) == nil {
+				c.logger.Warn("ignoring invalid nameserver '%s' on DNS resolver '%s'", nameserver, name)
+				continue
+			}
+			resolver.Nameservers = append(resolver.Nameservers, &hatypes.DNSNameserver{
+				Name:     fmt.Sprintf("ns_%s_%s", ip, port),
+				Endpoint: net.JoinHostPort(ip, port),
+			})
+		}
+		if len(resolver.Nameservers) == 0 {
+			c.logger.Warn("ignoring DNS resolver without nameservers: %s", name)
+			continue
+		}
+		d.global.DNS.Resolvers = append(d.global.DNS.Resolvers, resolver)
+	}
+}
+
+const peersName = "ingress"
+
+func (c *updater) buildGlobalPeers(d *globalData) {
+	if d.config.PeersService == "" {
+		return
+	}
+	port := d.config.PeersPort
+	if port <= 0 || port > 65535 {
+		c.logger.Warn("ignoring peers-service due to an invalid peers-port: %d", port)
+		return
+	}
+	svc, err := c.cache.GetService(d.config.PeersService)
+	if err != nil {
+		c.logger.Warn("ignoring peers-service '%s': %v", d.config.PeersService, err)
+		return
+	}
+	endpoints, err := c.cache.GetEndpoints(svc)
+	if err != nil {
+		c.logger.Warn("ignoring peers-service '%s': %v", d.config.PeersService, err)
+		return
+	}
+	// the name of a peer is the name of the controller pod, which is also
+	// its hostname and the default name HAProxy uses to find the local peer
+	peers := map[string]*hatypes.Peer{}
+	addPeers := func(addresses []api.EndpointAddress) {
+		for _, addr := range addresses {
+			if addr.TargetRef == nil {
+				continue
+			}
+			peers[addr.TargetRef.Name] = &hatypes.Peer{
+				Name:     addr.TargetRef.Name,
+				Endpoint: net.JoinHostPort(addr.IP, strconv.Itoa(port)),
+			}
+		}
+	}
+	for _, subset := range endpoints.Subsets {
+		addPeers(subset.Addresses)
+		addPeers(subset.NotReadyAddresses)
+	}
+	if len(peers) == 0 {
+		c.logger.Warn("ignoring peers-service '%s': no controller pod found", d.config.PeersService)
+		return
+	}
+	d.global.Peers.Name = peersName
+	d.global.Peers.LocalPeer = c.options.LocalPodName
+	for _, peer := range peers {
+		d.global.Peers.Peers = append(d.global.Peers.Peers, peer)
+	}
+	sort.Slice(d.global.Peers.Peers, func(i, j int) bool {
+		return d.global.Peers.Peers[i].Name < d.global.Peers.Peers[j].Name
+	})
+}
+
+func (c *updater) buildGlobalProxyProtocol(d *globalData) {
+	if !d.config.UseProxyProtocol {
+		return
+	}
+	for _, frontend := range utils.Split(d.config.ProxyProtocolFrontends, ",") {
+		switch frontend {
+		case "http":
+			d.global.Bind.HTTPAcceptProxy = true
+		case "https":
+			d.global.Bind.HTTPSAcceptProxy = true
+		default:
+			c.logger.Warn("ignoring invalid frontend '%s' in proxy-protocol-frontends", frontend)
+		}
+	}
+	for _, cidr := range utils.Split(d.config.ProxyProtocolTrustedSources, ",") {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			c.logger.Warn("skipping invalid cidr '%s' in proxy-protocol-trusted-sources", cidr)
+		} else {
+			d.global.Bind.AcceptProxySources = append(d.global.Bind.AcceptProxySources, cidr)
+		}
+	}
 }
 
 func (c *updater) buildGlobalModSecurity(d *globalData) {
@@ -112,19 +352,238 @@ func (c *updater) buildGlobalModSecurity(d *globalData) {
 	d.global.ModSecurity.Timeout.Processing = d.config.ModsecurityTimeoutProcessing
 }
 
+func (c *updater) buildGlobalForwardedHeaders(d *globalData) {
+	if !d.config.UseForwardedHeaders {
+		return
+	}
+	var trusted []string
+	for _, cidr := range utils.Split(d.config.TrustedProxies, ",") {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			c.logger.Warn("skipping invalid cidr '%s' in trusted-proxies", cidr)
+		} else {
+			trusted = append(trusted, cidr)
+		}
+	}
+	if len(trusted) == 0 {
+		c.logger.Warn("ignoring use-forwarded-headers: trusted-proxies is missing")
+		return
+	}
+	d.global.TrustedProxies = trusted
+}
+
+func (c *updater) buildGlobalLocalZone(d *globalData) {
+	if d.config.LocalZone != "" {
+		d.global.LocalZone = d.config.LocalZone
+		return
+	}
+	if c.options.LocalNodeName == "" {
+		return
+	}
+	zone, err := c.cache.GetNodeZone(c.options.LocalNodeName)
+	if err != nil {
+		c.logger.Warn("cannot read the local zone: %v", err)
+		return
+	}
+	d.global.LocalZone = zone
+}
+
+func (c *updater) buildGlobalLua(d *globalData) {
+	for _, configMap := range utils.Split(d.config.LuaScripts, ",") {
+		files, err := c.cache.GetLuaScriptPaths(configMap)
+		if err != nil {
+			c.logger.Warn("skipping Lua scripts of configmap '%s': %v", configMap, err)
+			continue
+		}
+		for _, file := range files {
+			d.global.LuaScripts = append(d.global.LuaScripts, hatypes.LuaScript{
+				Filename: file.Filename,
+				SHA1Hash: file.SHA1Hash,
+			})
+		}
+	}
+}
+
+type spoeAgentConfig struct {
+	Name      string   `json:"name"`
+	Endpoints []string `json:"endpoints"`
+	Messages  []struct {
+		Name  string `json:"name"`
+		Args  string `json:"args"`
+		Event string `json:"event"`
+	} `json:"messages"`
+	Timeout struct {
+		Hello      string `json:"hello"`
+		Idle       string `json:"idle"`
+		Processing string `json:"processing"`
+	} `json:"timeout"`
+	VarPrefix string `json:"var-prefix"`
+}
+
 var (
-	forwardRegex = regexp.MustCompile(`^(add|ignore|ifmissing)$`)
+	spoeNameRegex   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	spoeEventsRegex = regexp.MustCompile(`^(on-backend-tcp-request|on-backend-http-request|on-server-session|on-tcp-response|on-http-response)$`)
 )
 
-func (c *updater) buildGlobalForwardFor(d *globalData) {
-	if forwardRegex.MatchString(d.config.Forwardfor) {
-		d.global.ForwardFor = d.config.Forwardfor
-	} else {
-		if d.config.Forwardfor != "" {
-			c.logger.Warn("Invalid forwardfor value option on configmap: '%s'. Using 'add' instead", d.config.Forwardfor)
+func (c *updater) buildGlobalSPOE(d *globalData) {
+	if d.config.SPOEAgents == "" {
+		return
+	}
+	var agents []spoeAgentConfig
+	if err := yaml.Unmarshal([]byte(d.config.SPOEAgents), &agents); err != nil {
+		c.logger.Warn("ignoring invalid spoe-agents configuration: %v", err)
+		return
+	}
+	names := map[string]bool{}
+	for _, agent := range agents {
+		if !spoeNameRegex.MatchString(agent.Name) || agent.Name == "modsecurity" {
+			c.logger.Warn("skipping SPOE agent with invalid name: '%s'", agent.Name)
+			continue
+		}
+		if names[agent.Name] {
+			c.logger.Warn("skipping duplicated SPOE agent: %s", agent.Name)
+			continue
+		}
+		if len(agent.Endpoints) == 0 {
+			c.logger.Warn("skipping SPOE agent '%s': missing endpoints", agent.Name)
+			continue
+		}
+		spoeAgent := &hatypes.SPOEAgent{
+			Name:      agent.Name,
+			Endpoints: agent.Endpoints,
+			Timeout: hatypes.SPOETimeoutConfig{
+				Hello:      agent.Timeout.Hello,
+				Idle:       agent.Timeout.Idle,
+				Processing: agent.Timeout.Processing,
+			},
+			VarPrefix: agent.VarPrefix,
+		}
+		// same defaults of the modsecurity agent
+		if spoeAgent.Timeout.Hello == "" {
+			spoeAgent.Timeout.Hello = "100ms"
 		}
-		d.global.ForwardFor = "add"
+		if spoeAgent.Timeout.Idle == "" {
+			spoeAgent.Timeout.Idle = "30s"
+		}
+		if spoeAgent.Timeout.Processing == "" {
+			spoeAgent.Timeout.Processing = "1s"
+		}
+		if spoeAgent.VarPrefix == "" {
+			spoeAgent.VarPrefix = agent.Name
+		}
+		messages := map[string]bool{}
+		for _, msg := range agent.Messages {
+			if !spoeNameRegex.MatchString(msg.Name) || messages[msg.Name] {
+				c.logger.Warn("skipping invalid or duplicated message '%s' of SPOE agent '%s'", msg.Name, agent.Name)
+				continue
+			}
+			if !spoeEventsRegex.MatchString(msg.Event) {
+				c.logger.Warn("skipping message '%s' of SPOE agent '%s': invalid event '%s'", msg.Name, agent.Name, msg.Event)
+				continue
+			}
+			messages[msg.Name] = true
+			spoeAgent.Messages = append(spoeAgent.Messages, &hatypes.SPOEMessage{
+				Name:  msg.Name,
+				Args:  msg.Args,
+				Event: msg.Event,
+			})
+		}
+		if len(spoeAgent.Messages) == 0 {
+			c.logger.Warn("skipping SPOE agent '%s': missing valid messages", agent.Name)
+			continue
+		}
+		names[agent.Name] = true
+		d.global.SPOEAgents = append(d.global.SPOEAgents, spoeAgent)
+	}
+}
+
+const statsUserlistName = "_stats"
+
+func (c *updater) buildGlobalStats(d *globalData) {
+	if d.config.StatsPort <= 0 {
+		return
+	}
+	d.global.Stats.Port = d.config.StatsPort
+	d.global.Stats.BindAddr = c.parseBindAddress("bind-ip-addr-stats", d.config.BindIPAddrStats)
+	d.global.Stats.AcceptProxy = d.config.StatsProxyProtocol
+	d.global.Stats.Admin = d.config.StatsAdmin
+	uri := d.config.StatsURI
+	if !strings.HasPrefix(uri, "/") || strings.ContainsAny(uri, " \t") {
+		if uri != "" {
+			c.logger.Warn("ignoring invalid stats-uri '%s', using '/' instead", uri)
+		}
+		uri = "/"
+	}
+	d.global.Stats.URI = uri
+	if secretName := d.config.StatsAuthSecret; secretName != "" {
+		if d.config.StatsAuth != "" {
+			c.logger.Warn("ignoring stats-auth: stats-auth-secret is also configured")
+		}
+		content, err := c.cache.GetSecretContent(secretName, "auth")
+		if err != nil {
+			c.logger.Error("error reading stats basic authentication: %v", err)
+			// an empty userlist denies every request instead of exposing the stats page
+			content = nil
+		}
+		users, errs := c.buildBackendAuthHTTPExtractUserlist("stats", secretName, string(content))
+		for _, err := range errs {
+			c.logger.Warn("ignoring malformed usr/passwd on secret '%s', declared on stats-auth-secret: %v", secretName, err)
+		}
+		c.haproxy.AddUserlist(statsUserlistName, users)
+		d.global.Stats.Userlist = statsUserlistName
+	} else if d.config.StatsAuth != "" {
+		d.global.Stats.Auth = d.config.StatsAuth
+	}
+	if secretName := d.config.StatsSSLCert; secretName != "" {
+		if file, err := c.cache.GetTLSSecretPath(secretName); err == nil {
+			d.global.Stats.TLSFilename = file.Filename
+			d.global.Stats.TLSHash = file.SHA1Hash
+		} else {
+			c.logger.Warn("using plain http on stats page: error reading stats-ssl-cert: %v", err)
+		}
+	}
+}
+
+func (c *updater) buildGlobalRuntimeAPI(d *globalData) {
+	if d.config.RuntimeAPIPort <= 0 {
+		return
+	}
+	var whitelist []string
+	for _, cidr := range utils.Split(d.config.RuntimeAPIWhitelist, ",") {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			c.logger.Warn("skipping invalid cidr '%s' in runtime-api-whitelist", cidr)
+		} else {
+			whitelist = append(whitelist, cidr)
+		}
+	}
+	if len(whitelist) == 0 {
+		// the runtime API is declared with admin level, it should never be exposed without restriction
+		c.logger.Warn("ignoring runtime-api-port: runtime-api-whitelist is missing")
+		return
+	}
+	d.global.RuntimeAPI.Port = d.config.RuntimeAPIPort
+	d.global.RuntimeAPI.Whitelist = whitelist
+}
+
+func (c *updater) buildGlobalAcme(d *globalData) {
+	if d.config.AcmeEndpoint == "" || c.options.AcmeSocket == "" {
+		return
+	}
+	if d.config.AcmeEmails == "" {
+		c.logger.Warn("ignoring acme-endpoint configmap option: acme-emails is missing")
+		return
+	}
+	expiring := d.config.AcmeExpiring
+	if expiring < 1 {
+		c.logger.Warn("invalid value of acme-expiring configmap option (%v), using 30", expiring)
+		expiring = 30
 	}
+	d.global.Acme.Enabled = true
+	d.global.Acme.Emails = d.config.AcmeEmails
+	d.global.Acme.Endpoint = d.config.AcmeEndpoint
+	d.global.Acme.Expiring = expiring
+	d.global.Acme.Prefix = "/.well-known/acme-challenge/"
+	d.global.Acme.Socket = c.options.AcmeSocket
+	d.global.Acme.TermsAgreed = d.config.AcmeTermsAgreed
 }
 
 func (c *updater) buildGlobalCustomConfig(d *globalData) {
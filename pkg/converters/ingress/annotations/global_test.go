@@ -20,7 +20,13 @@ import (
 	"reflect"
 	"testing"
 
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 )
 
 func TestModSecurity(t *testing.T) {
@@ -57,41 +63,41 @@ func TestModSecurity(t *testing.T) {
 	}
 }
 
-func TestForwardFor(t *testing.T) {
+func TestGlobalALPN(t *testing.T) {
 	testCases := []struct {
-		conf     string
+		alpn     string
+		http2    bool
 		expected string
 		logging  string
 	}{
 		// 0
 		{
-			conf:     "",
-			expected: "add",
-			logging:  "",
+			alpn:     "h2,http/1.1",
+			http2:    true,
+			expected: "h2,http/1.1",
 		},
 		// 1
 		{
-			conf:     "non",
-			expected: "add",
-			logging:  "WARN Invalid forwardfor value option on configmap: 'non'. Using 'add' instead",
+			alpn:     "h2,http/1.1",
+			expected: "http/1.1",
 		},
 		// 2
 		{
-			conf:     "add",
-			expected: "add",
-			logging:  "",
+			alpn:     "h2",
+			expected: "",
 		},
 		// 3
 		{
-			conf:     "ignore",
-			expected: "ignore",
-			logging:  "",
+			alpn:     "",
+			http2:    true,
+			expected: "",
 		},
 		// 4
 		{
-			conf:     "ifmissing",
-			expected: "ifmissing",
-			logging:  "",
+			alpn:     "h2 http/1.1",
+			http2:    true,
+			expected: "",
+			logging:  "WARN ignoring invalid tls-alpn: h2 http/1.1",
 		},
 	}
 	for i, test := range testCases {
@@ -99,12 +105,899 @@ func TestForwardFor(t *testing.T) {
 		u := c.createUpdater()
 		d := c.createGlobalData(&types.Config{
 			ConfigGlobals: types.ConfigGlobals{
-				Forwardfor: test.conf,
+				TLSALPN:  test.alpn,
+				UseHTTP2: test.http2,
+			},
+		})
+		u.buildGlobalSSL(d)
+		if d.global.SSL.ALPN != test.expected {
+			t.Errorf("ALPN differs on %d: expected '%s' but was '%s'", i, test.expected, d.global.SSL.ALPN)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalSSLCertRuntimeUpdate(t *testing.T) {
+	testCases := []struct {
+		update   bool
+		version  hatypes.Version
+		expected bool
+		logging  string
+	}{
+		// 0
+		{
+			version: hatypes.Version{Major: 2, Minor: 1},
+		},
+		// 1
+		{
+			update:   true,
+			version:  hatypes.Version{Major: 2, Minor: 1},
+			expected: true,
+		},
+		// 2
+		{
+			update:  true,
+			version: hatypes.Version{Major: 2, Minor: 0},
+			logging: "WARN ignoring ssl-cert-runtime-update: needs HAProxy 2.1 or newer, found version 2.0",
+		},
+		// 3
+		{
+			update:  true,
+			logging: "WARN ignoring ssl-cert-runtime-update: needs HAProxy 2.1 or newer, found version unknown",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{
+			ConfigGlobals: types.ConfigGlobals{
+				SSLCertRuntimeUpdate: test.update,
+			},
+		})
+		u.buildGlobalSSL(d)
+		if d.global.SSL.CertRuntimeUpdate != test.expected {
+			t.Errorf("cert runtime update differs on %d: expected %t but was %t", i, test.expected, d.global.SSL.CertRuntimeUpdate)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalQUIC(t *testing.T) {
+	publishSvc := func(ports ...api.ServicePort) *api.Service {
+		return &api.Service{
+			ObjectMeta: meta.ObjectMeta{Namespace: "ingress", Name: "haproxy"},
+			Spec:       api.ServiceSpec{Ports: ports},
+		}
+	}
+	tcp443 := api.ServicePort{Protocol: api.ProtocolTCP, Port: 443}
+	testCases := []struct {
+		useQUIC    bool
+		version    hatypes.Version
+		publishSvc *api.Service
+		expected   bool
+		expPort    int
+		logging    string
+	}{
+		// 0
+		{
+			useQUIC: false,
+			version: hatypes.Version{Major: 2, Minor: 6},
+		},
+		// 1
+		{
+			useQUIC:  true,
+			version:  hatypes.Version{Major: 2, Minor: 6},
+			expected: true,
+			expPort:  443,
+		},
+		// 2
+		{
+			useQUIC: true,
+			version: hatypes.Version{Major: 2, Minor: 4},
+			logging: "WARN ignoring use-quic: HTTP/3 needs HAProxy 2.6 or newer, found version 2.4",
+		},
+		// 3
+		{
+			useQUIC: true,
+			logging: "WARN ignoring use-quic: HTTP/3 needs HAProxy 2.6 or newer, found version unknown",
+		},
+		// 4
+		{
+			useQUIC:    true,
+			version:    hatypes.Version{Major: 2, Minor: 6},
+			publishSvc: publishSvc(tcp443, api.ServicePort{Protocol: api.ProtocolUDP, Port: 443}),
+			expected:   true,
+			expPort:    443,
+		},
+		// 5
+		{
+			useQUIC:    true,
+			version:    hatypes.Version{Major: 2, Minor: 6},
+			publishSvc: publishSvc(tcp443, api.ServicePort{Protocol: api.ProtocolUDP, Port: 8443, TargetPort: intstr.FromInt(443)}),
+			expected:   true,
+			expPort:    8443,
+		},
+		// 6
+		{
+			useQUIC:    true,
+			version:    hatypes.Version{Major: 2, Minor: 6},
+			publishSvc: publishSvc(tcp443, api.ServicePort{Protocol: api.ProtocolUDP, Port: 443, TargetPort: intstr.FromInt(8443)}),
+			expected:   true,
+			logging:    "WARN ignoring alt-svc of use-quic: published service 'ingress/haproxy' doesn't expose the UDP port 443",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.HAProxyVersion = test.version
+		c.cache.PublishSvc = test.publishSvc
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{
+			ConfigGlobals: types.ConfigGlobals{
+				QUICAltSvcMaxAge: 86400,
+				UseQUIC:          test.useQUIC,
+			},
+		})
+		u.buildGlobalQUIC(d)
+		if d.global.Bind.QUIC != test.expected || d.global.Bind.QUICAltSvcPort != test.expPort {
+			t.Errorf("QUIC differs on %d: expected %t/%d but was %t/%d", i, test.expected, test.expPort, d.global.Bind.QUIC, d.global.Bind.QUICAltSvcPort)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalBind(t *testing.T) {
+	testCases := []struct {
+		http    string
+		tcp     string
+		expHTTP hatypes.BindAddress
+		expTCP  hatypes.BindAddress
+		logging string
+	}{
+		// 0
+		{
+			http: "*",
+			tcp:  "*",
+		},
+		// 1
+		{
+			http:    "*,::",
+			tcp:     "10.0.0.1",
+			expHTTP: hatypes.BindAddress{"", "::"},
+			expTCP:  hatypes.BindAddress{"10.0.0.1"},
+		},
+		// 2
+		{
+			http:    "[2001:db8::1], 10.0.0.1",
+			tcp:     "::",
+			expHTTP: hatypes.BindAddress{"2001:db8::1", "10.0.0.1"},
+			expTCP:  hatypes.BindAddress{"::"},
+		},
+		// 3
+		{
+			http:    "10.0.0.300,::",
+			tcp:     "localhost",
+			expHTTP: hatypes.BindAddress{"::"},
+			logging: `
+WARN ignoring invalid address '10.0.0.300' on bind-ip-addr-http
+WARN ignoring invalid address 'localhost' on bind-ip-addr-tcp`,
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		d := c.createGlobalData(&types.Config{
+			ConfigGlobals: types.ConfigGlobals{
+				BindIPAddrHTTP: test.http,
+				BindIPAddrTCP:  test.tcp,
+			},
+		})
+		c.createUpdater().buildGlobalBind(d)
+		if !reflect.DeepEqual(d.global.Bind.HTTPAddr, test.expHTTP) {
+			t.Errorf("http addr differs on %d - expected: %v - actual: %v", i, test.expHTTP, d.global.Bind.HTTPAddr)
+		}
+		if !reflect.DeepEqual(d.global.Bind.TCPAddr, test.expTCP) {
+			t.Errorf("tcp addr differs on %d - expected: %v - actual: %v", i, test.expTCP, d.global.Bind.TCPAddr)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalDNS(t *testing.T) {
+	testCases := []struct {
+		resolvers string
+		expected  []*hatypes.DNSResolver
+		logging   string
+	}{
+		// 0
+		{
+			resolvers: "",
+		},
+		// 1
+		{
+			resolvers: "dns1=10.0.0.10",
+			expected: []*hatypes.DNSResolver{
+				{Name: "dns1", Nameservers: []*hatypes.DNSNameserver{{Name: "ns_10.0.0.10_53", Endpoint: "10.0.0.10:53"}}},
+			},
+		},
+		// 2
+		{
+			resolvers: `
+dns1=10.0.0.10:5353,10.0.0.11
+dns2=[2001:db8::10]:53,2001:db8::11`,
+			expected: []*hatypes.DNSResolver{
+				{Name: "dns1", Nameservers: []*hatypes.DNSNameserver{
+					{Name: "ns_10.0.0.10_5353", Endpoint: "10.0.0.10:5353"},
+					{Name: "ns_10.0.0.11_53", Endpoint: "10.0.0.11:53"},
+				}},
+				{Name: "dns2", Nameservers: []*hatypes.DNSNameserver{
+					{Name: "ns_2001:db8::10_53", Endpoint: "[2001:db8::10]:53"},
+					{Name: "ns_2001:db8::11_53", Endpoint: "[2001:db8::11]:53"},
+				}},
+			},
+		},
+		// 3
+		{
+			resolvers: `
+dns1
+dns2=kube-dns
+dns3=10.0.0.10,kube-dns
+dns3=10.0.0.11`,
+			expected: []*hatypes.DNSResolver{
+				{Name: "dns3", Nameservers: []*hatypes.DNSNameserver{{Name: "ns_10.0.0.10_53", Endpoint: "10.0.0.10:53"}}},
+			},
+			logging: `
+WARN ignoring misconfigured DNS resolver: dns1
+WARN ignoring invalid nameserver 'kube-dns' on DNS resolver 'dns2'
+WARN ignoring DNS resolver without nameservers: dns2
+WARN ignoring invalid nameserver 'kube-dns' on DNS resolver 'dns3'
+WARN ignoring duplicated DNS resolver: dns3`,
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		d := c.createGlobalData(&types.Config{
+			ConfigGlobals: types.ConfigGlobals{
+				DNSResolvers:    test.resolvers,
+				DNSTimeoutRetry: "1s",
 			},
 		})
-		u.buildGlobalForwardFor(d)
-		if d.global.ForwardFor != test.expected {
-			t.Errorf("ForwardFor differs on %d: expected '%s' but was '%s'", i, test.expected, d.global.ForwardFor)
+		c.createUpdater().buildGlobalDNS(d)
+		if !reflect.DeepEqual(d.global.DNS.Resolvers, test.expected) {
+			t.Errorf("resolvers differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.DNS.Resolvers)
+		}
+		if d.global.DNS.TimeoutRetry != "1s" {
+			t.Errorf("timeout retry differs on %d - expected: 1s - actual: %s", i, d.global.DNS.TimeoutRetry)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalProxyProtocol(t *testing.T) {
+	testCases := []struct {
+		config   types.ConfigGlobals
+		expected hatypes.GlobalBindConfig
+		logging  string
+	}{
+		// 0
+		{
+			config: types.ConfigGlobals{
+				ProxyProtocolFrontends: "http,https",
+			},
+		},
+		// 1
+		{
+			config: types.ConfigGlobals{
+				UseProxyProtocol:       true,
+				ProxyProtocolFrontends: "http,https",
+			},
+			expected: hatypes.GlobalBindConfig{HTTPAcceptProxy: true, HTTPSAcceptProxy: true},
+		},
+		// 2
+		{
+			config: types.ConfigGlobals{
+				UseProxyProtocol:       true,
+				ProxyProtocolFrontends: "https,tcp",
+			},
+			expected: hatypes.GlobalBindConfig{HTTPSAcceptProxy: true},
+			logging:  "WARN ignoring invalid frontend 'tcp' in proxy-protocol-frontends",
+		},
+		// 3
+		{
+			config: types.ConfigGlobals{
+				UseProxyProtocol:            true,
+				ProxyProtocolFrontends:      "http",
+				ProxyProtocolTrustedSources: "10.0.0.0/8, 192.168.0.0/16,10.0.0.1",
+			},
+			expected: hatypes.GlobalBindConfig{
+				HTTPAcceptProxy:    true,
+				AcceptProxySources: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			},
+			logging: "WARN skipping invalid cidr '10.0.0.1' in proxy-protocol-trusted-sources",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: test.config})
+		u.buildGlobalProxyProtocol(d)
+		if !reflect.DeepEqual(d.global.Bind, test.expected) {
+			t.Errorf("bind differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.Bind)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestAcme(t *testing.T) {
+	testCases := []struct {
+		socket   string
+		config   types.ConfigGlobals
+		expected hatypes.AcmeConfig
+		logging  string
+	}{
+		// 0
+		{
+			socket: "/var/run/acme.sock",
+		},
+		// 1
+		{
+			config: types.ConfigGlobals{
+				AcmeEmails:   "admin@local",
+				AcmeEndpoint: "v2",
+				AcmeExpiring: 30,
+			},
+		},
+		// 2
+		{
+			socket: "/var/run/acme.sock",
+			config: types.ConfigGlobals{
+				AcmeEndpoint: "v2",
+				AcmeExpiring: 30,
+			},
+			logging: "WARN ignoring acme-endpoint configmap option: acme-emails is missing",
+		},
+		// 3
+		{
+			socket: "/var/run/acme.sock",
+			config: types.ConfigGlobals{
+				AcmeEmails:      "admin@local",
+				AcmeEndpoint:    "v2-staging",
+				AcmeExpiring:    0,
+				AcmeTermsAgreed: true,
+			},
+			expected: hatypes.AcmeConfig{
+				Enabled:     true,
+				Emails:      "admin@local",
+				Endpoint:    "v2-staging",
+				Expiring:    30,
+				Prefix:      "/.well-known/acme-challenge/",
+				Socket:      "/var/run/acme.sock",
+				TermsAgreed: true,
+			},
+			logging: "WARN invalid value of acme-expiring configmap option (0), using 30",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.AcmeSocket = test.socket
+		d := c.createGlobalData(&types.Config{ConfigGlobals: test.config})
+		c.createUpdater().buildGlobalAcme(d)
+		if !reflect.DeepEqual(d.global.Acme, test.expected) {
+			t.Errorf("acme differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.Acme)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalLocalZone(t *testing.T) {
+	testCases := []struct {
+		config    types.ConfigGlobals
+		localNode string
+		expected  string
+		logging   string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			config:   types.ConfigGlobals{LocalZone: "zone-a"},
+			expected: "zone-a",
+		},
+		// 2
+		{
+			config:    types.ConfigGlobals{LocalZone: "zone-a"},
+			localNode: "node2",
+			expected:  "zone-a",
+		},
+		// 3
+		{
+			localNode: "node2",
+			expected:  "zone-b",
+		},
+		// 4
+		{
+			localNode: "node3",
+			logging:   "WARN cannot read the local zone: node not found: 'node3'",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.LocalNodeName = test.localNode
+		c.cache.NodeZones = map[string]string{"node1": "zone-a", "node2": "zone-b"}
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: test.config})
+		u.buildGlobalLocalZone(d)
+		if d.global.LocalZone != test.expected {
+			t.Errorf("local zone differs on %d - expected: %s - actual: %s", i, test.expected, d.global.LocalZone)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalPeers(t *testing.T) {
+	svc := &api.Service{ObjectMeta: meta.ObjectMeta{Namespace: "ingress", Name: "peers"}}
+	ep := &api.Endpoints{
+		Subsets: []api.EndpointSubset{{
+			Addresses: []api.EndpointAddress{
+				{IP: "10.0.1.12", TargetRef: &api.ObjectReference{Name: "ingress-def34"}},
+				{IP: "10.0.1.11", TargetRef: &api.ObjectReference{Name: "ingress-abc12"}},
+				{IP: "10.0.1.13"},
+			},
+			NotReadyAddresses: []api.EndpointAddress{
+				{IP: "10.0.1.14", TargetRef: &api.ObjectReference{Name: "ingress-ghi56"}},
+			},
+		}},
+	}
+	testCases := []struct {
+		config   types.ConfigGlobals
+		expected hatypes.PeersConfig
+		logging  string
+	}{
+		// 0
+		{
+			config: types.ConfigGlobals{PeersPort: 10000},
+		},
+		// 1
+		{
+			config: types.ConfigGlobals{PeersService: "ingress/peers", PeersPort: 10000},
+			expected: hatypes.PeersConfig{
+				Name: "ingress",
+				Peers: []*hatypes.Peer{
+					{Name: "ingress-abc12", Endpoint: "10.0.1.11:10000"},
+					{Name: "ingress-def34", Endpoint: "10.0.1.12:10000"},
+					{Name: "ingress-ghi56", Endpoint: "10.0.1.14:10000"},
+				},
+			},
+		},
+		// 2
+		{
+			config:  types.ConfigGlobals{PeersService: "ingress/peers", PeersPort: 0},
+			logging: "WARN ignoring peers-service due to an invalid peers-port: 0",
+		},
+		// 3
+		{
+			config:  types.ConfigGlobals{PeersService: "ingress/haproxy", PeersPort: 10000},
+			logging: "WARN ignoring peers-service 'ingress/haproxy': service not found: 'ingress/haproxy'",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.cache.SvcList = []*api.Service{svc}
+		c.cache.EpList = map[string]*api.Endpoints{"ingress/peers": ep}
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: test.config})
+		u.buildGlobalPeers(d)
+		if !reflect.DeepEqual(d.global.Peers, test.expected) {
+			t.Errorf("peers differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.Peers)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalLua(t *testing.T) {
+	testCases := []struct {
+		scripts  string
+		expected []string
+		logging  string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			scripts: "default/scripts",
+			expected: []string{
+				"/etc/haproxy/lua/default_scripts_headers.lua",
+				"/etc/haproxy/lua/default_scripts_tenant.lua",
+			},
+		},
+		// 2
+		{
+			scripts: "default/other,default/scripts",
+			expected: []string{
+				"/etc/haproxy/lua/default_scripts_headers.lua",
+				"/etc/haproxy/lua/default_scripts_tenant.lua",
+			},
+			logging: "WARN skipping Lua scripts of configmap 'default/other': configmap not found: 'default/other'",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.cache.LuaScripts = map[string][]string{
+			"default/scripts": {
+				"/etc/haproxy/lua/default_scripts_headers.lua",
+				"/etc/haproxy/lua/default_scripts_tenant.lua",
+			},
+		}
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{LuaScripts: test.scripts}})
+		u.buildGlobalLua(d)
+		var filenames []string
+		for _, script := range d.global.LuaScripts {
+			filenames = append(filenames, script.Filename)
+		}
+		if !reflect.DeepEqual(filenames, test.expected) {
+			t.Errorf("lua scripts differs on %d - expected: %v - actual: %v", i, test.expected, filenames)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalSPOE(t *testing.T) {
+	testCases := []struct {
+		agents   string
+		expected []*hatypes.SPOEAgent
+		logging  string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			agents: `
+- name: auth
+  endpoints: ["10.0.0.1:12345"]
+  messages:
+  - name: check-auth
+    args: src method path req.hdrs_bin
+    event: on-backend-http-request
+`,
+			expected: []*hatypes.SPOEAgent{{
+				Name:      "auth",
+				Endpoints: []string{"10.0.0.1:12345"},
+				Messages: []*hatypes.SPOEMessage{
+					{Name: "check-auth", Args: "src method path req.hdrs_bin", Event: "on-backend-http-request"},
+				},
+				Timeout:   hatypes.SPOETimeoutConfig{Hello: "100ms", Idle: "30s", Processing: "1s"},
+				VarPrefix: "auth",
+			}},
+		},
+		// 2
+		{
+			agents: `
+- name: mirror
+  endpoints: ["10.0.0.1:12345", "10.0.0.2:12345"]
+  var-prefix: mirr
+  timeout:
+    processing: 200ms
+  messages:
+  - name: mirror
+    args: req.body
+    event: on-frontend-http-request
+  - name: mirror
+    args: method path req.body
+    event: on-backend-http-request
+`,
+			expected: []*hatypes.SPOEAgent{{
+				Name:      "mirror",
+				Endpoints: []string{"10.0.0.1:12345", "10.0.0.2:12345"},
+				Messages: []*hatypes.SPOEMessage{
+					{Name: "mirror", Args: "method path req.body", Event: "on-backend-http-request"},
+				},
+				Timeout:   hatypes.SPOETimeoutConfig{Hello: "100ms", Idle: "30s", Processing: "200ms"},
+				VarPrefix: "mirr",
+			}},
+			logging: "WARN skipping message 'mirror' of SPOE agent 'mirror': invalid event 'on-frontend-http-request'",
+		},
+		// 3
+		{
+			agents: `
+- name: modsecurity
+  endpoints: ["10.0.0.1:12345"]
+`,
+			logging: "WARN skipping SPOE agent with invalid name: 'modsecurity'",
+		},
+		// 4
+		{
+			agents: `
+- name: auth
+  messages:
+  - name: check-auth
+    event: on-backend-http-request
+`,
+			logging: "WARN skipping SPOE agent 'auth': missing endpoints",
+		},
+		// 5
+		{
+			agents: `
+- name: auth
+  endpoints: ["10.0.0.1:12345"]
+`,
+			logging: "WARN skipping SPOE agent 'auth': missing valid messages",
+		},
+		// 6
+		{
+			agents:  `name: auth`,
+			logging: "WARN ignoring invalid spoe-agents configuration: error unmarshaling JSON: json: cannot unmarshal object into Go value of type []annotations.spoeAgentConfig",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{SPOEAgents: test.agents}})
+		u.buildGlobalSPOE(d)
+		if !reflect.DeepEqual(d.global.SPOEAgents, test.expected) {
+			t.Errorf("spoe agents differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.SPOEAgents)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalStats(t *testing.T) {
+	testCases := []struct {
+		config   types.ConfigGlobals
+		expected hatypes.StatsConfig
+		users    []hatypes.User
+		logging  string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			config:   types.ConfigGlobals{StatsPort: 1936, BindIPAddrStats: "*", StatsURI: "/"},
+			expected: hatypes.StatsConfig{Port: 1936, URI: "/"},
+		},
+		// 2
+		{
+			config: types.ConfigGlobals{
+				StatsPort:          1936,
+				BindIPAddrStats:    "127.0.0.1",
+				StatsAdmin:         true,
+				StatsAuth:          "admin:admin",
+				StatsProxyProtocol: true,
+				StatsSSLCert:       "default/stats",
+				StatsURI:           "/stats",
+			},
+			expected: hatypes.StatsConfig{
+				AcceptProxy: true,
+				Admin:       true,
+				Auth:        "admin:admin",
+				BindAddr:    hatypes.BindAddress{"127.0.0.1"},
+				Port:        1936,
+				TLSFilename: "/var/haproxy/ssl/stats.pem",
+				TLSHash:     "51dc98f936afa0b7d325d9619a6735bf91eaba43",
+				URI:         "/stats",
+			},
+		},
+		// 3
+		{
+			config:   types.ConfigGlobals{StatsPort: 1936, StatsAuth: "admin:admin", StatsAuthSecret: "default/stats-users"},
+			expected: hatypes.StatsConfig{Port: 1936, URI: "/", Userlist: "_stats"},
+			users:    []hatypes.User{{Name: "usr1", Passwd: "clear1"}},
+			logging:  "WARN ignoring stats-auth: stats-auth-secret is also configured",
+		},
+		// 4
+		{
+			config:   types.ConfigGlobals{StatsPort: 1936, StatsAuthSecret: "default/other", StatsSSLCert: "default/other", StatsURI: "stats"},
+			expected: hatypes.StatsConfig{Port: 1936, URI: "/", Userlist: "_stats"},
+			logging: `
+WARN ignoring invalid stats-uri 'stats', using '/' instead
+ERROR error reading stats basic authentication: secret not found: 'default/other'
+WARN using plain http on stats page: error reading stats-ssl-cert: secret not found: 'default/other'`,
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.cache.SecretTLSPath = map[string]string{"default/stats": "/var/haproxy/ssl/stats.pem"}
+		c.cache.SecretContent = ing_helper.SecretContent{"default/stats-users": {"auth": []byte("usr1::clear1")}}
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: test.config})
+		u.buildGlobalStats(d)
+		if !reflect.DeepEqual(d.global.Stats, test.expected) {
+			t.Errorf("stats differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.Stats)
+		}
+		var users []hatypes.User
+		for _, userlist := range u.haproxy.Userlists() {
+			if userlist.Name == "_stats" {
+				users = userlist.Users
+			}
+		}
+		if !reflect.DeepEqual(users, test.users) {
+			t.Errorf("stats users differs on %d - expected: %+v - actual: %+v", i, test.users, users)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalRuntimeAPI(t *testing.T) {
+	testCases := []struct {
+		port      int
+		whitelist string
+		expected  hatypes.RuntimeAPIConfig
+		logging   string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			port:     9999,
+			expected: hatypes.RuntimeAPIConfig{},
+			logging:  "WARN ignoring runtime-api-port: runtime-api-whitelist is missing",
+		},
+		// 2
+		{
+			port:      9999,
+			whitelist: "10.0.0.0/8,192.168.0.0/16",
+			expected:  hatypes.RuntimeAPIConfig{Port: 9999, Whitelist: []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		},
+		// 3
+		{
+			port:      9999,
+			whitelist: "10.0.0.0/8,10.0.0.300",
+			expected:  hatypes.RuntimeAPIConfig{Port: 9999, Whitelist: []string{"10.0.0.0/8"}},
+			logging:   "WARN skipping invalid cidr '10.0.0.300' in runtime-api-whitelist",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{
+			RuntimeAPIPort:      test.port,
+			RuntimeAPIWhitelist: test.whitelist,
+		}})
+		u.buildGlobalRuntimeAPI(d)
+		if !reflect.DeepEqual(d.global.RuntimeAPI, test.expected) {
+			t.Errorf("runtime api differs on %d - expected: %+v - actual: %+v", i, test.expected, d.global.RuntimeAPI)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalHTTPLogFormat(t *testing.T) {
+	testCases := []struct {
+		format   string
+		expected string
+		logging  string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			format: "default",
+		},
+		// 2
+		{
+			format:   "tls",
+			expected: hatypes.DefaultHTTPLogFormat + " %sslv/%sslc",
+		},
+		// 3
+		{
+			format:   "%ci:%cp %b/%s %ST",
+			expected: "%ci:%cp %b/%s %ST",
+		},
+		// 4
+		{
+			format:  "detailed",
+			logging: "WARN ignoring http-log-format 'detailed': unknown preset",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{
+			HTTPLogFormat: test.format,
+		}})
+		u.buildGlobalHTTPLogFormat(d)
+		if d.global.Syslog.HTTPLogFormat != test.expected {
+			t.Errorf("http log format differs on %d - expected: %s - actual: %s", i, test.expected, d.global.Syslog.HTTPLogFormat)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+func TestGlobalSyslog(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		socket   string
+		expected string
+	}{
+		// 0
+		{},
+		// 1
+		{
+			endpoint: "10.0.0.10:514",
+			expected: "10.0.0.10:514",
+		},
+		// 2
+		{
+			socket:   "/var/run/haproxy-log.sock",
+			expected: "/var/run/haproxy-log.sock",
+		},
+		// 3
+		{
+			endpoint: "10.0.0.10:514",
+			socket:   "/var/run/haproxy-log.sock",
+			expected: "10.0.0.10:514",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.options.LogSocket = test.socket
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{
+			SyslogEndpoint: test.endpoint,
+		}})
+		u.buildGlobalSyslog(d)
+		if d.global.Syslog.Endpoint != test.expected {
+			t.Errorf("syslog endpoint differs on %d - expected: %s - actual: %s", i, test.expected, d.global.Syslog.Endpoint)
+		}
+		c.logger.CompareLogging("")
+		c.teardown()
+	}
+}
+
+func TestGlobalForwardedHeaders(t *testing.T) {
+	testCases := []struct {
+		enabled  bool
+		trusted  string
+		expected []string
+		logging  string
+	}{
+		// 0
+		{
+			trusted: "10.0.0.0/8",
+		},
+		// 1
+		{
+			enabled: true,
+			logging: "WARN ignoring use-forwarded-headers: trusted-proxies is missing",
+		},
+		// 2
+		{
+			enabled:  true,
+			trusted:  "10.0.0.0/8,192.168.0.0/16",
+			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		// 3
+		{
+			enabled: true,
+			trusted: "10.0.0.300",
+			logging: `
+WARN skipping invalid cidr '10.0.0.300' in trusted-proxies
+WARN ignoring use-forwarded-headers: trusted-proxies is missing`,
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createGlobalData(&types.Config{ConfigGlobals: types.ConfigGlobals{
+			TrustedProxies:      test.trusted,
+			UseForwardedHeaders: test.enabled,
+		}})
+		u.buildGlobalForwardedHeaders(d)
+		if !reflect.DeepEqual(d.global.TrustedProxies, test.expected) {
+			t.Errorf("trusted proxies differs on %d - expected: %v - actual: %v", i, test.expected, d.global.TrustedProxies)
 		}
 		c.logger.CompareLogging(test.logging)
 		c.teardown()
@@ -16,6 +16,13 @@ limitations under the License.
 
 package annotations
 
+import (
+	"regexp"
+	"strings"
+
+	ingutils "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/utils"
+)
+
 func (c *updater) buildHostAuthTLS(d *hostData) {
 	if d.ann.AuthTLSSecret == "" {
 		return
@@ -24,9 +31,19 @@ func (c *updater) buildHostAuthTLS(d *hostData) {
 	if verify == "off" {
 		return
 	}
-	if cafile, err := c.cache.GetCASecretPath(d.ann.AuthTLSSecret); err == nil {
+	if d.ann.AuthTLSVerifyDepth != "" {
+		c.logger.Warn("ignoring auth-tls-verify-depth on %v: HAProxy doesn't limit the depth of the client certificate chain", d.ann.Source)
+	}
+	secretName, err := ingutils.SecretName(c.options, c.cache, d.ann.Source.Namespace, d.ann.AuthTLSSecret)
+	if err != nil {
+		c.logger.Error("error building TLS auth config on %v: %v", d.ann.Source, err)
+		return
+	}
+	if cafile, crlfile, err := c.cache.GetCASecretPath(secretName); err == nil {
 		d.host.TLS.CAFilename = cafile.Filename
 		d.host.TLS.CAHash = cafile.SHA1Hash
+		d.host.TLS.CRLFilename = crlfile.Filename
+		d.host.TLS.CRLHash = crlfile.SHA1Hash
 		d.host.TLS.CAVerifyOptional = verify == "optional" || verify == "optional_no_ca"
 		d.host.TLS.CAErrorPage = d.ann.AuthTLSErrorPage
 	} else {
@@ -34,6 +51,84 @@ func (c *updater) buildHostAuthTLS(d *hostData) {
 	}
 }
 
+var (
+	sslCiphersRegex = regexp.MustCompile(`^[A-Za-z0-9!+@=_.:-]+$`)
+	alpnRegex       = regexp.MustCompile(`^[A-Za-z0-9/._-]+(,[A-Za-z0-9/._-]+)*$`)
+	sslVersions     = []string{"SSLv3", "TLSv1.0", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+	sslVersionNames = map[string]int{"sslv3": 0, "tlsv10": 1, "tlsv11": 2, "tlsv12": 3, "tlsv13": 4}
+)
+
+func (c *updater) buildHostSSL(d *hostData) {
+	if d.ann.SSLCiphers != "" {
+		if sslCiphersRegex.MatchString(d.ann.SSLCiphers) {
+			d.host.TLS.Ciphers = d.ann.SSLCiphers
+		} else {
+			c.logger.Warn("ignoring invalid ssl-ciphers on %v: %s", d.ann.Source, d.ann.SSLCiphers)
+		}
+	}
+	if d.ann.TLSALPN != "" {
+		if alpnRegex.MatchString(d.ann.TLSALPN) {
+			if d.ann.TLSALPN != c.haproxy.Global().SSL.ALPN {
+				d.host.TLS.ALPN = d.ann.TLSALPN
+			}
+		} else {
+			c.logger.Warn("ignoring invalid tls-alpn on %v: %s", d.ann.Source, d.ann.TLSALPN)
+		}
+	}
+	if d.ann.SSLCipherSuites != "" {
+		if !c.options.HAProxyVersion.AtLeast(1, 9) {
+			c.logger.Warn("ignoring ssl-cipher-suites on %v: needs HAProxy 1.9 or newer, found version %s", d.ann.Source, c.options.HAProxyVersion)
+		} else if sslCiphersRegex.MatchString(d.ann.SSLCipherSuites) {
+			d.host.TLS.CipherSuites = d.ann.SSLCipherSuites
+		} else {
+			c.logger.Warn("ignoring invalid ssl-cipher-suites on %v: %s", d.ann.Source, d.ann.SSLCipherSuites)
+		}
+	}
+	options := strings.Fields(d.ann.SSLOptionsHost)
+	if len(options) == 0 {
+		return
+	}
+	enabled := make([]bool, len(sslVersions))
+	for i := range enabled {
+		enabled[i] = true
+	}
+	for _, opt := range options {
+		var version int
+		var found bool
+		if strings.HasPrefix(opt, "no-") {
+			version, found = sslVersionNames[opt[3:]]
+			if found {
+				enabled[version] = false
+			}
+		} else if strings.HasPrefix(opt, "force-") {
+			version, found = sslVersionNames[opt[6:]]
+			if found {
+				for i := range enabled {
+					enabled[i] = i == version
+				}
+			}
+		}
+		if !found {
+			c.logger.Warn("ignoring invalid ssl-options-host option '%s' on %v", opt, d.ann.Source)
+		}
+	}
+	minVersion, maxVersion := -1, -1
+	for i := range enabled {
+		if enabled[i] {
+			if minVersion < 0 {
+				minVersion = i
+			}
+			maxVersion = i
+		}
+	}
+	if minVersion < 0 {
+		c.logger.Warn("ignoring ssl-options-host on %v: all protocol versions are disabled", d.ann.Source)
+		return
+	}
+	d.host.TLS.MinVersion = sslVersions[minVersion]
+	d.host.TLS.MaxVersion = sslVersions[maxVersion]
+}
+
 func (c *updater) buildHostSSLPassthrough(d *hostData) {
 	if !d.ann.SSLPassthrough {
 		return
@@ -0,0 +1,228 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package annotations
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+func TestAuthTLS(t *testing.T) {
+	testCase := []struct {
+		ann        types.HostAnnotations
+		secretNs   []string
+		expected   hatypes.HostTLSConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 1
+		{
+			ann:      types.HostAnnotations{AuthTLSSecret: "default/ca1", AuthTLSVerifyClient: "off"},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 2
+		{
+			ann:        types.HostAnnotations{AuthTLSSecret: "default/ca9"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "ERROR error building TLS auth config: secret not found: 'default/ca9'",
+		},
+		// 3
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca1", AuthTLSErrorPage: "http://d1.local/error.html"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename:  "/ssl/ca1.pem",
+				CAErrorPage: "http://d1.local/error.html",
+			},
+		},
+		// 4
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca2", AuthTLSVerifyClient: "optional"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename:       "/ssl/ca2.pem",
+				CAVerifyOptional: true,
+				CRLFilename:      "/ssl/ca2.crl.pem",
+			},
+		},
+		// 5
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca1", AuthTLSVerifyDepth: "2"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/ssl/ca1.pem",
+			},
+			expLogging: "WARN ignoring auth-tls-verify-depth on ingress 'default/ing1': HAProxy doesn't limit the depth of the client certificate chain",
+		},
+		// 6
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "ca1"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/ssl/ca1.pem",
+			},
+		},
+		// 7
+		{
+			ann:        types.HostAnnotations{AuthTLSSecret: "other/ca3"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "ERROR error building TLS auth config on ingress 'default/ing1': cross namespace access to secret 'other/ca3' is not allowed",
+		},
+		// 8
+		{
+			ann:      types.HostAnnotations{AuthTLSSecret: "other/ca3"},
+			secretNs: []string{"other"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/ssl/ca3.pem",
+			},
+		},
+		// 9
+		{
+			ann:      types.HostAnnotations{AuthTLSSecret: "other/ca3"},
+			secretNs: []string{"*"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/ssl/ca3.pem",
+			},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.options.SecretNamespaces = test.secretNs
+		c.cache.SecretCAPath = map[string]string{
+			"default/ca1": "/ssl/ca1.pem",
+			"default/ca2": "/ssl/ca2.pem",
+			"other/ca3":   "/ssl/ca3.pem",
+		}
+		c.cache.SecretCRLPath = map[string]string{
+			"default/ca2": "/ssl/ca2.crl.pem",
+		}
+		d := c.createHostData("default", "ing1", &test.ann)
+		c.createUpdater().buildHostAuthTLS(d)
+		tls := d.host.TLS
+		if (tls.CAFilename != "") != (tls.CAHash != "") || (tls.CRLFilename != "") != (tls.CRLHash != "") {
+			t.Errorf("file hash differs from file name on %d: %+v", i, tls)
+		}
+		tls.CAHash, tls.CRLHash = "", ""
+		if !reflect.DeepEqual(tls, test.expected) {
+			t.Errorf("tls config differs on %d - expected: %+v - actual: %+v", i, test.expected, tls)
+		}
+		c.logger.CompareLogging(test.expLogging)
+	}
+}
+
+func TestSSLOptionsHost(t *testing.T) {
+	testCase := []struct {
+		ann        types.HostAnnotations
+		version    hatypes.Version
+		expected   hatypes.HostTLSConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 1
+		{
+			ann:      types.HostAnnotations{SSLOptionsHost: "no-sslv3"},
+			expected: hatypes.HostTLSConfig{MinVersion: "TLSv1.0", MaxVersion: "TLSv1.3"},
+		},
+		// 2
+		{
+			ann:      types.HostAnnotations{SSLOptionsHost: "force-tlsv13"},
+			expected: hatypes.HostTLSConfig{MinVersion: "TLSv1.3", MaxVersion: "TLSv1.3"},
+		},
+		// 3
+		{
+			ann:      types.HostAnnotations{SSLOptionsHost: "no-sslv3 no-tlsv10 no-tlsv13"},
+			expected: hatypes.HostTLSConfig{MinVersion: "TLSv1.1", MaxVersion: "TLSv1.2"},
+		},
+		// 4
+		{
+			ann:        types.HostAnnotations{SSLOptionsHost: "no-tlsv14 no-sslv3"},
+			expected:   hatypes.HostTLSConfig{MinVersion: "TLSv1.0", MaxVersion: "TLSv1.3"},
+			expLogging: "WARN ignoring invalid ssl-options-host option 'no-tlsv14' on ingress 'default/ing1'",
+		},
+		// 5
+		{
+			ann:        types.HostAnnotations{SSLOptionsHost: "no-sslv3 no-tlsv10 no-tlsv11 no-tlsv12 no-tlsv13"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring ssl-options-host on ingress 'default/ing1': all protocol versions are disabled",
+		},
+		// 6
+		{
+			ann:      types.HostAnnotations{SSLCiphers: "ECDHE-RSA-AES128-GCM-SHA256:!aNULL"},
+			expected: hatypes.HostTLSConfig{Ciphers: "ECDHE-RSA-AES128-GCM-SHA256:!aNULL"},
+		},
+		// 7
+		{
+			ann:        types.HostAnnotations{SSLCiphers: "ECDHE-RSA-AES128-GCM-SHA256 ssl-max-ver"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring invalid ssl-ciphers on ingress 'default/ing1': ECDHE-RSA-AES128-GCM-SHA256 ssl-max-ver",
+		},
+		// 8
+		{
+			ann:        types.HostAnnotations{SSLCipherSuites: "TLS_AES_128_GCM_SHA256"},
+			version:    hatypes.Version{Major: 1, Minor: 8},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring ssl-cipher-suites on ingress 'default/ing1': needs HAProxy 1.9 or newer, found version 1.8",
+		},
+		// 9
+		{
+			ann:      types.HostAnnotations{TLSALPN: "http/1.1"},
+			expected: hatypes.HostTLSConfig{ALPN: "http/1.1"},
+		},
+		// 10
+		{
+			ann:      types.HostAnnotations{TLSALPN: "h2,http/1.1"},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 11
+		{
+			ann:        types.HostAnnotations{TLSALPN: "h2, http/1.1"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring invalid tls-alpn on ingress 'default/ing1': h2, http/1.1",
+		},
+		// 12
+		{
+			ann:      types.HostAnnotations{SSLCipherSuites: "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256"},
+			version:  hatypes.Version{Major: 2, Minor: 2},
+			expected: hatypes.HostTLSConfig{CipherSuites: "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256"},
+		},
+		// 13
+		{
+			ann:        types.HostAnnotations{SSLCipherSuites: "TLS_AES_128_GCM_SHA256 alpn h2"},
+			version:    hatypes.Version{Major: 1, Minor: 9},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring invalid ssl-cipher-suites on ingress 'default/ing1': TLS_AES_128_GCM_SHA256 alpn h2",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.haproxy.Global().SSL.ALPN = "h2,http/1.1"
+		c.options.HAProxyVersion = test.version
+		d := c.createHostData("default", "ing1", &test.ann)
+		c.createUpdater().buildHostSSL(d)
+		if !reflect.DeepEqual(d.host.TLS, test.expected) {
+			t.Errorf("tls config differs on %d - expected: %+v - actual: %+v", i, test.expected, d.host.TLS)
+		}
+		c.logger.CompareLogging(test.expLogging)
+	}
+}
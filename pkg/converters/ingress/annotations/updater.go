@@ -17,6 +17,8 @@ limitations under the License.
 package annotations
 
 import (
+	"sync"
+
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
@@ -31,18 +33,26 @@ type Updater interface {
 }
 
 // NewUpdater ...
-func NewUpdater(haproxy haproxy.Config, cache ingtypes.Cache, logger types.Logger) Updater {
+func NewUpdater(haproxy haproxy.Config, options *ingtypes.ConverterOptions) Updater {
 	return &updater{
-		haproxy: haproxy,
-		cache:   cache,
-		logger:  logger,
+		haproxy:       haproxy,
+		options:       options,
+		cache:         options.Cache,
+		logger:        options.Logger,
+		userlistMutex: &sync.Mutex{},
+		resolverMutex: &sync.Mutex{},
 	}
 }
 
 type updater struct {
 	haproxy haproxy.Config
+	options *ingtypes.ConverterOptions
 	cache   ingtypes.Cache
 	logger  types.Logger
+	// userlists are shared between backends updated concurrently
+	userlistMutex *sync.Mutex
+	// the default resolver is created by the first backend that needs it
+	resolverMutex *sync.Mutex
 }
 
 type globalData struct {
@@ -65,29 +75,50 @@ func copyHAProxyTime(dst *string, src string) {
 	*dst = src
 }
 
+// WithLogger returns an updater which shares the state of this one and
+// logs to another logger, used to update backends concurrently
+func (c *updater) WithLogger(logger types.Logger) Updater {
+	u := *c
+	u.logger = logger
+	return &u
+}
+
 func (c *updater) UpdateGlobalConfig(global *hatypes.Global, config *ingtypes.Config) {
 	data := &globalData{
 		global: global,
 		config: config,
 	}
-	global.Syslog.Endpoint = config.SyslogEndpoint
 	global.Syslog.Format = config.SyslogFormat
 	global.Syslog.Tag = config.SyslogTag
-	global.Syslog.HTTPLogFormat = config.HTTPLogFormat
 	global.Syslog.HTTPSLogFormat = config.HTTPSLogFormat
 	global.Syslog.TCPLogFormat = config.TCPLogFormat
 	global.MaxConn = config.MaxConnections
 	global.DrainSupport.Drain = config.DrainSupport
 	global.DrainSupport.Redispatch = config.DrainSupportRedispatch
+	global.DynamicScaling.Enabled = config.DynamicScaling
+	global.DynamicScaling.SlotsIncrement = config.BackendServerSlotsIncrement
 	global.Cookie.Key = config.CookieKey
 	global.LoadServerState = config.LoadServerState
 	global.StatsSocket = "/var/run/haproxy-stats.sock"
 	c.buildGlobalProc(data)
 	c.buildGlobalTimeout(data)
+	c.buildGlobalSyslog(data)
+	c.buildGlobalHTTPLogFormat(data)
 	c.buildGlobalSSL(data)
+	c.buildGlobalQUIC(data)
+	c.buildGlobalBind(data)
+	c.buildGlobalDNS(data)
+	c.buildGlobalPeers(data)
+	c.buildGlobalProxyProtocol(data)
 	c.buildGlobalModSecurity(data)
-	c.buildGlobalForwardFor(data)
+	c.buildGlobalForwardedHeaders(data)
+	c.buildGlobalLocalZone(data)
+	c.buildGlobalLua(data)
+	c.buildGlobalSPOE(data)
+	c.buildGlobalStats(data)
+	c.buildGlobalRuntimeAPI(data)
 	c.buildGlobalCustomConfig(data)
+	c.buildGlobalAcme(data)
 }
 
 func (c *updater) UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnnotations) {
@@ -101,6 +132,7 @@ func (c *updater) UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnnotat
 	host.Timeout.Client = ann.TimeoutClient
 	host.Timeout.ClientFin = ann.TimeoutClientFin
 	c.buildHostAuthTLS(data)
+	c.buildHostSSL(data)
 	c.buildHostSSLPassthrough(data)
 }
 
@@ -110,21 +142,52 @@ func (c *updater) UpdateBackendConfig(backend *hatypes.Backend, ann *ingtypes.Ba
 		ann:     ann,
 	}
 	// TODO check ModeTCP with HTTP annotations
-	backend.BalanceAlgorithm = ann.BalanceAlgorithm
 	backend.HSTS.Enabled = ann.HSTS
 	backend.HSTS.MaxAge = ann.HSTSMaxAge
 	backend.HSTS.Preload = ann.HSTSPreload
 	backend.HSTS.Subdomains = ann.HSTSIncludeSubdomains
 	backend.MaxConnServer = ann.MaxconnServer
+	backend.PreferLastServer = ann.PreferLastServer
 	backend.ProxyBodySize = ann.ProxyBodySize
 	backend.SSLRedirect = ann.SSLRedirect
 	backend.SSL.AddCertHeader = ann.AuthTLSCertHeader
 	c.buildBackendAffinity(data)
 	c.buildBackendAuthHTTP(data)
+	c.buildBackendBalance(data)
+	c.buildBackendBandwidthLimit(data)
 	c.buildBackendBlueGreen(data)
+	c.buildBackendCache(data)
+	c.buildBackendCompression(data)
 	c.buildBackendCors(data)
+	c.buildBackendDrainTimeout(data)
+	c.buildBackendDNS(data)
+	c.buildBackendDNSSRV(data)
+	c.buildBackendFailoverCluster(data)
+	c.buildBackendForwardFor(data)
+	c.buildBackendGlobalRateLimit(data)
+	c.buildBackendHash(data)
+	c.buildBackendHTTPBufferRequest(data)
+	c.buildBackendHTTPConnMode(data)
+	c.buildBackendHTTPReuse(data)
+	c.buildBackendHTTPKeepAliveTimeout(data)
+	c.buildBackendLogCapture(data)
+	c.buildBackendLogFields(data)
+	c.buildBackendLua(data)
 	c.buildOAuth(data)
+	c.buildRetry(data)
 	c.buildRewriteURL(data)
+	c.buildStripPath(data)
+	c.buildBackendSecurityExempt(data)
+	c.buildBackendSecureSPIFFE(data)
+	c.buildBackendSecureALPN(data)
+	c.buildBackendProtocol(data)
+	c.buildBackendServerNaming(data)
+	c.buildBackendTimeout(data)
+	c.buildBackendVars(data)
+	c.buildBackendSSE(data)
+	c.buildBackendSlots(data)
+	c.buildBackendSPOE(data)
 	c.buildWAF(data)
 	c.buildWhitelist(data)
+	c.buildBackendZoneLocal(data)
 }
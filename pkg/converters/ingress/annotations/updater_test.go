@@ -17,12 +17,12 @@ limitations under the License.
 package annotations
 
 import (
+	"sync"
 	"testing"
 
 	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
 )
@@ -36,6 +36,7 @@ import (
 type testConfig struct {
 	t       *testing.T
 	haproxy haproxy.Config
+	options *types.ConverterOptions
 	cache   *ing_helper.CacheMock
 	logger  *types_helper.LoggerMock
 }
@@ -44,7 +45,8 @@ func setup(t *testing.T) *testConfig {
 	logger := &types_helper.LoggerMock{T: t}
 	return &testConfig{
 		t:       t,
-		haproxy: haproxy.CreateInstance(logger, &ha_helper.BindUtilsMock{}, haproxy.InstanceOptions{}).Config(),
+		haproxy: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
+		options: &types.ConverterOptions{},
 		cache:   &ing_helper.CacheMock{},
 		logger:  logger,
 	}
@@ -56,9 +58,12 @@ func (c *testConfig) teardown() {
 
 func (c *testConfig) createUpdater() *updater {
 	return &updater{
-		haproxy: c.haproxy,
-		cache:   c.cache,
-		logger:  c.logger,
+		haproxy:       c.haproxy,
+		options:       c.options,
+		cache:         c.cache,
+		logger:        c.logger,
+		userlistMutex: &sync.Mutex{},
+		resolverMutex: &sync.Mutex{},
 	}
 }
 
@@ -74,6 +79,18 @@ func (c *testConfig) createBackendData(namespace, name string, ann *types.Backen
 	}
 }
 
+func (c *testConfig) createHostData(namespace, name string, ann *types.HostAnnotations) *hostData {
+	ann.Source = types.Source{
+		Namespace: namespace,
+		Name:      name,
+		Type:      "ingress",
+	}
+	return &hostData{
+		host: &hatypes.Host{},
+		ann:  ann,
+	}
+}
+
 func (c *testConfig) createGlobalData(config *types.Config) *globalData {
 	return &globalData{
 		global: &hatypes.Global{},
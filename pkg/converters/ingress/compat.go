@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+)
+
+// NginxAnnotationPrefix is the annotation prefix used by nginx-ingress
+const NginxAnnotationPrefix = "nginx.ingress.kubernetes.io"
+
+// Compatibility levels of a nginx-ingress annotation
+const (
+	CompatEquivalent  = "equivalent"
+	CompatDiffers     = "differs"
+	CompatUnsupported = "unsupported"
+)
+
+// CompatFinding describes how an annotation of nginx-ingress
+// behaves if the ingress resource is moved to haproxy-ingress
+type CompatFinding struct {
+	Annotation string
+	Level      string
+	Reason     string
+}
+
+func (f *CompatFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Annotation, f.Level, f.Reason)
+}
+
+// nginxCompat is the haproxy-ingress counterpart of a nginx-ingress annotation.
+// check, if declared, overrides the level and reason based on the value
+type nginxCompat struct {
+	name   string
+	level  string
+	reason string
+	check  func(value string, ann map[string]string) (level, reason string)
+}
+
+var nginxAnnotations = map[string]nginxCompat{
+	"affinity":   {name: "affinity", level: CompatEquivalent},
+	"app-root":   {name: "app-root", level: CompatEquivalent},
+	"auth-realm": {name: "auth-realm", level: CompatEquivalent},
+	"auth-secret": {name: "auth-secret", level: CompatEquivalent, check: func(value string, ann map[string]string) (string, string) {
+		if ann["auth-secret-type"] == "auth-map" {
+			return CompatDiffers, "auth-map secrets aren't supported, the secret should have an htpasswd formatted 'auth' key"
+		}
+		return CompatEquivalent, ""
+	}},
+	"auth-signin": {name: "oauth", level: CompatDiffers,
+		reason: "the sign in page is provided by oauth2_proxy, see oauth-uri-prefix"},
+	"auth-type": {name: "auth-type", level: CompatEquivalent, check: func(value string, ann map[string]string) (string, string) {
+		if value != "basic" {
+			return CompatUnsupported, fmt.Sprintf("only basic authentication is supported, '%s' would be rejected", value)
+		}
+		return CompatEquivalent, ""
+	}},
+	"auth-url": {name: "oauth", level: CompatDiffers,
+		reason: "external authentication is only supported with oauth2_proxy, see oauth and oauth-service"},
+	"backend-protocol": {name: "secure-backends", level: CompatEquivalent, check: func(value string, ann map[string]string) (string, string) {
+		switch strings.ToUpper(value) {
+		case "HTTP":
+			return CompatEquivalent, "plain HTTP is the default"
+		case "HTTPS":
+			return CompatDiffers, "use secure-backends: \"true\" instead"
+		}
+		return CompatUnsupported, fmt.Sprintf("backend protocol '%s' is not supported", value)
+	}},
+	"configuration-snippet": {name: "config-backend", level: CompatDiffers,
+		reason: "snippets use nginx syntax, rewrite them with HAProxy syntax in config-backend"},
+	"cors-allow-credentials": {name: "cors-allow-credentials", level: CompatEquivalent},
+	"cors-allow-headers":     {name: "cors-allow-headers", level: CompatEquivalent},
+	"cors-allow-methods":     {name: "cors-allow-methods", level: CompatEquivalent},
+	"cors-allow-origin": {name: "cors-allow-origin", level: CompatEquivalent, check: func(value string, ann map[string]string) (string, string) {
+		if strings.Contains(value, ",") {
+			return CompatDiffers, "a list of origins isn't supported, the value is sent verbatim in the Access-Control-Allow-Origin header"
+		}
+		return CompatEquivalent, ""
+	}},
+	"cors-expose-headers": {name: "cors-expose-headers", level: CompatEquivalent},
+	"cors-max-age":        {name: "cors-max-age", level: CompatEquivalent},
+	"custom-http-errors":  {level: CompatUnsupported, reason: "custom error pages aren't supported per ingress"},
+	"default-backend":     {level: CompatUnsupported, reason: "the default backend can only be changed with --default-backend-service"},
+	"enable-access-log":   {level: CompatUnsupported, reason: "logging is configured globally"},
+	"enable-cors":         {name: "cors-enable", level: CompatDiffers, reason: "use cors-enable instead"},
+	"enable-opentracing":  {level: CompatUnsupported, reason: "tracing isn't supported"},
+	"force-ssl-redirect": {name: "ssl-redirect", level: CompatDiffers,
+		reason: "use ssl-redirect instead, hosts without a TLS config are never redirected"},
+	"limit-connections": {name: "limit-connections", level: CompatEquivalent},
+	"limit-rpm":         {level: CompatUnsupported, reason: "use limit-rps instead"},
+	"limit-rps":         {name: "limit-rps", level: CompatEquivalent},
+	"limit-whitelist":   {name: "limit-whitelist", level: CompatEquivalent},
+	"load-balance": {name: "balance-algorithm", level: CompatDiffers, check: func(value string, ann map[string]string) (string, string) {
+		if value == "round_robin" {
+			return CompatEquivalent, "roundrobin is the default balance-algorithm"
+		}
+		return CompatUnsupported, fmt.Sprintf("algorithm '%s' is not supported, see balance-algorithm", value)
+	}},
+	"permanent-redirect":      {level: CompatUnsupported, reason: "redirects aren't supported, use config-backend"},
+	"proxy-body-size":         {name: "proxy-body-size", level: CompatEquivalent},
+	"proxy-connect-timeout":   {name: "timeout-connect", level: CompatDiffers, reason: "use timeout-connect instead, values without a suffix are milliseconds"},
+	"proxy-read-timeout":      {name: "timeout-server", level: CompatDiffers, reason: "use timeout-server instead, which applies to the whole response instead of between two reads"},
+	"proxy-send-timeout":      {name: "timeout-server", level: CompatDiffers, reason: "use timeout-server instead, which applies to the whole response instead of between two writes"},
+	"rewrite-target":          {name: "rewrite-target", level: CompatDiffers, check: nginxRewriteCompat},
+	"secure-verify-ca-secret": {name: "secure-verify-ca-secret", level: CompatEquivalent},
+	"server-alias":            {name: "server-alias", level: CompatEquivalent},
+	"server-snippet":          {level: CompatUnsupported, reason: "snippets use nginx syntax and there isn't a host level snippet"},
+	"session-cookie-hash":     {level: CompatUnsupported, reason: "cookie values are the server names, see session-cookie-dynamic"},
+	"session-cookie-name":     {name: "session-cookie-name", level: CompatEquivalent},
+	"ssl-passthrough":         {name: "ssl-passthrough", level: CompatEquivalent},
+	"ssl-redirect":            {name: "ssl-redirect", level: CompatEquivalent},
+	"temporal-redirect":       {level: CompatUnsupported, reason: "redirects aren't supported, use config-backend"},
+	"upstream-hash-by":        {level: CompatUnsupported, reason: "see balance-algorithm and hash-type"},
+	"upstream-vhost":          {level: CompatUnsupported, reason: "the Host header is sent verbatim, use config-backend"},
+	"use-regex":               {level: CompatUnsupported, reason: "paths are always prefix matched"},
+	"whitelist-source-range":  {name: "whitelist-source-range", level: CompatEquivalent},
+	"x-forwarded-prefix":      {level: CompatUnsupported, reason: "use config-backend to add the header"},
+}
+
+func nginxRewriteCompat(value string, ann map[string]string) (string, string) {
+	if strings.Contains(value, "$") {
+		return CompatUnsupported, "capture groups aren't supported, haproxy-ingress replaces the path prefix of the ingress with the target"
+	}
+	return CompatDiffers, "nginx-ingress replaces the whole path with the target, haproxy-ingress only replaces the path prefix of the ingress, eg /app/login is rewritten to " +
+		strings.TrimSuffix(value, "/") + "/login instead of " + value
+}
+
+// NginxCompat reports how the nginx-ingress annotations of an ingress resource
+// would behave under haproxy-ingress, sorted by annotation name. prefix is the
+// haproxy-ingress annotation prefix used in the recommendations.
+func NginxCompat(ing *extensions.Ingress, prefix string) []*CompatFinding {
+	ann := map[string]string{}
+	for key, value := range ing.Annotations {
+		if name := strings.TrimPrefix(key, NginxAnnotationPrefix+"/"); name != key {
+			ann[name] = value
+		}
+	}
+	names := make([]string, 0, len(ann))
+	for name := range ann {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var findings []*CompatFinding
+	for _, name := range names {
+		value := ann[name]
+		finding := &CompatFinding{Annotation: NginxAnnotationPrefix + "/" + name}
+		compat, found := nginxAnnotations[name]
+		if !found {
+			finding.Level = CompatUnsupported
+			finding.Reason = "there isn't an equivalent annotation"
+			findings = append(findings, finding)
+			continue
+		}
+		finding.Level, finding.Reason = compat.level, compat.reason
+		if compat.check != nil {
+			finding.Level, finding.Reason = compat.check(value, ann)
+		}
+		if finding.Level == CompatEquivalent && finding.Reason == "" {
+			finding.Reason = "same behavior of " + prefix + "/" + compat.name
+		} else if compat.name != "" && finding.Reason == "" {
+			finding.Reason = "see " + prefix + "/" + compat.name
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
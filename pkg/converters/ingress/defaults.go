@@ -28,8 +28,13 @@ const (
 func createDefaults() *types.Config {
 	return &types.Config{
 		ConfigDefaults: types.ConfigDefaults{
-			BalanceAlgorithm: "roundrobin",
-			CookieKey:        "Ingress",
+			BackendProtocol:       "h1",
+			BalanceAlgorithm:      "roundrobin",
+			CertManagerIssuer:     "",
+			CertManagerIssuerKind: "Issuer",
+			CookieKey:             "Ingress",
+			ForwardedHeaders:      "",
+			Forwardfor:            "add",
 			HSTS:             true,
 			HSTSIncludeSubdomains: false,
 			HSTSMaxAge:            "15768000",
@@ -48,7 +53,12 @@ func createDefaults() *types.Config {
 			TimeoutTunnel:         "1h",
 		},
 		ConfigGlobals: types.ConfigGlobals{
+			AcmeEmails:                   "",
+			AcmeEndpoint:                 "",
+			AcmeExpiring:                 30,
+			AcmeTermsAgreed:              false,
 			BackendCheckInterval:         "2s",
+			BackendConflictStrategy:      "first-wins",
 			BackendServerSlotsIncrement:  32,
 			BindIPAddrHealthz:            "*",
 			BindIPAddrHTTP:               "*",
@@ -65,8 +75,8 @@ func createDefaults() *types.Config {
 			DNSTimeoutRetry:              "1s",
 			DrainSupport:                 false,
 			DrainSupportRedispatch:       true,
+			DrainTerminatingPeriod:       "",
 			DynamicScaling:               false,
-			Forwardfor:                   "add",
 			HealthzPort:                  10253,
 			HTTPLogFormat:                "",
 			HTTPPort:                     80,
@@ -83,23 +93,41 @@ func createDefaults() *types.Config {
 			NbprocSSL:                    0,
 			Nbthread:                     1,
 			NoTLSRedirectLocations:       "/.well-known/acme-challenge",
+			PeersPort:                    10000,
+			PeersService:                 "",
+			ProxyProtocolFrontends:       "http,https",
+			ProxyProtocolTrustedSources:  "",
+			QUICAltSvcMaxAge:             86400,
+			RuntimeAPIPort:               0,
+			RuntimeAPIWhitelist:          "",
 			SSLCiphers:                   defaultSSLCiphers,
 			SSLDHDefaultMaxSize:          2048,
 			SSLDHParam:                   "",
 			SSLEngine:                    "",
 			SSLHeadersPrefix:             "X-SSL",
+			SSLCertExpiring:              15,
+			SSLCertRuntimeUpdate:         false,
 			SSLModeAsync:                 false,
+			SSLOCSPUpdate:                false,
 			SSLOptions:                   "no-sslv3 no-tls-tickets",
+			StatsAdmin:                   false,
 			StatsAuth:                    "",
+			StatsAuthSecret:              "",
 			StatsPort:                    1936,
 			StatsProxyProtocol:           false,
 			StatsSSLCert:                 "",
+			StatsURI:                     "/",
 			StrictHost:                   true,
 			SyslogEndpoint:               "",
 			SyslogFormat:                 "rfc5424",
 			SyslogTag:                    "ingress",
 			TCPLogFormat:                 "",
 			TimeoutStop:                  "",
+			TLSALPN:                      "h2,http/1.1",
+			TrustedProxies:               "",
+			UseForwardedHeaders:          false,
+			UseHTTP2:                     true,
+			UseQUIC:                      false,
 			UseProxyProtocol:             false,
 		},
 	}
@@ -20,6 +20,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"strings"
+	"time"
 
 	api "k8s.io/api/core/v1"
 
@@ -29,16 +30,33 @@ import (
 // SecretContent ...
 type SecretContent map[string]map[string][]byte
 
+// ConfigMapContent ...
+type ConfigMapContent map[string]map[string]string
+
 // CacheMock ...
 type CacheMock struct {
-	SvcList       []*api.Service
-	EpList        map[string]*api.Endpoints
-	TermPodList   map[string][]*api.Pod
-	PodList       map[string]*api.Pod
-	SecretTLSPath map[string]string
-	SecretCAPath  map[string]string
-	SecretDHPath  map[string]string
-	SecretContent SecretContent
+	SvcList          []*api.Service
+	PublishSvc       *api.Service
+	EpList           map[string]*api.Endpoints
+	RemoteEpList     map[string]*api.Endpoints
+	TermPodList      map[string][]*api.Pod
+	PodList          map[string]*api.Pod
+	NodeZones        map[string]string
+	NsList           map[string]*api.Namespace
+	SecretTLSPath    map[string]string
+	SecretTLSExpire  map[string]time.Time
+	SecretCAPath     map[string]string
+	SecretCRLPath    map[string]string
+	SecretDHPath     map[string]string
+	SecretContent    SecretContent
+	ConfigMapContent ConfigMapContent
+	LuaScripts       map[string][]string
+	GlobalConfig     map[string]string
+	GlobalConfigErr  error
+	ClassConfig      map[string]string
+	ClassConfigErr   error
+	BackendResources map[string]map[string]string
+	HostResources    map[string]map[string]string
 }
 
 // GetService ...
@@ -54,6 +72,11 @@ func (c *CacheMock) GetService(serviceName string) (*api.Service, error) {
 	return nil, fmt.Errorf("service not found: '%s'", serviceName)
 }
 
+// GetPublishService ...
+func (c *CacheMock) GetPublishService() (*api.Service, error) {
+	return c.PublishSvc, nil
+}
+
 // GetEndpoints ...
 func (c *CacheMock) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
 	serviceName := service.Namespace + "/" + service.Name
@@ -63,6 +86,14 @@ func (c *CacheMock) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
 	return nil, fmt.Errorf("could not find endpoints for service '%s'", serviceName)
 }
 
+// GetRemoteEndpoints ...
+func (c *CacheMock) GetRemoteEndpoints(serviceName string) (*api.Endpoints, error) {
+	if ep, found := c.RemoteEpList[serviceName]; found {
+		return ep, nil
+	}
+	return nil, fmt.Errorf("could not find remote endpoints for service '%s'", serviceName)
+}
+
 // GetTerminatingPods ...
 func (c *CacheMock) GetTerminatingPods(service *api.Service) ([]*api.Pod, error) {
 	serviceName := service.Namespace + "/" + service.Name
@@ -80,26 +111,50 @@ func (c *CacheMock) GetPod(podName string) (*api.Pod, error) {
 	return nil, fmt.Errorf("pod not found: '%s'", podName)
 }
 
+// GetNodeZone ...
+func (c *CacheMock) GetNodeZone(nodeName string) (string, error) {
+	if zone, found := c.NodeZones[nodeName]; found {
+		return zone, nil
+	}
+	return "", fmt.Errorf("node not found: '%s'", nodeName)
+}
+
+// GetNamespace ...
+func (c *CacheMock) GetNamespace(name string) (*api.Namespace, error) {
+	if ns, found := c.NsList[name]; found {
+		return ns, nil
+	}
+	return nil, fmt.Errorf("namespace not found: '%s'", name)
+}
+
 // GetTLSSecretPath ...
 func (c *CacheMock) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
 	if path, found := c.SecretTLSPath[secretName]; found {
 		return ingtypes.File{
 			Filename: path,
 			SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
+			NotAfter: c.SecretTLSExpire[secretName],
 		}, nil
 	}
 	return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
 }
 
 // GetCASecretPath ...
-func (c *CacheMock) GetCASecretPath(secretName string) (ingtypes.File, error) {
+func (c *CacheMock) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
 	if path, found := c.SecretCAPath[secretName]; found {
-		return ingtypes.File{
+		ca = ingtypes.File{
 			Filename: path,
 			SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
-		}, nil
+		}
+		if path, found := c.SecretCRLPath[secretName]; found {
+			crl = ingtypes.File{
+				Filename: path,
+				SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
+			}
+		}
+		return ca, crl, nil
 	}
-	return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
+	return ca, crl, fmt.Errorf("secret not found: '%s'", secretName)
 }
 
 // GetDHSecretPath ...
@@ -123,3 +178,49 @@ func (c *CacheMock) GetSecretContent(secretName, keyName string) ([]byte, error)
 	}
 	return nil, fmt.Errorf("secret not found: '%s'", secretName)
 }
+
+// GetConfigMapContent ...
+func (c *CacheMock) GetConfigMapContent(configMapName, keyName string) ([]byte, error) {
+	if content, found := c.ConfigMapContent[configMapName]; found {
+		if val, found := content[keyName]; found {
+			return []byte(val), nil
+		}
+		return nil, fmt.Errorf("configmap '%s' does not have key '%s'", configMapName, keyName)
+	}
+	return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+}
+
+// GetLuaScriptPaths ...
+func (c *CacheMock) GetLuaScriptPaths(configMapName string) ([]ingtypes.File, error) {
+	if scripts, found := c.LuaScripts[configMapName]; found {
+		files := make([]ingtypes.File, len(scripts))
+		for i, script := range scripts {
+			files[i] = ingtypes.File{
+				Filename: script,
+				SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(script))),
+			}
+		}
+		return files, nil
+	}
+	return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+}
+
+// GetGlobalConfig ...
+func (c *CacheMock) GetGlobalConfig() (map[string]string, error) {
+	return c.GlobalConfig, c.GlobalConfigErr
+}
+
+// GetIngressClassConfig ...
+func (c *CacheMock) GetIngressClassConfig() (map[string]string, error) {
+	return c.ClassConfig, c.ClassConfigErr
+}
+
+// GetBackendResource ...
+func (c *CacheMock) GetBackendResource(resourceName string) map[string]string {
+	return c.BackendResources[resourceName]
+}
+
+// GetHostResource ...
+func (c *CacheMock) GetHostResource(resourceName string) map[string]string {
+	return c.HostResources[resourceName]
+}
@@ -18,8 +18,10 @@ package ingress
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
@@ -45,10 +47,25 @@ func NewIngressConverter(options *ingtypes.ConverterOptions, haproxy haproxy.Con
 		options:            options,
 		logger:             options.Logger,
 		cache:              options.Cache,
-		updater:            annotations.NewUpdater(haproxy, options.Cache, options.Logger),
-		globalConfig:       mergeConfig(createDefaults(), globalConfig),
+		updater:            annotations.NewUpdater(haproxy, options),
 		hostAnnotations:    map[*hatypes.Host]*ingtypes.HostAnnotations{},
 		backendAnnotations: map[*hatypes.Backend]*ingtypes.BackendAnnotations{},
+		backendSvcAnn:      map[*hatypes.Backend]*ingtypes.BackendAnnotations{},
+		backendIngresses:   map[*hatypes.Backend]map[string]bool{},
+		hostTLSMatch:       map[*hatypes.Host]int{},
+		expiringTLS:        map[string]bool{},
+	}
+	mergedConfig := c.mergeGlobalConfig(globalConfig)
+	c.globalConfig = mergeConfig(createDefaults(), mergedConfig)
+	if period := c.globalConfig.DrainTerminatingPeriod; period != "" {
+		if d, err := time.ParseDuration(period); err == nil && d >= 0 {
+			c.terminatingPeriod = d
+		} else {
+			c.logger.Warn("ignoring invalid drain-terminating-period: %s", period)
+		}
+	}
+	if options.Tracker != nil {
+		c.changes = options.Tracker.Changes(mergedConfig)
 	}
 	haproxy.ConfigDefaultX509Cert(options.DefaultSSLFile.Filename)
 	if options.DefaultBackend != "" {
@@ -70,15 +87,100 @@ type converter struct {
 	globalConfig       *ingtypes.Config
 	hostAnnotations    map[*hatypes.Host]*ingtypes.HostAnnotations
 	backendAnnotations map[*hatypes.Backend]*ingtypes.BackendAnnotations
+	backendSvcAnn      map[*hatypes.Backend]*ingtypes.BackendAnnotations
+	backendIngresses   map[*hatypes.Backend]map[string]bool
+	hostTLSMatch       map[*hatypes.Host]int
+	expiringTLS        map[string]bool
+	terminatingPeriod  time.Duration
+	changes            *ingtypes.Changes
+}
+
+// mergeGlobalConfig merges the global ConfigMap with the parameters of
+// the IngressClass and the spec of the global config resource, in this
+// order of precedence: resource, class parameters and ConfigMap
+func (c *converter) mergeGlobalConfig(configMap map[string]string) map[string]string {
+	if classConfig, err := c.cache.GetIngressClassConfig(); err != nil {
+		c.logger.Warn("ignoring IngressClass parameters due to an error reading them: %v", err)
+	} else if classConfig != nil {
+		config := make(map[string]string, len(configMap)+len(classConfig))
+		for key, value := range configMap {
+			config[key] = value
+		}
+		for key, value := range classConfig {
+			config[key] = value
+		}
+		configMap = config
+	}
+	crd, err := c.cache.GetGlobalConfig()
+	if err != nil {
+		c.logger.Warn("using global ConfigMap only due to an error reading global config resource: %v", err)
+		return configMap
+	}
+	if crd == nil {
+		return configMap
+	}
+	config := make(map[string]string, len(configMap)+len(crd))
+	for key, value := range configMap {
+		config[key] = value
+	}
+	keys := make([]string, 0, len(crd))
+	for key := range crd {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := crd[key]
+		if cmValue, found := configMap[key]; found && cmValue != value {
+			c.logger.Warn("global config resource overrides '%s' of the global ConfigMap: '%s' -> '%s'", key, cmValue, value)
+		}
+		config[key] = value
+	}
+	return config
 }
 
 func (c *converter) Sync(ingress []*extensions.Ingress) {
-	for _, ing := range ingress {
+	for _, ing := range c.sortIngress(ingress) {
 		c.syncIngress(ing)
 	}
 	c.syncAnnotations()
 }
 
+// sortIngress defines the order ingress resources are parsed, which
+// is the precedence used when two ingress resources configure the
+// same host or backend with conflicting annotations: higher
+// config-priority annotation first, then older ingress resources,
+// then namespace/name
+func (c *converter) sortIngress(ingress []*extensions.Ingress) []*extensions.Ingress {
+	priority := make(map[*extensions.Ingress]int, len(ingress))
+	for _, ing := range ingress {
+		if value, found := c.findAnnotation(ing.Annotations, "config-priority"); found {
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				c.logger.Warn("ignoring invalid config-priority '%s' on ingress '%s/%s'", value, ing.Namespace, ing.Name)
+				continue
+			}
+			priority[ing] = p
+		}
+	}
+	sorted := make([]*extensions.Ingress, len(ingress))
+	copy(sorted, ingress)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ing1 := sorted[i]
+		ing2 := sorted[j]
+		if priority[ing1] != priority[ing2] {
+			return priority[ing1] > priority[ing2]
+		}
+		if !ing1.CreationTimestamp.Equal(&ing2.CreationTimestamp) {
+			return ing1.CreationTimestamp.Before(&ing2.CreationTimestamp)
+		}
+		if ing1.Namespace != ing2.Namespace {
+			return ing1.Namespace < ing2.Namespace
+		}
+		return ing1.Name < ing2.Name
+	})
+	return sorted
+}
+
 func (c *converter) syncIngress(ing *extensions.Ingress) {
 	fullIngName := fmt.Sprintf("%s/%s", ing.Namespace, ing.Name)
 	ingFrontAnn, ingBackAnn := c.readAnnotations(&ingtypes.Source{
@@ -119,26 +221,40 @@ func (c *converter) syncIngress(ing *extensions.Ingress) {
 				continue
 			}
 			host.AddPath(backend, uri)
+			host.FindPath(uri).HSTS = &hatypes.HSTS{
+				Enabled:    ingBackAnn.HSTS,
+				MaxAge:     ingBackAnn.HSTSMaxAge,
+				Preload:    ingBackAnn.HSTSPreload,
+				Subdomains: ingBackAnn.HSTSIncludeSubdomains,
+			}
 			c.addHTTPPassthrough(fullSvcName, ingFrontAnn, ingBackAnn)
 		}
 		for _, tls := range ing.Spec.TLS {
 			for _, tlshost := range tls.Hosts {
-				if tlshost == hostname {
-					tlsPath := c.addTLS(ing.Namespace, tls.SecretName)
-					if host.TLS.TLSHash == "" {
-						host.TLS.TLSFilename = tlsPath.Filename
-						host.TLS.TLSHash = tlsPath.SHA1Hash
-					} else if host.TLS.TLSHash != tlsPath.SHA1Hash {
-						msg := fmt.Sprintf("TLS of host '%s' was already assigned", host.Hostname)
-						if tls.SecretName != "" {
-							c.logger.Warn("skipping TLS secret '%s' of ingress '%s': %s", tls.SecretName, fullIngName, msg)
-						} else {
-							c.logger.Warn("skipping default TLS secret of ingress '%s': %s", fullIngName, msg)
-						}
-					}
+				match := tlsMatch(tlshost, hostname)
+				if match == 0 {
+					continue
+				}
+				if tls.SecretName != "" && !c.isProviderSecret(tls.SecretName) {
+					c.trackTLSSecret(ing.Namespace+"/"+tls.SecretName, hostname, ingFrontAnn)
 				}
+				c.assignTLS(host, match, ing.Namespace, tls.SecretName, fullIngName)
 			}
 		}
+		if ingFrontAnn.TLSSecret != "" {
+			namespace, secretName := ing.Namespace, ingFrontAnn.TLSSecret
+			if !c.isProviderSecret(secretName) {
+				fullSecretName, err := utils.SecretName(c.options, c.cache, ing.Namespace, secretName)
+				if err != nil {
+					c.logger.Warn("ignoring tls-secret on %v: %v", ingFrontAnn.Source, err)
+					continue
+				}
+				pos := strings.Index(fullSecretName, "/")
+				namespace, secretName = fullSecretName[:pos], fullSecretName[pos+1:]
+				c.trackTLSSecret(fullSecretName, hostname, ingFrontAnn)
+			}
+			c.assignTLS(host, tlsMatchPinned, namespace, secretName, fullIngName)
+		}
 	}
 }
 
@@ -149,11 +265,7 @@ func (c *converter) syncAnnotations() {
 			c.updater.UpdateHostConfig(host, ann)
 		}
 	}
-	for _, backend := range c.haproxy.Backends() {
-		if ann, found := c.backendAnnotations[backend]; found {
-			c.updater.UpdateBackendConfig(backend, ann)
-		}
-	}
+	c.syncBackends(c.haproxy.Backends())
 }
 
 func (c *converter) addDefaultHostBackend(fullSvcName, svcPort string, ingFrontAnn *ingtypes.HostAnnotations, ingBackAnn *ingtypes.BackendAnnotations) error {
@@ -192,16 +304,24 @@ func (c *converter) addBackend(fullSvcName, svcPort string, ingAnn *ingtypes.Bac
 	ssvcName := strings.Split(fullSvcName, "/")
 	namespace := ssvcName[0]
 	svcName := ssvcName[1]
-	if svcPort == "" {
+	if svcPort == "" && len(svc.Spec.Ports) > 0 {
 		// if the port wasn't specified, take the first one
 		// from the api.Service object
 		svcPort = svc.Spec.Ports[0].TargetPort.String()
 	}
-	epport := findServicePort(svc, svcPort)
+	var epport intstr.IntOrString
+	if svc.Spec.Type == api.ServiceTypeExternalName {
+		epport = findExternalNamePort(svc, svcPort)
+	} else {
+		epport = findServicePort(svc, svcPort)
+	}
 	if epport.String() == "" {
 		return nil, fmt.Errorf("port not found: '%s'", svcPort)
 	}
 	backend := c.haproxy.AcquireBackend(namespace, svcName, epport.String())
+	if ingAnn.Source.Type == "ingress" {
+		c.trackBackendIngress(backend, ingAnn.Source)
+	}
 	ann, found := c.backendAnnotations[backend]
 	if !found {
 		// New backend, configure endpoints and svc annotations
@@ -215,13 +335,45 @@ func (c *converter) addBackend(fullSvcName, svcPort string, ingAnn *ingtypes.Bac
 			Type:      "service",
 		}, svc.Annotations)
 		c.backendAnnotations[backend] = ann
+		svcAnn := *ann
+		c.backendSvcAnn[backend] = &svcAnn
+	}
+	// Merging Ingress annotations, service annotations take precedence
+	// and only conflicts with other ingress resources use the strategy
+	svcAnn := *c.backendSvcAnn[backend]
+	svcSkipped, _ := utils.UpdateStruct(c.globalConfig.ConfigDefaults, ingAnn, &svcAnn)
+	merged := *ann
+	skipped, _ := utils.UpdateStruct(c.globalConfig.ConfigDefaults, ingAnn, &merged)
+	var svcConflicts, ingConflicts []string
+	conflict := map[string]bool{}
+	for _, name := range svcSkipped {
+		conflict[name] = true
+	}
+	for _, name := range skipped {
+		// hsts annotations are also configured per path, so they don't conflict
+		if strings.HasPrefix(name, "hsts") {
+			continue
+		}
+		if conflict[name] {
+			svcConflicts = append(svcConflicts, name)
+		} else {
+			ingConflicts = append(ingConflicts, name)
+		}
+	}
+	if len(ingConflicts) > 0 && c.globalConfig.BackendConflictStrategy == "error" {
+		return nil, fmt.Errorf("annotation(s) conflict with backend '%s/%s:%s': %v",
+			backend.Namespace, backend.Name, backend.Port, ingConflicts)
 	}
-	// Merging Ingress annotations
-	skipped, _ := utils.UpdateStruct(c.globalConfig.ConfigDefaults, ingAnn, ann)
-	if len(skipped) > 0 {
+	if len(svcConflicts) > 0 {
 		c.logger.Info("skipping backend '%s/%s:%s' annotation(s) from %v due to conflict: %v",
-			backend.Namespace, backend.Name, backend.Port, ingAnn.Source, skipped)
+			backend.Namespace, backend.Name, backend.Port, ingAnn.Source, svcConflicts)
 	}
+	if len(ingConflicts) > 0 {
+		// warnings are also emitted as events of the ingress resource
+		c.logger.Warn("skipping backend '%s/%s:%s' annotation(s) from %v due to conflict with another ingress: %v",
+			backend.Namespace, backend.Name, backend.Port, ingAnn.Source, ingConflicts)
+	}
+	*ann = merged
 	return backend, nil
 }
 
@@ -248,6 +400,21 @@ func findServicePort(svc *api.Service, servicePort string) intstr.IntOrString {
 	return intstr.FromString("")
 }
 
+// findExternalNamePort returns the port of an ExternalName service. Target
+// ports aren't used because there is no proxy between HAProxy and the external
+// hostname, and numeric ports don't need to be declared in the service.
+func findExternalNamePort(svc *api.Service, servicePort string) intstr.IntOrString {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == servicePort {
+			return intstr.FromInt(int(port.Port))
+		}
+	}
+	if port, err := strconv.Atoi(servicePort); err == nil && port > 0 {
+		return intstr.FromInt(port)
+	}
+	return intstr.FromString("")
+}
+
 func (c *converter) addHTTPPassthrough(fullSvcName string, ingFrontAnn *ingtypes.HostAnnotations, ingBackAnn *ingtypes.BackendAnnotations) {
 	// a very specific use case of pre-parsing annotations:
 	// need to add a backend if ssl-passthrough-http-port assigned
@@ -258,7 +425,114 @@ func (c *converter) addHTTPPassthrough(fullSvcName string, ingFrontAnn *ingtypes
 	}
 }
 
-func (c *converter) addTLS(namespace, secretName string) ingtypes.File {
+// trackTLSSecret sends the secrets used by the TLS hosts to the subsystems
+// which can issue their certificates
+func (c *converter) trackTLSSecret(secretName, hostname string, ann *ingtypes.HostAnnotations) {
+	if c.options.AcmeTracker != nil {
+		c.options.AcmeTracker.TrackAcme(secretName, hostname, ann.Acme)
+	}
+	if c.options.CertTracker != nil && ann.CertManagerIssuer != "" {
+		kind := ann.CertManagerIssuerKind
+		if kind != "Issuer" && kind != "ClusterIssuer" {
+			c.logger.Warn("ignoring invalid cert-manager-issuer-kind '%s' on %v, using 'Issuer'", kind, ann.Source)
+			kind = "Issuer"
+		}
+		c.options.CertTracker.TrackCertificate(secretName, hostname, ann.CertManagerIssuer, kind)
+	}
+}
+
+// Precedence of the TLS secrets which can be assigned to a host, the
+// greatest one wins despite the order the ingress resources are parsed
+const (
+	tlsMatchWildcard = iota + 1
+	tlsMatchExact
+	tlsMatchPinned
+)
+
+// tlsMatch returns how a host of the tls section of an ingress matches a
+// hostname: exactly, via a single level wildcard like `*.domain.tld`, or
+// zero if it doesn't match
+func tlsMatch(tlshost, hostname string) int {
+	if tlshost == hostname {
+		return tlsMatchExact
+	}
+	if strings.HasPrefix(tlshost, "*.") && !strings.HasPrefix(hostname, "*.") {
+		if pos := strings.Index(hostname, "."); pos > 0 && hostname[pos:] == tlshost[1:] {
+			return tlsMatchWildcard
+		}
+	}
+	return 0
+}
+
+func (c *converter) assignTLS(host *hatypes.Host, match int, namespace, secretName, fullIngName string) {
+	current := c.hostTLSMatch[host]
+	if match < current {
+		return
+	}
+	tlsPath := c.addTLS(namespace, secretName, host.Hostname)
+	if secretName != "" {
+		if c.isProviderSecret(secretName) {
+			c.checkExpiring(tlsPath, secretName, fullIngName)
+		} else {
+			c.checkExpiring(tlsPath, namespace+"/"+secretName, fullIngName)
+		}
+	}
+	if match > current {
+		c.hostTLSMatch[host] = match
+		host.TLS.TLSFilename = tlsPath.Filename
+		host.TLS.TLSHash = tlsPath.SHA1Hash
+	} else if host.TLS.TLSHash != tlsPath.SHA1Hash {
+		msg := fmt.Sprintf("TLS of host '%s' was already assigned", host.Hostname)
+		if secretName != "" {
+			c.logger.Warn("skipping TLS secret '%s' of ingress '%s': %s", secretName, fullIngName, msg)
+		} else {
+			c.logger.Warn("skipping default TLS secret of ingress '%s': %s", fullIngName, msg)
+		}
+	}
+}
+
+// checkExpiring warns, once per ingress, if the certificate of a secret
+// expires in less than ssl-cert-expiring days
+func (c *converter) checkExpiring(tlsPath ingtypes.File, tlsSecretName, fullIngName string) {
+	days := c.globalConfig.SSLCertExpiring
+	if days <= 0 || tlsPath.NotAfter.IsZero() || tlsPath.Filename == c.options.DefaultSSLFile.Filename {
+		return
+	}
+	key := fullIngName + "/" + tlsSecretName
+	if c.expiringTLS[key] {
+		return
+	}
+	if remaining := time.Until(tlsPath.NotAfter); remaining < time.Duration(days)*24*time.Hour {
+		c.expiringTLS[key] = true
+		if remaining <= 0 {
+			c.logger.Warn("ingress '%s' uses the certificate of secret '%s' which expired on %s", fullIngName, tlsSecretName, tlsPath.NotAfter.UTC().Format(time.RFC3339))
+		} else {
+			c.logger.Warn("ingress '%s' uses the certificate of secret '%s' which expires on %s", fullIngName, tlsSecretName, tlsPath.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+// isProviderSecret returns true if the secret name refers to a certificate
+// of a secret provider, eg `vault:<role>`
+func (c *converter) isProviderSecret(secretName string) bool {
+	if pos := strings.Index(secretName, ":"); pos > 0 {
+		_, found := c.options.SecretProviders[secretName[:pos]]
+		return found
+	}
+	return false
+}
+
+func (c *converter) addTLS(namespace, secretName, hostname string) ingtypes.File {
+	if c.isProviderSecret(secretName) {
+		pos := strings.Index(secretName, ":")
+		provider := c.options.SecretProviders[secretName[:pos]]
+		tlsFile, err := provider.GetTLSCertificate(secretName[pos+1:], hostname)
+		if err == nil {
+			return tlsFile
+		}
+		c.logger.Warn("using default certificate of host '%s' due to an error reading '%s': %v", hostname, secretName, err)
+		return c.options.DefaultSSLFile
+	}
 	if secretName != "" {
 		tlsSecretName := namespace + "/" + secretName
 		tlsFile, err := c.cache.GetTLSSecretPath(tlsSecretName)
@@ -271,11 +545,15 @@ func (c *converter) addTLS(namespace, secretName string) ingtypes.File {
 }
 
 func (c *converter) addEndpoints(svc *api.Service, svcPort intstr.IntOrString, backend *hatypes.Backend) error {
+	if svc.Spec.Type == api.ServiceTypeExternalName {
+		// the hostname is resolved by HAProxy, see buildBackendDNS
+		backend.NewEndpoint(svc.Spec.ExternalName, svcPort.IntValue(), "")
+		return nil
+	}
 	endpoints, err := c.cache.GetEndpoints(svc)
 	if err != nil {
 		return err
 	}
-	// TODO ServiceTypeExternalName
 	// TODO ServiceUpstream - annotation nao documentada
 	// TODO svcPort.IntValue() doesn't work if svc.targetPort is a pod's named port
 	for _, subset := range endpoints.Subsets {
@@ -303,32 +581,122 @@ func (c *converter) addEndpoints(svc *api.Service, svcPort intstr.IntOrString, b
 			ep := backend.NewEndpoint(pod.Status.PodIP, svcPort.IntValue(), pod.Namespace+"/"+pod.Name)
 			ep.Weight = 0
 		}
+	} else if c.terminatingPeriod > 0 {
+		// terminating pods are kept as draining servers, so requests on
+		// the fly and persistent connections have time to finish
+		pods, err := c.cache.GetTerminatingPods(svc)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp == nil || time.Since(pod.DeletionTimestamp.Time) > c.terminatingPeriod {
+				continue
+			}
+			port := svcPort.IntValue()
+			if backend.FindEndpoint(fmt.Sprintf("%s:%d", pod.Status.PodIP, port)) != nil {
+				// still listed as a ready address
+				continue
+			}
+			ep := backend.NewEndpoint(pod.Status.PodIP, port, pod.Namespace+"/"+pod.Name)
+			ep.Weight = 0
+		}
 	}
 	return nil
 }
 
+// findAnnotation returns the value of an annotation using the
+// first annotation prefix, in order of precedence, where it is found
+func (c *converter) findAnnotation(annotations map[string]string, name string) (string, bool) {
+	for _, prefix := range c.options.AnnotationPrefix {
+		if value, found := annotations[prefix+"/"+name]; found {
+			return value, true
+		}
+	}
+	return "", false
+}
+
 func (c *converter) readAnnotations(source *ingtypes.Source, annotations map[string]string) (*ingtypes.HostAnnotations, *ingtypes.BackendAnnotations) {
 	ann := make(map[string]string, len(annotations))
-	prefix := c.options.AnnotationPrefix + "/"
-	for annName, annValue := range annotations {
-		if strings.HasPrefix(annName, prefix) {
-			name := strings.TrimPrefix(annName, prefix)
-			ann[name] = annValue
+	annNames := make([]string, 0, len(annotations))
+	for annName := range annotations {
+		annNames = append(annNames, annName)
+	}
+	sort.Strings(annNames)
+	// prefixes are declared in order of precedence,
+	// so the first one is read last and overrides the others
+	prefixes := c.options.AnnotationPrefix
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		prefix := prefixes[i] + "/"
+		for _, annName := range annNames {
+			if strings.HasPrefix(annName, prefix) {
+				name := strings.TrimPrefix(annName, prefix)
+				annValue := annotations[annName]
+				if curValue, found := ann[name]; found && curValue != annValue {
+					c.logger.Warn("annotation '%s' overrides '%s' of a lower precedence prefix on %v: '%s' -> '%s'", annName, name, source, curValue, annValue)
+				}
+				ann[name] = annValue
+			}
 		}
 	}
 	frontAnn := &ingtypes.HostAnnotations{Source: *source}
 	backAnn := &ingtypes.BackendAnnotations{Source: *source}
 	utils.UpdateStruct(struct{}{}, c.globalConfig.ConfigDefaults, frontAnn)
 	utils.UpdateStruct(struct{}{}, c.globalConfig.ConfigDefaults, backAnn)
-	if err := utils.MergeMap(ann, frontAnn); err != nil {
+	frontConfig, backConfig := c.readResources(source, ann)
+	if err := utils.MergeMap(frontConfig, frontAnn); err != nil {
 		c.logger.Error("error merging host annotations from %v: %v", source, err)
 	}
-	if err := utils.MergeMap(ann, backAnn); err != nil {
+	if err := utils.MergeMap(backConfig, backAnn); err != nil {
 		c.logger.Error("error merging backend annotations from %v: %v", source, err)
 	}
 	return frontAnn, backAnn
 }
 
+// readResources merges annotations with the HAProxyHost and HAProxyBackend
+// resources referenced by the host-config and backend-config annotations.
+// Services use the HAProxyBackend of the same name if not referenced.
+// Annotations have precedence over the resources on conflicts.
+func (c *converter) readResources(source *ingtypes.Source, ann map[string]string) (front, back map[string]string) {
+	merge := func(kind, resourceName string, resource map[string]string) map[string]string {
+		config := make(map[string]string, len(ann)+len(resource))
+		for key, value := range resource {
+			if annValue, found := ann[key]; found && annValue != value {
+				c.logger.Warn("annotation '%s' of %v overrides %s '%s': '%s' -> '%s'", key, source, kind, resourceName, value, annValue)
+			}
+			config[key] = value
+		}
+		for key, value := range ann {
+			config[key] = value
+		}
+		return config
+	}
+	read := func(kind, annName string, getResource func(string) map[string]string, matchName string) map[string]string {
+		name, explicit := ann[annName]
+		if !explicit {
+			name = matchName
+		}
+		if name == "" {
+			return ann
+		}
+		resourceName := utils.FullQualifiedName(source.Namespace, name)
+		resource := getResource(resourceName)
+		if resource == nil {
+			if explicit {
+				c.logger.Warn("%s '%s' referenced on %v was not found", kind, resourceName, source)
+			}
+			return ann
+		}
+		return merge(kind, resourceName, resource)
+	}
+	var svcName string
+	if source.Type == "service" {
+		svcName = source.Name
+	}
+	front = read("HAProxyHost", "host-config", c.cache.GetHostResource, "")
+	back = read("HAProxyBackend", "backend-config", c.cache.GetBackendResource, svcName)
+	return front, back
+}
+
 func readServiceNamePort(backend *extensions.IngressBackend) (string, string) {
 	serviceName := backend.ServiceName
 	servicePort := backend.ServicePort.String()
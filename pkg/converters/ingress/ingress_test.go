@@ -17,13 +17,21 @@ limitations under the License.
 package ingress
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/diff"
 	yaml "gopkg.in/yaml.v2"
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -32,7 +40,6 @@ import (
 	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
 )
@@ -251,6 +258,59 @@ func TestSyncInvalidEndpoint(t *testing.T) {
 ERROR error adding endpoints of service 'default/echo': could not find endpoints for service 'default/echo'`)
 }
 
+func TestSyncExternalName(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.cache.SvcList = append(c.cache.SvcList, c.createObject(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: default
+spec:
+  type: ExternalName
+  externalName: api.example.com
+  ports:
+  - name: https
+    port: 443`).(*api.Service))
+	c.cache.SvcList = append(c.cache.SvcList, c.createObject(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: default
+spec:
+  type: ExternalName
+  externalName: web.example.com`).(*api.Service))
+	c.Sync(
+		c.createIng1("default/echo1", "echo.example.com", "/api", "api:https"),
+		c.createIng1("default/echo2", "echo.example.com", "/web", "web:8080"),
+		c.createIng1("default/echo3", "echo.example.com", "/app", "web:http"),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /web
+    backend: default_web_8080
+  - path: /api
+    backend: default_api_443`)
+
+	c.compareConfigBack(`
+- id: default_api_443
+  endpoints:
+  - ip: api.example.com
+    port: 443
+- id: default_web_8080
+  endpoints:
+  - ip: web.example.com
+    port: 8080` + defaultBackendConfig)
+
+	c.compareLogging(`
+WARN skipping backend config of ingress 'default/echo3': port not found: 'http'`)
+}
+
 func TestSyncDrainSupport(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -295,6 +355,71 @@ func TestSyncDrainSupport(t *testing.T) {
 	c.compareLogging(``)
 }
 
+func TestSyncDrainTerminatingPeriod(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	svc, _ := c.createSvc1("default/echo", "8080", "172.17.1.101")
+	svcName := svc.Namespace + "/" + svc.Name
+	pod1 := c.createPod1("default/echo-xxxxx", "172.17.1.102")
+	pod1.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-10 * time.Second)}
+	pod2 := c.createPod1("default/echo-yyyyy", "172.17.1.103")
+	pod2.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+	pod3 := c.createPod1("default/echo-zzzzz", "172.17.1.101")
+	pod3.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	c.cache.TermPodList[svcName] = []*api.Pod{pod1, pod2, pod3}
+
+	c.SyncDef(
+		map[string]string{"drain-terminating-period": "30s"},
+		c.createIng1("default/echo", "echo.example.com", "/", "echo:8080"),
+	)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.1.101
+    port: 8080
+  - ip: 172.17.1.102
+    port: 8080
+    drain: true
+- id: _default_backend
+  endpoints:
+  - ip: 172.17.0.99
+    port: 8080
+`)
+
+	c.compareLogging(``)
+}
+
+func TestSyncDrainTerminatingPeriodInvalid(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	svc, _ := c.createSvc1("default/echo", "8080", "172.17.1.101")
+	pod := c.createPod1("default/echo-xxxxx", "172.17.1.102")
+	pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	c.cache.TermPodList[svc.Namespace+"/"+svc.Name] = []*api.Pod{pod}
+
+	c.SyncDef(
+		map[string]string{"drain-terminating-period": "30"},
+		c.createIng1("default/echo", "echo.example.com", "/", "echo:8080"),
+	)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.1.101
+    port: 8080
+- id: _default_backend
+  endpoints:
+  - ip: 172.17.0.99
+    port: 8080
+`)
+
+	c.compareLogging(`
+WARN ignoring invalid drain-terminating-period: 30`)
+}
+
 func TestSyncRootPathLast(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -449,6 +574,146 @@ func TestSyncTLSCustom(t *testing.T) {
     tlsfilename: /tls/default/tls-echo.pem`)
 }
 
+type acmeTrackerMock struct {
+	tracked []string
+}
+
+func (a *acmeTrackerMock) TrackAcme(secretName, domain string, annotated bool) {
+	a.tracked = append(a.tracked, fmt.Sprintf("%s:%s:%t", secretName, domain, annotated))
+}
+
+func TestSyncTLSAcme(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	acme := &acmeTrackerMock{}
+	c.acmeTracker = acme
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo")
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo")
+	ing1.SetAnnotations(map[string]string{"ingress.kubernetes.io/acme": "true"})
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-echo2")
+	ing3 := c.createIngTLS1("default/echo3", "echo3.example.com", "/", "echo:8080", "")
+	c.Sync(ing1, ing2, ing3)
+
+	expected := []string{
+		"default/tls-echo:echo1.example.com:true",
+		"default/tls-echo2:echo2.example.com:false",
+	}
+	if !reflect.DeepEqual(acme.tracked, expected) {
+		t.Errorf("tracked secrets differ - expected: %v - actual: %v", expected, acme.tracked)
+	}
+
+	c.compareLogging(`
+WARN using default certificate due to an error reading secret 'default/tls-echo2': secret not found: 'default/tls-echo2'`)
+}
+
+type certTrackerMock struct {
+	tracked []string
+}
+
+func (m *certTrackerMock) TrackCertificate(secretName, domain, issuer, issuerKind string) {
+	m.tracked = append(m.tracked, fmt.Sprintf("%s:%s:%s/%s", secretName, domain, issuerKind, issuer))
+}
+
+func TestSyncTLSCertManager(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	certs := &certTrackerMock{}
+	c.certTracker = certs
+	c.createSvc1Auto()
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo1")
+	ing1.SetAnnotations(map[string]string{
+		"ingress.kubernetes.io/cert-manager-issuer":      "letsencrypt",
+		"ingress.kubernetes.io/cert-manager-issuer-kind": "ClusterIssuer",
+	})
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-echo2")
+	ing2.SetAnnotations(map[string]string{
+		"ingress.kubernetes.io/cert-manager-issuer":      "ca",
+		"ingress.kubernetes.io/cert-manager-issuer-kind": "Other",
+	})
+	ing3 := c.createIngTLS1("default/echo3", "echo3.example.com", "/", "echo:8080", "tls-echo3")
+	c.Sync(ing1, ing2, ing3)
+
+	expected := []string{
+		"default/tls-echo1:echo1.example.com:ClusterIssuer/letsencrypt",
+		"default/tls-echo2:echo2.example.com:Issuer/ca",
+	}
+	if !reflect.DeepEqual(certs.tracked, expected) {
+		t.Errorf("tracked secrets differ - expected: %v - actual: %v", expected, certs.tracked)
+	}
+
+	c.compareLogging(`
+WARN using default certificate due to an error reading secret 'default/tls-echo1': secret not found: 'default/tls-echo1'
+WARN ignoring invalid cert-manager-issuer-kind 'Other' on ingress 'default/echo2', using 'Issuer'
+WARN using default certificate due to an error reading secret 'default/tls-echo2': secret not found: 'default/tls-echo2'
+WARN using default certificate due to an error reading secret 'default/tls-echo3': secret not found: 'default/tls-echo3'`)
+}
+
+type secretProviderMock struct {
+	certs map[string]ingtypes.File
+}
+
+func (m *secretProviderMock) GetTLSCertificate(reference, domain string) (ingtypes.File, error) {
+	if crt, found := m.certs[reference+"/"+domain]; found {
+		return crt, nil
+	}
+	return ingtypes.File{}, fmt.Errorf("certificate is being issued")
+}
+
+func TestSyncTLSSecretProvider(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	acme := &acmeTrackerMock{}
+	c.acmeTracker = acme
+	c.secretProviders = map[string]ingtypes.SecretProvider{
+		"vault": &secretProviderMock{certs: map[string]ingtypes.File{
+			"web/echo1.example.com": {Filename: "/tls/vault-echo1.pem", SHA1Hash: "v1"},
+		}},
+	}
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo3")
+	ing3 := c.createIngTLS1("default/echo3", "echo3.example.com", "/", "echo:8080", "tls-echo3")
+	ing3.SetAnnotations(map[string]string{"ingress.kubernetes.io/tls-secret": "vault:web"})
+	// createIngTLS1 uses the colon as the secret and hosts separator
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "")
+	ing1.Spec.TLS[0].SecretName = "vault:web"
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "")
+	ing2.Spec.TLS[0].SecretName = "vault:web"
+	c.Sync(ing1, ing2, ing3)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/vault-echo1.pem
+- hostname: echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/tls-default.pem
+- hostname: echo3.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/tls-default.pem`)
+
+	expected := []string{"default/tls-echo3:echo3.example.com:false"}
+	if !reflect.DeepEqual(acme.tracked, expected) {
+		t.Errorf("tracked secrets differ - expected: %v - actual: %v", expected, acme.tracked)
+	}
+
+	c.compareLogging(`
+WARN using default certificate of host 'echo2.example.com' due to an error reading 'vault:web': certificate is being issued
+WARN using default certificate of host 'echo3.example.com' due to an error reading 'vault:web': certificate is being issued`)
+}
+
 func TestSyncRedeclareTLS(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -542,6 +807,144 @@ func TestSyncRedeclareTLSCustomFirst(t *testing.T) {
 WARN skipping default TLS secret of ingress 'default/echo2': TLS of host 'echo.example.com' was already assigned`)
 }
 
+func TestSyncTLSWildcard(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-wildcard")
+	c.createSecretTLS1("default/tls-echo2")
+	c.Sync(
+		c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-wildcard:*.example.com"),
+		c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-wildcard:*.example.com"),
+		c.createIngTLS1("default/echo3", "echo2.example.com", "/app", "echo:8080", "tls-echo2"),
+		c.createIngTLS1("default/echo4", "sub.echo.example.com", "/", "echo:8080", "tls-wildcard:*.example.com"),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-wildcard.pem
+- hostname: echo2.example.com
+  paths:
+  - path: /app
+    backend: default_echo_8080
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-echo2.pem
+- hostname: sub.echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080`)
+}
+
+func TestSyncTLSSecretAnnotation(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.secretNs = []string{"other"}
+	c.secretNsAnn = true
+	c.cache.NsList = map[string]*api.Namespace{
+		"shared": {ObjectMeta: metav1.ObjectMeta{Name: "shared", Annotations: map[string]string{
+			"ingress.kubernetes.io/allowed-secret-namespaces": "team1,default",
+		}}},
+		"private": {ObjectMeta: metav1.ObjectMeta{Name: "private"}},
+	}
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo1")
+	c.createSecretTLS1("default/tls-echo2")
+	c.createSecretTLS1("other/tls-echo3")
+	c.createSecretTLS1("shared/tls-echo5")
+	c.createSecretTLS1("private/tls-echo6")
+	c.Sync(
+		c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo1"),
+		c.createIng1Ann("default/echo2", "echo1.example.com", "/app", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "tls-echo2",
+		}),
+		c.createIng1Ann("default/echo3", "echo2.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "other/tls-echo3",
+		}),
+		c.createIng1Ann("default/echo4", "echo2.example.com", "/app", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "tls-echo1",
+		}),
+		c.createIng1Ann("default/echo5", "echo3.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "shared/tls-echo5",
+		}),
+		c.createIng1Ann("default/echo6", "echo4.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "private/tls-echo6",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /app
+    backend: default_echo_8080
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-echo2.pem
+- hostname: echo2.example.com
+  paths:
+  - path: /app
+    backend: default_echo_8080
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/other/tls-echo3.pem
+- hostname: echo3.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/shared/tls-echo5.pem
+- hostname: echo4.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080`)
+
+	c.compareLogging(`
+INFO skipping host annotation(s) from ingress 'default/echo4' due to conflict: [tls-secret]
+WARN skipping TLS secret 'tls-echo1' of ingress 'default/echo4': TLS of host 'echo2.example.com' was already assigned
+WARN ignoring tls-secret on ingress 'default/echo6': cross namespace access to secret 'private/tls-echo6' is not allowed`)
+}
+
+func TestSyncTLSExpiring(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	expiring := time.Now().Add(5 * 24 * time.Hour).UTC().Truncate(time.Second)
+	c.cache.SecretTLSExpire = map[string]time.Time{
+		"default/tls-echo1": expiring,
+		"default/tls-echo2": time.Now().Add(30 * 24 * time.Hour),
+		"default/tls-echo3": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo1")
+	c.createSecretTLS1("default/tls-echo2")
+	c.createSecretTLS1("default/tls-echo3")
+	c.SyncDef(map[string]string{"ssl-cert-expiring": "15"},
+		c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo1"),
+		c.createIngTLS1("default/echo2", "echo1.example.com", "/app", "echo:8080", "tls-echo1"),
+		c.createIngTLS1("default/echo3", "echo2.example.com", "/", "echo:8080", "tls-echo2"),
+		c.createIngTLS1("default/echo4", "echo3.example.com", "/", "echo:8080", "tls-echo3"),
+	)
+
+	c.compareLogging(`
+WARN ingress 'default/echo1' uses the certificate of secret 'default/tls-echo1' which expires on ` + expiring.Format(time.RFC3339) + `
+WARN ingress 'default/echo2' uses the certificate of secret 'default/tls-echo1' which expires on ` + expiring.Format(time.RFC3339) + `
+WARN ingress 'default/echo4' uses the certificate of secret 'default/tls-echo3' which expired on 2020-01-01T00:00:00Z`)
+
+	c.SyncDef(map[string]string{"ssl-cert-expiring": "0"},
+		c.createIngTLS1("default/echo5", "echo5.example.com", "/", "echo:8080", "tls-echo1"),
+	)
+	c.compareLogging("")
+}
+
 func TestSyncInvalidTLS(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -772,6 +1175,28 @@ func TestSyncAnnFront(t *testing.T) {
   rootredirect: /app`)
 }
 
+func TestSyncAnnFrontResource(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.cache.HostResources = map[string]map[string]string{
+		"default/echo-host": {"app-root": "/app"},
+	}
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/host-config": "echo-host",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  rootredirect: /app`)
+}
+
 func TestSyncAnnFrontsConflict(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -882,14 +1307,88 @@ func TestSyncAnnBack(t *testing.T) {
   balancealgorithm: leastconn` + defaultBackendConfig)
 }
 
-func TestSyncAnnBackSvc(t *testing.T) {
+func TestSyncAnnBackHSTSPerPath(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
 
-	c.createSvc1AutoAnn(map[string]string{
-		"ingress.kubernetes.io/balance-algorithm": "leastconn",
-	})
-	c.Sync(c.createIng1("default/echo", "echo.example.com", "/", "echo:8080"))
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo1", "echo.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/hsts":         "true",
+			"ingress.kubernetes.io/hsts-max-age": "15768000",
+		}),
+		c.createIng1Ann("default/echo2", "echo.example.com", "/app", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/hsts":         "true",
+			"ingress.kubernetes.io/hsts-max-age": "300",
+			"ingress.kubernetes.io/hsts-preload": "true",
+		}),
+		c.createIng1Ann("default/echo3", "echo.example.com", "/api", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/hsts": "false",
+		}),
+	)
+
+	expected := map[string]hatypes.HSTS{
+		"/":    {Enabled: true, MaxAge: 15768000},
+		"/app": {Enabled: true, MaxAge: 300, Preload: true},
+		"/api": {},
+	}
+	host := c.hconfig.FindHost("echo.example.com")
+	for uri, hsts := range expected {
+		path := host.FindPath(uri)
+		if path.HSTS == nil || *path.HSTS != hsts {
+			t.Errorf("hsts of path '%s' differs - expected: %+v - actual: %+v", uri, hsts, path.HSTS)
+		}
+	}
+	c.compareLogging("")
+}
+
+func TestSyncAnnBackSvc(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1AutoAnn(map[string]string{
+		"ingress.kubernetes.io/balance-algorithm": "leastconn",
+	})
+	c.Sync(c.createIng1("default/echo", "echo.example.com", "/", "echo:8080"))
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: leastconn` + defaultBackendConfig)
+}
+
+func TestSyncAnnBackResource(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.cache.BackendResources = map[string]map[string]string{
+		"default/echo-config": {"balance-algorithm": "leastconn", "maxconn-server": "10"},
+	}
+	c.createSvc1Auto()
+	c.Sync(c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", map[string]string{
+		"ingress.kubernetes.io/backend-config": "echo-config",
+	}))
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: leastconn
+  maxconnserver: 10` + defaultBackendConfig)
+}
+
+func TestSyncAnnBackResourceSvc(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.cache.BackendResources = map[string]map[string]string{
+		"default/echo": {"balance-algorithm": "leastconn"},
+	}
+	c.createSvc1Auto()
+	c.Sync(c.createIng1("default/echo", "echo.example.com", "/", "echo:8080"))
 
 	c.compareConfigBack(`
 - id: default_echo_8080
@@ -899,6 +1398,46 @@ func TestSyncAnnBackSvc(t *testing.T) {
   balancealgorithm: leastconn` + defaultBackendConfig)
 }
 
+func TestSyncAnnBackResourceConflict(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.cache.BackendResources = map[string]map[string]string{
+		"default/echo-config": {"balance-algorithm": "leastconn", "maxconn-server": "10"},
+	}
+	c.createSvc1Auto()
+	c.Sync(c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", map[string]string{
+		"ingress.kubernetes.io/backend-config":    "echo-config",
+		"ingress.kubernetes.io/balance-algorithm": "first",
+	}))
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: first
+  maxconnserver: 10` + defaultBackendConfig)
+
+	c.compareLogging(`
+WARN annotation 'balance-algorithm' of ingress 'default/echo' overrides HAProxyBackend 'default/echo-config': 'leastconn' -> 'first'`)
+}
+
+func TestSyncAnnResourceNotFound(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", map[string]string{
+		"ingress.kubernetes.io/backend-config": "echo-config",
+		"ingress.kubernetes.io/host-config":    "echo-host",
+	}))
+
+	c.compareLogging(`
+WARN HAProxyHost 'default/echo-host' referenced on ingress 'default/echo' was not found
+WARN HAProxyBackend 'default/echo-config' referenced on ingress 'default/echo' was not found`)
+}
+
 func TestSyncAnnBackSvcIngConflict(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -921,6 +1460,147 @@ func TestSyncAnnBackSvcIngConflict(t *testing.T) {
 INFO skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo' due to conflict: [balance-algorithm]`)
 }
 
+func TestSyncAnnBackIngsConflictPriority(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo1", "echo1.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "first",
+		}),
+		c.createIng1Ann("default/echo2", "echo2.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "leastconn",
+			"ingress.kubernetes.io/config-priority":   "10",
+		}),
+	)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: leastconn` + defaultBackendConfig)
+
+	c.compareLogging(`
+WARN skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo1' due to conflict with another ingress: [balance-algorithm]`)
+}
+
+func TestSyncAnnPrefixes(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.annPrefix = []string{"haproxy-ingress.github.io", "ingress.kubernetes.io"}
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo1", "echo1.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm":     "first",
+			"haproxy-ingress.github.io/balance-algorithm": "leastconn",
+			"ingress.kubernetes.io/maxconn-server":        "10",
+			"haproxy-ingress.github.io/maxconn-server":    "10",
+		}),
+		c.createIng1Ann("default/echo2", "echo2.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm":   "roundrobin",
+			"haproxy-ingress.github.io/config-priority": "10",
+			"ingress.kubernetes.io/config-priority":     "1",
+		}),
+	)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: roundrobin
+  maxconnserver: 10` + defaultBackendConfig)
+
+	c.compareLogging(`
+WARN annotation 'haproxy-ingress.github.io/config-priority' overrides 'config-priority' of a lower precedence prefix on ingress 'default/echo2': '1' -> '10'
+WARN annotation 'haproxy-ingress.github.io/balance-algorithm' overrides 'balance-algorithm' of a lower precedence prefix on ingress 'default/echo1': 'first' -> 'leastconn'
+WARN skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo1' due to conflict with another ingress: [balance-algorithm]`)
+}
+
+func TestSyncAnnBackIngsConflictError(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.SyncDef(map[string]string{"backend-conflict-strategy": "error"},
+		c.createIng1Ann("default/echo1", "echo1.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "leastconn",
+		}),
+		c.createIng1Ann("default/echo2", "echo2.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "first",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+- hostname: echo2.example.com
+  paths: []
+`)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: leastconn` + defaultBackendConfig)
+
+	c.compareLogging(`
+WARN skipping backend config of ingress 'default/echo2': annotation(s) conflict with backend 'default/echo:8080': [balance-algorithm]`)
+}
+
+func TestSyncAnnBackSvcIngsConflictError(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1AutoAnn(map[string]string{
+		"ingress.kubernetes.io/balance-algorithm": "leastconn",
+	})
+	c.SyncDef(map[string]string{"backend-conflict-strategy": "error"},
+		c.createIng1Ann("default/echo1", "echo1.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "first",
+		}),
+		c.createIng1Ann("default/echo2", "echo2.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/balance-algorithm": "first",
+			"ingress.kubernetes.io/maxconn-server":    "10",
+		}),
+		c.createIng1Ann("default/echo3", "echo3.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/maxconn-server": "20",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+- hostname: echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+- hostname: echo3.example.com
+  paths: []
+`)
+
+	c.compareConfigBack(`
+- id: default_echo_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+  balancealgorithm: leastconn
+  maxconnserver: 10` + defaultBackendConfig)
+
+	c.compareLogging(`
+INFO skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo1' due to conflict: [balance-algorithm]
+INFO skipping backend 'default/echo:8080' annotation(s) from ingress 'default/echo2' due to conflict: [balance-algorithm]
+WARN skipping backend config of ingress 'default/echo3': annotation(s) conflict with backend 'default/echo:8080': [maxconn-server]`)
+}
+
 func TestSyncAnnBacksSvcIng(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -1082,6 +1762,413 @@ WARN skipping http port config of ssl-passthrough: port not found: '9000'
 `)
 }
 
+func TestGlobalConfigResource(t *testing.T) {
+	testCase := []struct {
+		configMap  map[string]string
+		class      map[string]string
+		classErr   error
+		crd        map[string]string
+		crdErr     error
+		expected   map[string]string
+		expLogging string
+	}{
+		// 0
+		{
+			configMap: map[string]string{"max-connections": "1000"},
+			expected:  map[string]string{"max-connections": "1000"},
+		},
+		// 1
+		{
+			configMap: map[string]string{"max-connections": "1000", "ssl-redirect": "false"},
+			crd:       map[string]string{"max-connections": "1000", "timeout-client": "1m"},
+			expected:  map[string]string{"max-connections": "1000", "ssl-redirect": "false", "timeout-client": "1m"},
+		},
+		// 2
+		{
+			configMap: map[string]string{"max-connections": "1000", "ssl-redirect": "false"},
+			crd:       map[string]string{"ssl-redirect": "true", "max-connections": "2000"},
+			expected:  map[string]string{"max-connections": "2000", "ssl-redirect": "true"},
+			expLogging: `
+WARN global config resource overrides 'max-connections' of the global ConfigMap: '1000' -> '2000'
+WARN global config resource overrides 'ssl-redirect' of the global ConfigMap: 'false' -> 'true'`,
+		},
+		// 3
+		{
+			configMap:  map[string]string{"max-connections": "1000"},
+			crdErr:     fmt.Errorf("not found"),
+			expected:   map[string]string{"max-connections": "1000"},
+			expLogging: "WARN using global ConfigMap only due to an error reading global config resource: not found",
+		},
+		// 4
+		{
+			configMap: map[string]string{"max-connections": "1000", "ssl-redirect": "false"},
+			class:     map[string]string{"ssl-redirect": "true", "config-global": "tune.ssl.lifetime 600"},
+			expected:  map[string]string{"max-connections": "1000", "ssl-redirect": "true", "config-global": "tune.ssl.lifetime 600"},
+		},
+		// 5
+		{
+			configMap: map[string]string{"max-connections": "1000"},
+			class:     map[string]string{"max-connections": "2000", "timeout-client": "1m"},
+			crd:       map[string]string{"timeout-client": "2m"},
+			expected:  map[string]string{"max-connections": "2000", "timeout-client": "2m"},
+			expLogging: `
+WARN global config resource overrides 'timeout-client' of the global ConfigMap: '1m' -> '2m'`,
+		},
+		// 6
+		{
+			configMap:  map[string]string{"max-connections": "1000"},
+			class:      map[string]string{"max-connections": "2000"},
+			classErr:   fmt.Errorf("configmaps \"internal\" not found"),
+			expected:   map[string]string{"max-connections": "1000"},
+			expLogging: `WARN ignoring IngressClass parameters due to an error reading them: configmaps "internal" not found`,
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.ClassConfig = test.class
+		c.cache.ClassConfigErr = test.classErr
+		c.cache.GlobalConfig = test.crd
+		c.cache.GlobalConfigErr = test.crdErr
+		conv := &converter{
+			logger: c.logger,
+			cache:  c.cache,
+		}
+		config := conv.mergeGlobalConfig(test.configMap)
+		if !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("global config on %d differs - expected: %v - actual: %v", i, test.expected, config)
+		}
+		c.compareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestSyncWorkers(t *testing.T) {
+	syncWorkers := func(workers int) (backends string, logging []string) {
+		c := setup(t)
+		var ings []*extensions.Ingress
+		for i := 1; i <= 20; i++ {
+			c.createSvc1(fmt.Sprintf("default/echo%d", i), "8080", fmt.Sprintf("172.17.1.%d", i))
+			ings = append(ings, c.createIng1Ann(
+				fmt.Sprintf("default/app%d", i),
+				fmt.Sprintf("app%d.example.com", i),
+				"/",
+				fmt.Sprintf("echo%d:8080", i),
+				map[string]string{
+					"ingress.kubernetes.io/balance-algorithm": "leastconn",
+					"ingress.kubernetes.io/strip-path-prefix": fmt.Sprintf("/a b%d", i),
+				}))
+		}
+		conv := NewIngressConverter(
+			&ingtypes.ConverterOptions{
+				Cache:            c.cache,
+				Logger:           c.logger,
+				DefaultBackend:   "system/default",
+				AnnotationPrefix: c.annPrefix,
+				Workers:          workers,
+			},
+			c.hconfig,
+			map[string]string{},
+		)
+		conv.Sync(ings)
+		backends = _yamlMarshal(convertBackend(c.hconfig.Backends()...))
+		logging = c.logger.Logging
+		c.logger.Logging = []string{}
+		c.teardown()
+		return backends, logging
+	}
+	expBackends, expLogging := syncWorkers(1)
+	if len(expLogging) != 20 {
+		t.Errorf("expected 20 warnings, found %d: %v", len(expLogging), expLogging)
+	}
+	for _, workers := range []int{2, 8} {
+		backends, logging := syncWorkers(workers)
+		if backends != expBackends {
+			t.Errorf("backends differ using %d workers: %s", workers, diff.Diff(expBackends, backends))
+		}
+		if !reflect.DeepEqual(logging, expLogging) {
+			t.Errorf("logging differs using %d workers - expected: %v - actual: %v", workers, expLogging, logging)
+		}
+	}
+}
+
+func TestSyncTracker(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+	var ings []*extensions.Ingress
+	for i := 1; i <= 3; i++ {
+		c.createSvc1(fmt.Sprintf("default/echo%d", i), "8080", fmt.Sprintf("172.17.1.%d", i))
+		ings = append(ings, c.createIng1Ann(
+			fmt.Sprintf("default/app%d", i),
+			fmt.Sprintf("app%d.example.com", i),
+			"/",
+			fmt.Sprintf("echo%d:8080", i),
+			map[string]string{
+				"ingress.kubernetes.io/strip-path-prefix": fmt.Sprintf("/a b%d", i),
+			}))
+	}
+	tracker := ingtypes.NewTracker()
+	sync := func(tracker *ingtypes.Tracker) string {
+		c.hconfig = haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{}).Config()
+		NewIngressConverter(
+			&ingtypes.ConverterOptions{
+				Cache:            c.cache,
+				Logger:           c.logger,
+				DefaultBackend:   "system/default",
+				AnnotationPrefix: c.annPrefix,
+				Tracker:          tracker,
+			},
+			c.hconfig,
+			map[string]string{},
+		).Sync(ings)
+		return _yamlMarshal(convertBackend(c.hconfig.Backends()...))
+	}
+
+	sync(tracker)
+	c.compareLogging(`
+WARN ignoring invalid strip-path-prefix '/a b1' on service 'default/echo1'
+WARN ignoring invalid strip-path-prefix '/a b2' on service 'default/echo2'
+WARN ignoring invalid strip-path-prefix '/a b3' on service 'default/echo3'`)
+
+	c.cache.EpList["default/echo2"].Subsets[0].Addresses = append(
+		c.cache.EpList["default/echo2"].Subsets[0].Addresses, api.EndpointAddress{IP: "172.17.1.102", TargetRef: &api.ObjectReference{}})
+	tracker.TrackService("default/echo2")
+	backends := sync(tracker)
+	c.compareLogging(`
+INFO-V(2) reusing 3 of 4 backends from the last sync
+WARN ignoring invalid strip-path-prefix '/a b2' on service 'default/echo2'`)
+
+	expBackends := sync(nil)
+	c.logger.Logging = []string{}
+	if backends != expBackends {
+		t.Errorf("backends differ from a full sync: %s", diff.Diff(expBackends, backends))
+	}
+
+	tracker.TrackIngress("default/app3")
+	sync(tracker)
+	c.compareLogging(`
+INFO-V(2) reusing 3 of 4 backends from the last sync
+WARN ignoring invalid strip-path-prefix '/a b3' on service 'default/echo3'`)
+
+	tracker.TrackAll()
+	sync(tracker)
+	c.compareLogging(`
+WARN ignoring invalid strip-path-prefix '/a b1' on service 'default/echo1'
+WARN ignoring invalid strip-path-prefix '/a b2' on service 'default/echo2'
+WARN ignoring invalid strip-path-prefix '/a b3' on service 'default/echo3'`)
+}
+
+func TestSyncTrackerDynamicUpdate(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+	socket := serveRuntimeAPI(t, tempdir+"/admin.sock")
+	defer socket.Close()
+	var ings []*extensions.Ingress
+	for i := 1; i <= 2; i++ {
+		c.createSvc1(fmt.Sprintf("default/echo%d", i), "8080", fmt.Sprintf("172.17.1.%d", i))
+		ings = append(ings, c.createIng1(
+			fmt.Sprintf("default/app%d", i),
+			fmt.Sprintf("app%d.example.com", i),
+			"/",
+			fmt.Sprintf("echo%d:8080", i)))
+	}
+	instance := haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{
+		HAProxyConfigFile: tempdir + "/haproxy.cfg",
+		TemplatesDir:      "../../../rootfs/etc/haproxy",
+		MapsDir:           tempdir,
+	})
+	if err := instance.ParseTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	tracker := ingtypes.NewTracker()
+	sync := func() {
+		hconfig := instance.Config()
+		NewIngressConverter(
+			&ingtypes.ConverterOptions{
+				Cache:            c.cache,
+				Logger:           c.logger,
+				DefaultBackend:   "system/default",
+				AnnotationPrefix: c.annPrefix,
+				Tracker:          tracker,
+			},
+			hconfig,
+			map[string]string{"dynamic-scaling": "true"},
+		).Sync(ings)
+		hconfig.Global().StatsSocket = socket.Addr().String()
+		instance.Update()
+	}
+	addEndpoint := func(ip string) {
+		c.cache.EpList["default/echo2"].Subsets[0].Addresses = append(
+			c.cache.EpList["default/echo2"].Subsets[0].Addresses, api.EndpointAddress{IP: ip, TargetRef: &api.ObjectReference{}})
+		tracker.TrackService("default/echo2")
+	}
+
+	sync()
+	c.compareLogging(`
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+
+	// echo1 is reused from the first sync
+	addEndpoint("172.17.1.102")
+	sync()
+	c.compareLogging(`
+INFO-V(2) reusing 2 of 3 backends from the last sync
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot1 addr 172.17.1.102 port 8080
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot1 weight 1
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot1 state ready
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+
+	// echo1 is reused from the second sync, whose endpoints were changed by the dynamic update
+	addEndpoint("172.17.1.103")
+	sync()
+	c.compareLogging(`
+INFO-V(2) reusing 2 of 3 backends from the last sync
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot2 addr 172.17.1.103 port 8080
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot2 weight 1
+INFO-V(2) runtime API command: set server default_echo2_8080/_slot2 state ready
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+}
+
+// serveRuntimeAPI listens on a unix socket and answers every runtime API command with an empty response
+func serveRuntimeAPI(t *testing.T, path string) net.Listener {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("\n"))
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestValidate(t *testing.T) {
+	testCase := []struct {
+		ann         map[string]string
+		expErrors   []string
+		expWarnings []string
+	}{
+		// 0
+		{
+			ann: map[string]string{"ingress.kubernetes.io/balance-algorithm": "leastconn"},
+		},
+		// 1
+		{
+			ann:       map[string]string{"ingress.kubernetes.io/auth-type": "digest"},
+			expErrors: []string{"unsupported authentication type on service 'default/echo': digest"},
+		},
+		// 2
+		{
+			ann:         map[string]string{"ingress.kubernetes.io/strip-path-prefix": "/a b"},
+			expWarnings: []string{"ignoring invalid strip-path-prefix '/a b' on service 'default/echo'"},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.createSvc1Auto()
+		options := &ingtypes.ConverterOptions{
+			Cache:            c.cache,
+			Logger:           c.logger,
+			AnnotationPrefix: c.annPrefix,
+		}
+		errors, warnings := Validate(options, map[string]string{}, c.createIng1Ann("default/app", "app.example.com", "/", "echo:8080", test.ann))
+		if !reflect.DeepEqual(errors, test.expErrors) {
+			t.Errorf("errors on %d differ - expected: %v - actual: %v", i, test.expErrors, errors)
+		}
+		if !reflect.DeepEqual(warnings, test.expWarnings) {
+			t.Errorf("warnings on %d differ - expected: %v - actual: %v", i, test.expWarnings, warnings)
+		}
+		c.teardown()
+	}
+}
+
+func TestNginxCompat(t *testing.T) {
+	testCase := []struct {
+		ann      map[string]string
+		expected string
+	}{
+		// 0
+		{
+			ann: map[string]string{"ingress.kubernetes.io/balance-algorithm": "leastconn"},
+		},
+		// 1
+		{
+			ann: map[string]string{
+				"nginx.ingress.kubernetes.io/ssl-redirect":    "false",
+				"nginx.ingress.kubernetes.io/auth-type":       "basic",
+				"nginx.ingress.kubernetes.io/auth-secret":     "auth",
+				"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+			},
+			expected: `
+nginx.ingress.kubernetes.io/auth-secret: equivalent: same behavior of haproxy-ingress.github.io/auth-secret
+nginx.ingress.kubernetes.io/auth-type: equivalent: same behavior of haproxy-ingress.github.io/auth-type
+nginx.ingress.kubernetes.io/proxy-body-size: equivalent: same behavior of haproxy-ingress.github.io/proxy-body-size
+nginx.ingress.kubernetes.io/ssl-redirect: equivalent: same behavior of haproxy-ingress.github.io/ssl-redirect`,
+		},
+		// 2
+		{
+			ann: map[string]string{
+				"nginx.ingress.kubernetes.io/rewrite-target": "/",
+				"nginx.ingress.kubernetes.io/enable-cors":    "true",
+			},
+			expected: `
+nginx.ingress.kubernetes.io/enable-cors: differs: use cors-enable instead
+nginx.ingress.kubernetes.io/rewrite-target: differs: nginx-ingress replaces the whole path with the target, haproxy-ingress only replaces the path prefix of the ingress, eg /app/login is rewritten to /login instead of /`,
+		},
+		// 3
+		{
+			ann: map[string]string{
+				"nginx.ingress.kubernetes.io/rewrite-target":   "/$2",
+				"nginx.ingress.kubernetes.io/use-regex":        "true",
+				"nginx.ingress.kubernetes.io/auth-type":        "digest",
+				"nginx.ingress.kubernetes.io/mirror-uri":       "/mirror",
+				"nginx.ingress.kubernetes.io/backend-protocol": "HTTPS",
+			},
+			expected: `
+nginx.ingress.kubernetes.io/auth-type: unsupported: only basic authentication is supported, 'digest' would be rejected
+nginx.ingress.kubernetes.io/backend-protocol: differs: use secure-backends: "true" instead
+nginx.ingress.kubernetes.io/mirror-uri: unsupported: there isn't an equivalent annotation
+nginx.ingress.kubernetes.io/rewrite-target: unsupported: capture groups aren't supported, haproxy-ingress replaces the path prefix of the ingress with the target
+nginx.ingress.kubernetes.io/use-regex: unsupported: paths are always prefix matched`,
+		},
+		// 4
+		{
+			ann: map[string]string{
+				"nginx.ingress.kubernetes.io/cors-allow-origin": "https://a.example.com, https://b.example.com",
+				"nginx.ingress.kubernetes.io/load-balance":      "ewma",
+			},
+			expected: `
+nginx.ingress.kubernetes.io/cors-allow-origin: differs: a list of origins isn't supported, the value is sent verbatim in the Access-Control-Allow-Origin header
+nginx.ingress.kubernetes.io/load-balance: unsupported: algorithm 'ewma' is not supported, see balance-algorithm`,
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		ing := c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", test.ann)
+		var actual string
+		for _, finding := range NginxCompat(ing, "haproxy-ingress.github.io") {
+			actual += "\n" + finding.String()
+		}
+		if actual != test.expected {
+			t.Errorf("findings on %d differ - expected: %s - actual: %s", i, test.expected, actual)
+		}
+		c.teardown()
+	}
+}
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  *
  *  BUILDERS
@@ -1089,12 +2176,18 @@ WARN skipping http port config of ssl-passthrough: port not found: '9000'
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 type testConfig struct {
-	t       *testing.T
-	decode  func(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error)
-	hconfig haproxy.Config
-	logger  *types_helper.LoggerMock
-	cache   *ing_helper.CacheMock
-	updater *ing_helper.UpdaterMock
+	t               *testing.T
+	decode          func(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error)
+	hconfig         haproxy.Config
+	logger          *types_helper.LoggerMock
+	cache           *ing_helper.CacheMock
+	updater         *ing_helper.UpdaterMock
+	annPrefix       []string
+	acmeTracker     ingtypes.AcmeTracker
+	certTracker     ingtypes.CertificateTracker
+	secretProviders map[string]ingtypes.SecretProvider
+	secretNs        []string
+	secretNsAnn     bool
 }
 
 func setup(t *testing.T) *testConfig {
@@ -1105,7 +2198,7 @@ func setup(t *testing.T) *testConfig {
 	c := &testConfig{
 		t:       t,
 		decode:  scheme.Codecs.UniversalDeserializer().Decode,
-		hconfig: haproxy.CreateInstance(logger, &ha_helper.BindUtilsMock{}, haproxy.InstanceOptions{}).Config(),
+		hconfig: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
 		cache: &ing_helper.CacheMock{
 			SvcList:     []*api.Service{},
 			EpList:      map[string]*api.Endpoints{},
@@ -1114,7 +2207,8 @@ func setup(t *testing.T) *testConfig {
 				"system/ingress-default": "/tls/tls-default.pem",
 			},
 		},
-		logger: logger,
+		logger:    logger,
+		annPrefix: []string{"ingress.kubernetes.io"},
 	}
 	c.createSvc1("system/default", "8080", "172.17.0.99")
 	return c
@@ -1144,7 +2238,12 @@ func (c *testConfig) SyncDef(config map[string]string, ing ...*extensions.Ingres
 				Filename: "/tls/tls-default.pem",
 				SHA1Hash: "1",
 			},
-			AnnotationPrefix: "ingress.kubernetes.io",
+			AnnotationPrefix:          c.annPrefix,
+			AcmeTracker:               c.acmeTracker,
+			CertTracker:               c.certTracker,
+			SecretProviders:           c.secretProviders,
+			SecretNamespaces:          c.secretNs,
+			SecretNamespaceAnnotation: c.secretNsAnn,
 		},
 		c.hconfig,
 		config,
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"reflect"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+func (c *converter) trackBackendIngress(backend *hatypes.Backend, source ingtypes.Source) {
+	if c.changes == nil {
+		return
+	}
+	ingresses, found := c.backendIngresses[backend]
+	if !found {
+		ingresses = map[string]bool{}
+		c.backendIngresses[backend] = ingresses
+	}
+	ingresses[source.Namespace+"/"+source.Name] = true
+}
+
+// reuseBackends copies the configuration of the backends built by the
+// last sync whose ingress resources, service and endpoints didn't change,
+// and returns the backends whose annotations should be parsed again.
+func (c *converter) reuseBackends(backends []*hatypes.Backend) []*hatypes.Backend {
+	if c.changes == nil || c.changes.Full {
+		return backends
+	}
+	parse := make([]*hatypes.Backend, 0, len(backends))
+	for _, backend := range backends {
+		if !c.reuseBackend(backend) {
+			parse = append(parse, backend)
+		}
+	}
+	if reused := len(backends) - len(parse); reused > 0 {
+		c.logger.InfoV(2, "reusing %d of %d backends from the last sync", reused, len(backends))
+	}
+	return parse
+}
+
+func (c *converter) reuseBackend(backend *hatypes.Backend) bool {
+	ann, found := c.backendAnnotations[backend]
+	if !found {
+		return false
+	}
+	last := c.options.Tracker.LastBackend(backend.ID)
+	if last == nil || c.changes.Services[backend.Namespace+"/"+backend.Name] {
+		return false
+	}
+	if c.ingressesChanged(last.Ingresses) || c.ingressesChanged(c.backendIngresses[backend]) {
+		return false
+	}
+	// oauth looks for the backend of the auth service in the hosts, which are always rebuilt
+	if ann.OAuth != "" || !reflect.DeepEqual(ann, last.Ann) || !reflect.DeepEqual(backend.Paths, last.Backend.Paths) {
+		return false
+	}
+	paths := backend.Paths
+	*backend = *copyBackend(last.Backend)
+	backend.Paths = paths
+	if last.Userlist != nil && c.haproxy.FindUserlist(last.Userlist.Name) == nil {
+		userlist := c.haproxy.AddUserlist(last.Userlist.Name, last.Userlist.Users)
+		userlist.Groups = last.Userlist.Groups
+	}
+	return true
+}
+
+func (c *converter) ingressesChanged(ingresses map[string]bool) bool {
+	for ing := range ingresses {
+		if c.changes.Ingresses[ing] {
+			return true
+		}
+	}
+	return false
+}
+
+// commitBackends saves a copy of the backends of the current sync, used to
+// find the backends which can be reused by the next one. The backends are
+// copied because the dynamic update renames their endpoints and adds the
+// empty slots of the running backend after the sync.
+func (c *converter) commitBackends() {
+	if c.changes == nil {
+		return
+	}
+	backends := make(map[string]*ingtypes.TrackedBackend, len(c.backendAnnotations))
+	for backend, ann := range c.backendAnnotations {
+		tracked := &ingtypes.TrackedBackend{
+			Backend:   copyBackend(backend),
+			Ann:       ann,
+			Ingresses: c.backendIngresses[backend],
+		}
+		if backend.Userlist.Name != "" {
+			tracked.Userlist = c.haproxy.FindUserlist(backend.Userlist.Name)
+		}
+		backends[backend.ID] = tracked
+	}
+	c.options.Tracker.Commit(backends)
+}
+
+func copyBackend(backend *hatypes.Backend) *hatypes.Backend {
+	copied := *backend
+	copied.Endpoints = make([]*hatypes.Endpoint, len(backend.Endpoints))
+	for i, ep := range backend.Endpoints {
+		endpoint := *ep
+		copied.Endpoints[i] = &endpoint
+	}
+	return &copied
+}
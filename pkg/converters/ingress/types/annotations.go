@@ -19,30 +19,52 @@ package types
 // HostAnnotations ...
 type HostAnnotations struct {
 	Source                 Source `json:"-"`
+	Acme                   bool   `json:"acme"`
 	AppRoot                string `json:"app-root"`
 	AuthTLSErrorPage       string `json:"auth-tls-error-page"`
 	AuthTLSVerifyClient    string `json:"auth-tls-verify-client"`
+	AuthTLSVerifyDepth     string `json:"auth-tls-verify-depth"`
 	AuthTLSSecret          string `json:"auth-tls-secret"`
+	CertManagerIssuer      string `json:"cert-manager-issuer"`
+	CertManagerIssuerKind  string `json:"cert-manager-issuer-kind"`
 	ServerAlias            string `json:"server-alias"`
 	ServerAliasRegex       string `json:"server-alias-regex"`
 	SSLPassthrough         bool   `json:"ssl-passthrough"`
 	SSLPassthroughHTTPPort string `json:"ssl-passthrough-http-port"`
+	SSLCiphers             string `json:"ssl-ciphers"`
+	SSLCipherSuites        string `json:"ssl-cipher-suites"`
+	SSLOptionsHost         string `json:"ssl-options-host"`
 	TimeoutClient          string `json:"timeout-client"`
 	TimeoutClientFin       string `json:"timeout-client-fin"`
+	TLSALPN                string `json:"tls-alpn"`
+	TLSSecret              string `json:"tls-secret"`
 }
 
 // BackendAnnotations ...
 type BackendAnnotations struct {
 	Source                Source `json:"-"`
 	Affinity              string `json:"affinity"`
+	AuthGroupsAllowed     string `json:"auth-groups-allowed"`
 	AuthRealm             string `json:"auth-realm"`
 	AuthSecret            string `json:"auth-secret"`
 	AuthTLSCertHeader     bool   `json:"auth-tls-cert-header"`
 	AuthType              string `json:"auth-type"`
+	BackendProtocol       string `json:"backend-protocol"`
 	BalanceAlgorithm      string `json:"balance-algorithm"`
+	BandwidthLimitDown    string `json:"bandwidth-limit-download"`
+	BandwidthLimitKey     string `json:"bandwidth-limit-key"`
+	BandwidthLimitPeriod  string `json:"bandwidth-limit-period"`
+	BandwidthLimitUp      string `json:"bandwidth-limit-upload"`
+	BackendVars           string `json:"backend-vars"`
 	BlueGreenBalance      string `json:"blue-green-balance"`
 	BlueGreenDeploy       string `json:"blue-green-deploy"`
 	BlueGreenMode         string `json:"blue-green-mode"`
+	CacheEnable           bool   `json:"cache-enable"`
+	CacheMaxAge           int    `json:"cache-max-age"`
+	CacheMaxObjectSize    string `json:"cache-max-object-size"`
+	Compression           bool   `json:"compression"`
+	CompressionMinSize    string `json:"compression-min-size"`
+	CompressionTypes      string `json:"compression-types"`
 	ConfigBackend         string `json:"config-backend"`
 	CorsAllowCredentials  bool   `json:"cors-allow-credentials"`
 	CorsAllowHeaders      string `json:"cors-allow-headers"`
@@ -51,29 +73,63 @@ type BackendAnnotations struct {
 	CorsEnable            bool   `json:"cors-enable"`
 	CorsExposeHeaders     string `json:"cors-expose-headers"`
 	CorsMaxAge            int    `json:"cors-max-age"`
+	DisableH2Reuse        bool   `json:"disable-h2-reuse"`
+	DNSSRVRecord          string `json:"dns-srv-record"`
+	DNSSRVSlots           int    `json:"dns-srv-slots"`
+	DrainSupportTimeout   string `json:"drain-support-timeout"`
+	ExcludePathsSecurity  string `json:"exclude-paths-from-security"`
+	FailoverCluster       string `json:"failover-cluster"`
+	FailoverClusterWeight int    `json:"failover-cluster-weight"`
+	ForwardedHeaders      string `json:"forwarded-headers"`
+	Forwardfor            string `json:"forwardfor"`
+	GlobalRateLimit       int    `json:"global-rate-limit"`
+	GlobalRateLimitPeriod string `json:"global-rate-limit-period"`
+	HashBalanceFactor     int    `json:"hash-balance-factor"`
+	HashType              string `json:"hash-type"`
 	HSTS                  bool   `json:"hsts"`
+	HTTPBufferRequest     bool   `json:"http-buffer-request"`
+	HTTPConnectionMode    string `json:"http-connection-mode"`
+	HTTPKeepAliveTimeout  string `json:"http-keep-alive-timeout"`
+	HTTPReuse             string `json:"http-reuse"`
 	HSTSIncludeSubdomains bool   `json:"hsts-include-subdomains"`
 	HSTSMaxAge            int    `json:"hsts-max-age"`
 	HSTSPreload           bool   `json:"hsts-preload"`
 	LimitConnections      int    `json:"limit-connections"`
 	LimitRPS              int    `json:"limit-rps"`
 	LimitWhitelist        string `json:"limit-whitelist"`
+	LogCaptureReqHeaders  string `json:"log-capture-request-headers"`
+	LogCaptureResHeaders  string `json:"log-capture-response-headers"`
+	LogFields             string `json:"log-fields"`
+	LuaRequestAction      string `json:"lua-request-action"`
+	LuaResponseAction     string `json:"lua-response-action"`
 	MaxconnServer         int    `json:"maxconn-server"`
 	MaxQueueServer        int    `json:"maxqueue-server"`
 	OAuth                 string `json:"oauth"`
 	OAuthHeaders          string `json:"oauth-headers"`
+	OAuthService          string `json:"oauth-service"`
+	OAuthSkipPaths        string `json:"oauth-skip-paths"`
 	OAuthURIPrefix        string `json:"oauth-uri-prefix"`
+	PreferLastServer      bool   `json:"prefer-last-server"`
 	ProxyBodySize         string `json:"proxy-body-size"`
 	ProxyProtocol         string `json:"proxy-protocol"`
+	Retries               int    `json:"retries"`
+	RetryOn               string `json:"retry-on"`
 	RewriteTarget         string `json:"rewrite-target"`
-	SlotsIncrement        int    `json:"slots-increment"`
 	SecureBackends        bool   `json:"secure-backends"`
 	SecureCrtSecret       string `json:"secure-crt-secret"`
+	SecureALPN            string `json:"secure-alpn"`
+	SecureSPIFFE          bool   `json:"secure-spiffe"`
 	SecureVerifyCASecret  string `json:"secure-verify-ca-secret"`
+	ServerNaming          string `json:"server-naming"`
 	SessionCookieDynamic  bool   `json:"session-cookie-dynamic"`
 	SessionCookieName     string `json:"session-cookie-name"`
 	SessionCookieStrategy string `json:"session-cookie-strategy"`
+	SlotsIncrement        int    `json:"slots-increment"`
+	SlotsMin              int    `json:"slots-min"`
+	SPOEFilter            string `json:"spoe-filter"`
+	SSE                   bool   `json:"sse"`
 	SSLRedirect           bool   `json:"ssl-redirect"`
+	StripPathPrefix       string `json:"strip-path-prefix"`
 	TimeoutConnect        string `json:"timeout-connect"`
 	TimeoutHTTPRequest    string `json:"timeout-http-request"`
 	TimeoutKeepAlive      string `json:"timeout-keep-alive"`
@@ -85,6 +141,8 @@ type BackendAnnotations struct {
 	UseResolver           string `json:"use-resolver"`
 	WAF                   string `json:"waf"`
 	WhitelistSourceRange  string `json:"whitelist-source-range"`
+	ZoneLocalRouting      string `json:"zone-local-routing"`
+	ZoneLocalWeight       int    `json:"zone-local-weight"`
 }
 
 // Source ...
@@ -18,8 +18,13 @@ package types
 
 // ConfigDefaults ...
 type ConfigDefaults struct {
+	BackendProtocol       string `json:"backend-protocol"`
 	BalanceAlgorithm      string `json:"balance-algorithm"`
+	CertManagerIssuer     string `json:"cert-manager-issuer"`
+	CertManagerIssuerKind string `json:"cert-manager-issuer-kind"`
 	CookieKey             string `json:"cookie-key"`
+	ForwardedHeaders      string `json:"forwarded-headers"`
+	Forwardfor            string `json:"forwardfor"`
 	HSTS                  bool   `json:"hsts"`
 	HSTSIncludeSubdomains bool   `json:"hsts-include-subdomains"`
 	HSTSMaxAge            string `json:"hsts-max-age"`
@@ -40,7 +45,12 @@ type ConfigDefaults struct {
 
 // ConfigGlobals ...
 type ConfigGlobals struct {
+	AcmeEmails                   string `json:"acme-emails"`
+	AcmeEndpoint                 string `json:"acme-endpoint"`
+	AcmeExpiring                 int    `json:"acme-expiring"`
+	AcmeTermsAgreed              bool   `json:"acme-terms-agreed"`
 	BackendCheckInterval         string `json:"backend-check-interval"`
+	BackendConflictStrategy      string `json:"backend-conflict-strategy"`
 	BackendServerSlotsIncrement  int    `json:"backend-server-slots-increment"`
 	BindIPAddrHealthz            string `json:"bind-ip-addr-healthz"`
 	BindIPAddrHTTP               string `json:"bind-ip-addr-http"`
@@ -57,8 +67,8 @@ type ConfigGlobals struct {
 	DNSTimeoutRetry              string `json:"dns-timeout-retry"`
 	DrainSupport                 bool   `json:"drain-support"`
 	DrainSupportRedispatch       bool   `json:"drain-support-redispatch"`
+	DrainTerminatingPeriod       string `json:"drain-terminating-period"`
 	DynamicScaling               bool   `json:"dynamic-scaling"`
-	Forwardfor                   string `json:"forwardfor"`
 	HealthzPort                  int    `json:"healthz-port"`
 	HTTPLogFormat                string `json:"http-log-format"`
 	HTTPPort                     int    `json:"http-port"`
@@ -66,6 +76,8 @@ type ConfigGlobals struct {
 	HTTPSPort                    int    `json:"https-port"`
 	HTTPStoHTTPPort              int    `json:"https-to-http-port"`
 	LoadServerState              bool   `json:"load-server-state"`
+	LocalZone                    string `json:"local-zone"`
+	LuaScripts                   string `json:"lua-scripts"`
 	MaxConnections               int    `json:"max-connections"`
 	ModsecurityEndpoints         string `json:"modsecurity-endpoints"`
 	ModsecurityTimeoutHello      string `json:"modsecurity-timeout-hello"`
@@ -75,23 +87,42 @@ type ConfigGlobals struct {
 	NbprocSSL                    int    `json:"nbproc-ssl"`
 	Nbthread                     int    `json:"nbthread"`
 	NoTLSRedirectLocations       string `json:"no-tls-redirect-locations"`
+	PeersPort                    int    `json:"peers-port"`
+	PeersService                 string `json:"peers-service"`
+	ProxyProtocolFrontends       string `json:"proxy-protocol-frontends"`
+	ProxyProtocolTrustedSources  string `json:"proxy-protocol-trusted-sources"`
+	QUICAltSvcMaxAge             int    `json:"quic-alt-svc-max-age"`
+	RuntimeAPIPort               int    `json:"runtime-api-port"`
+	RuntimeAPIWhitelist          string `json:"runtime-api-whitelist"`
+	SPOEAgents                   string `json:"spoe-agents"`
+	SSLCertExpiring              int    `json:"ssl-cert-expiring"`
+	SSLCertRuntimeUpdate         bool   `json:"ssl-cert-runtime-update"`
 	SSLCiphers                   string `json:"ssl-ciphers"`
 	SSLDHDefaultMaxSize          int    `json:"ssl-dh-default-max-size"`
 	SSLDHParam                   string `json:"ssl-dh-param"`
 	SSLEngine                    string `json:"ssl-engine"`
 	SSLHeadersPrefix             string `json:"ssl-headers-prefix"`
 	SSLModeAsync                 bool   `json:"ssl-mode-async"`
+	SSLOCSPUpdate                bool   `json:"ssl-ocsp-update"`
 	SSLOptions                   string `json:"ssl-options"`
+	StatsAdmin                   bool   `json:"stats-admin"`
 	StatsAuth                    string `json:"stats-auth"`
+	StatsAuthSecret              string `json:"stats-auth-secret"`
 	StatsPort                    int    `json:"stats-port"`
 	StatsProxyProtocol           bool   `json:"stats-proxy-protocol"`
 	StatsSSLCert                 string `json:"stats-ssl-cert"`
+	StatsURI                     string `json:"stats-uri"`
 	StrictHost                   bool   `json:"strict-host"`
 	SyslogEndpoint               string `json:"syslog-endpoint"`
 	SyslogFormat                 string `json:"syslog-format"`
 	SyslogTag                    string `json:"syslog-tag"`
 	TCPLogFormat                 string `json:"tcp-log-format"`
 	TimeoutStop                  string `json:"timeout-stop"`
+	TLSALPN                      string `json:"tls-alpn"`
+	TrustedProxies               string `json:"trusted-proxies"`
+	UseForwardedHeaders          bool   `json:"use-forwarded-headers"`
+	UseHTTP2                     bool   `json:"use-http2"`
+	UseQUIC                      bool   `json:"use-quic"`
 	UseProxyProtocol             bool   `json:"use-proxy-protocol"`
 }
 
@@ -23,11 +23,40 @@ import (
 // Cache ...
 type Cache interface {
 	GetService(serviceName string) (*api.Service, error)
+	GetPublishService() (*api.Service, error)
 	GetEndpoints(service *api.Service) (*api.Endpoints, error)
+	GetRemoteEndpoints(serviceName string) (*api.Endpoints, error)
 	GetTerminatingPods(service *api.Service) ([]*api.Pod, error)
 	GetPod(podName string) (*api.Pod, error)
+	GetNodeZone(nodeName string) (string, error)
+	GetNamespace(name string) (*api.Namespace, error)
 	GetTLSSecretPath(secretName string) (File, error)
-	GetCASecretPath(secretName string) (File, error)
+	GetCASecretPath(secretName string) (ca, crl File, err error)
 	GetDHSecretPath(secretName string) (File, error)
 	GetSecretContent(secretName, keyName string) ([]byte, error)
+	GetConfigMapContent(configMapName, keyName string) ([]byte, error)
+	GetLuaScriptPaths(configMapName string) ([]File, error)
+	GetGlobalConfig() (map[string]string, error)
+	GetIngressClassConfig() (map[string]string, error)
+	GetBackendResource(resourceName string) map[string]string
+	GetHostResource(resourceName string) map[string]string
+}
+
+// AcmeTracker receives the TLS secrets and the domains they should have.
+// annotated is true if the acme annotation is true on the ingress.
+type AcmeTracker interface {
+	TrackAcme(secretName, domain string, annotated bool)
+}
+
+// CertificateTracker receives the TLS secrets whose certificate should be
+// issued by cert-manager, the domains and the issuer of the certificate
+type CertificateTracker interface {
+	TrackCertificate(secretName, domain, issuer, issuerKind string)
+}
+
+// SecretProvider issues or reads TLS certificates from a source other than
+// Kubernetes secrets. Secret names in the form `<provider>:<reference>`, eg
+// `vault:<role>`, are read from the provider registered with that name.
+type SecretProvider interface {
+	GetTLSCertificate(reference, domain string) (File, error)
 }
@@ -17,6 +17,9 @@ limitations under the License.
 package types
 
 import (
+	"time"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
 )
 
@@ -24,13 +27,29 @@ import (
 type File struct {
 	Filename string
 	SHA1Hash string
+	NotAfter time.Time
 }
 
 // ConverterOptions ...
 type ConverterOptions struct {
-	Logger           types.Logger
-	Cache            Cache
-	DefaultBackend   string
-	DefaultSSLFile   File
-	AnnotationPrefix string
+	Logger                    types.Logger
+	Cache                     Cache
+	DefaultBackend            string
+	DefaultSSLFile            File
+	AnnotationPrefix          []string
+	OAuthNamespaces           []string
+	SecretNamespaces          []string
+	SecretNamespaceAnnotation bool
+	Workers                   int
+	Tracker                   *Tracker
+	AcmeSocket                string
+	AcmeTracker               AcmeTracker
+	CertTracker               CertificateTracker
+	SecretProviders           map[string]SecretProvider
+	SPIFFESVIDFile            File
+	SPIFFEBundleFile          File
+	HAProxyVersion            hatypes.Version
+	LocalNodeName             string
+	LocalPodName              string
+	LogSocket                 string
 }
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"sync"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+// Tracker collects the objects changed since the last sync, and the
+// backends built by the last sync, so backends whose ingress, service
+// and endpoints didn't change can be reused instead of being parsed again.
+type Tracker struct {
+	mutex     sync.Mutex
+	changes   *Changes
+	last      *Changes
+	backends  map[string]*TrackedBackend
+	globalCfg map[string]string
+}
+
+// Changes ...
+type Changes struct {
+	Full      bool
+	Ingresses map[string]bool
+	Services  map[string]bool
+}
+
+// TrackedBackend is a backend built by a previous sync, the merged
+// annotations and the ingress resources which configured it
+type TrackedBackend struct {
+	Backend   *hatypes.Backend
+	Ann       *BackendAnnotations
+	Ingresses map[string]bool
+	Userlist  *hatypes.Userlist
+}
+
+// NewTracker ...
+func NewTracker() *Tracker {
+	return &Tracker{
+		changes: newChanges(true),
+	}
+}
+
+func newChanges(full bool) *Changes {
+	return &Changes{
+		Full:      full,
+		Ingresses: map[string]bool{},
+		Services:  map[string]bool{},
+	}
+}
+
+// TrackIngress marks an ingress resource, in the namespace/name format, as changed
+func (t *Tracker) TrackIngress(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.changes.Ingresses[name] = true
+}
+
+// TrackService marks a service or its endpoints, in the namespace/name format, as changed
+func (t *Tracker) TrackService(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.changes.Services[name] = true
+}
+
+// TrackAll asks for a full sync, used when the changed object
+// cannot be related with specific backends
+func (t *Tracker) TrackAll() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.changes.Full = true
+}
+
+// Changes returns the objects changed since the last call and starts
+// a new tracking. A full sync is returned if the global config changed.
+func (t *Tracker) Changes(globalCfg map[string]string) *Changes {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	changes := t.changes
+	t.changes = newChanges(false)
+	if !equalMaps(t.globalCfg, globalCfg) {
+		changes.Full = true
+	}
+	t.globalCfg = globalCfg
+	t.last = changes
+	return changes
+}
+
+// LastChanges returns the changes used by the last sync, or nil if a sync didn't run yet
+func (t *Tracker) LastChanges() *Changes {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.last
+}
+
+// LastBackend returns a backend built by the last sync, or nil if not found
+func (t *Tracker) LastBackend(id string) *TrackedBackend {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.backends[id]
+}
+
+// Commit saves the backends built by the current sync
+func (t *Tracker) Commit(backends map[string]*TrackedBackend) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.backends = backends
+}
+
+func equalMaps(m1, m2 map[string]string) bool {
+	if len(m1) != len(m2) {
+		return false
+	}
+	for key, value := range m1 {
+		if value2, found := m2[key]; !found || value != value2 {
+			return false
+		}
+	}
+	return true
+}
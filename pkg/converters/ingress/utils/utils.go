@@ -22,6 +22,8 @@ import (
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 )
 
 // FullQualifiedName ...
@@ -30,6 +32,50 @@ func FullQualifiedName(namespace, name string) string {
 	return fmt.Sprintf("%s/%s", namespace, name)
 }
 
+// SecretName returns the full qualified name of a `[<namespace>/]<name>`
+// secret reference declared on a resource of the given namespace. Secrets
+// of another namespace can only be read if their namespace is listed in
+// the SecretNamespaces option, or, if SecretNamespaceAnnotation is true,
+// if their namespace lists the resource's namespace in its
+// `<prefix>/allowed-secret-namespaces` annotation.
+func SecretName(options *types.ConverterOptions, cache types.Cache, namespace, secretRef string) (string, error) {
+	secretNamespace, secretName := namespace, secretRef
+	if pos := strings.Index(secretRef, "/"); pos >= 0 {
+		secretNamespace, secretName = secretRef[:pos], secretRef[pos+1:]
+	}
+	if secretNamespace == "" || secretName == "" || strings.Contains(secretName, "/") {
+		return "", fmt.Errorf("invalid secret name '%s', expected format is [<namespace>/]<name>", secretRef)
+	}
+	fullName := secretNamespace + "/" + secretName
+	if secretNamespace == namespace || namespaceMatch(options.SecretNamespaces, secretNamespace) {
+		return fullName, nil
+	}
+	if options.SecretNamespaceAnnotation {
+		ns, err := cache.GetNamespace(secretNamespace)
+		if err != nil {
+			return "", err
+		}
+		for _, prefix := range options.AnnotationPrefix {
+			if allowed, found := ns.Annotations[prefix+"/allowed-secret-namespaces"]; found {
+				if namespaceMatch(strings.Split(allowed, ","), namespace) {
+					return fullName, nil
+				}
+				break
+			}
+		}
+	}
+	return "", fmt.Errorf("cross namespace access to secret '%s' is not allowed", fullName)
+}
+
+func namespaceMatch(namespaces []string, namespace string) bool {
+	for _, ns := range namespaces {
+		if ns = strings.TrimSpace(ns); ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 // GCD calculates the Greatest Common Divisor between a and b
 func GCD(a, b int) int {
 	for b != 0 {
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"fmt"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+)
+
+// Validate parses an ingress resource in validation mode, using the same
+// annotation parsers of the converter on a scratch configuration. Returns
+// the messages logged with ERROR and WARN levels, respectively.
+func Validate(options *ingtypes.ConverterOptions, globalConfig map[string]string, ing *extensions.Ingress) (errors, warnings []string) {
+	logger := &validationLogger{}
+	validationOptions := *options
+	validationOptions.Logger = logger
+	validationOptions.Cache = &validationCache{Cache: options.Cache}
+	// the default backend is not a property of the ingress resource
+	validationOptions.DefaultBackend = ""
+	config := haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config()
+	NewIngressConverter(&validationOptions, config, globalConfig).Sync([]*extensions.Ingress{ing})
+	return logger.errors, logger.warnings
+}
+
+// validationCache doesn't fail on missing endpoints, services are
+// usually created and scaled independently of their ingress resources
+type validationCache struct {
+	ingtypes.Cache
+}
+
+func (c *validationCache) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
+	if ep, err := c.Cache.GetEndpoints(service); err == nil {
+		return ep, nil
+	}
+	return &api.Endpoints{}, nil
+}
+
+type validationLogger struct {
+	errors   []string
+	warnings []string
+}
+
+func (l *validationLogger) InfoV(v int, msg string, args ...interface{}) {}
+
+func (l *validationLogger) Info(msg string, args ...interface{}) {}
+
+func (l *validationLogger) Warn(msg string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(msg, args...))
+}
+
+func (l *validationLogger) Error(msg string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(msg, args...))
+}
+
+func (l *validationLogger) Fatal(msg string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(msg, args...))
+}
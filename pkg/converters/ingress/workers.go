@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"sync"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/annotations"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// loggerUpdater is implemented by updaters which can be used concurrently,
+// every goroutine should use its own logger
+type loggerUpdater interface {
+	WithLogger(logger types.Logger) annotations.Updater
+}
+
+// syncBackends updates the backends using a pool of workers. Messages are
+// buffered per backend and logged in the order of the backends after all
+// of them are updated, so the output doesn't depend on the scheduling of
+// the workers.
+func (c *converter) syncBackends(backends []*hatypes.Backend) {
+	defer c.commitBackends()
+	backends = c.reuseBackends(backends)
+	updater, ok := c.updater.(loggerUpdater)
+	if !ok || c.options.Workers <= 1 || len(backends) <= 1 {
+		for _, backend := range backends {
+			if ann, found := c.backendAnnotations[backend]; found {
+				c.updater.UpdateBackendConfig(backend, ann)
+			}
+		}
+		return
+	}
+	loggers := make([]*bufferedLogger, len(backends))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.options.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				backend := backends[i]
+				logger := &bufferedLogger{}
+				loggers[i] = logger
+				updater.WithLogger(logger).UpdateBackendConfig(backend, c.backendAnnotations[backend])
+			}
+		}()
+	}
+	for i, backend := range backends {
+		if _, found := c.backendAnnotations[backend]; found {
+			queue <- i
+		}
+	}
+	close(queue)
+	wg.Wait()
+	for _, logger := range loggers {
+		if logger != nil {
+			logger.flush(c.logger)
+		}
+	}
+}
+
+type logEntry struct {
+	level int
+	v     int
+	msg   string
+	args  []interface{}
+}
+
+const (
+	logInfoV = iota
+	logInfo
+	logWarn
+	logError
+	logFatal
+)
+
+// bufferedLogger holds the messages of a single goroutine
+// until they are sent to the converter's logger
+type bufferedLogger struct {
+	entries []logEntry
+}
+
+func (l *bufferedLogger) InfoV(v int, msg string, args ...interface{}) {
+	l.entries = append(l.entries, logEntry{level: logInfoV, v: v, msg: msg, args: args})
+}
+
+func (l *bufferedLogger) Info(msg string, args ...interface{}) {
+	l.entries = append(l.entries, logEntry{level: logInfo, msg: msg, args: args})
+}
+
+func (l *bufferedLogger) Warn(msg string, args ...interface{}) {
+	l.entries = append(l.entries, logEntry{level: logWarn, msg: msg, args: args})
+}
+
+func (l *bufferedLogger) Error(msg string, args ...interface{}) {
+	l.entries = append(l.entries, logEntry{level: logError, msg: msg, args: args})
+}
+
+func (l *bufferedLogger) Fatal(msg string, args ...interface{}) {
+	l.entries = append(l.entries, logEntry{level: logFatal, msg: msg, args: args})
+}
+
+func (l *bufferedLogger) flush(logger types.Logger) {
+	for _, e := range l.entries {
+		switch e.level {
+		case logInfoV:
+			logger.InfoV(e.v, e.msg, e.args...)
+		case logInfo:
+			logger.Info(e.msg, e.args...)
+		case logWarn:
+			logger.Warn(e.msg, e.args...)
+		case logError:
+			logger.Error(e.msg, e.args...)
+		case logFatal:
+			logger.Fatal(e.msg, e.args...)
+		}
+	}
+	l.entries = nil
+}
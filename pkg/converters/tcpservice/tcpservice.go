@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcpservice
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+var (
+	sniRegex  = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+	timeRegex = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+	// ports already used by the HTTP and HTTPS frontends
+	reservedPorts = map[int]bool{80: true, 443: true}
+)
+
+// Config ...
+type Config interface {
+	Sync(services []*TCPService)
+}
+
+// NewTCPServiceConverter ...
+func NewTCPServiceConverter(options *ingtypes.ConverterOptions, haproxy haproxy.Config) Config {
+	return &converter{
+		haproxy: haproxy,
+		options: options,
+		logger:  options.Logger,
+		cache:   options.Cache,
+	}
+}
+
+type converter struct {
+	haproxy haproxy.Config
+	options *ingtypes.ConverterOptions
+	logger  types.Logger
+	cache   ingtypes.Cache
+}
+
+func (c *converter) Sync(services []*TCPService) {
+	sorted := make([]*TCPService, len(services))
+	copy(sorted, services)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Metadata.Namespace+"/"+sorted[i].Metadata.Name <
+			sorted[j].Metadata.Namespace+"/"+sorted[j].Metadata.Name
+	})
+	for _, svc := range sorted {
+		c.syncTCPService(svc)
+	}
+}
+
+func (c *converter) syncTCPService(svc *TCPService) {
+	source := fmt.Sprintf("tcpservice '%s/%s'", svc.Metadata.Namespace, svc.Metadata.Name)
+	spec := &svc.Spec
+	if spec.Port <= 0 || spec.Port > 65535 {
+		c.logger.Warn("ignoring %s: invalid port '%d'", source, spec.Port)
+		return
+	}
+	if reservedPorts[spec.Port] {
+		c.logger.Warn("ignoring %s: port '%d' is used by the HTTP frontends", source, spec.Port)
+		return
+	}
+	sni := strings.ToLower(spec.SNI)
+	if sni != "" && !sniRegex.MatchString(sni) {
+		c.logger.Warn("ignoring %s: invalid sni '%s'", source, spec.SNI)
+		return
+	}
+	tcpPort := c.haproxy.FindTCPServicePort(spec.Port)
+	if tcpPort != nil {
+		if tcpPort.FindService(sni) != nil {
+			if sni == "" {
+				c.logger.Warn("skipping %s: port '%d' already has a default service", source, spec.Port)
+			} else {
+				c.logger.Warn("skipping %s: sni '%s' of port '%d' was already declared", source, sni, spec.Port)
+			}
+			return
+		}
+		if tcpPort.HasTLS() != (spec.TLS != nil) {
+			c.logger.Warn("skipping %s: all the services of port '%d' should either configure or not configure TLS", source, spec.Port)
+			return
+		}
+		if tcpPort.AcceptProxy != spec.ProxyProtocol {
+			c.logger.Warn("skipping %s: all the services of port '%d' should either configure or not configure proxyProtocol", source, spec.Port)
+			return
+		}
+	}
+	backend, err := c.addBackend(svc.Metadata.Namespace, spec.Service.Name, spec.Service.Port)
+	if err != nil {
+		c.logger.Warn("skipping %s: %v", source, err)
+		return
+	}
+	if tcpPort == nil {
+		tcpPort = c.haproxy.AcquireTCPServicePort(spec.Port)
+		tcpPort.AcceptProxy = spec.ProxyProtocol
+	}
+	service := tcpPort.AddService(sni, backend)
+	service.Source = source
+	if spec.TLS != nil {
+		tlsFile := c.options.DefaultSSLFile
+		if spec.TLS.SecretName != "" {
+			secretName := svc.Metadata.Namespace + "/" + spec.TLS.SecretName
+			if file, err := c.cache.GetTLSSecretPath(secretName); err == nil {
+				tlsFile = file
+			} else {
+				c.logger.Warn("using default certificate on %s due to an error reading secret '%s': %v", source, secretName, err)
+			}
+		}
+		service.TLSFilename = tlsFile.Filename
+		service.TLSHash = tlsFile.SHA1Hash
+	}
+	timeout := &spec.Timeout
+	if c.validTime(source, "client", timeout.Client) {
+		if tcpPort.TimeoutClient == "" {
+			tcpPort.TimeoutClient = timeout.Client
+		} else if tcpPort.TimeoutClient != timeout.Client {
+			c.logger.Warn("ignoring client timeout of %s: port '%d' already uses '%s'", source, spec.Port, tcpPort.TimeoutClient)
+		}
+	}
+	if c.validTime(source, "connect", timeout.Connect) {
+		backend.Timeout.Connect = timeout.Connect
+	}
+	if c.validTime(source, "server", timeout.Server) {
+		backend.Timeout.Server = timeout.Server
+	}
+}
+
+func (c *converter) validTime(source, name, value string) bool {
+	if value == "" {
+		return false
+	}
+	if !timeRegex.MatchString(value) {
+		c.logger.Warn("ignoring invalid %s timeout of %s: %s", name, source, value)
+		return false
+	}
+	return true
+}
+
+func (c *converter) addBackend(namespace, svcName string, port int) (*hatypes.Backend, error) {
+	svc, err := c.cache.GetService(namespace + "/" + svcName)
+	if err != nil {
+		return nil, err
+	}
+	var svcPort *api.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == port {
+			svcPort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("port not found: '%d'", port)
+	}
+	backend := c.haproxy.FindBackend(namespace, svcName, svcPort.TargetPort.String())
+	if backend != nil {
+		if !backend.ModeTCP {
+			return nil, fmt.Errorf("service '%s/%s' is already used as a HTTP backend", namespace, svcName)
+		}
+		return backend, nil
+	}
+	backend = c.haproxy.AcquireBackend(namespace, svcName, svcPort.TargetPort.String())
+	backend.ModeTCP = true
+	endpoints, err := c.cache.GetEndpoints(svc)
+	if err != nil {
+		c.logger.Error("error adding endpoints of service '%s/%s': %v", namespace, svcName, err)
+		return backend, nil
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, epPort := range subset.Ports {
+			if epPort.Protocol != api.ProtocolTCP ||
+				(epPort.Name != svcPort.Name && strconv.Itoa(int(epPort.Port)) != svcPort.TargetPort.String()) {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				targetRef := ""
+				if addr.TargetRef != nil {
+					targetRef = addr.TargetRef.Namespace + "/" + addr.TargetRef.Name
+				}
+				backend.NewEndpoint(addr.IP, int(epPort.Port), targetRef)
+			}
+		}
+	}
+	return backend, nil
+}
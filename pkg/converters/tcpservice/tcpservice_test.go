@@ -0,0 +1,341 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcpservice
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kylelemons/godebug/diff"
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestSyncTCPService(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/pgsql", 5432, 5432, "172.17.0.11,172.17.0.12")
+	c.sync(`
+- metadata: {namespace: default, name: pgsql}
+  spec:
+    port: 5432
+    service: {name: pgsql, port: 5432}
+    timeout: {client: 1h, connect: 5s, server: 1h}
+`)
+
+	c.comparePorts(`
+5432 timeout=1h
+  - default_pgsql_5432 [172.17.0.11:5432 172.17.0.12:5432] connect=5s server=1h`)
+}
+
+func TestSyncTCPServiceSNI(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/app1", 7000, 7000, "172.17.0.11")
+	c.createSvc("default/app2", 7000, 7000, "172.17.0.12")
+	c.createSvc("default/app3", 7000, 7000, "172.17.0.13")
+	c.sync(`
+- metadata: {namespace: default, name: app1}
+  spec:
+    port: 7000
+    sni: App1.local
+    service: {name: app1, port: 7000}
+- metadata: {namespace: default, name: app2}
+  spec:
+    port: 7000
+    sni: "*.app2.local"
+    service: {name: app2, port: 7000}
+- metadata: {namespace: default, name: app3}
+  spec:
+    port: 7000
+    service: {name: app3, port: 7000}
+`)
+
+	c.comparePorts(`
+7000
+  - default_app3_7000 [172.17.0.13:7000]
+  *.app2.local default_app2_7000 [172.17.0.12:7000]
+  app1.local default_app1_7000 [172.17.0.11:7000]`)
+}
+
+func TestSyncTCPServiceTLS(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/app", 8000, 8000, "172.17.0.11")
+	c.cache.SecretTLSPath = map[string]string{"default/app-tls": "/tls/app.pem"}
+	c.sync(`
+- metadata: {namespace: default, name: app1}
+  spec:
+    port: 8443
+    sni: app.local
+    service: {name: app, port: 8000}
+    tls: {secretName: app-tls}
+- metadata: {namespace: default, name: app2}
+  spec:
+    port: 8443
+    service: {name: app, port: 8000}
+    tls: {}
+- metadata: {namespace: default, name: app3}
+  spec:
+    port: 8443
+    sni: other.local
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app4}
+  spec:
+    port: 8443
+    sni: notfound.local
+    service: {name: app, port: 8000}
+    tls: {secretName: notfound}
+`)
+
+	c.comparePorts(`
+8443
+  - default_app_8000 [172.17.0.11:8000] tls=/tls/default.pem
+  app.local default_app_8000 [172.17.0.11:8000] tls=/tls/app.pem
+  notfound.local default_app_8000 [172.17.0.11:8000] tls=/tls/default.pem`)
+
+	c.compareLogging(`
+WARN skipping tcpservice 'default/app3': all the services of port '8443' should either configure or not configure TLS
+WARN using default certificate on tcpservice 'default/app4' due to an error reading secret 'default/notfound': secret not found: 'default/notfound'`)
+}
+
+func TestSyncTCPServiceProxyProtocol(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/app", 8000, 8000, "172.17.0.11")
+	c.sync(`
+- metadata: {namespace: default, name: app1}
+  spec:
+    port: 7000
+    proxyProtocol: true
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app2}
+  spec:
+    port: 7000
+    sni: app.local
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app3}
+  spec:
+    port: 7001
+    service: {name: app, port: 8000}
+`)
+
+	c.comparePorts(`
+7000 proxy
+  - default_app_8000 [172.17.0.11:8000]
+7001
+  - default_app_8000 [172.17.0.11:8000]`)
+
+	c.compareLogging(`
+WARN skipping tcpservice 'default/app2': all the services of port '7000' should either configure or not configure proxyProtocol`)
+}
+
+func TestSyncTCPServiceErrors(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc("default/app", 8000, 8000, "172.17.0.11")
+	c.createSvc("default/web", 8080, 8080, "172.17.0.12")
+	c.hconfig.AcquireBackend("default", "web", "8080")
+	c.sync(`
+- metadata: {namespace: default, name: app1}
+  spec:
+    port: 7000
+    service: {name: app, port: 8000}
+    timeout: {client: 1h, server: 1y}
+- metadata: {namespace: default, name: app2}
+  spec:
+    port: 7000
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app3}
+  spec:
+    port: 7000
+    sni: app.local
+    service: {name: app, port: 8000}
+    timeout: {client: 30m}
+- metadata: {namespace: default, name: app4}
+  spec:
+    port: 443
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app5}
+  spec:
+    port: 7001
+    sni: app_5.local
+    service: {name: app, port: 8000}
+- metadata: {namespace: default, name: app6}
+  spec:
+    port: 7002
+    service: {name: app, port: 9000}
+- metadata: {namespace: default, name: app7}
+  spec:
+    port: 7003
+    service: {name: notfound, port: 8000}
+- metadata: {namespace: default, name: web}
+  spec:
+    port: 7004
+    service: {name: web, port: 8080}
+`)
+
+	c.comparePorts(`
+7000 timeout=1h
+  - default_app_8000 [172.17.0.11:8000]
+  app.local default_app_8000 [172.17.0.11:8000]`)
+
+	c.compareLogging(`
+WARN ignoring invalid server timeout of tcpservice 'default/app1': 1y
+WARN skipping tcpservice 'default/app2': port '7000' already has a default service
+WARN ignoring client timeout of tcpservice 'default/app3': port '7000' already uses '1h'
+WARN ignoring tcpservice 'default/app4': port '443' is used by the HTTP frontends
+WARN ignoring tcpservice 'default/app5': invalid sni 'app_5.local'
+WARN skipping tcpservice 'default/app6': port not found: '9000'
+WARN skipping tcpservice 'default/app7': service not found: 'default/notfound'
+WARN skipping tcpservice 'default/web': service 'default/web' is already used as a HTTP backend`)
+}
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ *
+ *  BUILDERS
+ *
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type testConfig struct {
+	t       *testing.T
+	hconfig haproxy.Config
+	logger  *types_helper.LoggerMock
+	cache   *ing_helper.CacheMock
+}
+
+func setup(t *testing.T) *testConfig {
+	logger := &types_helper.LoggerMock{
+		Logging: []string{},
+		T:       t,
+	}
+	return &testConfig{
+		t:       t,
+		hconfig: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
+		cache: &ing_helper.CacheMock{
+			SvcList: []*api.Service{},
+			EpList:  map[string]*api.Endpoints{},
+		},
+		logger: logger,
+	}
+}
+
+func (c *testConfig) teardown() {
+	c.compareLogging("")
+}
+
+func (c *testConfig) createSvc(name string, port, targetPort int, endpoints string) {
+	sname := strings.Split(name, "/")
+	svc := &api.Service{
+		ObjectMeta: meta.ObjectMeta{Namespace: sname[0], Name: sname[1]},
+		Spec: api.ServiceSpec{
+			Ports: []api.ServicePort{{
+				Port:       int32(port),
+				TargetPort: intstr.FromInt(targetPort),
+			}},
+		},
+	}
+	ep := &api.Endpoints{
+		ObjectMeta: meta.ObjectMeta{Namespace: sname[0], Name: sname[1]},
+		Subsets: []api.EndpointSubset{{
+			Ports: []api.EndpointPort{{Port: int32(targetPort), Protocol: api.ProtocolTCP}},
+		}},
+	}
+	for _, ip := range strings.Split(endpoints, ",") {
+		ep.Subsets[0].Addresses = append(ep.Subsets[0].Addresses, api.EndpointAddress{IP: ip})
+	}
+	c.cache.SvcList = append(c.cache.SvcList, svc)
+	c.cache.EpList[name] = ep
+}
+
+func (c *testConfig) sync(resources string) {
+	var services []*TCPService
+	if err := yaml.Unmarshal([]byte(resources), &services); err != nil {
+		c.t.Fatalf("error parsing resources: %v", err)
+	}
+	NewTCPServiceConverter(&ingtypes.ConverterOptions{
+		Cache:          c.cache,
+		Logger:         c.logger,
+		DefaultSSLFile: ingtypes.File{Filename: "/tls/default.pem", SHA1Hash: "1"},
+	}, c.hconfig).Sync(services)
+}
+
+func (c *testConfig) comparePorts(expected string) {
+	var actual []string
+	for _, tcpPort := range c.hconfig.TCPServicePorts() {
+		line := fmt.Sprintf("%d", tcpPort.Port)
+		if tcpPort.AcceptProxy {
+			line += " proxy"
+		}
+		if tcpPort.TimeoutClient != "" {
+			line += " timeout=" + tcpPort.TimeoutClient
+		}
+		actual = append(actual, line)
+		for _, service := range tcpPort.Services {
+			backend := service.Backend
+			var endpoints []string
+			for _, ep := range backend.Endpoints {
+				endpoints = append(endpoints, ep.Name)
+			}
+			sni := service.SNI
+			if sni == "" {
+				sni = "-"
+			}
+			line := fmt.Sprintf("  %s %s %v", sni, backend.ID, endpoints)
+			if !backend.ModeTCP {
+				line += " http"
+			}
+			if backend.Timeout.Connect != "" {
+				line += " connect=" + backend.Timeout.Connect
+			}
+			if backend.Timeout.Server != "" {
+				line += " server=" + backend.Timeout.Server
+			}
+			if service.TLSFilename != "" {
+				line += " tls=" + service.TLSFilename
+			}
+			actual = append(actual, line)
+		}
+	}
+	c.compareText(strings.Join(actual, "\n"), expected)
+}
+
+func (c *testConfig) compareLogging(expected string) {
+	c.compareText(strings.Join(c.logger.Logging, "\n"), expected)
+	c.logger.Logging = []string{}
+}
+
+func (c *testConfig) compareText(actual, expected string) {
+	txt1 := "\n" + strings.Trim(expected, "\n")
+	txt2 := "\n" + strings.Trim(actual, "\n")
+	if txt1 != txt2 {
+		c.t.Error(diff.Diff(txt1, txt2))
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcpservice
+
+// The types below are the TCPService resource of the haproxy-ingress.github.io
+// group. Json tags follow the resource, so list items read from the apiserver
+// can be unmarshaled verbatim.
+
+// ObjectMeta ...
+type ObjectMeta struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// TCPService ...
+type TCPService struct {
+	Metadata ObjectMeta     `json:"metadata"`
+	Spec     TCPServiceSpec `json:"spec"`
+}
+
+// TCPServiceSpec ...
+type TCPServiceSpec struct {
+	// Port is the TCP port HAProxy listens to
+	Port int `json:"port"`
+	// SNI routes the TLS connections of the port whose SNI extension matches
+	// this hostname. Wildcard hostnames, eg `*.domain.tld`, are supported. An
+	// empty SNI declares the default service of the port.
+	SNI     string     `json:"sni"`
+	Service ServiceRef `json:"service"`
+	// ProxyProtocol configures the port to expect the PROXY protocol header
+	ProxyProtocol bool              `json:"proxyProtocol"`
+	TLS           *TCPServiceTLS    `json:"tls"`
+	Timeout       TCPServiceTimeout `json:"timeout"`
+}
+
+// ServiceRef ...
+type ServiceRef struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// TCPServiceTLS ...
+type TCPServiceTLS struct {
+	SecretName string `json:"secretName"`
+}
+
+// TCPServiceTimeout ...
+type TCPServiceTimeout struct {
+	Client  string `json:"client"`
+	Connect string `json:"connect"`
+	Server  string `json:"server"`
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// certUpdater applies the changes of the content of the certificates through
+// the HAProxy runtime API, using the `set ssl cert` and `commit ssl cert`
+// commands of HAProxy 2.1+. Certificates are identified by their file names,
+// so added or removed certificates, and any other change, require a reload.
+type certUpdater struct {
+	logger types.Logger
+	socket string
+	cmd    func(socket, command string) (string, error)
+	old    *config
+	cur    *config
+}
+
+// update sends the changed certificates and returns true if the new
+// configuration was applied, false if HAProxy should be reloaded.
+func (u *certUpdater) update() bool {
+	if u.old == nil || !u.cur.global.SSL.CertRuntimeUpdate {
+		return false
+	}
+	changed, ok := u.changedCerts()
+	if !ok || len(changed) == 0 {
+		return false
+	}
+	for _, filename := range changed {
+		if err := u.updateCert(filename); err != nil {
+			u.logger.Warn("error updating certificate '%s' via runtime API, a reload is required: %v", filename, err)
+			return false
+		}
+		u.logger.InfoV(2, "certificate '%s' updated via runtime API", filename)
+	}
+	return true
+}
+
+// changedCerts returns the file names whose hash changed. Returns false if
+// the configurations have any other difference.
+func (u *certUpdater) changedCerts() ([]string, bool) {
+	if len(u.old.hosts) != len(u.cur.hosts) {
+		return nil, false
+	}
+	oldHosts := make(map[string]*hatypes.Host, len(u.old.hosts))
+	for _, host := range u.old.hosts {
+		oldHosts[host.Hostname] = host
+	}
+	changed := map[string]bool{}
+	saved := make([]string, len(u.cur.hosts))
+	for i, cur := range u.cur.hosts {
+		saved[i] = cur.TLS.TLSHash
+		if old, found := oldHosts[cur.Hostname]; found && old.TLS.TLSFilename == cur.TLS.TLSFilename {
+			if old.TLS.TLSHash != cur.TLS.TLSHash {
+				changed[cur.TLS.TLSFilename] = true
+			}
+			cur.TLS.TLSHash = old.TLS.TLSHash
+		}
+	}
+	equals := reflect.DeepEqual(u.old, u.cur)
+	for i, cur := range u.cur.hosts {
+		cur.TLS.TLSHash = saved[i]
+	}
+	if !equals {
+		return nil, false
+	}
+	filenames := make([]string, 0, len(changed))
+	for filename := range changed {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames, true
+}
+
+// updateCert starts a transaction with the new content of a certificate
+// and commits it, the transaction is aborted on commit failures
+func (u *certUpdater) updateCert(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	payload := strings.TrimRight(string(content), "\n")
+	// the payload ends with an empty line, the last line break is added by cmd
+	out, err := u.cmd(u.socket, fmt.Sprintf("set ssl cert %s <<\n%s\n", filename, payload))
+	if err == nil {
+		err = dynCommandError(out, "")
+	}
+	if err == nil && !strings.Contains(out, "Transaction") {
+		err = fmt.Errorf("%s", strings.TrimSpace(out))
+	}
+	if err != nil {
+		return err
+	}
+	out, err = u.cmd(u.socket, "commit ssl cert "+filename)
+	if err == nil && !strings.Contains(out, "Success") {
+		err = fmt.Errorf("%s", strings.TrimSpace(out))
+	}
+	if err != nil {
+		u.cmd(u.socket, "abort ssl cert "+filename)
+		return err
+	}
+	return nil
+}
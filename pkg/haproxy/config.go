@@ -18,8 +18,10 @@ package haproxy
 
 import (
 	"fmt"
+	"hash/fnv"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/template"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
@@ -31,6 +33,8 @@ type Config interface {
 	FindHost(hostname string) *hatypes.Host
 	AcquireBackend(namespace, name, port string) *hatypes.Backend
 	FindBackend(namespace, name, port string) *hatypes.Backend
+	AcquireTCPServicePort(port int) *hatypes.TCPServicePort
+	FindTCPServicePort(port int) *hatypes.TCPServicePort
 	ConfigDefaultBackend(defaultBackend *hatypes.Backend)
 	ConfigDefaultX509Cert(filename string)
 	AddUserlist(name string, users []hatypes.User) *hatypes.Userlist
@@ -42,39 +46,43 @@ type Config interface {
 	Global() *hatypes.Global
 	Hosts() []*hatypes.Host
 	Backends() []*hatypes.Backend
+	BackendShards() [][]*hatypes.Backend
 	Userlists() []*hatypes.Userlist
+	TCPServicePorts() []*hatypes.TCPServicePort
 	Equals(other Config) bool
 }
 
 type config struct {
 	fgroup          *hatypes.FrontendGroup
-	bindUtils       hatypes.BindUtils
 	mapsTemplate    *template.Config
 	mapsDir         string
 	global          *hatypes.Global
 	hosts           []*hatypes.Host
 	backends        []*hatypes.Backend
 	userlists       []*hatypes.Userlist
+	tcpServices     []*hatypes.TCPServicePort
 	defaultHost     *hatypes.Host
 	defaultBackend  *hatypes.Backend
 	defaultX509Cert string
+	backendShards   int
 }
 
 type options struct {
-	mapsTemplate *template.Config
-	mapsDir      string
+	mapsTemplate  *template.Config
+	mapsDir       string
+	backendShards int
 }
 
-func createConfig(bindUtils hatypes.BindUtils, options options) *config {
+func createConfig(options options) *config {
 	mapsTemplate := options.mapsTemplate
 	if mapsTemplate == nil {
 		mapsTemplate = template.CreateConfig()
 	}
 	return &config{
-		bindUtils:    bindUtils,
-		global:       &hatypes.Global{},
-		mapsTemplate: mapsTemplate,
-		mapsDir:      options.mapsDir,
+		global:        &hatypes.Global{},
+		mapsTemplate:  mapsTemplate,
+		mapsDir:       options.mapsDir,
+		backendShards: options.backendShards,
 	}
 }
 
@@ -143,6 +151,27 @@ func (c *config) FindBackend(namespace, name, port string) *hatypes.Backend {
 	return nil
 }
 
+func (c *config) AcquireTCPServicePort(port int) *hatypes.TCPServicePort {
+	if tcpPort := c.FindTCPServicePort(port); tcpPort != nil {
+		return tcpPort
+	}
+	tcpPort := &hatypes.TCPServicePort{Port: port}
+	c.tcpServices = append(c.tcpServices, tcpPort)
+	sort.Slice(c.tcpServices, func(i, j int) bool {
+		return c.tcpServices[i].Port < c.tcpServices[j].Port
+	})
+	return tcpPort
+}
+
+func (c *config) FindTCPServicePort(port int) *hatypes.TCPServicePort {
+	for _, tcpPort := range c.tcpServices {
+		if tcpPort.Port == port {
+			return tcpPort
+		}
+	}
+	return nil
+}
+
 func createBackend(namespace, name, port string) *hatypes.Backend {
 	return &hatypes.Backend{
 		ID:        buildID(namespace, name, port),
@@ -210,6 +239,35 @@ func (c *config) BuildFrontendGroup() error {
 		HTTPSRedirMap:     fgroupMaps.AddMap(c.mapsDir + "/_global_https_redir.map"),
 		SSLPassthroughMap: fgroupMaps.AddMap(c.mapsDir + "/_global_sslpassthrough.map"),
 	}
+	fgroup.HTTPLogFormat = c.global.Syslog.HTTPLogFormat
+	var hasLogFields bool
+	for _, backend := range c.backends {
+		if backend.LogFields != "" {
+			hasLogFields = true
+		}
+		// capture slots are declared in the frontends and
+		// shared by the backends, see log-capture annotations
+		if len(backend.LogCapture.RequestHeaders) > fgroup.CaptureRequestSlots {
+			fgroup.CaptureRequestSlots = len(backend.LogCapture.RequestHeaders)
+		}
+		if len(backend.LogCapture.ResponseHeaders) > fgroup.CaptureResponseSlots {
+			fgroup.CaptureResponseSlots = len(backend.LogCapture.ResponseHeaders)
+		}
+	}
+	if hasLogFields {
+		// log-format is a frontend keyword, backends add their
+		// fields to the log line using the txn.log_fields variable
+		if fgroup.HTTPLogFormat == "" {
+			fgroup.HTTPLogFormat = hatypes.DefaultHTTPLogFormat
+		}
+		fgroup.HTTPLogFormat += " %[var(txn.log_fields)]"
+	}
+	if len(frontends) == 1 && len(frontends[0].Binds) == 1 {
+		// QUIC binds are bound to UDP port 443 and cannot route requests
+		// to another frontend or bind, so HTTP/3 is only used if all the
+		// hosts share the same configuration
+		frontends[0].Binds[0].QUIC = c.global.Bind.QUIC
+	}
 	if fgroup.HasTCPProxy() {
 		// More than one HAProxy's frontend or bind, or using ssl-passthrough config,
 		// so need a `mode tcp` frontend with `inspect-delay` and `req.ssl_sni`
@@ -218,16 +276,9 @@ func (c *config) BuildFrontendGroup() error {
 			for _, bind := range frontend.Binds {
 				i++
 				bindName := fmt.Sprintf("_socket%03d", i)
-				if len(bind.Hosts) == 1 {
-					bind.TLS.TLSCert = c.defaultX509Cert
+				bind.TLS.TLSCert = c.defaultX509Cert
+				if len(bind.Hosts) == 1 && sslBindConf(bind.Hosts[0]) == "" {
 					bind.TLS.TLSCertDir = bind.Hosts[0].TLS.TLSFilename
-				} else {
-					x509dir, err := c.createCertsDir(bindName, bind.Hosts)
-					if err != nil {
-						return err
-					}
-					bind.TLS.TLSCert = c.defaultX509Cert
-					bind.TLS.TLSCertDir = x509dir
 				}
 				bind.Name = bindName
 				bind.Socket = fmt.Sprintf("unix@/var/run/%s.sock", bindName)
@@ -238,17 +289,15 @@ func (c *config) BuildFrontendGroup() error {
 		// One single HAProxy's frontend and bind
 		bind := frontends[0].Binds[0]
 		bind.Name = "_public"
-		bind.Socket = ":443"
-		if len(bind.Hosts) == 1 {
-			bind.TLS.TLSCert = c.defaultX509Cert
+		bind.Socket = c.global.Bind.HTTPAddr.Listen(443)
+		if c.global.Bind.HTTPSAcceptProxy {
+			// trusted sources use a tcp-request rule instead of the bind keyword
+			bind.AcceptProxy = len(c.global.Bind.AcceptProxySources) == 0
+			bind.ExpectProxy = !bind.AcceptProxy
+		}
+		bind.TLS.TLSCert = c.defaultX509Cert
+		if len(bind.Hosts) == 1 && sslBindConf(bind.Hosts[0]) == "" {
 			bind.TLS.TLSCertDir = bind.Hosts[0].TLS.TLSFilename
-		} else {
-			x509dir, err := c.createCertsDir(bind.Name, bind.Hosts)
-			if err != nil {
-				return err
-			}
-			frontends[0].Binds[0].TLS.TLSCert = c.defaultX509Cert
-			frontends[0].Binds[0].TLS.TLSCertDir = x509dir
 		}
 	}
 	for _, frontend := range frontends {
@@ -262,9 +311,11 @@ func (c *config) BuildFrontendGroup() error {
 		frontend.TLSNoCrtErrorList = frontend.Maps.AddMap(mapsPrefix + "_no_crt.list")
 		frontend.TLSNoCrtErrorPagesMap = frontend.Maps.AddMap(mapsPrefix + "_no_crt_redir.map")
 		frontend.VarNamespaceMap = frontend.Maps.AddMap(mapsPrefix + "_k8s_ns.map")
+		frontend.HSTSMap = frontend.Maps.AddMap(mapsPrefix + "_hsts.map")
 		for _, bind := range frontend.Binds {
 			bind.Maps = hatypes.CreateMaps()
 			bind.UseServerList = bind.Maps.AddMap(c.mapsDir + "/" + bind.Name + ".list")
+			bind.CrtList = bind.Maps.AddMap(c.mapsDir + "/" + bind.Name + "_crt.list")
 		}
 	}
 	// Some maps use yes/no answers instead of a list with found/missing keys
@@ -277,10 +328,31 @@ func (c *config) BuildFrontendGroup() error {
 		if rootPath == nil {
 			return fmt.Errorf("missing root path on host %s", sslpassHost.Hostname)
 		}
+		var aliasName, aliasRegex string
+		if sslpassHost.Alias.AliasName != "" && c.FindHost(sslpassHost.Alias.AliasName) == nil {
+			aliasName = sslpassHost.Alias.AliasName
+		}
+		aliasRegex = sslpassHost.Alias.AliasRegex
 		fgroup.SSLPassthroughMap.AppendHostname(sslpassHost.Hostname, rootPath.BackendID)
-		fgroup.HTTPSRedirMap.AppendHostname(sslpassHost.Hostname+"/", yesno[sslpassHost.HTTPPassthroughBackend == nil])
+		fgroup.SSLPassthroughMap.AppendAliasName(aliasName, rootPath.BackendID)
+		fgroup.SSLPassthroughMap.AppendAliasRegex(aliasRegex, rootPath.BackendID)
+		redir := yesno[sslpassHost.HTTPPassthroughBackend == nil]
+		fgroup.HTTPSRedirMap.AppendHostname(sslpassHost.Hostname+"/", redir)
+		if aliasName != "" {
+			fgroup.HTTPSRedirMap.AppendAliasName(aliasName+"/", redir)
+		}
+		if aliasRegex != "" {
+			fgroup.HTTPSRedirMap.AppendAliasRegex(aliasRegex+"/", redir)
+		}
 		if sslpassHost.HTTPPassthroughBackend != nil {
-			fgroup.HTTPFrontsMap.AppendHostname(sslpassHost.Hostname+"/", sslpassHost.HTTPPassthroughBackend.ID)
+			back := sslpassHost.HTTPPassthroughBackend.ID
+			fgroup.HTTPFrontsMap.AppendHostname(sslpassHost.Hostname+"/", back)
+			if aliasName != "" {
+				fgroup.HTTPFrontsMap.AppendAliasName(aliasName+"/", back)
+			}
+			if aliasRegex != "" {
+				fgroup.HTTPFrontsMap.AppendAliasRegex(aliasRegex+"/", back)
+			}
 		}
 	}
 	for _, f := range frontends {
@@ -339,10 +411,14 @@ func (c *config) BuildFrontendGroup() error {
 				f.RootRedirMap.AppendHostname(host.Hostname, host.RootRedirect)
 			}
 		}
+		buildHSTSMap(f)
 		for _, bind := range f.Binds {
 			for _, host := range bind.Hosts {
 				bind.UseServerList.AppendHostname(host.Hostname, "")
 			}
+			if len(bind.Hosts) > 1 || bind.TLS.TLSCertDir == "" {
+				c.buildCrtList(bind)
+			}
 		}
 	}
 	if err := writeMaps(fgroup.Maps, c.mapsTemplate); err != nil {
@@ -358,10 +434,49 @@ func (c *config) BuildFrontendGroup() error {
 			}
 		}
 	}
+	if err := c.buildTCPServices(); err != nil {
+		return err
+	}
 	c.fgroup = fgroup
 	return nil
 }
 
+// buildTCPServices creates the SNI map and the crt-list of the TCP service
+// ports. The crt-list is only used if HAProxy terminates the TLS connections,
+// the certificate of the default service, if any, is used as the default one.
+func (c *config) buildTCPServices() error {
+	for _, tcpPort := range c.tcpServices {
+		mapsPrefix := fmt.Sprintf("%s/_tcp_%d", c.mapsDir, tcpPort.Port)
+		tcpPort.Maps = hatypes.CreateMaps()
+		tcpPort.SNIMap = tcpPort.Maps.AddMap(mapsPrefix + "_sni.map")
+		tcpPort.CrtList = tcpPort.Maps.AddMap(mapsPrefix + "_crt.list")
+		tcpPort.TLSCert = c.defaultX509Cert
+		if def := tcpPort.DefaultService(); def != nil && def.TLSFilename != "" {
+			tcpPort.TLSCert = def.TLSFilename
+		}
+		var wildcard []*hatypes.HostsMapEntry
+		for _, service := range tcpPort.Services {
+			if service.SNI == "" {
+				continue
+			}
+			tcpPort.SNIMap.AppendHostname(service.SNI, service.Backend.ID)
+			if service.TLSFilename != "" {
+				entry := &hatypes.HostsMapEntry{Key: service.TLSFilename, Value: service.SNI}
+				if strings.HasPrefix(service.SNI, "*.") {
+					wildcard = append(wildcard, entry)
+				} else {
+					tcpPort.CrtList.Match = append(tcpPort.CrtList.Match, entry)
+				}
+			}
+		}
+		tcpPort.CrtList.Match = append(tcpPort.CrtList.Match, wildcard...)
+		if err := writeMaps(tcpPort.Maps, c.mapsTemplate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func writeMaps(maps *hatypes.HostsMaps, template *template.Config) error {
 	for _, hmap := range maps.Items {
 		if err := template.WriteOutput(hmap.Match, hmap.MatchFile); err != nil {
@@ -376,20 +491,144 @@ func writeMaps(maps *hatypes.HostsMaps, template *template.Config) error {
 	return nil
 }
 
-func (c *config) createCertsDir(bindName string, hosts []*hatypes.Host) (string, error) {
-	certs := make([]string, 0, len(hosts))
-	added := map[string]bool{}
-	for _, host := range hosts {
+// buildCrtList adds the certificates of the hosts of a bind to its crt-list.
+// Every certificate has a SNI filter with the hostnames which should use it,
+// so the certificate of a host doesn't depend on the CN and SAN of the other
+// ones. Certificates of exact hostnames are declared before the wildcard ones.
+// Hosts without a certificate use the default one, which is only declared if
+// a wildcard filter would otherwise take precedence, or if the host has its
+// own ssl settings. Per host ssl settings are added as the sslbindconf of
+// the entry.
+func (c *config) buildCrtList(bind *hatypes.BindConfig) {
+	type crtEntry struct {
+		filename string
+		bindconf string
+		hosts    []string
+	}
+	var exact, wildcard []*crtEntry
+	var wildcardDomains []string
+	add := func(entries []*crtEntry, filename, bindconf, hostname string) []*crtEntry {
+		for _, entry := range entries {
+			if entry.filename == filename && entry.bindconf == bindconf {
+				entry.hosts = append(entry.hosts, hostname)
+				return entries
+			}
+		}
+		return append(entries, &crtEntry{filename: filename, bindconf: bindconf, hosts: []string{hostname}})
+	}
+	var defaultHosts []*hatypes.Host
+	for _, host := range bind.Hosts {
 		filename := host.TLS.TLSFilename
-		if filename != "" && !added[filename] && filename != c.defaultX509Cert {
-			certs = append(certs, host.TLS.TLSFilename)
-			added[filename] = true
+		bindconf := sslBindConf(host)
+		if filename == "" || filename == c.defaultX509Cert {
+			defaultHosts = append(defaultHosts, host)
+		} else if strings.HasPrefix(host.Hostname, "*.") {
+			wildcard = add(wildcard, filename, bindconf, host.Hostname)
+			wildcardDomains = append(wildcardDomains, host.Hostname[1:])
+		} else {
+			exact = add(exact, filename, bindconf, host.Hostname)
 		}
 	}
-	if len(certs) == 0 {
-		return "", nil
+	for _, host := range defaultHosts {
+		hostname := host.Hostname
+		bindconf := sslBindConf(host)
+		if bindconf != "" {
+			if strings.HasPrefix(hostname, "*.") {
+				wildcard = add(wildcard, c.defaultX509Cert, bindconf, hostname)
+			} else {
+				exact = add(exact, c.defaultX509Cert, bindconf, hostname)
+			}
+		} else if pos := strings.Index(hostname, "."); pos > 0 && !strings.HasPrefix(hostname, "*.") {
+			for _, domain := range wildcardDomains {
+				if hostname[pos:] == domain {
+					exact = add(exact, c.defaultX509Cert, "", hostname)
+					break
+				}
+			}
+		}
+	}
+	for _, entries := range [][]*crtEntry{exact, wildcard} {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].filename < entries[j].filename
+		})
+		for _, entry := range entries {
+			value := strings.Join(entry.hosts, " ")
+			if entry.bindconf != "" {
+				value = entry.bindconf + " " + value
+			}
+			bind.CrtList.Match = append(bind.CrtList.Match, &hatypes.HostsMapEntry{
+				Key:   entry.filename,
+				Value: value,
+			})
+		}
 	}
-	return c.bindUtils.CreateX509CertsDir(bindName, certs)
+}
+
+// sslBindConf builds the crt-list's sslbindconf of a host, or an empty
+// string if the host doesn't override the global ssl settings.
+// buildHSTSMap maps the paths of a frontend to their HSTS header if any path
+// overrides the HSTS config of its backend. All the paths are added, so a
+// path doesn't inherit the header of another path which is its prefix.
+func buildHSTSMap(f *hatypes.Frontend) {
+	var override bool
+	for _, host := range f.Hosts {
+		for _, path := range host.Paths {
+			if path.HSTS != nil && *path.HSTS != path.Backend.HSTS {
+				override = true
+			}
+		}
+	}
+	if !override {
+		return
+	}
+	for _, host := range f.Hosts {
+		for _, path := range host.Paths {
+			hsts := path.Backend.HSTS
+			if path.HSTS != nil {
+				hsts = *path.HSTS
+			}
+			f.HSTSMap.AppendHostname(host.Hostname+path.Path, hstsHeader(hsts))
+		}
+	}
+}
+
+// hstsHeader returns the value of the Strict-Transport-Security header,
+// or `-` if HSTS is disabled
+func hstsHeader(hsts hatypes.HSTS) string {
+	if !hsts.Enabled {
+		return "-"
+	}
+	header := fmt.Sprintf("max-age=%d", hsts.MaxAge)
+	if hsts.Subdomains {
+		header += ";includeSubDomains"
+	}
+	if hsts.Preload {
+		header += ";preload"
+	}
+	return header
+}
+
+func sslBindConf(host *hatypes.Host) string {
+	var conf []string
+	if host.TLS.MinVersion != "" {
+		conf = append(conf, "ssl-min-ver "+host.TLS.MinVersion)
+	}
+	if host.TLS.MaxVersion != "" {
+		conf = append(conf, "ssl-max-ver "+host.TLS.MaxVersion)
+	}
+	if host.TLS.Ciphers != "" {
+		conf = append(conf, "ciphers "+host.TLS.Ciphers)
+	}
+	if host.TLS.CipherSuites != "" {
+		conf = append(conf, "ciphersuites "+host.TLS.CipherSuites)
+	}
+	if host.TLS.ALPN != "" {
+		conf = append(conf, "alpn "+host.TLS.ALPN)
+	}
+	if len(conf) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(conf, " ") + "]"
 }
 
 func (c *config) DefaultHost() *hatypes.Host {
@@ -412,10 +651,31 @@ func (c *config) Backends() []*hatypes.Backend {
 	return c.backends
 }
 
+// BackendShards distributes the backends in the configured number of shards
+// based on the hash of the backend ID, so a backend is always rendered in the
+// same shard. Returns nil if backends shouldn't be sharded.
+func (c *config) BackendShards() [][]*hatypes.Backend {
+	if c.backendShards <= 0 {
+		return nil
+	}
+	shards := make([][]*hatypes.Backend, c.backendShards)
+	for _, backend := range c.backends {
+		hash := fnv.New32a()
+		hash.Write([]byte(backend.ID))
+		i := hash.Sum32() % uint32(c.backendShards)
+		shards[i] = append(shards[i], backend)
+	}
+	return shards
+}
+
 func (c *config) Userlists() []*hatypes.Userlist {
 	return c.userlists
 }
 
+func (c *config) TCPServicePorts() []*hatypes.TCPServicePort {
+	return c.tcpServices
+}
+
 func (c *config) Equals(other Config) bool {
 	c2, ok := other.(*config)
 	if !ok {
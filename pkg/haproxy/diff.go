@@ -0,0 +1,228 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxEditDistance limits the memory used by the diff of very different
+// configurations, a bigger distance is reported as a full replacement
+const maxEditDistance = 2000
+
+type diffOp struct {
+	kind byte // ' ' (equal), '-' (deleted) or '+' (inserted)
+	line string
+}
+
+var (
+	passwordRegex  = regexp.MustCompile(`(\b(?:insecure-)?password) +\S+`)
+	statsAuthRegex = regexp.MustCompile(`(\bstats +auth +[^:\s]*:)\S+`)
+	cookieKeyRegex = regexp.MustCompile(`(\bdynamic-cookie-key) +("[^"]*"|\S+)`)
+)
+
+// maskSecrets replaces the passwords of userlists, the password of the
+// stats page and the dynamic cookie key of a configuration or its diff
+func maskSecrets(content string) string {
+	content = passwordRegex.ReplaceAllString(content, "$1 <redacted>")
+	content = statsAuthRegex.ReplaceAllString(content, "${1}<redacted>")
+	return cookieKeyRegex.ReplaceAllString(content, "$1 <redacted>")
+}
+
+// unifiedDiff returns the differences between two files in the unified format,
+// with the number of context lines around each change. An empty string is
+// returned if both contents are equal.
+func unifiedDiff(oldName, newName string, oldContent, newContent []byte, context int) string {
+	if bytes.Equal(oldContent, newContent) {
+		return ""
+	}
+	ops := diffLines(splitLines(oldContent), splitLines(newContent))
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", oldName, newName)
+	// indexes of ops and line numbers of the old and new files, both starting at 0
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// a hunk ends when more than 2*context equal lines are found after a change
+		end := start
+		for equal := 0; end < len(ops) && equal <= 2*context; end++ {
+			if ops[end].kind == ' ' {
+				equal++
+			} else {
+				equal = 0
+			}
+		}
+		for end > start && ops[end-1].kind == ' ' {
+			end--
+		}
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		last := end + context
+		if last > len(ops) {
+			last = len(ops)
+		}
+		oldCount := oldLine[last] - oldLine[first]
+		newCount := newLine[last] - newLine[first]
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldLine[first], oldCount), hunkRange(newLine[first], newCount))
+		for _, op := range ops[first:last] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = end
+	}
+	return out.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		// empty ranges refer to the line before the change
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// diffLines finds the shortest edit script between two lists of lines,
+// using the Myers' algorithm on the lines between the common prefix and suffix
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	// v[k+offset] is the furthest x reached on diagonal k
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] has the diagonals -d-1 to d+1 of v before the round d
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > maxEditDistance {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+func backtrack(a, b []string, trace [][]int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		vk := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vk(k-1) < vk(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vk(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+				y--
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+				x--
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replaceAll(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
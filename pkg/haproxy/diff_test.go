@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(s ...string) []byte {
+		if len(s) == 0 {
+			return nil
+		}
+		return []byte(strings.Join(s, "\n") + "\n")
+	}
+	testCases := []struct {
+		old      []byte
+		new      []byte
+		expected string
+	}{
+		// 0
+		{
+			old:      lines("a", "b", "c"),
+			new:      lines("a", "b", "c"),
+			expected: ``,
+		},
+		// 1
+		{
+			old: lines("a", "b", "c", "d", "e", "f", "g", "h", "i"),
+			new: lines("a", "b", "c", "d", "E", "f", "g", "h", "i"),
+			expected: `
+@@ -2,7 +2,7 @@
+ b
+ c
+ d
+-e
++E
+ f
+ g
+ h`,
+		},
+		// 2
+		{
+			old: lines("a", "b", "c"),
+			new: lines("a", "b", "c", "d"),
+			expected: `
+@@ -1,3 +1,4 @@
+ a
+ b
+ c
++d`,
+		},
+		// 3
+		{
+			old: lines("a", "b", "c"),
+			new: lines("b", "c"),
+			expected: `
+@@ -1,3 +1,2 @@
+-a
+ b
+ c`,
+		},
+		// 4
+		{
+			old: lines(),
+			new: lines("a"),
+			expected: `
+@@ -0,0 +1 @@
++a`,
+		},
+		// 5
+		{
+			old: lines("1", "x", "2", "3", "4", "5", "6", "7", "8", "y", "9"),
+			new: lines("1", "2", "3", "4", "5", "6", "7", "8", "9"),
+			expected: `
+@@ -1,5 +1,4 @@
+ 1
+-x
+ 2
+ 3
+ 4
+@@ -7,5 +6,4 @@
+ 6
+ 7
+ 8
+-y
+ 9`,
+		},
+		// 6
+		{
+			old: lines("1", "x", "2", "3", "4", "5", "y", "6"),
+			new: lines("1", "2", "3", "4", "5", "6"),
+			expected: `
+@@ -1,8 +1,6 @@
+ 1
+-x
+ 2
+ 3
+ 4
+ 5
+-y
+ 6`,
+		},
+		// 7
+		{
+			old: lines("a", "b", "c", "a", "b", "b", "a"),
+			new: lines("c", "b", "a", "b", "a", "c"),
+			expected: `
+@@ -1,7 +1,6 @@
+-a
+-b
+ c
++b
+ a
+ b
+-b
+ a
++c`,
+		},
+	}
+	for i, test := range testCases {
+		expected := test.expected
+		if expected != "" {
+			expected = "--- old\n+++ new" + expected + "\n"
+		}
+		if actual := unifiedDiff("old", "new", test.old, test.new, 3); actual != expected {
+			t.Errorf("diff differs on %d\nexpected:\n%s\nactual:\n%s", i, expected, actual)
+		}
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	testCases := []struct {
+		content  string
+		expected string
+	}{
+		// 0
+		{
+			content:  "    user usr1 insecure-password s3cr3t",
+			expected: "    user usr1 insecure-password <redacted>",
+		},
+		// 1
+		{
+			content:  "+    user usr1 password $1$abc$xyz",
+			expected: "+    user usr1 password <redacted>",
+		},
+		// 2
+		{
+			content:  "-    stats auth admin:s3cr3t",
+			expected: "-    stats auth admin:<redacted>",
+		},
+		// 3
+		{
+			content:  "     dynamic-cookie-key \"s3cr3t\"",
+			expected: "     dynamic-cookie-key <redacted>",
+		},
+		// 4
+		{
+			content:  "    http-request auth realm localhost if !{ http_auth(default_auth) }",
+			expected: "    http-request auth realm localhost if !{ http_auth(default_auth) }",
+		},
+	}
+	for i, test := range testCases {
+		if actual := maskSecrets(test.content); actual != test.expected {
+			t.Errorf("masked content differs on %d - expected: %s - actual: %s", i, test.expected, actual)
+		}
+	}
+}
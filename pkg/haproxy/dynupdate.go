@@ -0,0 +1,436 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+const (
+	dynSlotPrefix = "_slot"
+	dynSlotIP     = "127.0.0.1"
+	dynSlotPort   = 81
+)
+
+// dynUpdater applies the endpoint changes between the running and the new
+// configuration through the HAProxy runtime API. Weight changes, eg from
+// blue/green balance, are always applied. If dynamic scaling is enabled, new
+// endpoints are added and removed endpoints are deleted with the `add server`
+// and `del server` commands of HAProxy 2.5+, or use the empty slots of the
+// backend on older versions. Any other change requires a reload.
+//
+// plan() is called before writing the configuration file and changes the new
+// configuration to reflect the running servers, apply() sends the commands
+// after the file was written, so HAProxy and the file don't diverge if the
+// file cannot be written. rollback() restores the new configuration if the
+// commands failed, so the file can be written again before the reload.
+type dynUpdater struct {
+	logger    types.Logger
+	socket    string
+	cmd       func(socket, command string) (string, error)
+	journal   Journal
+	addServer bool
+	old       *config
+	cur       *config
+	commands  []*dynCommand
+	saved     []*dynBackendUpdate
+}
+
+type dynBackendUpdate struct {
+	backend    *hatypes.Backend
+	endpoints  []*hatypes.Endpoint
+	emptySlots int
+}
+
+type dynCommand struct {
+	command string
+	// add is the `<backend>/<server>` being added, its arguments are
+	// copied from the server line of the written configuration file
+	add    string
+	expect string
+}
+
+// plan builds the runtime API commands and returns true if the new
+// configuration can be applied without a reload. Endpoints of the new
+// configuration are renamed to the names of the running servers, and the
+// empty slots of the running backend are preserved, so the written file
+// reflects the running HAProxy and the next update can be compared.
+func (d *dynUpdater) plan() bool {
+	if d.old == nil {
+		return false
+	}
+	scaling := d.cur.global.DynamicScaling.Enabled
+	if len(d.old.backends) != len(d.cur.backends) {
+		return false
+	}
+	oldBackends := make(map[string]*hatypes.Backend, len(d.old.backends))
+	for _, backend := range d.old.backends {
+		oldBackends[backend.ID] = backend
+	}
+	for _, cur := range d.cur.backends {
+		old, found := oldBackends[cur.ID]
+		if !found || !backendEqualsIgnoringEndpoints(old, cur) {
+			return false
+		}
+	}
+	if !d.configEqualsIgnoringEndpoints(oldBackends) {
+		return false
+	}
+	var updates []*dynBackendUpdate
+	var commands []*dynCommand
+	for _, cur := range d.cur.backends {
+		old := oldBackends[cur.ID]
+		endpoints, cmds, ok := d.planBackend(old, cur, scaling)
+		if !ok {
+			if scaling {
+				d.logger.InfoV(2, "cannot update backend '%s' dynamically, a reload is required", cur.ID)
+			}
+			return false
+		}
+		emptySlots := 0
+		if d.addServer {
+			emptySlots = old.EmptySlots
+		}
+		updates = append(updates, &dynBackendUpdate{backend: cur, endpoints: endpoints, emptySlots: emptySlots})
+		commands = append(commands, cmds...)
+	}
+	d.commands = commands
+	d.saved = make([]*dynBackendUpdate, len(updates))
+	for i, u := range updates {
+		d.saved[i] = &dynBackendUpdate{backend: u.backend, endpoints: u.backend.Endpoints, emptySlots: u.backend.EmptySlots}
+		u.backend.Endpoints = u.endpoints
+		u.backend.EmptySlots = u.emptySlots
+	}
+	return true
+}
+
+// apply sends the planned commands and returns true if all of them succeeded,
+// false if HAProxy should be reloaded. content is the written configuration,
+// used to build the arguments of the added servers.
+func (d *dynUpdater) apply(content map[string][]byte) bool {
+	for _, c := range d.commands {
+		if c.add != "" {
+			args := dynServerArgs(content, c.add)
+			if args == "" {
+				d.logger.Warn("server '%s' not found in the configuration file, a reload is required", c.add)
+				return false
+			}
+			if !d.send(fmt.Sprintf("add server %s %s", c.add, args), c.expect) {
+				return false
+			}
+			// added servers start with their checks disabled
+			fields := strings.Fields(args)
+			for _, check := range []struct{ arg, cmd string }{
+				{"check", "enable health"},
+				{"agent-check", "enable agent"},
+			} {
+				if dynHasArg(fields, check.arg) && !d.send(check.cmd+" "+c.add, "") {
+					return false
+				}
+			}
+			continue
+		}
+		if !d.send(c.command, c.expect) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *dynUpdater) send(command, expect string) bool {
+	out, err := d.cmd(d.socket, command)
+	if err == nil {
+		err = dynCommandError(out, expect)
+	}
+	if err != nil {
+		d.logger.Warn("error sending runtime API command '%s', a reload is required: %v", command, err)
+		return false
+	}
+	d.journal.Append(command)
+	d.logger.InfoV(2, "runtime API command: %s", command)
+	return true
+}
+
+func dynHasArg(fields []string, arg string) bool {
+	for _, field := range fields {
+		if field == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// rollback restores the endpoints of the new configuration changed by plan()
+func (d *dynUpdater) rollback() {
+	for _, u := range d.saved {
+		u.backend.Endpoints = u.endpoints
+		u.backend.EmptySlots = u.emptySlots
+	}
+}
+
+// backendEqualsIgnoringEndpoints compares two backends, except their endpoints and empty slots
+func backendEqualsIgnoringEndpoints(b1, b2 *hatypes.Backend) bool {
+	c1, c2 := *b1, *b2
+	c1.Endpoints, c2.Endpoints = nil, nil
+	c1.EmptySlots, c2.EmptySlots = 0, 0
+	return reflect.DeepEqual(&c1, &c2)
+}
+
+// configEqualsIgnoringEndpoints compares the whole configuration using the
+// endpoints of the old backends in the new ones. Hosts reference backends,
+// so the new endpoints are temporarily replaced instead of copying hosts.
+func (d *dynUpdater) configEqualsIgnoringEndpoints(oldBackends map[string]*hatypes.Backend) bool {
+	type backendState struct {
+		endpoints  []*hatypes.Endpoint
+		emptySlots int
+	}
+	saved := make([]backendState, len(d.cur.backends))
+	for i, cur := range d.cur.backends {
+		saved[i] = backendState{endpoints: cur.Endpoints, emptySlots: cur.EmptySlots}
+		old := oldBackends[cur.ID]
+		cur.Endpoints = old.Endpoints
+		cur.EmptySlots = old.EmptySlots
+	}
+	equals := reflect.DeepEqual(d.old, d.cur)
+	for i, cur := range d.cur.backends {
+		cur.Endpoints = saved[i].endpoints
+		cur.EmptySlots = saved[i].emptySlots
+	}
+	return equals
+}
+
+// planBackend builds the runtime API commands that change the servers of the
+// running backend to the new endpoints, and the resulting endpoints. Returns
+// false if the backend cannot be updated without a reload. Only weights are
+// changed if scaling is false.
+func (d *dynUpdater) planBackend(old, cur *hatypes.Backend, scaling bool) ([]*hatypes.Endpoint, []*dynCommand, bool) {
+	if cur.Resolver != "" {
+		// servers are resolved by HAProxy and don't have empty slots
+		return cur.Endpoints, nil, reflect.DeepEqual(old.Endpoints, cur.Endpoints)
+	}
+	servers := make([]*hatypes.Endpoint, 0, len(old.Endpoints)+old.EmptySlots)
+	servers = append(servers, old.Endpoints...)
+	if !d.addServer {
+		for i := 1; i <= old.EmptySlots; i++ {
+			servers = append(servers, newDynSlot(fmt.Sprintf("%s%d", dynSlotPrefix, i)))
+		}
+	}
+	desired := make(map[string]*hatypes.Endpoint, len(cur.Endpoints))
+	for _, ep := range cur.Endpoints {
+		key := dynEndpointKey(ep)
+		if _, found := desired[key]; found {
+			return nil, nil, false
+		}
+		desired[key] = ep
+	}
+	running := make(map[string]bool, len(servers))
+	var endpoints, free, freed []*hatypes.Endpoint
+	var cmds []*dynCommand
+	for _, srv := range servers {
+		if srv.Disabled {
+			free = append(free, srv)
+			running[srv.Name] = true
+			continue
+		}
+		key := dynEndpointKey(srv)
+		ep, found := desired[key]
+		if !found {
+			if !scaling {
+				return nil, nil, false
+			}
+			if d.addServer {
+				// removed endpoint, deleted from the running backend
+				cmds = append(cmds,
+					newDynCommand("set server %s/%s state maint", cur.ID, srv.Name),
+					&dynCommand{
+						command: fmt.Sprintf("del server %s/%s", cur.ID, srv.Name),
+						expect:  "Server deleted",
+					},
+				)
+				continue
+			}
+			// removed endpoint, becomes an empty slot
+			cmds = append(cmds,
+				newDynCommand("set server %s/%s state maint", cur.ID, srv.Name),
+				newDynCommand("set server %s/%s addr %s port %d", cur.ID, srv.Name, dynSlotIP, dynSlotPort),
+				newDynCommand("set server %s/%s weight 1", cur.ID, srv.Name),
+			)
+			freed = append(freed, newDynSlot(srv.Name))
+			running[srv.Name] = true
+			continue
+		}
+		delete(desired, key)
+		running[srv.Name] = true
+		if ep.Backup != srv.Backup || !dynCanRename(cur, ep, srv.Name) {
+			return nil, nil, false
+		}
+		if ep.Weight != srv.Weight {
+			cmds = append(cmds,
+				newDynCommand("set server %s/%s weight %d", cur.ID, srv.Name, ep.Weight),
+				newDynCommand("set server %s/%s state %s", cur.ID, srv.Name, dynState(ep.Weight)),
+			)
+		}
+		endpoints = append(endpoints, dynRename(ep, srv.Name))
+	}
+	// empty slots are used before the servers removed in this update
+	free = append(free, freed...)
+	for _, ep := range cur.Endpoints {
+		if _, found := desired[dynEndpointKey(ep)]; !found {
+			continue
+		}
+		if !scaling {
+			return nil, nil, false
+		}
+		if d.addServer {
+			// new endpoint, added with its own name, which should not
+			// conflict with the name of a running server
+			if running[ep.Name] || strings.HasPrefix(ep.Name, dynSlotPrefix) {
+				return nil, nil, false
+			}
+			running[ep.Name] = true
+			server := cur.ID + "/" + ep.Name
+			cmds = append(cmds,
+				&dynCommand{add: server, expect: "New server registered"},
+				newDynCommand("set server %s state %s", server, dynState(ep.Weight)),
+			)
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		// new endpoint, uses the first empty slot
+		if len(free) == 0 || ep.Backup || !dynCanRename(cur, ep, free[0].Name) {
+			return nil, nil, false
+		}
+		slot := free[0]
+		free = free[1:]
+		cmds = append(cmds,
+			newDynCommand("set server %s/%s addr %s port %d", cur.ID, slot.Name, ep.IP, ep.Port),
+			newDynCommand("set server %s/%s weight %d", cur.ID, slot.Name, ep.Weight),
+			newDynCommand("set server %s/%s state %s", cur.ID, slot.Name, dynState(ep.Weight)),
+		)
+		endpoints = append(endpoints, dynRename(ep, slot.Name))
+	}
+	endpoints = append(endpoints, free...)
+	return endpoints, cmds, true
+}
+
+func newDynCommand(format string, a ...interface{}) *dynCommand {
+	return &dynCommand{command: fmt.Sprintf(format, a...)}
+}
+
+func newDynSlot(name string) *hatypes.Endpoint {
+	return &hatypes.Endpoint{
+		Name:     name,
+		IP:       dynSlotIP,
+		Port:     dynSlotPort,
+		Disabled: true,
+		Weight:   1,
+	}
+}
+
+func dynRename(ep *hatypes.Endpoint, name string) *hatypes.Endpoint {
+	renamed := *ep
+	renamed.Name = name
+	return &renamed
+}
+
+// dynCanRename reports whether a running server can be reused by an endpoint
+// under another name. Servers named after pods need a reload instead, otherwise
+// the server name would point to another pod.
+func dynCanRename(backend *hatypes.Backend, ep *hatypes.Endpoint, name string) bool {
+	return backend.ServerNaming != "pod" || ep.Name == name
+}
+
+func dynEndpointKey(ep *hatypes.Endpoint) string {
+	return fmt.Sprintf("%s:%d", ep.IP, ep.Port)
+}
+
+func dynState(weight int) string {
+	if weight == 0 {
+		return "drain"
+	}
+	return "ready"
+}
+
+// dynServerArgs returns the address and the arguments of a server, declared
+// as `<backend>/<server>`, from the content of the configuration files. The
+// `disabled` keyword is removed, the state of the server is changed after
+// adding it.
+func dynServerArgs(content map[string][]byte, server string) string {
+	pos := strings.Index(server, "/")
+	if pos < 0 {
+		return ""
+	}
+	backend, name := server[:pos], server[pos+1:]
+	files := make([]string, 0, len(content))
+	for file := range content {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		inBackend := false
+		scanner := bufio.NewScanner(bytes.NewReader(content[file]))
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if line[0] != ' ' && line[0] != '\t' {
+				inBackend = len(fields) == 2 && fields[0] == "backend" && fields[1] == backend
+				continue
+			}
+			if inBackend && len(fields) >= 3 && fields[0] == "server" && fields[1] == name {
+				args := make([]string, 0, len(fields)-2)
+				for _, arg := range fields[2:] {
+					if arg != "disabled" {
+						args = append(args, arg)
+					}
+				}
+				return strings.Join(args, " ")
+			}
+		}
+	}
+	return ""
+}
+
+// dynCommandError returns the error message of a runtime API response, if any.
+// expect, if not empty, is the prefix of a successful response.
+func dynCommandError(out, expect string) error {
+	out = strings.TrimSpace(out)
+	if expect != "" {
+		if !strings.HasPrefix(out, expect) {
+			return errors.New(out)
+		}
+		return nil
+	}
+	for _, prefix := range []string{"No such", "Require", "Invalid", "Unknown"} {
+		if strings.HasPrefix(out, prefix) {
+			return errors.New(out)
+		}
+	}
+	return nil
+}
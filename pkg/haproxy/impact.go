@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+var reloadImpactGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ingress_controller",
+		Name:      "reload_impact",
+		Help:      "Estimated impact of the last HAProxy reload",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	prometheus.MustRegister(reloadImpactGauge)
+}
+
+// ReloadImpact is an estimate of the impact of applying a new
+// configuration that needs to reload HAProxy
+type ReloadImpact struct {
+	BackendsAdded   int
+	BackendsRemoved int
+	BackendsRebuilt int
+	CertsChanged    int
+	CertsReread     int
+	// Sessions is the number of current sessions on removed or rebuilt
+	// backends, -1 if the stats socket couldn't be read
+	Sessions int
+}
+
+// String ...
+func (r *ReloadImpact) String() string {
+	sessions := "unknown"
+	if r.Sessions >= 0 {
+		sessions = strconv.Itoa(r.Sessions)
+	}
+	return fmt.Sprintf(
+		"backends added=%d removed=%d rebuilt=%d; certs changed=%d reread=%d; sessions likely reset=%s",
+		r.BackendsAdded, r.BackendsRemoved, r.BackendsRebuilt, r.CertsChanged, r.CertsReread, sessions)
+}
+
+func (r *ReloadImpact) export() {
+	reloadImpactGauge.WithLabelValues("backends_added").Set(float64(r.BackendsAdded))
+	reloadImpactGauge.WithLabelValues("backends_removed").Set(float64(r.BackendsRemoved))
+	reloadImpactGauge.WithLabelValues("backends_rebuilt").Set(float64(r.BackendsRebuilt))
+	reloadImpactGauge.WithLabelValues("certs_changed").Set(float64(r.CertsChanged))
+	reloadImpactGauge.WithLabelValues("certs_reread").Set(float64(r.CertsReread))
+	reloadImpactGauge.WithLabelValues("sessions").Set(float64(r.Sessions))
+}
+
+// estimateImpact compares the old and the new configuration, returning
+// the backends whose current sessions would be affected by a reload
+func estimateImpact(oldConfig, curConfig Config) (*ReloadImpact, []string) {
+	impact := &ReloadImpact{}
+	var affected []string
+	oldBackends := make(map[string]*hatypes.Backend, len(oldConfig.Backends()))
+	for _, backend := range oldConfig.Backends() {
+		oldBackends[backend.ID] = backend
+	}
+	for _, backend := range curConfig.Backends() {
+		old, found := oldBackends[backend.ID]
+		if !found {
+			impact.BackendsAdded++
+			continue
+		}
+		delete(oldBackends, backend.ID)
+		if !reflect.DeepEqual(old, backend) {
+			impact.BackendsRebuilt++
+			affected = append(affected, backend.ID)
+		}
+	}
+	for id := range oldBackends {
+		impact.BackendsRemoved++
+		affected = append(affected, id)
+	}
+	oldCerts := certHashes(oldConfig)
+	for file, hash := range certHashes(curConfig) {
+		impact.CertsReread++
+		if oldHash, found := oldCerts[file]; !found || oldHash != hash {
+			impact.CertsChanged++
+		}
+	}
+	return impact, affected
+}
+
+// certHashes returns the certificate and CA files of a configuration
+// and their hashes
+func certHashes(config Config) map[string]string {
+	certs := map[string]string{}
+	for _, host := range config.Hosts() {
+		if host.TLS.TLSFilename != "" {
+			certs[host.TLS.TLSFilename] = host.TLS.TLSHash
+		}
+		if host.TLS.CAFilename != "" {
+			certs[host.TLS.CAFilename] = host.TLS.CAHash
+		}
+		if host.TLS.CRLFilename != "" {
+			certs[host.TLS.CRLFilename] = host.TLS.CRLHash
+		}
+	}
+	return certs
+}
+
+// readSessions sums the current sessions of the backends, reading
+// `show stat` from the stats socket
+func readSessions(socket string, backends []string) int {
+	if len(backends) == 0 {
+		return 0
+	}
+	out, err := utils.HAProxyCommand(socket, "show stat -1 2 -1")
+	if err != nil {
+		return -1
+	}
+	ids := make(map[string]bool, len(backends))
+	for _, id := range backends {
+		ids[id] = true
+	}
+	sessions := 0
+	for _, line := range strings.Split(out, "\n") {
+		// pxname,svname,qcur,qmax,scur,...
+		fields := strings.Split(line, ",")
+		if len(fields) < 5 || !ids[fields[0]] || fields[1] != "BACKEND" {
+			continue
+		}
+		scur, _ := strconv.Atoi(fields[4])
+		sessions += scur
+	}
+	return sessions
+}
@@ -18,12 +18,21 @@ package haproxy
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/dynconfig"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/template"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/tracing"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
 )
 
 // InstanceOptions ...
@@ -33,119 +42,505 @@ type InstanceOptions struct {
 	HAProxyConfigFile string
 	ReloadCmd         string
 	ReloadStrategy    string
+	MasterSockets     []string
+	TemplatesDir      string
+	HAProxyTemplate   string
+	TemplatePartials  string
+	ClassPartials     string
+	MapsDir           string
+	BackendShards     int
+	HAProxyVersion    hatypes.Version
+	Tracer            *tracing.Tracer
+	Auditor           Auditor
+	Notifier          Notifier
+	Journal           Journal
+	JournalVerified   bool
 }
 
+// Auditor receives the changes of the HAProxy configuration file
+type Auditor interface {
+	// Audit is called on updates which changed the configuration file, action
+	// is either reload or dynamic, diff is in the unified format
+	Audit(action, diff string)
+}
+
+// Journal persists the runtime API commands applied to HAProxy since its
+// last reload, so its runtime state can be verified after a restart
+type Journal interface {
+	// Begin is called before changing the config files or the running HAProxy
+	Begin()
+	// Append is called on every runtime API command applied to HAProxy
+	Append(command string)
+	// Commit is called after HAProxy was updated without a reload
+	Commit()
+	// Reset is called after HAProxy was reloaded
+	Reset()
+}
+
+type nopJournal struct{}
+
+func (nopJournal) Begin()                {}
+func (nopJournal) Append(command string) {}
+func (nopJournal) Commit()               {}
+func (nopJournal) Reset()                {}
+
 // Instance ...
 type Instance interface {
 	ParseTemplates() error
 	Config() Config
+	LastConfig() Config
+	UpdateError() error
 	Update()
+	ForceReload()
 }
 
 // CreateInstance ...
-func CreateInstance(logger types.Logger, bindUtils hatypes.BindUtils, options InstanceOptions) Instance {
-	dynconf := &dynconfig.Config{
-		Logger: logger,
+func CreateInstance(logger types.Logger, options InstanceOptions) Instance {
+	if options.HAProxyConfigFile == "" {
+		options.HAProxyConfigFile = "/etc/haproxy/haproxy.cfg"
+	}
+	if options.TemplatesDir == "" {
+		options.TemplatesDir = "/etc/haproxy"
+	}
+	if options.MapsDir == "" {
+		options.MapsDir = "/etc/haproxy/maps"
+	}
+	if options.HAProxyTemplate == "" {
+		options.HAProxyTemplate = options.TemplatesDir + "/template/haproxy.tmpl"
+	}
+	if options.Journal == nil {
+		options.Journal = nopJournal{}
 	}
 	return &instance{
 		logger:       logger,
-		bindUtils:    bindUtils,
 		options:      &options,
 		templates:    template.CreateConfig(),
 		mapsTemplate: template.CreateConfig(),
-		mapsDir:      "/etc/haproxy/maps",
-		dynconfig:    dynconf,
+		mapsDir:      options.MapsDir,
+		dynCmd:       utils.HAProxyCommand,
+		masterCmd:    utils.HAProxyMasterCommand,
 	}
 }
 
 type instance struct {
 	logger       types.Logger
-	bindUtils    hatypes.BindUtils
 	options      *InstanceOptions
 	templates    *template.Config
 	mapsTemplate *template.Config
 	mapsDir      string
-	dynconfig    *dynconfig.Config
+	dynCmd       func(socket, command string) (string, error)
+	masterCmd    func(master, command string) (string, error)
 	oldConfig    Config
 	curConfig    Config
+	oldMutex     sync.Mutex
+	forceReload  bool
+	updateErr    error
+	updateMutex  sync.Mutex
 }
 
+// ParseTemplates reads the templates, and can be called again to apply
+// changes of the template files. The current templates are preserved if
+// any of the files cannot be parsed. Should not be called concurrently
+// with Update.
 func (i *instance) ParseTemplates() error {
-	i.templates.ClearTemplates()
-	i.mapsTemplate.ClearTemplates()
-	if err := i.templates.NewTemplate(
+	templates := template.CreateConfig()
+	mapsTemplate := template.CreateConfig()
+	templatesDir := i.options.TemplatesDir
+	if err := templates.NewTemplate(
 		"spoe-modsecurity.tmpl",
-		"/etc/haproxy/modsecurity/spoe-modsecurity.tmpl",
-		"/etc/haproxy/spoe-modsecurity.conf",
+		templatesDir+"/modsecurity/spoe-modsecurity.tmpl",
+		filepath.Dir(i.options.HAProxyConfigFile)+"/spoe-modsecurity.conf",
+		0,
+		1024,
+	); err != nil {
+		return err
+	}
+	if err := templates.NewTemplate(
+		"spoe-agents.tmpl",
+		templatesDir+"/spoe/spoe-agents.tmpl",
+		filepath.Dir(i.options.HAProxyConfigFile)+"/spoe-agents.conf",
 		0,
 		1024,
 	); err != nil {
 		return err
 	}
-	if err := i.templates.NewTemplate(
-		"haproxy.tmpl",
-		"/etc/haproxy/template/haproxy.tmpl",
-		"/etc/haproxy/haproxy.cfg",
+	var partials []string
+	// sections overridden by more than one file use the last one, in lexical
+	// order, the partials of the IngressClass parameters are used last
+	for _, dir := range []string{i.options.TemplatePartials, i.options.ClassPartials} {
+		if dir != "" {
+			files, _ := filepath.Glob(dir + "/*.tmpl")
+			partials = append(partials, files...)
+		}
+	}
+	if err := templates.NewTemplate(
+		filepath.Base(i.options.HAProxyTemplate),
+		i.options.HAProxyTemplate,
+		i.options.HAProxyConfigFile,
 		i.options.MaxOldConfigFiles,
 		16384,
+		partials...,
 	); err != nil {
 		return err
 	}
-	err := i.mapsTemplate.NewTemplate(
+	if err := mapsTemplate.NewTemplate(
 		"map.tmpl",
-		"/etc/haproxy/maptemplate/map.tmpl",
+		templatesDir+"/maptemplate/map.tmpl",
 		"",
 		0,
 		2048,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	i.templates = templates
+	i.mapsTemplate = mapsTemplate
+	// the configuration should be written and HAProxy reloaded
+	// even if the model didn't change
+	i.forceReload = true
+	return nil
+}
+
+// ForceReload makes the next Update write the configuration and reload
+// HAProxy even if the model didn't change. Should not be called concurrently
+// with Update.
+func (i *instance) ForceReload() {
+	i.forceReload = true
 }
 
 func (i *instance) Config() Config {
 	if i.curConfig == nil {
-		config := createConfig(i.bindUtils, options{
-			mapsTemplate: i.mapsTemplate,
-			mapsDir:      i.mapsDir,
+		config := createConfig(options{
+			mapsTemplate:  i.mapsTemplate,
+			mapsDir:       i.mapsDir,
+			backendShards: i.options.BackendShards,
 		})
 		i.curConfig = config
 	}
 	return i.curConfig
 }
 
+// LastConfig returns the last configuration handled by Update(),
+// which should not be changed. Safe to be called concurrently.
+func (i *instance) LastConfig() Config {
+	i.oldMutex.Lock()
+	defer i.oldMutex.Unlock()
+	return i.oldConfig
+}
+
 func (i *instance) Update() {
+	updateStart := time.Now()
 	if i.curConfig == nil {
 		i.logger.InfoV(2, "new configuration is empty")
 		return
 	}
 	if err := i.curConfig.BuildFrontendGroup(); err != nil {
 		i.logger.Error("error building configuration group: %v", err)
+		i.updated(updateConfigError, err)
 		i.clearConfig()
 		return
 	}
-	if i.curConfig.Equals(i.oldConfig) {
+	if !i.forceReload && i.curConfig.Equals(i.oldConfig) {
 		i.logger.InfoV(2, "old and new configurations match, skipping reload")
+		incUpdateCount(updateUnchanged)
 		i.clearConfig()
 		return
 	}
-	if err := i.templates.Write(i.curConfig); err != nil {
-		i.logger.Error("error writing configuration: %v", err)
+	i.options.Journal.Begin()
+	// HAProxy was running before the controller started and its state was
+	// verified by the journal, the reload is skipped if the files don't change
+	var runningContent map[string][]byte
+	if i.oldConfig == nil && i.options.JournalVerified && !i.forceReload {
+		runningContent = i.readRunningFiles()
+	}
+	// dynamic update renames endpoints to the running servers, so it is
+	// planned before writing the configuration file. Runtime API commands
+	// are only sent after the file was written.
+	var dyn *dynUpdater
+	if !i.forceReload {
+		dyn = i.dynUpdater()
+		if !dyn.plan() {
+			dyn = nil
+		}
+	}
+	var oldContent map[string][]byte
+	if i.options.Auditor != nil {
+		oldContent = i.readConfigFiles()
+	}
+	start := time.Now()
+	span := i.options.Tracer.Start("render")
+	if err := i.writeConfig(); err != nil {
+		i.logger.Error("%v", err)
+		i.updated(updateConfigError, err)
+		span.SetError(err)
+		span.End()
 		i.clearConfig()
 		return
 	}
-	updated := i.dynconfig.Update()
+	span.End()
+	observeConfigWrite(start)
+	if runningContent != nil && reflect.DeepEqual(runningContent, i.readRunningFiles()) {
+		i.options.Journal.Commit()
+		i.logger.Info("HAProxy config files didn't change since the last run, skipping reload")
+		incUpdateCount(updateUnchanged)
+		i.clearConfig()
+		return
+	}
+	updated := false
+	if dyn != nil {
+		updated = dyn.apply(i.readConfigFiles())
+		if !updated {
+			// the file reflects the planned servers, restore the
+			// new configuration before reloading HAProxy
+			dyn.rollback()
+			if err := i.writeConfig(); err != nil {
+				i.logger.Error("%v", err)
+				i.updated(updateConfigError, err)
+				i.clearConfig()
+				return
+			}
+		}
+	} else if !i.forceReload {
+		updated = i.certUpdate()
+	}
+	i.forceReload = false
+	var impact *ReloadImpact
+	if !updated && i.oldConfig != nil {
+		var affected []string
+		impact, affected = estimateImpact(i.oldConfig, i.curConfig)
+		impact.Sessions = readSessions(i.curConfig.Global().StatsSocket, affected)
+	}
+	var summary *UpdateSummary
+	if i.options.Notifier != nil {
+		summary = &UpdateSummary{}
+		summarizeChanges(summary, i.oldConfig, i.curConfig)
+	}
 	i.clearConfig()
 	if updated {
-		if err := i.check(); err != nil {
+		i.options.Journal.Commit()
+		span := i.options.Tracer.Start("validate")
+		err := i.check()
+		if err != nil {
 			i.logger.Error("error validating config file:\n%v", err)
+			span.SetError(err)
 		}
+		span.End()
+		i.audit("dynamic", oldContent)
 		i.logger.Info("HAProxy updated without needing to reload")
+		i.updated(updateDynamic, err)
+		i.notify(summary, updateDynamic, err, updateStart)
 		return
 	}
-	if err := i.reload(); err != nil {
+	if impact != nil {
+		i.logger.Info("reloading HAProxy, estimated impact: %s", impact)
+		impact.export()
+	}
+	span = i.options.Tracer.Start("reload")
+	span.SetAttr("haproxy.reload_strategy", i.options.ReloadStrategy)
+	err := i.reload()
+	span.SetError(err)
+	span.End()
+	if err != nil {
 		i.logger.Error("error reloading server:\n%v", err)
+		i.updated(updateReloadError, err)
+		i.audit("reload_error", oldContent)
+		i.notify(summary, updateReloadError, err, updateStart)
 		return
 	}
+	i.options.Journal.Reset()
+	i.audit("reload", oldContent)
 	i.logger.Info("HAProxy successfully reloaded")
+	i.updated(updateReload, nil)
+	i.notify(summary, updateReload, nil, updateStart)
+}
+
+func (i *instance) notify(summary *UpdateSummary, result string, err error, start time.Time) {
+	if summary == nil {
+		return
+	}
+	summary.Result = result
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	summary.Duration = time.Since(start)
+	summary.DurationSeconds = summary.Duration.Seconds()
+	i.options.Notifier.Notify(summary)
+}
+
+// updated counts the result of an update and saves its error. Unchanged
+// configurations don't call updated, so they preserve the former error.
+func (i *instance) updated(result string, err error) {
+	incUpdateCount(result)
+	i.updateMutex.Lock()
+	defer i.updateMutex.Unlock()
+	i.updateErr = err
+}
+
+// UpdateError returns the error of the last update which changed the
+// configuration, or nil if it succeeded. Safe to be called concurrently.
+func (i *instance) UpdateError() error {
+	i.updateMutex.Lock()
+	defer i.updateMutex.Unlock()
+	return i.updateErr
+}
+
+func (i *instance) audit(action string, oldContent map[string][]byte) {
+	if i.options.Auditor == nil {
+		return
+	}
+	newContent := i.readConfigFiles()
+	if _, found := newContent[i.options.HAProxyConfigFile]; !found {
+		i.logger.Warn("error reading configuration file to audit")
+		return
+	}
+	var files []string
+	for file := range oldContent {
+		files = append(files, file)
+	}
+	for file := range newContent {
+		if _, found := oldContent[file]; !found {
+			files = append(files, file)
+		}
+	}
+	// main configuration file first, shards in the load order
+	sort.Slice(files, func(j, k int) bool {
+		if files[j] == i.options.HAProxyConfigFile || files[k] == i.options.HAProxyConfigFile {
+			return files[j] == i.options.HAProxyConfigFile
+		}
+		return files[j] < files[k]
+	})
+	dir := filepath.Dir(i.options.HAProxyConfigFile)
+	var diffs []string
+	for _, file := range files {
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			name = file
+		}
+		if diff := unifiedDiff(name, name, oldContent[file], newContent[file], 3); diff != "" {
+			diffs = append(diffs, diff)
+		}
+	}
+	if len(diffs) > 0 {
+		i.options.Auditor.Audit(action, maskSecrets(strings.Join(diffs, "")))
+	}
+}
+
+// readConfigFiles reads the content of the configuration file and
+// its shards, missing files aren't added
+func (i *instance) readConfigFiles() map[string][]byte {
+	files := []string{i.options.HAProxyConfigFile}
+	shards, _ := filepath.Glob(i.backendShardsDir() + "/*.cfg")
+	files = append(files, shards...)
+	content := make(map[string][]byte, len(files))
+	for _, file := range files {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			content[file] = data
+		}
+	}
+	return content
+}
+
+// readRunningFiles reads the config files and the maps, used to compare
+// the new configuration with the files of a running HAProxy
+func (i *instance) readRunningFiles() map[string][]byte {
+	content := i.readConfigFiles()
+	maps, _ := filepath.Glob(i.mapsDir + "/*")
+	for _, file := range maps {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			content[file] = data
+		}
+	}
+	return content
+}
+
+func (i *instance) backendShardsDir() string {
+	return filepath.Dir(i.options.HAProxyConfigFile) + "/backends.d"
+}
+
+// writeBackendShards writes the backends to the shard files, and the
+// userlists to their own file, only shards whose content changed are
+// written. Shard files are loaded by HAProxy after the main configuration
+// file.
+func (i *instance) writeBackendShards() error {
+	shards := i.curConfig.BackendShards()
+	if shards == nil {
+		return nil
+	}
+	dir := i.backendShardsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	global := i.curConfig.Global()
+	data := make([]interface{}, len(shards))
+	outputs := make([]string, len(shards))
+	current := make(map[string]bool, len(shards))
+	for j, shard := range shards {
+		data[j] = map[string]interface{}{"p1": global, "p2": shard}
+		outputs[j] = fmt.Sprintf("%s/backends-%03d.cfg", dir, j+1)
+		current[outputs[j]] = true
+	}
+	// remove shards of a former and bigger number of shards
+	files, _ := filepath.Glob(dir + "/backends-*.cfg")
+	for _, file := range files {
+		if !current[file] {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	written, err := i.templates.WriteShards("backends", data, outputs)
+	if err != nil {
+		return err
+	}
+	i.logger.InfoV(2, "%d of %d backend shards written", written, len(shards))
+	// userlists are referenced by backends and resolved by HAProxy after
+	// reading all the files, so they can be declared in their own file
+	_, err = i.templates.WriteShards("userlists", []interface{}{i.curConfig.Userlists()}, []string{dir + "/userlists.cfg"})
+	return err
+}
+
+func (i *instance) configFilesArgs() []string {
+	args := []string{"-f", i.options.HAProxyConfigFile}
+	if i.options.BackendShards > 0 {
+		args = append(args, "-f", i.backendShardsDir())
+	}
+	return args
+}
+
+func (i *instance) writeConfig() error {
+	if err := i.templates.Write(i.curConfig); err != nil {
+		return fmt.Errorf("error writing configuration: %v", err)
+	}
+	if err := i.writeBackendShards(); err != nil {
+		return fmt.Errorf("error writing backend shards: %v", err)
+	}
+	return nil
+}
+
+func (i *instance) dynUpdater() *dynUpdater {
+	old, _ := i.oldConfig.(*config)
+	cur := i.curConfig.(*config)
+	return &dynUpdater{
+		logger:    i.logger,
+		socket:    cur.global.StatsSocket,
+		cmd:       i.dynCmd,
+		journal:   i.options.Journal,
+		addServer: i.options.HAProxyVersion.AtLeast(2, 5),
+		old:       old,
+		cur:       cur,
+	}
+}
+
+func (i *instance) certUpdate() bool {
+	old, _ := i.oldConfig.(*config)
+	cur := i.curConfig.(*config)
+	updater := &certUpdater{
+		logger: i.logger,
+		socket: cur.global.StatsSocket,
+		cmd:    i.dynCmd,
+		old:    old,
+		cur:    cur,
+	}
+	return updater.update()
 }
 
 func (i *instance) check() error {
@@ -153,7 +548,8 @@ func (i *instance) check() error {
 		i.logger.Info("(test) check was skipped")
 		return nil
 	}
-	out, err := exec.Command(i.options.HAProxyCmd, "-c", "-f", i.options.HAProxyConfigFile).CombinedOutput()
+	args := append([]string{"-c"}, i.configFilesArgs()...)
+	out, err := exec.Command(i.options.HAProxyCmd, args...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf(string(out))
 	}
@@ -161,11 +557,18 @@ func (i *instance) check() error {
 }
 
 func (i *instance) reload() error {
+	if len(i.options.MasterSockets) > 0 {
+		return i.reloadMasters()
+	}
 	if i.options.ReloadCmd == "" {
 		i.logger.Info("(test) reload was skipped")
 		return nil
 	}
-	out, err := exec.Command(i.options.ReloadCmd, i.options.ReloadStrategy, i.options.HAProxyConfigFile).CombinedOutput()
+	args := []string{i.options.ReloadStrategy, i.options.HAProxyConfigFile}
+	if i.options.BackendShards > 0 {
+		args = append(args, i.backendShardsDir())
+	}
+	out, err := exec.Command(i.options.ReloadCmd, args...).CombinedOutput()
 	if len(out) > 0 {
 		i.logger.Warn("output from haproxy:\n%v", string(out))
 	}
@@ -175,8 +578,31 @@ func (i *instance) reload() error {
 	return nil
 }
 
+// reloadMasters asks the master process of external HAProxy instances to
+// reload. The configuration files are shared with them, eg in a volume,
+// and HAProxy should be started with the same files of configFilesArgs().
+func (i *instance) reloadMasters() error {
+	var errs []string
+	for _, master := range i.options.MasterSockets {
+		out, err := i.masterCmd(master, "reload")
+		if err == nil && strings.Contains(out, "Success=0") {
+			// HAProxy 2.7+ answers with the result and the startup logs
+			err = fmt.Errorf("reload failed:\n%s", strings.TrimSpace(out))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", master, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
 func (i *instance) clearConfig() {
 	// TODO releaseConfig (old support files, ...)
+	i.oldMutex.Lock()
 	i.oldConfig = i.curConfig
+	i.oldMutex.Unlock()
 	i.curConfig = nil
 }
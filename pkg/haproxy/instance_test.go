@@ -20,14 +20,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/diff"
-	yaml "gopkg.in/yaml.v2"
 
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
 )
@@ -43,6 +42,7 @@ func TestBackends(t *testing.T) {
 		doconfig  func(g *hatypes.Global, b *hatypes.Backend)
 		path      []string
 		srvsuffix string
+		srvlines  string
 		expected  string
 	}{
 		{
@@ -98,12 +98,31 @@ func TestBackends(t *testing.T) {
 		},
 		{
 			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
-				g.ForwardFor = "add"
+				b.ForwardFor = "add"
 			},
 			expected: `
     http-request set-header X-Original-Forwarded-For %[hdr(x-forwarded-for)] if { hdr(x-forwarded-for) -m found }
     http-request del-header x-forwarded-for
     option forwardfor`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.ForwardFor = "update"
+			},
+			expected: `
+    option forwardfor`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.ForwardFor = "ifmissing"
+				b.ForwardedHeaders = hatypes.ForwardedHeadersConfig{Host: true, Port: true, Proto: true}
+			},
+			expected: `
+    option forwardfor if-none
+    http-request set-header X-Forwarded-Proto https if { ssl_fc }
+    http-request set-header X-Forwarded-Proto http if !{ ssl_fc }
+    http-request set-header X-Forwarded-Port %[dst_port]
+    http-request set-header X-Forwarded-Host %[req.hdr(host)]`,
 		},
 		{
 			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
@@ -129,6 +148,56 @@ func TestBackends(t *testing.T) {
 			expected: `
     reqrep ^([^:\ ]*)\ /app/sub(.*)$       \1\ /other/\2
     reqrep ^([^:\ ]*)\ /app(.*)$       \1\ /other/\2`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.StripPath = hatypes.StripPathConfig{
+					Regex: `/tenants/[^/\ ?]+`,
+					Match: `^/tenants/[^/\ ?]+(/|$)`,
+					Headers: []hatypes.StripPathHeader{
+						{Name: "X-Tenant-Id", Field: 3},
+					},
+				}
+			},
+			expected: `
+    http-request del-header X-Tenant-Id
+    http-request set-header X-Tenant-Id %[path,field(3,/)] if { path_reg ^/tenants/[^/\ ?]+(/|$) }
+    reqrep ^([^:\ ]*)\ /tenants/[^/\ ?]+(\?[^\ ]*)?\ (.*)$ \1\ /\2\ \3
+    reqrep ^([^:\ ]*)\ /tenants/[^/\ ?]+(/[^\ ]*)\ (.*)$ \1\ \2\ \3`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.StripPath = hatypes.StripPathConfig{
+					Regex: `/[^/\ ?]+`,
+					Match: `^/[^/\ ?]+(/|$)`,
+					// HAProxy 2.1 or newer
+					ReplacePath: true,
+				}
+			},
+			expected: `
+    http-request replace-path ^/[^/\ ?]+$ /
+    http-request replace-path ^/[^/\ ?]+(/.*)$ \1`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.Vars = []hatypes.BackendVar{
+					{Name: "tier", Expr: "str(gold)"},
+					{Name: "tenant", Expr: "req.hdr(x-tenant),lower"},
+				}
+				b.CustomConfig = []string{"http-request set-header X-Tier %[var(txn.tier)]"}
+			},
+			expected: `
+    http-request set-var(txn.tier) str(gold)
+    http-request set-var(txn.tenant) req.hdr(x-tenant),lower
+    http-request set-header X-Tier %[var(txn.tier)]`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.Vars = []hatypes.BackendVar{{Name: "tier", Expr: "str(gold)"}}
+				b.ModeTCP = true
+			},
+			expected: `
+    tcp-request content set-var(txn.tier) str(gold)`,
 		},
 		{
 			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
@@ -158,6 +227,203 @@ func TestBackends(t *testing.T) {
     http-request redirect location /oauth2/start?rd=%[path] if !{ path_beg /oauth2/ } !{ var(txn.auth_response_successful) -m bool }
     http-request set-header X-Auth-Request-Email %[var(txn.auth_response_email)] if { var(txn.auth_response_email) -m found }`,
 		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.OAuth.Impl = "oauth2_proxy"
+				b.OAuth.BackendName = "system_oauth_4180"
+				b.OAuth.URIPrefix = "/oauth2"
+				b.OAuth.Headers = map[string]string{"X-Auth-Request-Email": "auth_response_email"}
+				b.OAuth.SkipPaths = []string{"/health", "/api/public"}
+				b.OAuth.SkipPrefixes = []string{"/health/", "/api/public/", "/static/"}
+			},
+			expected: `
+    acl unsafe-path path_reg (^|/)[.][.]?(/|$)
+    acl unsafe-path path_sub -i %2e %2f %5c \\
+    acl oauth-skip path /health /api/public
+    acl oauth-skip path_beg /health/ /api/public/ /static/
+    http-request deny deny_status 400 if oauth-skip unsafe-path
+    http-request set-header X-Real-IP %[src]
+    http-request lua.auth-request system_oauth_4180 /oauth2/auth if !oauth-skip
+    http-request redirect location /oauth2/start?rd=%[path] if !{ path_beg /oauth2/ } !oauth-skip !{ var(txn.auth_response_successful) -m bool }
+    http-request del-header X-Auth-Request-Email if oauth-skip
+    http-request set-header X-Auth-Request-Email %[var(txn.auth_response_email)] if { var(txn.auth_response_email) -m found }`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.OAuth.Impl = "oauth2_proxy"
+				b.OAuth.BackendName = "system_oauth_4180"
+				b.OAuth.URIPrefix = "/oauth2"
+				b.OAuth.SkipPrefixes = []string{"/static/"}
+			},
+			expected: `
+    acl unsafe-path path_reg (^|/)[.][.]?(/|$)
+    acl unsafe-path path_sub -i %2e %2f %5c \\
+    acl oauth-skip path_beg /static/
+    http-request deny deny_status 400 if oauth-skip unsafe-path
+    http-request set-header X-Real-IP %[src]
+    http-request lua.auth-request system_oauth_4180 /oauth2/auth if !oauth-skip
+    http-request redirect location /oauth2/start?rd=%[path] if !{ path_beg /oauth2/ } !oauth-skip !{ var(txn.auth_response_successful) -m bool }`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.SecurityExempt.Exact = []string{"/healthz", "/metrics"}
+				b.SecurityExempt.Prefix = []string{"/status/"}
+				b.Whitelist = []string{"10.0.0.0/8"}
+				b.Userlist.Name = "default_auth"
+				b.OAuth.Impl = "oauth2_proxy"
+				b.OAuth.BackendName = "system_oauth_4180"
+				b.OAuth.URIPrefix = "/oauth2"
+				b.OAuth.Headers = map[string]string{"X-Auth-Request-Email": "auth_response_email"}
+			},
+			expected: `
+    acl security-exempt path /healthz /metrics
+    acl security-exempt path_beg /status/
+    acl unsafe-path path_reg (^|/)[.][.]?(/|$)
+    acl unsafe-path path_sub -i %2e %2f %5c \\
+    http-request deny deny_status 400 if security-exempt unsafe-path
+    http-request deny if !{ src 10.0.0.0/8 } !security-exempt
+    http-request auth if !security-exempt !{ http_auth(default_auth) }
+    http-request set-header X-Real-IP %[src]
+    http-request lua.auth-request system_oauth_4180 /oauth2/auth if !security-exempt
+    http-request redirect location /oauth2/start?rd=%[path] if !{ path_beg /oauth2/ } !security-exempt !{ var(txn.auth_response_successful) -m bool }
+    http-request del-header X-Auth-Request-Email if security-exempt
+    http-request set-header X-Auth-Request-Email %[var(txn.auth_response_email)] if { var(txn.auth_response_email) -m found }`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.HTTPConnMode = "http-server-close"
+				b.Timeout.KeepAlive = "10m"
+				b.Timeout.Server = "1h"
+			},
+			expected: `
+    timeout http-keep-alive 10m
+    timeout server 1h
+    option http-server-close`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.SSE = true
+				b.Timeout.Server = "1h"
+			},
+			expected: `
+    timeout server 1h
+    option http-no-delay
+    http-request del-header Accept-Encoding if { req.hdr(accept) -m sub text/event-stream }
+    http-response set-header Cache-Control no-cache if { res.hdr(content-type) -m beg text/event-stream }
+    http-response set-header X-Accel-Buffering no if { res.hdr(content-type) -m beg text/event-stream }`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.Retry.On = []string{"conn-failure", "502", "503"}
+				b.Retry.Retries = 3
+			},
+			expected: `
+    retry-on conn-failure 502 503
+    retries 3
+    option redispatch 1
+    http-request disable-l7-retry if !{ method GET HEAD OPTIONS PUT DELETE TRACE }`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				ep := *b.Endpoints[0]
+				ep.Backup = true
+				b.Endpoints[0] = &ep
+			},
+			srvsuffix: "backup",
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.SSL.IsSecure = true
+				b.SSL.ALPN = "h2,http/1.1"
+				b.SSL.CAFilename = "/var/haproxy/ssl/ca.pem"
+			},
+			srvsuffix: "ssl alpn h2,http/1.1 verify required ca-file /var/haproxy/ssl/ca.pem",
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.ProtoH2 = true
+			},
+			srvsuffix: "proto h2",
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.DNSSRV = hatypes.DNSSRVConfig{
+					Record:   "_http._tcp.app.example.com",
+					Resolver: "k8s",
+					Slots:    5,
+				}
+			},
+			srvlines: `
+    server-template _srv 1-5 _http._tcp.app.example.com resolvers k8s resolve-prefer ipv4 init-addr none`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.HTTPReuse = "never"
+			},
+			expected: `
+    http-reuse never`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.HashType = "consistent djb2"
+				b.HashBalanceFactor = 150
+			},
+			expected: `
+    hash-type consistent djb2
+    hash-balance-factor 150`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.HTTPReuse = "aggressive"
+				b.PreferLastServer = true
+			},
+			expected: `
+    option prefer-last-server
+    http-reuse aggressive`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.PoolPurgeDelay = "30s"
+			},
+			srvsuffix: "pool-purge-delay 30s",
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.HTTPBufferRequest = true
+			},
+			expected: `
+    option http-buffer-request`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.EmptySlots = 3
+			},
+			srvlines: `
+    server-template _slot 1-3 127.0.0.1:81 disabled weight 1`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.BandwidthLimit.Download = "10m"
+				b.BandwidthLimit.Upload = "1m"
+				b.BandwidthLimit.Period = "1s"
+			},
+			expected: `
+    filter bwlim-out bwlim_download default-limit 10m default-period 1s
+    http-response set-bandwidth-limit bwlim_download
+    filter bwlim-in bwlim_upload default-limit 1m default-period 1s
+    http-request set-bandwidth-limit bwlim_upload`,
+		},
+		{
+			doconfig: func(g *hatypes.Global, b *hatypes.Backend) {
+				b.BandwidthLimit.Download = "10m"
+				b.BandwidthLimit.Period = "2s"
+				b.BandwidthLimit.PerSrc = true
+			},
+			expected: `
+    stick-table type ip size 100k expire 1m store bytes_in_rate(2s),bytes_out_rate(2s)
+    filter bwlim-out bwlim_download limit 10m key src
+    http-response set-bandwidth-limit bwlim_download`,
+		},
 	}
 	for _, test := range testCases {
 		c := setup(t)
@@ -193,7 +459,7 @@ func TestBackends(t *testing.T) {
 <<defaults>>
 backend d1_app_8080
     mode ` + mode + test.expected + `
-    server s1 172.17.0.11:8080 weight 100` + test.srvsuffix + `
+    server s1 172.17.0.11:8080 weight 100` + test.srvsuffix + test.srvlines + `
 <<backends-default>>
 <<frontends-default>>
 `)
@@ -285,6 +551,172 @@ empty/ default_empty_8080`)
 	c.logger.CompareLogging(defaultLogging)
 }
 
+func TestInstanceLastConfig(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	if last := c.instance.LastConfig(); last != nil {
+		t.Errorf("expected empty last config before the first update")
+	}
+	c.config.AcquireHost("empty").AddPath(c.config.AcquireBackend("default", "empty", "8080"), "/")
+	config := c.config
+	c.instance.Update()
+	if last := c.instance.LastConfig(); last != config {
+		t.Errorf("expected last config to be the updated one")
+	}
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceParseTemplates(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	tmpl := c.tempdir + "/custom.tmpl"
+	writeTemplate := func(content string) {
+		if err := ioutil.WriteFile(tmpl, []byte(content), 0644); err != nil {
+			t.Errorf("error writing template: %v", err)
+		}
+	}
+	instance := c.instance.(*instance)
+	instance.options.TemplatesDir = "../../rootfs/etc/haproxy"
+	instance.options.HAProxyTemplate = tmpl
+	instance.mapsDir = c.tempdir
+	update := func() {
+		c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("d1", "app", "8080"), "/")
+		c.instance.Update()
+		// a new config with the same model
+		c.config = c.instance.Config().(*config)
+		c.config.ConfigDefaultX509Cert("/var/haproxy/ssl/certs/default.pem")
+		c.configGlobal()
+	}
+
+	writeTemplate("global\n    maxconn {{ .Global.MaxConn }}\n")
+	if err := c.instance.ParseTemplates(); err != nil {
+		t.Errorf("error parsing template: %v", err)
+	}
+	update()
+	c.checkConfig(`
+global
+    maxconn 2000
+`)
+	c.logger.CompareLogging(defaultLogging)
+
+	// same model, new template
+	writeTemplate("global\n    daemon\n    maxconn {{ .Global.MaxConn }}\n")
+	if err := c.instance.ParseTemplates(); err != nil {
+		t.Errorf("error parsing template: %v", err)
+	}
+	update()
+	c.checkConfig(`
+global
+    daemon
+    maxconn 2000
+`)
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=0 reread=0; sessions likely reset=0` + defaultLogging)
+
+	// invalid template, the former one is preserved
+	templates := instance.templates
+	writeTemplate("global\n    maxconn {{ .Global.MaxConn\n")
+	if err := c.instance.ParseTemplates(); err == nil {
+		t.Errorf("expected an error parsing an invalid template")
+	}
+	if instance.templates != templates {
+		t.Errorf("expected the former templates to be preserved")
+	}
+	update()
+	c.checkConfig(`
+global
+    daemon
+    maxconn 2000
+`)
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=0 reread=0; sessions likely reset=0` + defaultLogging)
+}
+
+func TestInstanceForceReload(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	instance := c.instance.(*instance)
+	instance.mapsDir = c.tempdir
+	update := func() {
+		c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("d1", "app", "8080"), "/")
+		c.instance.Update()
+		c.config = c.instance.Config().(*config)
+		c.config.ConfigDefaultX509Cert("/var/haproxy/ssl/certs/default.pem")
+		c.configGlobal()
+	}
+
+	update()
+	c.logger.CompareLogging(defaultLogging)
+
+	// same model, nothing to do
+	update()
+	c.logger.CompareLogging(`
+INFO-V(2) old and new configurations match, skipping reload`)
+
+	// same model, reload forced
+	c.instance.ForceReload()
+	update()
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=0 reread=0; sessions likely reset=0` + defaultLogging)
+
+	// forced only once
+	update()
+	c.logger.CompareLogging(`
+INFO-V(2) old and new configurations match, skipping reload`)
+}
+
+func TestInstanceTemplatePartials(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	partials := c.tempdir + "/partials"
+	if err := os.Mkdir(partials, 0755); err != nil {
+		t.Errorf("error creating partials dir: %v", err)
+	}
+	for file, content := range map[string]string{
+		"global.tmpl": `{{ define "global" }}
+global
+    maxconn {{ .Global.MaxConn }}
+{{- end }}`,
+		"backend.tmpl": `{{ define "backend" }}
+{{- $backend := .p2 }}
+backend {{ $backend.ID }}
+    mode http
+    # custom backend
+{{- end }}`,
+	} {
+		if err := ioutil.WriteFile(partials+"/"+file, []byte(content), 0644); err != nil {
+			t.Errorf("error writing partial: %v", err)
+		}
+	}
+	instance := c.instance.(*instance)
+	instance.options.TemplatesDir = "../../rootfs/etc/haproxy"
+	instance.options.HAProxyTemplate = "../../rootfs/etc/haproxy/template/haproxy.tmpl"
+	instance.options.TemplatePartials = partials
+	if err := c.instance.ParseTemplates(); err != nil {
+		t.Errorf("error parsing templates: %v", err)
+	}
+	c.newConfig()
+
+	c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("d1", "app", "8080"), "/")
+	c.instance.Update()
+
+	c.checkConfig(`
+global
+    maxconn 2000
+<<defaults>>
+backend d1_app_8080
+    mode http
+    # custom backend
+<<backends-default>>
+<<frontends-default>>
+`)
+	c.logger.CompareLogging(defaultLogging)
+}
+
 func TestInstanceDefaultHost(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -359,7 +791,7 @@ d2.local/app d2_app_8080
 	c.logger.CompareLogging(defaultLogging)
 }
 
-func TestInstanceSingleFrontendSingleBind(t *testing.T) {
+func TestInstanceHSTSPerPath(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
 
@@ -367,25 +799,19 @@ func TestInstanceSingleFrontendSingleBind(t *testing.T) {
 	def.Endpoints = []*hatypes.Endpoint{endpointS0}
 	c.config.ConfigDefaultBackend(def)
 
-	var h *hatypes.Host
-	var b *hatypes.Backend
-
-	b = c.config.AcquireBackend("d1", "app", "8080")
-	h = c.config.AcquireHost("d1.local")
-	h.AddPath(b, "/")
-	b.SSLRedirect = true
+	b := c.config.AcquireBackend("d1", "app", "8080")
 	b.Endpoints = []*hatypes.Endpoint{endpointS1}
-	h.VarNamespace = true
-	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
-	h.TLS.TLSHash = "1"
-
-	b = c.config.AcquireBackend("d2", "app", "8080")
-	h = c.config.AcquireHost("d2.local")
-	h.AddPath(b, "/app")
 	b.SSLRedirect = true
-	b.Endpoints = []*hatypes.Endpoint{endpointS1}
-	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d2.pem"
-	h.TLS.TLSHash = "2"
+	b.HSTS = hatypes.HSTS{Enabled: true, MaxAge: 15768000}
+	h := c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.AddPath(b, "/app")
+	h.AddPath(b, "/api")
+	h.FindPath("/app").HSTS = &hatypes.HSTS{}
+	h.FindPath("/api").HSTS = &hatypes.HSTS{Enabled: true, MaxAge: 300, Subdomains: true, Preload: true}
+	h = c.config.AcquireHost("d2.local")
+	h.AddPath(b, "/")
+	h.FindPath("/").HSTS = &hatypes.HSTS{Enabled: true, MaxAge: 15768000}
 
 	c.instance.Update()
 	c.checkConfig(`
@@ -393,9 +819,7 @@ func TestInstanceSingleFrontendSingleBind(t *testing.T) {
 <<defaults>>
 backend d1_app_8080
     mode http
-    server s1 172.17.0.11:8080 weight 100
-backend d2_app_8080
-    mode http
+    http-response set-header Strict-Transport-Security "max-age=15768000"
     server s1 172.17.0.11:8080 weight 100
 backend _default_backend
     mode http
@@ -412,41 +836,28 @@ frontend _front_http
     default_backend _default_backend
 frontend _front001
     mode http
-    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/certs/_public
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
     http-request set-var(req.base) base,lower,regsub(:[0-9]+/,/)
     http-request set-var(req.hostbackend) var(req.base),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
-    http-request set-var(txn.namespace) var(req.base),map_beg(/etc/haproxy/maps/_front001_k8s_ns.map,-)
+    http-request set-var(txn.hsts) var(req.base),map_beg(/etc/haproxy/maps/_front001_hsts.map,_nomatch)
+    http-response set-header Strict-Transport-Security %[var(txn.hsts)] if !{ var(txn.hsts) _nomatch } !{ var(txn.hsts) - }
+    http-response del-header Strict-Transport-Security if { var(txn.hsts) - }
     <<tls-del-headers>>
     use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
     default_backend _default_backend
 `)
 
-	c.checkMap("_global_http_front.map", `
-`)
-	c.checkMap("_global_https_redir.map", `
-d1.local/ yes
-d2.local/app yes
-`)
-	c.checkMap("_front001_host.map", `
-d1.local/ d1_app_8080
-d2.local/app d2_app_8080
-`)
-	c.checkMap("_front001_k8s_ns.map", `
-d1.local/ d1
-d2.local/app -
+	c.checkMap("_front001_hsts.map", `
+d1.local/app -
+d1.local/api max-age=300;includeSubDomains;preload
+d1.local/ max-age=15768000
+d2.local/ max-age=15768000
 `)
 
-	c.checkCerts(`
-certdirs:
-- dir: /var/haproxy/certs/_public
-  certs:
-  - /var/haproxy/ssl/certs/d1.pem
-  - /var/haproxy/ssl/certs/d2.pem`)
-
 	c.logger.CompareLogging(defaultLogging)
 }
 
-func TestInstanceSingleFrontendTwoBindsCA(t *testing.T) {
+func TestInstanceSingleFrontendSingleBind(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
 
@@ -457,12 +868,232 @@ func TestInstanceSingleFrontendTwoBindsCA(t *testing.T) {
 	var h *hatypes.Host
 	var b *hatypes.Backend
 
-	b = c.config.AcquireBackend("d", "app", "8080")
+	b = c.config.AcquireBackend("d1", "app", "8080")
 	h = c.config.AcquireHost("d1.local")
 	h.AddPath(b, "/")
 	b.SSLRedirect = true
 	b.Endpoints = []*hatypes.Endpoint{endpointS1}
-	b.SSL.AddCertHeader = true
+	h.VarNamespace = true
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
+	h.TLS.TLSHash = "1"
+
+	b = c.config.AcquireBackend("d2", "app", "8080")
+	h = c.config.AcquireHost("d2.local")
+	h.AddPath(b, "/app")
+	b.SSLRedirect = true
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d2.pem"
+	h.TLS.TLSHash = "2"
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend d2_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend _default_backend
+    mode http
+    server s0 172.17.0.99:8080 weight 100
+<<backend-errors>>
+frontend _front_http
+    mode http
+    bind :80
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _default_backend
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt-list /etc/haproxy/maps/_public_crt.list
+    http-request set-var(req.base) base,lower,regsub(:[0-9]+/,/)
+    http-request set-var(req.hostbackend) var(req.base),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    http-request set-var(txn.namespace) var(req.base),map_beg(/etc/haproxy/maps/_front001_k8s_ns.map,-)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _default_backend
+`)
+
+	c.checkMap("_global_http_front.map", `
+`)
+	c.checkMap("_global_https_redir.map", `
+d1.local/ yes
+d2.local/app yes
+`)
+	c.checkMap("_front001_host.map", `
+d1.local/ d1_app_8080
+d2.local/app d2_app_8080
+`)
+	c.checkMap("_front001_k8s_ns.map", `
+d1.local/ d1
+d2.local/app -
+`)
+
+	c.checkMap("_public_crt.list", `
+/var/haproxy/ssl/certs/d1.pem d1.local
+/var/haproxy/ssl/certs/d2.pem d2.local
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceQUIC(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().Bind.QUIC = true
+	c.config.Global().Bind.QUICAltSvcMaxAge = 3600
+	c.config.Global().Bind.QUICAltSvcPort = 8443
+
+	def := c.config.AcquireBackend("default", "default-backend", "8080")
+	def.Endpoints = []*hatypes.Endpoint{endpointS0}
+	c.config.ConfigDefaultBackend(def)
+
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h := c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
+	h.TLS.TLSHash = "1"
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend _default_backend
+    mode http
+    server s0 172.17.0.99:8080 weight 100
+<<backend-errors>>
+frontend _front_http
+    mode http
+    bind :80
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _default_backend
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/ssl/certs/d1.pem
+    bind quic4@:443 ssl alpn h3 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/ssl/certs/d1.pem
+    bind quic6@:443 ssl alpn h3 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/ssl/certs/d1.pem
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    http-response set-header alt-svc "h3=\":8443\"; ma=3600"
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _default_backend
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceCrtList(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	b := c.config.AcquireBackend("d", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	certs := map[string]string{
+		"*.d.local":     "/var/haproxy/ssl/certs/wildcard.pem",
+		"app1.d.local":  "/var/haproxy/ssl/certs/app.pem",
+		"app2.d.local":  "/var/haproxy/ssl/certs/default.pem",
+		"app3.d.local":  "",
+		"app4.d.local":  "/var/haproxy/ssl/certs/app.pem",
+		"sub.a.d.local": "",
+		"d.local":       "/var/haproxy/ssl/certs/d.pem",
+		"other.local":   "",
+	}
+	for hostname, crt := range certs {
+		h := c.config.AcquireHost(hostname)
+		h.AddPath(b, "/")
+		h.TLS.TLSFilename = crt
+	}
+
+	c.instance.Update()
+	c.checkMap("_public_crt.list", `
+/var/haproxy/ssl/certs/app.pem app1.d.local app4.d.local
+/var/haproxy/ssl/certs/d.pem d.local
+/var/haproxy/ssl/certs/default.pem app2.d.local app3.d.local
+/var/haproxy/ssl/certs/wildcard.pem *.d.local
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceCrtListSSLConf(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	b := c.config.AcquireBackend("d", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+
+	h := c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d.pem"
+	h.TLS.MinVersion = "TLSv1.0"
+	h.TLS.MaxVersion = "TLSv1.3"
+
+	c.instance.Update()
+	c.checkMap("_public_crt.list", `
+/var/haproxy/ssl/certs/d.pem [ssl-min-ver TLSv1.0 ssl-max-ver TLSv1.3] d1.local
+`)
+
+	c.newConfig()
+	b = c.config.AcquireBackend("d", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d.pem"
+	h.TLS.MinVersion = "TLSv1.0"
+	h.TLS.MaxVersion = "TLSv1.3"
+	h = c.config.AcquireHost("d2.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d.pem"
+	h = c.config.AcquireHost("d3.local")
+	h.AddPath(b, "/")
+	h.TLS.MinVersion = "TLSv1.3"
+	h.TLS.MaxVersion = "TLSv1.3"
+	h.TLS.Ciphers = "ECDHE-RSA-AES128-GCM-SHA256"
+	h.TLS.CipherSuites = "TLS_AES_128_GCM_SHA256"
+	h.TLS.ALPN = "http/1.1"
+
+	c.instance.Update()
+	c.checkMap("_public_crt.list", `
+/var/haproxy/ssl/certs/d.pem [ssl-min-ver TLSv1.0 ssl-max-ver TLSv1.3] d1.local
+/var/haproxy/ssl/certs/d.pem d2.local
+/var/haproxy/ssl/certs/default.pem [ssl-min-ver TLSv1.3 ssl-max-ver TLSv1.3 ciphers ECDHE-RSA-AES128-GCM-SHA256 ciphersuites TLS_AES_128_GCM_SHA256 alpn http/1.1] d3.local
+`)
+
+	c.logger.CompareLogging(defaultLogging + `
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=0 reread=1; sessions likely reset=0` + defaultLogging)
+}
+
+func TestInstanceSingleFrontendTwoBindsCA(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	def := c.config.AcquireBackend("default", "default-backend", "8080")
+	def.Endpoints = []*hatypes.Endpoint{endpointS0}
+	c.config.ConfigDefaultBackend(def)
+
+	var h *hatypes.Host
+	var b *hatypes.Backend
+
+	b = c.config.AcquireBackend("d", "app", "8080")
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	b.SSLRedirect = true
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	b.SSL.AddCertHeader = true
 	h.TLS.CAFilename = "/var/haproxy/ssl/ca/d1.local.pem"
 	h.TLS.CAHash = "1"
 	h.TLS.CAErrorPage = "http://d1.local/error.html"
@@ -471,6 +1102,8 @@ func TestInstanceSingleFrontendTwoBindsCA(t *testing.T) {
 	h.AddPath(b, "/")
 	h.TLS.CAFilename = "/var/haproxy/ssl/ca/d2.local.pem"
 	h.TLS.CAHash = "2"
+	h.TLS.CRLFilename = "/var/haproxy/ssl/ca/d2.local.crl.pem"
+	h.TLS.CRLHash = "2"
 
 	c.instance.Update()
 	c.checkConfig(`
@@ -498,7 +1131,8 @@ listen _front__tls
     ## _front001/_socket002
     use-server _server_socket002 if { req.ssl_sni -i -f /etc/haproxy/maps/_socket002.list }
     server _server_socket002 unix@/var/run/_socket002.sock send-proxy-v2 weight 0
-    # TODO default backend
+    ## default
+    use-server _server_socket001 if TRUE
 frontend _front_http
     mode http
     bind :80
@@ -511,11 +1145,12 @@ frontend _front_http
 frontend _front001
     mode http
     bind unix@/var/run/_socket001.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem ca-file /var/haproxy/ssl/ca/d1.local.pem verify optional ca-ignore-err all crt-ignore-err all
-    bind unix@/var/run/_socket002.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem ca-file /var/haproxy/ssl/ca/d2.local.pem verify optional ca-ignore-err all crt-ignore-err all
+    bind unix@/var/run/_socket002.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem ca-file /var/haproxy/ssl/ca/d2.local.pem crl-file /var/haproxy/ssl/ca/d2.local.crl.pem verify optional ca-ignore-err all crt-ignore-err all
     http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
     <<tls-del-headers>>
     http-request set-header x-ha-base %[ssl_fc_sni]%[path]
     http-request set-var(req.snibackend) hdr(x-ha-base),lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_sni.map,_nomatch)
+    http-request set-var(txn.tls_crt_verify) ssl_c_verify if { ssl_c_used }
     acl tls-has-crt ssl_c_used
     acl tls-need-crt ssl_fc_sni -i -f /etc/haproxy/maps/_front001_no_crt.list
     acl tls-has-invalid-crt ssl_c_ca_err gt 0
@@ -649,7 +1284,8 @@ listen _front__tls
     ## _front002/_socket003
     use-server _server_socket003 if { req.ssl_sni -i -f /etc/haproxy/maps/_socket003.list }
     server _server_socket003 unix@/var/run/_socket003.sock send-proxy-v2 weight 0
-    # TODO default backend
+    ## default
+    use-server _server_socket001 if TRUE
 frontend _front_http
     mode http
     bind :80
@@ -667,6 +1303,7 @@ frontend _front001
     <<tls-del-headers>>
     http-request set-header x-ha-base %[ssl_fc_sni]%[path]
     http-request set-var(req.snibackend) hdr(x-ha-base),lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_sni.map,_nomatch)
+    http-request set-var(txn.tls_crt_verify) ssl_c_verify if { ssl_c_used }
     acl tls-has-invalid-crt ssl_c_ca_err gt 0
     acl tls-has-invalid-crt ssl_c_err gt 0
     acl tls-check-crt ssl_fc_sni -i -f /etc/haproxy/maps/_front001_inv_crt.list
@@ -678,13 +1315,14 @@ frontend _front001
     default_backend _default_backend
 frontend _front002
     mode http
-    bind unix@/var/run/_socket002.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/certs/_socket002 ca-file /var/haproxy/ssl/ca/d2.local.pem verify optional ca-ignore-err all crt-ignore-err all
+    bind unix@/var/run/_socket002.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt-list /etc/haproxy/maps/_socket002_crt.list ca-file /var/haproxy/ssl/ca/d2.local.pem verify optional ca-ignore-err all crt-ignore-err all
     bind unix@/var/run/_socket003.sock accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
     timeout client 2s
     http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front002_host.map,_nomatch)
     <<tls-del-headers>>
     http-request set-header x-ha-base %[ssl_fc_sni]%[path]
     http-request set-var(req.snibackend) hdr(x-ha-base),lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front002_sni.map,_nomatch)
+    http-request set-var(txn.tls_crt_verify) ssl_c_verify if { ssl_c_used }
     acl tls-has-crt ssl_c_used
     acl tls-need-crt ssl_fc_sni -i -f /etc/haproxy/maps/_front002_no_crt.list
     acl tls-has-invalid-crt ssl_c_ca_err gt 0
@@ -759,11 +1397,9 @@ d21.local http://d21.local/error.html
 d22.local http://d22.local/error.html
 `)
 
-	c.checkCerts(`
-certdirs:
-- dir: /var/haproxy/certs/_socket002
-  certs:
-  - /var/haproxy/ssl/certs/d.pem`)
+	c.checkMap("_socket002_crt.list", `
+/var/haproxy/ssl/certs/d.pem d21.local d22.local
+`)
 
 	c.logger.CompareLogging(defaultLogging)
 }
@@ -872,12 +1508,14 @@ func TestInstanceSSLPassthrough(t *testing.T) {
 	b.SSLRedirect = true
 	b.Endpoints = []*hatypes.Endpoint{endpointS31}
 	h.SSLPassthrough = true
+	h.Alias.AliasName = "d2-alias.local"
 
 	b = c.config.AcquireBackend("d3", "app-ssl", "8443")
 	h = c.config.AcquireHost("d3.local")
 	h.AddPath(b, "/")
 	b.Endpoints = []*hatypes.Endpoint{endpointS41s}
 	h.SSLPassthrough = true
+	h.Alias.AliasRegex = "^d3-[a-z]+\\.local"
 
 	b = c.config.AcquireBackend("d3", "app-http", "8080")
 	b.Endpoints = []*hatypes.Endpoint{endpointS41h}
@@ -902,27 +1540,133 @@ listen _front__tls
     bind :443
     tcp-request inspect-delay 5s
     tcp-request content set-var(req.sslpassback) req.ssl_sni,lower,map(/etc/haproxy/maps/_global_sslpassthrough.map,_nomatch)
+    tcp-request content set-var(req.sslpassregback) req.ssl_sni,lower,map_reg(/etc/haproxy/maps/_global_sslpassthrough_regex.map,_nomatch) if { var(req.sslpassback) _nomatch }
     tcp-request content accept if { req.ssl_hello_type 1 }
     use_backend %[var(req.sslpassback)] unless { var(req.sslpassback) _nomatch }
-    # TODO default backend
+    use_backend %[var(req.sslpassregback)] unless { var(req.sslpassregback) _nomatch }
 frontend _front_http
     mode http
     bind :80
     http-request set-var(req.base) base,regsub(:[0-9]+/,/)
-    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    http-request set-var(req.redir) var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch)
+    http-request redirect scheme https if { var(req.redir) yes }
+    http-request redirect scheme https if { var(req.redir) _nomatch } { var(req.base),map_reg(/etc/haproxy/maps/_global_https_redir_regex.map,_nomatch) yes }
     <<tls-del-headers>>
     http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    http-request set-var(req.backend) var(req.base),map_reg(/etc/haproxy/maps/_global_http_front_regex.map,_nomatch) if { var(req.backend) _nomatch }
     use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
     default_backend _error404`)
 
 	c.checkMap("_global_sslpassthrough.map", `
+d2-alias.local d2_app_8080
 d2.local d2_app_8080
 d3.local d3_app-ssl_8443`)
+	c.checkMap("_global_sslpassthrough_regex.map", `
+^d3-[a-z]+\.local d3_app-ssl_8443`)
 	c.checkMap("_global_http_front.map", `
 d3.local/ d3_app-http_8080`)
+	c.checkMap("_global_http_front_regex.map", `
+^d3-[a-z]+\.local/ d3_app-http_8080`)
 	c.checkMap("_global_https_redir.map", `
 d2.local/ yes
+d2-alias.local/ yes
 d3.local/ no`)
+	c.checkMap("_global_https_redir_regex.map", `
+^d3-[a-z]+\.local/ no`)
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceTCPServices(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	var b *hatypes.Backend
+	var svc *hatypes.TCPService
+
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	b = c.config.AcquireBackend("d1", "pgsql", "5432")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	b.ModeTCP = true
+	tcp := c.config.AcquireTCPServicePort(5432)
+	tcp.AddService("", b)
+	tcp.TimeoutClient = "1h"
+
+	tcp = c.config.AcquireTCPServicePort(7000)
+	b = c.config.AcquireBackend("d2", "app1", "7000")
+	b.Endpoints = []*hatypes.Endpoint{endpointS21}
+	b.ModeTCP = true
+	tcp.AddService("app1.local", b)
+	b = c.config.AcquireBackend("d2", "app2", "7000")
+	b.Endpoints = []*hatypes.Endpoint{endpointS22}
+	b.ModeTCP = true
+	tcp.AddService("*.app2.local", b)
+
+	tcp = c.config.AcquireTCPServicePort(8443)
+	b = c.config.AcquireBackend("d3", "app", "8000")
+	b.Endpoints = []*hatypes.Endpoint{endpointS31}
+	b.ModeTCP = true
+	svc = tcp.AddService("app.local", b)
+	svc.TLSFilename = "/var/haproxy/ssl/certs/app.pem"
+	svc = tcp.AddService("*.app.local", b)
+	svc.TLSFilename = "/var/haproxy/ssl/certs/wildcard.pem"
+	svc = tcp.AddService("", b)
+	svc.TLSFilename = "/var/haproxy/ssl/certs/app.pem"
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+frontend _tcp_5432
+    mode tcp
+    bind :5432
+    timeout client 1h
+    default_backend d1_pgsql_5432
+frontend _tcp_7000
+    mode tcp
+    bind :7000
+    tcp-request inspect-delay 5s
+    tcp-request content set-var(req.tcpback) req.ssl_sni,lower,map(/etc/haproxy/maps/_tcp_7000_sni.map,_nomatch)
+    tcp-request content set-var(req.tcpback) req.ssl_sni,lower,map_reg(/etc/haproxy/maps/_tcp_7000_sni_regex.map,_nomatch) if { var(req.tcpback) _nomatch }
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    use_backend %[var(req.tcpback)] unless { var(req.tcpback) _nomatch }
+frontend _tcp_8443
+    mode tcp
+    bind :8443 ssl crt /var/haproxy/ssl/certs/app.pem crt-list /etc/haproxy/maps/_tcp_8443_crt.list
+    tcp-request content set-var(req.tcpback) ssl_fc_sni,lower,map(/etc/haproxy/maps/_tcp_8443_sni.map,_nomatch)
+    tcp-request content set-var(req.tcpback) ssl_fc_sni,lower,map_reg(/etc/haproxy/maps/_tcp_8443_sni_regex.map,_nomatch) if { var(req.tcpback) _nomatch }
+    use_backend %[var(req.tcpback)] unless { var(req.tcpback) _nomatch }
+    default_backend d3_app_8000
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend d1_pgsql_5432
+    mode tcp
+    server s1 172.17.0.11:8080 weight 100
+backend d2_app1_7000
+    mode tcp
+    server s21 172.17.0.121:8080 weight 100
+backend d2_app2_7000
+    mode tcp
+    server s22 172.17.0.122:8080 weight 100
+backend d3_app_8000
+    mode tcp
+    server s31 172.17.0.131:8080 weight 100
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.checkMap("_tcp_7000_sni.map", `
+app1.local d2_app1_7000`)
+	c.checkMap("_tcp_7000_sni_regex.map", `
+^[^.]+\.app2\.local$ d2_app2_7000`)
+	c.checkMap("_tcp_8443_sni.map", `
+app.local d3_app_8000`)
+	c.checkMap("_tcp_8443_crt.list", `
+/var/haproxy/ssl/certs/app.pem app.local
+/var/haproxy/ssl/certs/wildcard.pem *.app.local`)
 
 	c.logger.CompareLogging(defaultLogging)
 }
@@ -1096,48 +1840,101 @@ d3.local/ d3_app_8080
 
 }
 
-func TestUserlist(t *testing.T) {
-	type list struct {
-		name  string
-		users []hatypes.User
-	}
-	testCase := []struct {
-		lists    []list
-		listname string
-		realm    string
-		config   string
-	}{
-		{
-			lists: []list{
-				{
-					name: "default_usr",
-					users: []hatypes.User{
-						{Name: "usr1", Passwd: "clear1", Encrypted: false},
-					},
-				},
-			},
-			listname: "default_usr",
-			config: `
-userlist default_usr
-    user usr1 insecure-password clear1`,
-		},
-		{
-			lists: []list{
-				{
-					name: "default_auth",
-					users: []hatypes.User{
-						{Name: "usr1", Passwd: "clear1", Encrypted: false},
-						{Name: "usr2", Passwd: "xxxx", Encrypted: true},
-					},
-				},
-			},
-			listname: "default_auth",
-			realm:    "usrlist",
-			config: `
-userlist default_auth
-    user usr1 insecure-password clear1
-    user usr2 password xxxx`,
-		},
+func TestInstanceAcme(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	var h *hatypes.Host
+	var b *hatypes.Backend
+
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+
+	acme := &c.config.Global().Acme
+	acme.Enabled = true
+	acme.Prefix = "/.well-known/acme-challenge/"
+	acme.Socket = "/var/run/acme.sock"
+
+	c.instance.Update()
+
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+<<backends-default>>
+backend _acme_challenge
+    mode http
+    server _acme_server unix@/var/run/acme.sock
+frontend _front_http
+    mode http
+    bind :80
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend _acme_challenge if { path_beg /.well-known/acme-challenge/ }
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestUserlist(t *testing.T) {
+	type list struct {
+		name   string
+		groups []hatypes.UserGroup
+		users  []hatypes.User
+	}
+	testCase := []struct {
+		lists    []list
+		listname string
+		realm    string
+		groups   []string
+		config   string
+	}{
+		{
+			lists: []list{
+				{
+					name: "default_usr",
+					users: []hatypes.User{
+						{Name: "usr1", Passwd: "clear1", Encrypted: false},
+					},
+				},
+			},
+			listname: "default_usr",
+			config: `
+userlist default_usr
+    user usr1 insecure-password clear1`,
+		},
+		{
+			lists: []list{
+				{
+					name: "default_auth",
+					users: []hatypes.User{
+						{Name: "usr1", Passwd: "clear1", Encrypted: false},
+						{Name: "usr2", Passwd: "xxxx", Encrypted: true},
+					},
+				},
+			},
+			listname: "default_auth",
+			realm:    "usrlist",
+			config: `
+userlist default_auth
+    user usr1 insecure-password clear1
+    user usr2 password xxxx`,
+		},
 		{
 			lists: []list{
 				{
@@ -1159,6 +1956,31 @@ userlist default_auth
 userlist default_auth1
     user usr1 insecure-password clear1
 userlist default_auth2
+    user usr2 password xxxx`,
+		},
+		{
+			lists: []list{
+				{
+					name: "default_auth",
+					groups: []hatypes.UserGroup{
+						{Name: "admin", Users: []string{"usr1"}},
+						{Name: "dev", Users: []string{"usr1", "usr2"}},
+						{Name: "ops"},
+					},
+					users: []hatypes.User{
+						{Name: "usr1", Passwd: "clear1", Encrypted: false},
+						{Name: "usr2", Passwd: "xxxx", Encrypted: true},
+					},
+				},
+			},
+			listname: "default_auth",
+			groups:   []string{"admin", "ops"},
+			config: `
+userlist default_auth
+    group admin users usr1
+    group dev users usr1,usr2
+    group ops
+    user usr1 insecure-password clear1
     user usr2 password xxxx`,
 		},
 	}
@@ -1174,15 +1996,20 @@ userlist default_auth2
 		h.AddPath(b, "/")
 
 		for _, list := range test.lists {
-			c.config.AddUserlist(list.name, list.users)
+			c.config.AddUserlist(list.name, list.users).Groups = list.groups
 		}
 		b.Userlist.Name = test.listname
 		b.Userlist.Realm = test.realm
+		b.Userlist.Groups = test.groups
 
 		var realm string
 		if test.realm != "" {
 			realm = fmt.Sprintf(` realm "%s"`, test.realm)
 		}
+		auth := "http_auth(" + test.listname + ")"
+		if len(test.groups) > 0 {
+			auth = "http_auth_group(" + test.listname + ") " + strings.Join(test.groups, " ")
+		}
 
 		c.instance.Update()
 		c.checkConfig(`
@@ -1190,7 +2017,7 @@ userlist default_auth2
 <<defaults>>` + test.config + `
 backend d1_app_8080
     mode http
-    http-request auth` + realm + ` if !{ http_auth(` + test.listname + `) }
+    http-request auth` + realm + ` if !{ ` + auth + ` }
     server s1 172.17.0.11:8080 weight 100
 <<backends-default>>
 <<frontends-default>>
@@ -1343,7 +2170,8 @@ listen _front__tls
     ## _front002/_socket003 wildcard
     use-server _server_socket003_wildcard if { req.ssl_sni -i -m reg -f /etc/haproxy/maps/_socket003_regex.list }
     server _server_socket003_wildcard unix@/var/run/_socket003.sock send-proxy-v2 weight 0
-    # TODO default backend
+    ## default
+    use-server _server_socket001 if TRUE
 frontend _front_http
     mode http
     bind :80
@@ -1372,6 +2200,7 @@ frontend _front001
     http-request set-var(req.snibase) hdr(x-ha-base),lower,regsub(:[0-9]+/,/)
     http-request set-var(req.snibackend) var(req.snibase),map_beg(/etc/haproxy/maps/_front001_sni.map,_nomatch)
     http-request set-var(req.snibackend) var(req.snibase),map_reg(/etc/haproxy/maps/_front001_sni_regex.map,_nomatch) if { var(req.snibackend) _nomatch }
+    http-request set-var(txn.tls_crt_verify) ssl_c_verify if { ssl_c_used }
     acl tls-has-invalid-crt ssl_c_ca_err gt 0
     acl tls-has-invalid-crt ssl_c_err gt 0
     acl tls-check-crt ssl_fc_sni -i -f /etc/haproxy/maps/_front001_inv_crt.list
@@ -1468,111 +2297,1794 @@ d1.local/ d1_app_8080
 	c.logger.CompareLogging(defaultLogging)
 }
 
-/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
- *
- *  BUILDERS
- *
- * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
-
-type testConfig struct {
-	t          *testing.T
-	logger     *helper_test.LoggerMock
-	bindUtils  *ha_helper.BindUtilsMock
-	instance   Instance
-	config     Config
-	tempdir    string
-	configfile string
-}
-
-func setup(t *testing.T) *testConfig {
-	logger := &helper_test.LoggerMock{T: t}
-	tempdir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Errorf("error creating tempdir: %v", err)
-	}
-	configfile := tempdir + "/haproxy.cfg"
-	instance := CreateInstance(logger, &ha_helper.BindUtilsMock{}, InstanceOptions{
-		HAProxyConfigFile: configfile,
-	}).(*instance)
-	if err := instance.templates.NewTemplate(
-		"haproxy.tmpl",
-		"../../rootfs/etc/haproxy/template/haproxy.tmpl",
-		configfile,
-		0,
-		2048,
-	); err != nil {
-		t.Errorf("error parsing haproxy.tmpl: %v", err)
-	}
-	if err := instance.mapsTemplate.NewTemplate(
-		"map.tmpl",
-		"../../rootfs/etc/haproxy/maptemplate/map.tmpl",
-		"",
-		0,
-		2048,
-	); err != nil {
-		t.Errorf("error parsing map.tmpl: %v", err)
+func TestInstanceAcceptProxyWhitelist(t *testing.T) {
+	testCases := []struct {
+		passthrough bool
+		expected    string
+	}{
+		// 0
+		{
+			passthrough: false,
+			expected: `
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    http-request deny if !{ src 10.0.0.0/8 192.168.0.0/16 }
+    server s1 172.17.0.11:8080 weight 100
+<<backends-default>>
+frontend _front_http
+    mode http
+    bind :80 accept-proxy
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443 accept-proxy ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem crt /var/haproxy/ssl/certs/d1.pem
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404`,
+		},
+		// 1
+		{
+			passthrough: true,
+			expected: `
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode tcp
+    tcp-request content reject if !{ src 10.0.0.0/8 192.168.0.0/16 }
+    server s1 172.17.0.11:8080 weight 100
+<<backends-default>>
+listen _front__tls
+    mode tcp
+    bind :443 accept-proxy
+    tcp-request inspect-delay 5s
+    tcp-request content set-var(req.sslpassback) req.ssl_sni,lower,map(/etc/haproxy/maps/_global_sslpassthrough.map,_nomatch)
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    use_backend %[var(req.sslpassback)] unless { var(req.sslpassback) _nomatch }
+frontend _front_http
+    mode http
+    bind :80 accept-proxy
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404`,
+		},
 	}
-	bindUtils := &ha_helper.BindUtilsMock{}
-	config := createConfig(bindUtils, options{
-		mapsTemplate: instance.mapsTemplate,
-		mapsDir:      tempdir,
-	})
-	instance.curConfig = config
-	config.ConfigDefaultX509Cert("/var/haproxy/ssl/certs/default.pem")
-	c := &testConfig{
-		t:          t,
-		logger:     logger,
-		bindUtils:  bindUtils,
-		instance:   instance,
-		config:     config,
-		tempdir:    tempdir,
-		configfile: configfile,
+	for _, test := range testCases {
+		c := setup(t)
+		c.config.Global().Bind.HTTPAcceptProxy = true
+		c.config.Global().Bind.HTTPSAcceptProxy = true
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		b.Whitelist = []string{"10.0.0.0/8", "192.168.0.0/16"}
+		h := c.config.AcquireHost("d1.local")
+		h.AddPath(b, "/")
+		if test.passthrough {
+			b.ModeTCP = true
+			h.SSLPassthrough = true
+		} else {
+			h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
+			h.TLS.TLSHash = "1"
+		}
+		c.instance.Update()
+		c.checkConfig(test.expected)
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
 	}
-	c.configGlobal()
-	return c
 }
 
-func (c *testConfig) teardown() {
-	c.logger.CompareLogging("")
-	if err := os.RemoveAll(c.tempdir); err != nil {
-		c.t.Errorf("error removing tempdir: %v", err)
-	}
-}
+func TestInstanceBindAddress(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
 
-func (c *testConfig) configGlobal() {
-	global := c.config.Global()
-	global.Cookie.Key = "Ingress"
-	global.MaxConn = 2000
-	global.SSL.Ciphers = "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256"
-	global.SSL.DHParam.Filename = "/var/haproxy/tls/dhparam.pem"
-	global.SSL.HeadersPrefix = "X-SSL"
-	global.SSL.Options = "no-sslv3"
-	global.StatsSocket = "/var/run/haproxy.sock"
-	global.Timeout.Client = "50s"
-	global.Timeout.ClientFin = "50s"
-	global.Timeout.Connect = "5s"
-	global.Timeout.HTTPRequest = "5s"
-	global.Timeout.KeepAlive = "1m"
-	global.Timeout.Queue = "5s"
-	global.Timeout.Server = "50s"
-	global.Timeout.ServerFin = "50s"
-	global.Timeout.Stop = "15m"
-	global.Timeout.Tunnel = "1h"
-}
+	c.config.Global().Bind.HTTPAddr = hatypes.BindAddress{"", "::"}
+	c.config.Global().Bind.TCPAddr = hatypes.BindAddress{"10.0.0.1", "2001:db8::1"}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+	b = c.config.AcquireBackend("d1", "pgsql", "5432")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	b.ModeTCP = true
+	c.config.AcquireTCPServicePort(5432).AddService("", b)
 
-var endpointS0 = &hatypes.Endpoint{
-	Name:   "s0",
-	IP:     "172.17.0.99",
-	Port:   8080,
-	Weight: 100,
-}
-var endpointS1 = &hatypes.Endpoint{
-	Name:   "s1",
-	IP:     "172.17.0.11",
-	Port:   8080,
-	Weight: 100,
-}
-var endpointS21 = &hatypes.Endpoint{
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+frontend _tcp_5432
+    mode tcp
+    bind 10.0.0.1:5432,2001:db8::1:5432 v6only
+    default_backend d1_pgsql_5432
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend d1_pgsql_5432
+    mode tcp
+    server s1 172.17.0.11:8080 weight 100
+<<backends-default>>
+frontend _front_http
+    mode http
+    bind :80,:::80 v6only
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443,:::443 v6only ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceAcceptProxySources(t *testing.T) {
+	testCases := []struct {
+		http        bool
+		https       bool
+		sources     []string
+		passthrough bool
+		expected    string
+	}{
+		// 0
+		{
+			http: true,
+			expected: `
+frontend _tcp_5432
+    mode tcp
+    bind :5432 accept-proxy
+    default_backend d1_pgsql_5432
+<<backends>>
+frontend _front_http
+    mode http
+    bind :80 accept-proxy
+<<frontend-http>>
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
+<<frontend-https>>`,
+		},
+		// 1
+		{
+			https:   true,
+			sources: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			expected: `
+frontend _tcp_5432
+    mode tcp
+    bind :5432
+    tcp-request connection expect-proxy layer4 if { src 10.0.0.0/8 192.168.0.0/16 }
+    default_backend d1_pgsql_5432
+<<backends>>
+frontend _front_http
+    mode http
+    bind :80
+<<frontend-http>>
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
+    tcp-request connection expect-proxy layer4 if { src 10.0.0.0/8 192.168.0.0/16 }
+<<frontend-https>>`,
+		},
+		// 2
+		{
+			http:        true,
+			https:       true,
+			sources:     []string{"10.0.0.0/8"},
+			passthrough: true,
+			expected: `
+frontend _tcp_5432
+    mode tcp
+    bind :5432
+    tcp-request connection expect-proxy layer4 if { src 10.0.0.0/8 }
+    default_backend d1_pgsql_5432
+<<backends>>
+listen _front__tls
+    mode tcp
+    bind :443
+    tcp-request connection expect-proxy layer4 if { src 10.0.0.0/8 }
+    tcp-request inspect-delay 5s
+    tcp-request content set-var(req.sslpassback) req.ssl_sni,lower,map(/etc/haproxy/maps/_global_sslpassthrough.map,_nomatch)
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    use_backend %[var(req.sslpassback)] unless { var(req.sslpassback) _nomatch }
+frontend _front_http
+    mode http
+    bind :80
+    tcp-request connection expect-proxy layer4 if { src 10.0.0.0/8 }
+<<frontend-http>>`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+		c.config.Global().Bind.HTTPAcceptProxy = test.http
+		c.config.Global().Bind.HTTPSAcceptProxy = test.https
+		c.config.Global().Bind.AcceptProxySources = test.sources
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		h := c.config.AcquireHost("d1.local")
+		h.AddPath(b, "/")
+		if test.passthrough {
+			b.ModeTCP = true
+			h.SSLPassthrough = true
+		}
+		b = c.config.AcquireBackend("d1", "pgsql", "5432")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		b.ModeTCP = true
+		tcp := c.config.AcquireTCPServicePort(5432)
+		tcp.AddService("", b)
+		tcp.AcceptProxy = true
+		mode := "http"
+		if test.passthrough {
+			mode = "tcp"
+		}
+		c.instance.Update()
+		c.checkConfig(strings.NewReplacer(
+			"<<backends>>", `backend d1_app_8080
+    mode `+mode+`
+    server s1 172.17.0.11:8080 weight 100
+backend d1_pgsql_5432
+    mode tcp
+    server s1 172.17.0.11:8080 weight 100
+<<backends-default>>`,
+			"<<frontend-http>>", `    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404`,
+			"<<frontend-https>>", `    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404`,
+		).Replace("\n<<global>>\n<<defaults>>" + test.expected))
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
+	}
+}
+
+func TestInstanceReloadImpact(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	var h *hatypes.Host
+	var b *hatypes.Backend
+
+	b = c.config.AcquireBackend("d1", "app1", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
+	h.TLS.TLSHash = "1"
+	b = c.config.AcquireBackend("d1", "app2", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS21}
+	h = c.config.AcquireHost("d2.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d2.pem"
+	h.TLS.TLSHash = "1"
+	c.instance.Update()
+	c.logger.CompareLogging(defaultLogging)
+
+	c.newConfig()
+	b = c.config.AcquireBackend("d1", "app1", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d1.pem"
+	h.TLS.TLSHash = "1"
+	b = c.config.AcquireBackend("d1", "app2", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS21, endpointS22}
+	h = c.config.AcquireHost("d2.local")
+	h.AddPath(b, "/")
+	h.TLS.TLSFilename = "/var/haproxy/ssl/certs/d2.pem"
+	h.TLS.TLSHash = "2"
+	b = c.config.AcquireBackend("d1", "app3", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS31}
+	h = c.config.AcquireHost("d3.local")
+	h.AddPath(b, "/")
+	c.instance.Update()
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=1 removed=0 rebuilt=1; certs changed=1 reread=2; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+}
+
+func TestInstanceDNSResolvers(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	dns := &c.config.Global().DNS
+	dns.AcceptedPayloadSize = 8192
+	dns.HoldObsolete = "0s"
+	dns.HoldValid = "1s"
+	dns.TimeoutRetry = "1s"
+	dns.Resolvers = []*hatypes.DNSResolver{
+		{Name: "dns1", Nameservers: []*hatypes.DNSNameserver{
+			{Name: "ns_10.0.0.10_53", Endpoint: "10.0.0.10:53"},
+			{Name: "ns_10.0.0.11_53", Endpoint: "10.0.0.11:53"},
+		}},
+		{Name: "k8s", ParseResolvConf: true},
+	}
+	b := c.config.AcquireBackend("d1", "api", "443")
+	b.NewEndpoint("api.example.com", 443, "")
+	b.Resolver = "k8s"
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+resolvers dns1
+    nameserver ns_10.0.0.10_53 10.0.0.10:53
+    nameserver ns_10.0.0.11_53 10.0.0.11:53
+    accepted_payload_size 8192
+    hold obsolete 0s
+    hold valid 1s
+    timeout retry 1s
+resolvers k8s
+    parse-resolv-conf
+    accepted_payload_size 8192
+    hold obsolete 0s
+    hold valid 1s
+    timeout retry 1s
+backend d1_api_443
+    mode http
+    server api.example.com:443 api.example.com:443 weight 1 resolvers k8s resolve-prefer ipv4 init-addr last,libc,none
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstancePeers(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().Peers = hatypes.PeersConfig{
+		Name: "ingress",
+		Peers: []*hatypes.Peer{
+			{Name: "ingress-abc12", Endpoint: "10.0.1.11:10000"},
+			{Name: "ingress-def34", Endpoint: "10.0.1.12:10000"},
+		},
+	}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.BandwidthLimit.Download = "10m"
+	b.BandwidthLimit.Period = "2s"
+	b.BandwidthLimit.PerSrc = true
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+peers ingress
+    peer ingress-abc12 10.0.1.11:10000
+    peer ingress-def34 10.0.1.12:10000
+backend d1_app_8080
+    mode http
+    stick-table type ip size 100k expire 1m store bytes_in_rate(2s),bytes_out_rate(2s) peers ingress
+    filter bwlim-out bwlim_download limit 10m key src
+    http-response set-bandwidth-limit bwlim_download
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceGlobalRateLimit(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.RateLimit = hatypes.RateLimitConfig{
+		Limit:     100,
+		Period:    "10s",
+		Peers:     "ingress",
+		Tables:    []string{"_rl_d1_app_8080_ingress-a", "_rl_d1_app_8080_ingress-b"},
+		Track:     "_rl_d1_app_8080_ingress-a",
+		Whitelist: []string{"10.0.0.0/8"},
+	}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    http-request track-sc0 src table _rl_d1_app_8080_ingress-a unless { src 10.0.0.0/8 }
+    http-request set-var(txn.rate_limit) src,table_http_req_rate(_rl_d1_app_8080_ingress-a)
+    http-request set-var(txn.rate_limit) src,table_http_req_rate(_rl_d1_app_8080_ingress-b),add(txn.rate_limit)
+    http-request deny deny_status 429 if { var(txn.rate_limit) gt 100 } !{ src 10.0.0.0/8 }
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+backend _rl_d1_app_8080_ingress-a
+    stick-table type ip size 100k expire 10s store http_req_rate(10s) peers ingress
+backend _rl_d1_app_8080_ingress-b
+    stick-table type ip size 100k expire 10s store http_req_rate(10s) peers ingress
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+
+	// requests to exempt paths are neither tracked nor denied
+	c.newConfig()
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.RateLimit = hatypes.RateLimitConfig{
+		Limit:     100,
+		Period:    "10s",
+		Tables:    []string{"_rl_d1_app_8080_ingress-a"},
+		Track:     "_rl_d1_app_8080_ingress-a",
+		Whitelist: []string{"10.0.0.0/8"},
+	}
+	b.SecurityExempt.Exact = []string{"/healthz"}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    acl security-exempt path /healthz
+    acl unsafe-path path_reg (^|/)[.][.]?(/|$)
+    acl unsafe-path path_sub -i %2e %2f %5c \\
+    http-request deny deny_status 400 if security-exempt unsafe-path
+    http-request track-sc0 src table _rl_d1_app_8080_ingress-a unless { src 10.0.0.0/8 } || security-exempt
+    http-request set-var(txn.rate_limit) src,table_http_req_rate(_rl_d1_app_8080_ingress-a)
+    http-request deny deny_status 429 if { var(txn.rate_limit) gt 100 } !{ src 10.0.0.0/8 } !security-exempt
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+backend _rl_d1_app_8080_ingress-a
+    stick-table type ip size 100k expire 10s store http_req_rate(10s)
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown` + defaultLogging)
+}
+
+func TestInstanceLua(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().LuaScripts = []hatypes.LuaScript{
+		{Filename: "/etc/haproxy/lua/default_scripts_headers.lua"},
+		{Filename: "/etc/haproxy/lua/default_scripts_tenant.lua"},
+	}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.Lua.RequestActions = []string{"tenant", "add_headers"}
+	b.Lua.ResponseActions = []string{"strip_headers"}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+global
+    daemon
+    stats socket /var/run/haproxy.sock level admin expose-fd listeners
+    maxconn 2000
+    hard-stop-after 15m
+    lua-load /usr/local/etc/haproxy/lua/send-response.lua
+    lua-load /usr/local/etc/haproxy/lua/auth-request.lua
+    lua-load /etc/haproxy/lua/default_scripts_headers.lua
+    lua-load /etc/haproxy/lua/default_scripts_tenant.lua
+    ssl-dh-param-file /var/haproxy/tls/dhparam.pem
+    ssl-default-bind-ciphers ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256
+    ssl-default-bind-options no-sslv3
+<<defaults>>
+backend d1_app_8080
+    mode http
+    http-request lua.tenant
+    http-request lua.add_headers
+    http-response lua.strip_headers
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceCache(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.Cache = hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, MaxObjectSize: 524288, TotalMaxSize: 4}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    http-request cache-use _cache_d1_app_8080
+    http-response cache-store _cache_d1_app_8080
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+cache _cache_d1_app_8080
+    total-max-size 4
+    max-age 60
+    max-object-size 524288
+<<backends-default>>
+<<frontends-default>>
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceCompression(t *testing.T) {
+	testCases := []struct {
+		cache    bool
+		minSize  int64
+		expected string
+	}{
+		// 0
+		{
+			expected: `
+    filter compression
+    compression algo gzip
+    compression type text/html application/json`,
+		},
+		// 1
+		{
+			cache:   true,
+			minSize: 1024,
+			expected: `
+    http-request cache-use _cache_d1_app_8080
+    http-response cache-store _cache_d1_app_8080
+    filter compression
+    filter cache _cache_d1_app_8080
+    compression algo gzip
+    compression type text/html application/json
+    compression minsize-res 1024`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.NewEndpoint("172.17.0.11", 8080, "")
+		b.Compression = hatypes.CompressionConfig{
+			Algo:    "gzip",
+			MinSize: test.minSize,
+			Types:   []string{"text/html", "application/json"},
+		}
+		var cache string
+		if test.cache {
+			b.Cache = hatypes.CacheConfig{Name: "_cache_d1_app_8080", MaxAge: 60, TotalMaxSize: 4}
+			cache = `
+cache _cache_d1_app_8080
+    total-max-size 4
+    max-age 60`
+		}
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+		c.instance.Update()
+		c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http` + test.expected + `
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1` + cache + `
+<<backends-default>>
+<<frontends-default>>
+`)
+
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
+	}
+}
+
+func TestInstanceStats(t *testing.T) {
+	testCases := []struct {
+		stats    hatypes.StatsConfig
+		userlist string
+		expected string
+	}{
+		// 0
+		{
+			stats: hatypes.StatsConfig{Port: 1936, URI: "/", Auth: "admin:admin"},
+			expected: `
+listen stats
+    bind :1936
+    mode http
+    stats enable
+    stats realm HAProxy\ Statistics
+    stats auth admin:admin
+    stats uri /
+    no log
+    stats show-legends`,
+		},
+		// 1
+		{
+			stats: hatypes.StatsConfig{
+				AcceptProxy: true,
+				Admin:       true,
+				BindAddr:    hatypes.BindAddress{"127.0.0.1"},
+				Port:        1936,
+				TLSFilename: "/var/haproxy/ssl/stats.pem",
+				TLSHash:     "1",
+				URI:         "/stats",
+				Userlist:    "_stats",
+			},
+			userlist: `
+userlist _stats
+    user usr1 insecure-password clear1`,
+			expected: `
+listen stats
+    # CRT PEM checksum: 1
+    bind 127.0.0.1:1936 ssl crt /var/haproxy/ssl/stats.pem accept-proxy
+    mode http
+    stats enable
+    stats realm HAProxy\ Statistics
+    stats http-request auth realm HAProxy\ Statistics unless { http_auth(_stats) }
+    stats admin if TRUE
+    stats uri /stats
+    no log
+    stats show-legends`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+
+		if test.stats.Userlist != "" {
+			c.config.AddUserlist(test.stats.Userlist, []hatypes.User{{Name: "usr1", Passwd: "clear1"}})
+		}
+		c.config.Global().Stats = test.stats
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.NewEndpoint("172.17.0.11", 8080, "")
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+		c.instance.Update()
+		c.checkConfig(`
+<<global>>
+<<defaults>>` + test.userlist + `
+backend d1_app_8080
+    mode http
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+<<frontends-default>>` + test.expected + `
+`)
+
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
+	}
+}
+
+func TestInstanceRuntimeAPI(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().RuntimeAPI = hatypes.RuntimeAPIConfig{Port: 9999, Whitelist: []string{"10.0.0.0/8", "192.168.0.0/16"}}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+<<frontends-default>>
+frontend _runtime_api
+    mode tcp
+    bind :9999
+    timeout client 1h
+    tcp-request connection reject unless { src 10.0.0.0/8 192.168.0.0/16 }
+    default_backend _runtime_api
+backend _runtime_api
+    mode tcp
+    timeout server 1h
+    server runtime unix@/var/run/haproxy.sock
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceSPOE(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().SPOEAgents = []*hatypes.SPOEAgent{
+		{Name: "auth", Endpoints: []string{"10.0.0.1:12345", "10.0.0.2:12345"}},
+		{Name: "mirror", Endpoints: []string{"10.0.0.3:12345"}},
+	}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	b.SPOEFilters = []string{"auth", "mirror"}
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    filter spoe engine auth config /etc/haproxy/spoe-agents.conf
+    filter spoe engine mirror config /etc/haproxy/spoe-agents.conf
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+<<frontends-default>>
+backend _spoe_auth
+    mode tcp
+    timeout connect 5s
+    timeout server  5s
+    server spoa0 10.0.0.1:12345
+    server spoa1 10.0.0.2:12345
+backend _spoe_mirror
+    mode tcp
+    timeout connect 5s
+    timeout server  5s
+    server spoa0 10.0.0.3:12345
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
+
+func TestInstanceDynamicUpdate(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	var cmds []string
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		cmds = append(cmds, command)
+		return "", nil
+	}
+	var h *hatypes.Host
+	var b *hatypes.Backend
+
+	c.config.Global().DynamicScaling.Enabled = true
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1, endpointS21}
+	b.EmptySlots = 1
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	c.instance.Update()
+	c.logger.CompareLogging(defaultLogging)
+	if len(cmds) > 0 {
+		t.Errorf("expected no runtime API command on the first update, found: %v", cmds)
+	}
+
+	// weight change, removed endpoint and new endpoint
+	c.newConfig()
+	c.config.Global().DynamicScaling.Enabled = true
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	s1 := *endpointS1
+	s1.Weight = 50
+	b.Endpoints = []*hatypes.Endpoint{&s1, endpointS22}
+	b.EmptySlots = 1
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 50
+    server _slot1 172.17.0.122:8080 weight 100
+    server s21 127.0.0.1:81 disabled weight 1
+<<backends-default>>
+<<frontends-default>>
+`)
+	expectedCmds := []string{
+		"set server d1_app_8080/s1 weight 50",
+		"set server d1_app_8080/s1 state ready",
+		"set server d1_app_8080/s21 state maint",
+		"set server d1_app_8080/s21 addr 127.0.0.1 port 81",
+		"set server d1_app_8080/s21 weight 1",
+		"set server d1_app_8080/_slot1 addr 172.17.0.122 port 8080",
+		"set server d1_app_8080/_slot1 weight 100",
+		"set server d1_app_8080/_slot1 state ready",
+	}
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+INFO-V(2) runtime API command: ` + strings.Join(expectedCmds, `
+INFO-V(2) runtime API command: `) + `
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+	cmds = nil
+
+	// two new endpoints but only one empty slot
+	c.newConfig()
+	c.config.Global().DynamicScaling.Enabled = true
+	b = c.config.AcquireBackend("d1", "app", "8080")
+	b.Endpoints = []*hatypes.Endpoint{endpointS1, endpointS21, endpointS22, endpointS31}
+	h = c.config.AcquireHost("d1.local")
+	h.AddPath(b, "/")
+	c.instance.Update()
+	c.logger.CompareLogging(`
+INFO-V(2) cannot update backend 'd1_app_8080' dynamically, a reload is required
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+	if len(cmds) > 0 {
+		t.Errorf("expected no runtime API command when a reload is required, found: %v", cmds)
+	}
+}
+
+func TestInstanceDynamicPodNaming(t *testing.T) {
+	testCases := []struct {
+		version hatypes.Version
+		update  []*hatypes.Endpoint
+		cmds    []string
+	}{
+		// 0
+		{
+			update: []*hatypes.Endpoint{endpointS1},
+			cmds: []string{
+				"set server d1_app_8080/s21 state maint",
+				"set server d1_app_8080/s21 addr 127.0.0.1 port 81",
+				"set server d1_app_8080/s21 weight 1",
+			},
+		},
+		// 1
+		{
+			// new pod would use the empty slot, named after another pod
+			update: []*hatypes.Endpoint{endpointS1, endpointS21, endpointS22},
+		},
+		// 2
+		{
+			// another pod reusing the IP and port of a removed one
+			update: []*hatypes.Endpoint{endpointS1, {Name: "s23", IP: "172.17.0.121", Port: 8080, Weight: 100}},
+		},
+		// 3
+		{
+			version: hatypes.Version{Major: 2, Minor: 5},
+			update:  []*hatypes.Endpoint{endpointS1, endpointS21, endpointS22},
+			cmds: []string{
+				"add server d1_app_8080/s22 172.17.0.122:8080 weight 100",
+				"set server d1_app_8080/s22 state ready",
+			},
+		},
+		// 4
+		{
+			version: hatypes.Version{Major: 2, Minor: 5},
+			update:  []*hatypes.Endpoint{endpointS1, {Name: "s23", IP: "172.17.0.121", Port: 8080, Weight: 100}},
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		c.instance.(*instance).options.HAProxyVersion = test.version
+		var cmds []string
+		c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+			cmds = append(cmds, command)
+			if strings.HasPrefix(command, "add server ") {
+				return "New server registered.", nil
+			}
+			return "", nil
+		}
+		update := func(endpoints ...*hatypes.Endpoint) {
+			c.newConfig()
+			c.config.Global().DynamicScaling.Enabled = true
+			b := c.config.AcquireBackend("d1", "app", "8080")
+			b.Endpoints = endpoints
+			b.EmptySlots = 1
+			b.ServerNaming = "pod"
+			c.config.AcquireHost("d1.local").AddPath(b, "/")
+			c.instance.Update()
+		}
+		update(endpointS1, endpointS21)
+		c.logger.CompareLogging(defaultLogging)
+		update(test.update...)
+		if !reflect.DeepEqual(cmds, test.cmds) {
+			t.Errorf("runtime API commands on %d differ, expected: %v, actual: %v", i, test.cmds, cmds)
+		}
+		if test.cmds == nil {
+			c.logger.CompareLogging(`
+INFO-V(2) cannot update backend 'd1_app_8080' dynamically, a reload is required
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+		} else {
+			c.logger.CompareLogging(`
+INFO-V(2) runtime API command: ` + strings.Join(test.cmds, `
+INFO-V(2) runtime API command: `) + `
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+		}
+		c.teardown()
+	}
+}
+
+func TestInstanceDynamicAddServer(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.instance.(*instance).options.HAProxyVersion = hatypes.Version{Major: 2, Minor: 5}
+	var cmds []string
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		cmds = append(cmds, command)
+		switch {
+		case strings.HasPrefix(command, "add server "):
+			return "New server registered.", nil
+		case strings.HasPrefix(command, "del server "):
+			return "Server deleted.", nil
+		}
+		return "", nil
+	}
+	update := func(endpoints ...*hatypes.Endpoint) {
+		c.newConfig()
+		c.config.Global().DynamicScaling.Enabled = true
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = endpoints
+		b.EmptySlots = 1
+		b.HealthCheck.Interval = "2s"
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+		c.instance.Update()
+	}
+
+	update(endpointS1, endpointS21)
+	c.logger.CompareLogging(defaultLogging)
+
+	// weight change, removed endpoint and new endpoint, servers keep their names
+	s1 := *endpointS1
+	s1.Weight = 50
+	cmds = nil
+	update(&s1, endpointS22)
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 50 check inter 2s
+    server s22 172.17.0.122:8080 weight 100 check inter 2s
+    server-template _slot 1-1 127.0.0.1:81 disabled weight 1 check inter 2s
+<<backends-default>>
+<<frontends-default>>
+`)
+	expectedCmds := []string{
+		"set server d1_app_8080/s1 weight 50",
+		"set server d1_app_8080/s1 state ready",
+		"set server d1_app_8080/s21 state maint",
+		"del server d1_app_8080/s21",
+		"add server d1_app_8080/s22 172.17.0.122:8080 weight 100 check inter 2s",
+		"enable health d1_app_8080/s22",
+		"set server d1_app_8080/s22 state ready",
+	}
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+INFO-V(2) runtime API command: ` + strings.Join(expectedCmds, `
+INFO-V(2) runtime API command: `) + `
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+
+	// server with active connections cannot be deleted
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		if strings.HasPrefix(command, "del server ") {
+			return "Server still has connections attached to it, cannot remove it.", nil
+		}
+		return "", nil
+	}
+	update(&s1)
+	c.logger.CompareLogging(`
+INFO-V(2) runtime API command: set server d1_app_8080/s22 state maint
+WARN error sending runtime API command 'del server d1_app_8080/s22', a reload is required: Server still has connections attached to it, cannot remove it.
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+}
+
+func TestInstanceDynamicRollback(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		if strings.Contains(command, " addr 172.") {
+			return "No such server.", nil
+		}
+		return "", nil
+	}
+	update := func(endpoints ...*hatypes.Endpoint) {
+		c.newConfig()
+		c.config.Global().DynamicScaling.Enabled = true
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = endpoints
+		b.EmptySlots = 1
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+		c.instance.Update()
+	}
+
+	update(endpointS1)
+	c.logger.CompareLogging(defaultLogging)
+
+	// the file is written again with the endpoint names after a failure
+	update(endpointS1, endpointS22)
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+    server s22 172.17.0.122:8080 weight 100
+    server-template _slot 1-1 127.0.0.1:81 disabled weight 1
+<<backends-default>>
+<<frontends-default>>
+`)
+	c.logger.CompareLogging(`
+WARN error sending runtime API command 'set server d1_app_8080/_slot1 addr 172.17.0.122 port 8080', a reload is required: No such server.
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+}
+
+type journalMock struct {
+	calls []string
+}
+
+func (j *journalMock) Begin()                { j.calls = append(j.calls, "begin") }
+func (j *journalMock) Append(command string) { j.calls = append(j.calls, command) }
+func (j *journalMock) Commit()               { j.calls = append(j.calls, "commit") }
+func (j *journalMock) Reset()                { j.calls = append(j.calls, "reset") }
+
+func TestInstanceJournal(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	journal := &journalMock{}
+	instance := c.instance.(*instance)
+	instance.options.Journal = journal
+	instance.dynCmd = func(socket, command string) (string, error) {
+		return "", nil
+	}
+	config := func(endpoints ...*hatypes.Endpoint) {
+		c.config.Global().DynamicScaling.Enabled = true
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = endpoints
+		b.EmptySlots = 1
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+	}
+	restart := func() {
+		instance.oldConfig = nil
+		instance.options.JournalVerified = true
+		c.newConfig()
+	}
+	testCases := []struct {
+		update   func()
+		expCalls []string
+		logging  string
+	}{
+		// 0
+		{
+			update: func() {
+				config(endpointS1)
+			},
+			expCalls: []string{"begin", "reset"},
+			logging:  defaultLogging,
+		},
+		// 1
+		{
+			update: func() {
+				restart()
+				config(endpointS1)
+			},
+			expCalls: []string{"begin", "commit"},
+			logging: `
+INFO HAProxy config files didn't change since the last run, skipping reload`,
+		},
+		// 2
+		{
+			update: func() {
+				c.newConfig()
+				config(endpointS1, endpointS21)
+			},
+			expCalls: []string{
+				"begin",
+				"set server d1_app_8080/_slot1 addr 172.17.0.121 port 8080",
+				"set server d1_app_8080/_slot1 weight 100",
+				"set server d1_app_8080/_slot1 state ready",
+				"commit",
+			},
+			logging: `
+INFO-V(2) runtime API command: set server d1_app_8080/_slot1 addr 172.17.0.121 port 8080
+INFO-V(2) runtime API command: set server d1_app_8080/_slot1 weight 100
+INFO-V(2) runtime API command: set server d1_app_8080/_slot1 state ready
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`,
+		},
+		// 3
+		{
+			update: func() {
+				// servers of the running HAProxy were renamed by the dynamic update
+				restart()
+				config(endpointS1, endpointS21)
+			},
+			expCalls: []string{"begin", "reset"},
+			logging:  defaultLogging,
+		},
+		// 4
+		{
+			update: func() {
+				restart()
+				config(endpointS1, endpointS21)
+				instance.ForceReload()
+			},
+			expCalls: []string{"begin", "reset"},
+			logging:  defaultLogging,
+		},
+	}
+	for i, test := range testCases {
+		journal.calls = nil
+		test.update()
+		c.instance.Update()
+		if !reflect.DeepEqual(journal.calls, test.expCalls) {
+			t.Errorf("journal calls on %d differ, expected: %v, actual: %v", i, test.expCalls, journal.calls)
+		}
+		c.logger.CompareLogging(test.logging)
+	}
+}
+
+func TestDynServerArgs(t *testing.T) {
+	content := map[string][]byte{
+		"/etc/haproxy/haproxy.cfg": []byte(`
+global
+    daemon
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 disabled weight 1 check
+backend d2_app_8080
+    server s1 172.17.0.21:8080 weight 100
+`),
+		"/etc/haproxy/backends.d/backends-001.cfg": []byte(`
+backend d3_app_8080
+    server s1 172.17.0.31:8080 weight 10 ssl verify none
+`),
+	}
+	testCases := []struct {
+		server   string
+		expected string
+	}{
+		// 0
+		{
+			server:   "d1_app_8080/s1",
+			expected: "172.17.0.11:8080 weight 1 check",
+		},
+		// 1
+		{
+			server:   "d2_app_8080/s1",
+			expected: "172.17.0.21:8080 weight 100",
+		},
+		// 2
+		{
+			server:   "d3_app_8080/s1",
+			expected: "172.17.0.31:8080 weight 10 ssl verify none",
+		},
+		// 3
+		{
+			server:   "d2_app_8080/s2",
+			expected: "",
+		},
+		// 4
+		{
+			server:   "global",
+			expected: "",
+		},
+	}
+	for i, test := range testCases {
+		actual := dynServerArgs(content, test.server)
+		if actual != test.expected {
+			t.Errorf("server args on %d differ, expected: '%s', actual: '%s'", i, test.expected, actual)
+		}
+	}
+}
+
+func TestDynCommandError(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expect   string
+		expected string
+	}{
+		// 0
+		{
+			out: "\n",
+		},
+		// 1
+		{
+			out:      "No such server.\n",
+			expected: "No such server.",
+		},
+		// 2
+		{
+			out:    "New server registered.\n",
+			expect: "New server registered",
+		},
+		// 3
+		{
+			out:      "'server' : unknown keyword 'foo'\n",
+			expect:   "New server registered",
+			expected: "'server' : unknown keyword 'foo'",
+		},
+	}
+	for i, test := range testCases {
+		var actual string
+		if err := dynCommandError(test.out, test.expect); err != nil {
+			actual = err.Error()
+		}
+		if actual != test.expected {
+			t.Errorf("command error on %d differs, expected: '%s', actual: '%s'", i, test.expected, actual)
+		}
+	}
+}
+
+func TestInstanceDynamicWeight(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	var cmds []string
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		cmds = append(cmds, command)
+		return "", nil
+	}
+	update := func(endpoints ...*hatypes.Endpoint) {
+		c.newConfig()
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = endpoints
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+		c.instance.Update()
+	}
+
+	update(endpointS1, endpointS21)
+	c.logger.CompareLogging(defaultLogging)
+
+	// blue/green balance change, dynamic scaling is disabled
+	s1, s21 := *endpointS1, *endpointS21
+	s1.Weight, s21.Weight = 75, 0
+	cmds = nil
+	update(&s1, &s21)
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 75
+    server s21 172.17.0.121:8080 weight 0
+<<backends-default>>
+<<frontends-default>>
+`)
+	expectedCmds := []string{
+		"set server d1_app_8080/s1 weight 75",
+		"set server d1_app_8080/s1 state ready",
+		"set server d1_app_8080/s21 weight 0",
+		"set server d1_app_8080/s21 state drain",
+	}
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+INFO-V(2) runtime API command: ` + strings.Join(expectedCmds, `
+INFO-V(2) runtime API command: `) + `
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+
+	// new endpoint needs a reload without dynamic scaling
+	cmds = nil
+	update(&s1, &s21, endpointS22)
+	if len(cmds) > 0 {
+		t.Errorf("expected no runtime API command when a reload is required, found: %v", cmds)
+	}
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown
+INFO (test) reload was skipped
+INFO HAProxy successfully reloaded`)
+}
+
+func TestInstanceCertRuntimeUpdate(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	crtFile, _ := ioutil.TempFile("", "crt")
+	defer os.Remove(crtFile.Name())
+	crtFile.Write([]byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"))
+	crtFile.Close()
+	crt := crtFile.Name()
+
+	var cmds []string
+	var output map[string]string
+	c.instance.(*instance).dynCmd = func(socket, command string) (string, error) {
+		cmds = append(cmds, command)
+		return output[strings.Fields(command)[0]], nil
+	}
+	update := func(hash string, otherChange bool) {
+		c.newConfig()
+		c.config.Global().SSL.CertRuntimeUpdate = true
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		if otherChange {
+			b.Endpoints = []*hatypes.Endpoint{endpointS21}
+		}
+		h := c.config.AcquireHost("d1.local")
+		h.AddPath(b, "/")
+		h.TLS.TLSFilename = crt
+		h.TLS.TLSHash = hash
+		c.instance.Update()
+	}
+
+	update("1", false)
+	c.logger.CompareLogging(defaultLogging)
+
+	// changed certificate
+	cmds = nil
+	output = map[string]string{"set": "Transaction created for certificate " + crt + "!", "commit": "Committing " + crt + "\nSuccess!"}
+	update("2", false)
+	expectedCmds := []string{
+		"set ssl cert " + crt + " <<\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		"commit ssl cert " + crt,
+	}
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+INFO-V(2) certificate '` + crt + `' updated via runtime API
+INFO (test) check was skipped
+INFO HAProxy updated without needing to reload`)
+
+	// changed certificate and backend
+	cmds = nil
+	update("3", true)
+	if len(cmds) > 0 {
+		t.Errorf("expected no runtime API command when a reload is required, found: %v", cmds)
+	}
+	c.logger.CompareLogging(`
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=1 reread=1; sessions likely reset=unknown` + defaultLogging)
+
+	// unsupported command, backend doesn't change
+	cmds = nil
+	output = map[string]string{"set": "Unknown command."}
+	update("4", true)
+	expectedCmds = expectedCmds[:1]
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+WARN error updating certificate '` + crt + `' via runtime API, a reload is required: Unknown command.
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=1 reread=1; sessions likely reset=0` + defaultLogging)
+
+	// failed commit
+	cmds = nil
+	output = map[string]string{"set": "Transaction updated for certificate " + crt + "!", "commit": "Committing " + crt + "\nError!"}
+	update("5", true)
+	expectedCmds = append(expectedCmds, "commit ssl cert "+crt, "abort ssl cert "+crt)
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("runtime API commands differ, expected: %v, actual: %v", expectedCmds, cmds)
+	}
+	c.logger.CompareLogging(`
+WARN error updating certificate '` + crt + `' via runtime API, a reload is required: Committing ` + crt + `
+Error!
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=1 reread=1; sessions likely reset=0` + defaultLogging)
+}
+
+func TestInstanceBackendShards(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.instance.(*instance).options.BackendShards = 2
+	var h *hatypes.Host
+	var b *hatypes.Backend
+
+	c.config.(*config).backendShards = 2
+	for _, app := range []string{"app1", "app2", "app3"} {
+		b = c.config.AcquireBackend("d1", app, "8080")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		h = c.config.AcquireHost(app + ".local")
+		h.AddPath(b, "/")
+	}
+	c.config.AddUserlist("default_auth", []hatypes.User{{Name: "usr1", Passwd: "clear1"}})
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+<<backends-default>>
+<<frontends-default>>
+`)
+	userlists := strings.TrimSpace(c.readConfig(c.tempdir + "/backends.d/userlists.cfg"))
+	expUserlists := `userlist default_auth
+    user usr1 insecure-password clear1`
+	if userlists != expUserlists {
+		t.Errorf("diff of userlists shard:\n%s", diff.Diff(expUserlists, userlists))
+	}
+	c.checkShards([]string{`
+backend d1_app1_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend d1_app3_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100`, `
+backend d1_app2_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100`,
+	})
+	c.logger.CompareLogging(`
+INFO-V(2) 2 of 2 backend shards written` + defaultLogging)
+
+	c.newConfig()
+	c.config.(*config).backendShards = 2
+	for _, app := range []string{"app1", "app2", "app3"} {
+		b = c.config.AcquireBackend("d1", app, "8080")
+		b.Endpoints = []*hatypes.Endpoint{endpointS1}
+		h = c.config.AcquireHost(app + ".local")
+		h.AddPath(b, "/")
+	}
+	b.Endpoints = []*hatypes.Endpoint{endpointS31}
+	c.instance.Update()
+	c.checkShards([]string{`
+backend d1_app1_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100
+backend d1_app3_8080
+    mode http
+    server s31 172.17.0.131:8080 weight 100`, `
+backend d1_app2_8080
+    mode http
+    server s1 172.17.0.11:8080 weight 100`,
+	})
+	c.logger.CompareLogging(`
+INFO-V(2) 1 of 2 backend shards written
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown` + defaultLogging)
+}
+
+type auditorMock struct {
+	actions []string
+	diffs   []string
+}
+
+func (a *auditorMock) Audit(action, diff string) {
+	a.actions = append(a.actions, action)
+	a.diffs = append(a.diffs, diff)
+}
+
+func TestInstanceAudit(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	auditor := &auditorMock{}
+	c.instance.(*instance).options.Auditor = auditor
+	c.config.AcquireHost("empty").AddPath(c.config.AcquireBackend("default", "empty", "8080"), "/")
+	c.instance.Update()
+
+	c.newConfig()
+	c.config.Global().MaxConn = 4000
+	c.config.AcquireHost("empty").AddPath(c.config.AcquireBackend("default", "empty", "8080"), "/")
+	c.instance.Update()
+
+	if len(auditor.actions) != 2 || auditor.actions[0] != "reload" || auditor.actions[1] != "reload" {
+		t.Fatalf("expected two reload audits, found %v", auditor.actions)
+	}
+	if !strings.HasPrefix(auditor.diffs[0], "--- haproxy.cfg\n+++ haproxy.cfg\n@@ -0,0 +1,") {
+		t.Errorf("expected a diff from an empty file, found:\n%s", auditor.diffs[0])
+	}
+	expected := `--- haproxy.cfg
++++ haproxy.cfg
+@@ -9,7 +9,7 @@
+ global
+     daemon
+     stats socket /var/run/haproxy.sock level admin expose-fd listeners
+-    maxconn 2000
++    maxconn 4000
+     hard-stop-after 15m
+     lua-load /usr/local/etc/haproxy/lua/send-response.lua
+     lua-load /usr/local/etc/haproxy/lua/auth-request.lua
+@@ -19,7 +19,7 @@
+ 
+ defaults
+     log global
+-    maxconn 2000
++    maxconn 4000
+     option redispatch
+     option dontlognull
+     option http-server-close
+`
+	if auditor.diffs[1] != expected {
+		t.Errorf("diff differs\nexpected:\n%s\nactual:\n%s", expected, auditor.diffs[1])
+	}
+	c.logger.CompareLogging(defaultLogging + `
+INFO reloading HAProxy, estimated impact: backends added=0 removed=0 rebuilt=0; certs changed=0 reread=0; sessions likely reset=0` + defaultLogging)
+}
+
+func TestInstanceAuditShardsAndSecrets(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	auditor := &auditorMock{}
+	c.instance.(*instance).options.Auditor = auditor
+	c.instance.(*instance).options.BackendShards = 2
+	update := func(passwd string, ep *hatypes.Endpoint) {
+		c.config.(*config).backendShards = 2
+		c.config.AddUserlist("default_auth", []hatypes.User{{Name: "usr1", Passwd: passwd}})
+		c.config.Global().Stats.Auth = "admin:" + passwd
+		c.config.Global().Stats.Port = 1936
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.Endpoints = []*hatypes.Endpoint{ep}
+		c.config.AcquireHost("app.local").AddPath(b, "/")
+		c.instance.Update()
+	}
+	update("s3cr3t1", endpointS1)
+	c.newConfig()
+	update("s3cr3t2", endpointS21)
+
+	if len(auditor.diffs) != 2 {
+		t.Fatalf("expected two audits, found %d", len(auditor.diffs))
+	}
+	for _, diff := range auditor.diffs {
+		if strings.Contains(diff, "s3cr3t") {
+			t.Errorf("diff has secret values:\n%s", diff)
+		}
+	}
+	diff := auditor.diffs[1]
+	for _, expected := range []string{
+		"--- haproxy.cfg\n+++ haproxy.cfg\n",
+		"--- backends.d/backends-002.cfg\n+++ backends.d/backends-002.cfg\n",
+		"--- backends.d/userlists.cfg\n+++ backends.d/userlists.cfg\n",
+		"-    server s1 172.17.0.11:8080 weight 100\n+    server s21 172.17.0.121:8080 weight 100\n",
+		"insecure-password <redacted>",
+		"stats auth admin:<redacted>",
+	} {
+		if !strings.Contains(diff, expected) {
+			t.Errorf("expected '%s' in the diff:\n%s", expected, diff)
+		}
+	}
+	c.logger.Logging = []string{}
+}
+
+type notifierMock struct {
+	summaries []*UpdateSummary
+}
+
+func (n *notifierMock) Notify(summary *UpdateSummary) {
+	n.summaries = append(n.summaries, summary)
+}
+
+func TestInstanceNotify(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	notifier := &notifierMock{}
+	c.instance.(*instance).options.Notifier = notifier
+	c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("default", "app1", "8080"), "/")
+	c.config.AcquireHost("d2.local").AddPath(c.config.AcquireBackend("default", "app2", "8080"), "/")
+	c.instance.Update()
+
+	c.newConfig()
+	c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("default", "app1", "8080"), "/app")
+	c.config.AcquireHost("d3.local").AddPath(c.config.AcquireBackend("default", "app3", "8080"), "/")
+	c.instance.Update()
+
+	if len(notifier.summaries) != 2 {
+		t.Fatalf("expected two summaries, found %d", len(notifier.summaries))
+	}
+	testCases := []*UpdateSummary{
+		{
+			Result:        "reload",
+			HostsAdded:    []string{"d1.local", "d2.local"},
+			BackendsAdded: []string{"default_app1_8080", "default_app2_8080"},
+		},
+		{
+			Result:          "reload",
+			HostsAdded:      []string{"d3.local"},
+			HostsRemoved:    []string{"d2.local"},
+			HostsChanged:    []string{"d1.local"},
+			BackendsAdded:   []string{"default_app3_8080"},
+			BackendsRemoved: []string{"default_app2_8080"},
+			BackendsChanged: []string{"default_app1_8080"},
+		},
+	}
+	for i, expected := range testCases {
+		summary := *notifier.summaries[i]
+		if summary.Duration <= 0 || summary.DurationSeconds != summary.Duration.Seconds() {
+			t.Errorf("invalid duration on %d: %v", i, summary.Duration)
+		}
+		summary.Duration = 0
+		summary.DurationSeconds = 0
+		if !reflect.DeepEqual(&summary, expected) {
+			t.Errorf("summary differs on %d\nexpected: %+v\nactual:   %+v", i, expected, &summary)
+		}
+	}
+	c.logger.CompareLogging(defaultLogging + `
+INFO reloading HAProxy, estimated impact: backends added=1 removed=1 rebuilt=1; certs changed=0 reread=0; sessions likely reset=unknown` + defaultLogging)
+}
+
+func TestInstanceReloadMasters(t *testing.T) {
+	testCases := []struct {
+		answers map[string]string
+		logging string
+	}{
+		// 0
+		{
+			answers: map[string]string{
+				"/var/run/master.sock": "",
+				"haproxy:9999":         "",
+			},
+			logging: `
+INFO HAProxy successfully reloaded`,
+		},
+		// 1
+		{
+			answers: map[string]string{
+				"/var/run/master.sock": "Success=1\n--\n",
+				"haproxy:9999":         "Success=0\n--\n[ALERT] config error\n",
+			},
+			logging: `
+ERROR error reloading server:
+haproxy:9999: reload failed:
+Success=0
+--
+[ALERT] config error`,
+		},
+		// 2
+		{
+			answers: map[string]string{
+				"/var/run/master.sock": "",
+			},
+			logging: `
+ERROR error reloading server:
+haproxy:9999: connection refused`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+		instance := c.instance.(*instance)
+		instance.options.MasterSockets = []string{"/var/run/master.sock", "haproxy:9999"}
+		var commands []string
+		instance.masterCmd = func(master, command string) (string, error) {
+			commands = append(commands, master+" "+command)
+			out, found := test.answers[master]
+			if !found {
+				return "", fmt.Errorf("connection refused")
+			}
+			return out, nil
+		}
+		c.config.AcquireHost("d1.local").AddPath(c.config.AcquireBackend("d1", "app", "8080"), "/")
+		c.instance.Update()
+		expected := []string{"/var/run/master.sock reload", "haproxy:9999 reload"}
+		if !reflect.DeepEqual(commands, expected) {
+			t.Errorf("expected commands %v, but was %v", expected, commands)
+		}
+		c.logger.CompareLogging(test.logging)
+		c.teardown()
+	}
+}
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ *
+ *  BUILDERS
+ *
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type testConfig struct {
+	t          *testing.T
+	logger     *helper_test.LoggerMock
+	instance   Instance
+	config     Config
+	tempdir    string
+	configfile string
+}
+
+func setup(t *testing.T) *testConfig {
+	logger := &helper_test.LoggerMock{T: t}
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("error creating tempdir: %v", err)
+	}
+	configfile := tempdir + "/haproxy.cfg"
+	instance := CreateInstance(logger, InstanceOptions{
+		HAProxyConfigFile: configfile,
+	}).(*instance)
+	if err := instance.templates.NewTemplate(
+		"haproxy.tmpl",
+		"../../rootfs/etc/haproxy/template/haproxy.tmpl",
+		configfile,
+		0,
+		2048,
+	); err != nil {
+		t.Errorf("error parsing haproxy.tmpl: %v", err)
+	}
+	if err := instance.mapsTemplate.NewTemplate(
+		"map.tmpl",
+		"../../rootfs/etc/haproxy/maptemplate/map.tmpl",
+		"",
+		0,
+		2048,
+	); err != nil {
+		t.Errorf("error parsing map.tmpl: %v", err)
+	}
+	config := createConfig(options{
+		mapsTemplate: instance.mapsTemplate,
+		mapsDir:      tempdir,
+	})
+	instance.curConfig = config
+	config.ConfigDefaultX509Cert("/var/haproxy/ssl/certs/default.pem")
+	c := &testConfig{
+		t:          t,
+		logger:     logger,
+		instance:   instance,
+		config:     config,
+		tempdir:    tempdir,
+		configfile: configfile,
+	}
+	c.configGlobal()
+	return c
+}
+
+func (c *testConfig) newConfig() {
+	instance := c.instance.(*instance)
+	config := createConfig(options{
+		mapsTemplate: instance.mapsTemplate,
+		mapsDir:      c.tempdir,
+	})
+	instance.curConfig = config
+	config.ConfigDefaultX509Cert("/var/haproxy/ssl/certs/default.pem")
+	c.config = config
+	c.configGlobal()
+}
+
+func (c *testConfig) teardown() {
+	c.logger.CompareLogging("")
+	if err := os.RemoveAll(c.tempdir); err != nil {
+		c.t.Errorf("error removing tempdir: %v", err)
+	}
+}
+
+func (c *testConfig) configGlobal() {
+	global := c.config.Global()
+	global.Cookie.Key = "Ingress"
+	global.MaxConn = 2000
+	global.SSL.ALPN = "h2,http/1.1"
+	global.SSL.Ciphers = "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256"
+	global.SSL.DHParam.Filename = "/var/haproxy/tls/dhparam.pem"
+	global.SSL.HeadersPrefix = "X-SSL"
+	global.SSL.Options = "no-sslv3"
+	global.StatsSocket = "/var/run/haproxy.sock"
+	global.Timeout.Client = "50s"
+	global.Timeout.ClientFin = "50s"
+	global.Timeout.Connect = "5s"
+	global.Timeout.HTTPRequest = "5s"
+	global.Timeout.KeepAlive = "1m"
+	global.Timeout.Queue = "5s"
+	global.Timeout.Server = "50s"
+	global.Timeout.ServerFin = "50s"
+	global.Timeout.Stop = "15m"
+	global.Timeout.Tunnel = "1h"
+}
+
+var endpointS0 = &hatypes.Endpoint{
+	Name:   "s0",
+	IP:     "172.17.0.99",
+	Port:   8080,
+	Weight: 100,
+}
+var endpointS1 = &hatypes.Endpoint{
+	Name:   "s1",
+	IP:     "172.17.0.11",
+	Port:   8080,
+	Weight: 100,
+}
+var endpointS21 = &hatypes.Endpoint{
 	Name:   "s21",
 	IP:     "172.17.0.121",
 	Port:   8080,
@@ -1619,9 +4131,15 @@ var defaultLogging = `
 INFO (test) reload was skipped
 INFO HAProxy successfully reloaded`
 
-func _yamlMarshal(in interface{}) string {
-	out, _ := yaml.Marshal(in)
-	return string(out)
+func (c *testConfig) checkShards(expected []string) {
+	for i, exp := range expected {
+		file := fmt.Sprintf("%s/backends.d/backends-%03d.cfg", c.tempdir, i+1)
+		actual := strings.TrimSpace(c.readConfig(file))
+		exp = strings.TrimSpace(exp)
+		if actual != exp {
+			c.t.Errorf("diff of backend shard %d:\n%s", i+1, diff.Diff(exp, actual))
+		}
+	}
 }
 
 func (c *testConfig) checkConfig(expected string) {
@@ -1708,11 +4226,6 @@ func (c *testConfig) checkMap(mapName, expected string) {
 	c.compareText(mapName, actual, expected)
 }
 
-func (c *testConfig) checkCerts(expected string) {
-	actual := _yamlMarshal(c.bindUtils)
-	c.compareText("certs", actual, expected)
-}
-
 var replaceComments = regexp.MustCompile(`(?m)^[ \t]{0,2}(#.*)?[\r\n]+`)
 
 func (c *testConfig) readConfig(fileName string) string {
@@ -1732,3 +4245,222 @@ func (c *testConfig) compareText(name, actual, expected string) {
 		c.t.Error("\ndiff of " + name + ":" + diff.Diff(txtExpected, txtActual))
 	}
 }
+
+func TestInstanceLogFields(t *testing.T) {
+	testCases := []struct {
+		format    string
+		logFields string
+		expected  string
+		expFront  string
+	}{
+		// 0
+		{
+			expFront: `
+    option httplog`,
+		},
+		// 1
+		{
+			format: "%ci:%cp %b/%s %ST",
+			expFront: `
+    log-format %ci:%cp %b/%s %ST`,
+		},
+		// 2
+		{
+			logFields: "%sslv %[req.hdr(x-tenant)]",
+			expected: `
+    http-request set-header X-Ingress-Log-Fields "%sslv %[req.hdr(x-tenant)]"
+    http-request set-var(txn.log_fields) req.fhdr(x-ingress-log-fields)
+    http-request del-header X-Ingress-Log-Fields`,
+			expFront: `
+    log-format ` + hatypes.DefaultHTTPLogFormat + ` %[var(txn.log_fields)]`,
+		},
+		// 3
+		{
+			format:    "%ci:%cp %b/%s %ST",
+			logFields: "%sslv",
+			expected: `
+    http-request set-header X-Ingress-Log-Fields "%sslv"
+    http-request set-var(txn.log_fields) req.fhdr(x-ingress-log-fields)
+    http-request del-header X-Ingress-Log-Fields`,
+			expFront: `
+    log-format %ci:%cp %b/%s %ST %[var(txn.log_fields)]`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+
+		c.config.Global().Syslog.Endpoint = "127.0.0.1:514"
+		c.config.Global().Syslog.Format = "rfc5424"
+		c.config.Global().Syslog.Tag = "ingress"
+		c.config.Global().Syslog.HTTPLogFormat = test.format
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.NewEndpoint("172.17.0.11", 8080, "")
+		b.LogFields = test.logFields
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+		c.instance.Update()
+		c.checkConfig(`
+global
+    daemon
+    stats socket /var/run/haproxy.sock level admin expose-fd listeners
+    maxconn 2000
+    hard-stop-after 15m
+    log 127.0.0.1:514 format rfc5424 local0
+    log-tag ingress
+    lua-load /usr/local/etc/haproxy/lua/send-response.lua
+    lua-load /usr/local/etc/haproxy/lua/auth-request.lua
+    ssl-dh-param-file /var/haproxy/tls/dhparam.pem
+    ssl-default-bind-ciphers ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256
+    ssl-default-bind-options no-sslv3
+<<defaults>>
+backend d1_app_8080
+    mode http` + test.expected + `
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+frontend _front_http
+    mode http
+    bind :80` + test.expFront + `
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem` + test.expFront + `
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404
+`)
+
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
+	}
+}
+
+func TestInstanceLogCapture(t *testing.T) {
+	testCases := []struct {
+		reqHeaders []string
+		resHeaders []string
+		expected   string
+		expFront   string
+	}{
+		// 0
+		{
+			reqHeaders: []string{"User-Agent", "X-Tenant"},
+			expected: `
+    http-request capture req.fhdr(User-Agent) id 0
+    http-request capture req.fhdr(X-Tenant) id 1`,
+			expFront: `
+    option httplog
+    declare capture request len 128
+    declare capture request len 128`,
+		},
+		// 1
+		{
+			reqHeaders: []string{"User-Agent"},
+			resHeaders: []string{"Content-Type"},
+			expected: `
+    http-request capture req.fhdr(User-Agent) id 0
+    http-response capture res.fhdr(Content-Type) id 0`,
+			expFront: `
+    option httplog
+    declare capture request len 128
+    declare capture response len 128`,
+		},
+	}
+	for _, test := range testCases {
+		c := setup(t)
+
+		c.config.Global().Syslog.Endpoint = "127.0.0.1:514"
+		c.config.Global().Syslog.Format = "rfc5424"
+		c.config.Global().Syslog.Tag = "ingress"
+		b := c.config.AcquireBackend("d1", "app", "8080")
+		b.NewEndpoint("172.17.0.11", 8080, "")
+		b.LogCapture.RequestHeaders = test.reqHeaders
+		b.LogCapture.ResponseHeaders = test.resHeaders
+		c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+		c.instance.Update()
+		c.checkConfig(`
+global
+    daemon
+    stats socket /var/run/haproxy.sock level admin expose-fd listeners
+    maxconn 2000
+    hard-stop-after 15m
+    log 127.0.0.1:514 format rfc5424 local0
+    log-tag ingress
+    lua-load /usr/local/etc/haproxy/lua/send-response.lua
+    lua-load /usr/local/etc/haproxy/lua/auth-request.lua
+    ssl-dh-param-file /var/haproxy/tls/dhparam.pem
+    ssl-default-bind-ciphers ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256
+    ssl-default-bind-options no-sslv3
+<<defaults>>
+backend d1_app_8080
+    mode http` + test.expected + `
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+frontend _front_http
+    mode http
+    bind :80` + test.expFront + `
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem` + test.expFront + `
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404
+`)
+
+		c.logger.CompareLogging(defaultLogging)
+		c.teardown()
+	}
+}
+
+func TestInstanceTrustedProxies(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.config.Global().TrustedProxies = []string{"10.0.0.0/8", "192.168.0.0/16"}
+	b := c.config.AcquireBackend("d1", "app", "8080")
+	b.NewEndpoint("172.17.0.11", 8080, "")
+	c.config.AcquireHost("d1.local").AddPath(b, "/")
+
+	c.instance.Update()
+	c.checkConfig(`
+<<global>>
+<<defaults>>
+backend d1_app_8080
+    mode http
+    server 172.17.0.11:8080 172.17.0.11:8080 weight 1
+<<backends-default>>
+frontend _front_http
+    mode http
+    bind :80
+    http-request set-src hdr_ip(x-forwarded-for,-1) if { src 10.0.0.0/8 192.168.0.0/16 } { hdr(x-forwarded-for) -m found }
+    http-request set-var(req.base) base,regsub(:[0-9]+/,/)
+    http-request redirect scheme https if { var(req.base),map_beg(/etc/haproxy/maps/_global_https_redir.map,_nomatch) yes }
+    <<tls-del-headers>>
+    http-request set-var(req.backend) var(req.base),map_beg(/etc/haproxy/maps/_global_http_front.map,_nomatch)
+    use_backend %[var(req.backend)] unless { var(req.backend) _nomatch }
+    default_backend _error404
+frontend _front001
+    mode http
+    bind :443 ssl alpn h2,http/1.1 crt /var/haproxy/ssl/certs/default.pem
+    http-request set-src hdr_ip(x-forwarded-for,-1) if { src 10.0.0.0/8 192.168.0.0/16 } { hdr(x-forwarded-for) -m found }
+    http-request set-var(req.hostbackend) base,lower,regsub(:[0-9]+/,/),map_beg(/etc/haproxy/maps/_front001_host.map,_nomatch)
+    <<tls-del-headers>>
+    use_backend %[var(req.hostbackend)] unless { var(req.hostbackend) _nomatch }
+    default_backend _error404
+`)
+
+	c.logger.CompareLogging(defaultLogging)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	updateUnchanged   = "unchanged"
+	updateDynamic     = "dynamic"
+	updateReload      = "reload"
+	updateReloadError = "reload_error"
+	updateConfigError = "config_error"
+)
+
+var (
+	updateCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ingress_controller",
+			Name:      "haproxy_updates",
+			Help:      "Cumulative number of HAProxy configuration updates by their result",
+		},
+		[]string{"result"},
+	)
+	configWriteDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "ingress_controller",
+			Name:      "config_write_duration_seconds",
+			Help:      "Time spent rendering and writing the HAProxy configuration files",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(updateCount)
+	prometheus.MustRegister(configWriteDuration)
+}
+
+func incUpdateCount(result string) {
+	updateCount.WithLabelValues(result).Inc()
+}
+
+func observeConfigWrite(start time.Time) {
+	configWriteDuration.Observe(time.Since(start).Seconds())
+}
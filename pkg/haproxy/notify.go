@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// UpdateSummary describes an update which changed the HAProxy configuration
+type UpdateSummary struct {
+	// Result is reload, reload_error or dynamic
+	Result          string        `json:"result"`
+	Error           string        `json:"error,omitempty"`
+	Duration        time.Duration `json:"-"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	HostsAdded      []string      `json:"hostsAdded,omitempty"`
+	HostsRemoved    []string      `json:"hostsRemoved,omitempty"`
+	HostsChanged    []string      `json:"hostsChanged,omitempty"`
+	BackendsAdded   []string      `json:"backendsAdded,omitempty"`
+	BackendsRemoved []string      `json:"backendsRemoved,omitempty"`
+	BackendsChanged []string      `json:"backendsChanged,omitempty"`
+}
+
+// Notifier receives the summary of the updates which changed the configuration
+type Notifier interface {
+	Notify(summary *UpdateSummary)
+}
+
+// summarizeChanges fills the hosts and backends added, removed
+// or changed between the old and the new configuration
+func summarizeChanges(summary *UpdateSummary, oldConfig, curConfig Config) {
+	oldHosts := map[string]interface{}{}
+	curHosts := map[string]interface{}{}
+	oldBackends := map[string]interface{}{}
+	curBackends := map[string]interface{}{}
+	if oldConfig != nil {
+		for _, host := range oldConfig.Hosts() {
+			oldHosts[host.Hostname] = host
+		}
+		for _, backend := range oldConfig.Backends() {
+			oldBackends[backend.ID] = backend
+		}
+	}
+	for _, host := range curConfig.Hosts() {
+		curHosts[host.Hostname] = host
+	}
+	for _, backend := range curConfig.Backends() {
+		curBackends[backend.ID] = backend
+	}
+	summary.HostsAdded, summary.HostsRemoved, summary.HostsChanged = compareItems(oldHosts, curHosts)
+	summary.BackendsAdded, summary.BackendsRemoved, summary.BackendsChanged = compareItems(oldBackends, curBackends)
+}
+
+func compareItems(oldItems, curItems map[string]interface{}) (added, removed, changed []string) {
+	for name, cur := range curItems {
+		old, found := oldItems[name]
+		if !found {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(old, cur) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldItems {
+		if _, found := curItems[name]; !found {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
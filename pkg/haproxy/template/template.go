@@ -18,9 +18,12 @@ package template
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	gotemplate "text/template"
 )
 
@@ -31,7 +34,8 @@ func CreateConfig() *Config {
 
 // Config ...
 type Config struct {
-	templates []*template
+	templates   []*template
+	shardHashes map[string][sha1.Size]byte
 }
 
 // ClearTemplates ...
@@ -39,12 +43,19 @@ func (c *Config) ClearTemplates() {
 	c.templates = nil
 }
 
-// NewTemplate ...
-func (c *Config) NewTemplate(name, file, output string, rotate, startingBufferSize int) error {
+// NewTemplate parses the template file, and optional partial files whose
+// define actions replace the templates of the same name declared in the
+// template file, eg a single section of the configuration.
+func (c *Config) NewTemplate(name, file, output string, rotate, startingBufferSize int, partials ...string) error {
 	tmpl, err := gotemplate.New(name).Funcs(funcMap).ParseFiles(file)
 	if err != nil {
 		return fmt.Errorf("cannot read template file: %v", err)
 	}
+	for _, partial := range partials {
+		if err := overrideTemplates(tmpl, partial); err != nil {
+			return err
+		}
+	}
 	c.templates = append(c.templates, &template{
 		tmpl:      tmpl,
 		output:    output,
@@ -54,6 +65,26 @@ func (c *Config) NewTemplate(name, file, output string, rotate, startingBufferSi
 	return nil
 }
 
+func overrideTemplates(tmpl *gotemplate.Template, partial string) error {
+	ptmpl, err := gotemplate.New(filepath.Base(partial)).Funcs(funcMap).ParseFiles(partial)
+	if err != nil {
+		return fmt.Errorf("cannot read partial template file: %v", err)
+	}
+	for _, t := range ptmpl.Templates() {
+		if t.Name() == ptmpl.Name() {
+			// content outside of the define actions
+			continue
+		}
+		if tmpl.Lookup(t.Name()) == nil {
+			return fmt.Errorf("partial template file %s: template '%s' not found in %s", partial, t.Name(), tmpl.Name())
+		}
+		if _, err := tmpl.AddParseTree(t.Name(), t.Tree); err != nil {
+			return fmt.Errorf("partial template file %s: %v", partial, err)
+		}
+	}
+	return nil
+}
+
 // Write ...
 func (c *Config) Write(data interface{}) error {
 	return c.WriteOutput(data, "")
@@ -75,6 +106,64 @@ func (c *Config) WriteOutput(data interface{}, output string) error {
 	return nil
 }
 
+// WriteShards executes the named template, declared in one of the template
+// files with a define action, once per data item, and writes every output to
+// the file of the same index in parallel. Files whose content didn't change
+// since the last call are not written. Returns the number of written files.
+func (c *Config) WriteShards(name string, data []interface{}, outputs []string) (int, error) {
+	if len(data) != len(outputs) {
+		return 0, fmt.Errorf("expected %d outputs, found %d", len(data), len(outputs))
+	}
+	var tmpl *gotemplate.Template
+	for _, t := range c.templates {
+		if tmpl = t.tmpl.Lookup(name); tmpl != nil {
+			break
+		}
+	}
+	if tmpl == nil {
+		return 0, fmt.Errorf("template '%s' not found", name)
+	}
+	if c.shardHashes == nil {
+		c.shardHashes = map[string][sha1.Size]byte{}
+	}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs []error
+	written := 0
+	for i := range data {
+		wg.Add(1)
+		go func(data interface{}, output string) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, data)
+			if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+			hash := sha1.Sum(buf.Bytes())
+			mutex.Lock()
+			changed := c.shardHashes[output] != hash
+			mutex.Unlock()
+			if err == nil && changed {
+				err = ioutil.WriteFile(output, buf.Bytes(), 0644)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cannot write %s: %v", output, err))
+				delete(c.shardHashes, output)
+			} else if changed {
+				c.shardHashes[output] = hash
+				written++
+			}
+		}(data[i], outputs[i])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return written, errs[0]
+	}
+	return written, nil
+}
+
 type template struct {
 	tmpl        *gotemplate.Template
 	output      string
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,6 +53,71 @@ func TestNewTemplateFileNotFound(t *testing.T) {
 	}
 }
 
+func TestNewTemplatePartials(t *testing.T) {
+	testCases := []struct {
+		partials []string
+		expected string
+		err      string
+	}{
+		// 0
+		{
+			expected: "a:1;b:1;",
+		},
+		// 1
+		{
+			partials: []string{`{{ define "a" }}A:{{ . }};{{ end }}`},
+			expected: "A:1;b:1;",
+		},
+		// 2
+		{
+			partials: []string{
+				`{{ define "a" }}A:{{ . }};{{ end }}`,
+				`{{ define "b" }}B:{{ . }};{{ end }}`,
+			},
+			expected: "A:1;B:1;",
+		},
+		// 3
+		{
+			partials: []string{`{{ define "a" }}A:{{ . }};{{ end }}{{ define "c" }}{{ end }}`},
+			err:      "template 'c' not found in h.tmpl",
+		},
+		// 4
+		{
+			partials: []string{`{{ define "a" }}A:{{ . }{{ end }}`},
+			err:      "cannot read partial template file",
+		},
+	}
+	for i, test := range testCases {
+		c := setup(t)
+		file := c.tempdir + "/h.tmpl"
+		output := c.tempdir + "/h.cfg"
+		if err := ioutil.WriteFile(file, []byte(`{{ template "a" . }}{{ template "b" . }}{{ define "a" }}a:{{ . }};{{ end }}{{ define "b" }}b:{{ . }};{{ end }}`), 0644); err != nil {
+			t.Errorf("error writing template file: %v", err)
+		}
+		var partials []string
+		for j, content := range test.partials {
+			partial := fmt.Sprintf("%s/p%d.tmpl", c.tempdir, j)
+			if err := ioutil.WriteFile(partial, []byte(content), 0644); err != nil {
+				t.Errorf("error writing partial file: %v", err)
+			}
+			partials = append(partials, partial)
+		}
+		err := c.templateConfig.NewTemplate("h.tmpl", file, output, 0, 1024, partials...)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %d: expected error containing '%s', but found '%v'", i, test.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("test %d: error parsing template: %v", i, err)
+		} else if err := c.templateConfig.Write(1); err != nil {
+			t.Errorf("test %d: error writing template: %v", i, err)
+		} else if cnt, _ := ioutil.ReadFile(output); string(cnt) != test.expected {
+			t.Errorf("test %d: expected '%s', but found '%s'", i, test.expected, string(cnt))
+		}
+		c.teardown()
+	}
+}
+
 func TestWrite(t *testing.T) {
 	type tmplContent struct {
 		content string
@@ -260,6 +326,58 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWriteShards(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+	c.newTemplate(`main{{ define "shard" }}{{ range . }}{{ . }};{{ end }}{{ end }}`, 0)
+	outputs := []string{
+		c.tempdir + "/shard1.cfg",
+		c.tempdir + "/shard2.cfg",
+	}
+	testCases := []struct {
+		data     []interface{}
+		written  int
+		expected []string
+	}{
+		// 0
+		{
+			data:     []interface{}{[]string{"a", "b"}, []string{"c"}},
+			written:  2,
+			expected: []string{"a;b;\n", "c;\n"},
+		},
+		// 1
+		{
+			data:     []interface{}{[]string{"a", "b"}, []string{"c"}},
+			written:  0,
+			expected: []string{"a;b;\n", "c;\n"},
+		},
+		// 2
+		{
+			data:     []interface{}{[]string{"a"}, []string{"c"}},
+			written:  1,
+			expected: []string{"a;\n", "c;\n"},
+		},
+	}
+	for i, test := range testCases {
+		written, err := c.templateConfig.WriteShards("shard", test.data, outputs)
+		if err != nil {
+			t.Errorf("test %d: error writing shards: %v", i, err)
+		}
+		if written != test.written {
+			t.Errorf("test %d: expected %d written shards but was %d", i, test.written, written)
+		}
+		for j, output := range outputs {
+			cnt, _ := ioutil.ReadFile(output)
+			if string(cnt) != test.expected[j] {
+				t.Errorf("test %d: expected content '%s' on shard %d, but found '%s'", i, test.expected[j], j, string(cnt))
+			}
+		}
+	}
+	if _, err := c.templateConfig.WriteShards("notfound", nil, nil); err == nil {
+		t.Errorf("expected error writing a missing template")
+	}
+}
+
 func (c *testConfig) newTemplate(content string, rotate int) {
 	cnt := len(c.templateConfig.templates) + 1
 	templateFileName := fmt.Sprintf("h%d.tmpl", cnt)
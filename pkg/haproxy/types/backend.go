@@ -37,6 +37,16 @@ func (b *Backend) NewEndpoint(ip string, port int, targetRef string) *Endpoint {
 	return endpoint
 }
 
+// FindEndpoint ...
+func (b *Backend) FindEndpoint(name string) *Endpoint {
+	for _, endpoint := range b.Endpoints {
+		if endpoint.Name == name {
+			return endpoint
+		}
+	}
+	return nil
+}
+
 // AddPath ...
 func (b *Backend) AddPath(path string) {
 	for _, p := range b.Paths {
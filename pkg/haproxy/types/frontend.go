@@ -108,6 +108,28 @@ func (fg *FrontendGroup) HasTCPProxy() bool {
 	return fg.HasSSLPassthrough || len(fg.Frontends) > 1 || len(fg.Frontends[0].Binds) > 1
 }
 
+// Listen returns the addresses and options of a bind keyword. IPv6 sockets
+// don't accept IPv4 connections, so IPv4 clients of a dual-stack bind are
+// logged with their IPv4 address instead of a IPv4-mapped IPv6 one.
+func (b BindAddress) Listen(port int) string {
+	if len(b) == 0 {
+		return fmt.Sprintf(":%d", port)
+	}
+	addrs := make([]string, len(b))
+	var v6 bool
+	for i, addr := range b {
+		addrs[i] = fmt.Sprintf("%s:%d", addr, port)
+		if strings.Contains(addr, ":") {
+			v6 = true
+		}
+	}
+	listen := strings.Join(addrs, ",")
+	if v6 {
+		listen += " v6only"
+	}
+	return listen
+}
+
 // String ...
 func (f *Frontend) String() string {
 	return fmt.Sprintf("%+v", *f)
@@ -123,6 +145,16 @@ func (f *Frontend) HasTLSAuth() bool {
 	return false
 }
 
+// HasQUIC ...
+func (f *Frontend) HasQUIC() bool {
+	for _, bind := range f.Binds {
+		if bind.QUIC {
+			return true
+		}
+	}
+	return false
+}
+
 // HasInvalidErrorPage ...
 func (f *Frontend) HasInvalidErrorPage() bool {
 	for _, host := range f.Hosts {
@@ -233,8 +265,10 @@ func newFrontend(host *Host) *Frontend {
 func newFrontendBind(host *Host) *BindConfig {
 	return &BindConfig{
 		TLS: BindTLSConfig{
-			CAFilename: host.TLS.CAFilename,
-			CAHash:     host.TLS.CAHash,
+			CAFilename:  host.TLS.CAFilename,
+			CAHash:      host.TLS.CAHash,
+			CRLFilename: host.TLS.CRLFilename,
+			CRLHash:     host.TLS.CRLHash,
 		},
 	}
 }
@@ -247,5 +281,5 @@ func (f *Frontend) match(host *Host) bool {
 }
 
 func (b *BindConfig) match(host *Host) bool {
-	return b.TLS.CAHash == host.TLS.CAHash
+	return b.TLS.CAHash == host.TLS.CAHash && b.TLS.CRLHash == host.TLS.CRLHash
 }
@@ -208,3 +208,41 @@ func TestBuildSSLPassthrough(t *testing.T) {
 		}
 	}
 }
+
+func TestBindAddressListen(t *testing.T) {
+	testCases := []struct {
+		addr     BindAddress
+		expected string
+	}{
+		// 0
+		{
+			addr:     nil,
+			expected: ":80",
+		},
+		// 1
+		{
+			addr:     BindAddress{"10.0.0.1"},
+			expected: "10.0.0.1:80",
+		},
+		// 2
+		{
+			addr:     BindAddress{"::"},
+			expected: ":::80 v6only",
+		},
+		// 3
+		{
+			addr:     BindAddress{"", "::"},
+			expected: ":80,:::80 v6only",
+		},
+		// 4
+		{
+			addr:     BindAddress{"10.0.0.1", "2001:db8::1"},
+			expected: "10.0.0.1:80,2001:db8::1:80 v6only",
+		},
+	}
+	for i, test := range testCases {
+		if listen := test.addr.Listen(80); listen != test.expected {
+			t.Errorf("listen differs on %d: expected '%s' but was '%s'", i, test.expected, listen)
+		}
+	}
+}
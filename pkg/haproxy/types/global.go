@@ -16,7 +16,12 @@ limitations under the License.
 
 package types
 
-// BindUtils ...
-type BindUtils interface {
-	CreateX509CertsDir(bindName string, certs []string) (string, error)
+// FindResolver ...
+func (dns *DNSConfig) FindResolver(name string) *DNSResolver {
+	for _, resolver := range dns.Resolvers {
+		if resolver.Name == name {
+			return resolver
+		}
+	}
+	return nil
 }
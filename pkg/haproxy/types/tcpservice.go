@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (p *TCPServicePort) String() string {
+	return fmt.Sprintf("%+v", *p)
+}
+
+// AddService adds a service to the port. An empty sni declares the
+// default service of the port.
+func (p *TCPServicePort) AddService(sni string, backend *Backend) *TCPService {
+	service := &TCPService{
+		SNI:     sni,
+		Backend: backend,
+	}
+	p.Services = append(p.Services, service)
+	sort.Slice(p.Services, func(i, j int) bool {
+		return p.Services[i].SNI < p.Services[j].SNI
+	})
+	return service
+}
+
+// FindService ...
+func (p *TCPServicePort) FindService(sni string) *TCPService {
+	for _, service := range p.Services {
+		if service.SNI == sni {
+			return service
+		}
+	}
+	return nil
+}
+
+// DefaultService returns the service used when the SNI extension
+// doesn't match any service, nil if the port doesn't have one
+func (p *TCPServicePort) DefaultService() *TCPService {
+	return p.FindService("")
+}
+
+// HasTLS returns true if HAProxy terminates the TLS connections of the port
+func (p *TCPServicePort) HasTLS() bool {
+	for _, service := range p.Services {
+		if service.TLSFilename != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TCPService) String() string {
+	return fmt.Sprintf("%+v", *s)
+}
@@ -18,6 +18,8 @@ package types
 
 // Global ...
 type Global struct {
+	Acme            AcmeConfig
+	Bind            GlobalBindConfig
 	Procs           ProcsConfig
 	Syslog          SyslogConfig
 	MaxConn         int
@@ -25,14 +27,132 @@ type Global struct {
 	SSL             SSLConfig
 	ModSecurity     ModSecurityConfig
 	Cookie          CookieConfig
+	DNS             DNSConfig
 	DrainSupport    DrainConfig
-	ForwardFor      string
+	DynamicScaling  DynamicScalingConfig
+	Peers           PeersConfig
 	LoadServerState bool
+	LocalZone       string
+	LuaScripts      []LuaScript
+	SPOEAgents      []*SPOEAgent
+	RuntimeAPI      RuntimeAPIConfig
+	Stats           StatsConfig
 	StatsSocket     string
+	TrustedProxies  []string
 	CustomConfig    []string
 	CustomDefaults  []string
 }
 
+// AcmeConfig ...
+type AcmeConfig struct {
+	Enabled     bool
+	Emails      string
+	Endpoint    string
+	Expiring    int
+	Prefix      string
+	Socket      string
+	TermsAgreed bool
+}
+
+// GlobalBindConfig ...
+type GlobalBindConfig struct {
+	HTTPAddr           BindAddress
+	TCPAddr            BindAddress
+	HTTPAcceptProxy    bool
+	HTTPSAcceptProxy   bool
+	AcceptProxySources []string
+	QUIC               bool
+	QUICAltSvcMaxAge   int
+	QUICAltSvcPort     int
+}
+
+// BindAddress is a list of IPv4 and IPv6 addresses of a bind keyword, an
+// empty address or an empty list listens on all the IPv4 addresses
+type BindAddress []string
+
+// DNSConfig ...
+type DNSConfig struct {
+	Resolvers           []*DNSResolver
+	AcceptedPayloadSize int
+	HoldObsolete        string
+	HoldValid           string
+	TimeoutRetry        string
+}
+
+// DNSResolver ...
+type DNSResolver struct {
+	Name            string
+	Nameservers     []*DNSNameserver
+	ParseResolvConf bool
+}
+
+// DNSNameserver ...
+type DNSNameserver struct {
+	Name     string
+	Endpoint string
+}
+
+// LuaScript ...
+type LuaScript struct {
+	Filename string
+	SHA1Hash string
+}
+
+// PeersConfig ...
+type PeersConfig struct {
+	Name      string
+	LocalPeer string
+	Peers     []*Peer
+}
+
+// Peer ...
+type Peer struct {
+	Name     string
+	Endpoint string
+}
+
+// SPOEAgent ...
+type SPOEAgent struct {
+	Name      string
+	Endpoints []string
+	Messages  []*SPOEMessage
+	Timeout   SPOETimeoutConfig
+	VarPrefix string
+}
+
+// SPOEMessage ...
+type SPOEMessage struct {
+	Name  string
+	Args  string
+	Event string
+}
+
+// SPOETimeoutConfig ...
+type SPOETimeoutConfig struct {
+	Hello      string
+	Idle       string
+	Processing string
+}
+
+// RuntimeAPIConfig ...
+type RuntimeAPIConfig struct {
+	Port      int
+	Whitelist []string
+}
+
+// StatsConfig ...
+type StatsConfig struct {
+	AcceptProxy bool
+	Admin       bool
+	Auth        string
+	BindAddr    BindAddress
+	Port        int
+	TLSFilename string
+	TLSHash     string
+	URI         string
+	Userlist    string
+}
+
 // ProcsConfig ...
 type ProcsConfig struct {
 	Nbproc          int
@@ -44,6 +164,9 @@ type ProcsConfig struct {
 	CPUMap          string
 }
 
+// DefaultHTTPLogFormat is the log format used by `option httplog`
+const DefaultHTTPLogFormat = `%ci:%cp [%tr] %ft %b/%s %TR/%Tw/%Tc/%Tr/%Ta %ST %B %CC %CS %tsc %ac/%fc/%bc/%sc/%rc %sq/%bq %hr %hs %{+Q}r`
+
 // SyslogConfig ...
 type SyslogConfig struct {
 	Endpoint       string
@@ -63,12 +186,15 @@ type TimeoutConfig struct {
 
 // SSLConfig ...
 type SSLConfig struct {
-	DHParam       DHParamConfig
-	Ciphers       string
-	Options       string
-	Engine        string
-	ModeAsync     bool
-	HeadersPrefix string
+	ALPN              string
+	DHParam           DHParamConfig
+	Ciphers           string
+	Options           string
+	Engine            string
+	ModeAsync         bool
+	OCSPUpdate        bool
+	HeadersPrefix     string
+	CertRuntimeUpdate bool
 }
 
 // DHParamConfig ...
@@ -94,6 +220,12 @@ type DrainConfig struct {
 	Redispatch bool
 }
 
+// DynamicScalingConfig ...
+type DynamicScalingConfig struct {
+	Enabled        bool
+	SlotsIncrement int
+}
+
 // ModSecurityTimeoutConfig ...
 type ModSecurityTimeoutConfig struct {
 	Hello      string
@@ -124,7 +256,10 @@ type HostsMaps struct {
 type FrontendGroup struct {
 	Frontends []*Frontend
 	//
-	HasSSLPassthrough bool
+	HasSSLPassthrough    bool
+	HTTPLogFormat        string
+	CaptureRequestSlots  int
+	CaptureResponseSlots int
 	//
 	Maps              *HostsMaps
 	HTTPFrontsMap     *HostsMap
@@ -150,6 +285,7 @@ type Frontend struct {
 	TLSNoCrtErrorList          *HostsMap
 	TLSNoCrtErrorPagesMap      *HostsMap
 	VarNamespaceMap            *HostsMap
+	HSTSMap                    *HostsMap
 }
 
 // BindConfig ...
@@ -159,18 +295,23 @@ type BindConfig struct {
 	Hosts  []*Host
 	//
 	AcceptProxy bool
+	ExpectProxy bool
+	QUIC        bool
 	TLS         BindTLSConfig
 	//
 	Maps          *HostsMaps
 	UseServerList *HostsMap
+	CrtList       *HostsMap
 }
 
 // BindTLSConfig ...
 type BindTLSConfig struct {
-	CAFilename string
-	CAHash     string
-	TLSCert    string
-	TLSCertDir string
+	CAFilename  string
+	CAHash      string
+	CRLFilename string
+	CRLHash     string
+	TLSCert     string
+	TLSCertDir  string
 }
 
 // Host ...
@@ -202,6 +343,9 @@ type HostPath struct {
 	Path      string
 	Backend   *Backend
 	BackendID string
+	// HSTS overrides the HSTS config of the backend on this path, nil
+	// means that the backend config is used
+	HSTS *HSTS
 }
 
 // HostAliasConfig ...
@@ -222,6 +366,13 @@ type HostTLSConfig struct {
 	CAFilename       string
 	CAHash           string
 	CAVerifyOptional bool
+	CRLFilename      string
+	CRLHash          string
+	ALPN             string
+	Ciphers          string
+	CipherSuites     string
+	MinVersion       string
+	MaxVersion       string
 	TLSFilename      string
 	TLSHash          string
 }
@@ -236,29 +387,57 @@ type Backend struct {
 	//
 	AgentCheck        AgentCheck
 	BalanceAlgorithm  string
+	BandwidthLimit    BandwidthLimit
+	Cache             CacheConfig
+	Compression       CompressionConfig
 	Cookie            Cookie
 	Cors              Cors
 	CustomConfig      []string
+	DNSSRV            DNSSRVConfig
+	EmptySlots        int
+	ForwardedHeaders  ForwardedHeadersConfig
+	ForwardFor        string
+	HashBalanceFactor int
+	HashType          string
 	HealthCheck       HealthCheck
 	HSTS              HSTS
+	HTTPBufferRequest bool
+	HTTPConnMode      string
+	HTTPReuse         string
+	LogCapture        LogCaptureConfig
+	LogFields         string
+	Lua               LuaActionsConfig
 	MaxConnServer     int
 	MaxQueueServer    int
 	ModeTCP           bool
 	OAuth             OAuthConfig
 	Paths             []string
+	PoolPurgeDelay    string
+	PreferLastServer  bool
+	ProtoH2           bool
 	ProxyBodySize     string
+	RateLimit         RateLimitConfig
+	Resolver          string
+	Retry             RetryConfig
 	RewriteURL        string
+	SecurityExempt    SecurityExemptConfig
 	SendProxyProtocol string
+	ServerNaming      string
+	SPOEFilters       []string
+	SSE               bool
 	SSL               SSLBackendConfig
 	SSLRedirect       bool
+	StripPath         StripPathConfig
 	Timeout           BackendTimeoutConfig
 	Userlist          UserlistConfig
+	Vars              []BackendVar
 	WAF               string
 	Whitelist         []string
 }
 
 // Endpoint ...
 type Endpoint struct {
+	Backup    bool
 	Disabled  bool
 	IP        string
 	Name      string
@@ -267,6 +446,57 @@ type Endpoint struct {
 	Weight    int
 }
 
+// CacheConfig ...
+type CacheConfig struct {
+	Name          string
+	MaxAge        int
+	MaxObjectSize int64
+	TotalMaxSize  int64
+}
+
+// CompressionConfig ...
+type CompressionConfig struct {
+	Algo    string
+	MinSize int64
+	Types   []string
+}
+
+// ForwardedHeadersConfig ...
+type ForwardedHeadersConfig struct {
+	Host  bool
+	Port  bool
+	Proto bool
+}
+
+// LogCaptureConfig ...
+type LogCaptureConfig struct {
+	RequestHeaders  []string
+	ResponseHeaders []string
+}
+
+// LuaActionsConfig ...
+type LuaActionsConfig struct {
+	RequestActions  []string
+	ResponseActions []string
+}
+
+// RateLimitConfig ...
+type RateLimitConfig struct {
+	Limit     int
+	Period    string
+	Peers     string
+	Tables    []string
+	Track     string
+	Whitelist []string
+}
+
+// DNSSRVConfig ...
+type DNSSRVConfig struct {
+	Record   string
+	Resolver string
+	Slots    int
+}
+
 // AgentCheck ...
 type AgentCheck struct {
 	Addr     string
@@ -275,6 +505,14 @@ type AgentCheck struct {
 	Send     string
 }
 
+// BandwidthLimit ...
+type BandwidthLimit struct {
+	Download string
+	Upload   string
+	Period   string
+	PerSrc   bool
+}
+
 // HealthCheck ...
 type HealthCheck struct {
 	Addr      string
@@ -290,10 +528,46 @@ type OAuthConfig struct {
 	BackendName string
 	URIPrefix   string
 	Headers     map[string]string
+	// SkipPaths match exactly, SkipPrefixes are path_beg and end with a slash
+	SkipPaths    []string
+	SkipPrefixes []string
+}
+
+// SecurityExemptConfig ...
+type SecurityExemptConfig struct {
+	Exact  []string
+	Prefix []string
+}
+
+// BackendVar ...
+type BackendVar struct {
+	Name string
+	Expr string
+}
+
+// StripPathConfig ...
+type StripPathConfig struct {
+	Regex       string
+	Match       string
+	Headers     []StripPathHeader
+	ReplacePath bool
+}
+
+// StripPathHeader ...
+type StripPathHeader struct {
+	Name  string
+	Field int
+}
+
+// RetryConfig ...
+type RetryConfig struct {
+	On      []string
+	Retries int
 }
 
 // SSLBackendConfig ...
 type SSLBackendConfig struct {
+	ALPN          string
 	HasTLSAuth    bool
 	AddCertHeader bool
 	IsSecure      bool
@@ -315,8 +589,9 @@ type BackendTimeoutConfig struct {
 }
 
 type UserlistConfig struct {
-	Name  string
-	Realm string
+	Name   string
+	Realm  string
+	Groups []string
 }
 
 // Cookie ...
@@ -348,8 +623,15 @@ type HSTS struct {
 
 // Userlist ...
 type Userlist struct {
+	Name   string
+	Groups []UserGroup
+	Users  []User
+}
+
+// UserGroup ...
+type UserGroup struct {
 	Name  string
-	Users []User
+	Users []string
 }
 
 // User ...
@@ -358,3 +640,27 @@ type User struct {
 	Passwd    string
 	Encrypted bool
 }
+
+// TCPServicePort is a TCP port whose streams are routed to one of its
+// services, either the service of the SNI extension of the TLS handshake
+// or the default service of the port.
+type TCPServicePort struct {
+	Port          int
+	Services      []*TCPService
+	AcceptProxy   bool
+	TimeoutClient string
+	//
+	TLSCert string
+	Maps    *HostsMaps
+	SNIMap  *HostsMap
+	CrtList *HostsMap
+}
+
+// TCPService ...
+type TCPService struct {
+	Source      string
+	SNI         string
+	Backend     *Backend
+	TLSFilename string
+	TLSHash     string
+}
@@ -23,3 +23,13 @@ import (
 func (u *Userlist) String() string {
 	return fmt.Sprintf("%+v", *u)
 }
+
+// HasGroup ...
+func (u *Userlist) HasGroup(name string) bool {
+	for _, group := range u.Groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
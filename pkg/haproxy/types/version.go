@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var versionRegex = regexp.MustCompile(`(?:HA-?Proxy version |(?m:^)Version: |^)([0-9]+)\.([0-9]+)`)
+
+// Version is the major and minor version of the HAProxy binary.
+// The zero value means an unknown version.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion reads the version from the output of `haproxy -v`, from the
+// output of the `show info` runtime command, or from a version number, eg 2.4
+func ParseVersion(out string) (Version, error) {
+	match := versionRegex.FindStringSubmatch(out)
+	if match == nil {
+		return Version{}, fmt.Errorf("version not found: %s", out)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return Version{Major: major, Minor: minor}, nil
+}
+
+// AtLeast ...
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (v Version) String() string {
+	if v.Major == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected Version
+		expErr   bool
+	}{
+		// 0
+		{
+			out:      "HA-Proxy version 1.8.20 2019/04/25\nCopyright 2000-2019 Willy Tarreau <willy@haproxy.org>\n",
+			expected: Version{Major: 1, Minor: 8},
+		},
+		// 1
+		{
+			out:      "HAProxy version 2.6.6-274d1a4 2022/09/22 - https://haproxy.org/\n",
+			expected: Version{Major: 2, Minor: 6},
+		},
+		// 2
+		{
+			out:      "Name: HAProxy\nVersion: 2.4.22-f8e3218\nRelease_date: 2023/02/14\n",
+			expected: Version{Major: 2, Minor: 4},
+		},
+		// 3
+		{
+			out:      "2.2",
+			expected: Version{Major: 2, Minor: 2},
+		},
+		// 4
+		{
+			out:    "haproxy: not found",
+			expErr: true,
+		},
+		// 5
+		{
+			out:    "v2.4",
+			expErr: true,
+		},
+	}
+	for i, test := range testCases {
+		version, err := ParseVersion(test.out)
+		if (err != nil) != test.expErr {
+			t.Errorf("error on %d differs - expected error: %t - actual: %v", i, test.expErr, err)
+		}
+		if version != test.expected {
+			t.Errorf("version on %d differs - expected: %v - actual: %v", i, test.expected, version)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	v := Version{Major: 2, Minor: 6}
+	for _, test := range []struct {
+		major, minor int
+		expected     bool
+	}{
+		{1, 8, true},
+		{2, 0, true},
+		{2, 6, true},
+		{2, 7, false},
+		{3, 0, false},
+	} {
+		if v.AtLeast(test.major, test.minor) != test.expected {
+			t.Errorf("%v.AtLeast(%d, %d) should be %t", v, test.major, test.minor, test.expected)
+		}
+	}
+	if (Version{}).String() != "unknown" || v.String() != "2.6" {
+		t.Errorf("unexpected version strings: %v %v", Version{}, v)
+	}
+}
@@ -25,6 +25,16 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(controller.RunCheck(os.Args[2:]))
+		case "nginx-compat":
+			os.Exit(controller.RunNginxCompat(os.Args[2:]))
+		case "runtime":
+			os.Exit(controller.RunRuntime(os.Args[2:]))
+		}
+	}
 	hc := controller.NewHAProxyController()
 	errCh := make(chan error)
 	go handleSignal(hc, errCh)
@@ -0,0 +1,299 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocsp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Certificate status of an OCSP response
+const (
+	Good = iota
+	Revoked
+	Unknown
+)
+
+// Response is a verified OCSP response of a single certificate, see RFC 6960.
+// Raw has the DER encoded response as sent by the responder, which is the
+// content stapled by HAProxy.
+type Response struct {
+	Status     int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Raw        []byte
+}
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	signatureAlgorithms = []struct {
+		oid  asn1.ObjectIdentifier
+		algo x509.SignatureAlgorithm
+	}{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	}
+)
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type request struct {
+	TBSRequest tbsRequest
+}
+
+type tbsRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []singleRequest
+}
+
+type singleRequest struct {
+	Cert certID
+}
+
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"explicit,tag:0,default:0,optional"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []singleResponse
+	Extensions     []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    revokedInfo      `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// CreateRequest creates the DER encoded OCSP request of crt, which should be
+// signed by issuer. The SHA1 hash algorithm is used to identify the issuer,
+// which is the one supported by all the responders.
+func CreateRequest(crt, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(crt, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(request{
+		TBSRequest: tbsRequest{
+			RequestList: []singleRequest{{Cert: *id}},
+		},
+	})
+}
+
+func newCertID(crt, issuer *x509.Certificate) (*certID, error) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("error reading public key of the issuer: %v", err)
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return &certID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSHA1,
+			Parameters: asn1.RawValue{Tag: asn1.TagNull},
+		},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   crt.SerialNumber,
+	}, nil
+}
+
+// ParseResponse parses a DER encoded OCSP response and returns the status of
+// crt. The response should be signed by issuer or by a delegated responder
+// whose certificate is signed by issuer.
+func ParseResponse(der []byte, crt, issuer *x509.Certificate) (*Response, error) {
+	basic, single, err := parseSingleResponse(der, crt)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(basic, issuer); err != nil {
+		return nil, err
+	}
+	id, err := newCertID(crt, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if single.CertID.HashAlgorithm.Algorithm.Equal(oidSHA1) &&
+		(!bytes.Equal(single.CertID.IssuerNameHash, id.IssuerNameHash) || !bytes.Equal(single.CertID.IssuerKeyHash, id.IssuerKeyHash)) {
+		return nil, fmt.Errorf("OCSP response refers to another issuer")
+	}
+	resp := &Response{
+		ThisUpdate: single.ThisUpdate,
+		NextUpdate: single.NextUpdate,
+		Raw:        der,
+	}
+	switch {
+	case bool(single.Good):
+		resp.Status = Good
+	case bool(single.Unknown):
+		resp.Status = Unknown
+	default:
+		resp.Status = Revoked
+	}
+	return resp, nil
+}
+
+// MatchSerial returns true if der is an OCSP response with the status of
+// crt. The signature of the response isn't verified.
+func MatchSerial(der []byte, crt *x509.Certificate) bool {
+	_, _, err := parseSingleResponse(der, crt)
+	return err == nil
+}
+
+func parseSingleResponse(der []byte, crt *x509.Certificate) (*basicResponse, *singleResponse, error) {
+	var resp responseASN1
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, nil, fmt.Errorf("error parsing OCSP response: %v", err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("trailing data in OCSP response")
+	}
+	if resp.Status != 0 {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidBasicResponse) {
+		return nil, nil, fmt.Errorf("unsupported OCSP response type: %v", resp.Response.ResponseType)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, nil, fmt.Errorf("error parsing basic OCSP response: %v", err)
+	}
+	for i := range basic.TBSResponseData.Responses {
+		single := &basic.TBSResponseData.Responses[i]
+		if single.CertID.SerialNumber != nil && single.CertID.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+			return &basic, single, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("OCSP response doesn't have the status of serial %x", crt.SerialNumber)
+}
+
+func verifySignature(basic *basicResponse, issuer *x509.Certificate) error {
+	algo := x509.UnknownSignatureAlgorithm
+	for _, sig := range signatureAlgorithms {
+		if sig.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = sig.algo
+			break
+		}
+	}
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported OCSP signature algorithm: %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	signed := basic.TBSResponseData.Raw
+	signature := basic.Signature.RightAlign()
+	if issuer.CheckSignature(algo, signed, signature) == nil {
+		return nil
+	}
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			continue
+		}
+		if !hasOCSPSigning(responder) || responder.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		if responder.CheckSignature(algo, signed, signature) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("OCSP response isn't signed by the issuer or by a delegated responder")
+}
+
+func hasOCSPSigning(crt *x509.Certificate) bool {
+	for _, usage := range crt.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch sends the OCSP request of crt to the first responder declared in the
+// certificate and returns its verified response.
+func Fetch(client *http.Client, crt, issuer *x509.Certificate) (*Response, error) {
+	if len(crt.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate doesn't declare an OCSP responder")
+	}
+	req, err := CreateRequest(crt, issuer)
+	if err != nil {
+		return nil, err
+	}
+	url := crt.OCSPServer[0]
+	resp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned HTTP status %d", url, resp.StatusCode)
+	}
+	return ParseResponse(body, crt, issuer)
+}
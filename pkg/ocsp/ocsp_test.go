@@ -0,0 +1,286 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ocsp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	t   *testing.T
+	crt *x509.Certificate
+	key *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	crt, _ := x509.ParseCertificate(der)
+	return &testCA{t: t, crt: crt, key: key}
+}
+
+func (ca *testCA) issue(serial int64, ocspServer string, usage ...x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "d1.local"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  usage,
+	}
+	if ocspServer != "" {
+		tmpl.OCSPServer = []string{ocspServer}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.crt, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatalf("error creating certificate: %v", err)
+	}
+	crt, _ := x509.ParseCertificate(der)
+	return crt, key
+}
+
+type responseOptions struct {
+	status     asn1.Enumerated
+	serial     *big.Int
+	good       bool
+	revoked    bool
+	signer     *ecdsa.PrivateKey
+	responders []*x509.Certificate
+}
+
+func (ca *testCA) response(issuer *x509.Certificate, opt responseOptions) []byte {
+	if opt.status != 0 {
+		der, _ := asn1.Marshal(responseASN1{Status: opt.status})
+		return der
+	}
+	id, err := newCertID(&x509.Certificate{SerialNumber: opt.serial}, issuer)
+	if err != nil {
+		ca.t.Fatalf("error creating cert id: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	single := singleResponse{
+		CertID:     *id,
+		ThisUpdate: now,
+		NextUpdate: now.Add(4 * 24 * time.Hour),
+	}
+	if opt.revoked {
+		single.Revoked = revokedInfo{RevocationTime: now}
+	} else if opt.good {
+		single.Good = true
+	} else {
+		single.Unknown = true
+	}
+	tbs, err := asn1.Marshal(responseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: issuer.RawSubject},
+		ProducedAt:     now,
+		Responses:      []singleResponse{single},
+	})
+	if err != nil {
+		ca.t.Fatalf("error marshaling response data: %v", err)
+	}
+	signer := opt.signer
+	if signer == nil {
+		signer = ca.key
+	}
+	hash := sha256.Sum256(tbs)
+	signature, _ := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	var certs []asn1.RawValue
+	for _, crt := range opt.responders {
+		certs = append(certs, asn1.RawValue{FullBytes: crt.Raw})
+	}
+	basic, err := asn1.Marshal(basicResponse{
+		TBSResponseData:    responseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithms[5].oid},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+		Certificates:       certs,
+	})
+	if err != nil {
+		ca.t.Fatalf("error marshaling basic response: %v", err)
+	}
+	der, _ := asn1.Marshal(responseASN1{
+		Response: responseBytes{ResponseType: oidBasicResponse, Response: basic},
+	})
+	return der
+}
+
+func TestCreateRequest(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	crt, _ := ca.issue(10, "")
+	der, err := CreateRequest(crt, ca.crt)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	var req request
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		t.Fatalf("error parsing request: %v", err)
+	}
+	if len(req.TBSRequest.RequestList) != 1 {
+		t.Fatalf("expected 1 request, found %d", len(req.TBSRequest.RequestList))
+	}
+	id := req.TBSRequest.RequestList[0].Cert
+	if id.SerialNumber.Int64() != 10 {
+		t.Errorf("expected serial 10, found %v", id.SerialNumber)
+	}
+	if !id.HashAlgorithm.Algorithm.Equal(oidSHA1) || len(id.IssuerNameHash) != 20 || len(id.IssuerKeyHash) != 20 {
+		t.Errorf("unexpected cert id: %+v", id)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	crt, _ := ca.issue(10, "")
+	responder, responderKey := ca.issue(20, "", x509.ExtKeyUsageOCSPSigning)
+	noUsage, noUsageKey := ca.issue(21, "")
+	testCases := []struct {
+		issuer    *x509.Certificate
+		opt       responseOptions
+		expStatus int
+		expError  string
+	}{
+		// 0
+		{
+			opt:       responseOptions{serial: big.NewInt(10), good: true},
+			expStatus: Good,
+		},
+		// 1
+		{
+			opt:       responseOptions{serial: big.NewInt(10), revoked: true},
+			expStatus: Revoked,
+		},
+		// 2
+		{
+			opt:       responseOptions{serial: big.NewInt(10)},
+			expStatus: Unknown,
+		},
+		// 3
+		{
+			opt:      responseOptions{status: 6},
+			expError: "OCSP responder returned status 6",
+		},
+		// 4
+		{
+			opt:      responseOptions{serial: big.NewInt(11), good: true},
+			expError: "OCSP response doesn't have the status of serial a",
+		},
+		// 5
+		{
+			opt:      responseOptions{serial: big.NewInt(10), good: true, signer: other.key},
+			expError: "OCSP response isn't signed by the issuer or by a delegated responder",
+		},
+		// 6
+		{
+			opt:       responseOptions{serial: big.NewInt(10), good: true, signer: responderKey, responders: []*x509.Certificate{responder}},
+			expStatus: Good,
+		},
+		// 7
+		{
+			opt:      responseOptions{serial: big.NewInt(10), good: true, signer: noUsageKey, responders: []*x509.Certificate{noUsage}},
+			expError: "OCSP response isn't signed by the issuer or by a delegated responder",
+		},
+		// 8
+		{
+			issuer:   other.crt,
+			opt:      responseOptions{serial: big.NewInt(10), good: true, signer: ca.key},
+			expError: "OCSP response refers to another issuer",
+		},
+	}
+	for i, test := range testCases {
+		issuer := test.issuer
+		if issuer == nil {
+			issuer = ca.crt
+		}
+		der := ca.response(issuer, test.opt)
+		resp, err := ParseResponse(der, crt, ca.crt)
+		if test.expError != "" {
+			if err == nil || err.Error() != test.expError {
+				t.Errorf("expected error '%s' on %d, found: %v", test.expError, i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error on %d: %v", i, err)
+			continue
+		}
+		if resp.Status != test.expStatus {
+			t.Errorf("expected status %d on %d, found %d", test.expStatus, i, resp.Status)
+		}
+		if resp.NextUpdate.Sub(resp.ThisUpdate) != 4*24*time.Hour {
+			t.Errorf("unexpected update interval on %d: %v - %v", i, resp.ThisUpdate, resp.NextUpdate)
+		}
+		if string(resp.Raw) != string(der) {
+			t.Errorf("raw response differs on %d", i)
+		}
+		if !MatchSerial(der, crt) {
+			t.Errorf("expected serial match on %d", i)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	var response []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req request
+		if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/ocsp-request" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(response)
+	}))
+	defer server.Close()
+	crt, _ := ca.issue(10, server.URL)
+	response = ca.response(ca.crt, responseOptions{serial: big.NewInt(10), good: true})
+	resp, err := Fetch(server.Client(), crt, ca.crt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != Good {
+		t.Errorf("expected good status, found %d", resp.Status)
+	}
+	noServer, _ := ca.issue(11, "")
+	if _, err := Fetch(server.Client(), noServer, ca.crt); err == nil || !strings.Contains(err.Error(), "OCSP responder") {
+		t.Errorf("expected missing responder error, found: %v", err)
+	}
+}
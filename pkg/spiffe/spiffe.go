@@ -0,0 +1,248 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package spiffe is a minimal client of the X.509 part of the SPIFFE
+// Workload API, see https://github.com/spiffe/spiffe/blob/master/standards/SPIFFE_Workload_API.md
+// The gRPC framing and the protobuf messages are encoded by hand, so the
+// client doesn't depend on the gRPC and protobuf libraries.
+package spiffe
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// SVID is a X.509 SPIFFE Verifiable Identity Document and the trust bundle
+// of its trust domain
+type SVID struct {
+	ID           string
+	Certificates []*x509.Certificate
+	// PrivateKey is the PKCS#8 DER encoded private key
+	PrivateKey []byte
+	Bundle     []*x509.Certificate
+}
+
+// CertificatePEM returns the PEM encoded certificate chain followed by the
+// private key, the format used by HAProxy
+func (s *SVID) CertificatePEM() []byte {
+	var out bytes.Buffer
+	for _, crt := range s.Certificates {
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
+	}
+	return out.Bytes()
+}
+
+// PrivateKeyPEM ...
+func (s *SVID) PrivateKeyPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: s.PrivateKey})
+}
+
+// BundlePEM returns the PEM encoded CA certificates of the trust domain
+func (s *SVID) BundlePEM() []byte {
+	var out bytes.Buffer
+	for _, crt := range s.Bundle {
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
+	}
+	return out.Bytes()
+}
+
+// WatchX509SVID connects to the Workload API listening on a unix socket and
+// calls update whenever the agent sends new SVIDs, eg after a rotation.
+// WatchX509SVID blocks until the stream is closed by the agent, stopCh is
+// closed or an error happens.
+func WatchX509SVID(socket string, stopCh <-chan struct{}, update func([]SVID)) error {
+	conn, err := net.Dial("unix", strings.TrimPrefix(socket, "unix://"))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stopCh
+		conn.Close()
+	}()
+	defer conn.Close()
+	cc, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(conn)
+	if err != nil {
+		return err
+	}
+	// empty X509SVIDRequest message
+	req, err := http.NewRequest("POST", "http://localhost/SpiffeWorkloadAPI/FetchX509SVID", bytes.NewReader(grpcFrame(nil)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = 5
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("workload.spiffe.io", "true")
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Workload API returned HTTP status %d", resp.StatusCode)
+	}
+	if err := grpcStatus(resp.Header); err != nil {
+		return err
+	}
+	for {
+		msg, err := readGRPCFrame(resp.Body)
+		if err == io.EOF {
+			if err := grpcStatus(resp.Trailer); err != nil {
+				return err
+			}
+			return fmt.Errorf("Workload API closed the stream")
+		}
+		if err != nil {
+			return err
+		}
+		svids, err := ParseX509SVIDResponse(msg)
+		if err != nil {
+			return err
+		}
+		update(svids)
+	}
+}
+
+func grpcStatus(header http.Header) error {
+	if status := header.Get("grpc-status"); status != "" && status != "0" {
+		return fmt.Errorf("Workload API returned gRPC status %s: %s", status, header.Get("grpc-message"))
+	}
+	return nil
+}
+
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	copy(frame[5:], msg)
+	return frame
+}
+
+func readGRPCFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ParseX509SVIDResponse parses the protobuf encoded X509SVIDResponse message
+func ParseX509SVIDResponse(msg []byte) ([]SVID, error) {
+	var svids []SVID
+	err := parseMessage(msg, func(field int, value []byte) error {
+		if field != 1 {
+			// crl and federated_bundles aren't used
+			return nil
+		}
+		svid, err := parseX509SVID(value)
+		if err != nil {
+			return err
+		}
+		svids = append(svids, *svid)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(svids) == 0 {
+		return nil, fmt.Errorf("Workload API response does not have any SVID")
+	}
+	return svids, nil
+}
+
+func parseX509SVID(msg []byte) (*SVID, error) {
+	svid := &SVID{}
+	err := parseMessage(msg, func(field int, value []byte) (err error) {
+		switch field {
+		case 1:
+			svid.ID = string(value)
+		case 2:
+			svid.Certificates, err = x509.ParseCertificates(value)
+		case 3:
+			svid.PrivateKey = value
+			_, err = x509.ParsePKCS8PrivateKey(value)
+		case 4:
+			svid.Bundle, err = x509.ParseCertificates(value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if svid.ID == "" || len(svid.Certificates) == 0 || len(svid.PrivateKey) == 0 {
+		return nil, fmt.Errorf("incomplete SVID")
+	}
+	return svid, nil
+}
+
+// parseMessage calls field for every length delimited field of a protobuf
+// message, other wire types are skipped
+func parseMessage(msg []byte, field func(field int, value []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf message")
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return fmt.Errorf("invalid protobuf message")
+			}
+			msg = msg[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return fmt.Errorf("invalid protobuf message")
+			}
+			msg = msg[size:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return fmt.Errorf("invalid protobuf message")
+			}
+			value := msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+			if err := field(int(key>>3), value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func createCert(t *testing.T, id string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "spiffe"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if id != "" {
+		u, _ := url.Parse(id)
+		tmpl.URIs = []*url.URL{u}
+	} else {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	crt, _ := x509.ParseCertificate(der)
+	return crt, key
+}
+
+// protoField encodes a length delimited protobuf field
+func protoField(field int, value []byte) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(field<<3|2))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	return append(buf[:n], value...)
+}
+
+func createResponse(t *testing.T, id string) ([]byte, *x509.Certificate, *x509.Certificate) {
+	ca, caKey := createCert(t, "", nil, nil)
+	crt, key := createCert(t, id, ca, caKey)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	var svid []byte
+	svid = append(svid, protoField(1, []byte(id))...)
+	svid = append(svid, protoField(2, crt.Raw)...)
+	svid = append(svid, protoField(3, der)...)
+	svid = append(svid, protoField(4, ca.Raw)...)
+	// varint field, skipped
+	svid = append(svid, 5<<3, 1)
+	var resp []byte
+	resp = append(resp, protoField(1, svid)...)
+	resp = append(resp, protoField(2, []byte("crl"))...)
+	return resp, crt, ca
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	resp, crt, ca := createResponse(t, "spiffe://cluster.local/ns/ingress/sa/haproxy")
+	svids, err := ParseX509SVIDResponse(resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(svids) != 1 {
+		t.Fatalf("expected 1 SVID, found %d", len(svids))
+	}
+	svid := svids[0]
+	if svid.ID != "spiffe://cluster.local/ns/ingress/sa/haproxy" {
+		t.Errorf("unexpected id: %s", svid.ID)
+	}
+	if len(svid.Certificates) != 1 || !svid.Certificates[0].Equal(crt) {
+		t.Errorf("unexpected certificates: %v", svid.Certificates)
+	}
+	if len(svid.Bundle) != 1 || !svid.Bundle[0].Equal(ca) {
+		t.Errorf("unexpected bundle: %v", svid.Bundle)
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(svid.PrivateKey); err != nil {
+		t.Errorf("unexpected private key: %v", err)
+	}
+
+	for i, invalid := range [][]byte{
+		{},
+		{0x0a, 0x10, 0x01},
+		protoField(1, protoField(1, []byte("spiffe://cluster.local/id"))),
+		protoField(1, protoField(2, []byte("invalid"))),
+	} {
+		if _, err := ParseX509SVIDResponse(invalid); err == nil {
+			t.Errorf("expected error on invalid response %d", i)
+		}
+	}
+}
+
+func TestWatchX509SVID(t *testing.T) {
+	resp1, crt1, _ := createResponse(t, "spiffe://cluster.local/id1")
+	resp2, crt2, _ := createResponse(t, "spiffe://cluster.local/id2")
+	dir, _ := ioutil.TempDir("", "spiffe")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	var header http.Header
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		server := &http2.Server{}
+		server.ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			if _, err := readGRPCFrame(r.Body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "grpc-status")
+			w.Write(grpcFrame(resp1))
+			w.(http.Flusher).Flush()
+			w.Write(grpcFrame(resp2))
+			w.Header().Set("grpc-status", "14")
+		})})
+	}()
+
+	var updates [][]SVID
+	err = WatchX509SVID("unix://"+socket, make(chan struct{}), func(svids []SVID) {
+		updates = append(updates, svids)
+	})
+	if err == nil || err.Error() != "Workload API returned gRPC status 14: " {
+		t.Errorf("expected gRPC status error, found: %v", err)
+	}
+	if header.Get("workload.spiffe.io") != "true" {
+		t.Errorf("missing workload.spiffe.io header: %v", header)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("expected 2 updates, found %d", len(updates))
+	}
+	if !updates[0][0].Certificates[0].Equal(crt1) || !updates[1][0].Certificates[0].Equal(crt2) {
+		t.Errorf("unexpected certificates on updates")
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	maxQueuedSpans   = 1024
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+// Tracer builds the spans of the synchronizations and exports them to an
+// OpenTelemetry collector, using the JSON encoding of OTLP over HTTP.
+//
+// A synchronization is started with StartSync(), spans created with Start()
+// are children of the synchronization in progress. Synchronizations are
+// serialized by the sync queue, so only one trace is tracked at a time.
+// A nil Tracer is valid and doesn't trace anything.
+type Tracer struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	mutex    sync.Mutex
+	root     *Span
+	queue    []*Span
+}
+
+// Span is a timed step of a synchronization. A nil Span is valid and ignores all the calls.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      error
+}
+
+// NewTracer creates a tracer which sends spans to the OTLP/HTTP endpoint
+// of a collector, eg http://otel-collector:4318
+func NewTracer(endpoint, service string) *Tracer {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	return &Tracer{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run exports the finished spans every interval until stopCh is closed
+func (t *Tracer) Run(interval time.Duration, stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.flush()
+			case <-stopCh:
+				t.flush()
+				return
+			}
+		}
+	}()
+}
+
+// StartSync starts the root span of a new trace
+func (t *Tracer) StartSync(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := t.newSpan(newID(16), "", name)
+	t.mutex.Lock()
+	t.root = span
+	t.mutex.Unlock()
+	return span
+}
+
+// Start starts a child span of the synchronization in progress,
+// a new trace is started if there isn't a synchronization in progress
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	root := t.root
+	t.mutex.Unlock()
+	if root == nil {
+		return t.newSpan(newID(16), "", name)
+	}
+	return t.newSpan(root.traceID, root.spanID, name)
+}
+
+func (t *Tracer) newSpan(traceID, parentID, name string) *Span {
+	return &Span{
+		tracer:   t,
+		traceID:  traceID,
+		spanID:   newID(8),
+		parentID: parentID,
+		name:     name,
+		start:    time.Now(),
+		attrs:    map[string]interface{}{},
+	}
+}
+
+// SetAttr adds an attribute to the span, values should be strings, ints or bools
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// SetError flags the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+	s.err = err
+}
+
+// End finishes the span and queues it to be exported
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	t := s.tracer
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.root == s {
+		t.root = nil
+	}
+	if len(t.queue) >= maxQueuedSpans {
+		glog.V(2).Infof("tracing queue is full, dropping span '%s'", s.name)
+		return
+	}
+	t.queue = append(t.queue, s)
+}
+
+func (t *Tracer) flush() {
+	t.mutex.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mutex.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		glog.Warningf("error encoding spans: %v", err)
+		return
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		glog.Warningf("error exporting %d spans to %s: %v", len(spans), t.endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		glog.Warningf("error exporting %d spans to %s: %s", len(spans), t.endpoint, resp.Status)
+	}
+}
+
+// The types below are the JSON mapping of the OTLP trace protobuf messages,
+// see opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (t *Tracer) encode(spans []*Span) *exportRequest {
+	data := make([]spanData, len(spans))
+	for i, s := range spans {
+		data[i] = spanData{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttrs(s.attrs),
+		}
+		if s.err != nil {
+			data[i].Status = &status{Code: statusCodeError, Message: s.err.Error()}
+		}
+	}
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: encodeAttrs(map[string]interface{}{"service.name": t.service}),
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: "github.com/jcmoraisjr/haproxy-ingress"},
+				Spans: data,
+			}},
+		}},
+	}
+}
+
+func encodeAttrs(attrs map[string]interface{}) []keyValue {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kv := make([]keyValue, 0, len(attrs))
+	for _, key := range keys {
+		var v anyValue
+		switch value := attrs[key].(type) {
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case bool:
+			v.BoolValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		kv = append(kv, keyValue{Key: key, Value: v})
+	}
+	return kv
+}
+
+func newID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
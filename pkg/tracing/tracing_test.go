@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportSpans(t *testing.T) {
+	var paths []string
+	var req exportRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL+"/", "haproxy-ingress")
+	trace := tracer.StartSync("sync")
+	span := tracer.Start("convert")
+	span.SetAttr("backends", 10)
+	span.End()
+	span = tracer.Start("reload")
+	span.SetError(fmt.Errorf("reload failed"))
+	span.End()
+	trace.End()
+	orphan := tracer.Start("render")
+	orphan.End()
+	tracer.flush()
+	tracer.flush()
+
+	if len(paths) != 1 || paths[0] != "/v1/traces" {
+		t.Fatalf("expected one request to /v1/traces, found %v", paths)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if attrs := req.ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || *attrs[0].Value.StringValue != "haproxy-ingress" {
+		t.Errorf("unexpected resource attributes: %+v", attrs)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, found %d", len(spans))
+	}
+	convert, reload, sync, render := spans[0], spans[1], spans[2], spans[3]
+	testCases := []struct {
+		span   spanData
+		name   string
+		parent string
+		trace  string
+	}{
+		{convert, "convert", sync.SpanID, sync.TraceID},
+		{reload, "reload", sync.SpanID, sync.TraceID},
+		{sync, "sync", "", sync.TraceID},
+	}
+	for _, test := range testCases {
+		if test.span.Name != test.name || test.span.ParentSpanID != test.parent || test.span.TraceID != test.trace {
+			t.Errorf("unexpected span %s: %+v", test.name, test.span)
+		}
+	}
+	if len(sync.TraceID) != 32 || len(sync.SpanID) != 16 {
+		t.Errorf("unexpected id length, trace: %s, span: %s", sync.TraceID, sync.SpanID)
+	}
+	if render.ParentSpanID != "" || render.TraceID == sync.TraceID {
+		t.Errorf("expected a new trace on span out of a sync: %+v", render)
+	}
+	if len(convert.Attributes) != 1 || convert.Attributes[0].Key != "backends" || *convert.Attributes[0].Value.IntValue != "10" {
+		t.Errorf("unexpected attributes: %+v", convert.Attributes)
+	}
+	if reload.Status == nil || reload.Status.Code != statusCodeError || reload.Status.Message != "reload failed" {
+		t.Errorf("expected error status: %+v", reload.Status)
+	}
+	if convert.Status != nil {
+		t.Errorf("expected empty status: %+v", convert.Status)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartSync("sync")
+	span.SetAttr("key", "value")
+	span.SetError(fmt.Errorf("error"))
+	span.End()
+	tracer.Start("convert").End()
+}
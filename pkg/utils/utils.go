@@ -18,9 +18,11 @@ package utils
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/mitchellh/mapstructure"
@@ -109,3 +111,68 @@ func SendToSocket(socket string, command string) error {
 	}
 	return nil
 }
+
+var haproxyMasters []string
+
+// SetHAProxyMasters configures the addresses of the master CLI of external
+// HAProxy instances. HAProxyCommand sends the commands to their current
+// worker process instead of the local unix socket. Should be called before
+// any command is sent.
+func SetHAProxyMasters(masters []string) {
+	haproxyMasters = masters
+}
+
+// HAProxyCommand sends a command to a HAProxy unix socket and returns its whole response.
+// If the master CLI of external instances are configured, show commands are answered
+// by the first instance, and other commands are sent to all the instances, failing if
+// any of them fails or answers differently from the first one.
+func HAProxyCommand(socket string, command string) (string, error) {
+	if len(haproxyMasters) == 0 {
+		return haproxySend("unix", socket, command)
+	}
+	command = "@1 " + command
+	masters := haproxyMasters
+	if strings.HasPrefix(command, "@1 show ") {
+		masters = masters[:1]
+	}
+	var out string
+	for i, master := range masters {
+		o, err := HAProxyMasterCommand(master, command)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", master, err)
+		}
+		if i == 0 {
+			out = o
+		} else if o != out {
+			return o, fmt.Errorf("%s: %s", master, strings.TrimSpace(o))
+		}
+	}
+	return out, nil
+}
+
+// HAProxyMasterCommand sends a command to the master CLI of a HAProxy instance,
+// either a unix socket or a host:port address, and returns its whole response
+func HAProxyMasterCommand(master, command string) (string, error) {
+	network := "unix"
+	if strings.Contains(master, ":") && !strings.Contains(master, "/") {
+		network = "tcp"
+	}
+	return haproxySend(network, master, command)
+}
+
+func haproxySend(network, address, command string) (string, error) {
+	c, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+	out, err := ioutil.ReadAll(c)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
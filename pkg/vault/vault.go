@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config ...
+type Config struct {
+	// Address of the Vault server, eg https://vault:8200
+	Address string
+	// Mount path of the PKI secrets engine, default is pki
+	PKIMount string
+	// TokenFile is the path of a file with the Vault token, read on every login
+	TokenFile string
+	// KubernetesRole is the role used to login via the Kubernetes auth
+	// method, used if TokenFile is empty
+	KubernetesRole string
+	// KubernetesMount is the mount path of the Kubernetes auth method,
+	// default is kubernetes
+	KubernetesMount string
+	// JWTFile is the path of the service account token sent on the
+	// Kubernetes auth login
+	JWTFile string
+	// TTL requested on every certificate, the role default is used if empty
+	TTL string
+}
+
+// Certificate is a certificate issued by the PKI secrets engine. Certificate
+// has the PEM encoded certificate followed by its chain.
+type Certificate struct {
+	Certificate []byte
+	PrivateKey  []byte
+	Serial      string
+	NotAfter    time.Time
+}
+
+// Client is a minimal client of the Vault PKI secrets engine, see
+// https://www.vaultproject.io/api/secret/pki. A Client isn't safe
+// for concurrent use.
+type Client struct {
+	config Config
+	client *http.Client
+	token  string
+	expire time.Time
+}
+
+type vaultResponse struct {
+	Errors []string `json:"errors"`
+	Auth   *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Data *struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+		PrivateKey  string   `json:"private_key"`
+		Serial      string   `json:"serial_number"`
+		Expiration  int64    `json:"expiration"`
+	} `json:"data"`
+}
+
+// NewClient ...
+func NewClient(config Config) *Client {
+	if config.PKIMount == "" {
+		config.PKIMount = "pki"
+	}
+	if config.KubernetesMount == "" {
+		config.KubernetesMount = "kubernetes"
+	}
+	if config.JWTFile == "" {
+		config.JWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+	config.Address = strings.TrimRight(config.Address, "/")
+	return &Client{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Issue issues a new certificate of the domains using a role of the PKI
+// secrets engine. The first domain is used as the common name.
+func (c *Client) Issue(role string, domains []string) (*Certificate, error) {
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("missing domains")
+	}
+	if err := c.login(); err != nil {
+		return nil, fmt.Errorf("error logging in to Vault: %v", err)
+	}
+	req := map[string]string{
+		"common_name": domains[0],
+		"format":      "pem",
+	}
+	if len(domains) > 1 {
+		req["alt_names"] = strings.Join(domains[1:], ",")
+	}
+	if c.config.TTL != "" {
+		req["ttl"] = c.config.TTL
+	}
+	resp, err := c.post(fmt.Sprintf("/v1/%s/issue/%s", c.config.PKIMount, role), c.token, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data == nil || resp.Data.Certificate == "" || resp.Data.PrivateKey == "" {
+		return nil, fmt.Errorf("Vault response does not have a certificate and a private key")
+	}
+	crt := []string{strings.TrimSpace(resp.Data.Certificate)}
+	chain := resp.Data.CAChain
+	if len(chain) == 0 && resp.Data.IssuingCA != "" {
+		chain = []string{resp.Data.IssuingCA}
+	}
+	for _, ca := range chain {
+		crt = append(crt, strings.TrimSpace(ca))
+	}
+	return &Certificate{
+		Certificate: []byte(strings.Join(crt, "\n") + "\n"),
+		PrivateKey:  []byte(strings.TrimSpace(resp.Data.PrivateKey) + "\n"),
+		Serial:      resp.Data.Serial,
+		NotAfter:    time.Unix(resp.Data.Expiration, 0),
+	}, nil
+}
+
+// login reads the token file, or logs in via the Kubernetes auth method if
+// the current token is about to expire
+func (c *Client) login() error {
+	if c.config.TokenFile != "" {
+		token, err := ioutil.ReadFile(c.config.TokenFile)
+		if err != nil {
+			return err
+		}
+		c.token = strings.TrimSpace(string(token))
+		return nil
+	}
+	if c.config.KubernetesRole == "" {
+		return fmt.Errorf("neither a token file nor a Kubernetes auth role was configured")
+	}
+	if c.token != "" && time.Now().Before(c.expire) {
+		return nil
+	}
+	jwt, err := ioutil.ReadFile(c.config.JWTFile)
+	if err != nil {
+		return err
+	}
+	resp, err := c.post(fmt.Sprintf("/v1/auth/%s/login", c.config.KubernetesMount), "", map[string]string{
+		"role": c.config.KubernetesRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("Vault login response does not have a token")
+	}
+	c.token = resp.Auth.ClientToken
+	// renew the token on the last tenth of its lease
+	lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+	c.expire = time.Now().Add(lease - lease/10)
+	return nil
+}
+
+func (c *Client) post(path, token string, payload interface{}) (*vaultResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.config.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	httpResp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var resp vaultResponse
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, fmt.Errorf("error parsing Vault response, HTTP status %d: %v", httpResp.StatusCode, err)
+		}
+	}
+	if httpResp.StatusCode >= 300 {
+		if httpResp.StatusCode == http.StatusForbidden {
+			// expired or revoked token
+			c.token = ""
+		}
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("Vault returned HTTP status %d: %s", httpResp.StatusCode, strings.Join(resp.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault returned HTTP status %d", httpResp.StatusCode)
+	}
+	return &resp, nil
+}
@@ -17,13 +17,16 @@
 # A script to help with haproxy reloads. Needs sudo for :80.
 #
 # Receives the reload strategy as the first parameter:
-#  native <.cfg>
+#  native <.cfg> [<dir>]
 #    Uses native HAProxy soft restart. Running it for the first time starts
 #    HAProxy, each subsequent invocation will perform a soft-reload.
-#  reusesocket <.cfg>
+#  reusesocket <.cfg> [<dir>]
 #    Pass the listening sockets to the new HAProxy process instead of
 #    rebinding them, allowing hitless reloads.
 #
+# The optional directory has additional .cfg files, eg backend shards,
+# loaded after the main config file.
+#
 # HAProxy options:
 #  -f config file
 #  -p pid file
@@ -42,11 +45,15 @@ if [ -S $HAPROXY_SOCKET ]; then
 else
     echo "#" > $HAPROXY_STATE
 fi
+CONFIG_DIR=""
+if [ -n "$3" ]; then
+    CONFIG_DIR="-f $3"
+fi
 case "$1" in
     native)
         CONFIG="$2"
         HAPROXY_PID=/var/run/haproxy.pid
-        haproxy -f "$CONFIG" -p "$HAPROXY_PID" -D -sf $(cat "$HAPROXY_PID" 2>/dev/null || :)
+        haproxy -f "$CONFIG" $CONFIG_DIR -p "$HAPROXY_PID" -D -sf $(cat "$HAPROXY_PID" 2>/dev/null || :)
         ;;
     reusesocket|multibinder)
         # multibinder is now deprecated and, if used, is an alias to reusesocket
@@ -54,9 +61,9 @@ case "$1" in
         HAPROXY_PID=/var/run/haproxy.pid
         OLD_PID=$(cat "$HAPROXY_PID" 2>/dev/null || :)
         if [ -S "$HAPROXY_SOCKET" ]; then
-            haproxy -f "$CONFIG" -p "$HAPROXY_PID" -sf $OLD_PID -x "$HAPROXY_SOCKET"
+            haproxy -f "$CONFIG" $CONFIG_DIR -p "$HAPROXY_PID" -sf $OLD_PID -x "$HAPROXY_SOCKET"
         else
-            haproxy -f "$CONFIG" -p "$HAPROXY_PID" -sf $OLD_PID
+            haproxy -f "$CONFIG" $CONFIG_DIR -p "$HAPROXY_PID" -sf $OLD_PID
         fi
         ;;
     *)